@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// addonOptionsPath is where the Supervisor mounts the add-on's options.
+	addonOptionsPath = "/data/options.json"
+	// addonRecorderPath is the recorder of the default Home Assistant setup, seen through the
+	// add-on's config mount.
+	addonRecorderPath = "/config/home-assistant_v2.db"
+	// supervisorCoreURL proxies the Home Assistant API for add-ons holding SUPERVISOR_TOKEN.
+	supervisorCoreURL = "http://supervisor/core"
+)
+
+var (
+	addonOptionsFile string
+	addonOnce        bool
+)
+
+// addonOptions is the options.json document the Supervisor writes from the add-on's
+// configuration tab.
+type addonOptions struct {
+	// DSN is passed as --dsn to every job that takes one.
+	DSN string `json:"dsn"`
+	// Recorder is passed as --sqlite to every job that takes one; default addonRecorderPath.
+	Recorder string `json:"recorder"`
+	Dialect  string `json:"dialect"`
+	Sink     string `json:"sink"`
+	// Interval between runs, e.g. "15m".
+	Interval string `json:"interval"`
+	// Jobs are ha-tools arguments, e.g. "energy --entity=dryer"; they run in order.
+	Jobs []string `json:"jobs"`
+	// Config is the --config document (indexes, alerts, ...), inline.
+	Config json.RawMessage `json:"config"`
+
+	interval time.Duration
+}
+
+// addonCmd is the entrypoint of the Home Assistant OS add-on image.
+var addonCmd = &cobra.Command{
+	Use:   "addon",
+	Short: "Run sync jobs as a Home Assistant add-on",
+	Long:  "Reads the add-on options from /data/options.json and runs the configured jobs every interval, filling in --sqlite with the recorder under /config and --dsn from the options. Job output goes to stdout/stderr, which the Supervisor shows as the add-on log.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, err := loadAddonOptions(addonOptionsFile)
+		if err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		binary, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("locate ha-tools binary: %w", err)
+		}
+		global, cleanup, err := opts.globalArgs()
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		out, errOut := cmd.OutOrStdout(), cmd.ErrOrStderr()
+		for {
+			failed := runAddonJobs(ctx, out, errOut, binary, global, opts)
+			if addonOnce {
+				if failed > 0 {
+					return fmt.Errorf("%d job(s) failed", failed)
+				}
+				return nil
+			}
+
+			addonLog(out, "next run in %s", opts.interval)
+			select {
+			case <-ctx.Done():
+				addonLog(out, "stopping")
+				return nil
+			case <-time.After(opts.interval):
+			}
+		}
+	},
+}
+
+func init() {
+	addonCmd.Flags().StringVar(&addonOptionsFile, "options", addonOptionsPath, "Add-on options file written by the Supervisor")
+	addonCmd.Flags().BoolVar(&addonOnce, "once", false, "Run the jobs once and exit instead of every interval")
+
+	rootCmd.AddCommand(addonCmd)
+}
+
+// loadAddonOptions reads and validates the options file, filling in the add-on defaults.
+func loadAddonOptions(path string) (*addonOptions, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read add-on options: %w", err)
+	}
+	opts := &addonOptions{}
+	if err := json.Unmarshal(raw, opts); err != nil {
+		return nil, fmt.Errorf("parse add-on options %s: %w", path, err)
+	}
+
+	if opts.Recorder == "" {
+		opts.Recorder = addonRecorderPath
+	}
+	if _, err := os.Stat(opts.Recorder); err != nil {
+		return nil, fmt.Errorf("recorder %s not found; is the add-on's config folder mapped? (%w)", opts.Recorder, err)
+	}
+	if opts.Interval == "" {
+		opts.Interval = "15m"
+	}
+	opts.interval, err = time.ParseDuration(opts.Interval)
+	if err != nil || opts.interval <= 0 {
+		return nil, fmt.Errorf("add-on options: invalid interval %q", opts.Interval)
+	}
+	if len(opts.Jobs) == 0 {
+		return nil, errors.New("add-on options: jobs is empty")
+	}
+	for _, job := range opts.Jobs {
+		if len(strings.Fields(job)) == 0 {
+			return nil, errors.New("add-on options: jobs contains an empty entry")
+		}
+	}
+	return opts, nil
+}
+
+// globalArgs returns the global flags every job runs with. An inline config is written to a
+// temporary file for --config; cleanup removes it.
+func (o *addonOptions) globalArgs() ([]string, func(), error) {
+	var args []string
+	if o.Dialect != "" {
+		args = append(args, "--dialect="+o.Dialect)
+	}
+	if o.Sink != "" {
+		args = append(args, "--sink="+o.Sink)
+	}
+	if len(o.Config) == 0 || string(o.Config) == "null" {
+		return args, func() {}, nil
+	}
+
+	f, err := os.CreateTemp("", "ha-tools-config-*.json")
+	if err != nil {
+		return nil, nil, fmt.Errorf("write add-on config: %w", err)
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	if _, err := f.Write(o.Config); err != nil {
+		f.Close()
+		cleanup()
+		return nil, nil, fmt.Errorf("write add-on config: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("write add-on config: %w", err)
+	}
+	// Fail at startup rather than in every job.
+	if _, err := loadConfig(f.Name()); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("add-on options config: %w", err)
+	}
+	// Keep the alert rules' pending conditions in the add-on's persistent /data folder.
+	alertState := filepath.Join(filepath.Dir(addonOptionsFile), "ha-tools-alerts.json")
+	return append(args, "--config="+f.Name(), "--alert-state="+alertState), cleanup, nil
+}
+
+// jobArgs splits job into arguments and adds --sqlite and --dsn when the subcommand takes them
+// and the job does not set them itself.
+func (o *addonOptions) jobArgs(job string, global []string) []string {
+	args := strings.Fields(job)
+	sub, _, err := rootCmd.Find(args)
+	if err == nil && sub != rootCmd {
+		if sub.Flags().Lookup("sqlite") != nil && !hasFlagArg(args, "sqlite") {
+			args = append(args, "--sqlite="+o.Recorder)
+		}
+		if o.DSN != "" && sub.Flags().Lookup("dsn") != nil && !hasFlagArg(args, "dsn") {
+			args = append(args, "--dsn="+o.DSN)
+		}
+	}
+	return append(args, global...)
+}
+
+func hasFlagArg(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == "--"+name || strings.HasPrefix(arg, "--"+name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// runAddonJobs runs every job in order and returns how many failed. A failing job does not stop
+// the others: the add-on keeps exporting what it can and the log shows the failure.
+func runAddonJobs(ctx context.Context, out, errOut io.Writer, binary string, global []string, opts *addonOptions) int {
+	failed := 0
+	for _, job := range opts.Jobs {
+		if ctx.Err() != nil {
+			return failed
+		}
+		args := opts.jobArgs(job, global)
+		addonLog(out, "running %s", strings.Fields(job)[0])
+		start := time.Now()
+
+		c := exec.CommandContext(ctx, binary, args...)
+		c.Stdout, c.Stderr = out, errOut
+		err := c.Run()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == exitSkippedRows {
+			addonLog(out, "%s finished with skipped rows in %s", strings.Fields(job)[0], time.Since(start).Round(time.Second))
+			continue
+		}
+		if err != nil {
+			failed++
+			addonLog(errOut, "%s failed after %s: %v", strings.Fields(job)[0], time.Since(start).Round(time.Second), err)
+			continue
+		}
+		addonLog(out, "%s finished in %s", strings.Fields(job)[0], time.Since(start).Round(time.Second))
+	}
+	return failed
+}
+
+// addonLog writes a timestamped line; the Supervisor log viewer shows raw container output.
+func addonLog(w io.Writer, format string, args ...any) {
+	fmt.Fprintf(w, "%s %s\n", time.Now().Format(time.DateTime), fmt.Sprintf(format, args...))
+}
+
+// supervisorHomeAssistant returns the Supervisor's Home Assistant API proxy when running as an
+// add-on, so alert rules can notify without a long-lived token.
+func supervisorHomeAssistant() *homeAssistantConfig {
+	token := os.Getenv("SUPERVISOR_TOKEN")
+	if token == "" {
+		return nil
+	}
+	return &homeAssistantConfig{URL: supervisorCoreURL, Token: token}
+}
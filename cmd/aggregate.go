@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// targetResolution is the --target-resolution flag: the bucket numeric exporters aggregate every
+// entity's samples to, 0 to keep them as recorded.
+var targetResolution time.Duration
+
+func init() {
+	rootCmd.PersistentFlags().DurationVar(&targetResolution, "target-resolution", 0, "Aggregate every numeric entity to one row per entity and bucket of this size, e.g. 1m, 5m or 1h, to keep the destination small: counters (state_class total/total_increasing) keep the bucket's last reading, other sensors its mean, unless the config's aggregations say otherwise (0 = export every sample)")
+}
+
+// ohlcFlagUsage documents the --ohlc flag of the numeric exporters.
+const ohlcFlagUsage = "Add open_value, close_value, min_value and max_value columns holding each aggregated bucket's first, last, smallest and largest sample, so plots can show ranges (raw rows repeat their value)"
+
+func validateTargetResolution() error {
+	return validateResolution("--target-resolution", targetResolution)
+}
+
+// validateResolution accepts whole minutes that divide a day, so buckets line up with UTC days.
+func validateResolution(name string, d time.Duration) error {
+	if d == 0 {
+		return nil
+	}
+	if d < time.Minute || d%time.Minute != 0 || (24*time.Hour)%d != 0 {
+		return fmt.Errorf("%s must be a whole number of minutes dividing a day, such as 1m, 5m, 15m or 1h, got %s", name, d)
+	}
+	return nil
+}
+
+// aggregation is how an aggregated row's value is derived from its bucket's samples.
+type aggregation string
+
+const (
+	// aggregateMean averages the samples, for measurements.
+	aggregateMean aggregation = "mean"
+	// aggregateLast keeps the newest sample, for counters.
+	aggregateLast aggregation = "last"
+	// aggregateMin and aggregateMax keep the smallest and largest sample.
+	aggregateMin aggregation = "min"
+	aggregateMax aggregation = "max"
+	// aggregateMedian keeps the middle sample, which spikes do not pull like they pull a mean.
+	aggregateMedian aggregation = "median"
+)
+
+var aggregations = []aggregation{aggregateMean, aggregateLast, aggregateMin, aggregateMax, aggregateMedian, "p<1-99>"}
+
+func (a aggregation) valid() bool {
+	if _, ok := a.percentile(); ok {
+		return true
+	}
+	switch a {
+	case aggregateMean, aggregateLast, aggregateMin, aggregateMax:
+		return true
+	}
+	return false
+}
+
+// percentile returns the percentile of median and p<1-99> aggregations such as p95.
+func (a aggregation) percentile() (float64, bool) {
+	if a == aggregateMedian {
+		return 50, true
+	}
+	digits, ok := strings.CutPrefix(string(a), "p")
+	if !ok || digits == "" || digits[0] == '0' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil || n < 1 || n > 99 {
+		return 0, false
+	}
+	return float64(n), true
+}
+
+// percentileOf returns the p-th percentile of the values, interpolating linearly between the two
+// closest ranks. It sorts values in place.
+func percentileOf(values []float64, p float64) float64 {
+	sort.Float64s(values)
+	rank := p / 100 * float64(len(values)-1)
+	lower := int(rank)
+	if lower+1 >= len(values) {
+		return values[len(values)-1]
+	}
+	return values[lower] + (rank-float64(lower))*(values[lower+1]-values[lower])
+}
+
+// validateAggregations checks the config's aggregations: entity_id globs mapped to a function.
+func (c *fileConfig) validateAggregations() error {
+	for pattern, how := range c.Aggregations {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("aggregations: invalid entity pattern %q", pattern)
+		}
+		if !how.valid() {
+			names := make([]string, len(aggregations))
+			for i, a := range aggregations {
+				names[i] = string(a)
+			}
+			return fmt.Errorf("aggregations.%s: unknown aggregation %q (use %s)", pattern, how, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// rowAggregation picks how a sensor's samples are aggregated: the config's aggregations entry
+// for the entity, else by state_class. Averaging the running total of a counter (total,
+// total_increasing) would understate it, so counters keep their last reading; measurements are
+// averaged.
+func rowAggregation(row numericRow) aggregation {
+	if how, ok := appConfig.aggregationFor(row.entityID); ok {
+		return how
+	}
+	switch row.meta.StateClass.String {
+	case "total", "total_increasing":
+		return aggregateLast
+	default:
+		return aggregateMean
+	}
+}
+
+// aggregationFor returns the aggregations entry for the entity: its exact entity_id, else the
+// longest matching glob.
+func (c *fileConfig) aggregationFor(entityID string) (aggregation, bool) {
+	if how, ok := c.Aggregations[entityID]; ok {
+		return how, true
+	}
+	patterns := make([]string, 0, len(c.Aggregations))
+	for pattern := range c.Aggregations {
+		if ok, _ := path.Match(pattern, entityID); ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if len(patterns) == 0 {
+		return "", false
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+	return c.Aggregations[patterns[0]], true
+}
+
+// bucketStart returns the start of the bucket of the given resolution holding t, in UTC. Buckets
+// are cut on the UTC time line rather than the export zone's wall clock, so every bucket spans
+// exactly resolution: the hour a DST change repeats (fall back) is two buckets, not one of two
+// hours, and the hour it skips (spring forward) no bucket rather than an empty one.
+func bucketStart(t time.Time, resolution time.Duration) time.Time {
+	return t.UTC().Truncate(resolution)
+}
+
+// bucketAggregator folds consecutive samples of one entity into one row per time bucket. Rows
+// must arrive ordered per entity by time; a new entity or bucket emits the open one.
+type bucketAggregator struct {
+	emit func(numericRow) error
+
+	active      bool
+	entityID    string
+	bucket      time.Time
+	resolution  time.Duration
+	aggregation aggregation
+	sum         float64
+	count       int
+	min, max    float64
+	// values buffers the samples for percentile aggregations, which the running figures cannot
+	// answer.
+	values []float64
+	// oldest is the bucket's first sample; newest its newest, whose time, state_id and attributes
+	// the row carries.
+	oldest, newest numericRow
+}
+
+// bucketSpread is the OHLC summary of a bucket's samples.
+type bucketSpread struct {
+	open, close, min, max float64
+}
+
+func newBucketAggregator(emit func(numericRow) error) *bucketAggregator {
+	return &bucketAggregator{emit: emit}
+}
+
+// Add folds a row with a valid time and numeric state into its bucket of the given resolution.
+func (a *bucketAggregator) Add(row numericRow, resolution time.Duration, how aggregation) error {
+	bucket := bucketStart(row.lastUpdated.Time, resolution)
+	if a.active && (row.entityID != a.entityID || !bucket.Equal(a.bucket) || resolution != a.resolution) {
+		if err := a.Flush(); err != nil {
+			return err
+		}
+	}
+	if !a.active {
+		*a = bucketAggregator{emit: a.emit, active: true, entityID: row.entityID, bucket: bucket, resolution: resolution, aggregation: how, values: a.values, oldest: row, newest: row}
+	}
+
+	value := row.numericState.Float64
+	if a.count == 0 || value < a.min {
+		a.min = value
+	}
+	if a.count == 0 || value > a.max {
+		a.max = value
+	}
+	a.sum += value
+	a.count++
+	if _, ok := a.aggregation.percentile(); ok {
+		a.values = append(a.values, value)
+	}
+	newest := a.newest.lastUpdated.Time
+	if row.lastUpdated.Time.After(newest) || (row.lastUpdated.Time.Equal(newest) && row.stateID > a.newest.stateID) {
+		a.newest = row
+	}
+	oldest := a.oldest.lastUpdated.Time
+	if row.lastUpdated.Time.Before(oldest) || (row.lastUpdated.Time.Equal(oldest) && row.stateID < a.oldest.stateID) {
+		a.oldest = row
+	}
+	return nil
+}
+
+// Flush emits the open bucket, if any.
+func (a *bucketAggregator) Flush() error {
+	if !a.active {
+		return nil
+	}
+	// The values buffer is kept for the next bucket.
+	defer func() { *a = bucketAggregator{emit: a.emit, values: a.values[:0]} }()
+
+	var value float64
+	p, isPercentile := a.aggregation.percentile()
+	switch {
+	case isPercentile:
+		value = percentileOf(a.values, p)
+	case a.aggregation == aggregateLast:
+		value = a.newest.numericState.Float64
+	case a.aggregation == aggregateMin:
+		value = a.min
+	case a.aggregation == aggregateMax:
+		value = a.max
+	default:
+		value = a.sum / float64(a.count)
+	}
+	return a.emit(numericRow{
+		stateID:      a.newest.stateID,
+		entityID:     a.entityID,
+		state:        strconv.FormatFloat(value, 'f', -1, 64),
+		numericState: sql.NullFloat64{Float64: value, Valid: true},
+		meta:         a.newest.meta,
+		lastUpdated:  a.newest.lastUpdated,
+		bucket:       a.bucket,
+		spread: bucketSpread{
+			open:  a.oldest.numericState.Float64,
+			close: a.newest.numericState.Float64,
+			min:   a.min,
+			max:   a.max,
+		},
+		samples: a.count,
+	})
+}
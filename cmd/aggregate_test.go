@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+	// The DST cases need zone data on hosts without it.
+	_ "time/tzdata"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("load time zone %s: %v", name, err)
+	}
+	return loc
+}
+
+func TestBucketStartAcrossDST(t *testing.T) {
+	berlin := mustLoadLocation(t, "Europe/Berlin")
+	newYork := mustLoadLocation(t, "America/New_York")
+	utc := func(s string) time.Time {
+		at, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at.UTC()
+	}
+	// in reads an instant on the zone's wall clock; the repeated hour is reached through UTC, since
+	// time.Date does not say which of its two instants it returns.
+	in := func(s string, loc *time.Location) time.Time { return utc(s).In(loc) }
+
+	tests := []struct {
+		name       string
+		at         time.Time
+		resolution time.Duration
+		wall       string
+		want       time.Time
+	}{
+		// Europe/Berlin springs forward on 2024-03-31: 02:00 CET becomes 03:00 CEST.
+		{"berlin last minute before the gap", in("2024-03-31T00:59:59.999999Z", berlin), time.Minute, "01:59", utc("2024-03-31T00:59:00Z")},
+		{"berlin first minute after the gap", in("2024-03-31T01:00:00Z", berlin), time.Minute, "03:00", utc("2024-03-31T01:00:00Z")},
+		{"berlin hour before the gap", in("2024-03-31T00:30:00Z", berlin), time.Hour, "01:30", utc("2024-03-31T00:00:00Z")},
+		{"berlin hour after the gap", in("2024-03-31T01:30:00Z", berlin), time.Hour, "03:30", utc("2024-03-31T01:00:00Z")},
+		// Europe/Berlin falls back on 2024-10-27: 02:00-03:00 runs twice, first in CEST, then CET.
+		{"berlin repeated hour, first pass", in("2024-10-27T00:30:00Z", berlin), time.Minute, "02:30", utc("2024-10-27T00:30:00Z")},
+		{"berlin repeated hour, second pass", in("2024-10-27T01:30:00Z", berlin), time.Minute, "02:30", utc("2024-10-27T01:30:00Z")},
+		{"berlin repeated hour, first pass bucket", in("2024-10-27T00:59:59Z", berlin), time.Hour, "02:59", utc("2024-10-27T00:00:00Z")},
+		{"berlin repeated hour, second pass bucket", in("2024-10-27T01:00:00Z", berlin), time.Hour, "02:00", utc("2024-10-27T01:00:00Z")},
+		// America/New_York springs forward on 2024-03-10 (02:00 EST becomes 03:00 EDT) and falls
+		// back on 2024-11-03 (01:00-02:00 runs twice, first in EDT, then EST).
+		{"new york last minute before the gap", in("2024-03-10T06:59:30Z", newYork), time.Minute, "01:59", utc("2024-03-10T06:59:00Z")},
+		{"new york first minute after the gap", in("2024-03-10T07:00:00Z", newYork), time.Minute, "03:00", utc("2024-03-10T07:00:00Z")},
+		{"new york repeated hour, first pass", in("2024-11-03T05:15:00Z", newYork), 15 * time.Minute, "01:15", utc("2024-11-03T05:15:00Z")},
+		{"new york repeated hour, second pass", in("2024-11-03T06:15:00Z", newYork), 15 * time.Minute, "01:15", utc("2024-11-03T06:15:00Z")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if wall := tt.at.Format("15:04"); wall != tt.wall {
+				t.Fatalf("test instant reads %s on the wall clock, want %s", wall, tt.wall)
+			}
+			got := bucketStart(tt.at, tt.resolution)
+			if !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("bucketStart(%s, %s) = %s, want %s", tt.at, tt.resolution, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBucketStartSpansExactResolution walks minute by minute across both DST changes of a zone and
+// checks that consecutive buckets are always exactly one resolution apart: the repeated hour is two
+// buckets, and the skipped hour leaves no gap.
+func TestBucketStartSpansExactResolution(t *testing.T) {
+	tests := []struct {
+		zone       string
+		from       string
+		resolution time.Duration
+		buckets    int
+	}{
+		{"Europe/Berlin", "2024-03-30T23:00:00Z", time.Hour, 3},
+		{"Europe/Berlin", "2024-10-26T23:00:00Z", time.Hour, 3},
+		{"Europe/Berlin", "2024-10-26T23:00:00Z", 15 * time.Minute, 12},
+		{"America/New_York", "2024-03-10T05:00:00Z", time.Hour, 3},
+		{"America/New_York", "2024-11-03T04:00:00Z", 5 * time.Minute, 36},
+	}
+	for _, tt := range tests {
+		t.Run(tt.zone+" "+tt.from+" "+tt.resolution.String(), func(t *testing.T) {
+			loc := mustLoadLocation(t, tt.zone)
+			from, err := time.Parse(time.RFC3339, tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buckets []time.Time
+			for at := from; at.Before(from.Add(3 * time.Hour)); at = at.Add(time.Minute) {
+				bucket := bucketStart(at.In(loc), tt.resolution)
+				if n := len(buckets); n == 0 || !bucket.Equal(buckets[n-1]) {
+					buckets = append(buckets, bucket)
+				}
+			}
+			if len(buckets) != tt.buckets {
+				t.Fatalf("3 hours from %s fall into %d buckets of %s, want %d", tt.from, len(buckets), tt.resolution, tt.buckets)
+			}
+			for i := 1; i < len(buckets); i++ {
+				if step := buckets[i].Sub(buckets[i-1]); step != tt.resolution {
+					t.Errorf("bucket %s follows %s after %s, want %s", buckets[i], buckets[i-1], step, tt.resolution)
+				}
+			}
+		})
+	}
+}
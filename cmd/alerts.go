@@ -0,0 +1,428 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alertFreshness keeps backfills quiet: a rule only fires for rows at most this old.
+const alertFreshness = time.Hour
+
+// alertRule is one entry of the config's alerts list, e.g.
+// {"name": "dryer running", "entity": "sensor.dryer_power", "above": 2000, "for": "10m", "webhook": "https://..."}.
+type alertRule struct {
+	Name string `json:"name"`
+	// Entity is an entity_id or a glob such as sensor.*_battery.
+	Entity string   `json:"entity"`
+	Above  *float64 `json:"above"`
+	Below  *float64 `json:"below"`
+	// State matches the raw state instead, e.g. "unavailable" for offline devices.
+	State string `json:"state"`
+	// For is how long the condition must hold before the rule fires, e.g. "10m".
+	For string `json:"for"`
+	// Webhook receives a JSON POST; Notify names a Home Assistant notify service.
+	Webhook string `json:"webhook"`
+	Notify  string `json:"notify"`
+
+	holdFor time.Duration
+}
+
+func (r *alertRule) validate(haConfigured bool) error {
+	if r.Name == "" || r.Entity == "" {
+		return errors.New("name and entity are required")
+	}
+	if _, err := path.Match(r.Entity, ""); err != nil {
+		return fmt.Errorf("%s: invalid entity pattern %q", r.Name, r.Entity)
+	}
+	numeric := r.Above != nil || r.Below != nil
+	if numeric == (r.State != "") {
+		return fmt.Errorf("%s: set above and/or below, or state", r.Name)
+	}
+	if r.Webhook == "" && r.Notify == "" {
+		return fmt.Errorf("%s: set webhook and/or notify", r.Name)
+	}
+	if r.Notify != "" && !haConfigured {
+		return fmt.Errorf("%s: notify requires the home_assistant section", r.Name)
+	}
+	if r.For != "" {
+		d, err := time.ParseDuration(r.For)
+		if err != nil || d < 0 {
+			return fmt.Errorf("%s: invalid duration %q", r.Name, r.For)
+		}
+		r.holdFor = d
+	}
+	return nil
+}
+
+// matches reports whether a reading meets the rule's condition.
+func (r *alertRule) matches(state string, value sql.NullFloat64) bool {
+	if r.State != "" {
+		return state == r.State
+	}
+	if !value.Valid {
+		return false
+	}
+	return (r.Above == nil || value.Float64 > *r.Above) && (r.Below == nil || value.Float64 < *r.Below)
+}
+
+// describe renders the condition for notification messages.
+func (r *alertRule) describe() string {
+	var parts []string
+	if r.State != "" {
+		parts = append(parts, "state is "+r.State)
+	}
+	if r.Above != nil {
+		parts = append(parts, "above "+strconv.FormatFloat(*r.Above, 'g', -1, 64))
+	}
+	if r.Below != nil {
+		parts = append(parts, "below "+strconv.FormatFloat(*r.Below, 'g', -1, 64))
+	}
+	condition := strings.Join(parts, " and ")
+	if r.holdFor > 0 {
+		condition += " for " + r.holdFor.String()
+	}
+	return condition
+}
+
+// alertEvent is the JSON body posted to webhooks.
+type alertEvent struct {
+	Rule      string    `json:"rule"`
+	EntityID  string    `json:"entity_id"`
+	State     string    `json:"state"`
+	Value     *float64  `json:"value,omitempty"`
+	Since     time.Time `json:"since"`
+	At        time.Time `json:"at"`
+	Message   string    `json:"message"`
+	Condition string    `json:"condition"`
+}
+
+type alertKey struct {
+	rule     string
+	entityID string
+}
+
+// alertStatus tracks a rule for one entity: since when its condition holds, whether it fired, and
+// the newest matching reading, reported when the rule fires after its "for" duration.
+type alertStatus struct {
+	Rule     string    `json:"rule"`
+	EntityID string    `json:"entity_id"`
+	Since    time.Time `json:"since"`
+	Fired    bool      `json:"fired,omitempty"`
+	State    string    `json:"state"`
+	Value    *float64  `json:"value,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// alertStateFile is the --alert-state document.
+type alertStateFile struct {
+	// Seen is the newest row time evaluated per entity; older rows are re-reads of earlier runs.
+	Seen   map[string]time.Time `json:"seen"`
+	Status []*alertStatus       `json:"status"`
+}
+
+// alertEvaluator checks exported rows against the configured rules. Pending conditions persist in
+// --alert-state, so a "for" duration spans runs (including the separate job processes of watch
+// and addon) and fires on the wall clock once it elapsed, even when no further rows arrive.
+type alertEvaluator struct {
+	rules  []*alertRule
+	ha     *homeAssistantConfig
+	client *http.Client
+
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	status map[alertKey]*alertStatus
+	// dirty is set when seen or status changed since the state file was read.
+	dirty bool
+}
+
+// alerts is set when the config declares rules; the exporters feed it every row they read.
+var alerts *alertEvaluator
+
+var alertStatePath string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&alertStatePath, "alert-state", "ha-tools-alerts.json", "File keeping the alert rules' pending conditions between runs, so a \"for\" duration spans runs; only used when the config declares alerts")
+}
+
+func newAlertEvaluator(cfg *fileConfig) *alertEvaluator {
+	if len(cfg.Alerts) == 0 {
+		return nil
+	}
+	return &alertEvaluator{
+		rules:  cfg.Alerts,
+		ha:     cfg.HomeAssistant,
+		client: &http.Client{Timeout: 10 * time.Second},
+		seen:   make(map[string]time.Time),
+		status: make(map[alertKey]*alertStatus),
+	}
+}
+
+// load replaces the in-memory state with the --alert-state file. A missing file is an empty
+// state; statuses of rules no longer configured are dropped.
+func (a *alertEvaluator) load() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.seen = make(map[string]time.Time)
+	a.status = make(map[alertKey]*alertStatus)
+	a.dirty = false
+	if alertStatePath == "" {
+		return nil
+	}
+	raw, err := os.ReadFile(alertStatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read alert state: %w", err)
+	}
+	var state alertStateFile
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return fmt.Errorf("parse alert state %s: %w", alertStatePath, err)
+	}
+	for entityID, at := range state.Seen {
+		a.seen[entityID] = at
+	}
+	for _, st := range state.Status {
+		if a.rule(st.Rule) != nil {
+			a.status[alertKey{rule: st.Rule, entityID: st.EntityID}] = st
+		}
+	}
+	return nil
+}
+
+// save writes the state back to --alert-state when it changed.
+func (a *alertEvaluator) save() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.dirty || alertStatePath == "" {
+		return nil
+	}
+	state := alertStateFile{Seen: a.seen, Status: make([]*alertStatus, 0, len(a.status))}
+	for _, st := range a.status {
+		state.Status = append(state.Status, st)
+	}
+	sort.Slice(state.Status, func(i, j int) bool {
+		if state.Status[i].Rule != state.Status[j].Rule {
+			return state.Status[i].Rule < state.Status[j].Rule
+		}
+		return state.Status[i].EntityID < state.Status[j].EntityID
+	})
+	raw, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(alertStatePath, append(raw, '\n')); err != nil {
+		return fmt.Errorf("write alert state: %w", err)
+	}
+	a.dirty = false
+	return nil
+}
+
+func (a *alertEvaluator) rule(name string) *alertRule {
+	for _, rule := range a.rules {
+		if rule.Name == name {
+			return rule
+		}
+	}
+	return nil
+}
+
+// observeAlerts evaluates one recorder row read by an exporter. Exporters call it before dropping
+// unavailable or non-numeric rows, so state rules such as "unavailable" see them.
+func observeAlerts(ctx context.Context, entityID, state string, value sql.NullFloat64, at sql.NullTime) {
+	if alerts == nil || !at.Valid {
+		return
+	}
+	alerts.observe(ctx, entityID, state, value, at.Time)
+}
+
+// observe evaluates one reading. Rows at or before the entity's newest evaluated row were seen by
+// an earlier run (or another exporter) and are ignored. Delivery failures are reported on stderr
+// so a broken webhook never stops an export.
+func (a *alertEvaluator) observe(ctx context.Context, entityID, state string, value sql.NullFloat64, at time.Time) {
+	type firing struct {
+		rule  *alertRule
+		event alertEvent
+	}
+	var fire []firing
+
+	a.mu.Lock()
+	if seen, ok := a.seen[entityID]; ok && !at.After(seen) {
+		a.mu.Unlock()
+		return
+	}
+	a.seen[entityID] = at
+	a.dirty = true
+	for _, rule := range a.rules {
+		if matched, _ := path.Match(rule.Entity, entityID); !matched {
+			continue
+		}
+		if event, ok := a.transition(rule, entityID, state, value, at); ok {
+			fire = append(fire, firing{rule, event})
+		}
+	}
+	a.mu.Unlock()
+
+	for _, f := range fire {
+		a.deliver(ctx, f.rule, f.event)
+	}
+}
+
+// transition updates the rule's status for the entity and returns the event to deliver, if any.
+// The caller holds a.mu.
+func (a *alertEvaluator) transition(rule *alertRule, entityID, state string, value sql.NullFloat64, at time.Time) (alertEvent, bool) {
+	key := alertKey{rule: rule.Name, entityID: entityID}
+	if !rule.matches(state, value) {
+		delete(a.status, key)
+		return alertEvent{}, false
+	}
+	st, ok := a.status[key]
+	if !ok {
+		st = &alertStatus{Rule: rule.Name, EntityID: entityID, Since: at}
+		a.status[key] = st
+	}
+	st.State, st.At, st.Value = state, at, nil
+	if value.Valid {
+		v := value.Float64
+		st.Value = &v
+	}
+	if st.Fired || at.Sub(st.Since) < rule.holdFor || wallClock.Now().Sub(at) > alertFreshness {
+		return alertEvent{}, false
+	}
+	return a.fire(rule, st, at), true
+}
+
+// fireDue fires the rules whose condition has held for their "for" duration by now, without
+// waiting for another row. A duration that elapsed more than alertFreshness ago stays quiet, like
+// a backfilled row.
+func (a *alertEvaluator) fireDue(ctx context.Context) {
+	type firing struct {
+		rule  *alertRule
+		event alertEvent
+	}
+	var fire []firing
+
+	a.mu.Lock()
+	now := wallClock.Now()
+	for _, st := range a.status {
+		rule := a.rule(st.Rule)
+		if st.Fired || rule == nil {
+			continue
+		}
+		due := st.Since.Add(rule.holdFor)
+		if now.Before(due) || now.Sub(due) > alertFreshness {
+			continue
+		}
+		fire = append(fire, firing{rule, a.fire(rule, st, now)})
+	}
+	a.mu.Unlock()
+
+	for _, f := range fire {
+		a.deliver(ctx, f.rule, f.event)
+	}
+}
+
+// fire marks the status fired and builds its event. The caller holds a.mu.
+func (a *alertEvaluator) fire(rule *alertRule, st *alertStatus, at time.Time) alertEvent {
+	st.Fired = true
+	a.dirty = true
+	event := alertEvent{
+		Rule:      rule.Name,
+		EntityID:  st.EntityID,
+		State:     st.State,
+		Value:     st.Value,
+		Since:     st.Since,
+		At:        at,
+		Condition: rule.describe(),
+	}
+	event.Message = fmt.Sprintf("%s: %s is %s (%s since %s)", rule.Name, st.EntityID, st.State, event.Condition, st.Since.Format(time.Kitchen))
+	return event
+}
+
+// finishAlerts fires the rules that came due during the run and saves the state. It runs once the
+// command ended, so its context is not the (possibly cancelled) command's.
+func finishAlerts() error {
+	if alerts == nil {
+		return nil
+	}
+	alerts.fireDue(context.Background())
+	return alerts.save()
+}
+
+func (a *alertEvaluator) deliver(ctx context.Context, rule *alertRule, event alertEvent) {
+	if rule.Webhook != "" {
+		if err := a.post(ctx, rule.Webhook, "", event); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: alert %q webhook: %v\n", rule.Name, err)
+		}
+	}
+	if rule.Notify != "" {
+		endpoint := strings.TrimSuffix(a.ha.URL, "/") + "/api/services/notify/" + rule.Notify
+		body := map[string]string{"title": "ha-tools: " + rule.Name, "message": event.Message}
+		token, err := resolveSecret(ctx, a.ha.Token)
+		if err == nil {
+			err = a.post(ctx, endpoint, token, body)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: alert %q notify.%s: %v\n", rule.Name, rule.Notify, err)
+		}
+	}
+}
+
+func (a *alertEvaluator) post(ctx context.Context, endpoint, token string, body any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sync re-reads the state the job processes of watch and addon left behind, fires the rules that
+// came due, and saves it again.
+func (a *alertEvaluator) sync(ctx context.Context) error {
+	if err := a.load(); err != nil {
+		return err
+	}
+	a.fireDue(ctx)
+	return a.save()
+}
+
+// detachAlerts hands the evaluator to a command that runs exporters as child processes: they
+// observe the rows, while the parent only fires what comes due between them, through sync. The
+// parent's own end-of-run save would overwrite the children's newer state.
+func detachAlerts() *alertEvaluator {
+	a := alerts
+	alerts = nil
+	return a
+}
@@ -0,0 +1,314 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	anomaliesMySQLDSN    string
+	anomaliesEntity      string
+	anomaliesWindow      time.Duration
+	anomaliesBaseline    time.Duration
+	anomaliesZ           float64
+	anomaliesMinSamples  int
+	anomaliesWriteResult bool
+)
+
+// anomalyEpisodeGap merges flagged samples of one entity closer than this into one episode.
+const anomalyEpisodeGap = 15 * time.Minute
+
+// energyAnomaliesCmd flags unusual readings in energy_points.
+var energyAnomaliesCmd = &cobra.Command{
+	Use:   "anomalies",
+	Short: "Report statistically unusual readings in energy_points",
+	Long:  "Compares recent energy_points readings with each entity's baseline for the same hour of day and flags samples beyond a z-score threshold or outside the fixed bounds in the config's anomaly_thresholds. Consecutive flagged samples are merged into episodes, printed as a report and optionally written to an energy_anomalies table.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if anomaliesMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+		if anomaliesWindow <= 0 || anomaliesBaseline <= anomaliesWindow {
+			return errors.New("--baseline must be longer than --window, and both positive")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		return reportEnergyAnomalies(ctx, cmd.OutOrStdout(), anomaliesMySQLDSN)
+	},
+}
+
+func init() {
+	energyAnomaliesCmd.Flags().StringVar(&anomaliesMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	energyAnomaliesCmd.Flags().StringVar(&anomaliesEntity, "entity", "", "Optional slug narrowing the checked entities (substring of entity_id)")
+	energyAnomaliesCmd.Flags().DurationVar(&anomaliesWindow, "window", 24*time.Hour, "How far back to look for anomalies")
+	energyAnomaliesCmd.Flags().DurationVar(&anomaliesBaseline, "baseline", 30*24*time.Hour, "History preceding the window used as the baseline")
+	energyAnomaliesCmd.Flags().Float64Var(&anomaliesZ, "z", 3, "Flag samples whose z-score magnitude reaches this value")
+	energyAnomaliesCmd.Flags().IntVar(&anomaliesMinSamples, "min-samples", 20, "Minimum baseline samples per entity and hour before z-scores are used")
+	energyAnomaliesCmd.Flags().BoolVar(&anomaliesWriteResult, "write", false, "Also upsert the episodes into an energy_anomalies table")
+	_ = energyAnomaliesCmd.MarkFlagRequired("dsn")
+
+	energyCmd.AddCommand(energyAnomaliesCmd)
+}
+
+var energyAnomaliesTable = &tableSpec{
+	name: "energy_anomalies",
+	columns: []columnSpec{
+		{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "started_at", sqlType: "DATETIME NOT NULL"},
+		{name: "ended_at", sqlType: "DATETIME NOT NULL"},
+		{name: "reason", sqlType: "VARCHAR(32) NOT NULL"},
+		{name: "samples", sqlType: "INT NOT NULL"},
+		{name: "peak_value", sqlType: "DOUBLE NOT NULL"},
+		{name: "peak_z", sqlType: "DOUBLE NULL"},
+	},
+	primaryKey: []string{"entity_id", "started_at"},
+}
+
+// hourBaseline is the distribution of one entity's readings at one hour of day.
+type hourBaseline struct {
+	mean    float64
+	stddev  float64
+	samples int
+}
+
+type baselineKey struct {
+	entityID string
+	hour     int
+}
+
+// anomalyEpisode is a run of flagged samples of one entity for one reason.
+type anomalyEpisode struct {
+	entityID  string
+	reason    string
+	start     time.Time
+	end       time.Time
+	samples   int
+	peakValue float64
+	peakZ     sql.NullFloat64
+	// peakScore ranks samples within the episode: |z| or the distance past the bound.
+	peakScore float64
+}
+
+func reportEnergyAnomalies(ctx context.Context, out io.Writer, mysqlDSN string) error {
+	sink, err := openSink(ctx, sinkName, mysqlDSN)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	sq, ok := sink.(sqlSink)
+	if !ok {
+		return fmt.Errorf("energy anomalies reads energy_points back and needs a SQL sink, not %s", sinkName)
+	}
+	db := sq.DB()
+
+	windowStart := wallClock.Now().Add(-anomaliesWindow)
+	baselines, err := loadHourBaselines(ctx, db, windowStart.Add(-anomaliesBaseline), windowStart)
+	if err != nil {
+		return fmt.Errorf("load baselines: %w", err)
+	}
+
+	episodes, err := findEnergyAnomalies(ctx, db, windowStart, baselines)
+	if err != nil {
+		return fmt.Errorf("scan energy_points: %w", err)
+	}
+
+	printAnomalyReport(out, episodes)
+
+	if !anomaliesWriteResult || len(episodes) == 0 {
+		return nil
+	}
+	if err := sink.EnsureSchema(ctx, energyAnomaliesTable); err != nil {
+		return fmt.Errorf("ensure energy_anomalies table: %w", err)
+	}
+
+	const anomaliesBatchSize = 500
+
+	writer := newBatchWriter(sink, energyAnomaliesTable, anomaliesBatchSize)
+	for _, e := range episodes {
+		if err := writer.Add(ctx, e.entityID, e.start, e.end, e.reason, e.samples, e.peakValue, e.peakZ); err != nil {
+			return err
+		}
+	}
+	return writer.Flush(ctx)
+}
+
+// entityFilter returns the SQL condition and arguments narrowing entities to --entity.
+func entityFilter() (string, []any) {
+	if anomaliesEntity == "" {
+		return "", nil
+	}
+	return " AND entity_id LIKE ?" + likeEscape, []any{likeContains(anomaliesEntity)}
+}
+
+// loadHourBaselines returns each entity's baseline per hour of day in the export zone. The hours
+// are taken here, as standby's overnight period is, because HOUR() would read the stored times in
+// the driver's zone and shift the baseline at every DST change.
+func loadHourBaselines(ctx context.Context, db *sql.DB, from, to time.Time) (map[baselineKey]hourBaseline, error) {
+	filter, filterArgs := entityFilter()
+	query := `
+SELECT entity_id, numeric_state, last_updated
+FROM energy_points
+WHERE last_updated >= ? AND last_updated < ? AND numeric_state IS NOT NULL` + filter + `
+`
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(qctx, query, append([]any{from, to}, filterArgs...)...)
+	if err != nil {
+		return nil, explainTimeout(qctx, err)
+	}
+	defer rows.Close()
+
+	// Welford's online mean and variance, as STDDEV_POP computes them.
+	type moments struct {
+		count    int
+		mean, m2 float64
+	}
+	acc := make(map[baselineKey]*moments)
+	for rows.Next() {
+		var (
+			entityID    string
+			value       float64
+			lastUpdated time.Time
+		)
+		if err := rows.Scan(&entityID, &value, &lastUpdated); err != nil {
+			return nil, err
+		}
+		key := baselineKey{entityID: entityID, hour: inExportZone(lastUpdated).Hour()}
+		m, ok := acc[key]
+		if !ok {
+			m = &moments{}
+			acc[key] = m
+		}
+		m.count++
+		delta := value - m.mean
+		m.mean += delta / float64(m.count)
+		m.m2 += delta * (value - m.mean)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	baselines := make(map[baselineKey]hourBaseline, len(acc))
+	for key, m := range acc {
+		baselines[key] = hourBaseline{mean: m.mean, stddev: math.Sqrt(m.m2 / float64(m.count)), samples: m.count}
+	}
+	return baselines, nil
+}
+
+func findEnergyAnomalies(ctx context.Context, db *sql.DB, since time.Time, baselines map[baselineKey]hourBaseline) ([]anomalyEpisode, error) {
+	filter, filterArgs := entityFilter()
+	query := `
+SELECT entity_id, numeric_state, last_updated
+FROM energy_points
+WHERE last_updated >= ? AND numeric_state IS NOT NULL` + filter + `
+ORDER BY entity_id, last_updated
+`
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(qctx, query, append([]any{since}, filterArgs...)...)
+	if err != nil {
+		return nil, explainTimeout(qctx, err)
+	}
+	defer rows.Close()
+
+	var (
+		episodes []anomalyEpisode
+		current  *anomalyEpisode
+	)
+	for rows.Next() {
+		var (
+			entityID    string
+			value       float64
+			lastUpdated time.Time
+		)
+		if err := rows.Scan(&entityID, &value, &lastUpdated); err != nil {
+			return nil, err
+		}
+
+		reason, score, z := classifyReading(entityID, value, baselines[baselineKey{entityID: entityID, hour: inExportZone(lastUpdated).Hour()}])
+		if reason == "" {
+			continue
+		}
+
+		if current != nil && current.entityID == entityID && current.reason == reason && lastUpdated.Sub(current.end) <= anomalyEpisodeGap {
+			current.end = lastUpdated
+			current.samples++
+			if score > current.peakScore {
+				current.peakScore, current.peakValue, current.peakZ = score, value, z
+			}
+			continue
+		}
+		episodes = append(episodes, anomalyEpisode{
+			entityID:  entityID,
+			reason:    reason,
+			start:     lastUpdated,
+			end:       lastUpdated,
+			samples:   1,
+			peakValue: value,
+			peakZ:     z,
+			peakScore: score,
+		})
+		current = &episodes[len(episodes)-1]
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return episodes, nil
+}
+
+// classifyReading checks the configured bounds first, then the hour-of-day z-score. It returns an
+// empty reason for normal readings.
+func classifyReading(entityID string, value float64, baseline hourBaseline) (reason string, score float64, z sql.NullFloat64) {
+	if t, ok := appConfig.AnomalyThresholds[entityID]; ok {
+		if t.Max != nil && value > *t.Max {
+			return "above max", value - *t.Max, z
+		}
+		if t.Min != nil && value < *t.Min {
+			return "below min", *t.Min - value, z
+		}
+	}
+
+	if baseline.samples < anomaliesMinSamples || baseline.stddev == 0 {
+		return "", 0, z
+	}
+	zScore := (value - baseline.mean) / baseline.stddev
+	if math.Abs(zScore) < anomaliesZ {
+		return "", 0, z
+	}
+	reason = "z-score high"
+	if zScore < 0 {
+		reason = "z-score low"
+	}
+	return reason, math.Abs(zScore), sql.NullFloat64{Float64: zScore, Valid: true}
+}
+
+func printAnomalyReport(w io.Writer, episodes []anomalyEpisode) {
+	if len(episodes) == 0 {
+		fmt.Fprintln(w, "no anomalies found")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "entity\treason\tstarted\tended\tsamples\tpeak\tz")
+	for _, e := range episodes {
+		z := "-"
+		if e.peakZ.Valid {
+			z = fmt.Sprintf("%.1f", e.peakZ.Float64)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%g\t%s\n",
+			e.entityID, e.reason, e.start.Format(time.RFC3339), e.end.Format(time.RFC3339), e.samples, e.peakValue, z)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAnomalyBaselinesUseExportZoneHours(t *testing.T) {
+	useClock(t, "", "Europe/Berlin")
+	savedEntity, savedMinSamples, savedZ := anomaliesEntity, anomaliesMinSamples, anomaliesZ
+	t.Cleanup(func() { anomaliesEntity, anomaliesMinSamples, anomaliesZ = savedEntity, savedMinSamples, savedZ })
+	anomaliesEntity, anomaliesMinSamples, anomaliesZ = "", 5, 3
+
+	ctx := context.Background()
+	db := openFixture(t, filepath.Join(t.TempDir(), "destination.db"))
+	if _, err := db.Exec("CREATE TABLE energy_points (entity_id TEXT NOT NULL, numeric_state REAL, last_updated DATETIME)"); err != nil {
+		t.Fatal(err)
+	}
+	insert := func(at time.Time, value float64) {
+		t.Helper()
+		if _, err := db.Exec("INSERT INTO energy_points VALUES ('sensor.plug_1_power', ?, ?)", value, at.UTC()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	berlin := mustLoadLocation(t, "Europe/Berlin")
+	// Two weeks of readings at 03:00 and 04:00 Berlin time, across the change to summer time on
+	// 31 March, after which they are an hour earlier in UTC.
+	for day := 20; day < 34; day++ {
+		insert(time.Date(2024, 3, day, 3, 0, 0, 0, berlin), float64(10+day%3))
+		insert(time.Date(2024, 3, day, 4, 0, 0, 0, berlin), float64(100+day%3))
+	}
+
+	from, to := time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 3, 0, 0, 0, 0, time.UTC)
+	baselines, err := loadHourBaselines(ctx, db, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(baselines) != 2 {
+		t.Errorf("baselines cover %d hours, want 2: %v", len(baselines), baselines)
+	}
+	for hour, mean := range map[int]float64{3: 11, 4: 101} {
+		b := baselines[baselineKey{entityID: "sensor.plug_1_power", hour: hour}]
+		if b.samples != 14 || math.Abs(b.mean-mean) > 0.1 || math.Abs(b.stddev-0.8) > 0.1 {
+			t.Errorf("hour %d baseline = %+v, want 14 samples around %g", hour, b, mean)
+		}
+	}
+
+	// At 03:00 a reading of 11 is normal and one of 101, normal an hour later, is not.
+	insert(time.Date(2024, 4, 3, 3, 0, 0, 0, berlin), 11)
+	insert(time.Date(2024, 4, 3, 3, 30, 0, 0, berlin), 101)
+	insert(time.Date(2024, 4, 3, 4, 0, 0, 0, berlin), 101)
+	episodes, err := findEnergyAnomalies(ctx, db, to, baselines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(episodes) != 1 || episodes[0].reason != "z-score high" || episodes[0].peakValue != 101 || episodes[0].samples != 1 {
+		t.Fatalf("episodes = %+v, want the 03:30 reading of 101", episodes)
+	}
+	if got := inExportZone(episodes[0].start).Format("15:04"); got != "03:30" {
+		t.Errorf("episode starts at %s, want 03:30", got)
+	}
+}
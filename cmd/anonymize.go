@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// anonymizeRule fuzzes an entity's coordinates and, optionally, hides its entity_id. Unset fields
+// of a per-entity rule inherit the profile's.
+type anonymizeRule struct {
+	// Grid snaps coordinates to the centre of a cell this size, e.g. "500m".
+	Grid string `json:"grid"`
+	// Noise moves each point by a random offset of up to this distance, e.g. "200m".
+	Noise string `json:"noise"`
+	// Pseudonymize replaces the entity_id with a stable keyed hash.
+	Pseudonymize *bool `json:"pseudonymize"`
+}
+
+// anonymizeProfile is one entry of the config's "anonymize" section, selected with gps --anonymize.
+type anonymizeProfile struct {
+	anonymizeRule
+	// Salt keys the pseudonyms and the noise, so neither can be recomputed without it. Keep it
+	// stable: a new salt renames every entity.
+	Salt string `json:"salt"`
+	// Entities overrides the profile per entity_id.
+	Entities map[string]anonymizeRule `json:"entities"`
+}
+
+func (p *anonymizeProfile) validate() error {
+	if p.Salt == "" {
+		return errors.New("salt is required")
+	}
+	if _, err := p.resolve(p.anonymizeRule); err != nil {
+		return err
+	}
+	for entityID, rule := range p.Entities {
+		if _, err := p.resolve(rule); err != nil {
+			return fmt.Errorf("entities.%s: %w", entityID, err)
+		}
+	}
+	return nil
+}
+
+// anonymizer is a resolved rule for one entity.
+type anonymizer struct {
+	salt         []byte
+	gridMeters   float64
+	noiseMeters  float64
+	pseudonymize bool
+}
+
+// resolve layers rule over the profile's own rule.
+func (p *anonymizeProfile) resolve(rule anonymizeRule) (*anonymizer, error) {
+	a := &anonymizer{salt: []byte(p.Salt)}
+	grid, noise, pseudonymize := p.Grid, p.Noise, p.Pseudonymize
+	if rule.Grid != "" {
+		grid = rule.Grid
+	}
+	if rule.Noise != "" {
+		noise = rule.Noise
+	}
+	if rule.Pseudonymize != nil {
+		pseudonymize = rule.Pseudonymize
+	}
+
+	var err error
+	if grid != "" {
+		if a.gridMeters, err = parseDistance(grid); err != nil {
+			return nil, fmt.Errorf("grid: %w", err)
+		}
+	}
+	if noise != "" {
+		if a.noiseMeters, err = parseDistance(noise); err != nil {
+			return nil, fmt.Errorf("noise: %w", err)
+		}
+	}
+	a.pseudonymize = pseudonymize != nil && *pseudonymize
+	return a, nil
+}
+
+// gpsAnonymizer applies a profile to GPS rows, resolving each entity's rule once.
+type gpsAnonymizer struct {
+	profile  *anonymizeProfile
+	entities map[string]*anonymizer
+}
+
+// newGPSAnonymizer looks up the --anonymize profile in the config; it returns nil without a profile.
+func newGPSAnonymizer(name string) (*gpsAnonymizer, error) {
+	if name == "" {
+		return nil, nil
+	}
+	profile, ok := appConfig.Anonymize[name]
+	if !ok {
+		return nil, fmt.Errorf("--anonymize: no profile %q in the config's anonymize section", name)
+	}
+	return &gpsAnonymizer{profile: profile, entities: map[string]*anonymizer{}}, nil
+}
+
+// Apply fuzzes the row's coordinates (and matched coordinates, when present) and pseudonymizes its
+// entity_id. The noise is derived from the salt and state_id, so re-exports write the same values.
+func (g *gpsAnonymizer) Apply(row *gpsRow, matched *matchedPoint) error {
+	a, ok := g.entities[row.entityID]
+	if !ok {
+		var err error
+		if a, err = g.profile.resolve(g.profile.Entities[row.entityID]); err != nil {
+			return err
+		}
+		g.entities[row.entityID] = a
+	}
+
+	seed := a.mac(row.entityID + "\x00" + strconv.FormatInt(row.stateID, 10))
+	row.latitude.Float64, row.longitude.Float64 = a.fuzz(row.latitude.Float64, row.longitude.Float64, seed)
+	if matched != nil && matched.latitude.Valid && matched.longitude.Valid {
+		matched.latitude.Float64, matched.longitude.Float64 = a.fuzz(matched.latitude.Float64, matched.longitude.Float64, seed)
+	}
+	if a.pseudonymize {
+		row.entityID = a.pseudonym(row.entityID)
+	}
+	return nil
+}
+
+func (a *anonymizer) mac(message string) []byte {
+	h := hmac.New(sha256.New, a.salt)
+	h.Write([]byte(message))
+	return h.Sum(nil)
+}
+
+// pseudonym keeps the entity's domain so the rows stay recognizable as trackers or people, e.g.
+// device_tracker.phone becomes device_tracker.anon_3f9c0a1b22de.
+func (a *anonymizer) pseudonym(entityID string) string {
+	domain, _, _ := strings.Cut(entityID, ".")
+	return domain + ".anon_" + hex.EncodeToString(a.mac(entityID)[:6])
+}
+
+// metersPerDegree is the length of one degree of latitude.
+const metersPerDegree = earthRadiusMeters * math.Pi / 180
+
+// fuzz adds noise within noiseMeters (uniform over the disc) and then snaps to the grid.
+func (a *anonymizer) fuzz(lat, lon float64, seed []byte) (float64, float64) {
+	if a.noiseMeters > 0 {
+		u1 := float64(binary.BigEndian.Uint64(seed[0:8])>>11) / (1 << 53)
+		u2 := float64(binary.BigEndian.Uint64(seed[8:16])>>11) / (1 << 53)
+		distance := a.noiseMeters * math.Sqrt(u1)
+		bearing := 2 * math.Pi * u2
+		lat += distance * math.Cos(bearing) / metersPerDegree
+		lon += distance * math.Sin(bearing) / (metersPerDegree * math.Max(math.Cos(lat*math.Pi/180), 1e-6))
+	}
+	if a.gridMeters > 0 {
+		latStep := a.gridMeters / metersPerDegree
+		lat = (math.Floor(lat/latStep) + 0.5) * latStep
+		// Longitude cells keep their width in meters, so they widen in degrees towards the poles.
+		lonStep := a.gridMeters / (metersPerDegree * math.Max(math.Cos(lat*math.Pi/180), 1e-6))
+		lon = (math.Floor(lon/lonStep) + 0.5) * lonStep
+	}
+	lat = math.Max(-90, math.Min(90, lat))
+	lon = math.Mod(lon+540, 360) - 180
+	return lat, lon
+}
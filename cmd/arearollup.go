@@ -0,0 +1,300 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// groupByArea is the only --group-by value: rollups per Home Assistant area from ha_entities.
+const groupByArea = "area"
+
+// groupByUsage documents the --group-by flag of the exporters that support it.
+const groupByUsage = "Materialize daily rollups per group after the export; \"area\" groups by the Home Assistant area in ha_entities (run registry first)"
+
+func validateGroupBy(groupBy string) error {
+	if groupBy != "" && groupBy != groupByArea {
+		return fmt.Errorf("unknown --group-by %q (supported: %s)", groupBy, groupByArea)
+	}
+	return nil
+}
+
+// addGroupByFlag registers --group-by on an exporter.
+func addGroupByFlag(cmd *cobra.Command, groupBy *string) {
+	cmd.Flags().StringVar(groupBy, "group-by", "", groupByUsage)
+}
+
+// numericAreaDailyTable rolls a numeric family up per area, day, and unit. increase sums each
+// entity's max - min of the day, which is the day's consumption for cumulative meters (kWh).
+func numericAreaDailyTable(f numericFamily) *tableSpec {
+	return &tableSpec{
+		name: f.name + "_area_daily",
+		columns: []columnSpec{
+			{name: "area_id", sqlType: "VARCHAR(64) NOT NULL"},
+			{name: "day", sqlType: "DATE NOT NULL"},
+			{name: "unit", sqlType: "VARCHAR(64) NOT NULL"},
+			{name: "entities", sqlType: "INT NOT NULL"},
+			{name: "samples", sqlType: "INT NOT NULL"},
+			{name: "avg_value", sqlType: "DOUBLE NOT NULL"},
+			{name: "min_value", sqlType: "DOUBLE NOT NULL"},
+			{name: "max_value", sqlType: "DOUBLE NOT NULL"},
+			{name: "increase", sqlType: "DOUBLE NOT NULL"},
+		},
+		primaryKey: []string{"area_id", "day", "unit"},
+	}
+}
+
+// presenceAreaDailyTable counts zone stays per area of the tracking entity, day, and zone.
+var presenceAreaDailyTable = &tableSpec{
+	name: "presence_area_daily",
+	columns: []columnSpec{
+		{name: "area_id", sqlType: "VARCHAR(64) NOT NULL"},
+		{name: "day", sqlType: "DATE NOT NULL"},
+		{name: "zone", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "entities", sqlType: "INT NOT NULL"},
+		{name: "stays", sqlType: "INT NOT NULL"},
+		{name: "seconds", sqlType: "BIGINT NOT NULL"},
+	},
+	primaryKey: []string{"area_id", "day", "zone"},
+}
+
+// refreshNumericAreaDaily recomputes the family's area rollup for every export-zone day touched
+// since the given time.
+func refreshNumericAreaDaily(ctx context.Context, sink Sink, f numericFamily, since time.Time) error {
+	query := `
+SELECT e.area_id, COALESCE(p.unit, ''), p.entity_id, p.last_updated, p.numeric_state
+FROM ` + f.name + `_points p
+JOIN ` + haEntitiesTable.name + ` e ON e.entity_id = p.entity_id
+WHERE p.last_updated >= ? AND p.numeric_state IS NOT NULL AND e.area_id IS NOT NULL
+`
+	type areaDay struct{ area, day, unit string }
+	type entityDay struct {
+		areaDay
+		entityID string
+	}
+	type stats struct {
+		samples    int64
+		total      float64
+		minV, maxV float64
+		entities   int64
+		increase   float64
+	}
+	perEntity := make(map[entityDay]*stats)
+	fold := func(scan func(dest ...any) error) error {
+		var (
+			key         entityDay
+			lastUpdated sql.NullTime
+			value       float64
+		)
+		if err := scan(&key.area, &key.unit, &key.entityID, &lastUpdated, &value); err != nil {
+			return err
+		}
+		if !lastUpdated.Valid {
+			return nil
+		}
+		key.day = exportDay(lastUpdated.Time)
+		st, ok := perEntity[key]
+		if !ok {
+			st = &stats{minV: value, maxV: value}
+			perEntity[key] = st
+		}
+		st.samples++
+		st.total += value
+		st.minV, st.maxV = math.Min(st.minV, value), math.Max(st.maxV, value)
+		return nil
+	}
+	result := func() [][]any {
+		perArea := make(map[areaDay]*stats)
+		for key, e := range perEntity {
+			a, ok := perArea[key.areaDay]
+			if !ok {
+				a = &stats{minV: e.minV, maxV: e.maxV}
+				perArea[key.areaDay] = a
+			}
+			a.entities++
+			a.samples += e.samples
+			a.total += e.total
+			a.minV, a.maxV = math.Min(a.minV, e.minV), math.Max(a.maxV, e.maxV)
+			a.increase += e.maxV - e.minV
+		}
+		rows := make([][]any, 0, len(perArea))
+		for key, a := range perArea {
+			rows = append(rows, []any{key.area, key.day, key.unit, a.entities, a.samples, a.total / float64(a.samples), a.minV, a.maxV, a.increase})
+		}
+		return rows
+	}
+	return refreshDailyRollup(ctx, sink, numericAreaDailyTable(f), query, []any{rollupStart(since, 0)}, fold, result)
+}
+
+// refreshPresenceAreaDaily recomputes presence_area_daily for every export-zone day touched since
+// the given time. Ongoing stays count without a duration.
+func refreshPresenceAreaDaily(ctx context.Context, sink Sink, since time.Time) error {
+	query := `
+SELECT e.area_id, p.zone, p.entity_id, p.arrived_at, p.duration_seconds
+FROM ` + presencePointsTable.name + ` p
+JOIN ` + haEntitiesTable.name + ` e ON e.entity_id = p.entity_id
+WHERE p.arrived_at >= ? AND e.area_id IS NOT NULL
+`
+	type areaDay struct{ area, day, zone string }
+	type stays struct {
+		entities map[string]bool
+		stays    int64
+		seconds  int64
+	}
+	days := make(map[areaDay]*stays)
+	fold := func(scan func(dest ...any) error) error {
+		var (
+			key       areaDay
+			entityID  string
+			arrivedAt time.Time
+			duration  sql.NullInt64
+		)
+		if err := scan(&key.area, &key.zone, &entityID, &arrivedAt, &duration); err != nil {
+			return err
+		}
+		key.day = exportDay(arrivedAt)
+		d, ok := days[key]
+		if !ok {
+			d = &stays{entities: make(map[string]bool)}
+			days[key] = d
+		}
+		d.entities[entityID] = true
+		d.stays++
+		d.seconds += duration.Int64
+		return nil
+	}
+	result := func() [][]any {
+		rows := make([][]any, 0, len(days))
+		for key, d := range days {
+			rows = append(rows, []any{key.area, key.day, key.zone, int64(len(d.entities)), d.stays, d.seconds})
+		}
+		return rows
+	}
+	return refreshDailyRollup(ctx, sink, presenceAreaDailyTable, query, []any{rollupStart(since, 0)}, fold, result)
+}
+
+func dayStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// rollupStart returns the export-zone midnight starting the day that holds since, moved by days.
+// It is in UTC: the driver converts it to its own zone, and destinations storing times as text
+// compare them in UTC.
+func rollupStart(since time.Time, days int) time.Time {
+	return dayStart(inExportZone(since)).AddDate(0, 0, days).UTC()
+}
+
+// refreshRollup upserts the rows of query, whose columns follow table.columns, into table. The
+// rollup is computed by the destination, so sinks without SQL access skip it.
+func refreshRollup(ctx context.Context, sink Sink, table *tableSpec, query string, args ...any) error {
+	sq, ok := sink.(sqlSink)
+	if !ok {
+		return nil
+	}
+	if err := sink.EnsureSchema(ctx, table); err != nil {
+		return fmt.Errorf("ensure %s table: %w", table.name, err)
+	}
+
+	return rollupError(table, refreshRollupRows(ctx, sink, sq, table, query, args))
+}
+
+// rollupError explains a failed rollup refresh, pointing area rollups missing their entities at
+// the registry command.
+func rollupError(table *tableSpec, err error) error {
+	const mysqlErrNoSuchTable = 1146
+	if isMySQLError(err, mysqlErrNoSuchTable) && strings.Contains(err.Error(), haEntitiesTable.name) {
+		return fmt.Errorf("refresh %s: area rollups join %s; run `ha-tools registry` first: %w", table.name, haEntitiesTable.name, err)
+	}
+	if err != nil {
+		return fmt.Errorf("refresh %s: %w", table.name, err)
+	}
+	return nil
+}
+
+// refreshDailyRollup recomputes a rollup by export-zone day from the rows query reads back from the
+// destination: fold takes each row, and result returns the rollup's rows, whose columns follow
+// table.columns. The days are taken here because DATE() would read the stored times in the
+// driver's zone. Queries start at rollupStart, so the first day is complete. Sinks without SQL
+// access skip the rollup.
+func refreshDailyRollup(ctx context.Context, sink Sink, table *tableSpec, query string, args []any, fold func(scan func(dest ...any) error) error, result func() [][]any) error {
+	sq, ok := sink.(sqlSink)
+	if !ok {
+		return nil
+	}
+	if err := sink.EnsureSchema(ctx, table); err != nil {
+		return fmt.Errorf("ensure %s table: %w", table.name, err)
+	}
+
+	return rollupError(table, refreshDailyRollupRows(ctx, sink, sq, table, query, args, fold, result))
+}
+
+func refreshDailyRollupRows(ctx context.Context, sink Sink, sq sqlSink, table *tableSpec, query string, args []any, fold func(scan func(dest ...any) error) error, result func() [][]any) error {
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := sq.DB().QueryContext(qctx, query, args...)
+	if err != nil {
+		return explainTimeout(qctx, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fold(rows.Scan); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	const rollupBatchSize = 500
+	writer := newBatchWriter(sink, table, rollupBatchSize)
+	for _, row := range result() {
+		if err := writer.Add(ctx, row...); err != nil {
+			return err
+		}
+	}
+	return writer.Flush(ctx)
+}
+
+func refreshRollupRows(ctx context.Context, sink Sink, sq sqlSink, table *tableSpec, query string, args []any) error {
+	columns := table.writeColumns()
+	if destDialect.insertSelectUpsert {
+		stmt := "INSERT INTO " + table.name + " (" + strings.Join(columns, ", ") + ")" + query +
+			destDialect.upsertClause(table.name, table.primaryKey, columns, "")
+		_, err := execStatement(ctx, sq.DB(), stmt, args...)
+		return err
+	}
+
+	// Dialects without INSERT ... SELECT upserts compute the rollup with a plain SELECT.
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := sq.DB().QueryContext(qctx, query, args...)
+	if err != nil {
+		return explainTimeout(qctx, err)
+	}
+	defer rows.Close()
+
+	const rollupBatchSize = 500
+	writer := newBatchWriter(sink, table, rollupBatchSize)
+	for rows.Next() {
+		values := make([]any, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if err := writer.Add(ctx, values...); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return writer.Flush(ctx)
+}
@@ -0,0 +1,300 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxAttributesDepth bounds the nesting of an attributes document, like encoding/json does.
+const maxAttributesDepth = 10000
+
+// attributeScanner walks the top-level members of a state's attributes JSON without decoding it:
+// callers read the values of the few keys they need and every other value is skipped in place, so
+// a row costs no allocations beyond the strings kept. The whole document is still checked to be
+// valid JSON, so malformed attributes are rejected as a full decode rejects them.
+//
+//	sc := attributeScanner{raw: raw}
+//	for sc.next() {
+//		if sc.key == "unit_of_measurement" {
+//			unit, ok := sc.stringValue()
+//		}
+//	}
+//	if sc.err != nil { ... }
+type attributeScanner struct {
+	raw string
+	pos int
+	// key is the current member's key; value is its raw JSON.
+	key, value string
+	err        error
+	started    bool
+}
+
+// next moves to the next top-level member, returning false at the end of the object or on an
+// error, which err then holds. A null document has no members; any other non-object is an error.
+func (sc *attributeScanner) next() bool {
+	if sc.err != nil {
+		return false
+	}
+	if !sc.started {
+		sc.started = true
+		sc.skipSpace()
+		if strings.HasPrefix(sc.raw[sc.pos:], "null") {
+			sc.pos += len("null")
+			return sc.end()
+		}
+		if !sc.consume('{') {
+			return sc.fail("attributes are not a JSON object")
+		}
+		sc.skipSpace()
+		if sc.consume('}') {
+			return sc.end()
+		}
+	} else {
+		sc.skipSpace()
+		if sc.consume('}') {
+			return sc.end()
+		}
+		if !sc.consume(',') {
+			return sc.fail("expected ',' or '}' after object value")
+		}
+		sc.skipSpace()
+	}
+
+	keyStart := sc.pos
+	if !sc.skipString() {
+		return sc.fail("expected object key")
+	}
+	key, ok := unquoteJSONString(sc.raw[keyStart:sc.pos])
+	if !ok {
+		return sc.fail("invalid object key")
+	}
+	sc.skipSpace()
+	if !sc.consume(':') {
+		return sc.fail("expected ':' after object key")
+	}
+	sc.skipSpace()
+	valueStart := sc.pos
+	if !sc.skipValue(1) {
+		if sc.err != nil {
+			return false
+		}
+		return sc.fail("invalid value")
+	}
+	sc.key, sc.value = key, sc.raw[valueStart:sc.pos]
+	return true
+}
+
+// stringValue returns the current member's value when it is a non-blank JSON string, trimmed.
+func (sc *attributeScanner) stringValue() (string, bool) {
+	if !strings.HasPrefix(sc.value, `"`) {
+		return "", false
+	}
+	s, ok := unquoteJSONString(sc.value)
+	s = strings.TrimSpace(s)
+	return s, ok && s != ""
+}
+
+// floatValue returns the current member's value when it is a JSON number or a string holding one.
+func (sc *attributeScanner) floatValue() (float64, bool) {
+	s := sc.value
+	switch {
+	case strings.HasPrefix(s, `"`):
+		var ok bool
+		if s, ok = unquoteJSONString(s); !ok || s == "" {
+			return 0, false
+		}
+	case s == "" || s[0] != '-' && (s[0] < '0' || s[0] > '9'):
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+// end checks that only whitespace follows the document.
+func (sc *attributeScanner) end() bool {
+	sc.skipSpace()
+	if sc.pos < len(sc.raw) {
+		return sc.fail("invalid character after top-level value")
+	}
+	return false
+}
+
+func (sc *attributeScanner) fail(msg string) bool {
+	sc.err = fmt.Errorf("%s at offset %d", msg, sc.pos)
+	return false
+}
+
+func (sc *attributeScanner) consume(c byte) bool {
+	if sc.pos < len(sc.raw) && sc.raw[sc.pos] == c {
+		sc.pos++
+		return true
+	}
+	return false
+}
+
+func (sc *attributeScanner) skipSpace() {
+	for sc.pos < len(sc.raw) {
+		switch sc.raw[sc.pos] {
+		case ' ', '\t', '\n', '\r':
+			sc.pos++
+		default:
+			return
+		}
+	}
+}
+
+// skipValue moves past one JSON value nested depth levels deep, reporting whether it was valid.
+func (sc *attributeScanner) skipValue(depth int) bool {
+	if sc.pos >= len(sc.raw) {
+		return false
+	}
+	switch c := sc.raw[sc.pos]; {
+	case c == '"':
+		return sc.skipString()
+	case c == '{' || c == '[':
+		if depth >= maxAttributesDepth {
+			return sc.fail("exceeded max depth")
+		}
+		return sc.skipContainer(depth)
+	case c == '-' || (c >= '0' && c <= '9'):
+		return sc.skipNumber()
+	}
+	for _, literal := range []string{"true", "false", "null"} {
+		if strings.HasPrefix(sc.raw[sc.pos:], literal) {
+			sc.pos += len(literal)
+			return true
+		}
+	}
+	return false
+}
+
+// skipContainer moves past an object or array.
+func (sc *attributeScanner) skipContainer(depth int) bool {
+	isObject := sc.raw[sc.pos] == '{'
+	closing := byte(']')
+	if isObject {
+		closing = '}'
+	}
+	sc.pos++
+	sc.skipSpace()
+	if sc.consume(closing) {
+		return true
+	}
+	for {
+		if isObject {
+			if !sc.skipString() {
+				return false
+			}
+			sc.skipSpace()
+			if !sc.consume(':') {
+				return false
+			}
+			sc.skipSpace()
+		}
+		if !sc.skipValue(depth + 1) {
+			return false
+		}
+		sc.skipSpace()
+		if sc.consume(closing) {
+			return true
+		}
+		if !sc.consume(',') {
+			return false
+		}
+		sc.skipSpace()
+	}
+}
+
+// skipString moves past a JSON string, checking its escapes and that it holds no control
+// characters.
+func (sc *attributeScanner) skipString() bool {
+	if !sc.consume('"') {
+		return false
+	}
+	for sc.pos < len(sc.raw) {
+		switch c := sc.raw[sc.pos]; {
+		case c == '"':
+			sc.pos++
+			return true
+		case c < 0x20:
+			return false
+		case c == '\\':
+			sc.pos++
+			if sc.pos >= len(sc.raw) {
+				return false
+			}
+			switch sc.raw[sc.pos] {
+			case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+				sc.pos++
+			case 'u':
+				if sc.pos+5 > len(sc.raw) || !isHex4(sc.raw[sc.pos+1:sc.pos+5]) {
+					return false
+				}
+				sc.pos += 5
+			default:
+				return false
+			}
+		default:
+			sc.pos++
+		}
+	}
+	return false
+}
+
+// skipNumber moves past a JSON number: -?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?
+func (sc *attributeScanner) skipNumber() bool {
+	sc.consume('-')
+	if sc.consume('0') {
+		// No leading zeros.
+	} else if !sc.skipDigits() {
+		return false
+	}
+	if sc.consume('.') && !sc.skipDigits() {
+		return false
+	}
+	if sc.consume('e') || sc.consume('E') {
+		if !sc.consume('+') {
+			sc.consume('-')
+		}
+		if !sc.skipDigits() {
+			return false
+		}
+	}
+	return true
+}
+
+func (sc *attributeScanner) skipDigits() bool {
+	start := sc.pos
+	for sc.pos < len(sc.raw) && sc.raw[sc.pos] >= '0' && sc.raw[sc.pos] <= '9' {
+		sc.pos++
+	}
+	return sc.pos > start
+}
+
+func isHex4(s string) bool {
+	for i := 0; i < 4; i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// unquoteJSONString returns the text of a quoted JSON string that skipString accepted. Strings
+// without escapes are sliced out of the document; the rest go through encoding/json, which also
+// replaces invalid UTF-8 the way a full decode does.
+func unquoteJSONString(quoted string) (string, bool) {
+	inner := quoted[1 : len(quoted)-1]
+	if !strings.ContainsRune(inner, '\\') && utf8.ValidString(inner) {
+		return inner, true
+	}
+	var s string
+	if err := json.Unmarshal([]byte(quoted), &s); err != nil {
+		return "", false
+	}
+	return s, true
+}
@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// decodeStateMetadata is how extractStateMetadata read attributes before attributeScanner: a full
+// decode into a map. The scanner must agree with it on every document.
+func decodeStateMetadata(raw string) (stateMetadata, error) {
+	meta := stateMetadata{}
+	trimmed := strings.TrimSpace(raw)
+	if trimmed == "" {
+		return meta, nil
+	}
+
+	var attrs map[string]any
+	if err := json.Unmarshal([]byte(trimmed), &attrs); err != nil {
+		return meta, fmt.Errorf("unmarshal shared_attrs: %w", err)
+	}
+	pick := func(key string) sql.NullString {
+		v, ok := pickString(attrs[key])
+		return sql.NullString{String: v, Valid: ok}
+	}
+	meta.Unit = pick("unit_of_measurement")
+	meta.DeviceClass = pick("device_class")
+	meta.StateClass = pick("state_class")
+	meta.FriendlyName = pick("friendly_name")
+	return meta, nil
+}
+
+// energyAttributes is a smart plug power sensor's attributes as the recorder stores them.
+const energyAttributes = `{"state_class":"measurement","unit_of_measurement":"W","device_class":"power","friendly_name":"Washing Machine Plug Power","icon":"mdi:flash","attribution":"Data provided by the plug","last_reset":null,"options":["low","high"],"device":{"manufacturer":"Shelly","model":"Plug S","sw_version":"20230913-112003/v1.14.0-gcb84623"}}`
+
+var attributeDocuments = []struct {
+	name string
+	raw  string
+}{
+	{"empty", ``},
+	{"blank", " \n\t"},
+	{"null", `null`},
+	{"empty object", `{}`},
+	{"energy sensor", energyAttributes},
+	{"missing keys", `{"icon":"mdi:flash","friendly_name":"Only a name"}`},
+	{"no wanted keys", `{"a":1,"b":[true,false,null]}`},
+	{"non-string values", `{"unit_of_measurement":5,"device_class":null,"state_class":["measurement"],"friendly_name":{"en":"Plug"}}`},
+	{"blank and padded strings", `{"unit_of_measurement":"  ","friendly_name":"  Plug  "}`},
+	{"duplicate keys", `{"unit_of_measurement":"W","unit_of_measurement":"kW","device_class":"power","device_class":null}`},
+	{"whitespace", " {\n  \"unit_of_measurement\" : \"W\" ,\r\n\t\"device_class\":\"power\"\n} \n"},
+	{"escaped strings", `{"friendly_name":"Plug \"Kitchen\" \\ back\/slash\n\t\b\f\r","unit_of_measurement":"W"}`},
+	{"escaped key", `{"unit_of_measurement":"W","device_class":"power"}`},
+	{"unicode escapes", `{"unit_of_measurement":"°C","friendly_name":"Straße 温度"}`},
+	{"surrogate pair", `{"friendly_name":"Plug 🔌"}`},
+	{"lone surrogate", `{"friendly_name":"Plug \ud83d"}`},
+	{"raw unicode", `{"unit_of_measurement":"°C","friendly_name":"Küche 🔌"}`},
+	{"invalid utf-8", "{\"friendly_name\":\"Plug \xff\xfe\",\"unit_of_measurement\":\"W\"}"},
+	{"nested objects and arrays", `{"device":{"unit_of_measurement":"inner","nested":[{"friendly_name":"inner"},[[]],{}]},"unit_of_measurement":"W","options":[1,-2.5e3,"x",{"y":[null]}]}`},
+	{"numbers", `{"a":0,"b":-0.5,"c":1e10,"d":2E-3,"e":12.25e+2,"unit_of_measurement":"W"}`},
+	{"deep nesting", `{"a":` + strings.Repeat("[", 500) + strings.Repeat("]", 500) + `,"unit_of_measurement":"W"}`},
+
+	{"not an object", `["unit_of_measurement","W"]`},
+	{"string document", `"W"`},
+	{"number document", `42`},
+	{"trailing comma", `{"unit_of_measurement":"W",}`},
+	{"trailing garbage", `{"unit_of_measurement":"W"} x`},
+	{"two documents", `{"unit_of_measurement":"W"}{}`},
+	{"unterminated object", `{"unit_of_measurement":"W"`},
+	{"unterminated string", `{"unit_of_measurement":"W}`},
+	{"missing colon", `{"unit_of_measurement" "W"}`},
+	{"unquoted key", `{unit_of_measurement:"W"}`},
+	{"single quotes", `{'unit_of_measurement':'W'}`},
+	{"bad escape", `{"friendly_name":"Plug \x41"}`},
+	{"short unicode escape", `{"friendly_name":"Plug \u00b"}`},
+	{"control character", "{\"friendly_name\":\"Plug\x01\"}"},
+	{"leading zero", `{"a":01,"unit_of_measurement":"W"}`},
+	{"bare minus", `{"a":-,"unit_of_measurement":"W"}`},
+	{"missing fraction digits", `{"a":1.,"unit_of_measurement":"W"}`},
+	{"missing exponent digits", `{"a":1e,"unit_of_measurement":"W"}`},
+	{"bad literal", `{"a":tru,"unit_of_measurement":"W"}`},
+	{"unclosed nested array", `{"a":[1,2,"unit_of_measurement":"W"}`},
+	{"malformed after wanted keys", `{"unit_of_measurement":"W","device_class":"power","x":[}`},
+	{"null with garbage", `null x`},
+}
+
+func TestExtractStateMetadataMatchesJSONDecode(t *testing.T) {
+	for _, doc := range attributeDocuments {
+		t.Run(doc.name, func(t *testing.T) {
+			want, wantErr := decodeStateMetadata(doc.raw)
+			got, gotErr := extractStateMetadata(doc.raw)
+			if (gotErr != nil) != (wantErr != nil) {
+				t.Fatalf("extractStateMetadata(%q) error = %v, encoding/json's = %v", doc.raw, gotErr, wantErr)
+			}
+			if wantErr == nil && got != want {
+				t.Errorf("extractStateMetadata(%q) = %+v, encoding/json reads %+v", doc.raw, got, want)
+			}
+		})
+	}
+}
+
+func TestExtractStateMetadataRejectsTooDeepDocuments(t *testing.T) {
+	raw := `{"a":` + strings.Repeat("[", maxAttributesDepth+1) + strings.Repeat("]", maxAttributesDepth+1) + `}`
+	if _, err := decodeStateMetadata(raw); err == nil {
+		t.Fatal("encoding/json accepted the document; the depth limit changed")
+	}
+	if _, err := extractStateMetadata(raw); err == nil {
+		t.Error("extractStateMetadata accepted a document nested deeper than encoding/json allows")
+	}
+}
+
+func FuzzExtractStateMetadata(f *testing.F) {
+	for _, doc := range attributeDocuments {
+		f.Add(doc.raw)
+	}
+	f.Fuzz(func(t *testing.T, raw string) {
+		want, wantErr := decodeStateMetadata(raw)
+		got, gotErr := extractStateMetadata(raw)
+		if (gotErr != nil) != (wantErr != nil) {
+			t.Fatalf("extractStateMetadata(%q) error = %v, encoding/json's = %v", raw, gotErr, wantErr)
+		}
+		if wantErr == nil && got != want {
+			t.Errorf("extractStateMetadata(%q) = %+v, encoding/json reads %+v", raw, got, want)
+		}
+	})
+}
+
+// BenchmarkExtractEnergyMetadata compares the full decode extractStateMetadata used to do per
+// exported row with the scanner it uses now, on a smart plug's attributes.
+func BenchmarkExtractEnergyMetadata(b *testing.B) {
+	for _, bench := range []struct {
+		name    string
+		extract func(string) (stateMetadata, error)
+	}{
+		{"json.Unmarshal", decodeStateMetadata},
+		{"attributeScanner", extractStateMetadata},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(energyAttributes)))
+			for i := 0; i < b.N; i++ {
+				if _, err := bench.extract(energyAttributes); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// trackerAttributes is a phone's device tracker attributes as the recorder stores them.
+const trackerAttributes = `{"source_type":"gps","battery_level":87,"latitude":52.37403,"longitude":4.88969,"gps_accuracy":12,"altitude":3.2,"course":180,"speed":0,"vertical_accuracy":3,"friendly_name":"Phone","icon":"mdi:cellphone"}`
+
+// decodeBatteryLevel is how extractBatteryLevel read attributes before attributeScanner.
+func decodeBatteryLevel(state, raw string) (sql.NullFloat64, error) {
+	trimmed := strings.TrimSpace(raw)
+	if trimmed == "" {
+		return sql.NullFloat64{}, nil
+	}
+
+	var attrs map[string]any
+	if err := json.Unmarshal([]byte(trimmed), &attrs); err != nil {
+		return sql.NullFloat64{}, fmt.Errorf("unmarshal shared_attrs: %w", err)
+	}
+	if v, ok := pickFloat(attrs["battery_level"]); ok {
+		return sql.NullFloat64{Float64: v, Valid: true}, nil
+	}
+	if class, ok := pickString(attrs["device_class"]); ok && class == "battery" {
+		return parseNumericState(state), nil
+	}
+	return sql.NullFloat64{}, nil
+}
+
+func TestExtractBatteryLevelMatchesJSONDecode(t *testing.T) {
+	documents := append([]struct {
+		name string
+		raw  string
+	}{
+		{"phone tracker", trackerAttributes},
+		{"battery sensor", `{"unit_of_measurement":"%","device_class":"battery","friendly_name":"Phone Battery"}`},
+		{"padded battery class", `{"device_class":"  battery "}`},
+		{"level and class", `{"device_class":"battery","battery_level":12.5}`},
+		{"string level", `{"battery_level":"42"}`},
+		{"blank string level", `{"battery_level":"","device_class":"battery"}`},
+		{"escaped string level", `{"battery_level":"\u0034\u0032"}`},
+		{"non-numeric level", `{"battery_level":"low","device_class":"battery"}`},
+		{"null level", `{"battery_level":null,"device_class":"battery"}`},
+		{"boolean level", `{"battery_level":true}`},
+		{"object level", `{"battery_level":{"value":50}}`},
+		{"negative and exponent", `{"battery_level":-1.5e1}`},
+		{"duplicate level", `{"battery_level":10,"battery_level":"x","device_class":"battery"}`},
+		{"duplicate class", `{"device_class":"battery","device_class":"power"}`},
+		{"nested level", `{"device":{"battery_level":80}}`},
+	}, attributeDocuments...)
+	for _, state := range []string{"55", "unavailable", ""} {
+		for _, doc := range documents {
+			t.Run(doc.name+"/"+state, func(t *testing.T) {
+				want, wantErr := decodeBatteryLevel(state, doc.raw)
+				got, gotErr := extractBatteryLevel(state, doc.raw)
+				if (gotErr != nil) != (wantErr != nil) {
+					t.Fatalf("extractBatteryLevel(%q, %q) error = %v, encoding/json's = %v", state, doc.raw, gotErr, wantErr)
+				}
+				if wantErr == nil && got != want {
+					t.Errorf("extractBatteryLevel(%q, %q) = %+v, encoding/json reads %+v", state, doc.raw, got, want)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkExtractBatteryLevel compares the full decode extractBatteryLevel used to do with the
+// scanner, on a phone tracker's attributes.
+func BenchmarkExtractBatteryLevel(b *testing.B) {
+	for _, bench := range []struct {
+		name    string
+		extract func(string, string) (sql.NullFloat64, error)
+	}{
+		{"json.Unmarshal", decodeBatteryLevel},
+		{"attributeScanner", extractBatteryLevel},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(trackerAttributes)))
+			for i := 0; i < b.N; i++ {
+				if _, err := bench.extract("home", trackerAttributes); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	automationsSQLitePaths []string
+	automationsMySQLDSN    string
+	automationsTraces      bool
+)
+
+// automationsCmd exports automation and script runs from the recorder's event log.
+var automationsCmd = &cobra.Command{
+	Use:   "automations",
+	Short: "Export Home Assistant automation and script runs into MySQL",
+	Long:  "Reads automation_triggered and script_started events from the Home Assistant SQLite recorder database and upserts one row per run, with the trigger source and context id, into an automation_runs table. With --traces, the traces Home Assistant saved in .storage/trace.saved_traces are also upserted into automation_traces with their start, finish, duration, and outcome; they join to the runs on context_id.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(automationsSQLitePaths) == 0 {
+			return errors.New("sqlite database path is required")
+		}
+		if automationsMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		return forEachRecorder(ctx, automationsSQLitePaths, func(sqlitePath string) error {
+			return transferAutomationData(ctx, sqlitePath, automationsMySQLDSN)
+		})
+	},
+}
+
+func init() {
+	automationsCmd.Flags().StringArrayVar(&automationsSQLitePaths, "sqlite", nil, recorderFlagUsage)
+	automationsCmd.Flags().StringVar(&automationsMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	automationsCmd.Flags().BoolVar(&automationsTraces, "traces", false, "Also export the saved traces of .storage/trace.saved_traces into automation_traces")
+	automationsCmd.Flags().StringVar(&storageDir, "storage-dir", "", "Home Assistant .storage directory with the saved traces and the entity registry (default: .storage next to the recorder)")
+	_ = automationsCmd.MarkFlagRequired("sqlite")
+	_ = automationsCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(automationsCmd)
+}
+
+// automationRunEvents are the recorder event types that start a run, by the domain they belong to.
+var automationRunEvents = map[string]string{
+	"automation_triggered": "automation",
+	"script_started":       "script",
+}
+
+// automationRunsTable holds one row per automation_triggered or script_started event.
+var automationRunsTable = &tableSpec{
+	name: "automation_runs",
+	columns: []columnSpec{
+		{name: "event_id", sqlType: "BIGINT NOT NULL"},
+		{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "domain", sqlType: "VARCHAR(32) NOT NULL"},
+		{name: "name", sqlType: "VARCHAR(255) NULL"},
+		{name: "source", sqlType: "TEXT NULL"},
+		{name: "context_id", sqlType: "CHAR(26) NULL"},
+		{name: "fired_at", sqlType: "DATETIME(6) NULL"},
+	},
+	primaryKey:    []string{"event_id"},
+	entityColumn:  "entity_id",
+	timeColumn:    "fired_at",
+	idColumn:      "event_id",
+	indexDefaults: []string{"entity-time"},
+	history:       true,
+}
+
+// automationTracesTable holds the saved traces, keyed like Home Assistant keys them: the trace key
+// (domain.item_id) and run id. Home Assistant keeps only the newest few traces per automation, so
+// rows accumulate across runs of the exporter.
+var automationTracesTable = &tableSpec{
+	name: "automation_traces",
+	columns: []columnSpec{
+		{name: "trace_key", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "run_id", sqlType: "VARCHAR(64) NOT NULL"},
+		{name: "entity_id", sqlType: "VARCHAR(255) NULL"},
+		{name: "domain", sqlType: "VARCHAR(32) NOT NULL"},
+		{name: "context_id", sqlType: "CHAR(26) NULL"},
+		{name: "trigger_description", sqlType: "TEXT NULL"},
+		{name: "state", sqlType: "VARCHAR(32) NULL"},
+		{name: "script_execution", sqlType: "VARCHAR(64) NULL"},
+		{name: "last_step", sqlType: "VARCHAR(255) NULL"},
+		{name: "error", sqlType: "TEXT NULL"},
+		{name: "started_at", sqlType: "DATETIME NULL"},
+		{name: "finished_at", sqlType: "DATETIME NULL"},
+		{name: "duration_ms", sqlType: "BIGINT NULL"},
+	},
+	primaryKey: []string{"trace_key", "run_id"},
+	indexes:    []indexSpec{{name: "idx_automation_traces_context", columns: []string{"context_id"}}},
+}
+
+func transferAutomationData(ctx context.Context, sqlitePath, mysqlDSN string) error {
+	sqliteDB, err := openRecorder(ctx, sqlitePath)
+	if err != nil {
+		return err
+	}
+	defer sqliteDB.Close()
+
+	sink, err := openExportSink(ctx, mysqlDSN)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	if err := transferAutomationRuns(ctx, sqliteDB, sink); err != nil {
+		return err
+	}
+	if !automationsTraces {
+		return nil
+	}
+	return transferAutomationTraces(ctx, sink, homeAssistantStorageDir(sqlitePath))
+}
+
+func transferAutomationRuns(ctx context.Context, sqliteDB *sql.DB, sink Sink) error {
+	if err := sink.EnsureSchema(ctx, automationRunsTable); err != nil {
+		return fmt.Errorf("ensure automation_runs table: %w", err)
+	}
+	entityWatermarks, err := loadWatermarks(ctx, sink, automationRunsTable)
+	if err != nil {
+		return fmt.Errorf("load automation checkpoints: %w", err)
+	}
+	watermarkTies, err := loadWatermarkTies(ctx, sink, automationRunsTable)
+	if err != nil {
+		return fmt.Errorf("load automation checkpoints: %w", err)
+	}
+
+	// Recorder schemas before 2023.4 are read through shims (see recorderschema.go), whose inline
+	// context ids are left out.
+	const query = `
+SELECT
+    e.event_id,
+    et.event_type,
+    COALESCE(ed.shared_data, ''),
+    e.time_fired_ts,
+    e.context_id_bin
+FROM events e
+JOIN event_types et ON e.event_type_id = et.event_type_id
+LEFT JOIN event_data ed ON e.data_id = ed.data_id
+WHERE et.event_type IN ('automation_triggered', 'script_started')
+ORDER BY e.event_id
+`
+	rows, err := sqliteDB.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("query sqlite database: %w", err)
+	}
+	defer rows.Close()
+
+	const automationsBatchSize = 500
+
+	writer := newBatchWriter(sink, automationRunsTable, automationsBatchSize)
+	for rows.Next() {
+		var (
+			eventID      int64
+			eventType    string
+			eventData    string
+			timeFiredVal sql.NullFloat64
+			contextID    []byte
+		)
+		if err := rows.Scan(&eventID, &eventType, &eventData, &timeFiredVal, &contextID); err != nil {
+			return fmt.Errorf("scan sqlite row: %w", err)
+		}
+
+		var data struct {
+			EntityID string  `json:"entity_id"`
+			Name     *string `json:"name"`
+			Source   *string `json:"source"`
+		}
+		if err := json.Unmarshal([]byte(eventData), &data); err != nil || data.EntityID == "" {
+			if err == nil {
+				err = errors.New("no entity_id")
+			}
+			if err := skipBadRow(eventType, eventID, fmt.Errorf("parse data of event_id %d: %w", eventID, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		firedAt, err := floatToNullTime(timeFiredVal)
+		if err != nil {
+			if err := skipBadRow(data.EntityID, eventID, fmt.Errorf("convert time_fired_ts for event_id %d: %w", eventID, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		if firedAt.Valid && exportedBefore(entityWatermarks, watermarkTies, data.EntityID, firedAt.Time, eventID) {
+			exportedRows.add(automationRunsTable.name, 1)
+			continue
+		}
+
+		if err := writer.Add(ctx, eventID, data.EntityID, automationRunEvents[eventType], data.Name, data.Source, ulidString(contextID), firedAt); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate sqlite rows: %w", err)
+	}
+	if err := writer.Flush(ctx); err != nil {
+		return err
+	}
+	return finalizeTable(ctx, sink, automationRunsTable)
+}
+
+// savedTrace is the extended form of one trace in trace.saved_traces.
+type savedTrace struct {
+	ExtendedDict struct {
+		RunID           string  `json:"run_id"`
+		Domain          string  `json:"domain"`
+		ItemID          string  `json:"item_id"`
+		State           *string `json:"state"`
+		ScriptExecution *string `json:"script_execution"`
+		LastStep        *string `json:"last_step"`
+		Error           *string `json:"error"`
+		Trigger         *string `json:"trigger"`
+		Timestamp       struct {
+			Start  *string `json:"start"`
+			Finish *string `json:"finish"`
+		} `json:"timestamp"`
+		Context struct {
+			ID *string `json:"id"`
+		} `json:"context"`
+	} `json:"extended_dict"`
+}
+
+// transferAutomationTraces upserts every saved trace. The traces name automations by their
+// config id; the entity registry maps that to the entity_id, which stays NULL for unknown ids.
+func transferAutomationTraces(ctx context.Context, sink Sink, dir string) error {
+	var traces map[string][]savedTrace
+	if err := readStorageFile(dir, "trace.saved_traces", &traces); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// Home Assistant writes the file when it stops; until then there is nothing to export.
+			fmt.Fprintf(os.Stderr, "no saved traces: %v\n", err)
+			return nil
+		}
+		return err
+	}
+	entityIDs, err := traceEntityIDs(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := sink.EnsureSchema(ctx, automationTracesTable); err != nil {
+		return fmt.Errorf("ensure automation_traces table: %w", err)
+	}
+
+	const tracesBatchSize = 500
+
+	writer := newBatchWriter(sink, automationTracesTable, tracesBatchSize)
+	for key, list := range traces {
+		for _, trace := range list {
+			t := trace.ExtendedDict
+			if t.RunID == "" {
+				continue
+			}
+			domain := t.Domain
+			if domain == "" {
+				domain, _, _ = strings.Cut(key, ".")
+			}
+			var entityID sql.NullString
+			if id, ok := entityIDs[domain+"."+t.ItemID]; ok {
+				entityID = sql.NullString{String: id, Valid: true}
+			} else if domain == "script" {
+				entityID = sql.NullString{String: "script." + t.ItemID, Valid: true}
+			}
+			started, finished := parseTraceTime(t.Timestamp.Start), parseTraceTime(t.Timestamp.Finish)
+			var duration sql.NullInt64
+			if started.Valid && finished.Valid {
+				duration = sql.NullInt64{Int64: finished.Time.Sub(started.Time).Milliseconds(), Valid: true}
+			}
+			if err := writer.Add(ctx, key, t.RunID, entityID, domain, t.Context.ID, t.Trigger, t.State, t.ScriptExecution, t.LastStep, t.Error, started, finished, duration); err != nil {
+				return err
+			}
+		}
+	}
+	if err := writer.Flush(ctx); err != nil {
+		return err
+	}
+	return finalizeTable(ctx, sink, automationTracesTable)
+}
+
+// traceEntityIDs maps the trace keys of registered automations and scripts to their entity_ids.
+// Scripts are keyed by their object id, so one missing from the registry resolves without it.
+func traceEntityIDs(dir string) (map[string]string, error) {
+	entities, err := loadEntityRegistry(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	ids := make(map[string]string)
+	for _, e := range entities {
+		if e.Platform == "automation" || e.Platform == "script" {
+			ids[e.Platform+"."+e.UniqueID] = e.EntityID
+		}
+	}
+	return ids, nil
+}
+
+func parseTraceTime(s *string) sql.NullTime {
+	if s == nil {
+		return sql.NullTime{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, *s)
+	if err != nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+// ulidString renders a recorder context_id_bin as the ULID Home Assistant shows for the context.
+func ulidString(b []byte) sql.NullString {
+	if len(b) != 16 {
+		return sql.NullString{}
+	}
+	const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	n := new(big.Int).SetBytes(b)
+	mask := big.NewInt(31)
+	out := make([]byte, 26)
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = crockford[new(big.Int).And(n, mask).Int64()]
+		n.Rsh(n, 5)
+	}
+	return sql.NullString{String: string(out), Valid: true}
+}
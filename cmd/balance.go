@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	balanceSQLitePaths []string
+	balanceMySQLDSN    string
+	balanceProduction  []string
+	balanceConsumption []string
+	balanceGridImport  []string
+	balanceGridExport  []string
+)
+
+// energyBalanceCmd derives hourly solar self-consumption and grid flows from energy statistics.
+var energyBalanceCmd = &cobra.Command{
+	Use:   "balance",
+	Short: "Export hourly production/consumption balance into energy_balance",
+	Long:  "Reads the hourly long-term statistics of the given production meters and either consumption meters or grid import/export meters, and upserts per-hour production, consumption, self-consumption, grid import, and grid export in kWh into an energy_balance table, following the energy dashboard's semantics.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(balanceSQLitePaths) == 0 {
+			return errors.New("sqlite database path is required")
+		}
+		if balanceMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+		if len(balanceProduction) == 0 {
+			return errors.New("at least one --production statistic is required")
+		}
+		gridMeters := len(balanceGridImport) > 0 || len(balanceGridExport) > 0
+		if len(balanceConsumption) > 0 == gridMeters {
+			return errors.New("set either --consumption or --grid-import/--grid-export")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		return forEachRecorder(ctx, balanceSQLitePaths, func(sqlitePath string) error {
+			return transferEnergyBalance(ctx, sqlitePath, balanceMySQLDSN)
+		})
+	},
+}
+
+func init() {
+	energyBalanceCmd.Flags().StringArrayVar(&balanceSQLitePaths, "sqlite", nil, recorderFlagUsage)
+	energyBalanceCmd.Flags().StringVar(&balanceMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	energyBalanceCmd.Flags().StringSliceVar(&balanceProduction, "production", nil, "Statistic ids of energy production meters, e.g. sensor.solar_energy (repeatable)")
+	energyBalanceCmd.Flags().StringSliceVar(&balanceConsumption, "consumption", nil, "Statistic ids of household consumption meters (repeatable)")
+	energyBalanceCmd.Flags().StringSliceVar(&balanceGridImport, "grid-import", nil, "Statistic ids of grid import meters, instead of --consumption (repeatable)")
+	energyBalanceCmd.Flags().StringSliceVar(&balanceGridExport, "grid-export", nil, "Statistic ids of grid export (return) meters (repeatable)")
+	_ = energyBalanceCmd.MarkFlagRequired("sqlite")
+	_ = energyBalanceCmd.MarkFlagRequired("dsn")
+
+	energyCmd.AddCommand(energyBalanceCmd)
+}
+
+var energyBalanceTable = &tableSpec{
+	name: "energy_balance",
+	columns: []columnSpec{
+		{name: "period_start", sqlType: "DATETIME NOT NULL"},
+		{name: "production_kwh", sqlType: "DOUBLE NOT NULL"},
+		{name: "consumption_kwh", sqlType: "DOUBLE NOT NULL"},
+		{name: "self_consumption_kwh", sqlType: "DOUBLE NOT NULL"},
+		{name: "grid_import_kwh", sqlType: "DOUBLE NOT NULL"},
+		{name: "grid_export_kwh", sqlType: "DOUBLE NOT NULL"},
+	},
+	primaryKey: []string{"period_start"},
+}
+
+// energyFlows are one hour's kWh per meter role.
+type energyFlows struct {
+	production  float64
+	consumption float64
+	gridImport  float64
+	gridExport  float64
+}
+
+// balance splits the hour into self-consumed production and grid flows. With consumption meters
+// the grid flows are whatever production does not cover (or exceeds); with grid meters, as in the
+// energy dashboard, self-consumption is production minus export and consumption is import plus
+// self-consumption.
+func (f energyFlows) balance(gridMeters bool) (consumption, selfConsumption, gridImport, gridExport float64) {
+	if gridMeters {
+		selfConsumption = math.Max(f.production-f.gridExport, 0)
+		return f.gridImport + selfConsumption, selfConsumption, f.gridImport, f.gridExport
+	}
+	selfConsumption = math.Max(math.Min(f.production, f.consumption), 0)
+	return f.consumption, selfConsumption, f.consumption - selfConsumption, f.production - selfConsumption
+}
+
+func transferEnergyBalance(ctx context.Context, sqlitePath, mysqlDSN string) error {
+	sqliteDB, err := openRecorder(ctx, sqlitePath)
+	if err != nil {
+		return err
+	}
+	defer sqliteDB.Close()
+
+	sink, err := openExportSink(ctx, mysqlDSN)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	if err := sink.EnsureSchema(ctx, energyBalanceTable); err != nil {
+		return fmt.Errorf("ensure energy_balance table: %w", err)
+	}
+
+	hours := make(map[time.Time]*energyFlows)
+	roles := []struct {
+		statisticIDs []string
+		field        func(*energyFlows) *float64
+	}{
+		{balanceProduction, func(f *energyFlows) *float64 { return &f.production }},
+		{balanceConsumption, func(f *energyFlows) *float64 { return &f.consumption }},
+		{balanceGridImport, func(f *energyFlows) *float64 { return &f.gridImport }},
+		{balanceGridExport, func(f *energyFlows) *float64 { return &f.gridExport }},
+	}
+	for _, role := range roles {
+		for _, statisticID := range role.statisticIDs {
+			err := loadHourlyEnergy(ctx, sqliteDB, statisticID, func(start time.Time, kwh float64) {
+				flows, ok := hours[start]
+				if !ok {
+					flows = &energyFlows{}
+					hours[start] = flows
+				}
+				*role.field(flows) += kwh
+			})
+			if err != nil {
+				return fmt.Errorf("load statistics for %s: %w", statisticID, err)
+			}
+		}
+	}
+
+	starts := make([]time.Time, 0, len(hours))
+	for start := range hours {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	const balanceBatchSize = 500
+
+	gridMeters := len(balanceGridImport) > 0 || len(balanceGridExport) > 0
+	writer := newBatchWriter(sink, energyBalanceTable, balanceBatchSize)
+	for _, start := range starts {
+		flows := hours[start]
+		consumption, selfConsumption, gridImport, gridExport := flows.balance(gridMeters)
+		if err := writer.Add(ctx, start, flows.production, consumption, selfConsumption, gridImport, gridExport); err != nil {
+			return err
+		}
+	}
+	return writer.Flush(ctx)
+}
+
+// loadHourlyEnergy calls fn with the kWh of every hour of a cumulative energy statistic, taken as
+// the change of its sum since the previous hour. The first hour has no predecessor and is skipped.
+func loadHourlyEnergy(ctx context.Context, sqliteDB *sql.DB, statisticID string, fn func(start time.Time, kwh float64)) error {
+	var unit sql.NullString
+	err := sqliteDB.QueryRowContext(ctx, "SELECT unit_of_measurement FROM statistics_meta WHERE statistic_id = ?", statisticID).Scan(&unit)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("no such statistic in statistics_meta")
+	}
+	if err != nil {
+		return fmt.Errorf("query sqlite database: %w", err)
+	}
+	scale, err := energyUnitScale(unit.String)
+	if err != nil {
+		return err
+	}
+
+	const query = `
+SELECT s.start_ts, s.sum
+FROM statistics s
+JOIN statistics_meta m ON s.metadata_id = m.id
+WHERE m.statistic_id = ? AND s.sum IS NOT NULL
+ORDER BY s.start_ts
+`
+	rows, err := sqliteDB.QueryContext(ctx, query, statisticID)
+	if err != nil {
+		return fmt.Errorf("query sqlite database: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		prevSum  float64
+		havePrev bool
+	)
+	for rows.Next() {
+		var (
+			startVal sql.NullFloat64
+			sum      float64
+		)
+		if err := rows.Scan(&startVal, &sum); err != nil {
+			return fmt.Errorf("scan sqlite row: %w", err)
+		}
+		start, err := floatToNullTime(startVal)
+		if err != nil {
+			return fmt.Errorf("convert start_ts: %w", err)
+		}
+		if havePrev && start.Valid {
+			fn(start.Time, (sum-prevSum)*scale)
+		}
+		prevSum, havePrev = sum, true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate sqlite rows: %w", err)
+	}
+	return nil
+}
+
+// energyUnitScale converts a statistic's unit to kWh.
+func energyUnitScale(unit string) (float64, error) {
+	switch strings.ToLower(unit) {
+	case "wh":
+		return 0.001, nil
+	case "kwh":
+		return 1, nil
+	case "mwh":
+		return 1000, nil
+	default:
+		return 0, fmt.Errorf("unit %q is not an energy unit (Wh, kWh, MWh)", unit)
+	}
+}
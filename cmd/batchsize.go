@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// maxBatchRows caps adaptive batches, however small the rows, to bound memory and the work a
+	// failed batch repeats.
+	maxBatchRows = 5000
+	// maxPlaceholders is the most parameters a MySQL prepared statement takes.
+	maxPlaceholders = 65535
+)
+
+// adaptBatchSize resizes the writer's batches to the sink's byte limit, using the rows about to be
+// written to table as the sample of its row size.
+func (b *batchWriter) adaptBatchSize(table *tableSpec, rows [][]any) {
+	sizer, ok := b.sink.(batchSizer)
+	if !ok || b.fixedSize || len(rows) == 0 {
+		return
+	}
+	total := 0
+	for _, row := range rows {
+		total += estimateRowBytes(row)
+	}
+	if n := sizer.BatchRows(table, total/len(rows)+1); n > 0 {
+		b.limit.Store(int64(n))
+	}
+}
+
+// batchRows is the number of rows the writer currently sends per batch.
+func (b *batchWriter) batchRows() int {
+	if n := b.limit.Load(); n > 0 {
+		return int(n)
+	}
+	return b.size
+}
+
+// estimateRowBytes approximates the bytes a row takes in a write statement.
+func estimateRowBytes(row []any) int {
+	n := 0
+	for _, v := range row {
+		// Each value carries a type and length header.
+		n += 4
+		switch v := v.(type) {
+		case nil:
+		case string:
+			n += len(v)
+		case []byte:
+			n += len(v)
+		case sql.NullString:
+			n += len(v.String)
+		case time.Time, sql.NullTime:
+			n += 12
+		default:
+			n += 8
+		}
+	}
+	return n
+}
+
+// BatchRows sizes batches to half of max_allowed_packet, leaving room for the statement text and
+// for rows larger than the average. A batch split after a packet-too-large error halves the budget
+// for the rest of the run.
+func (s *mysqlSink) BatchRows(table *tableSpec, rowBytes int) int {
+	budget := s.packetBudget()
+	if budget <= 0 {
+		return 0
+	}
+	n := min(budget/int64(max(rowBytes, 1)), maxBatchRows, int64(maxPlaceholders/max(len(table.writeColumns()), 1)))
+	return int(max(n, 1))
+}
+
+// packetBudget reads max_allowed_packet on first use; 0 means it is unknown.
+func (s *mysqlSink) packetBudget() int64 {
+	s.packet.once.Do(func() {
+		var maxPacket int64
+		if err := queryRowStatement(context.Background(), s.db, "SELECT @@max_allowed_packet", nil, &maxPacket); err != nil {
+			return
+		}
+		s.packet.budget.Store(maxPacket / 2)
+	})
+	return s.packet.budget.Load()
+}
+
+// isPacketTooLarge reports whether the batch exceeded the server's or the driver's packet limit.
+func isPacketTooLarge(err error) bool {
+	const mysqlErrNetPacketTooLarge = 1153
+	return errors.Is(err, mysql.ErrPktTooLarge) || isMySQLError(err, mysqlErrNetPacketTooLarge)
+}
+
+// execSplitting runs write on the rows and, when they do not fit in one packet, on each half in
+// turn. A single row that does not fit fails with a hint to raise max_allowed_packet.
+func (s *mysqlSink) execSplitting(table *tableSpec, rows [][]any, write func([][]any) error) error {
+	err := write(rows)
+	if err == nil || !isPacketTooLarge(err) {
+		return err
+	}
+	if len(rows) == 1 {
+		return fmt.Errorf("%w (a single %s row exceeds max_allowed_packet; raise it on the server, and maxAllowedPacket in the DSN if set)", err, table.name)
+	}
+	if budget := s.packet.budget.Load(); budget > 1 {
+		s.packet.budget.CompareAndSwap(budget, budget/2)
+	}
+	half := len(rows) / 2
+	if err := s.execSplitting(table, rows[:half], write); err != nil {
+		return err
+	}
+	return s.execSplitting(table, rows[half:], write)
+}
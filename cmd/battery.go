@@ -0,0 +1,320 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	batterySQLitePaths []string
+	batteryMySQLDSN    string
+)
+
+// batteryCmd exports battery levels reported by phones, sensors, and other devices.
+var batteryCmd = &cobra.Command{
+	Use:   "battery",
+	Short: "Export Home Assistant battery levels into MySQL",
+	Long:  "Reads every state exposing a battery_level attribute (or a battery device_class sensor) and upserts a per-device time series plus daily minimum rollups into MySQL.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(batterySQLitePaths) == 0 {
+			return errors.New("sqlite database path is required")
+		}
+		if batteryMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		return forEachRecorder(ctx, batterySQLitePaths, func(sqlitePath string) error {
+			return transferBatteryData(ctx, sqlitePath, batteryMySQLDSN)
+		})
+	},
+}
+
+func init() {
+	batteryCmd.Flags().StringArrayVar(&batterySQLitePaths, "sqlite", nil, recorderFlagUsage)
+	batteryCmd.Flags().StringVar(&batteryMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	addOwnerFlags(batteryCmd)
+	_ = batteryCmd.MarkFlagRequired("sqlite")
+	_ = batteryCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(batteryCmd)
+}
+
+func transferBatteryData(ctx context.Context, sqlitePath, mysqlDSN string) error {
+	owners, err := newOwnerFilter(sqlitePath)
+	if err != nil {
+		return err
+	}
+
+	sqliteDB, err := openRecorder(ctx, sqlitePath)
+	if err != nil {
+		return err
+	}
+	defer sqliteDB.Close()
+
+	sink, err := openExportSink(ctx, mysqlDSN)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	for _, table := range []*tableSpec{batteryPointsTable, batteryDailyTable} {
+		if err := sink.EnsureSchema(ctx, table); err != nil {
+			return fmt.Errorf("ensure %s table: %w", table.name, err)
+		}
+	}
+
+	entityWatermarks, err := loadWatermarks(ctx, sink, batteryPointsTable)
+	if err != nil {
+		return fmt.Errorf("load battery checkpoints: %w", err)
+	}
+	watermarkTies, err := loadWatermarkTies(ctx, sink, batteryPointsTable)
+	if err != nil {
+		return fmt.Errorf("load battery checkpoints: %w", err)
+	}
+
+	incremental, args, err := incrementalPredicate("sm.entity_id", "s.last_updated_ts", entityWatermarks)
+	if err != nil {
+		return fmt.Errorf("load battery checkpoints: %w", err)
+	}
+	query := `
+SELECT
+    s.state_id,
+    sm.entity_id,
+    s.state,
+    s.last_updated_ts,
+    COALESCE(sa.shared_attrs, '')
+FROM states s
+JOIN state_attributes sa ON s.attributes_id = sa.attributes_id
+JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+WHERE (sa.shared_attrs LIKE '%"battery_level"%'
+    OR sa.shared_attrs LIKE '%"device_class":"battery"%')
+  AND ` + incremental + `
+ORDER BY s.state_id
+`
+
+	rows, err := sqliteDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("query sqlite database: %w", err)
+	}
+	defer rows.Close()
+
+	const batteryBatchSize = 500
+
+	writer := newBatchWriter(sink, batteryPointsTable, batteryBatchSize)
+
+	var earliest time.Time
+	for rows.Next() {
+		var (
+			stateID        int64
+			entityID       string
+			state          string
+			lastUpdatedVal sql.NullFloat64
+			attributesJSON string
+		)
+
+		if err := rows.Scan(&stateID, &entityID, &state, &lastUpdatedVal, &attributesJSON); err != nil {
+			return fmt.Errorf("scan sqlite row: %w", err)
+		}
+		if !owners.Allows(entityID) {
+			filteredRows.add(batteryPointsTable.name, 1)
+			continue
+		}
+
+		lastUpdated, err := floatToNullTime(lastUpdatedVal)
+		if err != nil {
+			if err := writer.Reject(ctx, rejectedRow{stateID, entityID, state, lastUpdatedVal, attributesJSON}, fmt.Errorf("convert last_updated_ts for state_id %d: %w", stateID, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		if lastUpdated.Valid && exportedBefore(entityWatermarks, watermarkTies, entityID, lastUpdated.Time, stateID) {
+			exportedRows.add(batteryPointsTable.name, 1)
+			continue
+		}
+
+		level, err := extractBatteryLevel(state, attributesJSON)
+		if err != nil {
+			if err := writer.Reject(ctx, rejectedRow{stateID, entityID, state, lastUpdatedVal, attributesJSON}, fmt.Errorf("parse attributes for state_id %d: %w", stateID, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		observeAlerts(ctx, entityID, state, level, lastUpdated)
+		if !level.Valid {
+			filteredRows.add(batteryPointsTable.name, 1)
+			continue
+		}
+
+		if lastUpdated.Valid && (earliest.IsZero() || lastUpdated.Time.Before(earliest)) {
+			earliest = lastUpdated.Time
+		}
+
+		if err := writer.Add(ctx, stateID, entityID, level, lastUpdated); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate sqlite rows: %w", err)
+	}
+
+	if err := writer.Flush(ctx); err != nil {
+		return err
+	}
+	// battery_daily is rolled up from the finished table, swapped in under --write-mode
+	// staging-swap.
+	if err := finalizeTable(ctx, sink, batteryPointsTable); err != nil {
+		return err
+	}
+
+	if !earliest.IsZero() {
+		if err := refreshBatteryDaily(ctx, sink, earliest); err != nil {
+			return fmt.Errorf("refresh battery_daily: %w", err)
+		}
+	}
+	return nil
+}
+
+var batteryPointsTable = &tableSpec{
+	name: "battery_points",
+	columns: []columnSpec{
+		{name: "state_id", sqlType: "BIGINT NOT NULL"},
+		{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "battery_level", sqlType: "DOUBLE NOT NULL"},
+		{name: "last_updated", sqlType: "DATETIME(6) NULL"},
+	},
+	primaryKey:    []string{"state_id"},
+	entityColumn:  "entity_id",
+	timeColumn:    "last_updated",
+	idColumn:      "state_id",
+	indexDefaults: []string{"entity-time"},
+	history:       true,
+}
+
+var batteryDailyTable = &tableSpec{
+	name: "battery_daily",
+	columns: []columnSpec{
+		{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "day", sqlType: "DATE NOT NULL"},
+		{name: "min_level", sqlType: "DOUBLE NOT NULL"},
+		{name: "samples", sqlType: "INT NOT NULL"},
+	},
+	primaryKey: []string{"entity_id", "day"},
+}
+
+// extractBatteryLevel returns the battery_level attribute, falling back to the state of
+// battery device_class sensors (e.g. sensor.phone_battery_level). It scans for the two keys
+// instead of decoding the whole document; see attributeScanner.
+func extractBatteryLevel(state, raw string) (sql.NullFloat64, error) {
+	if strings.TrimSpace(raw) == "" {
+		return sql.NullFloat64{}, nil
+	}
+
+	var (
+		level     sql.NullFloat64
+		isBattery bool
+	)
+	sc := attributeScanner{raw: raw}
+	for sc.next() {
+		// A repeated key replaces the earlier value, as in a full decode.
+		switch sc.key {
+		case "battery_level":
+			v, ok := sc.floatValue()
+			level = sql.NullFloat64{Float64: v, Valid: ok}
+		case "device_class":
+			class, ok := sc.stringValue()
+			isBattery = ok && class == "battery"
+		}
+	}
+	if sc.err != nil {
+		return sql.NullFloat64{}, fmt.Errorf("unmarshal shared_attrs: %w", sc.err)
+	}
+
+	if level.Valid {
+		return level, nil
+	}
+	if isBattery {
+		return parseNumericState(state), nil
+	}
+	return sql.NullFloat64{}, nil
+}
+
+// refreshBatteryDaily recomputes the daily minimum for every export-zone day touched since the
+// given time, starting at that day's midnight so its first day is complete. The rows are read back
+// from the destination and grouped here: DATE() would group the stored times by the driver's zone
+// instead. Sinks without SQL access leave battery_daily alone.
+func refreshBatteryDaily(ctx context.Context, sink Sink, since time.Time) error {
+	sq, ok := sink.(sqlSink)
+	if !ok {
+		return nil
+	}
+	const query = `
+SELECT entity_id, last_updated, battery_level
+FROM battery_points
+WHERE last_updated >= ?
+ORDER BY entity_id, last_updated
+`
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := sq.DB().QueryContext(qctx, query, rollupStart(since, 0))
+	if err != nil {
+		return explainTimeout(qctx, err)
+	}
+	defer rows.Close()
+
+	const batteryDailyBatchSize = 500
+
+	writer := newBatchWriter(sink, batteryDailyTable, batteryDailyBatchSize)
+	var (
+		entityID, day string
+		minLevel      float64
+		samples       int64
+	)
+	flush := func() error {
+		if samples == 0 {
+			return nil
+		}
+		return writer.Add(ctx, entityID, day, minLevel, samples)
+	}
+	for rows.Next() {
+		var (
+			rowEntityID string
+			lastUpdated sql.NullTime
+			level       float64
+		)
+		if err := rows.Scan(&rowEntityID, &lastUpdated, &level); err != nil {
+			return err
+		}
+		if !lastUpdated.Valid {
+			continue
+		}
+		rowDay := exportDay(lastUpdated.Time)
+		if rowEntityID != entityID || rowDay != day {
+			if err := flush(); err != nil {
+				return err
+			}
+			entityID, day, minLevel, samples = rowEntityID, rowDay, level, 0
+		}
+		minLevel = math.Min(minLevel, level)
+		samples++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return writer.Flush(ctx)
+}
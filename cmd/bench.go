@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	benchSQLitePath string
+	benchMySQLDSN   string
+	benchRows       int
+	benchBatchSizes []int
+	benchParallel   []int
+	benchKeep       bool
+)
+
+// benchCmd measures where export time goes for a recorder/destination pair.
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Measure export throughput against a destination",
+	Long:  "Reads recent recorder states, times the read and transform phases, then writes them through the selected sink into a scratch ha_tools_bench table with every combination of batch size and writer count, reporting rows/sec and the fastest settings.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if benchSQLitePath == "" {
+			return errors.New("sqlite database path is required")
+		}
+		if benchMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+		if benchRows <= 0 {
+			return errors.New("--rows must be positive")
+		}
+		for _, n := range append(append([]int{}, benchBatchSizes...), benchParallel...) {
+			if n <= 0 {
+				return errors.New("--batch-sizes and --parallel must be positive")
+			}
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		return runBench(ctx, cmd.OutOrStdout(), benchSQLitePath, benchMySQLDSN)
+	},
+}
+
+func init() {
+	benchCmd.Flags().StringVar(&benchSQLitePath, "sqlite", "", "Path to the Home Assistant SQLite recorder database")
+	benchCmd.Flags().StringVar(&benchMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	benchCmd.Flags().IntVar(&benchRows, "rows", 100000, "Number of most recent recorder states to benchmark with")
+	benchCmd.Flags().IntSliceVar(&benchBatchSizes, "batch-sizes", []int{100, 500, 1000, 2000}, "Batch sizes to try")
+	benchCmd.Flags().IntSliceVar(&benchParallel, "parallel", []int{1, 2, 4}, "Concurrent writer counts to try")
+	benchCmd.Flags().BoolVar(&benchKeep, "keep", false, "Keep the ha_tools_bench table instead of dropping it")
+	_ = benchCmd.MarkFlagRequired("sqlite")
+	_ = benchCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(benchCmd)
+}
+
+// benchTable is the scratch destination; it mirrors the wide numeric layout.
+var benchTable = &tableSpec{
+	name: "ha_tools_bench",
+	columns: []columnSpec{
+		{name: "state_id", sqlType: "BIGINT NOT NULL"},
+		{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "state", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "numeric_state", sqlType: "DOUBLE NULL"},
+		{name: "unit", sqlType: "VARCHAR(64) NULL"},
+		{name: "device_class", sqlType: "VARCHAR(64) NULL"},
+		{name: "friendly_name", sqlType: "VARCHAR(255) NULL"},
+		{name: "last_updated", sqlType: "DATETIME NULL"},
+	},
+	primaryKey: []string{"state_id"},
+}
+
+type benchRecorderRow struct {
+	stateID        int64
+	entityID       string
+	state          string
+	lastUpdatedVal sql.NullFloat64
+	attributesJSON string
+}
+
+// benchWriteResult is one batch size / writer count combination.
+type benchWriteResult struct {
+	batchSize int
+	parallel  int
+	elapsed   time.Duration
+	batches   int
+}
+
+func (r benchWriteResult) rowsPerSecond(rows int) float64 {
+	return float64(rows) / r.elapsed.Seconds()
+}
+
+func runBench(ctx context.Context, out io.Writer, sqlitePath, mysqlDSN string) error {
+	sqliteDB, err := openRecorder(ctx, sqlitePath)
+	if err != nil {
+		return err
+	}
+	defer sqliteDB.Close()
+
+	started := time.Now()
+	recorderRows, err := benchRead(ctx, sqliteDB, benchRows)
+	if err != nil {
+		return err
+	}
+	readElapsed := time.Since(started)
+	if len(recorderRows) == 0 {
+		return errors.New("the recorder has no states to benchmark with")
+	}
+
+	var memBefore, memAfter runtime.MemStats
+	runtime.ReadMemStats(&memBefore)
+	started = time.Now()
+	values, err := benchTransform(recorderRows)
+	if err != nil {
+		return err
+	}
+	transformElapsed := time.Since(started)
+	runtime.ReadMemStats(&memAfter)
+	transformAllocs := float64(memAfter.Mallocs-memBefore.Mallocs) / float64(len(recorderRows))
+
+	sink, err := openSink(ctx, sinkName, mysqlDSN)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	if err := sink.EnsureSchema(ctx, benchTable); err != nil {
+		return fmt.Errorf("ensure %s table: %w", benchTable.name, err)
+	}
+	if !benchKeep {
+		defer dropBenchTable(sink)
+	}
+
+	var results []benchWriteResult
+	for _, parallel := range benchParallel {
+		for _, batchSize := range benchBatchSizes {
+			if err := resetBenchTable(ctx, sink); err != nil {
+				return err
+			}
+			result, err := benchWrite(ctx, sink, values, batchSize, parallel)
+			if err != nil {
+				return fmt.Errorf("write with batch size %d and %d writer(s): %w", batchSize, parallel, err)
+			}
+			results = append(results, result)
+		}
+	}
+
+	printBenchReport(out, len(values), readElapsed, transformElapsed, transformAllocs, results)
+	return nil
+}
+
+// benchRead loads the newest limit recorder states with their attributes.
+func benchRead(ctx context.Context, db *sql.DB, limit int) ([]benchRecorderRow, error) {
+	const query = `
+SELECT
+    s.state_id,
+    sm.entity_id,
+    s.state,
+    s.last_updated_ts,
+    COALESCE(sa.shared_attrs, '')
+FROM states s
+JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+LEFT JOIN state_attributes sa ON s.attributes_id = sa.attributes_id
+ORDER BY s.state_id DESC
+LIMIT ?
+`
+	rows, err := db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query sqlite database: %w", err)
+	}
+	defer rows.Close()
+
+	recorderRows := make([]benchRecorderRow, 0, limit)
+	for rows.Next() {
+		var r benchRecorderRow
+		var state sql.NullString
+		if err := rows.Scan(&r.stateID, &r.entityID, &state, &r.lastUpdatedVal, &r.attributesJSON); err != nil {
+			return nil, fmt.Errorf("scan sqlite row: %w", err)
+		}
+		r.state = state.String
+		recorderRows = append(recorderRows, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate sqlite rows: %w", err)
+	}
+	return recorderRows, nil
+}
+
+// benchTransform does the per-row work the numeric exporters do: attribute parsing, numeric
+// parsing, and timestamp conversion.
+func benchTransform(recorderRows []benchRecorderRow) ([][]any, error) {
+	values := make([][]any, 0, len(recorderRows))
+	for _, r := range recorderRows {
+		meta, err := extractStateMetadata(r.attributesJSON)
+		if err != nil {
+			return nil, fmt.Errorf("parse attributes for state_id %d: %w", r.stateID, err)
+		}
+		lastUpdated, err := floatToNullTime(r.lastUpdatedVal)
+		if err != nil {
+			return nil, fmt.Errorf("convert last_updated_ts for state_id %d: %w", r.stateID, err)
+		}
+		values = append(values, []any{
+			r.stateID,
+			r.entityID,
+			r.state,
+			parseNumericState(r.state),
+			meta.Unit,
+			meta.DeviceClass,
+			meta.FriendlyName,
+			lastUpdated,
+		})
+	}
+	return values, nil
+}
+
+// benchWrite writes every row once, split evenly across parallel writers sharing the sink.
+func benchWrite(ctx context.Context, sink Sink, values [][]any, batchSize, parallel int) (benchWriteResult, error) {
+	result := benchWriteResult{batchSize: batchSize, parallel: parallel}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	started := time.Now()
+	chunk := (len(values) + parallel - 1) / parallel
+	for start := 0; start < len(values); start += chunk {
+		end := start + chunk
+		if end > len(values) {
+			end = len(values)
+		}
+		result.batches += (end - start + batchSize - 1) / batchSize
+
+		wg.Add(1)
+		go func(part [][]any) {
+			defer wg.Done()
+			writer := newBatchWriter(sink, benchTable, batchSize)
+			// The batch size is what is being measured, so keep it.
+			writer.fixedSize = true
+			err := func() error {
+				for _, row := range part {
+					if err := writer.Add(ctx, row...); err != nil {
+						return err
+					}
+				}
+				return writer.Flush(ctx)
+			}()
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(values[start:end])
+	}
+	wg.Wait()
+	result.elapsed = time.Since(started)
+	return result, firstErr
+}
+
+// resetBenchTable empties the scratch table so every run inserts rather than updates.
+func resetBenchTable(ctx context.Context, sink Sink) error {
+	sq, ok := sink.(sqlSink)
+	if !ok {
+		return nil
+	}
+	if _, err := execStatement(ctx, sq.DB(), "DELETE FROM "+benchTable.name); err != nil {
+		return fmt.Errorf("reset %s: %w", benchTable.name, err)
+	}
+	return nil
+}
+
+func dropBenchTable(sink Sink) {
+	if sq, ok := sink.(sqlSink); ok {
+		_, _ = sq.DB().Exec("DROP TABLE IF EXISTS " + benchTable.name)
+	}
+}
+
+func printBenchReport(w io.Writer, rows int, readElapsed, transformElapsed time.Duration, transformAllocs float64, results []benchWriteResult) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "phase\tbatch size\twriters\telapsed\trows/sec\tms/batch\n")
+	fmt.Fprintf(tw, "read\t-\t-\t%s\t%.0f\t-\n", readElapsed.Round(time.Millisecond), float64(rows)/readElapsed.Seconds())
+	fmt.Fprintf(tw, "transform\t-\t-\t%s\t%.0f\t-\n", transformElapsed.Round(time.Millisecond), float64(rows)/transformElapsed.Seconds())
+
+	var best benchWriteResult
+	for _, r := range results {
+		perBatch := float64(r.elapsed.Milliseconds()) * float64(r.parallel) / float64(r.batches)
+		fmt.Fprintf(tw, "write\t%d\t%d\t%s\t%.0f\t%.1f\n", r.batchSize, r.parallel, r.elapsed.Round(time.Millisecond), r.rowsPerSecond(rows), perBatch)
+		if best.elapsed == 0 || r.elapsed < best.elapsed {
+			best = r
+		}
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\n%d rows, %.1f allocations per row in transform. Fastest write: batch size %d with %d writer(s) at %.0f rows/sec.\n", rows, transformAllocs, best.batchSize, best.parallel, best.rowsPerSecond(rows))
+	switch {
+	case readElapsed > best.elapsed && readElapsed > transformElapsed:
+		fmt.Fprintln(w, "Reading the recorder is the bottleneck; a copied recorder opened with --sqlite-options='mode=ro&immutable=1' avoids lock waits.")
+	case transformElapsed > best.elapsed:
+		fmt.Fprintln(w, "Attribute parsing is the bottleneck; the destination has headroom.")
+	default:
+		fmt.Fprintln(w, "Writing is the bottleneck; prefer the fastest settings above.")
+	}
+}
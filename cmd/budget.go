@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// rowBudget is the --row-budget flag: the most rows an export writes to one table without asking
+// first, so an accidental full re-export doesn't use up a metered destination's quota.
+var rowBudget int64
+
+func init() {
+	rootCmd.PersistentFlags().Int64Var(&rowBudget, "row-budget", 0, "Ask for confirmation before an export writes more than this many rows to a table, e.g. to stay within a TiDB Serverless request unit quota (0 = never ask)")
+}
+
+func validateRowBudget() error {
+	if rowBudget < 0 {
+		return fmt.Errorf("--row-budget must not be negative, got %d", rowBudget)
+	}
+	return nil
+}
+
+// countNewRecorderRows counts the recorder rows an export is about to read past its watermarks:
+// the rows of from matching where whose timeExpr is newer than the watermark of their entityExpr.
+// Rows the export later skips (non-numeric states, merged buckets) are counted too, so this is an
+// upper bound.
+func countNewRecorderRows(ctx context.Context, sqliteDB *sql.DB, from, where string, args []any, entityExpr, timeExpr string, watermarks map[string]time.Time) (int64, error) {
+	seconds := make(map[string]float64, len(watermarks))
+	for entityID, at := range watermarks {
+		seconds[entityID] = float64(at.UnixMicro()) / 1e6
+	}
+	encoded, err := json.Marshal(seconds)
+	if err != nil {
+		return 0, err
+	}
+	if where == "" {
+		where = "1 = 1"
+	}
+	query := "SELECT COUNT(*) FROM " + from + `
+LEFT JOIN json_each(?) w ON w.key = ` + entityExpr + `
+WHERE (` + where + `) AND (w.value IS NULL OR ` + timeExpr + ` > w.value)`
+
+	var n int64
+	if err := sqliteDB.QueryRowContext(ctx, query, append([]any{string(encoded)}, args...)...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count recorder rows to export: %w", err)
+	}
+	return n, nil
+}
+
+// writeEstimate approximates what writing rows to table costs.
+type writeEstimate struct {
+	rows    int64
+	batches int64
+	// requestUnits follows TiDB Serverless pricing of a request unit per write request and per KiB
+	// written, counting each secondary index entry as another row.
+	requestUnits int64
+}
+
+// estimatedRowBytes is the size assumed per column of a written row.
+const estimatedRowBytes = 32
+
+func estimateWrite(table *tableSpec, rows int64, batchSize int) writeEstimate {
+	est := writeEstimate{rows: rows, batches: (rows + int64(batchSize) - 1) / int64(batchSize)}
+	entries := rows * int64(1+len(table.uniqueKeys)+len(table.indexes))
+	kib := (entries*int64(len(table.columns))*estimatedRowBytes + 1023) / 1024
+	est.requestUnits = est.batches + kib
+	return est
+}
+
+func (e writeEstimate) String() string {
+	s := fmt.Sprintf("about %d rows in %d batches", e.rows, e.batches)
+	if destDialect.name == "tidb" {
+		s += fmt.Sprintf(" (roughly %d request units)", e.requestUnits)
+	}
+	return s
+}
+
+// checkRowBudget asks for confirmation when writing rows to table exceeds --row-budget.
+func checkRowBudget(table *tableSpec, rows int64, batchSize int) error {
+	if rowBudget == 0 || rows <= rowBudget || explainMode {
+		return nil
+	}
+	est := estimateWrite(table, rows, batchSize)
+	return confirm(fmt.Sprintf("%s: %s exceeds --row-budget %d", table.name, est, rowBudget))
+}
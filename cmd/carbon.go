@@ -0,0 +1,376 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Supported --provider values of the carbon command.
+const (
+	// carbonElectricityMaps is Electricity Maps' hourly carbon intensity of a zone such as DE.
+	carbonElectricityMaps = "electricitymaps"
+	// carbonNationalGrid is the GB National Grid ESO carbon intensity API, half-hourly.
+	carbonNationalGrid = "nationalgrid"
+)
+
+var (
+	carbonMySQLDSN string
+	carbonProvider string
+	carbonZone     string
+	carbonToken    string
+	carbonURL      string
+	carbonDays     int
+)
+
+// carbonCmd fetches the grid's carbon intensity into carbon_intensity.
+var carbonCmd = &cobra.Command{
+	Use:   "carbon",
+	Short: "Fetch the grid's carbon intensity into MySQL",
+	Long:  "Fetches the carbon intensity of grid electricity (gCO2eq/kWh) from Electricity Maps or the GB National Grid carbon intensity API and upserts it into a carbon_intensity table, one row per hour or half hour. Each run fetches from the day of the newest stored interval of the zone up to now. energy --carbon-zone tags the exported meter readings with it and totals the emissions per day.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if carbonMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+		if carbonDays <= 0 {
+			return errors.New("--days must be positive")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		provider, err := newCarbonProvider(ctx, carbonProvider, carbonZone, carbonToken, carbonURL)
+		if err != nil {
+			return err
+		}
+		return transferCarbonIntensity(ctx, carbonMySQLDSN, provider)
+	},
+}
+
+func init() {
+	carbonCmd.Flags().StringVar(&carbonMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	carbonCmd.Flags().StringVar(&carbonProvider, "provider", carbonNationalGrid, "Carbon intensity source: electricitymaps or nationalgrid")
+	carbonCmd.Flags().StringVar(&carbonZone, "zone", "", "Zone: an Electricity Maps zone such as DE or US-CAL-CISO, or for nationalgrid GB (default) or a region id from 1 to 17")
+	carbonCmd.Flags().StringVar(&carbonToken, "token", "", "Electricity Maps API token, or a secret reference such as env:ELECTRICITYMAPS_TOKEN (aws-sm: references read AWS credentials only from $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY, and $AWS_SESSION_TOKEN)")
+	carbonCmd.Flags().StringVar(&carbonURL, "url", "", "Provider API endpoint, for a proxy or mirror (default: the provider's public API)")
+	carbonCmd.Flags().IntVar(&carbonDays, "days", 7, "Days of history to fetch when the zone has no stored intensities yet")
+	_ = carbonCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(carbonCmd)
+}
+
+// carbonIntensityTable holds one row per interval in local time. estimated marks a forecast or
+// estimate the provider may still replace with a measured value; the next run updates it.
+var carbonIntensityTable = &tableSpec{
+	name: "carbon_intensity",
+	columns: []columnSpec{
+		{name: "provider", sqlType: "VARCHAR(32) NOT NULL"},
+		{name: "zone", sqlType: "VARCHAR(64) NOT NULL"},
+		{name: "start_time", sqlType: "DATETIME NOT NULL"},
+		{name: "end_time", sqlType: "DATETIME NOT NULL"},
+		{name: "intensity", sqlType: "DOUBLE NOT NULL"},
+		{name: "estimated", sqlType: "BOOLEAN NOT NULL"},
+	},
+	primaryKey:   []string{"provider", "zone", "start_time"},
+	indexes:      []indexSpec{{name: "idx_carbon_intensity_zone_time", columns: []string{"zone", "start_time"}}},
+	entityColumn: "zone",
+	timeColumn:   "start_time",
+}
+
+// carbonPoint is one interval's carbon intensity in gCO2eq/kWh.
+type carbonPoint struct {
+	start, end time.Time
+	intensity  float64
+	estimated  bool
+}
+
+// intensityProvider fetches the carbon intensity of one zone.
+type intensityProvider struct {
+	name     string
+	zone     string
+	endpoint string
+	header   http.Header
+	fetch    func(ctx context.Context, p *intensityProvider, from, to time.Time) ([]carbonPoint, error)
+	client   *http.Client
+}
+
+// carbonFetchSpan is the longest period requested at once; both APIs cap their ranges.
+const carbonFetchSpan = 7 * 24 * time.Hour
+
+func newCarbonProvider(ctx context.Context, name, zone, token, endpoint string) (*intensityProvider, error) {
+	p := &intensityProvider{name: name, zone: zone, endpoint: endpoint, client: &http.Client{Timeout: 30 * time.Second}}
+	switch name {
+	case carbonElectricityMaps:
+		if p.zone == "" {
+			return nil, errors.New("electricitymaps needs --zone, such as DE or US-CAL-CISO")
+		}
+		if token == "" {
+			return nil, errors.New("electricitymaps needs --token")
+		}
+		resolved, err := resolveSecret(ctx, token)
+		if err != nil {
+			return nil, fmt.Errorf("resolve --token: %w", err)
+		}
+		p.header = http.Header{"Auth-Token": {resolved}}
+		if p.endpoint == "" {
+			p.endpoint = "https://api.electricitymap.org/v3/carbon-intensity/past-range"
+		}
+		p.fetch = fetchElectricityMapsIntensity
+	case carbonNationalGrid:
+		p.zone = strings.ToUpper(p.zone)
+		if p.zone == "" {
+			p.zone = "GB"
+		}
+		if p.zone != "GB" {
+			if region, err := strconv.Atoi(p.zone); err != nil || region < 1 || region > 17 {
+				return nil, fmt.Errorf("nationalgrid serves --zone GB or a region id from 1 to 17, not %q", zone)
+			}
+		}
+		if p.endpoint == "" {
+			p.endpoint = "https://api.carbonintensity.org.uk"
+		}
+		p.fetch = fetchNationalGridIntensity
+	default:
+		return nil, fmt.Errorf("unknown --provider %q (supported: %s, %s)", name, carbonElectricityMaps, carbonNationalGrid)
+	}
+	return p, nil
+}
+
+func transferCarbonIntensity(ctx context.Context, mysqlDSN string, provider *intensityProvider) error {
+	sink, err := openExportSink(ctx, mysqlDSN)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	if err := sink.EnsureSchema(ctx, carbonIntensityTable); err != nil {
+		return fmt.Errorf("ensure carbon_intensity table: %w", err)
+	}
+	watermarks, err := loadWatermarks(ctx, sink, carbonIntensityTable)
+	if err != nil {
+		return fmt.Errorf("load carbon_intensity checkpoints: %w", err)
+	}
+
+	// The newest stored day is fetched again, so its estimates are replaced by measured values.
+	now := wallClock.Now()
+	from := dayStart(now).AddDate(0, 0, -carbonDays)
+	if newest, ok := watermarks[provider.zone]; ok && newest.After(from) {
+		from = dayStart(newest)
+	}
+
+	const carbonBatchSize = 500
+	writer := newBatchWriter(sink, carbonIntensityTable, carbonBatchSize)
+	for start := from; start.Before(now); start = start.Add(carbonFetchSpan) {
+		end := start.Add(carbonFetchSpan)
+		if end.After(now) {
+			end = now
+		}
+		points, err := provider.fetch(ctx, provider, start, end)
+		if err != nil {
+			return err
+		}
+		for _, pt := range points {
+			if err := writer.Add(ctx, provider.name, provider.zone, inExportZone(pt.start), inExportZone(pt.end), pt.intensity, pt.estimated); err != nil {
+				return err
+			}
+		}
+	}
+	if err := writer.Flush(ctx); err != nil {
+		return err
+	}
+	return finalizeTable(ctx, sink, carbonIntensityTable)
+}
+
+// fetchElectricityMapsIntensity reads a zone's hourly history for [from, to). Hours without a
+// value are skipped.
+func fetchElectricityMapsIntensity(ctx context.Context, p *intensityProvider, from, to time.Time) ([]carbonPoint, error) {
+	query := url.Values{}
+	query.Set("zone", p.zone)
+	query.Set("start", from.UTC().Format(time.RFC3339))
+	query.Set("end", to.UTC().Format(time.RFC3339))
+	var decoded struct {
+		Data []struct {
+			CarbonIntensity *float64  `json:"carbonIntensity"`
+			Datetime        time.Time `json:"datetime"`
+			IsEstimated     bool      `json:"isEstimated"`
+		} `json:"data"`
+	}
+	if _, err := fetchJSON(ctx, p.client, p.name, p.endpoint+"?"+query.Encode(), p.header, &decoded); err != nil {
+		return nil, err
+	}
+	points := make([]carbonPoint, 0, len(decoded.Data))
+	for _, d := range decoded.Data {
+		if d.CarbonIntensity == nil {
+			continue
+		}
+		points = append(points, carbonPoint{start: d.Datetime, end: d.Datetime.Add(time.Hour), intensity: *d.CarbonIntensity, estimated: d.IsEstimated})
+	}
+	return points, nil
+}
+
+// nationalGridTime is the minute-precision ISO 8601 the carbon intensity API uses, e.g.
+// 2024-01-20T12:00Z.
+const nationalGridTime = "2006-01-02T15:04Z07:00"
+
+// nationalGridInterval is one half hour of the national or regional intensity. actual is only
+// reported nationally, and lags the forecast.
+type nationalGridInterval struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Intensity struct {
+		Forecast *float64 `json:"forecast"`
+		Actual   *float64 `json:"actual"`
+	} `json:"intensity"`
+}
+
+// fetchNationalGridIntensity reads the national or a regional intensity for [from, to), using
+// the actual value where there is one and the forecast otherwise.
+func fetchNationalGridIntensity(ctx context.Context, p *intensityProvider, from, to time.Time) ([]carbonPoint, error) {
+	base := strings.TrimSuffix(p.endpoint, "/")
+	span := url.PathEscape(from.UTC().Format(nationalGridTime)) + "/" + url.PathEscape(to.UTC().Format(nationalGridTime))
+
+	var intervals []nationalGridInterval
+	if p.zone == "GB" {
+		var decoded struct {
+			Data []nationalGridInterval `json:"data"`
+		}
+		if _, err := fetchJSON(ctx, p.client, p.name, base+"/intensity/"+span, nil, &decoded); err != nil {
+			return nil, err
+		}
+		intervals = decoded.Data
+	} else {
+		var decoded struct {
+			Data struct {
+				Data []nationalGridInterval `json:"data"`
+			} `json:"data"`
+		}
+		if _, err := fetchJSON(ctx, p.client, p.name, base+"/regional/intensity/"+span+"/regionid/"+p.zone, nil, &decoded); err != nil {
+			return nil, err
+		}
+		intervals = decoded.Data.Data
+	}
+
+	points := make([]carbonPoint, 0, len(intervals))
+	for _, in := range intervals {
+		start, err := time.Parse(nationalGridTime, in.From)
+		if err != nil {
+			return nil, fmt.Errorf("nationalgrid: parse interval start %q: %w", in.From, err)
+		}
+		end, err := time.Parse(nationalGridTime, in.To)
+		if err != nil {
+			return nil, fmt.Errorf("nationalgrid: parse interval end %q: %w", in.To, err)
+		}
+		pt := carbonPoint{start: start, end: end}
+		switch {
+		case in.Intensity.Actual != nil:
+			pt.intensity = *in.Intensity.Actual
+		case in.Intensity.Forecast != nil:
+			pt.intensity, pt.estimated = *in.Intensity.Forecast, true
+		default:
+			continue
+		}
+		points = append(points, pt)
+	}
+	return points, nil
+}
+
+// emissionsDailyTable totals a numeric family's energy meters per entity and day with the
+// emissions of the grid electricity it took. Each increase of a meter reading is tagged with the
+// carbon intensity of the interval it ended in; covered_kwh is the part of energy_kwh that found
+// one, and intensity the consumption-weighted mean over it.
+func emissionsDailyTable(f numericFamily) *tableSpec {
+	return &tableSpec{
+		name: f.name + "_emissions_daily",
+		columns: []columnSpec{
+			{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+			{name: "day", sqlType: "DATE NOT NULL"},
+			{name: "zone", sqlType: "VARCHAR(64) NOT NULL"},
+			{name: "energy_kwh", sqlType: "DOUBLE NOT NULL"},
+			{name: "covered_kwh", sqlType: "DOUBLE NOT NULL"},
+			{name: "intensity", sqlType: "DOUBLE NULL"},
+			{name: "emissions_g", sqlType: "DOUBLE NOT NULL"},
+		},
+		primaryKey: []string{"entity_id", "day"},
+	}
+}
+
+// refreshEmissionsDaily recomputes the family's daily emissions for every export-zone day touched
+// since the given time, from the meters (kWh, Wh, or MWh) in <name>_points and carbon_intensity of
+// the zone. Increases follow the utilities meters: a drop below meterResetRatio of the previous
+// reading is a reset, a smaller one counts as nothing. The day before is read too, so the first
+// reading of a day has its predecessor.
+func refreshEmissionsDaily(ctx context.Context, sink Sink, f numericFamily, zone string, since time.Time) error {
+	query := `
+SELECT i.entity_id, i.last_updated, i.kwh, (
+    SELECT c.intensity FROM ` + carbonIntensityTable.name + ` c
+    WHERE c.zone = ? AND c.start_time < i.last_updated AND c.end_time >= i.last_updated
+    ORDER BY c.start_time DESC LIMIT 1
+) AS intensity
+FROM (
+    SELECT
+        p.entity_id,
+        p.last_updated,
+        CASE
+            WHEN LAG(p.numeric_state) OVER w IS NULL THEN NULL
+            WHEN p.numeric_state >= LAG(p.numeric_state) OVER w THEN p.numeric_state - LAG(p.numeric_state) OVER w
+            WHEN p.numeric_state < LAG(p.numeric_state) OVER w * ` + strconv.FormatFloat(meterResetRatio, 'f', -1, 64) + ` THEN p.numeric_state
+            ELSE 0
+        END * CASE p.unit WHEN 'Wh' THEN 0.001 WHEN 'MWh' THEN 1000 ELSE 1 END AS kwh
+    FROM ` + f.name + `_points p
+    WHERE p.last_updated >= ? AND p.numeric_state IS NOT NULL AND p.unit IN ('Wh', 'kWh', 'MWh')
+    WINDOW w AS (PARTITION BY p.entity_id ORDER BY p.last_updated)
+) i
+WHERE i.last_updated >= ? AND i.kwh IS NOT NULL
+`
+	type entityDay struct{ entityID, day string }
+	type emissions struct {
+		energy, covered, grams float64
+	}
+	days := make(map[entityDay]*emissions)
+	fold := func(scan func(dest ...any) error) error {
+		var (
+			key         entityDay
+			lastUpdated time.Time
+			kwh         float64
+			intensity   sql.NullFloat64
+		)
+		if err := scan(&key.entityID, &lastUpdated, &kwh, &intensity); err != nil {
+			return err
+		}
+		key.day = exportDay(lastUpdated)
+		d, ok := days[key]
+		if !ok {
+			d = &emissions{}
+			days[key] = d
+		}
+		d.energy += kwh
+		if intensity.Valid {
+			d.covered += kwh
+			d.grams += kwh * intensity.Float64
+		}
+		return nil
+	}
+	result := func() [][]any {
+		rows := make([][]any, 0, len(days))
+		for key, d := range days {
+			var intensity sql.NullFloat64
+			if d.covered > 0 {
+				intensity = sql.NullFloat64{Float64: d.grams / d.covered, Valid: true}
+			}
+			rows = append(rows, []any{key.entityID, key.day, zone, d.energy, d.covered, intensity, d.grams})
+		}
+		return rows
+	}
+	args := []any{zone, rollupStart(since, -1), rollupStart(since, 0)}
+	return refreshDailyRollup(ctx, sink, emissionsDailyTable(f), query, args, fold, result)
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// destCharset is the character set of every destination table. utf8mb4 is the only MySQL charset
+// holding all of Unicode; friendly names with emoji fail on latin1 and 3-byte utf8.
+const destCharset = "utf8mb4"
+
+// destCollation is the --collation flag value used for destination tables.
+var destCollation = "utf8mb4_unicode_ci"
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&destCollation, "collation", destCollation, "utf8mb4 collation of destination tables (e.g. utf8mb4_0900_ai_ci, utf8mb4_bin); tables in another collation are converted only when this is set explicitly")
+}
+
+var collationPattern = regexp.MustCompile(`^utf8mb4_[a-z0-9_]+$`)
+
+func validateCollation() error {
+	if !collationPattern.MatchString(destCollation) {
+		return fmt.Errorf("invalid --collation %q: use a utf8mb4 collation such as utf8mb4_unicode_ci", destCollation)
+	}
+	return nil
+}
+
+// tableOptions renders the table options appended to CREATE TABLE.
+func tableOptions() string {
+	return fmt.Sprintf("DEFAULT CHARSET=%s COLLATE=%s", destCharset, destCollation)
+}
+
+// tableCollation returns the collation of table in the current database, or "" when it does
+// not exist.
+func tableCollation(ctx context.Context, db *sql.DB, table string) (string, error) {
+	var collation sql.NullString
+	err := queryRowStatement(ctx, db, `
+SELECT TABLE_COLLATION
+FROM INFORMATION_SCHEMA.TABLES
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+`, []any{table}, &collation)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read %s collation: %w", table, err)
+	}
+	return collation.String, nil
+}
+
+// migrateTableCharset converts a table created by an older release (or by hand) in another
+// charset to utf8mb4. A utf8mb4 table in a different collation is only converted when --collation
+// was given explicitly, since the rebuild can be slow on large tables.
+func migrateTableCharset(ctx context.Context, db *sql.DB, table string) error {
+	current, err := tableCollation(ctx, db, table)
+	if err != nil || current == "" || current == destCollation {
+		return err
+	}
+	if strings.HasPrefix(current, destCharset+"_") && !rootCmd.PersistentFlags().Changed("collation") {
+		return nil
+	}
+	if !destDialect.blockingAlters {
+		return errSchemaDrift(table, "%s uses collation %s; convert it to %s outside ha-tools (the %s dialect does not migrate tables in place)", table, current, destCollation, destDialect.name)
+	}
+
+	stmt := fmt.Sprintf("ALTER TABLE %s CONVERT TO CHARACTER SET %s COLLATE %s", table, destCharset, destCollation)
+	if _, err := execStatement(ctx, db, stmt); err != nil {
+		return fmt.Errorf("convert %s from %s to %s: %w", table, current, destCollation, err)
+	}
+	return nil
+}
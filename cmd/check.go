@@ -0,0 +1,344 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkSQLitePath   string
+	checkMySQLDSN     string
+	checkHAConfig     string
+	checkSyncInterval time.Duration
+)
+
+// checkCmd validates the recorder and destination before any data moves.
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Validate the recorder and destination connection settings",
+	Long:  "Verifies connectivity, TLS, server versions, time zone settings, destination permissions (CREATE/ALTER/INSERT), and the recorder schema version, printing the effective settings without moving any data.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if checkSQLitePath == "" && checkMySQLDSN == "" {
+			return errors.New("at least one of --sqlite or --dsn is required")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		var report checkReport
+		if checkSQLitePath != "" {
+			checkRecorder(ctx, &report, checkSQLitePath)
+		}
+		if checkMySQLDSN != "" {
+			checkDestination(ctx, &report, checkMySQLDSN)
+		}
+
+		report.print(cmd.OutOrStdout())
+		if failed := report.failures(); failed > 0 {
+			return fmt.Errorf("%d check(s) failed", failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	checkCmd.Flags().StringVar(&checkSQLitePath, "sqlite", "", "Path to the Home Assistant SQLite recorder database")
+	checkCmd.Flags().StringVar(&checkMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	checkCmd.Flags().StringVar(&checkHAConfig, "ha-config", "", "Home Assistant configuration.yaml to read recorder purge_keep_days from (default: next to the recorder)")
+	checkCmd.Flags().DurationVar(&checkSyncInterval, "sync-interval", 0, "How often exports run; warns when the recorder purges history sooner")
+
+	rootCmd.AddCommand(checkCmd)
+}
+
+type checkResult struct {
+	name   string
+	ok     bool
+	warn   bool
+	detail string
+	// fix is an actionable suggestion printed under the result (doctor only).
+	fix string
+}
+
+type checkReport struct {
+	results []checkResult
+}
+
+func (r *checkReport) pass(name, detail string, args ...any) {
+	r.results = append(r.results, checkResult{name: name, ok: true, detail: fmt.Sprintf(detail, args...)})
+}
+
+// warning records a check that passed but deserves attention; it does not fail the command.
+func (r *checkReport) warning(name, detail string, args ...any) {
+	r.results = append(r.results, checkResult{name: name, ok: true, warn: true, detail: fmt.Sprintf(detail, args...)})
+}
+
+func (r *checkReport) fail(name string, err error) {
+	r.results = append(r.results, checkResult{name: name, detail: err.Error()})
+}
+
+// suggest attaches a fix to the most recent result.
+func (r *checkReport) suggest(fix string, args ...any) {
+	if len(r.results) > 0 {
+		r.results[len(r.results)-1].fix = fmt.Sprintf(fix, args...)
+	}
+}
+
+func (r *checkReport) failures() int {
+	n := 0
+	for _, res := range r.results {
+		if !res.ok {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *checkReport) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, res := range r.results {
+		status := "ok"
+		switch {
+		case !res.ok:
+			status = "FAIL"
+		case res.warn:
+			status = "WARN"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", status, res.name, res.detail)
+		if res.fix != "" {
+			fmt.Fprintf(tw, "\t\tfix: %s\n", res.fix)
+		}
+	}
+	tw.Flush()
+}
+
+// recorderTables are the recorder tables the exporters read from.
+var recorderTables = []string{"states", "states_meta", "state_attributes"}
+
+func checkRecorder(ctx context.Context, report *checkReport, sqlitePath string) {
+	db, err := openRecorder(ctx, sqlitePath)
+	if err != nil {
+		report.fail("recorder connection", err)
+		return
+	}
+	defer db.Close()
+	report.pass("recorder connection", "%s", recorderDSN(sqlitePath, sqliteOptions))
+
+	var sqliteVersion string
+	if err := db.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&sqliteVersion); err != nil {
+		report.fail("recorder sqlite version", err)
+	} else {
+		report.pass("recorder sqlite version", "%s", sqliteVersion)
+	}
+
+	var missing []string
+	for _, table := range recorderTables {
+		found, err := recorderHasTable(ctx, db, table)
+		if err != nil {
+			report.fail("recorder tables", err)
+			return
+		}
+		if !found {
+			missing = append(missing, table)
+		}
+	}
+	if len(missing) > 0 {
+		report.fail("recorder tables", fmt.Errorf("missing %s", strings.Join(missing, ", ")))
+		return
+	}
+	report.pass("recorder tables", "%s", strings.Join(recorderTables, ", "))
+
+	switch schemaVersion, err := recorderSchemaVersion(ctx, db); {
+	case err != nil:
+		report.fail("recorder schema version", err)
+	case schemaVersion == 0:
+		report.warning("recorder schema version", "unknown (no schema_changes table); read as the current schema")
+	default:
+		report.pass("recorder schema version", "%d", schemaVersion)
+	}
+
+	var minTS, maxTS sql.NullFloat64
+	if err := db.QueryRowContext(ctx, "SELECT MIN(last_updated_ts), MAX(last_updated_ts) FROM states").Scan(&minTS, &maxTS); err != nil {
+		report.fail("recorder time range", err)
+	} else {
+		oldest, _ := floatToNullTime(minTS)
+		newest, _ := floatToNullTime(maxTS)
+		report.pass("recorder time range", "%s .. %s", formatNullTime(oldest), formatNullTime(newest))
+		checkPurgeWindow(report, sqlitePath, oldest, newest)
+	}
+}
+
+// defaultPurgeKeepDays is the recorder's purge_keep_days when configuration.yaml doesn't set it.
+const defaultPurgeKeepDays = 10
+
+// checkPurgeWindow compares the recorder's retention with --sync-interval. Retention comes from
+// configuration.yaml when it can be read, otherwise from the span of states in the recorder.
+func checkPurgeWindow(report *checkReport, sqlitePath string, oldest, newest sql.NullTime) {
+	configPath := checkHAConfig
+	if configPath == "" {
+		configPath = filepath.Join(filepath.Dir(strings.TrimPrefix(sqlitePath, "file:")), "configuration.yaml")
+	}
+
+	var (
+		retention time.Duration
+		source    string
+	)
+	if days, found, err := readPurgeKeepDays(configPath); err == nil {
+		if !found {
+			days = defaultPurgeKeepDays
+		}
+		retention = time.Duration(days) * 24 * time.Hour
+		source = fmt.Sprintf("purge_keep_days %d from %s", days, configPath)
+	} else if checkHAConfig != "" {
+		report.fail("recorder retention", err)
+		return
+	} else if oldest.Valid && newest.Valid {
+		retention = newest.Time.Sub(oldest.Time)
+		source = "estimated from the recorder time range"
+	} else {
+		report.warning("recorder retention", "unknown: the recorder is empty and no configuration.yaml was found")
+		return
+	}
+
+	days := retention.Hours() / 24
+	switch {
+	case checkSyncInterval <= 0:
+		report.pass("recorder retention", "%.1f days (%s)", days, source)
+	case checkSyncInterval >= retention:
+		report.warning("recorder retention", "%.1f days (%s) is not longer than the %s sync interval: history is purged before it is exported; sync more often or raise purge_keep_days",
+			days, source, checkSyncInterval)
+	default:
+		report.pass("recorder retention", "%.1f days (%s) covers the %s sync interval", days, source, checkSyncInterval)
+	}
+}
+
+// readPurgeKeepDays finds recorder.purge_keep_days in a Home Assistant configuration.yaml. It reads
+// the plain "recorder:" block only; !include files and secrets are not followed.
+func readPurgeKeepDays(path string) (days int, found bool, err error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false, fmt.Errorf("read %s: %w", path, err)
+	}
+
+	inRecorder := false
+	for _, line := range strings.Split(string(raw), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			inRecorder = strings.HasPrefix(trimmed, "recorder:")
+			continue
+		}
+		if !inRecorder {
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok || strings.TrimSpace(key) != "purge_keep_days" {
+			continue
+		}
+		value, _, _ = strings.Cut(value, "#")
+		days, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || days < 1 {
+			return 0, false, fmt.Errorf("%s: invalid purge_keep_days %q", path, strings.TrimSpace(value))
+		}
+		return days, true, nil
+	}
+	return 0, false, nil
+}
+
+func checkDestination(ctx context.Context, report *checkReport, mysqlDSN string) {
+	db, err := openDestination(ctx, mysqlDSN)
+	if err != nil {
+		report.fail("destination connection", err)
+		return
+	}
+	defer db.Close()
+	report.pass("destination connection", "dialect %s", destDialect.name)
+
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		report.fail("destination server version", err)
+	} else {
+		report.pass("destination server version", "%s", version)
+	}
+
+	if schema, err := currentMySQLDatabase(ctx, db); err != nil {
+		report.fail("destination database", err)
+	} else {
+		report.pass("destination database", "%s", schema)
+	}
+
+	var (
+		statusName string
+		cipher     sql.NullString
+	)
+	if err := db.QueryRowContext(ctx, "SHOW SESSION STATUS LIKE 'Ssl_cipher'").Scan(&statusName, &cipher); err != nil {
+		report.fail("destination tls", err)
+	} else if cipher.String == "" {
+		report.pass("destination tls", "not encrypted")
+	} else {
+		report.pass("destination tls", "%s", cipher.String)
+	}
+
+	var globalTZ, sessionTZ, systemTZ sql.NullString
+	if err := db.QueryRowContext(ctx, "SELECT @@global.time_zone, @@session.time_zone, @@system_time_zone").Scan(&globalTZ, &sessionTZ, &systemTZ); err != nil {
+		report.fail("destination time zone", err)
+	} else {
+		now := inExportZone(wallClock.Now())
+		report.pass("destination time zone", "global=%s session=%s system=%s (exporter %s, UTC%s)",
+			globalTZ.String, sessionTZ.String, systemTZ.String, now.Location(), now.Format("-07:00"))
+	}
+
+	checkDestinationPermissions(ctx, report, db)
+}
+
+// checkDestinationPermissions exercises CREATE, ALTER, INSERT, and DROP on a scratch table. A table
+// left behind by a check that crashed is dropped first, so its columns don't fail the ALTER.
+func checkDestinationPermissions(ctx context.Context, report *checkReport, db *sql.DB) {
+	const table = "ha_tools_check"
+
+	if _, err := db.ExecContext(ctx, "DROP TABLE IF EXISTS "+table); err != nil {
+		report.fail("destination permissions", fmt.Errorf("DROP: %w", err))
+		return
+	}
+	steps := []struct {
+		privilege string
+		stmt      string
+	}{
+		{"CREATE", "CREATE TABLE " + table + " (id BIGINT PRIMARY KEY)"},
+		{"ALTER", "ALTER TABLE " + table + " ADD COLUMN checked_at DATETIME NULL"},
+		{"INSERT", "INSERT INTO " + table + " (id, checked_at) VALUES (1, NOW()) " + destDialect.upsertClause(table, []string{"id"}, []string{"checked_at"}, "")},
+		{"DROP", "DROP TABLE " + table},
+	}
+
+	var granted []string
+	for _, step := range steps {
+		if _, err := db.ExecContext(ctx, step.stmt); err != nil {
+			report.fail("destination permissions", fmt.Errorf("%s: %w", step.privilege, err))
+			_, _ = db.ExecContext(ctx, "DROP TABLE IF EXISTS "+table)
+			return
+		}
+		granted = append(granted, step.privilege)
+	}
+	report.pass("destination permissions", "%s", strings.Join(granted, ", "))
+}
+
+func formatNullTime(t sql.NullTime) string {
+	if !t.Valid {
+		return "-"
+	}
+	return t.Time.Format(time.RFC3339)
+}
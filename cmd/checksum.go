@@ -0,0 +1,394 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	checksumSQLitePath    string
+	checksumMySQLDSN      string
+	checksumTable         string
+	checksumEntity        string
+	checksumMatch         string
+	checksumMinuteAverage bool
+	checksumDays          int
+)
+
+// checksumCmd fingerprints each entity's day of rows in the recorder and in the destination and
+// reports the days that differ.
+var checksumCmd = &cobra.Command{
+	Use:   "checksum",
+	Short: "Compare per-entity, per-day checksums of the recorder and a destination table",
+	Long:  "Hashes the timestamps of every entity's rows per UTC day, once from a recorder scan (running the exporter's conversion) and once from the destination table, stores both in ha_tools_checksums, and lists the days that differ. Neither the recorder nor the exported rows are modified.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if checksumSQLitePath == "" {
+			return errors.New("sqlite path is required")
+		}
+		if checksumMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+		if checksumDays <= 0 {
+			return errors.New("--days must be positive")
+		}
+		selector, err := newEntitySelector(checksumMatch, checksumEntity)
+		if err != nil {
+			return err
+		}
+		source, ok := checksumSources(selector)[checksumTable]
+		if !ok {
+			return fmt.Errorf("unsupported --table %q (supported: %s)", checksumTable, strings.Join(checksumTableNames(), ", "))
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		since := wallClock.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-checksumDays)
+
+		sqliteDB, err := openRecorder(ctx, checksumSQLitePath)
+		if err != nil {
+			return err
+		}
+		defer sqliteDB.Close()
+		if source, err = source.resolve(ctx, sqliteDB); err != nil {
+			return err
+		}
+
+		sink, err := openSink(ctx, sinkName, checksumMySQLDSN)
+		if err != nil {
+			return err
+		}
+		defer sink.Close()
+		sq, ok := sink.(sqlSink)
+		if !ok {
+			return fmt.Errorf("the %s sink does not support checksum", sinkName)
+		}
+
+		recorderSums, err := recorderChecksums(ctx, sqliteDB, source, since)
+		if err != nil {
+			return err
+		}
+		destSums, err := destinationChecksums(ctx, sq.DB(), checksumTable, since)
+		if err != nil {
+			return err
+		}
+
+		results := compareChecksums(recorderSums, destSums)
+		if err := storeChecksums(ctx, sink, checksumTable, results); err != nil {
+			return err
+		}
+
+		mismatched := printChecksumResults(cmd.OutOrStdout(), results)
+		if mismatched > 0 {
+			return &verificationError{err: fmt.Errorf("%d entity-day(s) of %s differ from the recorder", mismatched, checksumTable)}
+		}
+		return nil
+	},
+}
+
+func init() {
+	checksumCmd.Flags().StringVar(&checksumSQLitePath, "sqlite", "", "Path to the Home Assistant SQLite recorder database")
+	checksumCmd.Flags().StringVar(&checksumMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	checksumCmd.Flags().StringVar(&checksumTable, "table", gpsPointsTable.name, "Destination table to verify: "+strings.Join(checksumTableNames(), ", "))
+	checksumCmd.Flags().StringVar(&checksumEntity, "entity", "", "Entity slug the numeric table was exported with (energy --entity, climate-sensors --entity)")
+	checksumCmd.Flags().StringVar(&checksumMatch, "match", matchPrefix, "The --match the numeric table was exported with")
+	checksumCmd.Flags().BoolVar(&checksumMinuteAverage, "minute-average", false, "climate_points was exported with --minute-average")
+	checksumCmd.Flags().IntVar(&checksumDays, "days", 7, "Number of UTC days to verify, ending today")
+	_ = checksumCmd.MarkFlagRequired("sqlite")
+	_ = checksumCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(checksumCmd)
+}
+
+// checksumSource selects the recorder rows behind a destination table. Rows are converted with the
+// table's replay conversion, so the scan skips exactly what the exporter skips.
+type checksumSource struct {
+	// where filters the recorder rows (aliases s, sm, sa); args bind its placeholders.
+	where string
+	args  []any
+	// selector is the numeric tables' --entity selection, applied by resolve.
+	selector *entitySelector
+	// resolution returns the bucket the exporter aggregates the entity's rows to, 0 for none.
+	resolution func(entityID string) time.Duration
+	convert    rowReplayer
+}
+
+// resolve narrows the source to the entity_ids its selector matches, as the exporter does.
+func (s checksumSource) resolve(ctx context.Context, sqliteDB *sql.DB) (checksumSource, error) {
+	family, err := numericFamily{where: s.where, args: s.args, selector: s.selector}.resolve(ctx, sqliteDB)
+	if err != nil {
+		return s, err
+	}
+	s.where, s.args, s.selector = family.where, family.args, nil
+	return s, nil
+}
+
+func checksumSources(selector *entitySelector) map[string]checksumSource {
+	never := func(string) time.Duration { return 0 }
+	numeric := func(f numericFamily) checksumSource {
+		return checksumSource{where: f.where, args: f.args, selector: f.selector, resolution: f.bucketResolution, convert: replayNumericRow}
+	}
+	return map[string]checksumSource{
+		gpsPointsTable.name: {
+			where:      `sa.shared_attrs LIKE '%"latitude"%' AND sa.shared_attrs LIKE '%"longitude"%'`,
+			resolution: never,
+			convert:    replayGPSRow,
+		},
+		batteryPointsTable.name: {
+			where:      `sa.shared_attrs LIKE '%"battery_level"%' OR sa.shared_attrs LIKE '%"device_class":"battery"%'`,
+			resolution: never,
+			convert:    replayBatteryRow,
+		},
+		weatherPointsTable.name: {
+			where:      `sm.entity_id = 'sun.sun' OR sm.entity_id LIKE 'weather.%'`,
+			resolution: never,
+			convert:    replayWeatherRow,
+		},
+		"energy_points":    numeric(newEnergyFamily(selector)),
+		"climate_points":   numeric(newClimateFamily(selector, checksumMinuteAverage)),
+		"utilities_points": numeric(newUtilitiesFamily(selector)),
+	}
+}
+
+func checksumTableNames() []string {
+	sources := checksumSources(nil)
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// entityDay identifies one entity's rows on one UTC day.
+type entityDay struct {
+	entityID string
+	day      string
+}
+
+// dayChecksum is the fingerprint of an entity-day: its row count and a hash of its sorted
+// timestamps in whole seconds, which every destination DATETIME column keeps.
+type dayChecksum struct {
+	rows int
+	hash string
+}
+
+// timestampSet collects the row timestamps (Unix seconds) per entity-day.
+type timestampSet map[entityDay][]int64
+
+func (s timestampSet) add(entityID string, t time.Time) {
+	t = t.UTC().Round(time.Second)
+	key := entityDay{entityID, t.Format(time.DateOnly)}
+	s[key] = append(s[key], t.Unix())
+}
+
+func (s timestampSet) checksums() map[entityDay]dayChecksum {
+	sums := make(map[entityDay]dayChecksum, len(s))
+	for key, seconds := range s {
+		sort.Slice(seconds, func(i, j int) bool { return seconds[i] < seconds[j] })
+		h := sha256.New()
+		var buf [8]byte
+		for _, sec := range seconds {
+			binary.BigEndian.PutUint64(buf[:], uint64(sec))
+			h.Write(buf[:])
+		}
+		sums[key] = dayChecksum{rows: len(seconds), hash: hex.EncodeToString(h.Sum(nil)[:8])}
+	}
+	return sums
+}
+
+// recorderChecksums scans the recorder rows since the cutoff the way the exporter converts them.
+// Aggregated entities contribute one row per bucket, stamped with its newest sample.
+func recorderChecksums(ctx context.Context, sqliteDB *sql.DB, source checksumSource, since time.Time) (map[entityDay]dayChecksum, error) {
+	query := `
+SELECT
+    s.state_id,
+    sm.entity_id,
+    s.state,
+    s.last_updated_ts,
+    COALESCE(sa.shared_attrs, '')
+FROM states s
+JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+LEFT JOIN state_attributes sa ON s.attributes_id = sa.attributes_id
+WHERE (` + source.where + `) AND s.last_updated_ts >= ?
+ORDER BY sm.entity_id, s.last_updated_ts
+`
+	args := append(append([]any{}, source.args...), float64(since.Unix()))
+	rows, err := sqliteDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query sqlite database: %w", err)
+	}
+	defer rows.Close()
+
+	set := timestampSet{}
+	type bucketKey struct {
+		entityID string
+		bucket   time.Time
+	}
+	newest := map[bucketKey]time.Time{}
+	for rows.Next() {
+		var row rejectedRow
+		if err := rows.Scan(&row.stateID, &row.entityID, &row.state, &row.lastUpdatedTS, &row.attributes); err != nil {
+			return nil, fmt.Errorf("scan sqlite row: %w", err)
+		}
+		// Rows the exporter rejects or skips are not in the destination either.
+		if _, ok, err := source.convert(row); err != nil || !ok {
+			continue
+		}
+		lastUpdated, err := floatToNullTime(row.lastUpdatedTS)
+		if err != nil || !lastUpdated.Valid {
+			continue
+		}
+		if resolution := source.resolution(row.entityID); resolution > 0 {
+			key := bucketKey{row.entityID, bucketStart(lastUpdated.Time, resolution)}
+			if lastUpdated.Time.After(newest[key]) {
+				newest[key] = lastUpdated.Time
+			}
+			continue
+		}
+		set.add(row.entityID, lastUpdated.Time)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate sqlite rows: %w", err)
+	}
+	for key, t := range newest {
+		set.add(key.entityID, t)
+	}
+	return set.checksums(), nil
+}
+
+// destinationChecksums reads the exported timestamps since the cutoff.
+func destinationChecksums(ctx context.Context, db *sql.DB, table string, since time.Time) (map[entityDay]dayChecksum, error) {
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	query := "SELECT entity_id, last_updated FROM " + table + " WHERE last_updated >= ?"
+	rows, err := db.QueryContext(qctx, query, since)
+	if err != nil {
+		return nil, explainTimeout(ctx, fmt.Errorf("read %s: %w", table, err))
+	}
+	defer rows.Close()
+
+	set := timestampSet{}
+	for rows.Next() {
+		var (
+			entityID    string
+			lastUpdated time.Time
+		)
+		if err := rows.Scan(&entityID, &lastUpdated); err != nil {
+			return nil, fmt.Errorf("scan %s row: %w", table, err)
+		}
+		set.add(entityID, lastUpdated)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, explainTimeout(ctx, fmt.Errorf("iterate %s rows: %w", table, err))
+	}
+	return set.checksums(), nil
+}
+
+// checksumResult is one entity-day with both sides' fingerprints; a side without rows is zero.
+type checksumResult struct {
+	key         entityDay
+	recorder    dayChecksum
+	destination dayChecksum
+}
+
+func (r checksumResult) matches() bool {
+	return r.recorder == r.destination
+}
+
+func compareChecksums(recorder, destination map[entityDay]dayChecksum) []checksumResult {
+	results := make([]checksumResult, 0, len(recorder))
+	for key, sum := range recorder {
+		results = append(results, checksumResult{key: key, recorder: sum, destination: destination[key]})
+	}
+	for key, sum := range destination {
+		if _, ok := recorder[key]; !ok {
+			results = append(results, checksumResult{key: key, destination: sum})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].key.entityID != results[j].key.entityID {
+			return results[i].key.entityID < results[j].key.entityID
+		}
+		return results[i].key.day < results[j].key.day
+	})
+	return results
+}
+
+// checksumsTable keeps the latest fingerprints of both sides per table, entity, and day, so drift
+// can be tracked (and queried) without rescanning.
+var checksumsTable = &tableSpec{
+	name: "ha_tools_checksums",
+	columns: []columnSpec{
+		{name: "table_name", sqlType: "VARCHAR(64) NOT NULL"},
+		{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "day", sqlType: "DATE NOT NULL"},
+		{name: "recorder_rows", sqlType: "INT NOT NULL"},
+		{name: "recorder_hash", sqlType: "CHAR(16) NULL"},
+		{name: "destination_rows", sqlType: "INT NOT NULL"},
+		{name: "destination_hash", sqlType: "CHAR(16) NULL"},
+		{name: "matches", sqlType: "BOOLEAN NOT NULL"},
+		{name: "checked_at", sqlType: "DATETIME NOT NULL"},
+	},
+	primaryKey: []string{"table_name", "entity_id", "day"},
+}
+
+func storeChecksums(ctx context.Context, sink Sink, table string, results []checksumResult) error {
+	if err := sink.EnsureSchema(ctx, checksumsTable); err != nil {
+		return fmt.Errorf("ensure %s table: %w", checksumsTable.name, err)
+	}
+	const checksumBatchSize = 500
+	writer := newBatchWriter(sink, checksumsTable, checksumBatchSize)
+	checkedAt := wallClock.Now().UTC()
+	nullHash := func(s dayChecksum) sql.NullString {
+		return sql.NullString{String: s.hash, Valid: s.rows > 0}
+	}
+	for _, r := range results {
+		if err := writer.Add(ctx, table, r.key.entityID, r.key.day,
+			r.recorder.rows, nullHash(r.recorder),
+			r.destination.rows, nullHash(r.destination),
+			r.matches(), checkedAt); err != nil {
+			return fmt.Errorf("write %s: %w", checksumsTable.name, err)
+		}
+	}
+	if err := writer.Flush(ctx); err != nil {
+		return fmt.Errorf("write %s: %w", checksumsTable.name, err)
+	}
+	return nil
+}
+
+// printChecksumResults lists the entity-days that differ and returns how many there are.
+func printChecksumResults(w io.Writer, results []checksumResult) int {
+	var mismatched []checksumResult
+	for _, r := range results {
+		if !r.matches() {
+			mismatched = append(mismatched, r)
+		}
+	}
+	if len(mismatched) == 0 {
+		fmt.Fprintf(w, "%d entity-day(s) match\n", len(results))
+		return 0
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENTITY\tDAY\tRECORDER ROWS\tDESTINATION ROWS\tRECORDER HASH\tDESTINATION HASH")
+	for _, r := range mismatched {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\n", r.key.entityID, r.key.day, r.recorder.rows, r.destination.rows, r.recorder.hash, r.destination.hash)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d of %d entity-day(s) differ\n", len(mismatched), len(results))
+	return len(mismatched)
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	climateSQLitePaths   []string
+	climateMySQLDSN      string
+	climateEntity        string
+	climateMatch         string
+	climateMinuteAverage bool
+	climateOptions       numericExportOptions
+)
+
+// climateCmd exports temperature and humidity sensors through the shared numeric pipeline.
+var climateCmd = &cobra.Command{
+	Use:   "climate-sensors",
+	Short: "Export Home Assistant temperature/humidity sensors into MySQL",
+	Long:  "Reads sensor.*_temperature and sensor.*_humidity states from the Home Assistant SQLite recorder database and upserts them into a climate_points table.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(climateSQLitePaths) == 0 {
+			return errors.New("sqlite database path is required")
+		}
+		if climateMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		selector, err := newEntitySelector(climateMatch, climateEntity)
+		if err != nil {
+			return err
+		}
+		family := newClimateFamily(selector, climateMinuteAverage)
+		return forEachRecorder(ctx, climateSQLitePaths, func(sqlitePath string) error {
+			return transferNumericData(ctx, sqlitePath, climateMySQLDSN, family, climateOptions)
+		})
+	},
+}
+
+func init() {
+	climateCmd.Flags().StringArrayVar(&climateSQLitePaths, "sqlite", nil, recorderFlagUsage)
+	climateCmd.Flags().StringVar(&climateMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	climateCmd.Flags().StringVar(&climateEntity, "entity", "", "Optional slug narrowing the exported sensors (see --match)")
+	addMatchFlag(climateCmd, &climateMatch)
+	climateCmd.Flags().BoolVar(&climateMinuteAverage, "minute-average", false, "Aggregate temperature/humidity samples per entity and minute (mean, or as the config's aggregations say)")
+	climateCmd.Flags().BoolVar(&climateOptions.normalized, "normalized", false, "Write into the normalized entities/climate_facts schema instead of the wide climate_points table")
+	climateCmd.Flags().BoolVar(&climateOptions.withDelta, "with-delta", false, "Fill prev_numeric_state and delta columns per entity during export")
+	climateCmd.Flags().BoolVar(&climateOptions.withPreviousState, "with-previous-state", false, previousStateFlagUsage)
+	climateCmd.Flags().BoolVar(&climateOptions.ohlc, "ohlc", false, ohlcFlagUsage)
+	climateCmd.Flags().StringVar(&climateOptions.idStrategy, "id-strategy", idStrategyAuto, "How state_id is assigned: auto (AUTO_INCREMENT) or hash (derived from entity_id and time, idempotent for averaged rows)")
+	addGroupByFlag(climateCmd, &climateOptions.groupBy)
+	_ = climateCmd.MarkFlagRequired("sqlite")
+	_ = climateCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(climateCmd)
+}
+
+// newClimateFamily matches sensor.*_temperature and sensor.*_humidity entities, optionally
+// narrowed by the --entity selection.
+func newClimateFamily(selector *entitySelector, minuteAverage bool) numericFamily {
+	family := numericFamily{
+		name:     "climate",
+		where:    "sm.entity_id LIKE 'sensor.%' AND (sm.entity_id LIKE '%\\_temperature%' ESCAPE '\\' OR sm.entity_id LIKE '%\\_humidity%' ESCAPE '\\')",
+		selector: selector,
+	}
+	if minuteAverage {
+		family.averageTokens = []string{"_temperature", "_humidity"}
+	}
+	return family
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+)
+
+// clock tells the time. Export and rollup logic reads it through wallClock instead of calling
+// time.Now, so it can run at a fixed instant, e.g. across a DST change.
+type clock interface {
+	Now() time.Time
+}
+
+// systemClock is the real time.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// fixedClock always tells the same instant.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+// wallClock is the clock commands read.
+var wallClock clock = systemClock{}
+
+// nowFlag is the --now flag: run at a fixed instant instead of the current time.
+var nowFlag string
+
+// timeZoneName is the --time-zone flag; exportZone is the resolved zone. Recorder times are
+// read in it, and local days, hours, months, and tariff windows follow it.
+var (
+	timeZoneName string
+	exportZone   = time.Local
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&nowFlag, "now", "", "Run as if it were this time (RFC 3339), e.g. to reproduce a report, rollup, or fetch window")
+	rootCmd.PersistentFlags().StringVar(&timeZoneName, "time-zone", "", "IANA zone local days, hours, months, and tariff windows follow, e.g. Europe/Berlin (default: the system zone, $TZ)")
+}
+
+// resolveClock applies --time-zone and --now.
+func resolveClock() error {
+	exportZone = time.Local
+	if timeZoneName != "" {
+		loc, err := time.LoadLocation(timeZoneName)
+		if err != nil {
+			return fmt.Errorf("--time-zone: %w", err)
+		}
+		exportZone = loc
+	}
+	wallClock = systemClock{}
+	if nowFlag != "" {
+		now, err := time.Parse(time.RFC3339, nowFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --now %q (use RFC 3339, e.g. 2024-03-31T02:30:00+02:00)", nowFlag)
+		}
+		wallClock = fixedClock(now)
+	}
+	return nil
+}
+
+// inExportZone returns t in the export zone.
+func inExportZone(t time.Time) time.Time {
+	return t.In(exportZone)
+}
+
+// exportDay returns the export-zone date holding t, the value written to DATE columns. A
+// time.Time would be converted to the driver's zone first and could land on the day before.
+func exportDay(t time.Time) string {
+	return inExportZone(t).Format(time.DateOnly)
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// useClock runs the test as if --now and --time-zone were given, restoring the clock after it.
+func useClock(t *testing.T, now, zone string) {
+	t.Helper()
+	savedNow, savedZoneName := nowFlag, timeZoneName
+	savedClock, savedZone := wallClock, exportZone
+	t.Cleanup(func() {
+		nowFlag, timeZoneName = savedNow, savedZoneName
+		wallClock, exportZone = savedClock, savedZone
+	})
+	nowFlag, timeZoneName = now, zone
+	if err := resolveClock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveClock(t *testing.T) {
+	useClock(t, "2024-03-31T02:30:00+02:00", "Europe/Berlin")
+	if got, want := wallClock.Now(), time.Date(2024, 3, 31, 0, 30, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("wallClock.Now() = %s, want %s", got, want)
+	}
+	if got := wallClock.Now(); !got.Equal(wallClock.Now()) {
+		t.Error("a fixed clock moved")
+	}
+	if exportZone.String() != "Europe/Berlin" {
+		t.Errorf("exportZone = %s, want Europe/Berlin", exportZone)
+	}
+	if got := inExportZone(wallClock.Now()).Format("15:04 MST"); got != "01:30 CET" {
+		t.Errorf("now in the export zone reads %s, want 01:30 CET", got)
+	}
+
+	for _, tt := range []struct{ now, zone string }{
+		{"2024-03-31 02:30", ""},
+		{"", "Europe/Nowhere"},
+	} {
+		nowFlag, timeZoneName = tt.now, tt.zone
+		if err := resolveClock(); err == nil {
+			t.Errorf("resolveClock() with --now %q --time-zone %q did not fail", tt.now, tt.zone)
+		}
+	}
+
+	nowFlag, timeZoneName = "", ""
+	if err := resolveClock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := wallClock.(systemClock); !ok || exportZone != time.Local {
+		t.Errorf("without flags the clock is %T in %s, want the system clock in the local zone", wallClock, exportZone)
+	}
+}
+
+// TestLocalDaysFollowExportZone checks that "now" falls on the export zone's calendar day, and that
+// the days around a DST change are as long as the zone makes them.
+func TestLocalDaysFollowExportZone(t *testing.T) {
+	tests := []struct {
+		zone  string
+		now   string
+		day   string
+		hours float64
+	}{
+		{"Europe/Berlin", "2024-03-30T23:30:00Z", "2024-03-31", 23},
+		{"Europe/Berlin", "2024-10-27T12:00:00Z", "2024-10-27", 25},
+		{"America/New_York", "2024-03-10T04:30:00Z", "2024-03-09", 24},
+		{"America/New_York", "2024-03-10T05:30:00Z", "2024-03-10", 23},
+		{"America/New_York", "2024-11-03T05:30:00Z", "2024-11-03", 25},
+		{"UTC", "2024-03-30T23:30:00Z", "2024-03-30", 24},
+	}
+	for _, tt := range tests {
+		t.Run(tt.zone+" "+tt.now, func(t *testing.T) {
+			useClock(t, tt.now, tt.zone)
+			start := dayStart(inExportZone(wallClock.Now()))
+			if got := start.Format(time.DateOnly); got != tt.day {
+				t.Errorf("%s falls on %s in %s, want %s", tt.now, got, tt.zone, tt.day)
+			}
+			if hours := start.AddDate(0, 0, 1).Sub(start).Hours(); hours != tt.hours {
+				t.Errorf("%s in %s is %v hours long, want %v", tt.day, tt.zone, hours, tt.hours)
+			}
+		})
+	}
+}
+
+func TestBillingPeriodFollowsExportZone(t *testing.T) {
+	tests := []struct {
+		zone       string
+		now        string
+		start, end string
+		hours      float64
+	}{
+		// 22:30 UTC on March 31 is already April in Berlin, but still March in New York.
+		{"Europe/Berlin", "2024-03-31T22:30:00Z", "2024-03-01T00:00:00+01:00", "2024-04-01T00:00:00+02:00", 31*24 - 1},
+		{"America/New_York", "2024-03-31T22:30:00Z", "2024-02-01T00:00:00-05:00", "2024-03-01T00:00:00-05:00", 29 * 24},
+		{"America/New_York", "2024-12-01T05:00:00Z", "2024-11-01T00:00:00-04:00", "2024-12-01T00:00:00-05:00", 30*24 + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.zone+" "+tt.now, func(t *testing.T) {
+			useClock(t, tt.now, tt.zone)
+			start, end, err := parseBillingPeriod("", inExportZone(wallClock.Now()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := start.Format(time.RFC3339); got != tt.start {
+				t.Errorf("period starts %s, want %s", got, tt.start)
+			}
+			if got := end.Format(time.RFC3339); got != tt.end {
+				t.Errorf("period ends %s, want %s", got, tt.end)
+			}
+			if hours := end.Sub(start).Hours(); hours != tt.hours {
+				t.Errorf("period is %v hours long, want %v", hours, tt.hours)
+			}
+		})
+	}
+}
+
+func TestTariffWindowFollowsExportZone(t *testing.T) {
+	price := 0.3
+	tariff := &tariffConfig{Windows: []*tariffWindow{
+		{Name: "weekend", Days: []string{"sat", "sun"}, Price: &price},
+		{Name: "peak", From: "07:00", To: "23:00", Price: &price},
+		{Name: "offpeak", Price: &price},
+	}}
+	if err := tariff.validate(); err != nil {
+		t.Fatal(err)
+	}
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		zone string
+		at   string
+		want string
+	}{
+		// Friday 22:30 UTC is Friday peak in New York but Saturday in Berlin.
+		{"America/New_York", "2024-03-29T22:30:00Z", "peak"},
+		{"Europe/Berlin", "2024-03-29T23:30:00Z", "weekend"},
+		// 06:30 UTC is offpeak in Berlin in winter (07:30 CET is peak) ...
+		{"Europe/Berlin", "2024-03-28T05:30:00Z", "offpeak"},
+		{"Europe/Berlin", "2024-03-28T06:30:00Z", "peak"},
+		// ... and peak once the clocks went forward (08:30 CEST).
+		{"Europe/Berlin", "2024-04-02T05:30:00Z", "peak"},
+		{"Europe/Berlin", "2024-04-02T04:30:00Z", "offpeak"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.zone+" "+tt.at, func(t *testing.T) {
+			useClock(t, "", tt.zone)
+			i := tariff.window(at(tt.at))
+			if i < 0 {
+				t.Fatalf("no window holds %s", tt.at)
+			}
+			if got := tariff.Windows[i].Name; got != tt.want {
+				t.Errorf("%s in %s is in window %s, want %s", tt.at, tt.zone, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWatermarksCompareInstants checks that recorder times are read into the export zone without
+// changing the instant, so watermarks compare the same whichever zone a run used, including for
+// the two passes of a repeated hour that read the same on the wall clock.
+func TestWatermarksCompareInstants(t *testing.T) {
+	useClock(t, "2024-10-27T03:00:00Z", "Europe/Berlin")
+	recorderTime := func(utc string) time.Time {
+		t.Helper()
+		v, err := time.Parse(time.RFC3339Nano, utc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nt, err := floatToNullTime(sql.NullFloat64{Float64: float64(v.UnixMicro()) / 1e6, Valid: true})
+		if err != nil || !nt.Valid {
+			t.Fatalf("floatToNullTime(%s) = %v, %v", utc, nt, err)
+		}
+		if nt.Time.Location() != exportZone {
+			t.Fatalf("recorder time read in %s, want the export zone %s", nt.Time.Location(), exportZone)
+		}
+		if !nt.Time.Equal(v) {
+			t.Fatalf("recorder time %s read as %s", utc, nt.Time)
+		}
+		return nt.Time
+	}
+	firstPass := recorderTime("2024-10-27T00:30:00.123456Z")
+	secondPass := recorderTime("2024-10-27T01:30:00.123456Z")
+	if a, b := firstPass.Format(time.DateTime), secondPass.Format(time.DateTime); a != b {
+		t.Fatalf("the repeated hour reads %s and %s, want the same wall clock", a, b)
+	}
+
+	newYork := mustLoadLocation(t, "America/New_York")
+	tests := []struct {
+		name      string
+		watermark time.Time
+		at        time.Time
+		id        int64
+		want      bool
+	}{
+		{"second pass after first pass watermark", firstPass, secondPass, 1, false},
+		{"first pass before second pass watermark", secondPass, firstPass, 1, true},
+		{"watermark stored by a run in another zone", firstPass.In(newYork), firstPass, 7, true},
+		{"watermark stored in UTC, later id", firstPass.UTC(), firstPass, 8, false},
+		{"microsecond after the watermark", firstPass.In(newYork), firstPass.Add(time.Microsecond), 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			watermarks := map[string]time.Time{"sensor.plug_1_power": tt.watermark}
+			ties := map[string]int64{"sensor.plug_1_power": 7}
+			if got := exportedBefore(watermarks, ties, "sensor.plug_1_power", tt.at, tt.id); got != tt.want {
+				t.Errorf("exportedBefore(watermark %s, %s, id %d) = %v, want %v", tt.watermark, tt.at, tt.id, got, tt.want)
+			}
+		})
+	}
+}
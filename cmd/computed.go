@@ -0,0 +1,915 @@
+package cmd
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// computedColumn is one entry of the config's computed_columns section, e.g.
+// {"energy_points": ["cost = numeric_state * 0.31 / 1000", "is_night = hour(last_updated) < 6"]}:
+// a column the sinks add to the table and fill per row from an expression over its other columns.
+type computedColumn struct {
+	name string
+	expr exprNode
+}
+
+var computedDefinitionPattern = regexp.MustCompile(`^\s*([A-Za-z_][A-Za-z0-9_]*)\s*=([^=].*)$`)
+
+// parseComputedColumn parses a "name = expression" definition.
+func parseComputedColumn(definition string) (*computedColumn, error) {
+	m := computedDefinitionPattern.FindStringSubmatch(definition)
+	if m == nil {
+		return nil, fmt.Errorf("%q is not of the form name = expression", definition)
+	}
+	expr, err := parseExpr(m[2])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m[1], err)
+	}
+	return &computedColumn{name: strings.ToLower(m[1]), expr: expr}, nil
+}
+
+// validateComputedColumns parses every definition; types are checked once the table's columns are
+// known, when an exporter first uses the table.
+func (c *fileConfig) validateComputedColumns() error {
+	c.computed = make(map[string][]*computedColumn, len(c.ComputedColumns))
+	for _, table := range sortedKeys(c.ComputedColumns) {
+		seen := make(map[string]bool)
+		for i, definition := range c.ComputedColumns[table] {
+			column, err := parseComputedColumn(definition)
+			if err != nil {
+				return fmt.Errorf("computed_columns.%s[%d]: %w", table, i, err)
+			}
+			if seen[column.name] {
+				return fmt.Errorf("computed_columns.%s[%d]: %s is defined twice", table, i, column.name)
+			}
+			seen[column.name] = true
+			c.computed[table] = append(c.computed[table], column)
+		}
+	}
+	return nil
+}
+
+// computedTable is a table extended with its computed columns, and the compiled expressions
+// filling them from a row of the base table.
+type computedTable struct {
+	table *tableSpec
+	eval  []func(row []any) any
+	err   error
+}
+
+var computedTables struct {
+	mu     sync.Mutex
+	tables map[*tableSpec]*computedTable
+}
+
+// withComputedColumns returns the table with the config's computed columns appended, the same
+// *tableSpec for every call so sinks can cache per table. Tables without computed columns (and
+// tables already extended) are returned unchanged.
+func withComputedColumns(table *tableSpec) (*tableSpec, error) {
+	c, err := computedFor(table)
+	if err != nil || c == nil {
+		return table, err
+	}
+	return c.table, nil
+}
+
+func computedFor(table *tableSpec) (*computedTable, error) {
+	if len(appConfig.computed[table.name]) == 0 {
+		return nil, nil
+	}
+	computedTables.mu.Lock()
+	defer computedTables.mu.Unlock()
+	if computedTables.tables == nil {
+		computedTables.tables = make(map[*tableSpec]*computedTable)
+	}
+	if c, ok := computedTables.tables[table]; ok {
+		if c == nil {
+			return nil, nil
+		}
+		return c, c.err
+	}
+	c := compileComputedTable(table, appConfig.computed[table.name])
+	computedTables.tables[table] = c
+	if c.err == nil {
+		// The extended table passes through here again from the sinks; it needs nothing more.
+		computedTables.tables[c.table] = nil
+	}
+	return c, c.err
+}
+
+func compileComputedTable(table *tableSpec, columns []*computedColumn) *computedTable {
+	env := make(map[string]exprColumn)
+	i := 0
+	for _, c := range table.columns {
+		if c.generated {
+			continue
+		}
+		if t, ok := exprColumnType(c.sqlType); ok {
+			env[c.name] = exprColumn{index: i, typ: t}
+		}
+		i++
+	}
+
+	c := &computedTable{}
+	extra := make([]columnSpec, 0, len(columns))
+	for _, column := range columns {
+		if indexOfColumn(table.columns, column.name) >= 0 {
+			c.err = fmt.Errorf("computed_columns.%s: %s is already a column of the table", table.name, column.name)
+			return c
+		}
+		eval, typ, err := column.expr.compile(env)
+		if err != nil {
+			c.err = fmt.Errorf("computed_columns.%s: %s: %w", table.name, column.name, err)
+			return c
+		}
+		extra = append(extra, columnSpec{name: column.name, sqlType: typ.sqlType()})
+		c.eval = append(c.eval, eval)
+	}
+	c.table = table.withColumns(extra...)
+	return c
+}
+
+// computeColumns appends the computed values to each row of the base table, returning the
+// extended table and rows.
+func computeColumns(table *tableSpec, rows [][]any) (*tableSpec, [][]any, error) {
+	c, err := computedFor(table)
+	if err != nil || c == nil {
+		return table, rows, err
+	}
+	extended := make([][]any, len(rows))
+	for i, row := range rows {
+		values := make([]any, len(row), len(row)+len(c.eval))
+		copy(values, row)
+		for _, eval := range c.eval {
+			values = append(values, eval(row))
+		}
+		extended[i] = values
+	}
+	return c.table, extended, nil
+}
+
+// exprType is the static type of an expression; every type also admits NULL.
+type exprType int
+
+const (
+	exprNull exprType = iota
+	exprNumber
+	exprBool
+	exprString
+	exprTime
+)
+
+func (t exprType) String() string {
+	return [...]string{"null", "number", "boolean", "string", "time"}[t]
+}
+
+func (t exprType) sqlType() string {
+	switch t {
+	case exprBool:
+		return "BOOLEAN NULL"
+	case exprString:
+		return "TEXT NULL"
+	case exprTime:
+		return "DATETIME NULL"
+	}
+	return "DOUBLE NULL"
+}
+
+// exprColumnType maps a column's SQL type to the expression type its values have.
+func exprColumnType(sqlType string) (exprType, bool) {
+	sqlType = strings.ToUpper(sqlType)
+	switch {
+	case strings.HasPrefix(sqlType, "BIGINT"), strings.HasPrefix(sqlType, "INT"), strings.HasPrefix(sqlType, "DOUBLE"):
+		return exprNumber, true
+	case strings.HasPrefix(sqlType, "BOOLEAN"):
+		return exprBool, true
+	case strings.HasPrefix(sqlType, "DATE"):
+		return exprTime, true
+	case strings.HasPrefix(sqlType, "VARCHAR"), strings.HasPrefix(sqlType, "CHAR"), strings.HasPrefix(sqlType, "TEXT"), strings.HasPrefix(sqlType, "MEDIUMTEXT"):
+		return exprString, true
+	}
+	return 0, false
+}
+
+type exprColumn struct {
+	index int
+	typ   exprType
+}
+
+// exprNode is a parsed expression; compile type-checks it against a table's columns and returns
+// its evaluator. Evaluators return nil for NULL, float64, bool, string, or time.Time.
+type exprNode interface {
+	compile(env map[string]exprColumn) (func(row []any) any, exprType, error)
+}
+
+type (
+	exprLiteral struct {
+		value any
+		typ   exprType
+	}
+	exprIdent struct {
+		name string
+	}
+	exprUnary struct {
+		op      string
+		operand exprNode
+	}
+	exprBinary struct {
+		op          string
+		left, right exprNode
+	}
+	exprCall struct {
+		name string
+		args []exprNode
+	}
+)
+
+func (e *exprLiteral) compile(map[string]exprColumn) (func([]any) any, exprType, error) {
+	return func([]any) any { return e.value }, e.typ, nil
+}
+
+func (e *exprIdent) compile(env map[string]exprColumn) (func([]any) any, exprType, error) {
+	column, ok := env[e.name]
+	if !ok {
+		names := sortedKeys(env)
+		return nil, 0, fmt.Errorf("unknown column %s (available: %s)", e.name, strings.Join(names, ", "))
+	}
+	return func(row []any) any { return exprValue(row[column.index]) }, column.typ, nil
+}
+
+// exprValue normalizes a row value (sql.Null*, integers) to an evaluator value.
+func exprValue(v any) any {
+	value, err := driver.DefaultParameterConverter.ConvertValue(v)
+	if err != nil {
+		return nil
+	}
+	switch value := value.(type) {
+	case int64:
+		return float64(value)
+	case float64:
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil
+		}
+	case []byte:
+		return string(value)
+	}
+	return value
+}
+
+func (e *exprUnary) compile(env map[string]exprColumn) (func([]any) any, exprType, error) {
+	operand, typ, err := e.operand.compile(env)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch e.op {
+	case "-":
+		if typ != exprNumber && typ != exprNull {
+			return nil, 0, fmt.Errorf("- needs a number, not a %s", typ)
+		}
+		return func(row []any) any {
+			if v, ok := operand(row).(float64); ok {
+				return -v
+			}
+			return nil
+		}, exprNumber, nil
+	default: // not
+		if typ != exprBool && typ != exprNull {
+			return nil, 0, fmt.Errorf("not needs a boolean, not a %s", typ)
+		}
+		return func(row []any) any {
+			if v, ok := operand(row).(bool); ok {
+				return !v
+			}
+			return nil
+		}, exprBool, nil
+	}
+}
+
+func (e *exprBinary) compile(env map[string]exprColumn) (func([]any) any, exprType, error) {
+	left, lt, err := e.left.compile(env)
+	if err != nil {
+		return nil, 0, err
+	}
+	right, rt, err := e.right.compile(env)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch e.op {
+	case "and", "or":
+		if (lt != exprBool && lt != exprNull) || (rt != exprBool && rt != exprNull) {
+			return nil, 0, fmt.Errorf("%s needs booleans, not %s and %s", e.op, lt, rt)
+		}
+		and := e.op == "and"
+		// SQL three-valued logic: false and NULL is false, true or NULL is true.
+		return func(row []any) any {
+			l, lok := left(row).(bool)
+			if lok && l != and {
+				return l
+			}
+			r, rok := right(row).(bool)
+			if rok && r != and {
+				return r
+			}
+			if lok && rok {
+				return and
+			}
+			return nil
+		}, exprBool, nil
+
+	case "+", "-", "*", "/", "%":
+		if (lt != exprNumber && lt != exprNull) || (rt != exprNumber && rt != exprNull) {
+			return nil, 0, fmt.Errorf("%s needs numbers, not %s and %s", e.op, lt, rt)
+		}
+		op := e.op
+		return func(row []any) any {
+			l, lok := left(row).(float64)
+			r, rok := right(row).(float64)
+			if !lok || !rok {
+				return nil
+			}
+			switch op {
+			case "+":
+				return l + r
+			case "-":
+				return l - r
+			case "*":
+				return l * r
+			}
+			// Like MySQL, dividing by zero yields NULL.
+			if r == 0 {
+				return nil
+			}
+			if op == "/" {
+				return l / r
+			}
+			return math.Mod(l, r)
+		}, exprNumber, nil
+	}
+
+	// Comparisons.
+	typ := lt
+	if typ == exprNull {
+		typ = rt
+	}
+	if lt != rt && lt != exprNull && rt != exprNull {
+		return nil, 0, fmt.Errorf("cannot compare %s with %s", lt, rt)
+	}
+	if typ == exprBool && e.op != "=" && e.op != "!=" {
+		return nil, 0, fmt.Errorf("%s cannot order booleans", e.op)
+	}
+	op := e.op
+	return func(row []any) any {
+		l, r := left(row), right(row)
+		if l == nil || r == nil {
+			return nil
+		}
+		c := compareExprValues(l, r)
+		switch op {
+		case "=":
+			return c == 0
+		case "!=":
+			return c != 0
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		}
+		return c >= 0
+	}, exprBool, nil
+}
+
+func compareExprValues(l, r any) int {
+	switch l := l.(type) {
+	case float64:
+		r := r.(float64)
+		switch {
+		case l < r:
+			return -1
+		case l > r:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(l, r.(string))
+	case time.Time:
+		return l.Compare(r.(time.Time))
+	case bool:
+		if l == r.(bool) {
+			return 0
+		}
+		return 1
+	}
+	return 0
+}
+
+// exprFunction describes a built-in function: its argument types (exprNull accepts any type) and
+// result type, or check for functions whose types depend on their arguments.
+type exprFunction struct {
+	args   []exprType
+	result exprType
+	// variadic allows further arguments of the last argument's type.
+	variadic bool
+	// check overrides args/result.
+	check func(types []exprType) (exprType, error)
+	call  func(args []any) any
+}
+
+// exprFunctions are the built-in functions. Time parts are read in the --time-zone zone.
+var exprFunctions = map[string]exprFunction{
+	"hour":    timePartFunction(func(t time.Time) int { return t.Hour() }),
+	"minute":  timePartFunction(func(t time.Time) int { return t.Minute() }),
+	"weekday": timePartFunction(func(t time.Time) int { return int(t.Weekday()) }),
+	"day":     timePartFunction(func(t time.Time) int { return t.Day() }),
+	"month":   timePartFunction(func(t time.Time) int { return int(t.Month()) }),
+	"year":    timePartFunction(func(t time.Time) int { return t.Year() }),
+	"abs":     numberFunction(math.Abs),
+	"floor":   numberFunction(math.Floor),
+	"ceil":    numberFunction(math.Ceil),
+	"round": {
+		check: func(types []exprType) (exprType, error) {
+			if len(types) < 1 || len(types) > 2 {
+				return 0, errors.New("takes a number and optional digits")
+			}
+			for _, t := range types {
+				if t != exprNumber && t != exprNull {
+					return 0, fmt.Errorf("needs numbers, not a %s", t)
+				}
+			}
+			return exprNumber, nil
+		},
+		call: func(args []any) any {
+			v, ok := args[0].(float64)
+			if !ok {
+				return nil
+			}
+			scale := 1.0
+			if len(args) == 2 {
+				digits, ok := args[1].(float64)
+				if !ok {
+					return nil
+				}
+				scale = math.Pow(10, math.Trunc(digits))
+			}
+			return math.Round(v*scale) / scale
+		},
+	},
+	"min": {args: []exprType{exprNumber, exprNumber}, result: exprNumber, variadic: true, call: func(args []any) any {
+		return foldNumbers(args, math.Min)
+	}},
+	"max": {args: []exprType{exprNumber, exprNumber}, result: exprNumber, variadic: true, call: func(args []any) any {
+		return foldNumbers(args, math.Max)
+	}},
+	"number": {args: []exprType{exprString}, result: exprNumber, call: func(args []any) any {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+		return v
+	}},
+	"lower":       stringFunction(strings.ToLower),
+	"upper":       stringFunction(strings.ToUpper),
+	"contains":    stringPredicate(strings.Contains),
+	"starts_with": stringPredicate(strings.HasPrefix),
+	"ends_with":   stringPredicate(strings.HasSuffix),
+	"concat": {
+		check: func(types []exprType) (exprType, error) {
+			if len(types) == 0 {
+				return 0, errors.New("needs arguments")
+			}
+			return exprString, nil
+		},
+		call: func(args []any) any {
+			var b strings.Builder
+			for _, arg := range args {
+				switch v := arg.(type) {
+				case nil:
+					return nil
+				case float64:
+					b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+				case time.Time:
+					b.WriteString(inExportZone(v).Format(time.DateTime))
+				default:
+					fmt.Fprint(&b, v)
+				}
+			}
+			return b.String()
+		},
+	},
+	"coalesce": {
+		check: func(types []exprType) (exprType, error) {
+			if len(types) == 0 {
+				return 0, errors.New("needs arguments")
+			}
+			return unifyExprTypes(types)
+		},
+		call: func(args []any) any {
+			for _, arg := range args {
+				if arg != nil {
+					return arg
+				}
+			}
+			return nil
+		},
+	},
+	"if": {
+		check: func(types []exprType) (exprType, error) {
+			if len(types) != 3 {
+				return 0, errors.New("takes a condition and two values")
+			}
+			if types[0] != exprBool && types[0] != exprNull {
+				return 0, fmt.Errorf("condition must be a boolean, not a %s", types[0])
+			}
+			return unifyExprTypes(types[1:])
+		},
+		call: func(args []any) any {
+			if cond, _ := args[0].(bool); cond {
+				return args[1]
+			}
+			return args[2]
+		},
+	},
+}
+
+func timePartFunction(part func(time.Time) int) exprFunction {
+	return exprFunction{args: []exprType{exprTime}, result: exprNumber, call: func(args []any) any {
+		t, ok := args[0].(time.Time)
+		if !ok {
+			return nil
+		}
+		return float64(part(inExportZone(t)))
+	}}
+}
+
+func numberFunction(fn func(float64) float64) exprFunction {
+	return exprFunction{args: []exprType{exprNumber}, result: exprNumber, call: func(args []any) any {
+		v, ok := args[0].(float64)
+		if !ok {
+			return nil
+		}
+		return fn(v)
+	}}
+}
+
+func stringFunction(fn func(string) string) exprFunction {
+	return exprFunction{args: []exprType{exprString}, result: exprString, call: func(args []any) any {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil
+		}
+		return fn(s)
+	}}
+}
+
+func stringPredicate(fn func(s, substr string) bool) exprFunction {
+	return exprFunction{args: []exprType{exprString, exprString}, result: exprBool, call: func(args []any) any {
+		s, ok := args[0].(string)
+		substr, ok2 := args[1].(string)
+		if !ok || !ok2 {
+			return nil
+		}
+		return fn(s, substr)
+	}}
+}
+
+func foldNumbers(args []any, fn func(a, b float64) float64) any {
+	result, ok := args[0].(float64)
+	if !ok {
+		return nil
+	}
+	for _, arg := range args[1:] {
+		v, ok := arg.(float64)
+		if !ok {
+			return nil
+		}
+		result = fn(result, v)
+	}
+	return result
+}
+
+// unifyExprTypes returns the one non-null type among types.
+func unifyExprTypes(types []exprType) (exprType, error) {
+	result := exprNull
+	for _, t := range types {
+		if t == exprNull {
+			continue
+		}
+		if result != exprNull && t != result {
+			return 0, fmt.Errorf("mixes %s and %s values", result, t)
+		}
+		result = t
+	}
+	return result, nil
+}
+
+func (e *exprCall) compile(env map[string]exprColumn) (func([]any) any, exprType, error) {
+	fn, ok := exprFunctions[e.name]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown function %s (available: %s)", e.name, strings.Join(sortedKeys(exprFunctions), ", "))
+	}
+	args := make([]func([]any) any, len(e.args))
+	types := make([]exprType, len(e.args))
+	for i, arg := range e.args {
+		var err error
+		if args[i], types[i], err = arg.compile(env); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	result := fn.result
+	if fn.check != nil {
+		var err error
+		if result, err = fn.check(types); err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", e.name, err)
+		}
+	} else {
+		if len(types) < len(fn.args) || (len(types) > len(fn.args) && !fn.variadic) {
+			return nil, 0, fmt.Errorf("%s takes %d arguments, not %d", e.name, len(fn.args), len(types))
+		}
+		for i, t := range types {
+			want := fn.args[min(i, len(fn.args)-1)]
+			if t != want && t != exprNull {
+				return nil, 0, fmt.Errorf("%s: argument %d must be a %s, not a %s", e.name, i+1, want, t)
+			}
+		}
+	}
+
+	call := fn.call
+	return func(row []any) any {
+		values := make([]any, len(args))
+		for i, arg := range args {
+			values[i] = arg(row)
+		}
+		return call(values)
+	}, result, nil
+}
+
+// parseExpr parses an expression. The grammar, loosest binding first:
+//
+//	or, and, not, comparisons (= == != <> < <= > >=), + -, * / %, unary -,
+//	then literals (numbers, 'strings', true, false, null), columns, calls, and parentheses.
+func parseExpr(source string) (exprNode, error) {
+	tokens, err := tokenizeExpr(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+	}
+	return node, nil
+}
+
+type exprTokenKind int
+
+const (
+	tokenEOF exprTokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOp
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string
+	pos  int
+}
+
+func tokenizeExpr(source string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(source) && source[i+1] >= '0' && source[i+1] <= '9':
+			start := i
+			for i < len(source) && (source[i] >= '0' && source[i] <= '9' || source[i] == '.' || source[i] == 'e' || source[i] == 'E' ||
+				(source[i] == '-' || source[i] == '+') && (source[i-1] == 'e' || source[i-1] == 'E')) {
+				i++
+			}
+			tokens = append(tokens, exprToken{tokenNumber, source[start:i], start})
+		case c == '\'' || c == '"':
+			start := i
+			var b strings.Builder
+			for i++; ; i++ {
+				if i >= len(source) {
+					return nil, fmt.Errorf("unterminated string at offset %d", start)
+				}
+				if source[i] == c {
+					// A doubled quote stands for itself.
+					if i+1 < len(source) && source[i+1] == c {
+						b.WriteByte(c)
+						i++
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(source[i])
+			}
+			tokens = append(tokens, exprToken{tokenString, b.String(), start})
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(source) && (source[i] == '_' || source[i] >= 'a' && source[i] <= 'z' || source[i] >= 'A' && source[i] <= 'Z' || source[i] >= '0' && source[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, exprToken{tokenIdent, strings.ToLower(source[start:i]), start})
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<>", "<=", ">=", "&&", "||", "+", "-", "*", "/", "%", "<", ">", "=", "!", "(", ")", ","} {
+				if strings.HasPrefix(source[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+			}
+			tokens = append(tokens, exprToken{tokenOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, exprToken{kind: tokenEOF, text: "end of expression", pos: len(source)}), nil
+}
+
+// is reports whether the token is the operator or punctuation op.
+func (t exprToken) is(op string) bool {
+	return t.kind == tokenOp && t.text == op
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek() exprToken { return p.tokens[p.pos] }
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is one of the operators or keywords, returning its
+// canonical spelling.
+func (p *exprParser) accept(spellings map[string]string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != tokenOp && tok.kind != tokenIdent {
+		return "", false
+	}
+	canonical, ok := spellings[tok.text]
+	if ok {
+		p.next()
+	}
+	return canonical, ok
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	return p.parseBinary(p.parseAnd, map[string]string{"or": "or", "||": "or"})
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.parseBinary(p.parseNot, map[string]string{"and": "and", "&&": "and"})
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if _, ok := p.accept(map[string]string{"not": "not", "!": "not"}); ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &exprUnary{op: "not", operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept(map[string]string{"=": "=", "==": "=", "!=": "!=", "<>": "!=", "<": "<", "<=": "<=", ">": ">", ">=": ">="})
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	return &exprBinary{op: op, left: left, right: right}, nil
+}
+
+func (p *exprParser) parseAdditive() (exprNode, error) {
+	return p.parseBinary(p.parseMultiplicative, map[string]string{"+": "+", "-": "-"})
+}
+
+func (p *exprParser) parseMultiplicative() (exprNode, error) {
+	return p.parseBinary(p.parseUnary, map[string]string{"*": "*", "/": "/", "%": "%"})
+}
+
+// parseBinary parses a left-associative chain of operand (op operand)*.
+func (p *exprParser) parseBinary(operand func() (exprNode, error), ops map[string]string) (exprNode, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(ops)
+		if !ok {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprBinary{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if _, ok := p.accept(map[string]string{"-": "-"}); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprUnary{op: "-", operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenNumber:
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at offset %d", tok.text, tok.pos)
+		}
+		return &exprLiteral{value: v, typ: exprNumber}, nil
+	case tokenString:
+		return &exprLiteral{value: tok.text, typ: exprString}, nil
+	case tokenIdent:
+		switch tok.text {
+		case "true", "false":
+			return &exprLiteral{value: tok.text == "true", typ: exprBool}, nil
+		case "null":
+			return &exprLiteral{typ: exprNull}, nil
+		}
+		if !p.peek().is("(") {
+			return &exprIdent{name: tok.text}, nil
+		}
+		p.next()
+		call := &exprCall{name: tok.text}
+		if p.peek().is(")") {
+			p.next()
+			return call, nil
+		}
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			sep := p.next()
+			if sep.is(")") {
+				return call, nil
+			}
+			if !sep.is(",") {
+				return nil, fmt.Errorf("expected , or ) at offset %d", sep.pos)
+			}
+		}
+	case tokenOp:
+		if tok.text == "(" {
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if closing := p.next(); !closing.is(")") {
+				return nil, fmt.Errorf("expected ) at offset %d", closing.pos)
+			}
+			return node, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at offset %d", strconv.Quote(tok.text), tok.pos)
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+// computedEnv is a recorder row's columns as compileComputedTable sees them.
+var computedEnv = map[string]exprColumn{
+	"numeric_state": {index: 0, typ: exprNumber},
+	"state":         {index: 1, typ: exprString},
+	"last_updated":  {index: 2, typ: exprTime},
+	"count":         {index: 3, typ: exprNumber},
+}
+
+func evalExpr(t *testing.T, source string, row []any) (any, exprType) {
+	t.Helper()
+	node, err := parseExpr(source)
+	if err != nil {
+		t.Fatalf("parse %q: %v", source, err)
+	}
+	eval, typ, err := node.compile(computedEnv)
+	if err != nil {
+		t.Fatalf("compile %q: %v", source, err)
+	}
+	return eval(row), typ
+}
+
+func TestComputedExpressions(t *testing.T) {
+	useClock(t, "", "Europe/Berlin")
+	// 2024-07-01 23:30 UTC is 01:30 on a Tuesday, 2 July, in Berlin.
+	at := time.Date(2024, 7, 1, 23, 30, 0, 0, time.UTC)
+	row := []any{sql.NullFloat64{Float64: 1500, Valid: true}, "on", at, int64(4)}
+	nullRow := []any{sql.NullFloat64{}, nil, sql.NullTime{}, int64(0)}
+
+	tests := []struct {
+		name   string
+		source string
+		row    []any
+		want   any
+		typ    exprType
+	}{
+		// Precedence, loosest first: or, and, not, comparisons, + -, * / %, unary -.
+		{"multiplication before addition", "1 + 2 * 3", row, 7.0, exprNumber},
+		{"parentheses", "(1 + 2) * 3", row, 9.0, exprNumber},
+		{"left associative subtraction", "10 - 4 - 3", row, 3.0, exprNumber},
+		{"left associative division", "12 / 3 / 2", row, 2.0, exprNumber},
+		{"unary minus binds tightest", "-2 * 3 + 1", row, -5.0, exprNumber},
+		{"modulo with multiplication", "7 % 4 * 2", row, 6.0, exprNumber},
+		{"arithmetic before comparison", "numeric_state / 1000 > 1 + 0.4", row, true, exprBool},
+		{"and before or", "true or false and false", row, true, exprBool},
+		{"not before and", "not false and false", row, false, exprBool},
+		{"comparison before not", "not 1 > 2", row, true, exprBool},
+		{"alternative spellings", "1 == 1 && 2 <> 3 || !true", row, true, exprBool},
+		{"columns", "numeric_state * 0.31 / 1000", row, 0.465, exprNumber},
+		{"integer column", "count + 1", row, 5.0, exprNumber},
+		{"string comparison", "state = 'on'", row, true, exprBool},
+		{"doubled quote", "concat('it''s ', state)", row, "it's on", exprString},
+
+		// NULL propagates through arithmetic, comparisons, and functions.
+		{"null column in arithmetic", "numeric_state * 2", nullRow, nil, exprNumber},
+		{"null literal in arithmetic", "1 + null", row, nil, exprNumber},
+		{"null negation", "-numeric_state", nullRow, nil, exprNumber},
+		{"null comparison", "numeric_state > 0", nullRow, nil, exprBool},
+		{"null equality", "state = null", row, nil, exprBool},
+		{"not null", "not (numeric_state > 0)", nullRow, nil, exprBool},
+		{"null function argument", "abs(numeric_state)", nullRow, nil, exprNumber},
+		{"null concat", "concat('x', state)", nullRow, nil, exprString},
+		{"coalesce", "coalesce(numeric_state, count, 7)", nullRow, 0.0, exprNumber},
+		{"false and null", "false and numeric_state > 0", nullRow, false, exprBool},
+		{"true and null", "true and numeric_state > 0", nullRow, nil, exprBool},
+		{"true or null", "numeric_state > 0 or true", nullRow, true, exprBool},
+		{"false or null", "numeric_state > 0 or false", nullRow, nil, exprBool},
+		{"null if condition", "if(numeric_state > 0, 'yes', 'no')", nullRow, "no", exprString},
+
+		// Dividing by zero yields NULL, as in MySQL.
+		{"division by zero", "numeric_state / 0", row, nil, exprNumber},
+		{"modulo by zero", "numeric_state % 0", row, nil, exprNumber},
+		{"division by zero column", "1 / count", nullRow, nil, exprNumber},
+		{"modulo", "numeric_state % 7", row, 2.0, exprNumber},
+
+		// Time parts are read in the --time-zone zone.
+		{"hour", "hour(last_updated)", row, 1.0, exprNumber},
+		{"minute", "minute(last_updated)", row, 30.0, exprNumber},
+		{"weekday", "weekday(last_updated)", row, 2.0, exprNumber},
+		{"day", "day(last_updated)", row, 2.0, exprNumber},
+		{"month", "month(last_updated)", row, 7.0, exprNumber},
+		{"year", "year(last_updated)", row, 2024.0, exprNumber},
+		{"time part of null", "hour(last_updated)", nullRow, nil, exprNumber},
+		{"time in concat", "concat(last_updated)", row, "2024-07-02 01:30:00", exprString},
+
+		{"round digits", "round(numeric_state / 7, 2)", row, 214.29, exprNumber},
+		{"number", "number(' 12.5 ')", row, 12.5, exprNumber},
+		{"number of text", "number(state)", row, nil, exprNumber},
+		{"min", "min(3, count, 9)", row, 3.0, exprNumber},
+		{"case-insensitive names", "UPPER(State)", row, "ON", exprString},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, typ := evalExpr(t, tt.source, tt.row)
+			if got != tt.want {
+				t.Errorf("%s = %#v, want %#v", tt.source, got, tt.want)
+			}
+			if typ != tt.typ {
+				t.Errorf("%s has type %s, want %s", tt.source, typ, tt.typ)
+			}
+		})
+	}
+}
+
+func TestComputedExpressionErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"number plus string", "numeric_state + state", "+ needs numbers, not number and string"},
+		{"negated string", "-state", "- needs a number, not a string"},
+		{"not of a number", "not count", "not needs a boolean, not a number"},
+		{"and of numbers", "count and true", "and needs booleans, not number and boolean"},
+		{"string compared with number", "state > 1", "cannot compare string with number"},
+		{"time compared with string", "last_updated = 'now'", "cannot compare time with string"},
+		{"ordered booleans", "(1 > 2) < true", "< cannot order booleans"},
+		{"time part of a number", "hour(numeric_state)", "hour: argument 1 must be a time, not a number"},
+		{"wrong argument count", "abs(1, 2)", "abs takes 1 arguments, not 2"},
+		{"if mixing types", "if(true, 1, 'one')", "if: mixes number and string values"},
+		{"if condition", "if(1, 2, 3)", "if: condition must be a boolean, not a number"},
+		{"unknown column", "watts * 2", "unknown column watts"},
+		{"unknown function", "sqrt(4)", "unknown function sqrt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := parseExpr(tt.source)
+			if err != nil {
+				t.Fatalf("parse %q: %v", tt.source, err)
+			}
+			_, _, err = node.compile(computedEnv)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("compile %q: error %v, want %q", tt.source, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"1 +", `unexpected "end of expression" at offset 3`},
+		{"(1 + 2", "expected ) at offset 6"},
+		{"'open", "unterminated string at offset 0"},
+		{"1 2", `unexpected "2" at offset 2`},
+		{"abs(1 2)", "expected , or ) at offset 6"},
+		{"1 ? 2", `unexpected '?' at offset 2`},
+		{"1 < 2 < 3", `unexpected "<" at offset 6`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			_, err := parseExpr(tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseExpr(%q): error %v, want %q", tt.source, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseComputedColumn(t *testing.T) {
+	column, err := parseComputedColumn("Is_Night = hour(last_updated) < 6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if column.name != "is_night" {
+		t.Errorf("name = %q, want is_night", column.name)
+	}
+	for _, definition := range []string{"cost", "cost == 1", "1cost = 2", "cost = 1 +"} {
+		if _, err := parseComputedColumn(definition); err == nil {
+			t.Errorf("parseComputedColumn(%q) succeeded", definition)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// configPath points at the optional JSON configuration file shared by all commands.
+var configPath string
+
+// appConfig holds the loaded configuration; it is empty when --config is not set.
+var appConfig = &fileConfig{}
+
+// fileConfig is the JSON document accepted by --config.
+type fileConfig struct {
+	// Indexes maps destination tables to the query patterns their secondary indexes should serve,
+	// e.g. {"energy_points": ["entity-time", "by-day"]}.
+	Indexes map[string][]string `json:"indexes"`
+	// AnomalyThresholds sets fixed bounds per entity_id for `energy anomalies`, e.g.
+	// {"sensor.fridge_power": {"max": 150}}.
+	AnomalyThresholds map[string]anomalyThreshold `json:"anomaly_thresholds"`
+	// Alerts are rules evaluated against every exported row; see alerts.go.
+	Alerts []*alertRule `json:"alerts"`
+	// HomeAssistant is the instance alert rules with notify call back into.
+	HomeAssistant *homeAssistantConfig `json:"home_assistant"`
+	// Owners lists the entities (or globs) of a person for --only-entities-owned-by, overriding
+	// the registry, e.g. {"person.alice": ["device_tracker.alice_phone", "sensor.alice_phone_*"]}.
+	Owners map[string][]string `json:"owners"`
+	// Anonymize holds named profiles for gps --anonymize; see anonymize.go.
+	Anonymize map[string]*anonymizeProfile `json:"anonymize"`
+	// Profiles are named connections for --dest and --source; see profiles.go.
+	Profiles map[string]*connectionProfile `json:"profiles"`
+	// Routes send states to destination tables in one pass for the route command; see route.go.
+	Routes []*routeRule `json:"routes"`
+	// Jobs are routed exports to separate destinations sharing one scan; see run.go.
+	Jobs []*runJob `json:"jobs"`
+	// ComputedColumns adds expression columns to destination tables; see computed.go.
+	ComputedColumns map[string][]string `json:"computed_columns"`
+	// Aggregations overrides how aggregated entities (entity_id or glob) combine a bucket's
+	// samples, e.g. {"sensor.*_energy": "max"}; see aggregate.go.
+	Aggregations map[string]aggregation `json:"aggregations"`
+	// Tariff is the time-of-use tariff of --tariff-split; see tariff.go.
+	Tariff *tariffConfig `json:"tariff"`
+	// SMTP is the mail server of energy report --email; see mail.go.
+	SMTP *smtpConfig `json:"smtp"`
+
+	computed map[string][]*computedColumn
+}
+
+// homeAssistantConfig addresses the Home Assistant REST API.
+type homeAssistantConfig struct {
+	URL string `json:"url"`
+	// Token may be a secret reference; see secrets.go.
+	Token string `json:"token"`
+}
+
+// anomalyThreshold bounds an entity's numeric_state; a nil bound is not checked.
+type anomalyThreshold struct {
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "Path to a JSON configuration file")
+}
+
+// loadConfig reads and validates the configuration file at path.
+func loadConfig(path string) (*fileConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	cfg := &fileConfig{}
+	if err := json.Unmarshal(raw, cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	if cfg.HomeAssistant == nil {
+		cfg.HomeAssistant = supervisorHomeAssistant()
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *fileConfig) validate() error {
+	for table, patterns := range c.Indexes {
+		for _, pattern := range patterns {
+			if _, ok := indexPatterns[pattern]; !ok {
+				return fmt.Errorf("indexes.%s: unknown query pattern %q", table, pattern)
+			}
+		}
+	}
+	for entityID, t := range c.AnomalyThresholds {
+		if t.Min == nil && t.Max == nil {
+			return fmt.Errorf("anomaly_thresholds.%s: set min and/or max", entityID)
+		}
+		if t.Min != nil && t.Max != nil && *t.Min > *t.Max {
+			return fmt.Errorf("anomaly_thresholds.%s: min is greater than max", entityID)
+		}
+	}
+	// The alert state file keys pending conditions by rule name.
+	alertNames := make(map[string]bool, len(c.Alerts))
+	for i, rule := range c.Alerts {
+		if err := rule.validate(c.HomeAssistant != nil); err != nil {
+			return fmt.Errorf("alerts[%d]: %w", i, err)
+		}
+		if alertNames[rule.Name] {
+			return fmt.Errorf("alerts[%d]: duplicate name %q", i, rule.Name)
+		}
+		alertNames[rule.Name] = true
+	}
+	for name, profile := range c.Anonymize {
+		if err := profile.validate(); err != nil {
+			return fmt.Errorf("anonymize.%s: %w", name, err)
+		}
+	}
+	if err := c.validateProfiles(); err != nil {
+		return err
+	}
+	for i, rule := range c.Routes {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("routes[%d]: %w", i, err)
+		}
+	}
+	if err := c.validateJobs(); err != nil {
+		return err
+	}
+	if err := c.validateComputedColumns(); err != nil {
+		return err
+	}
+	if err := c.validateAggregations(); err != nil {
+		return err
+	}
+	if c.Tariff != nil {
+		if err := c.Tariff.validate(); err != nil {
+			return err
+		}
+	}
+	if c.SMTP != nil {
+		if err := c.SMTP.validate(); err != nil {
+			return err
+		}
+	}
+	if ha := c.HomeAssistant; ha != nil && (ha.URL == "" || ha.Token == "") {
+		return fmt.Errorf("home_assistant: url and token are required")
+	}
+	if ha := c.HomeAssistant; ha != nil {
+		if err := validateSecretRef(ha.Token); err != nil {
+			return fmt.Errorf("home_assistant.token: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// assumeYes is the --yes flag: confirm destructive operations without asking.
+var assumeYes bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&assumeYes, "yes", false, "Confirm destructive operations (dropping indexes, primary keys, or columns, deleting rows, exports over --row-budget) without asking, e.g. in cron jobs")
+}
+
+// stdinReader is shared by every prompt, so input typed ahead isn't lost to a discarded buffer.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// confirm asks on the terminal whether to go ahead with what action describes, e.g. "drop the legacy
+// attributes column of energy_points". It returns nil when the answer is yes or --yes is set, and an
+// error otherwise, including when there is no terminal to ask on.
+func confirm(action string) error {
+	if assumeYes {
+		return nil
+	}
+	if !isTerminal(os.Stdin) {
+		return fmt.Errorf("%s: confirmation needed; pass --yes to confirm without a terminal", action)
+	}
+	fmt.Fprintf(os.Stderr, "%s. Continue? [y/N] ", action)
+	answer, err := stdinReader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("read confirmation: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return fmt.Errorf("%s: cancelled", action)
+}
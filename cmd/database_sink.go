@@ -0,0 +1,346 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+func init() {
+	RegisterSink("sql", openDatabaseSink)
+}
+
+// databaseDrivers maps the dialects the sql sink writes to onto their database/sql driver.
+var databaseDrivers = map[string]string{
+	"postgres": "postgres",
+	"sqlite":   "sqlite",
+}
+
+// databaseSink writes to a Postgres or SQLite destination through database/sql, with the upserts
+// of the selected --dialect. It creates tables, indexes, and missing columns, and reads
+// watermarks back, but runs none of the MySQL-specific maintenance (migrations, index plans,
+// leases, verification) or the rollups that need the mysql sink.
+type databaseSink struct {
+	db *sql.DB
+
+	mu         sync.Mutex
+	statements map[string]upsertStatement
+}
+
+func openDatabaseSink(ctx context.Context, target string) (Sink, error) {
+	driverName, ok := databaseDrivers[destDialect.name]
+	if !ok {
+		return nil, fmt.Errorf("the sql sink writes to the postgres and sqlite dialects; use the mysql sink for %s", destDialect.name)
+	}
+	if target == "" {
+		return nil, errors.New("the sql sink needs --dsn: a postgres:// URL or a SQLite file path")
+	}
+	db, err := sql.Open(driverName, target)
+	if err != nil {
+		return nil, fmt.Errorf("open %s destination: %w", destDialect.name, err)
+	}
+	if destDialect.name == "sqlite" {
+		// SQLite has a single writer; batches written in the background would fail with
+		// SQLITE_BUSY on a second connection.
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect to %s destination: %w", destDialect.name, err)
+	}
+	return &databaseSink{db: db, statements: make(map[string]upsertStatement)}, nil
+}
+
+func (s *databaseSink) Close() error { return s.db.Close() }
+
+// EnsureSchema creates the table and its indexes, and adds columns missing from tables created by
+// older releases. Added columns are nullable: neither Postgres nor SQLite can add a NOT NULL
+// column to a populated table without a default.
+func (s *databaseSink) EnsureSchema(ctx context.Context, table *tableSpec) error {
+	table, err := withComputedColumns(table)
+	if err != nil {
+		return err
+	}
+	if table.entityTable != nil {
+		if err := s.EnsureSchema(ctx, table.entityTable); err != nil {
+			return err
+		}
+	}
+
+	create, err := databaseCreateTable(destDialect, table)
+	if err != nil {
+		return err
+	}
+	if _, err := execStatement(ctx, s.db, create); err != nil {
+		return fmt.Errorf("create %s table: %w", table.name, err)
+	}
+	if err := s.addMissingColumns(ctx, table); err != nil {
+		return fmt.Errorf("migrate %s columns: %w", table.name, err)
+	}
+	for _, index := range table.indexes {
+		if index.spatial {
+			continue
+		}
+		stmt := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", index.name, table.name, strings.Join(index.columns, ", "))
+		if _, err := execStatement(ctx, s.db, stmt); err != nil {
+			return fmt.Errorf("ensure %s indexes: %w", table.name, err)
+		}
+	}
+	return nil
+}
+
+func (s *databaseSink) addMissingColumns(ctx context.Context, table *tableSpec) error {
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(qctx, fmt.Sprintf("SELECT * FROM %s LIMIT 0", table.name))
+	if err != nil {
+		return explainTimeout(qctx, err)
+	}
+	existing, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range table.columns {
+		if containsString(existing, c.name) {
+			continue
+		}
+		c = resolveColumn(c)
+		c.sqlType = strings.Replace(c.sqlType, "NOT NULL", "NULL", 1)
+		sqlType, err := databaseColumnType(destDialect, c, false)
+		if err != nil {
+			return err
+		}
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table.name, c.name, sqlType)
+		if _, err := execStatement(ctx, s.db, stmt); err != nil {
+			return fmt.Errorf("add column %s: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+// databaseCreateTable renders the CREATE TABLE statement of the table in d, a dialect the sql sink
+// writes to.
+func databaseCreateTable(d *sqlDialect, table *tableSpec) (string, error) {
+	// SQLite only generates keys for a column declared INTEGER PRIMARY KEY, inline.
+	inlineKey := ""
+	if d.name == "sqlite" && len(table.primaryKey) == 1 {
+		inlineKey = table.primaryKey[0]
+	}
+
+	var (
+		lines     []string
+		keyInline bool
+	)
+	for _, c := range table.columns {
+		c = resolveColumn(c)
+		sqlType, err := databaseColumnType(d, c, c.name == inlineKey)
+		if err != nil {
+			return "", fmt.Errorf("create %s table: %w", table.name, err)
+		}
+		keyInline = keyInline || strings.HasSuffix(sqlType, "PRIMARY KEY AUTOINCREMENT")
+		lines = append(lines, c.name+" "+sqlType)
+	}
+	if len(table.primaryKey) > 0 && !keyInline {
+		lines = append(lines, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(table.primaryKey, ", ")))
+	}
+	for _, key := range table.uniqueKeys {
+		lines = append(lines, fmt.Sprintf("CONSTRAINT %s UNIQUE (%s)", uniqueKeyName(table.name, key), strings.Join(key, ", ")))
+	}
+	if d.foreignKeys {
+		for _, fk := range table.foreignKeys {
+			lines = append(lines, fmt.Sprintf("CONSTRAINT fk_%s_%s FOREIGN KEY (%s) REFERENCES %s(%s)", table.name, fk.column, fk.column, fk.refTable, fk.refColumn))
+		}
+	}
+	return fmt.Sprintf("\nCREATE TABLE IF NOT EXISTS %s (\n    %s\n)\n", table.name, strings.Join(lines, ",\n    ")), nil
+}
+
+// mysqlTypeName matches the type name leading a MySQL column definition, with its length or
+// precision.
+var mysqlTypeName = regexp.MustCompile(`^([A-Z]+)(\([0-9, ]+\))?`)
+
+// databaseColumnType translates a column's MySQL definition into d. With inlineKey, a generated
+// SQLite column becomes the table's INTEGER PRIMARY KEY.
+func databaseColumnType(d *sqlDialect, c columnSpec, inlineKey bool) (string, error) {
+	m := mysqlTypeName.FindStringSubmatch(c.sqlType)
+	if m == nil {
+		return "", fmt.Errorf("column %s: unsupported type %q", c.name, c.sqlType)
+	}
+	rest := strings.TrimSpace(c.sqlType[len(m[0]):])
+
+	if strings.Contains(rest, "AUTO_INCREMENT") {
+		switch {
+		case d.name == "postgres":
+			return "BIGINT GENERATED BY DEFAULT AS IDENTITY", nil
+		case inlineKey:
+			return "INTEGER PRIMARY KEY AUTOINCREMENT", nil
+		}
+		rest = strings.TrimSpace(strings.Replace(rest, "AUTO_INCREMENT", "", 1))
+	}
+
+	name := m[1] + m[2]
+	switch m[1] {
+	case "DOUBLE":
+		name = "DOUBLE PRECISION"
+		if d.name == "sqlite" {
+			name = "REAL"
+		}
+	case "DATETIME":
+		// Times are written in UTC (see databaseValue), so the zone-less type holds them.
+		name = "TIMESTAMP"
+		if d.name == "sqlite" {
+			name = "DATETIME"
+		}
+	case "MEDIUMTEXT":
+		name = "TEXT"
+	case "POINT":
+		return "", fmt.Errorf("column %s: the %s dialect has no spatial types", c.name, d.name)
+	}
+	if rest == "" {
+		return name, nil
+	}
+	return name + " " + rest, nil
+}
+
+func (s *databaseSink) WriteBatch(ctx context.Context, table *tableSpec, rows [][]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	columns := table.writeColumns()
+	key := table.name + "(" + strings.Join(columns, ",") + ")"
+	s.mu.Lock()
+	stmt, ok := s.statements[key]
+	if !ok {
+		stmt = destDialect.newWriteStatement(table)
+		s.statements[key] = stmt
+	}
+	s.mu.Unlock()
+
+	var query strings.Builder
+	query.WriteString(stmt.prefix)
+	args := make([]any, 0, len(rows)*len(columns))
+	for i, row := range rows {
+		if i > 0 {
+			query.WriteString(",")
+		}
+		query.WriteString(stmt.placeholder)
+		for _, v := range row {
+			args = append(args, databaseValue(v))
+		}
+	}
+	query.WriteByte('\n')
+	query.WriteString(stmt.suffix)
+
+	if _, err := execStatement(ctx, s.db, destDialect.rebind(query.String()), args...); err != nil {
+		return fmt.Errorf("write %s rows: %w", table.name, err)
+	}
+	return nil
+}
+
+// databaseValue converts a written value for the destination: times go in as UTC, which the
+// zone-less TIMESTAMP columns store and SQLite's text times sort by.
+func databaseValue(v any) any {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC()
+	case sql.NullTime:
+		if v.Valid {
+			v.Time = v.Time.UTC()
+		}
+		return v
+	}
+	return v
+}
+
+// LoadWatermarks reads the newest time per entity.
+func (s *databaseSink) LoadWatermarks(ctx context.Context, table *tableSpec) (map[string]time.Time, error) {
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(qctx, watermarksQuery(table))
+	if err != nil {
+		return nil, explainTimeout(qctx, err)
+	}
+	defer rows.Close()
+
+	watermarks := make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			entityID string
+			ts       databaseTime
+		)
+		if err := rows.Scan(&entityID, &ts); err != nil {
+			return nil, err
+		}
+		if ts.Valid {
+			watermarks[entityID] = ts.Time
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return watermarks, nil
+}
+
+// LoadWatermarkTies reads the highest idColumn value among each entity's rows at its watermark.
+func (s *databaseSink) LoadWatermarkTies(ctx context.Context, table *tableSpec) (map[string]int64, error) {
+	return queryWatermarkTies(ctx, s.db, table)
+}
+
+// LoadLatest reads the newest row per entity; ties on the time column resolve to the highest key.
+func (s *databaseSink) LoadLatest(ctx context.Context, table *tableSpec, columns []string, fn func(scan func(dest ...any) error) error) error {
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(qctx, latestRowsQuery(table, columns))
+	if err != nil {
+		return explainTimeout(qctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := fn(rows.Scan); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// databaseTimeLayouts are the text forms SQLite returns times in when a query loses the column's
+// declared type, as aggregates such as MAX do.
+var databaseTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// databaseTime scans a time column that may come back as text.
+type databaseTime struct {
+	sql.NullTime
+}
+
+func (t *databaseTime) Scan(value any) error {
+	var text string
+	switch v := value.(type) {
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		return t.NullTime.Scan(value)
+	}
+	for _, layout := range databaseTimeLayouts {
+		if parsed, err := time.ParseInLocation(layout, text, time.UTC); err == nil {
+			t.Time, t.Valid = parsed, true
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot parse %q as a time", text)
+}
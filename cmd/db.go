@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"ha-tools/haerrors"
+)
+
+// sqliteOptions are URI query parameters applied when opening the recorder. The default opens it
+// read-only so ha-tools never takes write locks while Home Assistant is running.
+var sqliteOptions = "mode=ro&_pragma=busy_timeout(5000)"
+
+// mysqlMaxOpen, mysqlMaxIdle, and mysqlConnLifetime size the destination's connection pool. The
+// defaults are database/sql's: unlimited open connections, two idle ones, kept forever.
+var (
+	mysqlMaxOpen      int
+	mysqlMaxIdle      = 2
+	mysqlConnLifetime time.Duration
+)
+
+// mysqlCompress is the --mysql-compress flag enabling zlib compression of the destination protocol.
+var mysqlCompress bool
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&sqliteOptions, "sqlite-options", sqliteOptions, "SQLite URI parameters used to open the recorder (e.g. mode=ro&immutable=1)")
+	rootCmd.PersistentFlags().IntVar(&mysqlMaxOpen, "mysql-max-open", mysqlMaxOpen, "Most connections open to the destination at once (0 = no limit)")
+	rootCmd.PersistentFlags().IntVar(&mysqlMaxIdle, "mysql-max-idle", mysqlMaxIdle, "Idle destination connections kept for reuse (0 = close each after use)")
+	rootCmd.PersistentFlags().DurationVar(&mysqlConnLifetime, "mysql-conn-lifetime", 0, "Close destination connections older than this so long-running commands do not hold stale ones (e.g. 5m; 0 = no limit)")
+	rootCmd.PersistentFlags().BoolVar(&mysqlCompress, "mysql-compress", false, "Compress traffic to the destination (zlib), trading CPU for bandwidth on slow uplinks; the server must support protocol compression")
+}
+
+func validatePoolFlags() error {
+	if mysqlMaxOpen < 0 {
+		return fmt.Errorf("--mysql-max-open must not be negative, got %d", mysqlMaxOpen)
+	}
+	if mysqlMaxIdle < 0 {
+		return fmt.Errorf("--mysql-max-idle must not be negative, got %d", mysqlMaxIdle)
+	}
+	if mysqlConnLifetime < 0 {
+		return fmt.Errorf("--mysql-conn-lifetime must not be negative, got %s", mysqlConnLifetime)
+	}
+	return nil
+}
+
+// recorderPathEscaper percent-encodes the characters SQLite's URI parser would otherwise read as the
+// start of the query or fragment, or as an escape, when they appear in a plain recorder path.
+var recorderPathEscaper = strings.NewReplacer("%", "%25", "?", "%3F", "#", "%23")
+
+// recorderDSN turns a recorder path into a file: URI carrying the configured options. Plain paths
+// are escaped so '?', '#', and '%' stay part of the file name; paths that are already URIs keep their
+// own parameters, with the options appended.
+func recorderDSN(sqlitePath, options string) string {
+	options = strings.TrimPrefix(strings.TrimSpace(options), "?")
+	dsn := sqlitePath
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + recorderPathEscaper.Replace(dsn)
+	}
+	if options == "" {
+		return dsn
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + options
+	}
+	return dsn + "?" + options
+}
+
+// openRecorder opens the Home Assistant SQLite recorder database and verifies it is reachable.
+func openRecorder(ctx context.Context, sqlitePath string) (*sql.DB, error) {
+	sqliteDB, err := sql.Open(recorderDriver(), recorderDSN(sqlitePath, sqliteOptions))
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite database: %w", err)
+	}
+	sqliteDB.SetMaxOpenConns(1)
+
+	if err := sqliteDB.PingContext(ctx); err != nil {
+		sqliteDB.Close()
+		return nil, fmt.Errorf("ping sqlite database: %w", err)
+	}
+	checkCtx := ctx
+	if explainMode {
+		checkCtx = explainQuiet(ctx)
+	}
+	shims, err := adaptRecorder(checkCtx, sqliteDB, sqlitePath)
+	if err != nil {
+		sqliteDB.Close()
+		return nil, err
+	}
+	restrict := restrictRecorder
+	if explainMode {
+		restrict = emptyRecorder
+	}
+	if err := restrict(ctx, sqliteDB, shims); err != nil {
+		sqliteDB.Close()
+		return nil, err
+	}
+	return sqliteDB, nil
+}
+
+// isRecorderLocked reports whether err is the recorder driver's SQLITE_BUSY or SQLITE_LOCKED, left
+// when another process holds the database past the busy timeout.
+func isRecorderLocked(err error) bool {
+	const (
+		sqliteBusy   = 5
+		sqliteLocked = 6
+	)
+	var coded interface{ Code() int }
+	if !errors.As(err, &coded) {
+		return false
+	}
+	// Extended result codes keep the primary code in the low byte.
+	code := coded.Code() & 0xff
+	return code == sqliteBusy || code == sqliteLocked
+}
+
+// recorderFlagUsage documents the repeatable --sqlite flag of the exporters.
+const recorderFlagUsage = "Path to the Home Assistant SQLite recorder database; repeat it or use a glob to export several recorder copies, oldest first"
+
+// forEachRecorder runs an export once per recorder matched by the --sqlite values, oldest first.
+// Each run reads the watermarks the previous one wrote, so rotated copies of a recorder combine
+// into one history without re-exporting the overlap.
+func forEachRecorder(ctx context.Context, patterns []string, export func(sqlitePath string) error) error {
+	paths, err := recorderPaths(ctx, patterns)
+	if err != nil {
+		return err
+	}
+	if err := validateRecorderWriteMode(paths); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := export(path); err != nil {
+			if isRecorderLocked(err) {
+				return &haerrors.SourceLockedError{Path: path, Err: err}
+			}
+			if len(paths) > 1 {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// recorderPaths expands globs and, for several recorders, orders them by their earliest state.
+func recorderPaths(ctx context.Context, patterns []string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(pattern); err != nil {
+				return nil, fmt.Errorf("expand --sqlite %q: %w", pattern, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no recorder matches --sqlite %q", pattern)
+			}
+		}
+		for _, path := range matches {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	if len(paths) < 2 {
+		return paths, nil
+	}
+
+	earliest := make(map[string]float64, len(paths))
+	for _, path := range paths {
+		first, err := earliestRecorderState(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		earliest[path] = first
+	}
+	sort.SliceStable(paths, func(i, j int) bool { return earliest[paths[i]] < earliest[paths[j]] })
+	return paths, nil
+}
+
+// earliestRecorderState returns the oldest last_updated_ts of a recorder, or 0 when it is empty.
+func earliestRecorderState(ctx context.Context, sqlitePath string) (float64, error) {
+	db, err := openRecorder(ctx, sqlitePath)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var first sql.NullFloat64
+	if err := db.QueryRowContext(ctx, "SELECT MIN(last_updated_ts) FROM states").Scan(&first); err != nil {
+		return 0, fmt.Errorf("find earliest state: %w", err)
+	}
+	return first.Float64, nil
+}
+
+// openDestination opens the MySQL-compatible destination, applying the DSN tweaks every exporter relies on.
+func openDestination(ctx context.Context, mysqlDSN string) (*sql.DB, error) {
+	mysqlDSN = ensureParseTimeEnabled(mysqlDSN)
+	if mysqlCompress {
+		mysqlDSN = ensureCompressionEnabled(mysqlDSN)
+	}
+	mysqlDSN = applySessionFlags(mysqlDSN)
+	if err := maybeRegisterTiDBTLS(mysqlDSN); err != nil {
+		return nil, fmt.Errorf("configure mysql tls: %w", err)
+	}
+	mysqlDSN = alignSessionTimeZone(mysqlDSN)
+
+	mysqlDB, err := sql.Open("mysql", mysqlDSN)
+	if err != nil {
+		return nil, fmt.Errorf("open mysql database: %w", err)
+	}
+	mysqlDB.SetMaxOpenConns(mysqlMaxOpen)
+	mysqlDB.SetMaxIdleConns(mysqlMaxIdle)
+	mysqlDB.SetConnMaxLifetime(mysqlConnLifetime)
+
+	pingCtx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	if err := mysqlDB.PingContext(pingCtx); err != nil {
+		mysqlDB.Close()
+		return nil, fmt.Errorf("ping mysql database: %w", explainTimeout(pingCtx, err))
+	}
+	warnDestinationTimeZone(ctx, mysqlDB, mysqlDSN)
+	return mysqlDB, nil
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecorderDSNOpensUnusualPaths(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "home?assistant#v2 100%.db")
+	rw, err := sql.Open(recorderDriver(), recorderDSN(path, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rw.Exec("CREATE TABLE t (x INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	rw.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("recorder was not created at the literal path: %v", err)
+	}
+	ro, err := sql.Open(recorderDriver(), recorderDSN(path, "mode=ro"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	var n int
+	if err := ro.QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	decryptMySQLDSN string
+	decryptEntity   string
+	decryptSince    string
+	decryptOutput   string
+)
+
+// decryptCmd reads gps_points written with gps --encrypt and prints the plaintext coordinates.
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt",
+	Short: "Print gps_points rows exported with --encrypt as plaintext CSV",
+	Long:  "Reads gps_points rows whose latitude/longitude were AES-GCM encrypted by gps --encrypt, decrypts them with the key from --encryption-key-file (or $" + encryptionKeyEnv + "), and writes state_id, entity_id, state, latitude, longitude, gps_accuracy, and last_updated as CSV. The table itself is not modified.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if decryptMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+		var since time.Time
+		if decryptSince != "" {
+			var err error
+			if since, err = parseSince(decryptSince); err != nil {
+				return err
+			}
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		coordinates, err := newCoordinateCipher()
+		if err != nil {
+			return err
+		}
+
+		mysqlDB, err := openDestination(ctx, decryptMySQLDSN)
+		if err != nil {
+			return err
+		}
+		defer mysqlDB.Close()
+
+		out := cmd.OutOrStdout()
+		if decryptOutput != "" {
+			f, err := os.Create(decryptOutput)
+			if err != nil {
+				return fmt.Errorf("create output: %w", err)
+			}
+			defer f.Close()
+			out = f
+		}
+
+		n, err := decryptGPSPoints(ctx, mysqlDB, coordinates, decryptEntity, since, out)
+		if err != nil {
+			return err
+		}
+		if decryptOutput != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), "decrypted %d row(s) into %s\n", n, decryptOutput)
+		}
+		return nil
+	},
+}
+
+func init() {
+	decryptCmd.Flags().StringVar(&decryptMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	decryptCmd.Flags().StringVar(&decryptEntity, "entity", "", "Only decrypt rows of this entity_id")
+	decryptCmd.Flags().StringVar(&decryptSince, "since", "", "Only decrypt rows updated at or after this time (RFC 3339 or YYYY-MM-DD)")
+	decryptCmd.Flags().StringVar(&decryptOutput, "output", "", "Write the CSV to this file instead of stdout")
+	_ = decryptCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(decryptCmd)
+}
+
+// parseSince accepts an RFC 3339 timestamp or a date, read in the export zone.
+func parseSince(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, raw, exportZone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --since %q (use RFC 3339 or YYYY-MM-DD)", raw)
+	}
+	return t, nil
+}
+
+// decryptGPSPoints streams the matching rows as CSV and returns how many were written.
+func decryptGPSPoints(ctx context.Context, db *sql.DB, coordinates *coordinateCipher, entityID string, since time.Time, w io.Writer) (int, error) {
+	query := "SELECT state_id, entity_id, state, latitude, longitude, gps_accuracy, last_updated FROM " + gpsPointsTable.name + " WHERE 1 = 1"
+	var args []any
+	if entityID != "" {
+		query += " AND entity_id = ?"
+		args = append(args, entityID)
+	}
+	if !since.IsZero() {
+		query += " AND last_updated >= ?"
+		args = append(args, since)
+	}
+	query += " ORDER BY entity_id, last_updated, state_id"
+
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(qctx, query, args...)
+	if err != nil {
+		return 0, explainTimeout(ctx, fmt.Errorf("read %s: %w", gpsPointsTable.name, err))
+	}
+	defer rows.Close()
+
+	out := csv.NewWriter(w)
+	if err := out.Write([]string{"state_id", "entity_id", "state", "latitude", "longitude", "gps_accuracy", "last_updated"}); err != nil {
+		return 0, fmt.Errorf("write csv: %w", err)
+	}
+
+	n := 0
+	for rows.Next() {
+		var (
+			stateID              int64
+			entity, state        string
+			sealedLat, sealedLon []byte
+			accuracy             sql.NullFloat64
+			lastUpdated          sql.NullTime
+		)
+		if err := rows.Scan(&stateID, &entity, &state, &sealedLat, &sealedLon, &accuracy, &lastUpdated); err != nil {
+			return n, fmt.Errorf("scan %s row: %w", gpsPointsTable.name, err)
+		}
+		latitude, err := coordinates.Open(stateID, entity, "latitude", sealedLat)
+		if err != nil {
+			return n, err
+		}
+		longitude, err := coordinates.Open(stateID, entity, "longitude", sealedLon)
+		if err != nil {
+			return n, err
+		}
+
+		record := []string{
+			strconv.FormatInt(stateID, 10),
+			entity,
+			state,
+			strconv.FormatFloat(latitude, 'f', -1, 64),
+			strconv.FormatFloat(longitude, 'f', -1, 64),
+			"",
+			"",
+		}
+		if accuracy.Valid {
+			record[5] = strconv.FormatFloat(accuracy.Float64, 'f', -1, 64)
+		}
+		if lastUpdated.Valid {
+			record[6] = lastUpdated.Time.Format(time.RFC3339)
+		}
+		if err := out.Write(record); err != nil {
+			return n, fmt.Errorf("write csv: %w", err)
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, explainTimeout(ctx, fmt.Errorf("iterate %s rows: %w", gpsPointsTable.name, err))
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return n, fmt.Errorf("write csv: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	dedupeMySQLDSN  string
+	dedupeTable     string
+	dedupeBatchSize int
+	dedupeDryRun    bool
+)
+
+// dedupeCmd removes rows that AUTO_INCREMENT state_ids let earlier runs insert twice.
+var dedupeCmd = &cobra.Command{
+	Use:   "dedupe",
+	Short: "Remove duplicate rows from an exported table",
+	Long:  "Finds rows of a destination table sharing (entity_id, last_updated, state), keeps the one with the lowest state_id, and deletes the others in batches. Duplicates are left behind by older energy exports whose AUTO_INCREMENT state_id let re-exported rows in twice.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if dedupeMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+		if dedupeBatchSize <= 0 {
+			return errors.New("--batch-size must be positive")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		db, err := openDestination(ctx, dedupeMySQLDSN)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		return dedupeTableRows(ctx, cmd.OutOrStdout(), db, dedupeTable)
+	},
+}
+
+func init() {
+	dedupeCmd.Flags().StringVar(&dedupeMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	dedupeCmd.Flags().StringVar(&dedupeTable, "table", "energy_points", "Table to deduplicate; it needs state_id, entity_id, last_updated, and state columns")
+	dedupeCmd.Flags().IntVar(&dedupeBatchSize, "batch-size", 1000, "Rows deleted per statement")
+	dedupeCmd.Flags().BoolVar(&dedupeDryRun, "dry-run", false, "Only report how many duplicates would be removed")
+	_ = dedupeCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(dedupeCmd)
+}
+
+// dedupeKey identifies duplicate rows for the dedupe command.
+var dedupeKey = []string{"entity_id", "last_updated", "state"}
+
+// dedupeTableRows deletes every duplicate of table and reports the count.
+func dedupeTableRows(ctx context.Context, out io.Writer, db *sql.DB, table string) error {
+	ids, err := findDuplicateStateIDs(ctx, db, table, dedupeKey, "MIN")
+	if err != nil {
+		return fmt.Errorf("find duplicates in %s: %w", table, err)
+	}
+	if dedupeDryRun || len(ids) == 0 {
+		fmt.Fprintf(out, "%s: %d duplicate row(s) found\n", table, len(ids))
+		return nil
+	}
+
+	if err := confirm(fmt.Sprintf("delete %d duplicate row(s) from %s", len(ids), table)); err != nil {
+		return err
+	}
+	removed, err := deleteStateIDs(ctx, db, table, ids, dedupeBatchSize)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s: removed %d duplicate row(s)\n", table, removed)
+	return nil
+}
+
+// deleteStateIDs deletes the rows with the given state_ids, batchSize at a time.
+func deleteStateIDs(ctx context.Context, db *sql.DB, table string, ids []int64, batchSize int) (int64, error) {
+	var removed int64
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := ids[start:end]
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+		res, err := execStatement(ctx, db, "DELETE FROM "+quoteIdentifier(table)+" WHERE state_id IN ("+placeholders+")", args...)
+		if err != nil {
+			return removed, fmt.Errorf("delete duplicates from %s (%d removed so far): %w", table, removed, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return removed, fmt.Errorf("delete duplicates from %s: %w", table, err)
+		}
+		removed += n
+	}
+	return removed, nil
+}
+
+// findDuplicateStateIDs returns the state_id of every row repeating the key columns of another
+// row, sparing the row with the keep aggregate (MIN or MAX) of state_id in each group.
+func findDuplicateStateIDs(ctx context.Context, db *sql.DB, table string, key []string, keep string) ([]int64, error) {
+	joins := make([]string, len(key))
+	for i, c := range key {
+		joins[i] = fmt.Sprintf("t.%[1]s <=> d.%[1]s", c)
+	}
+	columns := strings.Join(key, ", ")
+	query := fmt.Sprintf(`
+SELECT t.state_id
+FROM %[1]s t
+JOIN (
+    SELECT %[2]s, %[3]s(state_id) AS keep_id
+    FROM %[1]s
+    GROUP BY %[2]s
+    HAVING COUNT(*) > 1
+) d ON %[4]s
+WHERE t.state_id <> d.keep_id
+ORDER BY t.state_id
+`, quoteIdentifier(table), columns, keep, strings.Join(joins, " AND "))
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(qctx, query)
+	if err != nil {
+		return nil, explainTimeout(qctx, err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
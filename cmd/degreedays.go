@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// addDegreeDayFlags registers the degree-day flags on an exporter with daily rollups.
+func addDegreeDayFlags(cmd *cobra.Command, opts *numericExportOptions) {
+	cmd.Flags().StringVar(&opts.degreeDaysEntity, "degree-days-entity", "", "Outdoor temperature sensor (or weather.* entity) to compute daily heating and cooling degree days from into degree_days")
+	cmd.Flags().Float64Var(&opts.heatingBase, "heating-base", 0, "Base temperature of heating degree days (0 = 18 °C, or 65 °F for a Fahrenheit sensor)")
+	cmd.Flags().Float64Var(&opts.coolingBase, "cooling-base", 0, "Base temperature of cooling degree days (0 = 18 °C, or 65 °F for a Fahrenheit sensor)")
+}
+
+// degreeDaysTable holds the outdoor temperature's daily time-weighted mean and the heating and
+// cooling degree days it makes. hours is how much of the day the sensor reported for, short of 24
+// on the first day, today, and days with outages.
+var degreeDaysTable = &tableSpec{
+	name: "degree_days",
+	columns: []columnSpec{
+		{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "day", sqlType: "DATE NOT NULL"},
+		{name: "unit", sqlType: "VARCHAR(64) NULL"},
+		{name: "mean_temperature", sqlType: "DOUBLE NOT NULL"},
+		{name: "min_temperature", sqlType: "DOUBLE NOT NULL"},
+		{name: "max_temperature", sqlType: "DOUBLE NOT NULL"},
+		{name: "hours", sqlType: "DOUBLE NOT NULL"},
+		{name: "heating_base", sqlType: "DOUBLE NOT NULL"},
+		{name: "cooling_base", sqlType: "DOUBLE NOT NULL"},
+		{name: "heating_degree_days", sqlType: "DOUBLE NOT NULL"},
+		{name: "cooling_degree_days", sqlType: "DOUBLE NOT NULL"},
+	},
+	primaryKey: []string{"entity_id", "day"},
+}
+
+// degreeDayBase returns the configured base, or the conventional one for the sensor's unit.
+func degreeDayBase(base float64, unit string) float64 {
+	switch {
+	case base != 0:
+		return base
+	case strings.HasSuffix(unit, "F"):
+		return 65
+	default:
+		return 18
+	}
+}
+
+// temperatureDay accumulates one local day of the outdoor temperature.
+type temperatureDay struct {
+	weighted, seconds float64
+	min, max          float64
+}
+
+// temperatureDays time-weights an entity's samples per local day: each sample holds until the
+// next, and an unavailable or non-numeric state ends it.
+type temperatureDays struct {
+	start time.Time
+	days  map[time.Time]*temperatureDay
+	order []time.Time
+	value sql.NullFloat64
+	since time.Time
+}
+
+func (d *temperatureDays) sample(t time.Time, value sql.NullFloat64) {
+	if d.value.Valid {
+		d.spend(d.since, t)
+	}
+	d.value, d.since = value, t
+}
+
+// spend credits the current value with the time from..to, split at midnight.
+func (d *temperatureDays) spend(from, to time.Time) {
+	if from.Before(d.start) {
+		from = d.start
+	}
+	for from.Before(to) {
+		day := dayStart(from)
+		end := day.AddDate(0, 0, 1)
+		if to.Before(end) {
+			end = to
+		}
+		acc := d.days[day]
+		if acc == nil {
+			acc = &temperatureDay{min: d.value.Float64, max: d.value.Float64}
+			d.days[day] = acc
+			d.order = append(d.order, day)
+		}
+		seconds := end.Sub(from).Seconds()
+		acc.weighted += d.value.Float64 * seconds
+		acc.seconds += seconds
+		acc.min = math.Min(acc.min, d.value.Float64)
+		acc.max = math.Max(acc.max, d.value.Float64)
+		from = end
+	}
+}
+
+// refreshDegreeDays recomputes degree_days from the recorder for every day since the one holding
+// the given time. The sensor's newest sample before that day seeds its temperature at midnight.
+func refreshDegreeDays(ctx context.Context, sqliteDB *sql.DB, sink Sink, opts numericExportOptions, since time.Time) error {
+	if err := sink.EnsureSchema(ctx, degreeDaysTable); err != nil {
+		return fmt.Errorf("ensure %s table: %w", degreeDaysTable.name, err)
+	}
+	start := dayStart(since)
+
+	const query = `
+SELECT s.state, s.last_updated_ts, COALESCE(sa.shared_attrs, '')
+FROM states s
+JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+LEFT JOIN state_attributes sa ON s.attributes_id = sa.attributes_id
+WHERE sm.entity_id = ? AND (s.last_updated_ts >= ? OR s.state_id = (
+    SELECT p.state_id FROM states p
+    WHERE p.metadata_id = sm.metadata_id AND p.last_updated_ts < ?
+    ORDER BY p.last_updated_ts DESC LIMIT 1
+))
+ORDER BY s.last_updated_ts
+`
+	startTS := float64(start.UnixMicro()) / 1e6
+	rows, err := sqliteDB.QueryContext(ctx, query, opts.degreeDaysEntity, startTS, startTS)
+	if err != nil {
+		return fmt.Errorf("query sqlite database for %s: %w", degreeDaysTable.name, err)
+	}
+	defer rows.Close()
+
+	weather := strings.HasPrefix(opts.degreeDaysEntity, "weather.")
+	days := &temperatureDays{start: start, days: make(map[time.Time]*temperatureDay)}
+	var unit string
+	for rows.Next() {
+		var (
+			state, attributesJSON string
+			lastUpdatedVal        sql.NullFloat64
+		)
+		if err := rows.Scan(&state, &lastUpdatedVal, &attributesJSON); err != nil {
+			return fmt.Errorf("scan sqlite row: %w", err)
+		}
+		lastUpdated, err := floatToNullTime(lastUpdatedVal)
+		if err != nil || !lastUpdated.Valid {
+			continue
+		}
+		var attrs map[string]any
+		if strings.TrimSpace(attributesJSON) != "" {
+			if err := json.Unmarshal([]byte(attributesJSON), &attrs); err != nil {
+				continue
+			}
+		}
+		value := parseNumericState(strings.TrimSpace(state))
+		if weather {
+			// Weather entities report the condition as their state and the temperature as an attribute.
+			value = sql.NullFloat64{}
+			if v, ok := pickFloat(attrs["temperature"]); ok {
+				value = sql.NullFloat64{Float64: v, Valid: true}
+			}
+			if u, ok := pickString(attrs["temperature_unit"]); ok {
+				unit = u
+			}
+		} else if u, ok := pickString(attrs["unit_of_measurement"]); ok {
+			unit = u
+		}
+		days.sample(lastUpdated.Time, value)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate sqlite rows: %w", err)
+	}
+	// The newest sample holds until now.
+	if days.value.Valid {
+		days.spend(days.since, wallClock.Now())
+	}
+
+	heatingBase, coolingBase := degreeDayBase(opts.heatingBase, unit), degreeDayBase(opts.coolingBase, unit)
+	var nullUnit sql.NullString
+	if unit != "" {
+		nullUnit = sql.NullString{String: unit, Valid: true}
+	}
+	round := func(v float64) float64 { return math.Round(v*1000) / 1000 }
+
+	const degreeDaysBatchSize = 500
+	writer := newBatchWriter(sink, degreeDaysTable, degreeDaysBatchSize)
+	for _, day := range days.order {
+		acc := days.days[day]
+		if acc.seconds == 0 {
+			continue
+		}
+		mean := acc.weighted / acc.seconds
+		hdd, cdd := math.Max(0, heatingBase-mean), math.Max(0, mean-coolingBase)
+		if err := writer.Add(ctx, opts.degreeDaysEntity, day, nullUnit, round(mean), acc.min, acc.max, round(acc.seconds/3600),
+			heatingBase, coolingBase, round(hdd), round(cdd)); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(ctx); err != nil {
+		return err
+	}
+	return finalizeTable(ctx, sink, degreeDaysTable)
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	destTimeZoneAlign = "align"
+	destTimeZoneKeep  = "keep"
+)
+
+// destTimeZone is the --mysql-time-zone flag: whether destination sessions are switched to the
+// zone the driver writes times in.
+var destTimeZone = destTimeZoneAlign
+
+// destTimeZoneNotice warns once per destination that its session zone differs from the DSN's loc.
+var destTimeZoneNotice sync.Map
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&destTimeZone, "mysql-time-zone", destTimeZone, "align (set the time_zone of destination sessions to UTC when the DSN's loc is UTC, the default, so NOW() and stored times agree) or keep (leave the server's zone and only warn when it differs)")
+}
+
+func validateDestTimeZone() error {
+	switch destTimeZone {
+	case destTimeZoneAlign, destTimeZoneKeep:
+		return nil
+	}
+	return fmt.Errorf("unknown --mysql-time-zone %q (supported: %s, %s)", destTimeZone, destTimeZoneAlign, destTimeZoneKeep)
+}
+
+// alignSessionTimeZone adds time_zone='+00:00' to the DSN under --mysql-time-zone=align when the
+// driver writes times in UTC (its loc) and the DSN does not set time_zone itself. The driver
+// renders time.Time values as DATETIME literals in loc, while NOW() and CURDATE() follow the
+// session zone, so the two must agree for SQL comparing stored times with the clock. Other locs
+// are left alone: naming their zone needs the server's time zone tables, and a fixed offset would
+// be wrong across DST changes.
+func alignSessionTimeZone(mysqlDSN string) string {
+	if destTimeZone != destTimeZoneAlign {
+		return mysqlDSN
+	}
+	cfg, err := mysql.ParseDSN(mysqlDSN)
+	if err != nil || (cfg.Loc != nil && cfg.Loc != time.UTC) {
+		return mysqlDSN
+	}
+	return ensureSessionVariable(mysqlDSN, "time_zone", "'+00:00'")
+}
+
+// sessionTimeZone returns the destination session's time_zone and its current offset from UTC in
+// seconds.
+func sessionTimeZone(ctx context.Context, db *sql.DB) (string, int, error) {
+	var zone sql.NullString
+	var offsetSeconds int
+	err := queryRowStatement(ctx, db, "SELECT @@session.time_zone, TIMESTAMPDIFF(SECOND, UTC_TIMESTAMP(), NOW())", nil, &zone, &offsetSeconds)
+	return zone.String, offsetSeconds, err
+}
+
+// zoneOffsetDiffers reports whether a session offset differs from loc's current one.
+func zoneOffsetDiffers(offsetSeconds int, loc *time.Location) bool {
+	_, locOffset := time.Now().In(loc).Zone()
+	// TIMESTAMPDIFF truncates; allow for the second ticking over between the two calls.
+	diff := offsetSeconds - locOffset
+	return diff > 60 || diff < -60
+}
+
+// warnDestinationTimeZone warns, once per destination, when the session zone of a freshly opened
+// destination differs from the DSN's loc. Times are written and read in loc, so watermarks stay
+// consistent, but NOW()-based SQL (hooks, dashboards, DATETIME defaults) is off by the difference,
+// and so are the stored times if another writer uses a different loc.
+func warnDestinationTimeZone(ctx context.Context, db *sql.DB, mysqlDSN string) {
+	cfg, err := mysql.ParseDSN(mysqlDSN)
+	if err != nil {
+		return
+	}
+	dest := cfg.Addr + "/" + cfg.DBName
+	if _, warned := destTimeZoneNotice.Load(dest); warned {
+		return
+	}
+	zone, offsetSeconds, err := sessionTimeZone(ctx, db)
+	if err != nil {
+		return
+	}
+	loc := cfg.Loc
+	if loc == nil {
+		loc = time.UTC
+	}
+	if !zoneOffsetDiffers(offsetSeconds, loc) {
+		return
+	}
+	if _, warned := destTimeZoneNotice.LoadOrStore(dest, true); warned {
+		return
+	}
+	_, locOffset := time.Now().In(loc).Zone()
+	fix := "set loc in the DSN to the server's zone (URL-encoded, e.g. loc=Europe%2FBerlin) or time_zone in the DSN to match loc"
+	if loc == time.UTC && strings.Contains(strings.ToLower(mysqlDSN), "time_zone=") {
+		fix = "remove time_zone from the DSN, or set loc to that zone"
+	} else if loc == time.UTC {
+		fix = "drop --mysql-time-zone=keep, or set loc in the DSN to the server's zone"
+	}
+	fmt.Fprintf(os.Stderr, "warning: destination session time zone %s is UTC%+.1fh, but times are written in %s (UTC%+.1fh, the DSN's loc); NOW() in SQL is off by %s against stored times; %s\n",
+		zone, float64(offsetSeconds)/3600, loc, float64(locOffset)/3600, time.Duration(offsetSeconds-locOffset)*time.Second, fix)
+}
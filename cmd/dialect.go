@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// upsertSyntax is how a dialect spells an idempotent write.
+type upsertSyntax int
+
+const (
+	// onDuplicateKey is MySQL's INSERT ... ON DUPLICATE KEY UPDATE c = VALUES(c).
+	onDuplicateKey upsertSyntax = iota
+	// onConflict is INSERT ... ON CONFLICT (key) DO UPDATE SET c = excluded.c (Postgres, SQLite).
+	onConflict
+)
+
+// sqlDialect captures what a destination allows, so schema management and upserts can stay within
+// it.
+type sqlDialect struct {
+	name string
+	// mysqlProtocol reports whether the destination speaks the MySQL wire protocol, which the mysql
+	// sink requires. Other dialects only shape generated SQL.
+	mysqlProtocol bool
+	// upsert picks the idempotent write syntax.
+	upsert upsertSyntax
+	// numberedPlaceholders binds parameters as $1, $2, ... instead of ?.
+	numberedPlaceholders bool
+	// foreignKeys reports whether FOREIGN KEY constraints may be declared.
+	foreignKeys bool
+	// blockingAlters reports whether existing tables may be migrated in place (primary key rewrites,
+	// column type changes, dropping legacy columns).
+	blockingAlters bool
+	// views reports whether CREATE OR REPLACE VIEW is available.
+	views bool
+	// insertSelectUpsert reports whether INSERT ... SELECT ... ON DUPLICATE KEY UPDATE is supported.
+	insertSelectUpsert bool
+	// lastInsertIDExpr reports whether LAST_INSERT_ID(expr) can return the id of an updated row.
+	lastInsertIDExpr bool
+	// spatial reports whether SRID-constrained geometry columns and SPATIAL indexes are available.
+	spatial bool
+	// followerRead reports whether reads can be served by follower replicas (tidb_replica_read).
+	followerRead bool
+	// schemaSuffix separates a tablet type target (e.g. "@primary") from the database name.
+	schemaSuffix string
+}
+
+var sqlDialects = map[string]*sqlDialect{
+	"mysql": {
+		name:               "mysql",
+		mysqlProtocol:      true,
+		foreignKeys:        true,
+		blockingAlters:     true,
+		views:              true,
+		insertSelectUpsert: true,
+		lastInsertIDExpr:   true,
+		spatial:            true,
+	},
+	"tidb": {
+		name:               "tidb",
+		mysqlProtocol:      true,
+		blockingAlters:     true,
+		views:              true,
+		insertSelectUpsert: true,
+		lastInsertIDExpr:   true,
+		followerRead:       true,
+	},
+	// planetscale targets Vitess: no foreign keys, schema changes go through deploy requests, and
+	// connections may address a tablet type such as db@primary.
+	"planetscale": {
+		name:          "planetscale",
+		mysqlProtocol: true,
+		schemaSuffix:  "@",
+	},
+	// postgres and sqlite describe destinations written through sinks that emit their SQL; schema
+	// migrations are MySQL-specific, so they never run there.
+	"postgres": {
+		name:                 "postgres",
+		upsert:               onConflict,
+		numberedPlaceholders: true,
+		foreignKeys:          true,
+		views:                true,
+		insertSelectUpsert:   true,
+	},
+	"sqlite": {
+		name:               "sqlite",
+		upsert:             onConflict,
+		views:              true,
+		insertSelectUpsert: true,
+	},
+}
+
+// dialectName is the --dialect flag value; destDialect is the resolved profile.
+var (
+	dialectName = "mysql"
+	destDialect = sqlDialects["mysql"]
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&dialectName, "dialect", dialectName, fmt.Sprintf("Destination SQL dialect (%s)", strings.Join(dialectNames(), ", ")))
+}
+
+func dialectNames() []string {
+	names := make([]string, 0, len(sqlDialects))
+	for name := range sqlDialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveDialect looks up a dialect profile by name.
+func resolveDialect(name string) (*sqlDialect, error) {
+	d, ok := sqlDialects[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("unknown dialect %q (supported: %s)", name, strings.Join(dialectNames(), ", "))
+	}
+	return d, nil
+}
+
+// schemaName strips tablet type targets such as "@primary" from a database name.
+func (d *sqlDialect) schemaName(database string) string {
+	if d.schemaSuffix == "" {
+		return database
+	}
+	if i := strings.Index(database, d.schemaSuffix); i > 0 {
+		return database[:i]
+	}
+	return database
+}
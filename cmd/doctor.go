@@ -0,0 +1,427 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/spf13/cobra"
+)
+
+var (
+	doctorSQLitePath string
+	doctorMySQLDSN   string
+	doctorBatchSize  int
+)
+
+// doctorRowBytes is a generous estimate of one exported row in an INSERT statement, attributes included.
+const doctorRowBytes = 1024
+
+// doctorPrivileges are the destination privileges the exporters and maintenance commands use.
+var doctorPrivileges = []string{"SELECT", "INSERT", "UPDATE", "DELETE", "CREATE", "ALTER", "INDEX"}
+
+// doctorCmd diagnoses the setups that most often make exports fail, without writing anything.
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose common recorder and destination problems and suggest fixes",
+	Long:  "Checks the recorder schema version and tables, the destination DSN (parseTime, TLS), the TLS handshake, destination privileges, time zone mismatches between the exporter, Home Assistant, and the destination, and max_allowed_packet against the batch size. Unlike check it writes nothing, and every problem comes with a suggested fix.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if doctorSQLitePath == "" && doctorMySQLDSN == "" {
+			return errors.New("at least one of --sqlite or --dsn is required")
+		}
+		if doctorBatchSize <= 0 {
+			return errors.New("--batch-size must be positive")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		var report checkReport
+		if doctorSQLitePath != "" {
+			doctorRecorder(ctx, &report, doctorSQLitePath)
+		}
+		if doctorMySQLDSN != "" {
+			doctorDestination(ctx, &report, doctorMySQLDSN)
+		}
+
+		report.print(cmd.OutOrStdout())
+		if failed := report.failures(); failed > 0 {
+			return fmt.Errorf("%d problem(s) found", failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	doctorCmd.Flags().StringVar(&doctorSQLitePath, "sqlite", "", "Path to the Home Assistant SQLite recorder database")
+	doctorCmd.Flags().StringVar(&doctorMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	doctorCmd.Flags().IntVar(&doctorBatchSize, "batch-size", 500, "Rows per INSERT to check max_allowed_packet against")
+
+	rootCmd.AddCommand(doctorCmd)
+}
+
+func doctorRecorder(ctx context.Context, report *checkReport, sqlitePath string) {
+	if _, err := os.Stat(sqlitePath); err != nil {
+		report.fail("recorder file", err)
+		report.suggest("pass the recorder database, usually home-assistant_v2.db in the Home Assistant config directory")
+		return
+	}
+	db, err := openRecorder(ctx, sqlitePath)
+	if err != nil {
+		report.fail("recorder connection", err)
+		report.suggest("make the file readable by this user; if Home Assistant holds a lock, retry or copy the database first")
+		return
+	}
+	defer db.Close()
+
+	var missing []string
+	for _, table := range recorderTables {
+		found, err := recorderHasTable(ctx, db, table)
+		if err != nil {
+			report.fail("recorder tables", err)
+			return
+		}
+		if !found {
+			missing = append(missing, table)
+		}
+	}
+	if len(missing) > 0 {
+		report.fail("recorder tables", fmt.Errorf("missing %s", strings.Join(missing, ", ")))
+		if len(missing) == 1 && missing[0] == "states_meta" {
+			report.suggest("upgrade Home Assistant to 2023.4 or later and let it migrate the recorder")
+		} else {
+			report.suggest("point --sqlite at the Home Assistant recorder (home-assistant_v2.db), not another SQLite file")
+		}
+		return
+	}
+	report.pass("recorder tables", "%s", strings.Join(recorderTables, ", "))
+
+	var version int
+	if err := db.QueryRowContext(ctx, "SELECT schema_version FROM schema_changes ORDER BY change_id DESC LIMIT 1").Scan(&version); err != nil {
+		report.fail("recorder schema version", err)
+	} else if version < minRecorderSchemaVersion {
+		report.fail("recorder schema version", fmt.Errorf("%d is older than %d", version, minRecorderSchemaVersion))
+		report.suggest("upgrade Home Assistant to 2023.4 or later and let it migrate the recorder")
+	} else if version > maxTestedRecorderSchemaVersion {
+		report.warning("recorder schema version", "%d is newer than the %d this build was tested with", version, maxTestedRecorderSchemaVersion)
+		report.suggest("upgrade ha-tools, or check that exports still look right")
+	} else if version < currentRecorderSchemaVersion {
+		report.pass("recorder schema version", "%d (read through compatibility views)", version)
+	} else {
+		report.pass("recorder schema version", "%d", version)
+	}
+
+	doctorHomeAssistantTimeZone(report, sqlitePath)
+}
+
+// doctorHomeAssistantTimeZone compares Home Assistant's configured time zone, read from
+// .storage/core.config next to the recorder, with the exporter's (--time-zone, or the system zone).
+// Recorder timestamps are UTC either way, but local-time features (standby night hours, by-day
+// summaries) follow the exporter's zone.
+func doctorHomeAssistantTimeZone(report *checkReport, sqlitePath string) {
+	path := filepath.Join(homeAssistantStorageDir(sqlitePath), "core.config")
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var coreConfig struct {
+		Data struct {
+			TimeZone string `json:"time_zone"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &coreConfig); err != nil || coreConfig.Data.TimeZone == "" {
+		return
+	}
+	haLoc, err := time.LoadLocation(coreConfig.Data.TimeZone)
+	if err != nil {
+		report.warning("home assistant time zone", "%s: %v", coreConfig.Data.TimeZone, err)
+		return
+	}
+
+	now := inExportZone(wallClock.Now())
+	_, haOffset := now.In(haLoc).Zone()
+	_, localOffset := now.Zone()
+	if haOffset != localOffset {
+		report.warning("home assistant time zone", "Home Assistant uses %s, ha-tools runs in %s (UTC%s)", haLoc, exportZone, now.Format("-07:00"))
+		report.suggest("run ha-tools with --time-zone %s so local-time reports match Home Assistant", haLoc)
+		return
+	}
+	report.pass("home assistant time zone", "%s matches the exporter", haLoc)
+}
+
+func doctorDestination(ctx context.Context, report *checkReport, mysqlDSN string) {
+	if err := maybeRegisterTiDBTLS(mysqlDSN); err != nil {
+		report.fail("destination dsn", err)
+		return
+	}
+	cfg, err := mysql.ParseDSN(mysqlDSN)
+	if err != nil {
+		report.fail("destination dsn", err)
+		report.suggest("use the form user:password@tcp(host:3306)/database?param=value")
+		return
+	}
+	if cfg.DBName == "" {
+		report.fail("destination dsn", errors.New("no database selected"))
+		report.suggest("end the DSN with /<database>, e.g. user:password@tcp(host:3306)/ha")
+		return
+	}
+	report.pass("destination dsn", "%s@%s/%s", cfg.User, cfg.Addr, cfg.DBName)
+
+	if strings.Contains(strings.ToLower(mysqlDSN), "parsetime=") && !cfg.ParseTime {
+		report.fail("destination parseTime", errors.New("parseTime=false makes DATETIME columns scan as bytes"))
+		report.suggest("remove parseTime=false from the DSN; ha-tools adds parseTime=true when it is absent")
+	} else {
+		report.pass("destination parseTime", "enabled")
+	}
+
+	tlsEnabled := cfg.TLSConfig != "" && cfg.TLSConfig != "false"
+	if !tlsEnabled && !isLocalAddr(cfg.Net, cfg.Addr) {
+		report.warning("destination tls", "not configured for remote host %s", cfg.Addr)
+		if destDialect.name == "tidb" {
+			report.suggest("add tls=tidb to the DSN; TiDB Cloud rejects unencrypted connections")
+		} else {
+			report.suggest("add tls=true (or tls=skip-verify for self-signed certificates) to the DSN")
+		}
+	}
+
+	db, err := openDestination(ctx, mysqlDSN)
+	if err != nil {
+		report.fail("destination connection", err)
+		report.suggest("%s", connectionFix(err, cfg))
+		return
+	}
+	defer db.Close()
+	report.pass("destination connection", "dialect %s", destDialect.name)
+
+	if tlsEnabled {
+		var statusName, cipher sql.NullString
+		if err := db.QueryRowContext(ctx, "SHOW SESSION STATUS LIKE 'Ssl_cipher'").Scan(&statusName, &cipher); err != nil {
+			report.fail("destination tls", err)
+		} else if cipher.String == "" {
+			report.fail("destination tls", fmt.Errorf("tls=%s is set but the session is not encrypted", cfg.TLSConfig))
+			report.suggest("use tls=true instead of tls=preferred so the connection fails rather than falling back to plain text")
+		} else {
+			report.pass("destination tls", "handshake ok, %s", cipher.String)
+		}
+	}
+
+	doctorDestinationTimeZone(ctx, report, db, cfg)
+	doctorDestinationCharset(ctx, report, db)
+	doctorDestinationPrivileges(ctx, report, db, cfg.DBName)
+	doctorMaxAllowedPacket(ctx, report, db)
+}
+
+// connectionFix suggests what to change for the usual connection errors.
+func connectionFix(err error, cfg *mysql.Config) string {
+	var mysqlErr *mysql.MySQLError
+	msg := err.Error()
+	switch {
+	case errors.As(err, &mysqlErr) && mysqlErr.Number == 1045:
+		return "check the user and password in the DSN, and that the user may connect from this host"
+	case errors.As(err, &mysqlErr) && mysqlErr.Number == 1049:
+		return fmt.Sprintf("create the database first: CREATE DATABASE `%s`", cfg.DBName)
+	case strings.Contains(msg, "x509") || strings.Contains(msg, "tls:"):
+		return "the TLS handshake failed; check the server certificate, or use tls=skip-verify for self-signed certificates"
+	case strings.Contains(msg, "connection refused"):
+		return fmt.Sprintf("nothing listens on %s; check the host and port, and that the server accepts remote connections", cfg.Addr)
+	case strings.Contains(msg, "no such host"):
+		return fmt.Sprintf("the host in %s does not resolve; check the DSN", cfg.Addr)
+	case strings.Contains(msg, "i/o timeout"):
+		return fmt.Sprintf("%s did not answer; check firewalls and the server's IP allow list", cfg.Addr)
+	}
+	return "check that the server is reachable with the DSN's credentials"
+}
+
+func isLocalAddr(network, addr string) bool {
+	if network == "unix" {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// doctorDestinationTimeZone compares the session offset with the DSN's loc: the driver renders
+// time.Time values in loc, so the two must agree for NOW()-based queries and DATETIME defaults.
+func doctorDestinationTimeZone(ctx context.Context, report *checkReport, db *sql.DB, cfg *mysql.Config) {
+	sessionTZ, offsetSeconds, err := sessionTimeZone(ctx, db)
+	if err != nil {
+		report.fail("destination time zone", err)
+		return
+	}
+
+	loc := cfg.Loc
+	if loc == nil {
+		loc = time.UTC
+	}
+	if zoneOffsetDiffers(offsetSeconds, loc) {
+		_, locOffset := time.Now().In(loc).Zone()
+		report.warning("destination time zone", "session %s is UTC%+.1fh but the DSN loc is %s (UTC%+.1fh)",
+			sessionTZ, float64(offsetSeconds)/3600, loc, float64(locOffset)/3600)
+		report.suggest("add the session's zone as loc to the DSN (URL-encoded, e.g. loc=Europe%%2FBerlin), or set time_zone='+00:00' on the server")
+		return
+	}
+	report.pass("destination time zone", "session %s matches the DSN loc %s", sessionTZ, loc)
+}
+
+// doctorDestinationCharset checks that the connection and the existing tables are utf8mb4, so
+// friendly names with emoji survive the round trip.
+func doctorDestinationCharset(ctx context.Context, report *checkReport, db *sql.DB) {
+	var connection, database string
+	if err := queryRowStatement(ctx, db, "SELECT @@character_set_connection, @@character_set_database", nil, &connection, &database); err != nil {
+		report.fail("destination charset", err)
+		return
+	}
+	if connection != destCharset {
+		report.fail("destination charset", fmt.Errorf("the connection uses %s", connection))
+		report.suggest("remove charset= from the DSN or set charset=%s", destCharset)
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, `
+SELECT TABLE_NAME, TABLE_COLLATION
+FROM INFORMATION_SCHEMA.TABLES
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_TYPE = 'BASE TABLE'
+ORDER BY TABLE_NAME
+`)
+	if err != nil {
+		report.fail("destination charset", err)
+		return
+	}
+	defer rows.Close()
+
+	var converted, other []string
+	for rows.Next() {
+		var table string
+		var collation sql.NullString
+		if err := rows.Scan(&table, &collation); err != nil {
+			report.fail("destination charset", err)
+			return
+		}
+		switch {
+		case !strings.HasPrefix(collation.String, destCharset+"_"):
+			converted = append(converted, fmt.Sprintf("%s (%s)", table, collation.String))
+		case collation.String != destCollation:
+			other = append(other, fmt.Sprintf("%s (%s)", table, collation.String))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		report.fail("destination charset", err)
+		return
+	}
+
+	switch {
+	case len(converted) > 0:
+		report.fail("destination charset", fmt.Errorf("not %s: %s", destCharset, strings.Join(converted, ", ")))
+		report.suggest("the next export converts ha-tools tables; for others run ALTER TABLE <table> CONVERT TO CHARACTER SET %s COLLATE %s", destCharset, destCollation)
+	case len(other) > 0:
+		report.warning("destination charset", "%s, but not in --collation %s: %s", destCharset, destCollation, strings.Join(other, ", "))
+		report.suggest("pass their collation as --collation to match them, or run an export with --collation=%s given explicitly to convert them", destCollation)
+	default:
+		report.pass("destination charset", "connection %s, database default %s, tables %s", connection, database, destCollation)
+	}
+}
+
+// grantScope extracts the privileges and the "db.table" scope of a SHOW GRANTS line.
+var grantScope = regexp.MustCompile("(?i)^GRANT (.+) ON (\\S+) TO ")
+
+// doctorDestinationPrivileges reads SHOW GRANTS instead of exercising the privileges, so doctor
+// stays read-only.
+func doctorDestinationPrivileges(ctx context.Context, report *checkReport, db *sql.DB, database string) {
+	rows, err := db.QueryContext(ctx, "SHOW GRANTS FOR CURRENT_USER()")
+	if err != nil {
+		report.fail("destination privileges", err)
+		return
+	}
+	defer rows.Close()
+
+	granted := map[string]bool{}
+	for rows.Next() {
+		var grant string
+		if err := rows.Scan(&grant); err != nil {
+			report.fail("destination privileges", err)
+			return
+		}
+		m := grantScope.FindStringSubmatch(grant)
+		if m == nil || !grantCoversDatabase(m[2], database) {
+			continue
+		}
+		for _, p := range strings.Split(m[1], ",") {
+			granted[strings.ToUpper(strings.TrimSpace(p))] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		report.fail("destination privileges", err)
+		return
+	}
+
+	if granted["ALL PRIVILEGES"] || granted["ALL"] {
+		report.pass("destination privileges", "ALL PRIVILEGES on %s", database)
+		return
+	}
+	var missing []string
+	for _, p := range doctorPrivileges {
+		if !granted[p] {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		report.fail("destination privileges", fmt.Errorf("missing %s on %s", strings.Join(missing, ", "), database))
+		report.suggest("GRANT %s ON `%s`.* TO <user>", strings.Join(doctorPrivileges, ", "), database)
+		return
+	}
+	report.pass("destination privileges", "%s on %s", strings.Join(doctorPrivileges, ", "), database)
+}
+
+// grantCoversDatabase reports whether a grant scope such as *.*, `ha`.*, or `ha\_db`.* applies
+// to every table of database.
+func grantCoversDatabase(scope, database string) bool {
+	if scope == "*.*" {
+		return true
+	}
+	db, table, ok := strings.Cut(scope, ".")
+	if !ok || table != "*" {
+		return false
+	}
+	db = strings.ReplaceAll(strings.Trim(db, "`"), `\_`, "_")
+	return db == database
+}
+
+// doctorMaxAllowedPacket checks that a full batch fits into one packet; larger INSERTs fail
+// with "packet too large" mid-export.
+func doctorMaxAllowedPacket(ctx context.Context, report *checkReport, db *sql.DB) {
+	var maxPacket int64
+	if err := db.QueryRowContext(ctx, "SELECT @@max_allowed_packet").Scan(&maxPacket); err != nil {
+		report.fail("destination max_allowed_packet", err)
+		return
+	}
+
+	estimate := int64(doctorBatchSize) * doctorRowBytes
+	switch {
+	case estimate > maxPacket:
+		report.fail("destination max_allowed_packet", fmt.Errorf("%d bytes is below the ~%d bytes of a %d-row batch", maxPacket, estimate, doctorBatchSize))
+		report.suggest("SET GLOBAL max_allowed_packet = %d (or max_allowed_packet=%d in the server config)", 4*estimate, 4*estimate)
+	case 2*estimate > maxPacket:
+		report.warning("destination max_allowed_packet", "%d bytes leaves little headroom over the ~%d bytes of a %d-row batch", maxPacket, estimate, doctorBatchSize)
+		report.suggest("raise max_allowed_packet to at least %d", 4*estimate)
+	default:
+		report.pass("destination max_allowed_packet", "%d bytes fits a %d-row batch (~%d bytes)", maxPacket, doctorBatchSize, estimate)
+	}
+}
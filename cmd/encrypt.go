@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// encryptionKeyEnv holds the coordinate encryption key when --encryption-key-file is not given.
+const encryptionKeyEnv = "HA_TOOLS_ENCRYPTION_KEY"
+
+// encryptionKeyFile is the --encryption-key-file flag shared by gps --encrypt and decrypt.
+var encryptionKeyFile string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&encryptionKeyFile, "encryption-key-file", "", "File holding the 32-byte AES key (base64 or hex) for encrypted coordinates; defaults to $"+encryptionKeyEnv)
+}
+
+// encryptedCoordinateType replaces DOUBLE latitude/longitude columns when coordinates are
+// encrypted: a 12-byte nonce, the 8-byte value, and the 16-byte GCM tag.
+const encryptedCoordinateType = "VARBINARY(64) NOT NULL"
+
+// loadEncryptionKey reads the AES-256 key from --encryption-key-file or the environment.
+func loadEncryptionKey() ([]byte, error) {
+	raw, source := os.Getenv(encryptionKeyEnv), "$"+encryptionKeyEnv
+	if encryptionKeyFile != "" {
+		content, err := os.ReadFile(encryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read encryption key: %w", err)
+		}
+		raw, source = string(content), encryptionKeyFile
+	}
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, fmt.Errorf("no encryption key: pass --encryption-key-file or set $%s", encryptionKeyEnv)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || len(key) != 32 {
+		key, err = hex.DecodeString(raw)
+	}
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("encryption key in %s must be 32 bytes, base64 or hex encoded (e.g. openssl rand -base64 32)", source)
+	}
+	return key, nil
+}
+
+// coordinateCipher seals latitude/longitude values with AES-256-GCM. Each value is bound to its
+// row and column through the additional data, so ciphertexts cannot be swapped between rows.
+type coordinateCipher struct {
+	aead cipher.AEAD
+}
+
+func newCoordinateCipher() (*coordinateCipher, error) {
+	key, err := loadEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	return &coordinateCipher{aead: aead}, nil
+}
+
+func coordinateAAD(stateID int64, entityID, column string) []byte {
+	return []byte(strconv.FormatInt(stateID, 10) + "\x00" + entityID + "\x00" + column)
+}
+
+// Seal encrypts v as nonce || ciphertext.
+func (c *coordinateCipher) Seal(stateID int64, entityID, column string, v float64) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	var plain [8]byte
+	binary.BigEndian.PutUint64(plain[:], math.Float64bits(v))
+	return c.aead.Seal(nonce, nonce, plain[:], coordinateAAD(stateID, entityID, column)), nil
+}
+
+// Open decrypts a value written by Seal for the same row and column.
+func (c *coordinateCipher) Open(stateID int64, entityID, column string, sealed []byte) (float64, error) {
+	if len(sealed) < c.aead.NonceSize() {
+		return 0, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, ciphertext, coordinateAAD(stateID, entityID, column))
+	if err != nil {
+		return 0, fmt.Errorf("decrypt %s of state_id %d: wrong key or tampered value", column, stateID)
+	}
+	if len(plain) != 8 {
+		return 0, fmt.Errorf("decrypt %s of state_id %d: unexpected length %d", column, stateID, len(plain))
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(plain)), nil
+}
+
+// checkCoordinateEncryption refuses to mix plaintext and encrypted coordinates in one table.
+func checkCoordinateEncryption(ctx context.Context, sink Sink, table string, encrypted bool) error {
+	sq, ok := sink.(sqlSink)
+	if !ok {
+		return nil
+	}
+	var dataType string
+	err := queryRowStatement(ctx, sq.DB(), `
+SELECT DATA_TYPE
+FROM INFORMATION_SCHEMA.COLUMNS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = 'latitude'
+`, []any{table}, &dataType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read %s.latitude type: %w", table, err)
+	}
+
+	stored := strings.EqualFold(dataType, "varbinary")
+	switch {
+	case encrypted && !stored:
+		return errSchemaDrift(table, "%s already holds plaintext coordinates; drop it or export --encrypt into another database", table)
+	case !encrypted && stored:
+		return errSchemaDrift(table, "%s holds encrypted coordinates; pass --encrypt", table)
+	}
+	return nil
+}
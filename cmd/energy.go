@@ -3,20 +3,18 @@ package cmd
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"strconv"
-	"strings"
-	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	energySQLitePath string
-	energyMySQLDSN   string
-	energyEntity     string
+	energySQLitePaths []string
+	energyMySQLDSN    string
+	energyEntity      string
+	energyMatch       string
+	energyOptions     numericExportOptions
 )
 
 // energyCmd migrates smart socket telemetry for the smart socket device.
@@ -25,7 +23,7 @@ var energyCmd = &cobra.Command{
 	Short: "Export Home Assistant energy metrics into MySQL",
 	Long:  "Reads smart socket telemetry (power, voltage, current, etc.) for the specified entity family and upserts it into a MySQL table.",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if energySQLitePath == "" {
+		if len(energySQLitePaths) == 0 {
 			return errors.New("sqlite database path is required")
 		}
 		if energyMySQLDSN == "" {
@@ -34,20 +32,39 @@ var energyCmd = &cobra.Command{
 		if energyEntity == "" {
 			return errors.New("entity is required")
 		}
+		selector, err := newEntitySelector(energyMatch, energyEntity)
+		if err != nil {
+			return err
+		}
 
 		ctx := cmd.Context()
 		if ctx == nil {
 			ctx = context.Background()
 		}
 
-		return transferEnergyData(ctx, energySQLitePath, energyMySQLDSN, energyEntity)
+		return forEachRecorder(ctx, energySQLitePaths, func(sqlitePath string) error {
+			return transferNumericData(ctx, sqlitePath, energyMySQLDSN, newEnergyFamily(selector), energyOptions)
+		})
 	},
 }
 
 func init() {
-	energyCmd.Flags().StringVar(&energySQLitePath, "sqlite", "", "Path to the Home Assistant SQLite recorder database")
+	energyCmd.Flags().StringArrayVar(&energySQLitePaths, "sqlite", nil, recorderFlagUsage)
 	energyCmd.Flags().StringVar(&energyMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
-	energyCmd.Flags().StringVar(&energyEntity, "entity", "", "Entity slug to export (match prefix for related sensors)")
+	energyCmd.Flags().StringVar(&energyEntity, "entity", "", "Entity slug to export, e.g. my_socket for sensor.my_socket_power and its other sensors (see --match)")
+	addMatchFlag(energyCmd, &energyMatch)
+	energyCmd.Flags().BoolVar(&energyOptions.normalized, "normalized", false, "Write into the normalized entities/energy_facts schema instead of the wide energy_points table")
+	energyCmd.Flags().BoolVar(&energyOptions.withDelta, "with-delta", false, "Fill prev_numeric_state and delta columns per entity during export")
+	energyCmd.Flags().BoolVar(&energyOptions.withPreviousState, "with-previous-state", false, previousStateFlagUsage)
+	energyCmd.Flags().BoolVar(&energyOptions.ohlc, "ohlc", false, ohlcFlagUsage)
+	energyCmd.Flags().StringVar(&energyOptions.idStrategy, "id-strategy", idStrategyAuto, "How state_id is assigned: auto (AUTO_INCREMENT) or hash (derived from entity_id and time, idempotent for averaged rows)")
+	addGroupByFlag(energyCmd, &energyOptions.groupBy)
+	energyCmd.Flags().Float64SliceVar(&energyOptions.histogramBuckets, "histogram-buckets", nil, "Also keep per-period histograms of power sensors in energy_histograms, with bands split at these bounds, e.g. 0,5,50,500,2000 (watts)")
+	energyCmd.Flags().DurationVar(&energyOptions.histogramInterval, "histogram-interval", histogramPeriodDefault, "Period each energy_histograms row covers, e.g. 15m, 1h or 24h")
+	addDegreeDayFlags(energyCmd, &energyOptions)
+	addOpenMeteoFlags(energyCmd, &energyOptions.openMeteo)
+	addTariffSplitFlag(energyCmd, &energyOptions.tariffSplit)
+	energyCmd.Flags().StringVar(&energyOptions.carbonZone, "carbon-zone", "", "Total each meter's daily emissions in energy_emissions_daily from the carbon_intensity of this zone (see the carbon command)")
 	_ = energyCmd.MarkFlagRequired("sqlite")
 	_ = energyCmd.MarkFlagRequired("dsn")
 	_ = energyCmd.MarkFlagRequired("entity")
@@ -55,464 +72,57 @@ func init() {
 	rootCmd.AddCommand(energyCmd)
 }
 
-func transferEnergyData(ctx context.Context, sqlitePath, mysqlDSN, entitySlug string) error {
-	mysqlDSN = ensureParseTimeEnabled(mysqlDSN)
-	if err := maybeRegisterTiDBTLS(mysqlDSN); err != nil {
-		return fmt.Errorf("configure mysql tls: %w", err)
-	}
-
-	sqliteDB, err := sql.Open("sqlite", sqlitePath)
-	if err != nil {
-		return fmt.Errorf("open sqlite database: %w", err)
-	}
-	defer sqliteDB.Close()
-	sqliteDB.SetMaxOpenConns(1)
-
-	if err := sqliteDB.PingContext(ctx); err != nil {
-		return fmt.Errorf("ping sqlite database: %w", err)
-	}
-
-	mysqlDB, err := sql.Open("mysql", mysqlDSN)
-	if err != nil {
-		return fmt.Errorf("open mysql database: %w", err)
-	}
-	defer mysqlDB.Close()
-
-	if err := mysqlDB.PingContext(ctx); err != nil {
-		return fmt.Errorf("ping mysql database: %w", err)
+// newEnergyFamily matches the smart socket's entities.
+func newEnergyFamily(selector *entitySelector) numericFamily {
+	return numericFamily{
+		name:          "energy",
+		selector:      selector,
+		averageTokens: []string{"_voltage", "_current", "_current_consumption"},
+		migratePoints: migrateEnergyPointsTable,
+		meterUnits:    []string{"Wh", "kWh", "MWh"},
 	}
+}
 
-	if err := ensureEnergyPointsTable(ctx, mysqlDB); err != nil {
-		return fmt.Errorf("ensure energy_points table: %w", err)
-	}
+// migrateEnergyPointsTable migrates energy_points layouts written by older releases.
+func migrateEnergyPointsTable(ctx context.Context, db *sql.DB) error {
+	const mysqlErrCantDrop = 1091
 
-	entityWatermarks, err := loadEnergyEntityWatermarks(ctx, mysqlDB)
-	if err != nil {
-		return fmt.Errorf("load energy checkpoints: %w", err)
+	if !destDialect.blockingAlters {
+		return nil
 	}
 
-	const queryPrefix = `
-SELECT
-    s.state_id,
-    sm.entity_id,
-    s.state,
-    s.last_updated_ts,
-    COALESCE(sa.shared_attrs, '')
-FROM states s
-JOIN states_meta sm ON s.metadata_id = sm.metadata_id
-LEFT JOIN state_attributes sa ON s.attributes_id = sa.attributes_id
+	const modifyStmt = `
+ALTER TABLE energy_points
+MODIFY COLUMN state_id BIGINT NOT NULL AUTO_INCREMENT
 `
-
-	query := queryPrefix + "WHERE sm.entity_id LIKE ? ORDER BY sm.entity_id, s.last_updated_ts"
-	entityPattern := "%" + entitySlug + "%"
-
-	rows, err := sqliteDB.QueryContext(ctx, query, entityPattern)
-	if err != nil {
-		return fmt.Errorf("query sqlite database: %w", err)
+	if _, err := execStatement(ctx, db, modifyStmt); err != nil {
+		return fmt.Errorf("ensure auto increment state_id: %w", err)
 	}
-	defer rows.Close()
-
-	const upsertPrefix = `
-INSERT INTO energy_points(
-    entity_id,
-    state,
-    numeric_state,
-    unit,
-    device_class,
-    state_class,
-    friendly_name,
-    last_updated
-) VALUES`
-	const upsertSuffix = `
-ON DUPLICATE KEY UPDATE
-    entity_id = VALUES(entity_id),
-    state = VALUES(state),
-    numeric_state = VALUES(numeric_state),
-    unit = VALUES(unit),
-    device_class = VALUES(device_class),
-    state_class = VALUES(state_class),
-    friendly_name = VALUES(friendly_name),
-    last_updated = VALUES(last_updated)
-`
-
-	const energyBatchSize = 500
-
-	var (
-		args          []any
-		valueSegments strings.Builder
-		rowCount      int
-	)
-	valueSegments.Grow(256)
-
-	flushBatch := func() error {
-		if rowCount == 0 {
-			return nil
-		}
-
-		var queryBuilder strings.Builder
-		queryBuilder.Grow(len(upsertPrefix) + valueSegments.Len() + len(upsertSuffix) + 1)
-		queryBuilder.WriteString(upsertPrefix)
-		queryBuilder.WriteString(valueSegments.String())
-		queryBuilder.WriteByte('\n')
-		queryBuilder.WriteString(upsertSuffix)
-
-		if _, err := mysqlDB.ExecContext(ctx, queryBuilder.String(), args...); err != nil {
-			return fmt.Errorf("upsert mysql rows: %w", err)
-		}
 
-		valueSegments.Reset()
-		args = args[:0]
-		rowCount = 0
-		return nil
+	var legacy int
+	if err := queryRowStatement(ctx, db, `
+SELECT COUNT(*)
+FROM INFORMATION_SCHEMA.COLUMNS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'energy_points' AND COLUMN_NAME = 'attributes'
+`, nil, &legacy); err != nil {
+		return fmt.Errorf("look up legacy attributes column: %w", err)
 	}
-
-	appendRow := func(row energyRow) error {
-		if rowCount > 0 {
-			valueSegments.WriteString(",")
-		}
-		valueSegments.WriteString("\n    (?, ?, ?, ?, ?, ?, ?, ?)")
-
-		args = append(args,
-			row.entityID,
-			row.state,
-			row.numericState,
-			row.meta.Unit,
-			row.meta.DeviceClass,
-			row.meta.StateClass,
-			row.meta.FriendlyName,
-			row.lastUpdated,
-		)
-
-		if row.lastUpdated.Valid {
-			if current, ok := entityWatermarks[row.entityID]; !ok || row.lastUpdated.Time.After(current) {
-				entityWatermarks[row.entityID] = row.lastUpdated.Time
-			}
-		}
-
-		rowCount++
-
-		if rowCount >= energyBatchSize {
-			return flushBatch()
-		}
+	if legacy == 0 {
 		return nil
 	}
-
-	averager := newMinuteAverager(appendRow)
-
-	for rows.Next() {
-		var (
-			stateID        int64
-			entityID       string
-			state          string
-			lastUpdatedVal sql.NullFloat64
-			attributesJSON string
-		)
-
-		if err := rows.Scan(&stateID, &entityID, &state, &lastUpdatedVal, &attributesJSON); err != nil {
-			return fmt.Errorf("scan sqlite row: %w", err)
-		}
-
-		lastUpdated, err := floatToNullTime(lastUpdatedVal)
-		if err != nil {
-			return fmt.Errorf("convert last_updated_ts for state_id %d: %w", stateID, err)
-		}
-
-		if lastUpdated.Valid {
-			if watermark, ok := entityWatermarks[entityID]; ok {
-				if !lastUpdated.Time.After(watermark) {
-					continue
-				}
-			}
-		}
-
-		meta, err := extractEnergyMetadata(attributesJSON)
-		if err != nil {
-			return fmt.Errorf("parse attributes for state_id %d: %w", stateID, err)
-		}
-
-		trimmedState := strings.TrimSpace(strings.ToLower(state))
-		if trimmedState == "unavailable" || trimmedState == "unknown" {
-			continue
-		}
-
-		numericState := parseNumericState(state)
-		if !numericState.Valid {
-			// Skip non numeric values (e.g. "on"/"off") to avoid writing NULL numeric_state rows.
-			continue
-		}
-		row := energyRow{
-			stateID:      stateID,
-			entityID:     entityID,
-			state:        state,
-			numericState: numericState,
-			meta:         meta,
-			lastUpdated:  lastUpdated,
-		}
-
-		if shouldAggregateRow(row) {
-			if err := averager.Add(row); err != nil {
-				return err
-			}
-			continue
-		}
-
-		if err := averager.Flush(); err != nil {
-			return err
-		}
-
-		if err := appendRow(row); err != nil {
-			return err
-		}
-	}
-
-	if err := rows.Err(); err != nil {
-		return fmt.Errorf("iterate sqlite rows: %w", err)
-	}
-
-	if err := averager.Flush(); err != nil {
+	if err := confirm("drop the legacy attributes column of energy_points"); err != nil {
 		return err
 	}
 
-	return flushBatch()
-}
-
-type energyMetadata struct {
-	Unit         sql.NullString
-	DeviceClass  sql.NullString
-	StateClass   sql.NullString
-	FriendlyName sql.NullString
-}
-
-func extractEnergyMetadata(raw string) (energyMetadata, error) {
-	meta := energyMetadata{}
-	trimmed := strings.TrimSpace(raw)
-	if trimmed == "" {
-		return meta, nil
-	}
-
-	var attrs map[string]any
-	if err := json.Unmarshal([]byte(trimmed), &attrs); err != nil {
-		return meta, fmt.Errorf("unmarshal shared_attrs: %w", err)
-	}
-
-	if v, ok := pickString(attrs["unit_of_measurement"]); ok {
-		meta.Unit = sql.NullString{String: v, Valid: true}
-	}
-	if v, ok := pickString(attrs["device_class"]); ok {
-		meta.DeviceClass = sql.NullString{String: v, Valid: true}
-	}
-	if v, ok := pickString(attrs["state_class"]); ok {
-		meta.StateClass = sql.NullString{String: v, Valid: true}
-	}
-	if v, ok := pickString(attrs["friendly_name"]); ok {
-		meta.FriendlyName = sql.NullString{String: v, Valid: true}
-	}
-
-	return meta, nil
-}
-
-func parseNumericState(raw string) sql.NullFloat64 {
-	if raw == "" {
-		return sql.NullFloat64{}
-	}
-	f, err := strconv.ParseFloat(raw, 64)
-	if err != nil {
-		return sql.NullFloat64{}
-	}
-	return sql.NullFloat64{Float64: f, Valid: true}
-}
-
-func ensureEnergyPointsTable(ctx context.Context, db *sql.DB) error {
-	const (
-		mysqlErrDuplicateKey = 1061
-		mysqlErrCantDrop     = 1091
-	)
-
-	const ddl = `
-CREATE TABLE IF NOT EXISTS energy_points (
-    state_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
-    entity_id VARCHAR(255) NOT NULL,
-    state VARCHAR(255) NOT NULL,
-    numeric_state DOUBLE NULL,
-    unit VARCHAR(64) NULL,
-    device_class VARCHAR(64) NULL,
-    state_class VARCHAR(64) NULL,
-    friendly_name VARCHAR(255) NULL,
-    last_updated DATETIME NULL
-)
-`
-
-	if _, err := db.ExecContext(ctx, ddl); err != nil {
-		return err
-	}
-
-	const modifyStmt = `
-ALTER TABLE energy_points
-MODIFY COLUMN state_id BIGINT NOT NULL AUTO_INCREMENT
-`
-	if _, err := db.ExecContext(ctx, modifyStmt); err != nil {
-		return fmt.Errorf("ensure auto increment state_id: %w", err)
-	}
-
 	dropAttrStmt := `
 ALTER TABLE energy_points
 DROP COLUMN attributes
 `
-	if _, err := db.ExecContext(ctx, dropAttrStmt); err != nil {
+	if _, err := execStatement(ctx, db, dropAttrStmt); err != nil {
 		if !isMySQLError(err, mysqlErrCantDrop) {
 			return fmt.Errorf("drop legacy attributes column: %w", err)
 		}
 	}
 
-	stmt := `
-ALTER TABLE energy_points
-ADD INDEX idx_energy_points_entity_last_updated (entity_id, last_updated)
-`
-	if _, err := db.ExecContext(ctx, stmt); err != nil {
-		if !isMySQLError(err, mysqlErrDuplicateKey) {
-			return fmt.Errorf("add supporting index: %w", err)
-		}
-	}
-
-	return nil
-}
-
-func loadEnergyEntityWatermarks(ctx context.Context, db *sql.DB) (map[string]time.Time, error) {
-	const query = `
-SELECT entity_id, MAX(last_updated)
-FROM energy_points
-GROUP BY entity_id
-`
-	rows, err := db.QueryContext(ctx, query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	watermarks := make(map[string]time.Time)
-	for rows.Next() {
-		var (
-			entityID string
-			ts       sql.NullTime
-		)
-		if err := rows.Scan(&entityID, &ts); err != nil {
-			return nil, err
-		}
-		if ts.Valid {
-			watermarks[entityID] = ts.Time
-		}
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return watermarks, nil
-}
-
-type energyRow struct {
-	stateID      int64
-	entityID     string
-	state        string
-	numericState sql.NullFloat64
-	meta         energyMetadata
-	lastUpdated  sql.NullTime
-}
-
-var energyMinuteAverageTokens = []string{"_voltage", "_current", "_current_consumption"}
-
-func shouldAggregateRow(row energyRow) bool {
-	return row.lastUpdated.Valid && row.numericState.Valid && needsMinuteAverage(row.entityID)
-}
-
-func needsMinuteAverage(entityID string) bool {
-	lowered := strings.ToLower(entityID)
-	for _, token := range energyMinuteAverageTokens {
-		if strings.Contains(lowered, token) {
-			return true
-		}
-	}
-	return false
-}
-
-type minuteAverager struct {
-	emit func(energyRow) error
-
-	active       bool
-	entityID     string
-	minute       time.Time
-	sum          float64
-	count        int
-	maxTime      time.Time
-	maxTimeValid bool
-	stateID      int64
-	meta         energyMetadata
-}
-
-func newMinuteAverager(emit func(energyRow) error) *minuteAverager {
-	return &minuteAverager{emit: emit}
-}
-
-func (m *minuteAverager) Add(row energyRow) error {
-	minute := row.lastUpdated.Time.Truncate(time.Minute)
-	if m.active {
-		if row.entityID != m.entityID || !minute.Equal(m.minute) {
-			if err := m.Flush(); err != nil {
-				return err
-			}
-		}
-	}
-	if !m.active {
-		m.active = true
-		m.entityID = row.entityID
-		m.minute = minute
-		m.sum = 0
-		m.count = 0
-		m.maxTime = time.Time{}
-		m.maxTimeValid = false
-	}
-
-	m.sum += row.numericState.Float64
-	m.count++
-
-	if !m.maxTimeValid || row.lastUpdated.Time.After(m.maxTime) || (row.lastUpdated.Time.Equal(m.maxTime) && row.stateID > m.stateID) {
-		m.maxTime = row.lastUpdated.Time
-		m.maxTimeValid = true
-		m.stateID = row.stateID
-		m.meta = row.meta
-	}
-
 	return nil
 }
-
-func (m *minuteAverager) Flush() error {
-	if !m.active {
-		return nil
-	}
-	defer m.reset()
-	if m.count == 0 || !m.maxTimeValid {
-		return nil
-	}
-
-	avg := m.sum / float64(m.count)
-	row := energyRow{
-		stateID:      m.stateID,
-		entityID:     m.entityID,
-		state:        strconv.FormatFloat(avg, 'f', -1, 64),
-		numericState: sql.NullFloat64{Float64: avg, Valid: true},
-		meta:         m.meta,
-		lastUpdated:  sql.NullTime{Time: m.maxTime, Valid: true},
-	}
-
-	return m.emit(row)
-}
-
-func (m *minuteAverager) reset() {
-	m.active = false
-	m.entityID = ""
-	m.minute = time.Time{}
-	m.sum = 0
-	m.count = 0
-	m.maxTime = time.Time{}
-	m.maxTimeValid = false
-	m.stateID = 0
-	m.meta = energyMetadata{}
-}
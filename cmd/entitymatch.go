@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Match modes of --match, deciding which entity_ids an --entity value selects.
+const (
+	// matchPrefix takes the entity named by the value and the ones extending it with _suffixes,
+	// e.g. plug_1 selects sensor.plug_1_power but not sensor.plug_10_power.
+	matchPrefix = "prefix"
+	// matchExact takes the entity_id equal to the value.
+	matchExact = "exact"
+	// matchGlob matches the entity_id against a shell pattern such as sensor.plug_?_power.
+	matchGlob = "glob"
+	// matchRegex matches the entity_id against a regular expression (unanchored).
+	matchRegex = "regex"
+	// matchContains takes every entity_id containing the value, as releases before --match did.
+	matchContains = "contains"
+)
+
+const matchFlagUsage = "How --entity selects entity_ids: prefix (the entity after the domain, and its <entity>_* sensors), exact, glob (e.g. sensor.plug_?_power), regex, or contains (any entity_id containing it, as older releases did)"
+
+// addMatchFlag registers --match on a command with --entity.
+func addMatchFlag(cmd *cobra.Command, mode *string) {
+	cmd.Flags().StringVar(mode, "match", matchPrefix, matchFlagUsage)
+}
+
+// entitySelector is an --entity value read in a --match mode.
+type entitySelector struct {
+	mode  string
+	value string
+	re    *regexp.Regexp
+}
+
+// newEntitySelector validates the --entity value for the mode. An empty value selects every
+// entity, returned as nil.
+func newEntitySelector(mode, value string) (*entitySelector, error) {
+	if value == "" {
+		return nil, nil
+	}
+	s := &entitySelector{mode: mode, value: strings.ToLower(value)}
+	switch mode {
+	case matchPrefix, matchExact, matchContains:
+	case matchGlob:
+		if _, err := path.Match(s.value, ""); err != nil {
+			return nil, fmt.Errorf("invalid --entity glob %q", value)
+		}
+	case matchRegex:
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --entity regex: %w", err)
+		}
+		s.value, s.re = value, re
+	default:
+		return nil, fmt.Errorf("unknown --match %q (supported: %s, %s, %s, %s, %s)", mode, matchPrefix, matchExact, matchGlob, matchRegex, matchContains)
+	}
+	return s, nil
+}
+
+func (s *entitySelector) matches(entityID string) bool {
+	if s.mode == matchRegex {
+		return s.re.MatchString(entityID)
+	}
+	entityID = strings.ToLower(entityID)
+	switch s.mode {
+	case matchExact:
+		return entityID == s.value
+	case matchGlob:
+		ok, _ := path.Match(s.value, entityID)
+		return ok
+	case matchContains:
+		return strings.Contains(entityID, s.value)
+	default:
+		name := entityID
+		if !strings.Contains(s.value, ".") {
+			_, name, _ = strings.Cut(entityID, ".")
+		}
+		rest, ok := strings.CutPrefix(name, s.value)
+		return ok && (rest == "" || rest[0] == '_')
+	}
+}
+
+// resolve returns the family narrowed to the entity_ids its selector matches, listed in the
+// filter. Matching runs here rather than in SQL, so no character of the --entity value acts as
+// a LIKE wildcard.
+func (f numericFamily) resolve(ctx context.Context, sqliteDB *sql.DB) (numericFamily, error) {
+	if f.selector == nil {
+		return f, nil
+	}
+	rows, err := sqliteDB.QueryContext(ctx, "SELECT entity_id FROM states_meta ORDER BY entity_id")
+	if err != nil {
+		return f, fmt.Errorf("list recorder entities: %w", err)
+	}
+	defer rows.Close()
+	var matched []any
+	for rows.Next() {
+		var entityID string
+		if err := rows.Scan(&entityID); err != nil {
+			return f, fmt.Errorf("scan recorder entity: %w", err)
+		}
+		if f.selector.matches(entityID) {
+			matched = append(matched, entityID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return f, fmt.Errorf("list recorder entities: %w", err)
+	}
+
+	condition := "0 = 1"
+	if len(matched) > 0 {
+		condition = "sm.entity_id IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(matched)), ", ") + ")"
+	}
+	resolved := f
+	resolved.selector = nil
+	resolved.args = append(append([]any{}, f.args...), matched...)
+	if f.where == "" {
+		resolved.where = condition
+	} else {
+		resolved.where = "(" + f.where + ") AND " + condition
+	}
+	return resolved, nil
+}
+
+// likeEscape is the ESCAPE clause of patterns built by likeContains. '!' rather than a backslash,
+// which MySQL string literals would treat as an escape of their own.
+const likeEscape = " ESCAPE '!'"
+
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// likeContains returns a LIKE pattern (used with likeEscape) matching values that contain s, so
+// the _ and % of an entity slug match only themselves.
+func likeContains(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// explainMode is the --explain flag: print the SQL a run would use instead of running it.
+var explainMode bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&explainMode, "explain", false, "Print the recorder queries, destination DDL, and write statements the command would use, without reading recorder rows or touching the destination")
+}
+
+// explainOut receives the --explain output.
+var explainOut io.Writer = os.Stdout
+
+// explainDriverName is the recorder driver under --explain: the sqlite driver printing each
+// query before running it.
+const explainDriverName = "sqlite-explain"
+
+var registerExplainDriver sync.Once
+
+// recorderDriver returns the driver openRecorder uses.
+func recorderDriver() string {
+	if !explainMode {
+		return "sqlite"
+	}
+	registerExplainDriver.Do(func() {
+		db, err := sql.Open("sqlite", "")
+		if err != nil {
+			panic(err)
+		}
+		sql.Register(explainDriverName, explainDriver{db.Driver()})
+		db.Close()
+	})
+	return explainDriverName
+}
+
+type explainDriver struct{ driver.Driver }
+
+func (d explainDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return explainConn{conn}, nil
+}
+
+// explainConn prints the queries run on a recorder connection.
+type explainConn struct{ driver.Conn }
+
+type explainQuietKey struct{}
+
+// explainQuiet marks queries ha-tools runs to set up the recorder, which --explain leaves out.
+func explainQuiet(ctx context.Context) context.Context {
+	return context.WithValue(ctx, explainQuietKey{}, true)
+}
+
+func (c explainConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if ctx.Value(explainQuietKey{}) == nil {
+		printExplainQuery(query, args)
+	}
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return queryer.QueryContext(ctx, query, args)
+}
+
+func (c explainConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return execer.ExecContext(ctx, query, args)
+}
+
+func printExplainQuery(query string, args []driver.NamedValue) {
+	fmt.Fprintf(explainOut, "-- recorder query\n%s;\n", strings.TrimSpace(query))
+	if len(args) == 0 {
+		return
+	}
+	values := make([]string, len(args))
+	for i, arg := range args {
+		values[i] = explainValue(arg.Value)
+	}
+	fmt.Fprintf(explainOut, "-- with: %s\n", strings.Join(values, ", "))
+}
+
+func explainValue(v any) string {
+	switch v := v.(type) {
+	case float64:
+		// Recorder timestamps are Unix seconds; show when they are.
+		if v > 1e9 && v < 1e10 {
+			return fmt.Sprintf("%v (%s)", v, time.Unix(0, int64(v*1e9)).UTC().Format(time.DateTime))
+		}
+	case string:
+		quoted, _ := json.Marshal(v)
+		return string(quoted)
+	}
+	return fmt.Sprint(v)
+}
+
+// emptyRecorder shadows the recorder's row tables with empty TEMP views, so --explain runs the
+// command's queries without reading a row and nothing is written.
+func emptyRecorder(ctx context.Context, sqliteDB *sql.DB, shims []recorderShim) error {
+	ctx = explainQuiet(ctx)
+	for _, source := range limitedSources {
+		var n int
+		if err := sqliteDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM main.sqlite_master WHERE type = 'table' AND name = ?", source.table).Scan(&n); err != nil {
+			return fmt.Errorf("look up recorder table %s: %w", source.table, err)
+		}
+		if n == 0 {
+			continue
+		}
+		if _, err := sqliteDB.ExecContext(ctx, "CREATE TEMP VIEW "+source.table+" AS "+shimQuery(shims, source.table)+" WHERE 0"); err != nil {
+			return fmt.Errorf("explain recorder table %s: %w", source.table, err)
+		}
+	}
+	return createShimViews(ctx, sqliteDB, shims, true)
+}
+
+// explainSink stands in for every destination under --explain: it prints the statements the
+// mysql sink would run and writes nothing. Watermarks are not read, so queries show a full export.
+type explainSink struct {
+	name string
+
+	mu      sync.Mutex
+	printed map[string]bool
+}
+
+func openExplainSink(name string) Sink {
+	return &explainSink{name: name, printed: make(map[string]bool)}
+}
+
+// EnsureSchema prints the table's DDL and an example write statement, once per table.
+func (s *explainSink) EnsureSchema(ctx context.Context, table *tableSpec) error {
+	table, err := withComputedColumns(table)
+	if err != nil {
+		return err
+	}
+	if table.entityTable != nil {
+		if err := s.EnsureSchema(ctx, table.entityTable); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.printed[table.name] {
+		return nil
+	}
+	s.printed[table.name] = true
+
+	fmt.Fprintf(explainOut, "-- %s destination table %s\n", s.name, table.name)
+	if s.name != "mysql" && s.name != "sqlfile" {
+		fmt.Fprintf(explainOut, "-- the %s sink creates no tables\n", s.name)
+	} else {
+		fmt.Fprintf(explainOut, "%s;\n", strings.TrimSpace(mysqlCreateTable(table)))
+		if s.name == "mysql" {
+			explainMigrations(table)
+		}
+	}
+	stmt := destDialect.newWriteStatement(table)
+	fmt.Fprintf(explainOut, "-- %s rows are written as (%s mode, one row shown)\n%s\n%s;\n",
+		table.name, tableWriteMode(table), strings.TrimSpace(stmt.prefix)+stmt.placeholder, strings.TrimSpace(stmt.suffix))
+	if tableWriteMode(table) == writeModeStagingSwap {
+		staging := stagingTableName(table.name)
+		fmt.Fprintf(explainOut, "-- into %[2]s instead, which replaces %[1]s after the export\nCREATE TABLE IF NOT EXISTS %[2]s LIKE %[1]s;\nRENAME TABLE %[1]s TO %[1]s_old, %[2]s TO %[1]s;\n", table.name, staging)
+	}
+	return nil
+}
+
+// explainMigrations lists what the mysql sink checks on an existing table. The statements depend
+// on the live schema, so only those certain to be attempted are printed.
+func explainMigrations(table *tableSpec) {
+	fmt.Fprintf(explainOut, "-- on an existing table: add missing columns, convert the character set if it differs")
+	if table.mysqlMigrate != nil {
+		fmt.Fprintf(explainOut, ", run the table's migrations")
+	}
+	fmt.Fprintln(explainOut)
+	for _, idx := range table.indexes {
+		kind := "INDEX"
+		if idx.spatial {
+			kind = "SPATIAL INDEX"
+		}
+		fmt.Fprintf(explainOut, "ALTER TABLE %s ADD %s %s (%s);\n", table.name, kind, quoteIdentifier(idx.name), strings.Join(idx.columns, ", "))
+	}
+	for _, idx := range table.plan() {
+		fmt.Fprintf(explainOut, "-- index plan: %s (%s)\n", idx.name, strings.Join(idx.columns, ", "))
+	}
+}
+
+func (s *explainSink) WriteBatch(ctx context.Context, table *tableSpec, rows [][]any) error {
+	return nil
+}
+
+func (s *explainSink) LoadWatermarks(ctx context.Context, table *tableSpec) (map[string]time.Time, error) {
+	return map[string]time.Time{}, nil
+}
+
+// LoadLatest and ResolveEntity let --with-delta and --normalized be explained.
+func (s *explainSink) LoadLatest(ctx context.Context, table *tableSpec, columns []string, fn func(scan func(dest ...any) error) error) error {
+	return nil
+}
+
+func (s *explainSink) ResolveEntity(ctx context.Context, entityID string, meta stateMetadata) (int64, error) {
+	return 0, nil
+}
+
+func (s *explainSink) Close() error { return nil }
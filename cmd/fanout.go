@@ -0,0 +1,423 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alsoDests is the --also-dest flag: further destinations the exporters write the same rows to.
+var alsoDests []string
+
+func init() {
+	rootCmd.PersistentFlags().StringArrayVar(&alsoDests, "also-dest", nil, "Also write the exported rows to this destination: a connection profile from the config, or <sink>:<target> such as ndjson:/var/lib/ha/points.ndjson. Each destination keeps its own watermarks, and one failing does not stop the others. Repeatable")
+}
+
+// validateAlsoDests checks that every --also-dest names a profile or a registered sink.
+func validateAlsoDests() error {
+	for _, ref := range alsoDests {
+		if _, _, ok := alsoDestSink(ref); ok {
+			continue
+		}
+		name, p, err := appConfig.profile(ref)
+		if err != nil {
+			return fmt.Errorf("--also-dest: %w", err)
+		}
+		if p.DSN == "" {
+			return fmt.Errorf("--also-dest: profile %s has no dsn", name)
+		}
+		if p.Dialect != "" && p.Dialect != destDialect.name {
+			return fmt.Errorf("--also-dest: profile %s uses the %s dialect, but the command uses %s", name, p.Dialect, destDialect.name)
+		}
+	}
+	return nil
+}
+
+// alsoDestSink splits a <sink>:<target> reference.
+func alsoDestSink(ref string) (string, string, bool) {
+	name, target, ok := strings.Cut(ref, ":")
+	if !ok {
+		return "", "", false
+	}
+	_, registered := sinkFactories[name]
+	return name, target, registered
+}
+
+// openExportSink opens the sink exporters write to: the --sink destination, fanned out to every
+// --also-dest. An --also-dest that cannot be opened fails on its own, like one failing later.
+func openExportSink(ctx context.Context, target string) (Sink, error) {
+	if err := checkPartialRead(sinkName); err != nil {
+		return nil, err
+	}
+	for _, ref := range alsoDests {
+		if err := checkPartialRead(alsoDestSinkName(ref)); err != nil {
+			return nil, fmt.Errorf("--also-dest %s: %w", ref, err)
+		}
+	}
+	primary, err := openSink(ctx, sinkName, target)
+	if err != nil {
+		return nil, err
+	}
+	if len(alsoDests) == 0 {
+		return primary, nil
+	}
+
+	fan := &fanoutSink{
+		watermarks: make(map[*fanoutMember]map[string]map[string]time.Time),
+		ties:       make(map[*fanoutMember]map[string]map[string]int64),
+	}
+	fan.members = append(fan.members, &fanoutMember{name: sinkName, sink: primary})
+	for _, ref := range alsoDests {
+		name, sink, err := openAlsoDest(ctx, ref)
+		m := &fanoutMember{name: name, sink: sink}
+		fan.members = append(fan.members, m)
+		if err != nil {
+			m.name = ref
+			fan.fail(m, err)
+		}
+	}
+	if _, ok := primary.(sqlFanoutPrimary); ok {
+		return &sqlFanoutSink{fanoutSink: fan}, nil
+	}
+	return fan, nil
+}
+
+// alsoDestSinkName returns the sink an --also-dest writes through.
+func alsoDestSinkName(ref string) string {
+	if name, _, ok := alsoDestSink(ref); ok {
+		return name
+	}
+	if _, p, err := appConfig.profile(ref); err == nil && p.Sink != "" {
+		return p.Sink
+	}
+	return sinkName
+}
+
+// openAlsoDest opens one --also-dest, returning the name it is logged under. DSNs are never
+// logged, since they may hold passwords.
+func openAlsoDest(ctx context.Context, ref string) (string, Sink, error) {
+	if name, target, ok := alsoDestSink(ref); ok {
+		sink, err := openSink(ctx, name, target)
+		return name, sink, err
+	}
+	name, p, err := appConfig.profile(ref)
+	if err != nil {
+		return "", nil, err
+	}
+	dsn, err := p.destinationDSN(ctx, name)
+	if err != nil {
+		return "", nil, fmt.Errorf("profile %s: %w", name, err)
+	}
+	sinkType := p.Sink
+	if sinkType == "" {
+		sinkType = sinkName
+	}
+	sink, err := openSink(ctx, sinkType, dsn)
+	return name, sink, err
+}
+
+// fanoutMember is one destination of a fan-out. A member that fails is left out of the rest of
+// the run.
+type fanoutMember struct {
+	name string
+	sink Sink
+	err  error
+}
+
+// fanoutSink writes every batch to each of its members. Exporters read from the oldest watermark
+// of all members, and each member only receives the rows newer than its own watermarks, so a
+// destination that fell behind catches up without the others being rewritten. A failing member
+// is reported and dropped; the others carry on, and the command fails at the end.
+type fanoutSink struct {
+	members []*fanoutMember
+
+	mu sync.Mutex
+	// watermarks holds each member's watermarks per table, as loaded by LoadWatermarks, and ties
+	// their watermark ties, as loaded by LoadWatermarkTies.
+	watermarks map[*fanoutMember]map[string]map[string]time.Time
+	ties       map[*fanoutMember]map[string]map[string]int64
+}
+
+// fanoutFailures collects the members that failed, for Execute to report.
+var fanoutFailures struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// fanoutError returns the failures of --also-dest fan-outs during this run.
+func fanoutError() error {
+	fanoutFailures.mu.Lock()
+	defer fanoutFailures.mu.Unlock()
+	return errors.Join(fanoutFailures.errs...)
+}
+
+// fail drops the member from the rest of the run. The resume token is abandoned: rows the others
+// committed are missing in this member, whose own watermarks resume it next run.
+func (f *fanoutSink) fail(m *fanoutMember, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if m.err != nil {
+		return
+	}
+	m.err = err
+	fmt.Fprintf(os.Stderr, "destination %s failed, it receives no more rows this run: %v\n", m.name, err)
+	fanoutFailures.mu.Lock()
+	fanoutFailures.errs = append(fanoutFailures.errs, fmt.Errorf("destination %s: %w", m.name, err))
+	fanoutFailures.mu.Unlock()
+	abandonResumeToken()
+}
+
+// live returns the members that have not failed.
+func (f *fanoutSink) live() []*fanoutMember {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var live []*fanoutMember
+	for _, m := range f.members {
+		if m.err == nil {
+			live = append(live, m)
+		}
+	}
+	return live
+}
+
+// each runs fn for every live member, failing those it returns an error for. It returns an error
+// only when no member is left.
+func (f *fanoutSink) each(fn func(m *fanoutMember) error) error {
+	for _, m := range f.live() {
+		if err := fn(m); err != nil {
+			f.fail(m, err)
+		}
+	}
+	if len(f.live()) == 0 {
+		return fmt.Errorf("every destination failed: %w", fanoutError())
+	}
+	return nil
+}
+
+func (f *fanoutSink) EnsureSchema(ctx context.Context, table *tableSpec) error {
+	return f.each(func(m *fanoutMember) error {
+		return m.sink.EnsureSchema(ctx, table)
+	})
+}
+
+func (f *fanoutSink) WriteBatch(ctx context.Context, table *tableSpec, rows [][]any) error {
+	return f.each(func(m *fanoutMember) error {
+		rows := f.newerRows(m, table, rows)
+		if len(rows) == 0 {
+			return nil
+		}
+		return m.sink.WriteBatch(ctx, table, rows)
+	})
+}
+
+// LoadWatermarks returns, per entity, the oldest watermark of all members; an entity missing from
+// any member is missing from the result, so the exporter reads its full history.
+func (f *fanoutSink) LoadWatermarks(ctx context.Context, table *tableSpec) (map[string]time.Time, error) {
+	loaded := make(map[*fanoutMember]map[string]time.Time)
+	err := f.each(func(m *fanoutMember) error {
+		watermarks, err := m.sink.LoadWatermarks(ctx, table)
+		if err != nil {
+			return err
+		}
+		loaded[m] = watermarks
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var oldest map[string]time.Time
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for m, watermarks := range loaded {
+		if f.watermarks[m] == nil {
+			f.watermarks[m] = make(map[string]map[string]time.Time)
+		}
+		f.watermarks[m][table.name] = watermarks
+		if m.err != nil {
+			continue
+		}
+		if oldest == nil {
+			oldest = make(map[string]time.Time, len(watermarks))
+			for entityID, at := range watermarks {
+				oldest[entityID] = at
+			}
+			continue
+		}
+		for entityID, at := range oldest {
+			mark, ok := watermarks[entityID]
+			switch {
+			case !ok:
+				delete(oldest, entityID)
+			case mark.Before(at):
+				oldest[entityID] = mark
+			}
+		}
+	}
+	return oldest, nil
+}
+
+// LoadWatermarkTies returns, per entity, the lowest tie of the members whose watermark is the
+// oldest one LoadWatermarks returned, so the exporter re-reads every row at that instant some
+// member still lacks. A member without a tie there (its sink cannot read them back, or its rows
+// carry no id) has all of them.
+func (f *fanoutSink) LoadWatermarkTies(ctx context.Context, table *tableSpec) (map[string]int64, error) {
+	loaded := make(map[*fanoutMember]map[string]int64)
+	err := f.each(func(m *fanoutMember) error {
+		loader, ok := m.sink.(watermarkTieLoader)
+		if !ok {
+			return nil
+		}
+		ties, err := loader.LoadWatermarkTies(ctx, table)
+		if err != nil {
+			return err
+		}
+		loaded[m] = ties
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	oldest := make(map[string]time.Time)
+	for _, m := range f.members {
+		if m.err != nil {
+			continue
+		}
+		for entityID, at := range f.watermarks[m][table.name] {
+			if current, ok := oldest[entityID]; !ok || at.Before(current) {
+				oldest[entityID] = at
+			}
+		}
+	}
+	lowest := make(map[string]int64)
+	for _, m := range f.members {
+		if f.ties[m] == nil {
+			f.ties[m] = make(map[string]map[string]int64)
+		}
+		f.ties[m][table.name] = loaded[m]
+		if m.err != nil {
+			continue
+		}
+		for entityID, tie := range loaded[m] {
+			if !f.watermarks[m][table.name][entityID].Equal(oldest[entityID]) {
+				continue
+			}
+			if current, ok := lowest[entityID]; !ok || tie < current {
+				lowest[entityID] = tie
+			}
+		}
+	}
+	return lowest, nil
+}
+
+// newerRows returns the rows the member lacks: those newer than its watermark for their entity,
+// and those at the watermark's instant above its tie. Tables without per-entity watermarks, or
+// referencing an entities dimension, are written in full.
+func (f *fanoutSink) newerRows(m *fanoutMember, table *tableSpec, rows [][]any) [][]any {
+	f.mu.Lock()
+	watermarks := f.watermarks[m][table.name]
+	ties := f.ties[m][table.name]
+	f.mu.Unlock()
+	if len(watermarks) == 0 || table.entityTable != nil || table.newerOnly {
+		return rows
+	}
+	columns := table.writeColumns()
+	entityAt, timeAt := slices.Index(columns, table.entityColumn), slices.Index(columns, table.timeColumn)
+	if entityAt < 0 || timeAt < 0 {
+		return rows
+	}
+
+	idAt := slices.Index(columns, table.idColumn)
+
+	newer := make([][]any, 0, len(rows))
+	for _, values := range rows {
+		entityID, ok := rowString(values[entityAt])
+		if !ok {
+			entityID = fmt.Sprint(values[entityAt])
+		}
+		var id int64
+		if idAt >= 0 {
+			if v, ok := rowInt64(values[idAt]); ok {
+				id = v
+			}
+		}
+		if at, ok := rowTime(values[timeAt]); ok && exportedBefore(watermarks, ties, entityID, at, id) {
+			continue
+		}
+		newer = append(newer, values)
+	}
+	return newer
+}
+
+// FinalizeTable runs every member's post-export maintenance.
+func (f *fanoutSink) FinalizeTable(ctx context.Context, table *tableSpec) error {
+	return f.each(func(m *fanoutMember) error {
+		return finalizeTable(ctx, m.sink, table)
+	})
+}
+
+// BatchRows sizes batches for the member with the smallest limit.
+func (f *fanoutSink) BatchRows(table *tableSpec, rowBytes int) int {
+	n := 0
+	for _, m := range f.live() {
+		sizer, ok := m.sink.(batchSizer)
+		if !ok {
+			continue
+		}
+		if rows := sizer.BatchRows(table, rowBytes); rows > 0 && (n == 0 || rows < n) {
+			n = rows
+		}
+	}
+	return n
+}
+
+func (f *fanoutSink) Close() error {
+	var errs []error
+	for _, m := range f.members {
+		if m.sink == nil {
+			continue
+		}
+		if err := m.sink.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("destination %s: %w", m.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sqlFanoutPrimary is what a primary destination needs for the SQL-only features of a fan-out.
+type sqlFanoutPrimary interface {
+	sqlSink
+	latestRowLoader
+}
+
+// sqlFanoutSink is a fan-out whose primary destination (--sink) is a SQL database. Reads (derived
+// values such as deltas and open presence stays) and destination-side rollups use the primary;
+// the other members receive the exported rows only.
+type sqlFanoutSink struct {
+	*fanoutSink
+}
+
+func (f *sqlFanoutSink) primary() sqlFanoutPrimary {
+	return f.members[0].sink.(sqlFanoutPrimary)
+}
+
+func (f *sqlFanoutSink) DB() *sql.DB { return f.primary().DB() }
+
+func (f *sqlFanoutSink) LoadLatest(ctx context.Context, table *tableSpec, columns []string, fn func(scan func(dest ...any) error) error) error {
+	return f.primary().LoadLatest(ctx, table, columns, fn)
+}
+
+// ResolveEntity refuses the normalized schema: every destination numbers its entities itself, so
+// one row cannot carry an id valid in all of them.
+func (f *sqlFanoutSink) ResolveEntity(ctx context.Context, entityID string, meta stateMetadata) (int64, error) {
+	return 0, errors.New("--normalized cannot be combined with --also-dest")
+}
@@ -0,0 +1,516 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	fixtureOutput   string
+	fixtureEntities int
+	fixtureDays     int
+	fixtureRate     time.Duration
+	fixtureSeed     int64
+	fixtureForce    bool
+)
+
+// fixtureSchemaVersion is the recorder schema version written to schema_changes.
+const fixtureSchemaVersion = 43
+
+// genfixtureCmd writes a synthetic recorder database for benchmarks and config testing.
+var genfixtureCmd = &cobra.Command{
+	Use:   "genfixture",
+	Short: "Generate a synthetic Home Assistant recorder database",
+	Long:  "Writes a SQLite database with the recorder's states, states_meta, state_attributes, and schema_changes tables, filled with realistic smart plug, climate, battery, device tracker, person, sun, and weather history, so exports and configs can be tried without real home data.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if fixtureOutput == "" {
+			return errors.New("output path is required")
+		}
+		if fixtureEntities <= 0 || fixtureDays <= 0 {
+			return errors.New("--entities and --days must be positive")
+		}
+		if fixtureRate < time.Second {
+			return errors.New("--rate must be at least 1s")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		rows, err := generateFixture(ctx, fixtureOutput)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "wrote %d states for %d entities to %s\n", rows, fixtureEntities, fixtureOutput)
+		return nil
+	},
+}
+
+func init() {
+	genfixtureCmd.Flags().StringVar(&fixtureOutput, "output", "", "Path of the SQLite database to create")
+	genfixtureCmd.Flags().IntVar(&fixtureEntities, "entities", 50, "Number of entities to generate")
+	genfixtureCmd.Flags().IntVar(&fixtureDays, "days", 30, "Days of history ending now")
+	genfixtureCmd.Flags().DurationVar(&fixtureRate, "rate", 10*time.Second, "Sampling interval per entity")
+	genfixtureCmd.Flags().Int64Var(&fixtureSeed, "seed", 1, "Random seed; the same seed produces the same values")
+	genfixtureCmd.Flags().BoolVar(&fixtureForce, "force", false, "Overwrite the output file if it exists")
+	_ = genfixtureCmd.MarkFlagRequired("output")
+
+	rootCmd.AddCommand(genfixtureCmd)
+}
+
+// fixtureHome is where generated trackers live.
+const (
+	fixtureHomeLatitude  = 52.5200
+	fixtureHomeLongitude = 13.4050
+)
+
+var fixtureSchema = []string{
+	`CREATE TABLE states_meta (
+    metadata_id INTEGER PRIMARY KEY,
+    entity_id VARCHAR(255)
+)`,
+	`CREATE UNIQUE INDEX ix_states_meta_entity_id ON states_meta (entity_id)`,
+	`CREATE TABLE state_attributes (
+    attributes_id INTEGER PRIMARY KEY,
+    hash BIGINT,
+    shared_attrs TEXT
+)`,
+	`CREATE INDEX ix_state_attributes_hash ON state_attributes (hash)`,
+	`CREATE TABLE states (
+    state_id INTEGER PRIMARY KEY,
+    state VARCHAR(255),
+    last_changed_ts FLOAT,
+    last_updated_ts FLOAT,
+    old_state_id INTEGER,
+    attributes_id INTEGER,
+    metadata_id INTEGER
+)`,
+	`CREATE INDEX ix_states_metadata_id_last_updated_ts ON states (metadata_id, last_updated_ts)`,
+	`CREATE INDEX ix_states_last_updated_ts ON states (last_updated_ts)`,
+	`CREATE TABLE schema_changes (
+    change_id INTEGER PRIMARY KEY,
+    schema_version INTEGER,
+    changed DATETIME
+)`,
+}
+
+// fixtureEntity is one generated entity and its evolving state.
+type fixtureEntity struct {
+	entityID   string
+	metadataID int64
+	// sample returns the state and attributes at t; ok=false records nothing for this step.
+	sample func(t time.Time) (state string, attrs map[string]any, ok bool)
+
+	lastStateID int64
+	lastState   string
+	lastChanged float64
+}
+
+func generateFixture(ctx context.Context, path string) (int64, error) {
+	if _, err := os.Stat(path); err == nil {
+		if !fixtureForce {
+			return 0, fmt.Errorf("%s already exists; pass --force to overwrite", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return 0, fmt.Errorf("remove existing fixture: %w", err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return 0, fmt.Errorf("open sqlite database: %w", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range fixtureSchema {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return 0, fmt.Errorf("create recorder schema: %w", err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO schema_changes (schema_version, changed) VALUES (?, ?)", fixtureSchemaVersion, time.Now().UTC()); err != nil {
+		return 0, fmt.Errorf("write schema version: %w", err)
+	}
+
+	rng := rand.New(rand.NewSource(fixtureSeed))
+	entities := newFixtureEntities(fixtureEntities, rng)
+	for i, e := range entities {
+		e.metadataID = int64(i + 1)
+		if _, err := db.ExecContext(ctx, "INSERT INTO states_meta (metadata_id, entity_id) VALUES (?, ?)", e.metadataID, e.entityID); err != nil {
+			return 0, fmt.Errorf("write states_meta: %w", err)
+		}
+	}
+
+	end := time.Now().Truncate(fixtureRate)
+	start := end.Add(-time.Duration(fixtureDays) * 24 * time.Hour)
+
+	w, err := newFixtureWriter(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	defer w.rollback()
+
+	for t := start; !t.After(end); t = t.Add(fixtureRate) {
+		for _, e := range entities {
+			state, attrs, ok := e.sample(t)
+			if !ok {
+				continue
+			}
+			// Spread samples within the step so entities do not share identical timestamps.
+			ts := float64(t.UnixNano())/1e9 + rng.Float64()*math.Min(fixtureRate.Seconds(), 1)
+			if err := w.write(ctx, e, state, attrs, ts); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := w.commit(); err != nil {
+		return 0, err
+	}
+	return w.rows, nil
+}
+
+// fixtureWriter inserts states in transactions of fixtureCommitEvery rows, deduplicating attributes
+// the way the recorder does.
+type fixtureWriter struct {
+	db         *sql.DB
+	tx         *sql.Tx
+	insertAttr *sql.Stmt
+	insertStat *sql.Stmt
+	attributes map[string]int64
+	pending    int
+	rows       int64
+}
+
+const fixtureCommitEvery = 10000
+
+func newFixtureWriter(ctx context.Context, db *sql.DB) (*fixtureWriter, error) {
+	w := &fixtureWriter{db: db, attributes: make(map[string]int64)}
+	if err := w.begin(ctx); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *fixtureWriter) begin(ctx context.Context) error {
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin fixture transaction: %w", err)
+	}
+	w.tx = tx
+	if w.insertAttr, err = tx.PrepareContext(ctx, "INSERT INTO state_attributes (hash, shared_attrs) VALUES (?, ?)"); err != nil {
+		return fmt.Errorf("prepare state_attributes insert: %w", err)
+	}
+	if w.insertStat, err = tx.PrepareContext(ctx, `
+INSERT INTO states (state, last_changed_ts, last_updated_ts, old_state_id, attributes_id, metadata_id)
+VALUES (?, ?, ?, ?, ?, ?)`); err != nil {
+		return fmt.Errorf("prepare states insert: %w", err)
+	}
+	return nil
+}
+
+func (w *fixtureWriter) write(ctx context.Context, e *fixtureEntity, state string, attrs map[string]any, ts float64) error {
+	raw, err := json.Marshal(attrs)
+	if err != nil {
+		return fmt.Errorf("encode attributes for %s: %w", e.entityID, err)
+	}
+	shared := string(raw)
+
+	attributesID, ok := w.attributes[shared]
+	if !ok {
+		res, err := w.insertAttr.ExecContext(ctx, fixtureHash(shared), shared)
+		if err != nil {
+			return fmt.Errorf("write state_attributes: %w", err)
+		}
+		if attributesID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		w.attributes[shared] = attributesID
+	}
+
+	if state != e.lastState || e.lastStateID == 0 {
+		e.lastChanged = ts
+	}
+	var oldStateID sql.NullInt64
+	if e.lastStateID != 0 {
+		oldStateID = sql.NullInt64{Int64: e.lastStateID, Valid: true}
+	}
+	res, err := w.insertStat.ExecContext(ctx, state, e.lastChanged, ts, oldStateID, attributesID, e.metadataID)
+	if err != nil {
+		return fmt.Errorf("write states: %w", err)
+	}
+	if e.lastStateID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+	e.lastState = state
+	w.rows++
+
+	w.pending++
+	if w.pending >= fixtureCommitEvery {
+		if err := w.commit(); err != nil {
+			return err
+		}
+		return w.begin(ctx)
+	}
+	return nil
+}
+
+func (w *fixtureWriter) commit() error {
+	w.pending = 0
+	if err := w.tx.Commit(); err != nil {
+		return fmt.Errorf("commit fixture transaction: %w", err)
+	}
+	w.tx = nil
+	return nil
+}
+
+func (w *fixtureWriter) rollback() {
+	if w.tx != nil {
+		_ = w.tx.Rollback()
+	}
+}
+
+// fixtureHash is a 32-bit FNV-1a hash standing in for the recorder's attribute hash.
+func fixtureHash(s string) int64 {
+	h := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= 16777619
+	}
+	return int64(h)
+}
+
+// newFixtureEntities cycles through entity kinds until n entities exist. sun.sun and weather.home
+// appear once; everything else is numbered.
+func newFixtureEntities(n int, rng *rand.Rand) []*fixtureEntity {
+	kinds := []func(i int) []*fixtureEntity{
+		func(i int) []*fixtureEntity { return fixturePlug(i, rng) },
+		func(i int) []*fixtureEntity { return fixtureRoom(i, rng) },
+		func(i int) []*fixtureEntity { return fixturePhone(i, rng) },
+	}
+
+	entities := []*fixtureEntity{fixtureSun(), fixtureWeather(rng)}
+	for i := 0; len(entities) < n; i++ {
+		entities = append(entities, kinds[i%len(kinds)](i/len(kinds)+1)...)
+	}
+	return entities[:n]
+}
+
+func fixtureFloat(v float64, decimals int) string {
+	return strconv.FormatFloat(v, 'f', decimals, 64)
+}
+
+// fixtureDaily returns a 0..1 wave peaking mid-afternoon.
+func fixtureDaily(t time.Time) float64 {
+	hour := float64(t.Hour()) + float64(t.Minute())/60
+	return (1 + math.Sin(2*math.Pi*(hour-9)/24)) / 2
+}
+
+// fixtureAway reports whether people are out: weekday office hours.
+func fixtureAway(t time.Time, offset int) bool {
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	hour := t.Hour()
+	return hour >= 8+offset%2 && hour < 17+offset%3
+}
+
+// fixturePlug generates smart socket power, voltage, and current sensors.
+func fixturePlug(i int, rng *rand.Rand) []*fixtureEntity {
+	slug := fmt.Sprintf("plug_%d", i)
+	power := func(t time.Time) float64 {
+		if fixtureDaily(t) < 0.3 {
+			return 0.4 + rng.Float64()*0.2 // standby
+		}
+		return 20 + 80*fixtureDaily(t) + rng.NormFloat64()*3
+	}
+	return []*fixtureEntity{
+		{
+			entityID: "sensor." + slug + "_power",
+			sample: func(t time.Time) (string, map[string]any, bool) {
+				return fixtureFloat(power(t), 1), map[string]any{
+					"unit_of_measurement": "W",
+					"device_class":        "power",
+					"state_class":         "measurement",
+					"friendly_name":       fmt.Sprintf("Plug %d Power", i),
+				}, true
+			},
+		},
+		{
+			entityID: "sensor." + slug + "_voltage",
+			sample: func(t time.Time) (string, map[string]any, bool) {
+				return fixtureFloat(230+rng.NormFloat64()*1.5, 1), map[string]any{
+					"unit_of_measurement": "V",
+					"device_class":        "voltage",
+					"state_class":         "measurement",
+					"friendly_name":       fmt.Sprintf("Plug %d Voltage", i),
+				}, true
+			},
+		},
+		{
+			entityID: "sensor." + slug + "_current",
+			sample: func(t time.Time) (string, map[string]any, bool) {
+				return fixtureFloat(power(t)/230, 3), map[string]any{
+					"unit_of_measurement": "A",
+					"device_class":        "current",
+					"state_class":         "measurement",
+					"friendly_name":       fmt.Sprintf("Plug %d Current", i),
+				}, true
+			},
+		},
+	}
+}
+
+// fixtureRoom generates a temperature and a humidity sensor.
+func fixtureRoom(i int, rng *rand.Rand) []*fixtureEntity {
+	slug := fmt.Sprintf("room_%d", i)
+	return []*fixtureEntity{
+		{
+			entityID: "sensor." + slug + "_temperature",
+			sample: func(t time.Time) (string, map[string]any, bool) {
+				return fixtureFloat(19+4*fixtureDaily(t)+rng.NormFloat64()*0.2, 1), map[string]any{
+					"unit_of_measurement": "°C",
+					"device_class":        "temperature",
+					"state_class":         "measurement",
+					"friendly_name":       fmt.Sprintf("Room %d Temperature", i),
+				}, true
+			},
+		},
+		{
+			entityID: "sensor." + slug + "_humidity",
+			sample: func(t time.Time) (string, map[string]any, bool) {
+				return fixtureFloat(55-12*fixtureDaily(t)+rng.NormFloat64(), 0), map[string]any{
+					"unit_of_measurement": "%",
+					"device_class":        "humidity",
+					"state_class":         "measurement",
+					"friendly_name":       fmt.Sprintf("Room %d Humidity", i),
+				}, true
+			},
+		},
+	}
+}
+
+// fixturePhone generates a GPS device tracker, its battery sensor, and the person carrying it.
+func fixturePhone(i int, rng *rand.Rand) []*fixtureEntity {
+	slug := fmt.Sprintf("phone_%d", i)
+	battery := 100.0
+	lat, lon := fixtureHomeLatitude, fixtureHomeLongitude
+
+	level := func() float64 {
+		battery -= 0.002 + rng.Float64()*0.004
+		if battery < 15 {
+			battery = 100
+		}
+		return math.Round(battery)
+	}
+
+	return []*fixtureEntity{
+		{
+			entityID: "device_tracker." + slug,
+			sample: func(t time.Time) (string, map[string]any, bool) {
+				state := "home"
+				if fixtureAway(t, i) {
+					state = "not_home"
+					lat += rng.NormFloat64() * 0.0005
+					lon += rng.NormFloat64() * 0.0005
+				} else {
+					lat, lon = fixtureHomeLatitude, fixtureHomeLongitude
+				}
+				return state, map[string]any{
+					"source_type":   "gps",
+					"latitude":      math.Round(lat*1e6) / 1e6,
+					"longitude":     math.Round(lon*1e6) / 1e6,
+					"gps_accuracy":  5 + rng.Intn(20),
+					"battery_level": battery,
+					"friendly_name": fmt.Sprintf("Phone %d", i),
+				}, true
+			},
+		},
+		{
+			entityID: "sensor." + slug + "_battery_level",
+			sample: func(t time.Time) (string, map[string]any, bool) {
+				return fixtureFloat(level(), 0), map[string]any{
+					"unit_of_measurement": "%",
+					"device_class":        "battery",
+					"state_class":         "measurement",
+					"friendly_name":       fmt.Sprintf("Phone %d Battery Level", i),
+				}, true
+			},
+		},
+		fixturePerson(i),
+	}
+}
+
+// fixturePerson only records zone changes, like the recorder does for unchanged states.
+func fixturePerson(i int) *fixtureEntity {
+	last := ""
+	return &fixtureEntity{
+		entityID: fmt.Sprintf("person.person_%d", i),
+		sample: func(t time.Time) (string, map[string]any, bool) {
+			state := "home"
+			if fixtureAway(t, i) {
+				state = "Work"
+				if i%2 == 0 {
+					state = "not_home"
+				}
+			}
+			if state == last {
+				return "", nil, false
+			}
+			last = state
+			return state, map[string]any{
+				"device_trackers": []string{fmt.Sprintf("device_tracker.phone_%d", i)},
+				"friendly_name":   fmt.Sprintf("Person %d", i),
+			}, true
+		},
+	}
+}
+
+func fixtureSun() *fixtureEntity {
+	return &fixtureEntity{
+		entityID: "sun.sun",
+		sample: func(t time.Time) (string, map[string]any, bool) {
+			hour := float64(t.Hour()) + float64(t.Minute())/60
+			elevation := 45 * math.Sin(2*math.Pi*(hour-6)/24)
+			state := "above_horizon"
+			if elevation < 0 {
+				state = "below_horizon"
+			}
+			return state, map[string]any{
+				"elevation":     math.Round(elevation*100) / 100,
+				"azimuth":       math.Round(hour/24*36000) / 100,
+				"rising":        hour < 12,
+				"friendly_name": "Sun",
+			}, true
+		},
+	}
+}
+
+func fixtureWeather(rng *rand.Rand) *fixtureEntity {
+	conditions := []string{"sunny", "partlycloudy", "cloudy", "rainy"}
+	return &fixtureEntity{
+		entityID: "weather.home",
+		sample: func(t time.Time) (string, map[string]any, bool) {
+			cloud := 100 * (1 - fixtureDaily(t.Add(time.Duration(t.YearDay())*time.Hour)))
+			return conditions[int(cloud)*len(conditions)/101], map[string]any{
+				"temperature":    math.Round((8+10*fixtureDaily(t)+rng.NormFloat64()*0.3)*10) / 10,
+				"humidity":       math.Round(60 + 20*cloud/100),
+				"pressure":       math.Round((1013+rng.NormFloat64()*2)*10) / 10,
+				"wind_speed":     math.Round(rng.Float64()*200) / 10,
+				"cloud_coverage": math.Round(cloud),
+				"uv_index":       math.Round(6*fixtureDaily(t)*(1-cloud/100)*10) / 10,
+				"friendly_name":  "Home",
+			}, true
+		},
+	}
+}
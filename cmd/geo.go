@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const earthRadiusMeters = 6371008.8
+
+// haversineMeters returns the great-circle distance between two WGS84 coordinates.
+func haversineMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// parseDistance parses a distance such as "10m", "0.5km", or "25" (meters) into meters.
+func parseDistance(raw string) (float64, error) {
+	trimmed := strings.TrimSpace(strings.ToLower(raw))
+	scale := 1.0
+	switch {
+	case strings.HasSuffix(trimmed, "km"):
+		trimmed, scale = strings.TrimSuffix(trimmed, "km"), 1000
+	case strings.HasSuffix(trimmed, "m"):
+		trimmed = strings.TrimSuffix(trimmed, "m")
+	}
+
+	v, err := strconv.ParseFloat(strings.TrimSpace(trimmed), 64)
+	if err != nil || v < 0 || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid distance %q (use e.g. 10m or 0.5km)", raw)
+	}
+	return v * scale, nil
+}
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// maxGeohashPrecision is the longest geohash encoded (~3.7cm x 1.9cm cells).
+const maxGeohashPrecision = 12
+
+// encodeGeohash returns the geohash of a coordinate with the given number of characters.
+func encodeGeohash(lat, lon float64, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lonRange := [2]float64{-180, 180}
+
+	hash := make([]byte, 0, precision)
+	bit, ch := 0, 0
+	even := true
+	for len(hash) < precision {
+		if even {
+			mid := (lonRange[0] + lonRange[1]) / 2
+			if lon >= mid {
+				ch |= 1 << (4 - bit)
+				lonRange[0] = mid
+			} else {
+				lonRange[1] = mid
+			}
+		} else {
+			mid := (latRange[0] + latRange[1]) / 2
+			if lat >= mid {
+				ch |= 1 << (4 - bit)
+				latRange[0] = mid
+			} else {
+				latRange[1] = mid
+			}
+		}
+		even = !even
+
+		if bit < 4 {
+			bit++
+			continue
+		}
+		hash = append(hash, geohashAlphabet[ch])
+		bit, ch = 0, 0
+	}
+	return string(hash)
+}
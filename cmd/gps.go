@@ -17,8 +17,10 @@ import (
 )
 
 var (
-	gpsSQLitePath string
-	gpsMySQLDSN   string
+	gpsSQLitePaths []string
+	gpsMySQLDSN    string
+	gpsMinMovement string
+	gpsOptions     gpsExportOptions
 )
 
 // gpsCmd migrates GPS state data from Home Assistant's recorder database into MySQL.
@@ -27,62 +29,170 @@ var gpsCmd = &cobra.Command{
 	Short: "Export Home Assistant GPS entries into MySQL",
 	Long:  "Reads latitude and longitude updates from the Home Assistant SQLite recorder database and upserts them into a MySQL table for external consumption.",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if gpsSQLitePath == "" {
+		if len(gpsSQLitePaths) == 0 {
 			return errors.New("sqlite database path is required")
 		}
 		if gpsMySQLDSN == "" {
 			return errors.New("mysql dsn is required")
 		}
 
+		var err error
+		if gpsOptions.minMovement, err = parseDistance(gpsMinMovement); err != nil {
+			return fmt.Errorf("--min-movement: %w", err)
+		}
+		if gpsOptions.spatial && !destDialect.spatial {
+			return fmt.Errorf("--spatial is not supported by the %s dialect", destDialect.name)
+		}
+		if gpsOptions.anonymize != "" && onError == onErrorCollect {
+			return errors.New("--anonymize cannot be combined with --on-error=collect, which stores the raw rows in ha_tools_rejects")
+		}
+		if gpsOptions.encrypt {
+			switch {
+			case onError == onErrorCollect:
+				return errors.New("--encrypt cannot be combined with --on-error=collect, which stores the raw rows in ha_tools_rejects")
+			case gpsOptions.spatial, gpsOptions.geohashPrecision > 0, gpsOptions.mapMatchURL != "":
+				return errors.New("--encrypt cannot be combined with --spatial, --geohash-precision, or --map-match-url, which store plaintext positions")
+			}
+		}
+		if gpsOptions.geohashPrecision < 0 || gpsOptions.geohashPrecision > maxGeohashPrecision {
+			return fmt.Errorf("--geohash-precision must be between 0 and %d", maxGeohashPrecision)
+		}
+
 		ctx := cmd.Context()
 		if ctx == nil {
 			ctx = context.Background()
 		}
 
-		return transferGPSData(ctx, gpsSQLitePath, gpsMySQLDSN)
+		return forEachRecorder(ctx, gpsSQLitePaths, func(sqlitePath string) error {
+			return transferGPSData(ctx, sqlitePath, gpsMySQLDSN, gpsOptions)
+		})
 	},
 }
 
 func init() {
-	gpsCmd.Flags().StringVar(&gpsSQLitePath, "sqlite", "", "Path to the Home Assistant SQLite recorder database")
+	gpsCmd.Flags().StringArrayVar(&gpsSQLitePaths, "sqlite", nil, recorderFlagUsage)
 	gpsCmd.Flags().StringVar(&gpsMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	gpsCmd.Flags().StringVar(&gpsMinMovement, "min-movement", "0", "Skip points closer than this distance (e.g. 10m, 0.5km) to the entity's previously exported point")
+	gpsCmd.Flags().IntVar(&gpsOptions.geohashPrecision, "geohash-precision", 0, "Fill an indexed geohash column with this many characters (1-12, 0 disables)")
+	gpsCmd.Flags().BoolVar(&gpsOptions.spatial, "spatial", false, "Also store coordinates in a spatially indexed POINT SRID 4326 location column")
+	gpsCmd.Flags().StringVar(&gpsOptions.mapMatchURL, "map-match-url", "", "OSRM-compatible match service prefix (e.g. http://localhost:5000/match/v1/driving); fills matched_latitude/matched_longitude")
+	gpsCmd.Flags().StringVar(&gpsOptions.anonymize, "anonymize", "", "Fuzz coordinates and pseudonymize entity_ids with this profile from the config's anonymize section")
+	gpsCmd.Flags().BoolVar(&gpsOptions.encrypt, "encrypt", false, "Store latitude/longitude AES-GCM encrypted with the key from --encryption-key-file (read them back with decrypt)")
+	addOwnerFlags(gpsCmd)
 	_ = gpsCmd.MarkFlagRequired("sqlite")
 	_ = gpsCmd.MarkFlagRequired("dsn")
 
 	rootCmd.AddCommand(gpsCmd)
 }
 
-func transferGPSData(ctx context.Context, sqlitePath, mysqlDSN string) error {
-	mysqlDSN = ensureParseTimeEnabled(mysqlDSN)
-	if err := maybeRegisterTiDBTLS(mysqlDSN); err != nil {
-		return fmt.Errorf("configure mysql tls: %w", err)
+// gpsExportOptions toggles optional GPS processing.
+type gpsExportOptions struct {
+	// minMovement skips points within this many meters of the entity's previously exported point.
+	minMovement float64
+	// mapMatchURL, when set, snaps each entity's trace to roads through an OSRM-compatible service.
+	mapMatchURL string
+	// geohashPrecision, when positive, fills the geohash column with that many characters.
+	geohashPrecision int
+	// spatial fills the location POINT column next to the latitude/longitude doubles.
+	spatial bool
+	// anonymize names the config profile applied to every row before it is written.
+	anonymize string
+	// encrypt stores latitude/longitude AES-GCM encrypted in VARBINARY columns.
+	encrypt bool
+}
+
+// gpsMatchedColumns hold the snapped coordinates next to the raw ones.
+var gpsMatchedColumns = []columnSpec{
+	{name: "matched_latitude", sqlType: "DOUBLE NULL"},
+	{name: "matched_longitude", sqlType: "DOUBLE NULL"},
+}
+
+// gpsLocationColumn stores each point as WKT written in longitude-latitude order; existing rows are
+// backfilled from their latitude/longitude.
+var gpsLocationColumn = columnSpec{
+	name:      "location",
+	sqlType:   "POINT NOT NULL SRID 4326",
+	valueExpr: "ST_GeomFromText(?, 4326, 'axis-order=long-lat')",
+	backfill:  "ST_GeomFromText(CONCAT('POINT(', longitude, ' ', latitude, ')'), 4326, 'axis-order=long-lat')",
+}
+
+// table returns gps_points with the optional columns the options fill.
+func (o gpsExportOptions) table() *tableSpec {
+	table := gpsPointsTable
+	if o.encrypt {
+		table = table.withColumnType("latitude", encryptedCoordinateType).
+			withColumnType("longitude", encryptedCoordinateType)
+	}
+	if o.mapMatchURL != "" {
+		table = table.withColumns(gpsMatchedColumns...)
+	}
+	if o.spatial {
+		table = table.withColumns(gpsLocationColumn).
+			withIndexes(indexSpec{name: "idx_gps_points_location", columns: []string{"location"}, spatial: true})
 	}
+	if o.geohashPrecision > 0 {
+		table = table.withColumns(columnSpec{name: "geohash", sqlType: fmt.Sprintf("VARCHAR(%d) NULL", maxGeohashPrecision)}).
+			withIndexes(indexSpec{name: "idx_gps_points_geohash", columns: []string{"geohash"}})
+	}
+	return table
+}
+
+// gpsRow is one GPS state on its way to the destination.
+type gpsRow struct {
+	stateID     int64
+	entityID    string
+	state       string
+	latitude    sql.NullFloat64
+	longitude   sql.NullFloat64
+	accuracy    sql.NullFloat64
+	lastUpdated sql.NullTime
+}
 
-	sqliteDB, err := sql.Open("sqlite", sqlitePath)
+// transferGPSData exports every GPS state. With minMovement set, the walk starts from each entity's
+// first point, so repeated runs export the same subset.
+func transferGPSData(ctx context.Context, sqlitePath, mysqlDSN string, opts gpsExportOptions) error {
+	owners, err := newOwnerFilter(sqlitePath)
 	if err != nil {
-		return fmt.Errorf("open sqlite database: %w", err)
+		return err
 	}
-	defer sqliteDB.Close()
-	sqliteDB.SetMaxOpenConns(1)
 
-	if err := sqliteDB.PingContext(ctx); err != nil {
-		return fmt.Errorf("ping sqlite database: %w", err)
+	sqliteDB, err := openRecorder(ctx, sqlitePath)
+	if err != nil {
+		return err
 	}
+	defer sqliteDB.Close()
 
-	mysqlDB, err := sql.Open("mysql", mysqlDSN)
+	sink, err := openExportSink(ctx, mysqlDSN)
 	if err != nil {
-		return fmt.Errorf("open mysql database: %w", err)
+		return err
 	}
-	defer mysqlDB.Close()
+	defer sink.Close()
 
-	if err := mysqlDB.PingContext(ctx); err != nil {
-		return fmt.Errorf("ping mysql database: %w", err)
+	var coordinates *coordinateCipher
+	if opts.encrypt {
+		if coordinates, err = newCoordinateCipher(); err != nil {
+			return err
+		}
+	}
+	if err := checkCoordinateEncryption(ctx, sink, gpsPointsTable.name, opts.encrypt); err != nil {
+		return err
 	}
 
-	if err := ensureGPSPointsTable(ctx, mysqlDB); err != nil {
+	table := opts.table()
+	if err := sink.EnsureSchema(ctx, table); err != nil {
 		return fmt.Errorf("ensure gps_points table: %w", err)
 	}
 
+	anonymizer, err := newGPSAnonymizer(opts.anonymize)
+	if err != nil {
+		return err
+	}
+
+	var matcher *mapMatcher
+	if opts.mapMatchURL != "" {
+		matcher = newMapMatcher(opts.mapMatchURL)
+	}
+
 	const query = `
 SELECT
     s.state_id,
@@ -95,6 +205,7 @@ JOIN state_attributes sa ON s.attributes_id = sa.attributes_id
 JOIN states_meta sm ON s.metadata_id = sm.metadata_id
 WHERE sa.shared_attrs LIKE '%"latitude"%'
   AND sa.shared_attrs LIKE '%"longitude"%'
+ORDER BY sm.entity_id, s.last_updated_ts
 `
 
 	rows, err := sqliteDB.QueryContext(ctx, query)
@@ -103,50 +214,71 @@ WHERE sa.shared_attrs LIKE '%"latitude"%'
 	}
 	defer rows.Close()
 
-	const upsertPrefix = `
-INSERT INTO gps_points(
-    state_id, entity_id, state, latitude, longitude, gps_accuracy, last_updated
-) VALUES`
-	const upsertSuffix = `
-ON DUPLICATE KEY UPDATE
-    entity_id = VALUES(entity_id),
-    state = VALUES(state),
-    latitude = VALUES(latitude),
-    longitude = VALUES(longitude),
-    gps_accuracy = VALUES(gps_accuracy),
-    last_updated = VALUES(last_updated)
-`
-
 	const gpsBatchSize = 500
 
-	var (
-		args          []any
-		valueSegments strings.Builder
-		rowCount      int
-	)
-	valueSegments.Grow(256)
+	writer := newBatchWriter(sink, table, gpsBatchSize)
 
-	flushBatch := func() error {
-		if rowCount == 0 {
-			return nil
+	// pending holds one entity's points until a map-match window is full.
+	var pending []gpsRow
+	flushPending := func() error {
+		var matched []matchedPoint
+		if matcher != nil && len(pending) > 0 {
+			var err error
+			if matched, err = matcher.Match(ctx, pending); err != nil {
+				return fmt.Errorf("map-match %s: %w", pending[0].entityID, err)
+			}
 		}
-
-		var queryBuilder strings.Builder
-		queryBuilder.Grow(len(upsertPrefix) + valueSegments.Len() + len(upsertSuffix) + 1)
-		queryBuilder.WriteString(upsertPrefix)
-		queryBuilder.WriteString(valueSegments.String())
-		queryBuilder.WriteByte('\n')
-		queryBuilder.WriteString(upsertSuffix)
-
-		if _, err := mysqlDB.ExecContext(ctx, queryBuilder.String(), args...); err != nil {
-			return fmt.Errorf("upsert mysql rows: %w", err)
+		for i, r := range pending {
+			if anonymizer != nil {
+				var m *matchedPoint
+				if matcher != nil {
+					m = &matched[i]
+				}
+				if err := anonymizer.Apply(&r, m); err != nil {
+					return err
+				}
+			}
+			var latitude, longitude any = r.latitude, r.longitude
+			if coordinates != nil {
+				var err error
+				if latitude, err = coordinates.Seal(r.stateID, r.entityID, "latitude", r.latitude.Float64); err != nil {
+					return err
+				}
+				if longitude, err = coordinates.Seal(r.stateID, r.entityID, "longitude", r.longitude.Float64); err != nil {
+					return err
+				}
+			}
+			values := []any{
+				r.stateID,
+				r.entityID,
+				r.state,
+				latitude,
+				longitude,
+				r.accuracy,
+				r.lastUpdated,
+			}
+			if matcher != nil {
+				values = append(values, matched[i].latitude, matched[i].longitude)
+			}
+			if opts.spatial {
+				values = append(values, fmt.Sprintf("POINT(%s %s)",
+					strconv.FormatFloat(r.longitude.Float64, 'f', -1, 64),
+					strconv.FormatFloat(r.latitude.Float64, 'f', -1, 64)))
+			}
+			if opts.geohashPrecision > 0 {
+				values = append(values, encodeGeohash(r.latitude.Float64, r.longitude.Float64, opts.geohashPrecision))
+			}
+			if err := writer.Add(ctx, values...); err != nil {
+				return err
+			}
 		}
-
-		valueSegments.Reset()
-		args = args[:0]
-		rowCount = 0
+		pending = pending[:0]
 		return nil
 	}
+
+	type exportedPoint struct{ lat, lon float64 }
+	lastPoints := make(map[string]exportedPoint)
+
 	for rows.Next() {
 		var (
 			stateID        int64
@@ -159,126 +291,96 @@ ON DUPLICATE KEY UPDATE
 		if err := rows.Scan(&stateID, &entityID, &state, &lastUpdatedVal, &attributesJSON); err != nil {
 			return fmt.Errorf("scan sqlite row: %w", err)
 		}
+		if !owners.Allows(entityID) {
+			filteredRows.add(table.name, 1)
+			continue
+		}
 
-		latitude, longitude, accuracy, err := extractCoordinates(attributesJSON)
+		lastUpdated, err := floatToNullTime(lastUpdatedVal)
 		if err != nil {
-			return fmt.Errorf("parse attributes for state_id %d: %w", stateID, err)
-		}
-		if !latitude.Valid || !longitude.Valid {
+			if err := writer.Reject(ctx, rejectedRow{stateID, entityID, state, lastUpdatedVal, attributesJSON}, fmt.Errorf("convert last_updated_ts for state_id %d: %w", stateID, err)); err != nil {
+				return err
+			}
 			continue
 		}
 
-		lastUpdated, err := floatToNullTime(lastUpdatedVal)
+		latitude, longitude, accuracy, err := extractCoordinates(attributesJSON)
 		if err != nil {
-			return fmt.Errorf("convert last_updated_ts for state_id %d: %w", stateID, err)
+			if err := writer.Reject(ctx, rejectedRow{stateID, entityID, state, lastUpdatedVal, attributesJSON}, fmt.Errorf("parse attributes for state_id %d: %w", stateID, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		observeAlerts(ctx, entityID, state, sql.NullFloat64{}, lastUpdated)
+		if !latitude.Valid || !longitude.Valid {
+			filteredRows.add(table.name, 1)
+			continue
 		}
 
-		if rowCount > 0 {
-			valueSegments.WriteString(",")
+		if opts.minMovement > 0 {
+			if last, ok := lastPoints[entityID]; ok && haversineMeters(last.lat, last.lon, latitude.Float64, longitude.Float64) < opts.minMovement {
+				filteredRows.add(table.name, 1)
+				continue
+			}
+			lastPoints[entityID] = exportedPoint{lat: latitude.Float64, lon: longitude.Float64}
 		}
-		valueSegments.WriteString("\n    (?, ?, ?, ?, ?, ?, ?)")
-		args = append(args,
-			stateID,
-			entityID,
-			state,
-			latitude,
-			longitude,
-			accuracy,
-			lastUpdated,
-		)
-		rowCount++
 
-		if rowCount >= gpsBatchSize {
-			if err := flushBatch(); err != nil {
+		if len(pending) > 0 && (pending[0].entityID != entityID || len(pending) >= mapMatchWindow) {
+			if err := flushPending(); err != nil {
 				return err
 			}
 		}
+		pending = append(pending, gpsRow{
+			stateID:     stateID,
+			entityID:    entityID,
+			state:       state,
+			latitude:    latitude,
+			longitude:   longitude,
+			accuracy:    accuracy,
+			lastUpdated: lastUpdated,
+		})
 	}
 
 	if err := rows.Err(); err != nil {
 		return fmt.Errorf("iterate sqlite rows: %w", err)
 	}
 
-	return flushBatch()
-}
-
-func ensureGPSPointsTable(ctx context.Context, db *sql.DB) error {
-	const ddl = `
-CREATE TABLE IF NOT EXISTS gps_points (
-    state_id BIGINT PRIMARY KEY,
-    entity_id VARCHAR(255) NOT NULL,
-    state VARCHAR(255) NOT NULL,
-    latitude DOUBLE NOT NULL,
-    longitude DOUBLE NOT NULL,
-    gps_accuracy DOUBLE NULL,
-    last_updated DATETIME NULL
-)
-`
-
-	if _, err := db.ExecContext(ctx, ddl); err != nil {
+	if err := flushPending(); err != nil {
 		return err
 	}
 
-	if err := ensureGPSPointsIndexes(ctx, db); err != nil {
-		return fmt.Errorf("ensure gps_points indexes: %w", err)
+	if err := writer.Flush(ctx); err != nil {
+		return err
 	}
 
-	return nil
+	return finalizeTable(ctx, sink, table)
 }
 
-type gpsIndexInfo struct {
-	nonUnique bool
-	columns   []string
+var gpsPointsTable = &tableSpec{
+	name: "gps_points",
+	columns: []columnSpec{
+		{name: "state_id", sqlType: "BIGINT NOT NULL"},
+		{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "state", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "latitude", sqlType: "DOUBLE NOT NULL"},
+		{name: "longitude", sqlType: "DOUBLE NOT NULL"},
+		{name: "gps_accuracy", sqlType: "DOUBLE NULL"},
+		{name: "last_updated", sqlType: "DATETIME(6) NULL"},
+	},
+	primaryKey:    []string{"state_id"},
+	entityColumn:  "entity_id",
+	timeColumn:    "last_updated",
+	indexDefaults: []string{"entity-time"},
+	history:       true,
+	mysqlMigrate:  migrateGPSPointsIndexes,
 }
 
-func ensureGPSPointsIndexes(ctx context.Context, db *sql.DB) error {
-	schema, err := currentMySQLDatabase(ctx, db)
-	if err != nil {
-		return err
-	}
-
-	query := `
-SELECT INDEX_NAME, COLUMN_NAME, NON_UNIQUE, SEQ_IN_INDEX
-FROM INFORMATION_SCHEMA.STATISTICS
-WHERE TABLE_SCHEMA = ? AND TABLE_NAME = 'gps_points'
-ORDER BY INDEX_NAME, SEQ_IN_INDEX
-`
-	rows, err := db.QueryContext(ctx, query, schema)
+// migrateGPSPointsIndexes repairs gps_points tables created by releases keyed on entity_id.
+func migrateGPSPointsIndexes(ctx context.Context, db *sql.DB) error {
+	indexes, err := loadTableIndexes(ctx, db, "gps_points")
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
-
-	indexes := map[string]*gpsIndexInfo{}
-	for rows.Next() {
-		var (
-			indexName string
-			column    sql.NullString
-			nonUnique int
-			seq       int
-		)
-		if err := rows.Scan(&indexName, &column, &nonUnique, &seq); err != nil {
-			return err
-		}
-		if !column.Valid {
-			continue
-		}
-		info, ok := indexes[indexName]
-		if !ok {
-			info = &gpsIndexInfo{
-				nonUnique: nonUnique == 1,
-				columns:   []string{},
-			}
-			indexes[indexName] = info
-		}
-		if len(info.columns) < seq {
-			info.columns = append(info.columns, make([]string, seq-len(info.columns))...)
-		}
-		info.columns[seq-1] = column.String
-	}
-	if err := rows.Err(); err != nil {
-		return err
-	}
 
 	if err := ensurePrimaryKeyOnStateID(ctx, db, indexes); err != nil {
 		return err
@@ -288,14 +390,10 @@ ORDER BY INDEX_NAME, SEQ_IN_INDEX
 		return err
 	}
 
-	if err := ensureSupportingEntityIndex(ctx, db); err != nil {
-		return err
-	}
-
 	return nil
 }
 
-func ensurePrimaryKeyOnStateID(ctx context.Context, db *sql.DB, indexes map[string]*gpsIndexInfo) error {
+func ensurePrimaryKeyOnStateID(ctx context.Context, db *sql.DB, indexes map[string]*tableIndexInfo) error {
 	const (
 		mysqlErrNoSuchKey = 1091
 	)
@@ -304,21 +402,27 @@ func ensurePrimaryKeyOnStateID(ctx context.Context, db *sql.DB, indexes map[stri
 	if primary != nil && len(primary.columns) == 1 && primary.columns[0] == "state_id" {
 		return nil
 	}
+	if !destDialect.blockingAlters {
+		return errSchemaDrift("gps_points", "gps_points primary key must be (state_id); the %s dialect does not allow rewriting it in place, apply the change through your schema workflow", destDialect.name)
+	}
 
-	if _, err := db.ExecContext(ctx, "ALTER TABLE gps_points DROP PRIMARY KEY"); err != nil {
+	if err := confirm("replace the primary key of gps_points with (state_id)"); err != nil {
+		return err
+	}
+	if _, err := execStatement(ctx, db, "ALTER TABLE gps_points DROP PRIMARY KEY"); err != nil {
 		if !isMySQLError(err, mysqlErrNoSuchKey) {
 			return fmt.Errorf("drop existing primary key: %w", err)
 		}
 	}
 
-	if _, err := db.ExecContext(ctx, "ALTER TABLE gps_points ADD PRIMARY KEY (state_id)"); err != nil {
+	if _, err := execStatement(ctx, db, "ALTER TABLE gps_points ADD PRIMARY KEY (state_id)"); err != nil {
 		return fmt.Errorf("add primary key on state_id: %w", err)
 	}
 
 	return nil
 }
 
-func dropConflictingEntityIndexes(ctx context.Context, db *sql.DB, indexes map[string]*gpsIndexInfo) error {
+func dropConflictingEntityIndexes(ctx context.Context, db *sql.DB, indexes map[string]*tableIndexInfo) error {
 	for name, info := range indexes {
 		if name == "PRIMARY" || info.nonUnique {
 			continue
@@ -327,8 +431,11 @@ func dropConflictingEntityIndexes(ctx context.Context, db *sql.DB, indexes map[s
 			continue
 		}
 		if containsString(info.columns, "entity_id") {
+			if err := confirm(fmt.Sprintf("drop unique index %s on gps_points, which rejects several points per entity", name)); err != nil {
+				return err
+			}
 			stmt := fmt.Sprintf("ALTER TABLE gps_points DROP INDEX %s", quoteIdentifier(name))
-			if _, err := db.ExecContext(ctx, stmt); err != nil {
+			if _, err := execStatement(ctx, db, stmt); err != nil {
 				return fmt.Errorf("drop unique index %s: %w", name, err)
 			}
 		}
@@ -336,30 +443,15 @@ func dropConflictingEntityIndexes(ctx context.Context, db *sql.DB, indexes map[s
 	return nil
 }
 
-func ensureSupportingEntityIndex(ctx context.Context, db *sql.DB) error {
-	const mysqlErrDuplicateKey = 1061
-
-	stmt := `
-ALTER TABLE gps_points
-ADD INDEX idx_gps_points_entity_last_updated (entity_id, last_updated)
-`
-	if _, err := db.ExecContext(ctx, stmt); err != nil {
-		if !isMySQLError(err, mysqlErrDuplicateKey) {
-			return fmt.Errorf("add supporting index: %w", err)
-		}
-	}
-	return nil
-}
-
 func currentMySQLDatabase(ctx context.Context, db *sql.DB) (string, error) {
 	var schema sql.NullString
-	if err := db.QueryRowContext(ctx, "SELECT DATABASE()").Scan(&schema); err != nil {
+	if err := queryRowStatement(ctx, db, "SELECT DATABASE()", nil, &schema); err != nil {
 		return "", fmt.Errorf("detect current database: %w", err)
 	}
 	if !schema.Valid || schema.String == "" {
 		return "", errors.New("mysql dsn must select a database; none detected")
 	}
-	return schema.String, nil
+	return destDialect.schemaName(schema.String), nil
 }
 
 func isMySQLError(err error, code uint16) bool {
@@ -459,6 +551,9 @@ func pickString(v any) (string, bool) {
 	}
 }
 
+// floatToNullTime converts a recorder timestamp in float seconds to a time, rounded to the
+// microseconds the recorder records and DATETIME(6) columns keep. The float's sub-microsecond
+// noise would otherwise put a row a few nanoseconds past the watermark stored for it.
 func floatToNullTime(v sql.NullFloat64) (sql.NullTime, error) {
 	if !v.Valid {
 		return sql.NullTime{}, nil
@@ -469,11 +564,7 @@ func floatToNullTime(v sql.NullFloat64) (sql.NullTime, error) {
 		return sql.NullTime{}, errors.New("invalid float for timestamp")
 	}
 
-	loc := time.Local
-	if loc == nil {
-		loc = time.UTC
-	}
-	t := time.Unix(int64(seconds), int64(frac*1e9)).In(loc)
+	t := inExportZone(time.Unix(int64(seconds), int64(math.Round(frac*1e6))*1e3))
 	if t.IsZero() {
 		return sql.NullTime{}, nil
 	}
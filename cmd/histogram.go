@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// histogramPeriodDefault is the default --histogram-interval.
+const histogramPeriodDefault = time.Hour
+
+// histogramTable holds, per power entity and period, how long the power stayed in each band of
+// --histogram-buckets. lower_bound is NULL for the band below the first bound, upper_bound for
+// the band above the last.
+func histogramTable(f numericFamily) *tableSpec {
+	return &tableSpec{
+		name: f.name + "_histograms",
+		columns: []columnSpec{
+			{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+			{name: "period_start", sqlType: "DATETIME NOT NULL"},
+			{name: "band", sqlType: "INT NOT NULL"},
+			{name: "lower_bound", sqlType: "DOUBLE NULL"},
+			{name: "upper_bound", sqlType: "DOUBLE NULL"},
+			{name: "samples", sqlType: "INT NOT NULL"},
+			{name: "seconds", sqlType: "DOUBLE NOT NULL"},
+		},
+		primaryKey: []string{"entity_id", "period_start", "band"},
+	}
+}
+
+func validateHistogram(bounds []float64, interval time.Duration) error {
+	if len(bounds) == 0 {
+		return nil
+	}
+	for i := 1; i < len(bounds); i++ {
+		if bounds[i] <= bounds[i-1] {
+			return fmt.Errorf("--histogram-buckets must be increasing, got %v after %v", bounds[i], bounds[i-1])
+		}
+	}
+	if interval == 0 {
+		return fmt.Errorf("--histogram-interval must be set")
+	}
+	return validateResolution("--histogram-interval", interval)
+}
+
+// isPowerSample reports whether a row feeds the power histograms.
+func isPowerSample(meta stateMetadata) bool {
+	return meta.DeviceClass.String == "power"
+}
+
+// histogramBand returns the band of a value: the number of bounds at or below it.
+func histogramBand(bounds []float64, value float64) int {
+	return sort.Search(len(bounds), func(i int) bool { return bounds[i] > value })
+}
+
+// powerHistogram accumulates one entity's periods; each sample's value holds until the entity's
+// next sample, and an unavailable or non-numeric state ends it.
+type powerHistogram struct {
+	bounds   []float64
+	interval time.Duration
+	// start is the first period written; time before it only seeds the value at its start.
+	start time.Time
+
+	entityID string
+	cells    map[histogramCell]*histogramCount
+	// value is the current sample, valid until the next one.
+	value sql.NullFloat64
+	since time.Time
+}
+
+type histogramCell struct {
+	period time.Time
+	band   int
+}
+
+type histogramCount struct {
+	samples int
+	seconds float64
+}
+
+// sample moves the entity to a new state at t; value is invalid for non-numeric states.
+func (h *powerHistogram) sample(t time.Time, value sql.NullFloat64) {
+	if h.value.Valid {
+		h.spend(h.since, t)
+	}
+	h.value, h.since = value, t
+	if value.Valid && !t.Before(h.start) {
+		h.cell(bucketStart(t, h.interval), histogramBand(h.bounds, value.Float64)).samples++
+	}
+}
+
+// spend credits the current value's band with the time from..to, split at period boundaries.
+func (h *powerHistogram) spend(from, to time.Time) {
+	if from.Before(h.start) {
+		from = h.start
+	}
+	band := histogramBand(h.bounds, h.value.Float64)
+	for from.Before(to) {
+		period := bucketStart(from, h.interval)
+		end := period.Add(h.interval)
+		if to.Before(end) {
+			end = to
+		}
+		h.cell(period, band).seconds += end.Sub(from).Seconds()
+		from = end
+	}
+}
+
+func (h *powerHistogram) cell(period time.Time, band int) *histogramCount {
+	key := histogramCell{period, band}
+	c := h.cells[key]
+	if c == nil {
+		c = &histogramCount{}
+		h.cells[key] = c
+	}
+	return c
+}
+
+// flush writes the entity's cells. The time after its newest sample is counted once the next
+// sample arrives.
+func (h *powerHistogram) flush(ctx context.Context, writer *batchWriter) error {
+	keys := make([]histogramCell, 0, len(h.cells))
+	for key := range h.cells {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b histogramCell) int {
+		if c := a.period.Compare(b.period); c != 0 {
+			return c
+		}
+		return a.band - b.band
+	})
+	for _, key := range keys {
+		var lower, upper sql.NullFloat64
+		if key.band > 0 {
+			lower = sql.NullFloat64{Float64: h.bounds[key.band-1], Valid: true}
+		}
+		if key.band < len(h.bounds) {
+			upper = sql.NullFloat64{Float64: h.bounds[key.band], Valid: true}
+		}
+		c := h.cells[key]
+		seconds := math.Round(c.seconds*1000) / 1000
+		if err := writer.Add(ctx, h.entityID, key.period, key.band, lower, upper, c.samples, seconds); err != nil {
+			return err
+		}
+	}
+	h.cells = make(map[histogramCell]*histogramCount)
+	h.value = sql.NullFloat64{}
+	return nil
+}
+
+// refreshPowerHistograms recomputes the family's power histograms from the recorder for every
+// period since the given time. Each entity's newest sample before then seeds its value at the
+// start.
+func refreshPowerHistograms(ctx context.Context, sqliteDB *sql.DB, sink Sink, family numericFamily, opts numericExportOptions, since time.Time) error {
+	table := histogramTable(family)
+	if err := sink.EnsureSchema(ctx, table); err != nil {
+		return fmt.Errorf("ensure %s table: %w", table.name, err)
+	}
+	start := bucketStart(since, opts.histogramInterval)
+
+	query := `
+SELECT
+    sm.entity_id,
+    s.state,
+    s.last_updated_ts,
+    COALESCE(sa.shared_attrs, ''),
+    s.attributes_id
+FROM states s
+JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+LEFT JOIN state_attributes sa ON s.attributes_id = sa.attributes_id
+WHERE (` + family.where + `) AND (s.last_updated_ts >= ? OR s.state_id IN (
+    SELECT (
+        SELECT p.state_id FROM states p
+        WHERE p.metadata_id = m.metadata_id AND p.last_updated_ts < ?
+        ORDER BY p.last_updated_ts DESC LIMIT 1
+    )
+    FROM states_meta m
+))
+ORDER BY sm.entity_id, s.last_updated_ts
+`
+	startTS := float64(start.UnixMicro()) / 1e6
+	args := append(append([]any{}, family.args...), startTS, startTS)
+	rows, err := sqliteDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("query sqlite database for %s: %w", table.name, err)
+	}
+	defer rows.Close()
+
+	const histogramBatchSize = 500
+	writer := newBatchWriter(sink, table, histogramBatchSize)
+	h := &powerHistogram{bounds: opts.histogramBuckets, interval: opts.histogramInterval, start: start, cells: make(map[histogramCell]*histogramCount)}
+	metadata := newMetadataCache()
+	for rows.Next() {
+		var (
+			entityID, state, attributesJSON string
+			lastUpdatedVal                  sql.NullFloat64
+			attributesID                    sql.NullInt64
+		)
+		if err := rows.Scan(&entityID, &state, &lastUpdatedVal, &attributesJSON, &attributesID); err != nil {
+			return fmt.Errorf("scan sqlite row: %w", err)
+		}
+		// Rows the export rejects are left out here as well.
+		lastUpdated, err := floatToNullTime(lastUpdatedVal)
+		if err != nil || !lastUpdated.Valid {
+			continue
+		}
+		meta, err := metadata.metadata(attributesID, attributesJSON)
+		if err != nil {
+			continue
+		}
+		if entityID != h.entityID {
+			if err := h.flush(ctx, writer); err != nil {
+				return err
+			}
+			h.entityID = entityID
+		}
+		value := parseNumericState(strings.TrimSpace(state))
+		if !isPowerSample(meta) {
+			// Only power sensors are binned; for them, like an unavailable state, this ends the
+			// current value without starting another.
+			value = sql.NullFloat64{}
+		}
+		h.sample(lastUpdated.Time, value)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate sqlite rows: %w", err)
+	}
+	if err := h.flush(ctx, writer); err != nil {
+		return err
+	}
+	if err := writer.Flush(ctx); err != nil {
+		return err
+	}
+	return finalizeTable(ctx, sink, table)
+}
@@ -0,0 +1,506 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	importMySQLDSN string
+	importTable    string
+	importFormat   string
+)
+
+// importCmd bulk-loads rows produced elsewhere into an exporter's destination table.
+var importCmd = &cobra.Command{
+	Use:   "import FILE",
+	Short: "Load CSV or NDJSON rows into a destination table",
+	Long:  "Validates rows from a CSV file (with a header row) or NDJSON file (one object per row, as written by --sink ndjson) against the column types of an exporter's table and writes them through the selected sink with the same schema management and upserts the exporters use, so data exported elsewhere can be merged. FILE \"-\" reads stdin. Columns missing from the input are written as NULL; rows failing validation follow --on-error.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if importMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+		entry, ok := importableTables()[importTable]
+		if !ok {
+			return fmt.Errorf("unknown --table %q (supported: %s)", importTable, strings.Join(importableTableNames(), ", "))
+		}
+		format := importFormat
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(args[0])), ".")
+		}
+		if format == "jsonl" {
+			format = "ndjson"
+		}
+		if format != "csv" && format != "ndjson" {
+			return errors.New("--format must be csv or ndjson")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		var in io.Reader = os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return fmt.Errorf("open input: %w", err)
+			}
+			defer f.Close()
+			in = f
+		}
+
+		sink, err := openSink(ctx, sinkName, importMySQLDSN)
+		if err != nil {
+			return err
+		}
+		defer sink.Close()
+
+		var reader importReader
+		if format == "csv" {
+			reader, err = newCSVImportReader(in)
+		} else {
+			reader = newNDJSONImportReader(in)
+		}
+		if err != nil {
+			return err
+		}
+
+		imported, skipped, err := importRows(ctx, sink, entry, reader)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "imported %d row(s) into %s", imported, importTable)
+		if skipped > 0 {
+			fmt.Fprintf(cmd.OutOrStdout(), ", skipped %d", skipped)
+		}
+		fmt.Fprintln(cmd.OutOrStdout())
+		return nil
+	},
+}
+
+func init() {
+	importCmd.Flags().StringVar(&importMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	importCmd.Flags().StringVar(&importTable, "table", "", fmt.Sprintf("Destination table (%s)", strings.Join(importableTableNames(), ", ")))
+	importCmd.Flags().StringVar(&importFormat, "format", "", "Input format, csv or ndjson; defaults to the file extension")
+	_ = importCmd.MarkFlagRequired("dsn")
+	_ = importCmd.MarkFlagRequired("table")
+
+	rootCmd.AddCommand(importCmd)
+}
+
+// importableTable is a table import can load: its base layout plus optional columns (those of
+// --with-delta, --map-match) added when the input has them.
+type importableTable struct {
+	table    *tableSpec
+	optional []columnSpec
+}
+
+func importableTables() map[string]importableTable {
+	tables := map[string]importableTable{
+		gpsPointsTable.name:      {table: gpsPointsTable, optional: gpsMatchedColumns},
+		batteryPointsTable.name:  {table: batteryPointsTable},
+		batteryDailyTable.name:   {table: batteryDailyTable},
+		weatherPointsTable.name:  {table: weatherPointsTable},
+		presencePointsTable.name: {table: presencePointsTable},
+		statisticsMetaTable.name: {table: statisticsMetaTable},
+		"statistics_points":      {table: statisticsPointsTable("statistics_points")},
+		"statistics_short_term_points": {
+			table: statisticsPointsTable("statistics_short_term_points"),
+		},
+	}
+	for _, name := range []string{"energy", "climate"} {
+		points := numericFamily{name: name}.pointsTable()
+		tables[points.name] = importableTable{table: points, optional: numericDeltaColumns}
+	}
+	return tables
+}
+
+func importableTableNames() []string {
+	var names []string
+	for name := range importableTables() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolve returns the table layout matching the input's columns and, for each write column, the
+// input column holding it (-1 when absent).
+func (t importableTable) resolve(header []string) (*tableSpec, []int, error) {
+	table := t.table
+	present := make(map[string]int, len(header))
+	for i, name := range header {
+		present[name] = i
+	}
+	if _, ok := present["state_id"]; ok {
+		// Numeric state_ids are AUTO_INCREMENT unless the rows bring their own.
+		table = withWrittenStateID(table)
+	}
+	for _, c := range t.optional {
+		if _, ok := present[c.name]; ok {
+			table = table.withColumns(c)
+		}
+	}
+
+	known := make(map[string]bool, len(table.columns))
+	for _, c := range table.columns {
+		known[c.name] = true
+	}
+	var unknown []string
+	for _, name := range header {
+		if !known[name] && name != "table" {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, nil, fmt.Errorf("%s has no column(s) %s", table.name, strings.Join(unknown, ", "))
+	}
+
+	var (
+		sources []int
+		missing []string
+	)
+	for _, c := range table.columns {
+		if c.generated {
+			continue
+		}
+		i, ok := present[c.name]
+		if !ok {
+			i = -1
+			if strings.Contains(c.sqlType, "NOT NULL") {
+				missing = append(missing, c.name)
+			}
+		}
+		sources = append(sources, i)
+	}
+	if len(missing) > 0 {
+		return nil, nil, fmt.Errorf("input lacks required %s column(s) %s", table.name, strings.Join(missing, ", "))
+	}
+	return table, sources, nil
+}
+
+// importField is one input value; null distinguishes JSON null and empty CSV fields.
+type importField struct {
+	raw  string
+	null bool
+}
+
+// importReader yields input rows as fields named by header.
+type importReader interface {
+	// Header returns the column names, which for NDJSON grow as new members are seen.
+	Header() []string
+	// Next returns the next row's fields in Header order, its line number, or io.EOF.
+	Next() ([]importField, int, error)
+}
+
+type csvImportReader struct {
+	r      *csv.Reader
+	header []string
+}
+
+func newCSVImportReader(in io.Reader) (*csvImportReader, error) {
+	r := csv.NewReader(in)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+	return &csvImportReader{r: r, header: header}, nil
+}
+
+func (c *csvImportReader) Header() []string { return c.header }
+
+func (c *csvImportReader) Next() ([]importField, int, error) {
+	record, err := c.r.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, parseErr.Line, err
+		}
+		return nil, 0, err
+	}
+	line, _ := c.r.FieldPos(0)
+	fields := make([]importField, len(record))
+	for i, v := range record {
+		fields[i] = importField{raw: v, null: v == ""}
+	}
+	return fields, line, nil
+}
+
+type ndjsonImportReader struct {
+	scanner *bufio.Scanner
+	line    int
+	header  []string
+	index   map[string]int
+}
+
+func newNDJSONImportReader(in io.Reader) *ndjsonImportReader {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &ndjsonImportReader{scanner: scanner, index: make(map[string]int)}
+}
+
+// Header lists every member seen so far, in order of first appearance.
+func (n *ndjsonImportReader) Header() []string { return n.header }
+
+func (n *ndjsonImportReader) Next() ([]importField, int, error) {
+	for n.scanner.Scan() {
+		n.line++
+		raw := strings.TrimSpace(n.scanner.Text())
+		if raw == "" {
+			continue
+		}
+		dec := json.NewDecoder(strings.NewReader(raw))
+		dec.UseNumber()
+		var object map[string]any
+		if err := dec.Decode(&object); err != nil {
+			return nil, n.line, fmt.Errorf("line %d: %w", n.line, err)
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if _, ok := n.index[name]; !ok {
+				n.index[name] = len(n.header)
+				n.header = append(n.header, name)
+			}
+		}
+		fields := make([]importField, len(n.header))
+		for i := range fields {
+			fields[i].null = true
+		}
+		for name, v := range object {
+			f := &fields[n.index[name]]
+			switch v := v.(type) {
+			case nil:
+			case string:
+				*f = importField{raw: v}
+			case json.Number:
+				*f = importField{raw: v.String()}
+			case bool:
+				*f = importField{raw: strconv.FormatBool(v)}
+			default:
+				return nil, n.line, fmt.Errorf("line %d: %s must be a string, number, boolean, or null", n.line, name)
+			}
+		}
+		return fields, n.line, nil
+	}
+	if err := n.scanner.Err(); err != nil {
+		return nil, n.line, err
+	}
+	return nil, n.line, io.EOF
+}
+
+// importRows validates and writes every row of reader, returning the written and skipped counts.
+func importRows(ctx context.Context, sink Sink, entry importableTable, reader importReader) (int, int, error) {
+	const importBatchSize = 500
+
+	var (
+		table    *tableSpec
+		sources  []int
+		columns  []columnSpec
+		writer   *batchWriter
+		resolved int
+		imported int
+		skipped  int
+	)
+	for {
+		fields, line, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return imported, skipped, fmt.Errorf("read input: %w", err)
+		}
+
+		if header := reader.Header(); table == nil || len(header) != resolved {
+			// NDJSON rows may introduce members later; re-resolve so they are checked too.
+			next, nextSources, err := entry.resolve(header)
+			if err != nil {
+				return imported, skipped, fmt.Errorf("line %d: %w", line, err)
+			}
+			if table != nil && len(next.writeColumns()) != len(table.writeColumns()) {
+				return imported, skipped, fmt.Errorf("line %d: rows must all have the same optional columns", line)
+			}
+			if table == nil {
+				if err := sink.EnsureSchema(ctx, next); err != nil {
+					return imported, skipped, fmt.Errorf("ensure %s table: %w", next.name, err)
+				}
+				writer = newBatchWriter(sink, next, importBatchSize)
+				columns = nil
+				for _, c := range next.columns {
+					if !c.generated {
+						columns = append(columns, resolveColumn(c))
+					}
+				}
+			}
+			table, sources, resolved = next, nextSources, len(header)
+		}
+		values := make([]any, len(columns))
+		var rowErr error
+		if i := indexOf(reader.Header(), "table"); i >= 0 && i < len(fields) && !fields[i].null && fields[i].raw != entry.table.name {
+			rowErr = fmt.Errorf("line %d: row belongs to table %s", line, fields[i].raw)
+		}
+		for i, c := range columns {
+			if rowErr != nil {
+				break
+			}
+			field := importField{null: true}
+			if src := sources[i]; src >= 0 && src < len(fields) {
+				field = fields[src]
+			}
+			if values[i], rowErr = parseImportValue(c, field); rowErr != nil {
+				rowErr = fmt.Errorf("line %d: %s: %w", line, c.name, rowErr)
+				break
+			}
+		}
+		if rowErr != nil {
+			var entityID string
+			if i := indexOfColumn(columns, table.entityColumn); i >= 0 && values[i] != nil {
+				entityID = fmt.Sprint(values[i])
+			}
+			if err := skipBadRow(entityID, 0, rowErr); err != nil {
+				return imported, skipped, err
+			}
+			skipped++
+			continue
+		}
+		if err := writer.Add(ctx, values...); err != nil {
+			return imported, skipped, err
+		}
+		imported++
+	}
+	if writer == nil {
+		return 0, skipped, nil
+	}
+	if err := writer.Flush(ctx); err != nil {
+		return imported, skipped, err
+	}
+	return imported, skipped, finalizeTable(ctx, sink, table)
+}
+
+func indexOf(values []string, target string) int {
+	for i, v := range values {
+		if v == target {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexOfColumn(columns []columnSpec, name string) int {
+	for i, c := range columns {
+		if c.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+var sizedTypePattern = regexp.MustCompile(`^(VAR)?(CHAR|BINARY)\((\d+)\)`)
+
+// parseImportValue converts a field to the Go value the column's SQL type takes, rejecting values
+// the destination would refuse or silently mangle.
+func parseImportValue(c columnSpec, f importField) (any, error) {
+	sqlType := strings.ToUpper(c.sqlType)
+	if f.null {
+		if strings.Contains(sqlType, "NOT NULL") && !strings.HasPrefix(sqlType, "VARCHAR") {
+			return nil, errors.New("value is required")
+		}
+		if strings.Contains(sqlType, "NOT NULL") {
+			return "", nil
+		}
+		return nil, nil
+	}
+	raw := strings.TrimSpace(f.raw)
+
+	switch {
+	case strings.HasPrefix(sqlType, "BIGINT"), strings.HasPrefix(sqlType, "INT"):
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", f.raw)
+		}
+		return v, nil
+	case strings.HasPrefix(sqlType, "DOUBLE"):
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", f.raw)
+		}
+		return v, nil
+	case strings.HasPrefix(sqlType, "BOOLEAN"):
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", f.raw)
+		}
+		return v, nil
+	case strings.HasPrefix(sqlType, "DATETIME"):
+		return parseImportTime(raw)
+	case strings.HasPrefix(sqlType, "DATE"):
+		t, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a date (YYYY-MM-DD)", f.raw)
+		}
+		return t, nil
+	}
+
+	if m := sizedTypePattern.FindStringSubmatch(sqlType); m != nil {
+		size, _ := strconv.Atoi(m[3])
+		if m[2] == "BINARY" {
+			v, err := base64.StdEncoding.DecodeString(raw)
+			if err != nil {
+				return nil, fmt.Errorf("binary values must be base64: %w", err)
+			}
+			if len(v) > size {
+				return nil, fmt.Errorf("%d bytes exceed %s", len(v), c.sqlType)
+			}
+			return v, nil
+		}
+		if !utf8.ValidString(f.raw) {
+			return nil, errors.New("value is not valid UTF-8")
+		}
+		// State columns widen themselves to fit, see --state-max-length.
+		if n := utf8.RuneCountInString(f.raw); n > size && !isStateColumn(c) {
+			return nil, fmt.Errorf("%d characters exceed %s", n, c.sqlType)
+		}
+	}
+	return f.raw, nil
+}
+
+// parseImportTime accepts RFC 3339 (what --sink ndjson writes) and MySQL's DATETIME text; times
+// without a zone are UTC.
+func parseImportTime(raw string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	if secs, err := strconv.ParseFloat(raw, 64); err == nil {
+		if t, err := floatToNullTime(sql.NullFloat64{Float64: secs, Valid: true}); err == nil && t.Valid {
+			return t.Time, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a time (RFC 3339, YYYY-MM-DD HH:MM:SS, or Unix seconds)", raw)
+}
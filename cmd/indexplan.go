@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// indexShape is the column layout of a secondary index managed by the index plan.
+type indexShape int
+
+const (
+	// shapeEntityTime indexes (entity, time) for per-entity range scans and latest-row lookups.
+	shapeEntityTime indexShape = iota
+	// shapeTime indexes (time) for scans across all entities.
+	shapeTime
+)
+
+// indexPatterns maps the query patterns accepted in the config to the index shape serving them.
+var indexPatterns = map[string]indexShape{
+	"entity-time":       shapeEntityTime,
+	"latest-per-entity": shapeEntityTime,
+	"by-entity-and-day": shapeEntityTime,
+	"time-range":        shapeTime,
+	"by-day":            shapeTime,
+}
+
+type plannedIndex struct {
+	name    string
+	columns []string
+}
+
+func (t *tableSpec) index(shape indexShape) plannedIndex {
+	switch shape {
+	case shapeTime:
+		return plannedIndex{
+			name:    fmt.Sprintf("idx_%s_%s", t.name, t.timeColumn),
+			columns: []string{t.timeColumn},
+		}
+	default:
+		return plannedIndex{
+			name:    fmt.Sprintf("idx_%s_entity_%s", t.name, t.timeColumn),
+			columns: []string{t.entityColumn, t.timeColumn},
+		}
+	}
+}
+
+// plan resolves the configured (or default) query patterns into the indexes to keep.
+func (t *tableSpec) plan() []plannedIndex {
+	patterns := t.indexDefaults
+	if configured, ok := appConfig.Indexes[t.name]; ok {
+		patterns = configured
+	}
+
+	seen := map[indexShape]bool{}
+	var planned []plannedIndex
+	for _, pattern := range patterns {
+		shape, ok := indexPatterns[pattern]
+		if !ok || seen[shape] {
+			continue
+		}
+		seen[shape] = true
+		planned = append(planned, t.index(shape))
+	}
+	return planned
+}
+
+// applyIndexPlan creates the planned secondary indexes on the table and drops indexes the plan
+// manages but no longer wants. Indexes with other names are left untouched.
+func applyIndexPlan(ctx context.Context, db *sql.DB, table *tableSpec) error {
+	existing, err := loadTableIndexes(ctx, db, table.name)
+	if err != nil {
+		return fmt.Errorf("load %s indexes: %w", table.name, err)
+	}
+
+	wanted := map[string]plannedIndex{}
+	for _, idx := range table.plan() {
+		wanted[idx.name] = idx
+	}
+
+	managed := []string{}
+	for _, shape := range []indexShape{shapeEntityTime, shapeTime} {
+		managed = append(managed, table.index(shape).name)
+	}
+	sort.Strings(managed)
+
+	for _, name := range managed {
+		info, exists := existing[name]
+		idx, keep := wanted[name]
+		if exists && (!keep || !slices.Equal(info.columns, idx.columns)) {
+			if err := confirm(fmt.Sprintf("drop index %s on %s (the index plan no longer wants it)", name, table.name)); err != nil {
+				return err
+			}
+			stmt := fmt.Sprintf("ALTER TABLE %s DROP INDEX %s", table.name, quoteIdentifier(name))
+			if _, err := execStatement(ctx, db, stmt); err != nil {
+				return fmt.Errorf("drop index %s: %w", name, err)
+			}
+			exists = false
+		}
+		if keep && !exists {
+			stmt := fmt.Sprintf("ALTER TABLE %s ADD INDEX %s (%s)", table.name, quoteIdentifier(name), strings.Join(idx.columns, ", "))
+			if _, err := execStatement(ctx, db, stmt); err != nil {
+				return fmt.Errorf("add index %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+type tableIndexInfo struct {
+	nonUnique bool
+	columns   []string
+}
+
+// loadTableIndexes returns the indexes of a table in the current database keyed by index name.
+func loadTableIndexes(ctx context.Context, db *sql.DB, table string) (map[string]*tableIndexInfo, error) {
+	schema, err := currentMySQLDatabase(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+SELECT INDEX_NAME, COLUMN_NAME, NON_UNIQUE, SEQ_IN_INDEX
+FROM INFORMATION_SCHEMA.STATISTICS
+WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?
+ORDER BY INDEX_NAME, SEQ_IN_INDEX
+`
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(qctx, query, schema, table)
+	if err != nil {
+		return nil, explainTimeout(qctx, err)
+	}
+	defer rows.Close()
+
+	indexes := map[string]*tableIndexInfo{}
+	for rows.Next() {
+		var (
+			indexName string
+			column    sql.NullString
+			nonUnique int
+			seq       int
+		)
+		if err := rows.Scan(&indexName, &column, &nonUnique, &seq); err != nil {
+			return nil, err
+		}
+		if !column.Valid {
+			continue
+		}
+		info, ok := indexes[indexName]
+		if !ok {
+			info = &tableIndexInfo{
+				nonUnique: nonUnique == 1,
+				columns:   []string{},
+			}
+			indexes[indexName] = info
+		}
+		if len(info.columns) < seq {
+			info.columns = append(info.columns, make([]string, seq-len(info.columns))...)
+		}
+		info.columns[seq-1] = column.String
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return indexes, nil
+}
@@ -0,0 +1,798 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ha-tools/haerrors"
+)
+
+// The tests in this file drive the transfer functions end to end: genfixture writes a recorder,
+// the exporters read it, and a memSink stands in for the destination.
+
+// newRecorderFixture generates a recorder with the given number of entities sampled every rate
+// over the last day.
+func newRecorderFixture(t *testing.T, entities int, rate time.Duration) string {
+	t.Helper()
+	savedEntities, savedDays, savedRate, savedSeed := fixtureEntities, fixtureDays, fixtureRate, fixtureSeed
+	t.Cleanup(func() {
+		fixtureEntities, fixtureDays, fixtureRate, fixtureSeed = savedEntities, savedDays, savedRate, savedSeed
+	})
+	fixtureEntities, fixtureDays, fixtureRate, fixtureSeed = entities, 1, rate, 1
+
+	path := filepath.Join(t.TempDir(), "home-assistant_v2.db")
+	if _, err := generateFixture(context.Background(), path); err != nil {
+		t.Fatalf("generate fixture: %v", err)
+	}
+	return path
+}
+
+// openFixture opens a recorder fixture for the test to read or change.
+func openFixture(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// startRun clears the per-run state Execute would start a process with.
+func startRun() {
+	writtenRows = rowCounter{}
+	exportedRows = rowCounter{}
+	filteredRows = rowCounter{}
+	mergedRows = rowCounter{}
+	committedRows.mu.Lock()
+	committedRows.resumed, committedRows.tables = nil, nil
+	committedRows.mu.Unlock()
+}
+
+// rowCount returns how many rows the counter holds for the table.
+func rowCount(c *rowCounter, table string) int64 {
+	counts, _ := c.counts()
+	return counts[table]
+}
+
+func TestBatteryExportResumesFromWatermarks(t *testing.T) {
+	ctx := context.Background()
+	recorder := newRecorderFixture(t, 12, 10*time.Minute)
+	target, store := newMemStore(t)
+
+	startRun()
+	if err := transferBatteryData(ctx, recorder, target); err != nil {
+		t.Fatalf("first export: %v", err)
+	}
+	first := rowCount(&writtenRows, "battery_points")
+	if first == 0 {
+		t.Fatal("first export wrote no battery rows")
+	}
+	if got := len(store.rows("battery_points")); int64(got) != first {
+		t.Fatalf("battery_points holds %d rows, want the %d written", got, first)
+	}
+
+	startRun()
+	if err := transferBatteryData(ctx, recorder, target); err != nil {
+		t.Fatalf("second export: %v", err)
+	}
+	if n := rowCount(&writtenRows, "battery_points"); n != 0 {
+		t.Errorf("second export wrote %d rows, want 0", n)
+	}
+	// The recorder query starts at the oldest watermark, so a rerun reads a handful of rows, not
+	// the whole history.
+	if n := rowCount(&exportedRows, "battery_points"); n >= first {
+		t.Errorf("second export read %d already exported rows, want fewer than the %d in the recorder", n, first)
+	}
+
+	db := openFixture(t, recorder)
+	var metadataID, attributesID int64
+	var newest float64
+	err := db.QueryRow(`
+SELECT s.metadata_id, s.attributes_id, s.last_updated_ts
+FROM states s JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+WHERE sm.entity_id LIKE 'sensor.%_battery_level'
+ORDER BY s.last_updated_ts DESC LIMIT 1`).Scan(&metadataID, &attributesID, &newest)
+	if err != nil {
+		t.Fatalf("read newest battery state: %v", err)
+	}
+	var oldest float64
+	if err := db.QueryRow("SELECT MIN(last_updated_ts) FROM states").Scan(&oldest); err != nil {
+		t.Fatalf("read oldest state: %v", err)
+	}
+	// A new reading of a known battery, and a battery added to the recorder with history older
+	// than every watermark.
+	if _, err := db.Exec("INSERT INTO states (state, last_updated_ts, attributes_id, metadata_id) VALUES ('41', ?, ?, ?)", newest+60, attributesID, metadataID); err != nil {
+		t.Fatalf("append battery state: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO states_meta (entity_id) VALUES ('sensor.late_battery_level')"); err != nil {
+		t.Fatalf("add battery entity: %v", err)
+	}
+	if _, err := db.Exec(`
+INSERT INTO states (state, last_updated_ts, attributes_id, metadata_id)
+SELECT '77', ?, ?, metadata_id FROM states_meta WHERE entity_id = 'sensor.late_battery_level'`, oldest-3600, attributesID); err != nil {
+		t.Fatalf("add battery history: %v", err)
+	}
+
+	startRun()
+	if err := transferBatteryData(ctx, recorder, target); err != nil {
+		t.Fatalf("third export: %v", err)
+	}
+	if n := rowCount(&writtenRows, "battery_points"); n != 2 {
+		t.Errorf("third export wrote %d rows, want the 2 added", n)
+	}
+	levels := make(map[string]float64)
+	for _, row := range store.rows("battery_points") {
+		levels[row["entity_id"].(string)] = row["battery_level"].(float64)
+	}
+	if got, ok := levels["sensor.late_battery_level"]; !ok || got != 77 {
+		t.Errorf("sensor.late_battery_level level = %v (stored %t), want 77", got, ok)
+	}
+}
+
+func TestEnergyExportAggregatesToTargetResolution(t *testing.T) {
+	ctx := context.Background()
+	recorder := newRecorderFixture(t, 5, time.Minute)
+	target, store := newMemStore(t)
+	saved := targetResolution
+	targetResolution = time.Hour
+	t.Cleanup(func() { targetResolution = saved })
+
+	selector, err := newEntitySelector(matchPrefix, "plug_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := numericExportOptions{idStrategy: idStrategyAuto}
+
+	startRun()
+	if err := transferNumericData(ctx, recorder, target, newEnergyFamily(selector), opts); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	rows := store.rows("energy_points")
+	if len(rows) == 0 {
+		t.Fatal("export wrote no energy rows")
+	}
+
+	// Expected buckets straight from the recorder: per entity and UTC hour, the samples' mean and
+	// the newest sample's time, which the bucket's row carries.
+	type bucketKey struct {
+		entityID string
+		hour     int64
+	}
+	type bucket struct {
+		sum    float64
+		count  int
+		newest float64
+	}
+	expected := make(map[bucketKey]*bucket)
+	var samples int
+	recorderRows, err := openFixture(t, recorder).Query(`
+SELECT sm.entity_id, CAST(s.state AS REAL), s.last_updated_ts
+FROM states s JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+WHERE sm.entity_id LIKE 'sensor.plug\_1\_%' ESCAPE '\'`)
+	if err != nil {
+		t.Fatalf("read recorder: %v", err)
+	}
+	defer recorderRows.Close()
+	for recorderRows.Next() {
+		var entityID string
+		var value, ts float64
+		if err := recorderRows.Scan(&entityID, &value, &ts); err != nil {
+			t.Fatal(err)
+		}
+		key := bucketKey{entityID, int64(ts) / 3600}
+		b, ok := expected[key]
+		if !ok {
+			b = &bucket{}
+			expected[key] = b
+		}
+		b.sum += value
+		b.count++
+		b.newest = math.Max(b.newest, ts)
+		samples++
+	}
+	if err := recorderRows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != len(expected) {
+		t.Errorf("export wrote %d rows, want one per entity and hour: %d", len(rows), len(expected))
+	}
+	for _, row := range rows {
+		entityID := row["entity_id"].(string)
+		at := row["last_updated"].(time.Time)
+		b, ok := expected[bucketKey{entityID, at.Unix() / 3600}]
+		if !ok {
+			t.Errorf("%s row at %s is in no recorder hour", entityID, at)
+			continue
+		}
+		if mean := b.sum / float64(b.count); math.Abs(row["numeric_state"].(float64)-mean) > 1e-9 {
+			t.Errorf("%s hour of %s = %v, want the mean %v of %d samples", entityID, at, row["numeric_state"], mean, b.count)
+		}
+		if want, _ := floatToNullTime(sql.NullFloat64{Float64: b.newest, Valid: true}); !at.Equal(want.Time) {
+			t.Errorf("%s hour row time = %s, want its newest sample's %s", entityID, at.UTC(), want.Time.UTC())
+		}
+	}
+	written, merged := rowCount(&writtenRows, "energy_points"), rowCount(&mergedRows, "energy_points")
+	if written+merged != int64(samples) {
+		t.Errorf("written %d + merged %d rows, want the %d samples read", written, merged, samples)
+	}
+
+	startRun()
+	if err := transferNumericData(ctx, recorder, target, newEnergyFamily(selector), opts); err != nil {
+		t.Fatalf("second export: %v", err)
+	}
+	if n := rowCount(&writtenRows, "energy_points"); n != 0 {
+		t.Errorf("second export wrote %d rows, want 0", n)
+	}
+}
+
+func TestEnergyExportBreaksWatermarkTiesOnStateID(t *testing.T) {
+	ctx := context.Background()
+	recorder := newRecorderFixture(t, 5, 10*time.Minute)
+	target, store := newMemStore(t)
+
+	selector, err := newEntitySelector(matchExact, "sensor.plug_1_power")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := numericExportOptions{idStrategy: idStrategyAuto}
+	startRun()
+	if err := transferNumericData(ctx, recorder, target, newEnergyFamily(selector), opts); err != nil {
+		t.Fatalf("first export: %v", err)
+	}
+
+	// Two more states at the newest instant, recorded after the exported one.
+	db := openFixture(t, recorder)
+	var metadataID, attributesID, lastID int64
+	var newest float64
+	err = db.QueryRow(`
+SELECT s.metadata_id, s.attributes_id, s.last_updated_ts
+FROM states s JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+WHERE sm.entity_id = 'sensor.plug_1_power'
+ORDER BY s.last_updated_ts DESC LIMIT 1`).Scan(&metadataID, &attributesID, &newest)
+	if err != nil {
+		t.Fatalf("read newest power state: %v", err)
+	}
+	for _, value := range []string{"1234.5", "2345.5"} {
+		res, err := db.Exec("INSERT INTO states (state, last_updated_ts, attributes_id, metadata_id) VALUES (?, ?, ?, ?)", value, newest, attributesID, metadataID)
+		if err != nil {
+			t.Fatalf("append power state: %v", err)
+		}
+		if lastID, err = res.LastInsertId(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	startRun()
+	if err := transferNumericData(ctx, recorder, target, newEnergyFamily(selector), opts); err != nil {
+		t.Fatalf("second export: %v", err)
+	}
+	if n := rowCount(&writtenRows, "energy_points"); n != 2 {
+		t.Errorf("second export wrote %d rows, want the 2 states at the watermark", n)
+	}
+	want, _ := floatToNullTime(sql.NullFloat64{Float64: newest, Valid: true})
+	var found bool
+	for _, row := range store.rows("energy_points") {
+		if !row["last_updated"].(time.Time).Equal(want.Time) {
+			continue
+		}
+		found = true
+		if row["numeric_state"] != 2345.5 || row["recorder_state_id"] != lastID {
+			t.Errorf("row at the watermark = %v (state_id %v), want the newest state 2345.5 (state_id %d)", row["numeric_state"], row["recorder_state_id"], lastID)
+		}
+	}
+	if !found {
+		t.Fatal("no energy_points row at the watermark")
+	}
+
+	startRun()
+	if err := transferNumericData(ctx, recorder, target, newEnergyFamily(selector), opts); err != nil {
+		t.Fatalf("third export: %v", err)
+	}
+	if n := rowCount(&writtenRows, "energy_points"); n != 0 {
+		t.Errorf("third export wrote %d rows, want 0", n)
+	}
+}
+
+func TestFanoutExportBreaksWatermarkTiesPerDestination(t *testing.T) {
+	ctx := context.Background()
+	recorder := newRecorderFixture(t, 5, 10*time.Minute)
+	target, primary := newMemStore(t)
+	selector, err := newEntitySelector(matchExact, "sensor.plug_1_power")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := numericExportOptions{idStrategy: idStrategyAuto}
+
+	startRun()
+	if err := transferNumericData(ctx, recorder, target, newEnergyFamily(selector), opts); err != nil {
+		t.Fatalf("first export: %v", err)
+	}
+	db := openFixture(t, recorder)
+	var metadataID, attributesID int64
+	var newest float64
+	err = db.QueryRow(`
+SELECT s.metadata_id, s.attributes_id, s.last_updated_ts
+FROM states s JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+WHERE sm.entity_id = 'sensor.plug_1_power'
+ORDER BY s.last_updated_ts DESC LIMIT 1`).Scan(&metadataID, &attributesID, &newest)
+	if err != nil {
+		t.Fatalf("read newest power state: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO states (state, last_updated_ts, attributes_id, metadata_id) VALUES ('1234.5', ?, ?, ?)", newest, attributesID, metadataID); err != nil {
+		t.Fatalf("append power state: %v", err)
+	}
+
+	// A second destination joins with nothing exported yet.
+	mirrorTarget := target + "/mirror"
+	mirror := &memStore{tables: make(map[string]*memTable)}
+	memStores.Store(mirrorTarget, mirror)
+	savedAlso := alsoDests
+	alsoDests = []string{"memory:" + mirrorTarget}
+	t.Cleanup(func() {
+		alsoDests = savedAlso
+		memStores.Delete(mirrorTarget)
+	})
+
+	startRun()
+	if err := transferNumericData(ctx, recorder, target, newEnergyFamily(selector), opts); err != nil {
+		t.Fatalf("fan-out export: %v", err)
+	}
+	want, _ := floatToNullTime(sql.NullFloat64{Float64: newest, Valid: true})
+	for name, store := range map[string]*memStore{"primary": primary, "mirror": mirror} {
+		var atWatermark any
+		for _, row := range store.rows("energy_points") {
+			if row["last_updated"].(time.Time).Equal(want.Time) {
+				atWatermark = row["numeric_state"]
+			}
+		}
+		if atWatermark != 1234.5 {
+			t.Errorf("%s row at the watermark = %v, want the later state 1234.5", name, atWatermark)
+		}
+	}
+	if got, want := len(mirror.rows("energy_points")), len(primary.rows("energy_points")); got != want {
+		t.Errorf("mirror holds %d rows, want the primary's %d", got, want)
+	}
+
+	// Both destinations now share the watermark and its tie; a state recorded after it reaches both.
+	if _, err := db.Exec("INSERT INTO states (state, last_updated_ts, attributes_id, metadata_id) VALUES ('2345.5', ?, ?, ?)", newest, attributesID, metadataID); err != nil {
+		t.Fatalf("append power state: %v", err)
+	}
+	startRun()
+	if err := transferNumericData(ctx, recorder, target, newEnergyFamily(selector), opts); err != nil {
+		t.Fatalf("second fan-out export: %v", err)
+	}
+	if n := rowCount(&writtenRows, "energy_points"); n != 1 {
+		t.Errorf("second fan-out export wrote %d rows, want the 1 state at the watermark", n)
+	}
+	for name, store := range map[string]*memStore{"primary": primary, "mirror": mirror} {
+		for _, row := range store.rows("energy_points") {
+			if row["last_updated"].(time.Time).Equal(want.Time) && row["numeric_state"] != 2345.5 {
+				t.Errorf("%s row at the watermark = %v, want the later state 2345.5", name, row["numeric_state"])
+			}
+		}
+	}
+}
+
+func TestBatteryExportResumesTokenAtItsStateID(t *testing.T) {
+	ctx := context.Background()
+	recorder := newRecorderFixture(t, 12, 10*time.Minute)
+	target, _ := newMemStore(t)
+
+	startRun()
+	if err := transferBatteryData(ctx, recorder, target); err != nil {
+		t.Fatalf("first export: %v", err)
+	}
+	committedRows.mu.Lock()
+	token, err := encodeResumeToken(resumeState{Tables: committedRows.tables})
+	committedRows.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another state of a battery at the instant the token ends at.
+	db := openFixture(t, recorder)
+	var metadataID, attributesID int64
+	var newest float64
+	err = db.QueryRow(`
+SELECT s.metadata_id, s.attributes_id, s.last_updated_ts
+FROM states s JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+WHERE sm.entity_id LIKE 'sensor.%_battery_level'
+ORDER BY s.last_updated_ts DESC LIMIT 1`).Scan(&metadataID, &attributesID, &newest)
+	if err != nil {
+		t.Fatalf("read newest battery state: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO states (state, last_updated_ts, attributes_id, metadata_id) VALUES ('41', ?, ?, ?)", newest, attributesID, metadataID); err != nil {
+		t.Fatalf("append battery state: %v", err)
+	}
+
+	saved := resumeFrom
+	t.Cleanup(func() { resumeFrom = saved })
+	resumeFrom = token
+	startRun()
+	if err := loadResumeToken(); err != nil {
+		t.Fatal(err)
+	}
+	if err := transferBatteryData(ctx, recorder, target); err != nil {
+		t.Fatalf("resumed export: %v", err)
+	}
+	if n := rowCount(&writtenRows, "battery_points"); n != 1 {
+		t.Errorf("resumed export wrote %d rows, want the 1 state after the token's", n)
+	}
+}
+
+func TestLimitedExportNeedsScratchDestination(t *testing.T) {
+	ctx := context.Background()
+	recorder := newRecorderFixture(t, 12, 10*time.Minute)
+	target, store := newMemStore(t)
+	saved := sourceLimit
+	sourceLimit = 100
+	t.Cleanup(func() { sourceLimit = saved })
+
+	startRun()
+	if err := transferBatteryData(ctx, recorder, target); err == nil {
+		t.Fatal("--limit export to a real destination did not fail")
+	}
+	if rows := store.rows("battery_points"); len(rows) != 0 {
+		t.Errorf("--limit export stored %d rows, want none", len(rows))
+	}
+
+	out := filepath.Join(t.TempDir(), "battery.ndjson")
+	sinkName = "ndjson"
+	startRun()
+	if err := transferBatteryData(ctx, recorder, out); err != nil {
+		t.Fatalf("--limit export to ndjson: %v", err)
+	}
+	if n := rowCount(&writtenRows, "battery_points"); n == 0 || n > 100 {
+		t.Errorf("--limit export wrote %d rows, want 1 to 100", n)
+	}
+}
+
+// legacyRecorderSchema is a schema 30 recorder (Home Assistant 2022.12): entity ids and text
+// timestamps in states, event data inline in events.
+var legacyRecorderSchema = []string{
+	`CREATE TABLE state_attributes (attributes_id INTEGER PRIMARY KEY, hash BIGINT, shared_attrs TEXT)`,
+	`CREATE TABLE states (
+    state_id INTEGER PRIMARY KEY,
+    entity_id VARCHAR(255),
+    state VARCHAR(255),
+    attributes_id INTEGER,
+    old_state_id INTEGER,
+    last_changed DATETIME,
+    last_updated DATETIME
+)`,
+	`CREATE TABLE events (event_id INTEGER PRIMARY KEY, event_type VARCHAR(64), event_data TEXT, time_fired DATETIME)`,
+	`CREATE TABLE schema_changes (change_id INTEGER PRIMARY KEY, schema_version INTEGER, changed DATETIME)`,
+	`INSERT INTO schema_changes (schema_version, changed) VALUES (30, '2022-12-07 00:00:00')`,
+}
+
+// downgradeFixture copies a current recorder fixture into the legacy schema.
+func downgradeFixture(t *testing.T, current string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	legacy := openFixture(t, path)
+	for _, stmt := range legacyRecorderSchema {
+		if _, err := legacy.Exec(stmt); err != nil {
+			t.Fatalf("create legacy schema: %v", err)
+		}
+	}
+	if _, err := legacy.Exec("ATTACH DATABASE ? AS current", current); err != nil {
+		t.Fatalf("attach fixture: %v", err)
+	}
+	if _, err := legacy.Exec("INSERT INTO state_attributes SELECT attributes_id, hash, shared_attrs FROM current.state_attributes"); err != nil {
+		t.Fatalf("copy state_attributes: %v", err)
+	}
+
+	rows, err := legacy.Query(`
+SELECT s.state_id, sm.entity_id, s.state, s.attributes_id, s.old_state_id, s.last_changed_ts, s.last_updated_ts
+FROM current.states s JOIN current.states_meta sm ON s.metadata_id = sm.metadata_id`)
+	if err != nil {
+		t.Fatalf("read fixture states: %v", err)
+	}
+	type state struct {
+		id, attributesID         int64
+		entityID, value          string
+		oldStateID               sql.NullInt64
+		lastChanged, lastUpdated float64
+	}
+	var states []state
+	for rows.Next() {
+		var s state
+		if err := rows.Scan(&s.id, &s.entityID, &s.value, &s.attributesID, &s.oldStateID, &s.lastChanged, &s.lastUpdated); err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, s)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Legacy timestamps are naive UTC text with microseconds.
+	text := func(ts float64) string {
+		return time.UnixMicro(int64(math.Round(ts * 1e6))).UTC().Format("2006-01-02 15:04:05.000000")
+	}
+	for _, s := range states {
+		if _, err := legacy.Exec("INSERT INTO states VALUES (?, ?, ?, ?, ?, ?, ?)", s.id, s.entityID, s.value, s.attributesID, s.oldStateID, text(s.lastChanged), text(s.lastUpdated)); err != nil {
+			t.Fatalf("copy states: %v", err)
+		}
+	}
+	return path
+}
+
+func TestBatteryExportReadsLegacyRecorderSchema(t *testing.T) {
+	ctx := context.Background()
+	recorder := newRecorderFixture(t, 12, 10*time.Minute)
+	legacy := downgradeFixture(t, recorder)
+
+	currentTarget, current := newMemStore(t)
+	startRun()
+	if err := transferBatteryData(ctx, recorder, currentTarget); err != nil {
+		t.Fatalf("export current recorder: %v", err)
+	}
+
+	legacyTarget := currentTarget + "/legacy"
+	migrated := &memStore{tables: make(map[string]*memTable)}
+	memStores.Store(legacyTarget, migrated)
+	t.Cleanup(func() { memStores.Delete(legacyTarget) })
+	startRun()
+	if err := transferBatteryData(ctx, legacy, legacyTarget); err != nil {
+		t.Fatalf("export legacy recorder: %v", err)
+	}
+
+	want, got := current.rows("battery_points"), migrated.rows("battery_points")
+	if len(want) == 0 || len(got) != len(want) {
+		t.Fatalf("legacy recorder exported %d battery rows, want %d", len(got), len(want))
+	}
+	byID := make(map[int64]map[string]any, len(want))
+	for _, row := range want {
+		byID[row["state_id"].(int64)] = row
+	}
+	for _, row := range got {
+		w, ok := byID[row["state_id"].(int64)]
+		if !ok {
+			t.Errorf("legacy recorder exported unknown state_id %v", row["state_id"])
+			continue
+		}
+		if row["entity_id"] != w["entity_id"] || row["battery_level"] != w["battery_level"] {
+			t.Errorf("state_id %v = %v %v, want %v %v", row["state_id"], row["entity_id"], row["battery_level"], w["entity_id"], w["battery_level"])
+		}
+		// The text timestamps carry whole microseconds, which the float ones may miss by one.
+		if diff := row["last_updated"].(time.Time).Sub(w["last_updated"].(time.Time)); diff > time.Microsecond || diff < -time.Microsecond {
+			t.Errorf("state_id %v last_updated = %s, want %s", row["state_id"], row["last_updated"], w["last_updated"])
+		}
+	}
+}
+
+func TestStagingSwapRejectsSeveralRecorders(t *testing.T) {
+	saved := writeMode
+	t.Cleanup(func() { writeMode = saved })
+	writeMode = writeModeStagingSwap
+
+	first := newRecorderFixture(t, 1, time.Hour)
+	second := newRecorderFixture(t, 1, time.Hour)
+	exported := 0
+	export := func(string) error {
+		exported++
+		return nil
+	}
+	if err := forEachRecorder(context.Background(), []string{first, second}, export); err == nil {
+		t.Fatal("staging-swap over two recorders did not fail")
+	}
+	if exported != 0 {
+		t.Errorf("%d recorders were exported before the command failed, want none", exported)
+	}
+
+	if err := forEachRecorder(context.Background(), []string{first}, export); err != nil {
+		t.Fatalf("staging-swap over one recorder: %v", err)
+	}
+	if exported != 1 {
+		t.Errorf("exported %d recorders, want 1", exported)
+	}
+}
+
+func TestAlertForRuleFiresOnceItsDurationElapsed(t *testing.T) {
+	ctx := context.Background()
+	recorder := newRecorderFixture(t, 5, 10*time.Minute)
+	target, _ := newMemStore(t)
+
+	var events []alertEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event alertEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decode alert: %v", err)
+		}
+		events = append(events, event)
+	}))
+	defer server.Close()
+
+	savedAlerts, savedPath, savedClock := alerts, alertStatePath, wallClock
+	t.Cleanup(func() { alerts, alertStatePath, wallClock = savedAlerts, savedPath, savedClock })
+	alertStatePath = filepath.Join(t.TempDir(), "alerts.json")
+	cfg := &fileConfig{Alerts: []*alertRule{{Name: "plug offline", Entity: "sensor.plug_*_power", State: "unavailable", For: "1h", Webhook: server.URL}}}
+	if err := cfg.Alerts[0].validate(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The plug goes offline after its newest reading and stays offline.
+	db := openFixture(t, recorder)
+	var metadataID, attributesID int64
+	var newest float64
+	err := db.QueryRow(`
+SELECT s.metadata_id, s.attributes_id, s.last_updated_ts
+FROM states s JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+WHERE sm.entity_id = 'sensor.plug_1_power'
+ORDER BY s.last_updated_ts DESC LIMIT 1`).Scan(&metadataID, &attributesID, &newest)
+	if err != nil {
+		t.Fatalf("read newest power state: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO states (state, last_updated_ts, attributes_id, metadata_id) VALUES ('unavailable', ?, ?, ?)", newest+60, attributesID, metadataID); err != nil {
+		t.Fatalf("append unavailable state: %v", err)
+	}
+	offline, _ := floatToNullTime(sql.NullFloat64{Float64: newest + 60, Valid: true})
+
+	// Each run is a separate process: a fresh evaluator on the same state file.
+	run := func(now time.Time) {
+		t.Helper()
+		wallClock = fixedClock(now)
+		alerts = newAlertEvaluator(cfg)
+		if err := alerts.load(); err != nil {
+			t.Fatal(err)
+		}
+		selector, err := newEntitySelector(matchExact, "sensor.plug_1_power")
+		if err != nil {
+			t.Fatal(err)
+		}
+		startRun()
+		if err := transferNumericData(ctx, recorder, target, newEnergyFamily(selector), numericExportOptions{idStrategy: idStrategyAuto}); err != nil {
+			t.Fatalf("export: %v", err)
+		}
+		if err := finishAlerts(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run(offline.Time.Add(10 * time.Minute))
+	if len(events) != 0 {
+		t.Fatalf("fired %d alerts 10 minutes into the outage, want 0", len(events))
+	}
+	// No new rows arrive; the rule fires once the hour is up.
+	run(offline.Time.Add(65 * time.Minute))
+	if len(events) != 1 {
+		t.Fatalf("fired %d alerts after the outage lasted an hour, want 1", len(events))
+	}
+	if e := events[0]; e.EntityID != "sensor.plug_1_power" || e.State != "unavailable" || !e.Since.Equal(offline.Time) {
+		t.Errorf("alert = %+v, want sensor.plug_1_power unavailable since %s", e, offline.Time)
+	}
+	run(offline.Time.Add(80 * time.Minute))
+	if len(events) != 1 {
+		t.Errorf("fired %d alerts, want the outage to fire once", len(events))
+	}
+}
+
+func TestSQLiteDestinationResumesFromWatermarks(t *testing.T) {
+	ctx := context.Background()
+	recorder := newRecorderFixture(t, 12, 10*time.Minute)
+	target := filepath.Join(t.TempDir(), "dest.db")
+	savedSink, savedDialect := sinkName, destDialect
+	t.Cleanup(func() { sinkName, destDialect = savedSink, savedDialect })
+	sinkName, destDialect = "sql", sqlDialects["sqlite"]
+
+	selector, err := newEntitySelector(matchExact, "sensor.plug_1_power")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := numericExportOptions{idStrategy: idStrategyAuto}
+	export := func() {
+		t.Helper()
+		startRun()
+		if err := transferBatteryData(ctx, recorder, target); err != nil {
+			t.Fatalf("battery export: %v", err)
+		}
+		if err := transferNumericData(ctx, recorder, target, newEnergyFamily(selector), opts); err != nil {
+			t.Fatalf("energy export: %v", err)
+		}
+	}
+
+	export()
+	battery, energy := rowCount(&writtenRows, "battery_points"), rowCount(&writtenRows, "energy_points")
+	if battery == 0 || energy == 0 {
+		t.Fatalf("first export wrote %d battery and %d energy rows, want some of each", battery, energy)
+	}
+	dest := openFixture(t, target)
+	for table, want := range map[string]int64{"battery_points": battery, "energy_points": energy} {
+		var n int64
+		if err := dest.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+			t.Fatalf("count %s: %v", table, err)
+		}
+		if n != want {
+			t.Errorf("%s holds %d rows, want the %d written", table, n, want)
+		}
+	}
+
+	export()
+	if n := rowCount(&writtenRows, "battery_points") + rowCount(&writtenRows, "energy_points"); n != 0 {
+		t.Errorf("second export wrote %d rows, want 0", n)
+	}
+}
+
+func TestLockedRecorderExitsWithSourceLocked(t *testing.T) {
+	ctx := context.Background()
+	recorder := newRecorderFixture(t, 2, 10*time.Minute)
+	target, _ := newMemStore(t)
+	saved := sqliteOptions
+	t.Cleanup(func() { sqliteOptions = saved })
+	sqliteOptions = "mode=ro&_pragma=busy_timeout(50)"
+
+	// Home Assistant holding a write transaction past the busy timeout.
+	conn, err := openFixture(t, recorder).Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "BEGIN EXCLUSIVE"); err != nil {
+		t.Fatalf("lock recorder: %v", err)
+	}
+	defer conn.ExecContext(ctx, "ROLLBACK")
+
+	startRun()
+	err = forEachRecorder(ctx, []string{recorder}, func(path string) error {
+		return transferBatteryData(ctx, path, target)
+	})
+	var locked *haerrors.SourceLockedError
+	if !errors.As(err, &locked) || locked.Path != recorder {
+		t.Fatalf("export of a locked recorder = %v, want a *haerrors.SourceLockedError for %s", err, recorder)
+	}
+	if code := exitCode(err, 0); code != exitSourceLocked {
+		t.Errorf("exit code = %d, want %d", code, exitSourceLocked)
+	}
+}
+
+func TestNumericAndRoutedExportsReadFromOldestWatermark(t *testing.T) {
+	ctx := context.Background()
+	recorder := newRecorderFixture(t, 5, 10*time.Minute)
+	target, _ := newMemStore(t)
+
+	selector, err := newEntitySelector(matchExact, "sensor.plug_1_power")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := []*routeRule{{Entity: "sensor.plug_*_power", Table: "power_points"}}
+	if err := rules[0].validate(); err != nil {
+		t.Fatal(err)
+	}
+	export := func() {
+		t.Helper()
+		startRun()
+		if err := transferNumericData(ctx, recorder, target, newEnergyFamily(selector), numericExportOptions{idStrategy: idStrategyAuto}); err != nil {
+			t.Fatalf("energy export: %v", err)
+		}
+		if err := transferRoutedData(ctx, recorder, target, rules); err != nil {
+			t.Fatalf("routed export: %v", err)
+		}
+	}
+
+	export()
+	energy, power := rowCount(&writtenRows, "energy_points"), rowCount(&writtenRows, "power_points")
+	if energy == 0 || power == 0 {
+		t.Fatalf("first export wrote %d energy and %d power rows, want some of each", energy, power)
+	}
+	export()
+	for table, first := range map[string]int64{"energy_points": energy, "power_points": power} {
+		if n := rowCount(&writtenRows, table); n != 0 {
+			t.Errorf("second export wrote %d %s rows, want 0", n, table)
+		}
+		// Only the rows at the watermark are read again, not the history.
+		if n := rowCount(&exportedRows, table); n >= first {
+			t.Errorf("second export read %d already exported %s rows, want fewer than the %d in the recorder", n, table, first)
+		}
+	}
+}
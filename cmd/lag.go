@@ -0,0 +1,462 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	lagSQLitePath string
+	lagMySQLDSN   string
+	lagTableNames []string
+	lagFormat     string
+	lagMax        time.Duration
+)
+
+// lagDefaultTables are the exporters' history tables measured unless --table is set; the config's
+// route and job tables are added to them.
+var lagDefaultTables = []string{"energy_points", "climate_points", "utilities_points", "battery_points", "weather_points", "gps_points"}
+
+var lagTablePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+const mysqlErrNoSuchTable = 1146
+
+// lagCmd prints how far the destination trails the recorder per entity.
+var lagCmd = &cobra.Command{
+	Use:   "lag",
+	Short: "Print the sync lag per entity (recorder's newest state minus newest exported row)",
+	Long:  "Compares the newest state of every entity in the recorder with its newest row in each destination table and prints the difference, most lagging first. --format=prometheus writes the ha_tools_sync_lag_seconds gauge for a textfile collector; --max-lag fails the command when an entity lags more, for cron checks.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if lagSQLitePath == "" {
+			return errors.New("sqlite database path is required")
+		}
+		if lagMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+		if !destDialect.mysqlProtocol {
+			return fmt.Errorf("lag reads the destination over the MySQL protocol, which the %s dialect does not speak", destDialect.name)
+		}
+		if lagMax < 0 {
+			return errors.New("--max-lag must not be negative")
+		}
+		switch lagFormat {
+		case "text", "json", "prometheus":
+		default:
+			return fmt.Errorf("--format must be text, json, or prometheus, got %q", lagFormat)
+		}
+		tables := lagTables(lagTableNames)
+		if err := validateLagTables(tables); err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		sqliteDB, err := openRecorder(ctx, lagSQLitePath)
+		if err != nil {
+			return err
+		}
+		defer sqliteDB.Close()
+		db, err := openDestination(ctx, lagMySQLDSN)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		lags, err := measureLag(ctx, sqliteDB, db, tables)
+		if err != nil {
+			return err
+		}
+		sorted := sortedLags(lags)
+		out := cmd.OutOrStdout()
+		switch lagFormat {
+		case "json":
+			err = writeLagJSON(out, sorted)
+		case "prometheus":
+			writeLagPrometheus(out, sorted)
+		default:
+			writeLagText(out, sorted)
+		}
+		if err != nil {
+			return err
+		}
+
+		if behind := lagsAbove(sorted, lagMax); lagMax > 0 && len(behind) > 0 {
+			return fmt.Errorf("%d entities lag more than %s, most %s (%s in %s)", len(behind), lagMax, formatLag(behind[0].mustLag()), behind[0].entityID, behind[0].table)
+		}
+		return nil
+	},
+}
+
+func init() {
+	lagCmd.Flags().StringVar(&lagSQLitePath, "sqlite", "", "Path to the Home Assistant SQLite recorder database")
+	lagCmd.Flags().StringVar(&lagMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	lagCmd.Flags().StringArrayVar(&lagTableNames, "table", nil, "Destination table to measure (default: the exporters' history tables and the config's route tables). Repeatable")
+	lagCmd.Flags().StringVar(&lagFormat, "format", "text", "Output format: text, json, or prometheus (text exposition of the ha_tools_sync_lag_seconds gauge)")
+	lagCmd.Flags().DurationVar(&lagMax, "max-lag", 0, "Fail when an entity lags more than this (e.g. 15m; 0 = never)")
+	_ = lagCmd.MarkFlagRequired("sqlite")
+	_ = lagCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(lagCmd)
+}
+
+// lagTables returns the given tables, or the default tables plus those the config routes to.
+func lagTables(tables []string) []string {
+	if len(tables) > 0 {
+		return tables
+	}
+	tables = append([]string{}, lagDefaultTables...)
+	add := func(rules []*routeRule) {
+		for _, rule := range rules {
+			if !containsString(tables, rule.Table) {
+				tables = append(tables, rule.Table)
+			}
+		}
+	}
+	add(appConfig.Routes)
+	for _, job := range appConfig.Jobs {
+		add(job.Routes)
+	}
+	return tables
+}
+
+func validateLagTables(tables []string) error {
+	for _, table := range tables {
+		if !lagTablePattern.MatchString(table) {
+			return fmt.Errorf("invalid table name %q", table)
+		}
+	}
+	return nil
+}
+
+type lagKey struct {
+	table    string
+	entityID string
+}
+
+// entityLag compares an entity's rows in one destination table with the recorder.
+type entityLag struct {
+	lagKey
+	rows     int64
+	exported time.Time
+	// recorded is the recorder's newest state of the entity; zero when it has none, e.g. after a
+	// purge or a rename.
+	recorded time.Time
+}
+
+// lag is how far the destination trails the recorder for the entity.
+func (e *entityLag) lag() (time.Duration, bool) {
+	if e.recorded.IsZero() || e.exported.IsZero() {
+		return 0, false
+	}
+	return max(e.recorded.Sub(e.exported), 0), true
+}
+
+func (e *entityLag) mustLag() time.Duration {
+	d, _ := e.lag()
+	return d
+}
+
+// formatLag renders a lag in whole seconds, e.g. 2m5s.
+func formatLag(d time.Duration) string {
+	return d.Round(time.Second).String()
+}
+
+// measureLag reads the newest state per entity from the recorder and the row count and newest row
+// per entity from each destination table. Tables that do not exist yet are skipped.
+func measureLag(ctx context.Context, sqliteDB, db *sql.DB, tables []string) (map[lagKey]*entityLag, error) {
+	recorded, err := recorderNewestStates(ctx, sqliteDB)
+	if err != nil {
+		return nil, err
+	}
+
+	lags := make(map[lagKey]*entityLag)
+	for _, table := range tables {
+		query := fmt.Sprintf("SELECT entity_id, COUNT(*), MAX(last_updated) FROM %s GROUP BY entity_id", table)
+		err := queryRows(ctx, db, query, func(scan func(dest ...any) error) error {
+			var (
+				key      = lagKey{table: table}
+				rows     int64
+				exported sql.NullTime
+			)
+			if err := scan(&key.entityID, &rows, &exported); err != nil {
+				return err
+			}
+			lags[key] = &entityLag{lagKey: key, rows: rows, exported: exported.Time, recorded: recorded[key.entityID]}
+			return nil
+		})
+		if err != nil && !isMySQLError(err, mysqlErrNoSuchTable) {
+			return nil, fmt.Errorf("read %s: %w", table, err)
+		}
+	}
+	return lags, nil
+}
+
+// recorderNewestStates returns the newest last_updated of every entity in the recorder.
+func recorderNewestStates(ctx context.Context, sqliteDB *sql.DB) (map[string]time.Time, error) {
+	const query = `
+SELECT sm.entity_id, MAX(s.last_updated_ts)
+FROM states s
+JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+GROUP BY sm.entity_id
+`
+	newest := make(map[string]time.Time)
+	err := queryRows(ctx, sqliteDB, query, func(scan func(dest ...any) error) error {
+		var (
+			entityID string
+			ts       sql.NullFloat64
+		)
+		if err := scan(&entityID, &ts); err != nil {
+			return err
+		}
+		if at, err := floatToNullTime(ts); err == nil && at.Valid {
+			newest[entityID] = at.Time
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read recorder: %w", err)
+	}
+	return newest, nil
+}
+
+// queryRows runs a query under --query-timeout and calls fn for every row.
+func queryRows(ctx context.Context, db *sql.DB, query string, fn func(scan func(dest ...any) error) error) error {
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(qctx, query)
+	if err != nil {
+		return explainTimeout(qctx, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows.Scan); err != nil {
+			return err
+		}
+	}
+	return explainTimeout(qctx, rows.Err())
+}
+
+// sortedLags orders the lags most lagging first, then by table and entity.
+func sortedLags(lags map[lagKey]*entityLag) []*entityLag {
+	sorted := make([]*entityLag, 0, len(lags))
+	for _, l := range lags {
+		sorted = append(sorted, l)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		li, lj := sorted[i].mustLag(), sorted[j].mustLag()
+		if li != lj {
+			return li > lj
+		}
+		if sorted[i].table != sorted[j].table {
+			return sorted[i].table < sorted[j].table
+		}
+		return sorted[i].entityID < sorted[j].entityID
+	})
+	return sorted
+}
+
+// lagsAbove returns the entries of the sorted lags exceeding limit.
+func lagsAbove(sorted []*entityLag, limit time.Duration) []*entityLag {
+	n := 0
+	for n < len(sorted) && sorted[n].mustLag() > limit {
+		n++
+	}
+	return sorted[:n]
+}
+
+func writeLagText(w io.Writer, sorted []*entityLag) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tENTITY\tRECORDED\tEXPORTED\tLAG")
+	for _, l := range sorted {
+		recorded, lag := "-", "-"
+		if !l.recorded.IsZero() {
+			recorded = l.recorded.Local().Format(time.DateTime)
+		}
+		if d, ok := l.lag(); ok {
+			lag = formatLag(d)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", l.table, l.entityID, recorded, l.exported.Local().Format(time.DateTime), lag)
+	}
+	tw.Flush()
+}
+
+// lagEntry is one element of --format=json.
+type lagEntry struct {
+	Table      string     `json:"table"`
+	EntityID   string     `json:"entity_id"`
+	Rows       int64      `json:"rows"`
+	Recorded   *time.Time `json:"recorded"`
+	Exported   time.Time  `json:"exported"`
+	LagSeconds *float64   `json:"lag_seconds"`
+}
+
+func writeLagJSON(w io.Writer, sorted []*entityLag) error {
+	entries := make([]lagEntry, 0, len(sorted))
+	for _, l := range sorted {
+		entry := lagEntry{Table: l.table, EntityID: l.entityID, Rows: l.rows, Exported: l.exported}
+		if !l.recorded.IsZero() {
+			recorded := l.recorded
+			entry.Recorded = &recorded
+		}
+		if d, ok := l.lag(); ok {
+			seconds := d.Seconds()
+			entry.LagSeconds = &seconds
+		}
+		entries = append(entries, entry)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeLagPrometheus writes the lags in the Prometheus text exposition format. Entities the
+// recorder no longer has are left out.
+func writeLagPrometheus(w io.Writer, sorted []*entityLag) {
+	fmt.Fprintln(w, "# HELP ha_tools_sync_lag_seconds Newest recorder state minus newest exported row, per destination table and entity.")
+	fmt.Fprintln(w, "# TYPE ha_tools_sync_lag_seconds gauge")
+	for _, l := range sorted {
+		d, ok := l.lag()
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, "ha_tools_sync_lag_seconds{table=\"%s\",entity_id=\"%s\"} %g\n", prometheusLabelEscaper.Replace(l.table), prometheusLabelEscaper.Replace(l.entityID), d.Seconds())
+	}
+}
+
+// lagMonitor checks the sync lag after each daemon run and notifies when entities fall more than
+// maxLag behind. Like an alert rule it fires once per entity and re-arms after it caught up.
+type lagMonitor struct {
+	maxLag  time.Duration
+	notify  string
+	webhook string
+	ha      *homeAssistantConfig
+	client  *http.Client
+
+	db     *sql.DB
+	behind map[lagKey]bool
+}
+
+// lagEvent is the JSON body posted to the --lag-webhook.
+type lagEvent struct {
+	MaxLagSeconds float64    `json:"max_lag_seconds"`
+	Entities      []lagEntry `json:"entities"`
+	Message       string     `json:"message"`
+}
+
+// newLagMonitor validates the daemon's --max-lag flags; it returns nil when maxLag is 0.
+func newLagMonitor(maxLag time.Duration, dsn, notify, webhook string) (*lagMonitor, error) {
+	if maxLag == 0 {
+		if notify != "" || webhook != "" {
+			return nil, errors.New("--lag-notify and --lag-webhook require --max-lag")
+		}
+		return nil, nil
+	}
+	if maxLag < 0 {
+		return nil, errors.New("--max-lag must not be negative")
+	}
+	if dsn == "" || sinkName != "mysql" || !destDialect.mysqlProtocol {
+		return nil, errors.New("--max-lag requires --dsn with the mysql sink")
+	}
+	m := &lagMonitor{
+		maxLag:  maxLag,
+		notify:  notify,
+		webhook: webhook,
+		ha:      appConfig.HomeAssistant,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		behind:  make(map[lagKey]bool),
+	}
+	if m.ha == nil {
+		m.ha = supervisorHomeAssistant()
+	}
+	if notify != "" && m.ha == nil {
+		return nil, errors.New("--lag-notify requires the config's home_assistant section")
+	}
+	return m, validateLagTables(lagTables(nil))
+}
+
+// check measures the lag, logs entities that fell behind or caught up, and notifies about those
+// that fell behind. Failures are logged: a daemon keeps syncing when the check cannot run.
+func (m *lagMonitor) check(ctx context.Context, out, errOut io.Writer, sqliteDB *sql.DB, dsn string) {
+	if m.db == nil {
+		db, err := openDestination(ctx, dsn)
+		if err != nil {
+			addonLog(errOut, "lag check: %v", err)
+			return
+		}
+		m.db = db
+	}
+	lags, err := measureLag(ctx, sqliteDB, m.db, lagTables(nil))
+	if err != nil {
+		addonLog(errOut, "lag check: %v", err)
+		return
+	}
+
+	var fell []*entityLag
+	for _, l := range sortedLags(lags) {
+		d, _ := l.lag()
+		switch {
+		case d > m.maxLag && !m.behind[l.lagKey]:
+			m.behind[l.lagKey] = true
+			fell = append(fell, l)
+			addonLog(errOut, "%s in %s lags %s behind the recorder (max %s)", l.entityID, l.table, formatLag(d), m.maxLag)
+		case d <= m.maxLag && m.behind[l.lagKey]:
+			delete(m.behind, l.lagKey)
+			addonLog(out, "%s in %s caught up", l.entityID, l.table)
+		}
+	}
+	if len(fell) > 0 {
+		m.deliver(ctx, errOut, fell)
+	}
+}
+
+func (m *lagMonitor) deliver(ctx context.Context, errOut io.Writer, fell []*entityLag) {
+	message := fmt.Sprintf("%s in %s lags %s behind the recorder", fell[0].entityID, fell[0].table, formatLag(fell[0].mustLag()))
+	if len(fell) > 1 {
+		message += fmt.Sprintf(", and %d more entities lag over %s", len(fell)-1, m.maxLag)
+	}
+	notifier := &alertEvaluator{ha: m.ha, client: m.client}
+	if m.webhook != "" {
+		event := lagEvent{MaxLagSeconds: m.maxLag.Seconds(), Message: message}
+		for _, l := range fell {
+			seconds := l.mustLag().Seconds()
+			recorded := l.recorded
+			event.Entities = append(event.Entities, lagEntry{Table: l.table, EntityID: l.entityID, Rows: l.rows, Recorded: &recorded, Exported: l.exported, LagSeconds: &seconds})
+		}
+		if err := notifier.post(ctx, m.webhook, "", event); err != nil {
+			addonLog(errOut, "lag webhook: %v", err)
+		}
+	}
+	if m.notify != "" {
+		endpoint := strings.TrimSuffix(m.ha.URL, "/") + "/api/services/notify/" + m.notify
+		body := map[string]string{"title": "ha-tools: sync is falling behind", "message": message}
+		token, err := resolveSecret(ctx, m.ha.Token)
+		if err == nil {
+			err = notifier.post(ctx, endpoint, token, body)
+		}
+		if err != nil {
+			addonLog(errOut, "lag notify.%s: %v", m.notify, err)
+		}
+	}
+}
+
+func (m *lagMonitor) close() {
+	if m != nil && m.db != nil {
+		m.db.Close()
+	}
+}
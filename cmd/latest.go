@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latestPoints is the --latest-points flag: keep latest_points current after every batch.
+var latestPoints bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&latestPoints, "latest-points", false, "Also maintain a latest_points table holding each exported entity's newest state")
+}
+
+// latestPointsTable holds one row per entity with its newest exported reading, so dashboards that
+// only need current values don't scan the history tables.
+var latestPointsTable = &tableSpec{
+	name: "latest_points",
+	columns: []columnSpec{
+		{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "source_table", sqlType: "VARCHAR(64) NOT NULL"},
+		{name: "state", sqlType: "VARCHAR(255) NULL"},
+		{name: "numeric_state", sqlType: "DOUBLE NULL"},
+		{name: "latitude", sqlType: "DOUBLE NULL"},
+		{name: "longitude", sqlType: "DOUBLE NULL"},
+		{name: "last_updated", sqlType: "DATETIME(6) NOT NULL"},
+	},
+	primaryKey: []string{"entity_id"},
+	timeColumn: "last_updated",
+	newerOnly:  true,
+}
+
+// latestEnsured records the sinks latest_points was already created on.
+var latestEnsured sync.Map
+
+// rowReading is the entity-level view of an exported row used by latest_points.
+type rowReading struct {
+	entityID  string
+	at        time.Time
+	state     string
+	value     sql.NullFloat64
+	latitude  sql.NullFloat64
+	longitude sql.NullFloat64
+}
+
+// readingFromRow extracts the reading of a row about to be written to table. Tables without
+// entity_id and time columns (summaries, normalized facts) have none.
+func readingFromRow(table *tableSpec, values []any) (rowReading, bool) {
+	columns := table.writeColumns()
+	if len(values) != len(columns) || table.timeColumn == "" {
+		return rowReading{}, false
+	}
+
+	var (
+		r                  rowReading
+		haveEntity, haveAt bool
+		haveState          bool
+	)
+	for i, c := range columns {
+		switch c {
+		case "entity_id":
+			r.entityID, haveEntity = rowString(values[i])
+		case table.timeColumn:
+			r.at, haveAt = rowTime(values[i])
+		case "state", "zone":
+			r.state, haveState = rowString(values[i])
+		case "numeric_state", "battery_level":
+			r.value = rowFloat(values[i])
+		case "latitude":
+			r.latitude = rowFloat(values[i])
+		case "longitude":
+			r.longitude = rowFloat(values[i])
+		}
+	}
+	if !haveEntity || r.entityID == "" || !haveAt {
+		return rowReading{}, false
+	}
+	if !haveState && r.value.Valid {
+		r.state = strconv.FormatFloat(r.value.Float64, 'f', -1, 64)
+	}
+	return r, true
+}
+
+// updateLatestPoints upserts the newest reading per entity among rows just written to table.
+func updateLatestPoints(ctx context.Context, sink Sink, table *tableSpec, rows [][]any) error {
+	if table == latestPointsTable {
+		return nil
+	}
+
+	newest := make(map[string]rowReading)
+	var order []string
+	for _, values := range rows {
+		r, ok := readingFromRow(table, values)
+		if !ok {
+			continue
+		}
+		current, seen := newest[r.entityID]
+		if !seen {
+			order = append(order, r.entityID)
+		}
+		if !seen || !r.at.Before(current.at) {
+			newest[r.entityID] = r
+		}
+	}
+	if len(order) == 0 {
+		return nil
+	}
+
+	if _, done := latestEnsured.Load(sink); !done {
+		if err := sink.EnsureSchema(ctx, latestPointsTable); err != nil {
+			return fmt.Errorf("ensure latest_points table: %w", err)
+		}
+		latestEnsured.Store(sink, true)
+	}
+
+	latestRows := make([][]any, 0, len(order))
+	for _, entityID := range order {
+		r := newest[entityID]
+		var state sql.NullString
+		if r.state != "" {
+			state = sql.NullString{String: r.state, Valid: true}
+		}
+		latestRows = append(latestRows, []any{r.entityID, table.name, state, r.value, r.latitude, r.longitude, r.at})
+	}
+	return sink.WriteBatch(ctx, latestPointsTable, latestRows)
+}
+
+func rowString(v any) (string, bool) {
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case sql.NullString:
+		return t.String, t.Valid
+	case *string:
+		if t != nil {
+			return *t, true
+		}
+	}
+	return "", false
+}
+
+func rowTime(v any) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case sql.NullTime:
+		return t.Time, t.Valid
+	}
+	return time.Time{}, false
+}
+
+func rowInt64(v any) (int64, bool) {
+	switch t := v.(type) {
+	case int64:
+		return t, true
+	case int:
+		return int64(t), true
+	case sql.NullInt64:
+		return t.Int64, t.Valid
+	}
+	return 0, false
+}
+
+func rowFloat(v any) sql.NullFloat64 {
+	switch t := v.(type) {
+	case float64:
+		return sql.NullFloat64{Float64: t, Valid: true}
+	case sql.NullFloat64:
+		return t
+	case *float64:
+		if t != nil {
+			return sql.NullFloat64{Float64: *t, Valid: true}
+		}
+	}
+	return sql.NullFloat64{}
+}
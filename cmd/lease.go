@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// leaseTTL is the --lease-ttl flag: how long a table lease lasts without renewal; 0 turns leases
+// off.
+var leaseTTL time.Duration
+
+func init() {
+	rootCmd.PersistentFlags().DurationVar(&leaseTTL, "lease-ttl", 0, "Take a lease on each destination table before reading its watermarks, renewed while the export runs, so several ha-tools instances exporting to one table take turns instead of writing it at once; a crashed instance's lease runs out after this long (e.g. 2m; 0 = no leases; mysql sink only)")
+}
+
+func validateLeaseTTL() error {
+	if leaseTTL < 0 {
+		return fmt.Errorf("--lease-ttl must not be negative, got %s", leaseTTL)
+	}
+	if leaseTTL > 0 && leaseTTL < 3*time.Second {
+		return fmt.Errorf("--lease-ttl must be at least 3s, got %s", leaseTTL)
+	}
+	return nil
+}
+
+// leasesTable holds the table leases: who exports to a table, and until when. expires_at is in
+// the server's clock, so instances on hosts with skewed clocks agree on it.
+var leasesTable = &tableSpec{
+	name: "ha_tools_leases",
+	columns: []columnSpec{
+		{name: "table_name", sqlType: "VARCHAR(64) NOT NULL"},
+		{name: "owner", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "expires_at", sqlType: "DATETIME(6) NOT NULL"},
+	},
+	primaryKey: []string{"table_name"},
+}
+
+// leaseOwner names this process in ha_tools_leases: its host, pid, and a random suffix, so a
+// restarted process with a recycled pid does not inherit a lease.
+var leaseOwner = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}()
+
+// tableLease is a lease this process holds.
+type tableLease struct {
+	// deadline is when the lease runs out (Unix nanoseconds, local clock) unless renewed. It is
+	// counted from before each renewal was sent, so it never trails the server's expires_at.
+	deadline atomic.Int64
+	// lost is set when another instance took the lease over.
+	lost atomic.Bool
+	stop context.CancelFunc
+}
+
+// acquireLease takes the lease on the table, waiting while another instance holds it, and renews
+// it in the background until releaseLease. The caller then reads the watermarks, which include
+// what the previous holder wrote.
+func (s *mysqlSink) acquireLease(ctx context.Context, table *tableSpec) error {
+	if leaseTTL == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	_, held := s.leases[table.name]
+	s.mu.Unlock()
+	if held {
+		return nil
+	}
+	s.leaseSchema.Do(func() { s.leaseSchemaErr = s.EnsureSchema(ctx, leasesTable) })
+	if s.leaseSchemaErr != nil {
+		return s.leaseSchemaErr
+	}
+
+	lease := &tableLease{}
+	waiting := false
+	for {
+		sent := time.Now()
+		holder, expires, err := s.claimLease(ctx, table.name)
+		if err != nil {
+			return fmt.Errorf("take lease on %s: %w", table.name, err)
+		}
+		if holder == leaseOwner {
+			lease.deadline.Store(sent.Add(leaseTTL).UnixNano())
+			break
+		}
+		if !waiting {
+			fmt.Fprintf(os.Stderr, "waiting for %s: %s holds its lease until %s\n", table.name, holder, expires.Format(time.RFC3339))
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait for lease on %s: %w", table.name, ctx.Err())
+		case <-time.After(leaseTTL / 3):
+		}
+	}
+
+	renewCtx, stop := context.WithCancel(context.Background())
+	lease.stop = stop
+	s.mu.Lock()
+	s.leases[table.name] = lease
+	s.mu.Unlock()
+	go s.renewLease(renewCtx, table.name, lease)
+	return nil
+}
+
+// claimLease takes the lease when it is free, expired, or already this process's, extending it by
+// --lease-ttl, and returns the lease's holder afterwards. MySQL applies the assignments in order,
+// so expires_at only moves when owner is this process.
+func (s *mysqlSink) claimLease(ctx context.Context, table string) (string, time.Time, error) {
+	stmt := `
+INSERT INTO ha_tools_leases (table_name, owner, expires_at)
+VALUES (?, ?, NOW(6) + INTERVAL ? MICROSECOND)
+ON DUPLICATE KEY UPDATE
+    owner = IF(expires_at < NOW(6) OR owner = VALUES(owner), VALUES(owner), owner),
+    expires_at = IF(owner = VALUES(owner), VALUES(expires_at), expires_at)`
+	if _, err := execStatement(ctx, s.db, stmt, table, leaseOwner, leaseTTL.Microseconds()); err != nil {
+		return "", time.Time{}, err
+	}
+	var (
+		holder  string
+		expires time.Time
+	)
+	err := queryRowStatement(ctx, s.db, "SELECT owner, expires_at FROM ha_tools_leases WHERE table_name = ?", []any{table}, &holder, &expires)
+	return holder, expires, err
+}
+
+// renewLease extends the lease every third of --lease-ttl. Failed renewals are retried; the lease
+// then runs out at its deadline, which checkLease enforces.
+func (s *mysqlSink) renewLease(ctx context.Context, table string, lease *tableLease) {
+	ticker := time.NewTicker(leaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		sent := time.Now()
+		holder, _, err := s.claimLease(ctx, table)
+		switch {
+		case err != nil:
+			if ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "renew lease on %s: %v\n", table, err)
+			}
+		case holder != leaseOwner:
+			lease.lost.Store(true)
+			return
+		default:
+			lease.deadline.Store(sent.Add(leaseTTL).UnixNano())
+		}
+	}
+}
+
+// checkLease fails when this process held the table's lease and lost it, or could not renew it in
+// time: another instance may be writing the table now.
+func (s *mysqlSink) checkLease(table string) error {
+	s.mu.Lock()
+	lease, ok := s.leases[table]
+	s.mu.Unlock()
+	switch {
+	case !ok:
+		return nil
+	case lease.lost.Load():
+		return fmt.Errorf("lost the lease on %s to another instance; stopped writing it", table)
+	case time.Now().UnixNano() > lease.deadline.Load():
+		return fmt.Errorf("could not renew the lease on %s within --lease-ttl; stopped writing it", table)
+	}
+	return nil
+}
+
+// releaseLease gives up the table's lease, so a waiting instance can take it at once.
+func (s *mysqlSink) releaseLease(ctx context.Context, table string) error {
+	s.mu.Lock()
+	lease, ok := s.leases[table]
+	delete(s.leases, table)
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	lease.stop()
+	if _, err := execStatement(ctx, s.db, "DELETE FROM ha_tools_leases WHERE table_name = ? AND owner = ?", table, leaseOwner); err != nil {
+		return fmt.Errorf("release lease on %s: %w", table, err)
+	}
+	return nil
+}
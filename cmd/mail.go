@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// smtpConfig is the mail server reports are sent through.
+type smtpConfig struct {
+	Host string `json:"host"`
+	// Port defaults to 587 (STARTTLS when the server offers it); 465 uses implicit TLS.
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	// Password may be a secret reference; see secrets.go.
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+func (c *smtpConfig) validate() error {
+	if c.Host == "" || c.From == "" || len(c.To) == 0 {
+		return errors.New("smtp: host, from, and to are required")
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("smtp: invalid port %d", c.Port)
+	}
+	if c.Password != "" {
+		if err := validateSecretRef(c.Password); err != nil {
+			return fmt.Errorf("smtp.password: %w", err)
+		}
+	}
+	return nil
+}
+
+// mailAttachment is a file attached to a message.
+type mailAttachment struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// sendMail sends a message with an HTML or plain-text body and optional attachments to the
+// configured recipients.
+func sendMail(ctx context.Context, cfg *smtpConfig, subject, bodyType string, body []byte, attachments ...mailAttachment) error {
+	msg, err := buildMail(cfg, subject, bodyType, body, attachments)
+	if err != nil {
+		return err
+	}
+
+	port := cfg.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		password, err := resolveSecret(ctx, cfg.Password)
+		if err != nil {
+			return fmt.Errorf("resolve smtp.password: %w", err)
+		}
+		auth = smtp.PlainAuth("", cfg.Username, password, cfg.Host)
+	}
+
+	if port != 465 {
+		if err := smtp.SendMail(addr, auth, cfg.From, cfg.To, msg); err != nil {
+			return fmt.Errorf("send mail via %s: %w", addr, err)
+		}
+		return nil
+	}
+
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: 30 * time.Second}, Config: &tls.Config{ServerName: cfg.Host}}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("connect to %s: %w", addr, err)
+	}
+	client, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("connect to %s: %w", addr, err)
+	}
+	defer client.Close()
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := client.Mail(cfg.From); err != nil {
+		return fmt.Errorf("send mail via %s: %w", addr, err)
+	}
+	for _, to := range cfg.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("send mail to %s: %w", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("send mail via %s: %w", addr, err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("send mail via %s: %w", addr, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("send mail via %s: %w", addr, err)
+	}
+	return client.Quit()
+}
+
+// buildMail renders the MIME message: the body alone, or with attachments a multipart/mixed one.
+func buildMail(cfg *smtpConfig, subject, bodyType string, body []byte, attachments []mailAttachment) ([]byte, error) {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+
+	if len(attachments) == 0 {
+		fmt.Fprintf(&msg, "Content-Type: %s; charset=utf-8\r\nContent-Transfer-Encoding: base64\r\n\r\n", bodyType)
+		writeBase64Lines(&msg, body)
+		return msg.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&msg)
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+	parts := append([]mailAttachment{{contentType: bodyType + "; charset=utf-8", data: body}}, attachments...)
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "base64")
+		if part.name != "" {
+			header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": part.name}))
+		}
+		w, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		var encoded bytes.Buffer
+		writeBase64Lines(&encoded, part.data)
+		if _, err := w.Write(encoded.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// writeBase64Lines writes data base64-encoded in lines of 76 characters, as MIME requires.
+func writeBase64Lines(buf *bytes.Buffer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mapMatchWindow is the most points sent per request; OSRM's default max-matching-size is 100.
+const mapMatchWindow = 100
+
+// mapMatcher snaps GPS traces to roads through an OSRM-compatible match service.
+type mapMatcher struct {
+	baseURL string
+	client  *http.Client
+}
+
+// newMapMatcher accepts the match service prefix, e.g. http://localhost:5000/match/v1/driving.
+func newMapMatcher(baseURL string) *mapMatcher {
+	return &mapMatcher{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// matchedPoint is the snapped location of one input point; both fields are NULL when the service
+// could not match it.
+type matchedPoint struct {
+	latitude  sql.NullFloat64
+	longitude sql.NullFloat64
+}
+
+type osrmMatchResponse struct {
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	Tracepoints []*struct {
+		Location []float64 `json:"location"`
+	} `json:"tracepoints"`
+}
+
+// Match snaps one entity's time-ordered points. A trace the service cannot match yields NULL
+// matches rather than an error.
+func (m *mapMatcher) Match(ctx context.Context, points []gpsRow) ([]matchedPoint, error) {
+	matched := make([]matchedPoint, len(points))
+	if len(points) < 2 {
+		return matched, nil
+	}
+
+	coords := make([]string, len(points))
+	radiuses := make([]string, len(points))
+	timestamps := make([]string, len(points))
+	increasing := true
+	for i, p := range points {
+		coords[i] = strconv.FormatFloat(p.longitude.Float64, 'f', -1, 64) + "," + strconv.FormatFloat(p.latitude.Float64, 'f', -1, 64)
+
+		radius := 10.0
+		if p.accuracy.Valid && p.accuracy.Float64 > 0 {
+			radius = math.Min(p.accuracy.Float64, 100)
+		}
+		radiuses[i] = strconv.FormatFloat(radius, 'f', 1, 64)
+
+		ts := p.lastUpdated.Time.Unix()
+		timestamps[i] = strconv.FormatInt(ts, 10)
+		if !p.lastUpdated.Valid || (i > 0 && ts <= points[i-1].lastUpdated.Time.Unix()) {
+			increasing = false
+		}
+	}
+
+	// Every value is numeric, so the query is assembled as-is to keep OSRM's ';' separators literal.
+	query := "tidy=true&radiuses=" + strings.Join(radiuses, ";")
+	if increasing {
+		query += "&timestamps=" + strings.Join(timestamps, ";")
+	}
+	endpoint := m.baseURL + "/" + strings.Join(coords, ";") + "?" + query
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("build map-match request: %w", err)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("call map-match service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, fmt.Errorf("read map-match response: %w", err)
+	}
+
+	var decoded osrmMatchResponse
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return nil, fmt.Errorf("decode map-match response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	switch decoded.Code {
+	case "Ok":
+	case "NoMatch", "NoSegment", "TooBig":
+		return matched, nil
+	default:
+		return nil, fmt.Errorf("map-match service returned %s: %s", decoded.Code, decoded.Message)
+	}
+
+	for i, tp := range decoded.Tracepoints {
+		if i >= len(matched) || tp == nil || len(tp.Location) != 2 {
+			continue
+		}
+		matched[i] = matchedPoint{
+			latitude:  sql.NullFloat64{Float64: tp.Location[1], Valid: true},
+			longitude: sql.NullFloat64{Float64: tp.Location[0], Valid: true},
+		}
+	}
+	return matched, nil
+}
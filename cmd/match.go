@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	matchSQLitePath string
+	matchEntity     string
+	matchMode       string
+	matchExporter   string
+)
+
+// matchCmd previews which entities an exporter's --entity selects.
+var matchCmd = &cobra.Command{
+	Use:   "match",
+	Short: "Show which entity_ids an exporter's --entity selects, with their row counts",
+	Long:  "Runs the entity selection of energy or climate-sensors against the recorder and lists every matching entity_id with its number of states and the time of its first and last one, so lookalike sensors the slug pulls in show up before they are exported.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if matchSQLitePath == "" {
+			return errors.New("sqlite database path is required")
+		}
+		family, err := matchFamily()
+		if err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		sqliteDB, err := openRecorder(ctx, matchSQLitePath)
+		if err != nil {
+			return err
+		}
+		defer sqliteDB.Close()
+		if family, err = family.resolve(ctx, sqliteDB); err != nil {
+			return err
+		}
+
+		query := `
+SELECT sm.entity_id, COUNT(s.state_id), MIN(s.last_updated_ts), MAX(s.last_updated_ts)
+FROM states s
+JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+LEFT JOIN state_attributes sa ON s.attributes_id = sa.attributes_id
+WHERE ` + family.where + `
+GROUP BY sm.entity_id
+ORDER BY sm.entity_id`
+		rows, err := sqliteDB.QueryContext(ctx, query, family.args...)
+		if err != nil {
+			return fmt.Errorf("query sqlite database: %w", err)
+		}
+		defer rows.Close()
+		var matches []entityMatch
+		for rows.Next() {
+			var (
+				m           entityMatch
+				first, last sql.NullFloat64
+			)
+			if err := rows.Scan(&m.entityID, &m.rows, &first, &last); err != nil {
+				return fmt.Errorf("scan sqlite row: %w", err)
+			}
+			if first.Valid && last.Valid {
+				m.first, m.last = unixSeconds(first.Float64), unixSeconds(last.Float64)
+			}
+			matches = append(matches, m)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("iterate sqlite rows: %w", err)
+		}
+		return renderMatches(cmd.OutOrStdout(), matches)
+	},
+}
+
+func init() {
+	matchCmd.Flags().StringVar(&matchSQLitePath, "sqlite", "", "Path to the Home Assistant SQLite recorder database")
+	matchCmd.Flags().StringVar(&matchEntity, "entity", "", "The --entity value to preview")
+	addMatchFlag(matchCmd, &matchMode)
+	matchCmd.Flags().StringVar(&matchExporter, "exporter", "energy", "Exporter whose selection to preview: energy or climate-sensors")
+	_ = matchCmd.MarkFlagRequired("sqlite")
+
+	rootCmd.AddCommand(matchCmd)
+}
+
+// matchFamily returns the family the exporter would select with --entity.
+func matchFamily() (numericFamily, error) {
+	selector, err := newEntitySelector(matchMode, matchEntity)
+	if err != nil {
+		return numericFamily{}, err
+	}
+	switch matchExporter {
+	case "energy":
+		if matchEntity == "" {
+			return numericFamily{}, errors.New("entity is required for energy")
+		}
+		return newEnergyFamily(selector), nil
+	case "climate-sensors":
+		return newClimateFamily(selector, false), nil
+	default:
+		return numericFamily{}, fmt.Errorf("unknown --exporter %q (supported: energy, climate-sensors)", matchExporter)
+	}
+}
+
+// entityMatch is one entity an exporter's selection matches.
+type entityMatch struct {
+	entityID    string
+	rows        int64
+	first, last time.Time
+}
+
+func unixSeconds(ts float64) time.Time {
+	return time.Unix(0, int64(ts*1e9))
+}
+
+func renderMatches(out io.Writer, matches []entityMatch) error {
+	if len(matches) == 0 {
+		_, err := fmt.Fprintln(out, "no entity matches")
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENTITY\tROWS\tFIRST\tLAST")
+	var total int64
+	for _, m := range matches {
+		total += m.rows
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", m.entityID, m.rows, formatMatchTime(m.first), formatMatchTime(m.last))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "%d entities, %d rows\n", len(matches), total)
+	return err
+}
+
+func formatMatchTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
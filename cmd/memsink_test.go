@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memSink is an in-process Sink for tests. It keeps each table's rows in memory the way the mysql
+// sink stores them: upserted by the primary key (or the first unique key when the primary key is
+// generated), with times rounded to their DATETIME column's precision, so exporters can be driven
+// end to end and their output inspected.
+type memSink struct {
+	store *memStore
+}
+
+// memStore is the destination memSinks opened on one target share, like a database several runs
+// export to.
+type memStore struct {
+	mu     sync.Mutex
+	tables map[string]*memTable
+}
+
+// memTable is one destination table: its columns in order and its rows by key.
+type memTable struct {
+	spec    *tableSpec
+	columns []string
+	keys    []string
+	rows    map[string]map[string]any
+	// order is the keys in first-written order.
+	order []string
+}
+
+var memStores sync.Map
+
+func init() {
+	RegisterSink("memory", func(ctx context.Context, target string) (Sink, error) {
+		store, ok := memStores.Load(target)
+		if !ok {
+			return nil, fmt.Errorf("no memory store %q", target)
+		}
+		return &memSink{store: store.(*memStore)}, nil
+	})
+}
+
+// newMemStore creates an empty store for the test and points --sink at it, returning the target to
+// pass as the DSN.
+func newMemStore(t *testing.T) (string, *memStore) {
+	t.Helper()
+	target := t.Name()
+	store := &memStore{tables: make(map[string]*memTable)}
+	memStores.Store(target, store)
+	saved := sinkName
+	sinkName = "memory"
+	t.Cleanup(func() {
+		sinkName = saved
+		memStores.Delete(target)
+	})
+	return target, store
+}
+
+func (s *memSink) EnsureSchema(ctx context.Context, table *tableSpec) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	mt, ok := s.store.tables[table.name]
+	if !ok {
+		mt = &memTable{spec: table, keys: memTableKey(table), rows: make(map[string]map[string]any)}
+		s.store.tables[table.name] = mt
+	}
+	// Like addMissingColumns, columns a table gained are added; stored rows hold NULL in them.
+	for _, c := range table.columns {
+		if !slices.Contains(mt.columns, c.name) {
+			mt.columns = append(mt.columns, c.name)
+		}
+	}
+	mt.spec = table
+	return nil
+}
+
+// memTableKey returns the columns rows are upserted by.
+func memTableKey(table *tableSpec) []string {
+	written := table.writeColumns()
+	candidates := append([][]string{table.primaryKey}, table.uniqueKeys...)
+	for _, key := range candidates {
+		if len(key) > 0 && !slices.ContainsFunc(key, func(c string) bool { return !slices.Contains(written, c) }) {
+			return key
+		}
+	}
+	return nil
+}
+
+func (s *memSink) WriteBatch(ctx context.Context, table *tableSpec, rows [][]any) error {
+	columns := table.writeColumns()
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	mt, ok := s.store.tables[table.name]
+	if !ok {
+		return fmt.Errorf("table %s does not exist", table.name)
+	}
+	for _, row := range rows {
+		if len(row) != len(columns) {
+			return fmt.Errorf("%s row has %d values for %d columns", table.name, len(row), len(columns))
+		}
+		record := make(map[string]any, len(columns))
+		for i, column := range columns {
+			value, err := driver.DefaultParameterConverter.ConvertValue(row[i])
+			if err != nil {
+				return fmt.Errorf("convert %s.%s: %w", table.name, column, err)
+			}
+			if t, ok := value.(time.Time); ok {
+				value = roundToColumn(table, column, t)
+			}
+			record[column] = value
+		}
+		key := fmt.Sprint(len(mt.order))
+		if len(mt.keys) > 0 {
+			parts := make([]string, len(mt.keys))
+			for i, column := range mt.keys {
+				parts[i] = memValueKey(record[column])
+			}
+			key = strings.Join(parts, "\x00")
+		}
+		if _, exists := mt.rows[key]; !exists {
+			mt.order = append(mt.order, key)
+		}
+		mt.rows[key] = record
+	}
+	return nil
+}
+
+// roundToColumn rounds t the way MySQL stores it in the column: to microseconds in DATETIME(6), to
+// whole seconds in DATETIME.
+func roundToColumn(table *tableSpec, column string, t time.Time) time.Time {
+	for _, c := range table.columns {
+		if c.name != column {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(c.sqlType, "DATETIME(6)"):
+			return t.Round(time.Microsecond)
+		case strings.HasPrefix(c.sqlType, "DATETIME"):
+			return t.Round(time.Second)
+		}
+	}
+	return t
+}
+
+// memValueKey renders a key column value so equal times in different zones compare equal.
+func memValueKey(v any) string {
+	if t, ok := v.(time.Time); ok {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+func (s *memSink) LoadWatermarks(ctx context.Context, table *tableSpec) (map[string]time.Time, error) {
+	watermarks := make(map[string]time.Time)
+	for _, row := range s.store.rows(table.name) {
+		at, ok := row[table.timeColumn].(time.Time)
+		if !ok {
+			continue
+		}
+		entityID := fmt.Sprint(row[table.entityColumn])
+		if current, seen := watermarks[entityID]; !seen || at.After(current) {
+			watermarks[entityID] = at
+		}
+	}
+	return watermarks, nil
+}
+
+func (s *memSink) LoadWatermarkTies(ctx context.Context, table *tableSpec) (map[string]int64, error) {
+	watermarks, err := s.LoadWatermarks(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	ties := make(map[string]int64)
+	for _, row := range s.store.rows(table.name) {
+		entityID := fmt.Sprint(row[table.entityColumn])
+		at, ok := row[table.timeColumn].(time.Time)
+		id, isID := row[table.idColumn].(int64)
+		if !ok || !isID || !at.Equal(watermarks[entityID]) {
+			continue
+		}
+		if tie, seen := ties[entityID]; !seen || id > tie {
+			ties[entityID] = id
+		}
+	}
+	return ties, nil
+}
+
+func (s *memSink) Close() error { return nil }
+
+// rows returns the table's rows in first-written order.
+func (s *memStore) rows(table string) []map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mt, ok := s.tables[table]
+	if !ok {
+		return nil
+	}
+	rows := make([]map[string]any, 0, len(mt.order))
+	for _, key := range mt.order {
+		rows = append(rows, mt.rows[key])
+	}
+	return rows
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"container/list"
+	"database/sql"
+	"fmt"
+)
+
+// attributesCacheSize is the --attributes-cache flag: how many parsed attribute sets an export
+// keeps by attributes_id.
+var attributesCacheSize int
+
+func init() {
+	rootCmd.PersistentFlags().IntVar(&attributesCacheSize, "attributes-cache", 4096, "Parsed attribute sets each export keeps by the recorder's attributes_id, so the states sharing one (most states of a chatty sensor) parse it once (0 = parse every row)")
+}
+
+func validateAttributesCache() error {
+	if attributesCacheSize < 0 {
+		return fmt.Errorf("--attributes-cache must not be negative, got %d", attributesCacheSize)
+	}
+	return nil
+}
+
+// metadataCache holds the stateMetadata of the most recently used attribute sets. The recorder
+// stores each distinct attributes document once in state_attributes, so a sensor whose attributes
+// never change points every state at the same attributes_id. A cache lives for one recorder query,
+// since ids of different recorders have nothing in common.
+type metadataCache struct {
+	size    int
+	entries map[int64]*list.Element
+	// recent orders the entries from most to least recently used.
+	recent *list.List
+}
+
+type metadataCacheEntry struct {
+	id   int64
+	meta stateMetadata
+}
+
+func newMetadataCache() *metadataCache {
+	return &metadataCache{size: attributesCacheSize, entries: make(map[int64]*list.Element), recent: list.New()}
+}
+
+// metadata returns the stateMetadata of the attributes raw stored under id, parsing them only when
+// the cache does not hold id. States without attributes (a NULL id) are parsed every time. Parse
+// errors are not cached.
+func (c *metadataCache) metadata(id sql.NullInt64, raw string) (stateMetadata, error) {
+	if !id.Valid || c.size == 0 {
+		return extractStateMetadata(raw)
+	}
+	if e, ok := c.entries[id.Int64]; ok {
+		c.recent.MoveToFront(e)
+		return e.Value.(*metadataCacheEntry).meta, nil
+	}
+	meta, err := extractStateMetadata(raw)
+	if err != nil {
+		return meta, err
+	}
+	if c.recent.Len() >= c.size {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.entries, oldest.Value.(*metadataCacheEntry).id)
+	}
+	c.entries[id.Int64] = c.recent.PushFront(&metadataCacheEntry{id: id.Int64, meta: meta})
+	return meta, nil
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// metricsPath is the --metrics-file flag.
+var metricsPath string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&metricsPath, "metrics-file", "", "Write the run's row counters in the Prometheus text exposition format to this file when the command ends, for a textfile collector")
+}
+
+// rowOutcomes are the values of the outcome label of ha_tools_rows_total, with their counters.
+var rowOutcomes = []struct {
+	name    string
+	counter *rowCounter
+}{
+	{"written", &writtenRows},
+	{"already_exported", &exportedRows},
+	{"filtered", &filteredRows},
+	{"merged", &mergedRows},
+}
+
+// writeRunMetrics writes the row counters to --metrics-file. Every outcome is written for every
+// table any counter saw, zeros included, so a query on one outcome finds the table's series.
+func writeRunMetrics(command string) error {
+	counts := make(map[string]map[string]int64, len(rowOutcomes))
+	tables := map[string]bool{}
+	for _, o := range rowOutcomes {
+		counts[o.name], _ = o.counter.counts()
+		for table := range counts[o.name] {
+			tables[table] = true
+		}
+	}
+	sorted := make([]string, 0, len(tables))
+	for table := range tables {
+		sorted = append(sorted, table)
+	}
+	sort.Strings(sorted)
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# HELP ha_tools_rows_total Rows an export read, per destination table and outcome: written, already_exported (by an earlier run, going by the watermarks), filtered, or merged (by aggregation).")
+	fmt.Fprintln(&buf, "# TYPE ha_tools_rows_total counter")
+	for _, table := range sorted {
+		for _, o := range rowOutcomes {
+			fmt.Fprintf(&buf, "ha_tools_rows_total{command=\"%s\",table=\"%s\",outcome=\"%s\"} %d\n", prometheusLabelEscaper.Replace(command), prometheusLabelEscaper.Replace(table), o.name, counts[o.name][table])
+		}
+	}
+	if err := replaceFile(metricsPath, buf.Bytes()); err != nil {
+		return fmt.Errorf("write metrics file: %w", err)
+	}
+	return nil
+}
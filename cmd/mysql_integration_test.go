@@ -0,0 +1,406 @@
+//go:build integration
+
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+)
+
+// The tests in this file run the exporters against MySQL and TiDB servers started in Docker, to
+// cover the SQL the in-memory sink cannot: destination migrations, the staging swap, index plans,
+// and the tables' charset. Run them with
+//
+//	go test -tags integration ./cmd -run TestMySQLCompatibleDestinations
+//
+// They are skipped when no Docker daemon is reachable.
+
+// destinationServer is a MySQL-compatible server running in a container.
+type destinationServer struct {
+	dialect string
+	// dsn connects to the server without selecting a database.
+	dsn string
+	db  *sql.DB
+}
+
+// startDestinationServer starts a container from image, waiting until it accepts connections.
+func startDestinationServer(t *testing.T, pool *dockertest.Pool, dialect string, opts *dockertest.RunOptions, port, credentials string) *destinationServer {
+	t.Helper()
+	resource, err := pool.RunWithOptions(opts, func(config *docker.HostConfig) {
+		config.AutoRemove = true
+		config.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		t.Fatalf("start %s:%s: %v", opts.Repository, opts.Tag, err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Purge(resource); err != nil {
+			t.Logf("remove %s container: %v", dialect, err)
+		}
+	})
+	// The container outlives a test binary that is killed, but not by long.
+	_ = resource.Expire(900)
+
+	server := &destinationServer{
+		dialect: dialect,
+		dsn:     fmt.Sprintf("%s@tcp(localhost:%s)/", credentials, resource.GetPort(port)),
+	}
+	err = pool.Retry(func() error {
+		db, err := sql.Open("mysql", server.dsn)
+		if err != nil {
+			return err
+		}
+		if err := db.Ping(); err != nil {
+			db.Close()
+			return err
+		}
+		server.db = db
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("connect to %s: %v", dialect, err)
+	}
+	t.Cleanup(func() { server.db.Close() })
+	return server
+}
+
+// database creates an empty database for the test, returning the DSN exporters write to and a
+// connection for the test to inspect it.
+func (s *destinationServer) database(t *testing.T) (string, *sql.DB) {
+	t.Helper()
+	name := "ha_" + strings.NewReplacer("/", "_", " ", "_", "-", "_").Replace(strings.ToLower(t.Name()))
+	if len(name) > 64 {
+		name = name[len(name)-64:]
+	}
+	if _, err := s.db.Exec("DROP DATABASE IF EXISTS " + name); err != nil {
+		t.Fatalf("drop database %s: %v", name, err)
+	}
+	if _, err := s.db.Exec("CREATE DATABASE " + name); err != nil {
+		t.Fatalf("create database %s: %v", name, err)
+	}
+
+	dsn := s.dsn + name + "?parseTime=true"
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return dsn, db
+}
+
+// useDestination points the exporters at the server's dialect through the mysql sink, with
+// destructive migrations confirmed, restoring the flags after the test.
+func (s *destinationServer) useDestination(t *testing.T) {
+	t.Helper()
+	savedSink, savedDialectName, savedDialect := sinkName, dialectName, destDialect
+	savedYes, savedWriteMode, savedConfig := assumeYes, writeMode, appConfig
+	t.Cleanup(func() {
+		sinkName, dialectName, destDialect = savedSink, savedDialectName, savedDialect
+		assumeYes, writeMode, appConfig = savedYes, savedWriteMode, savedConfig
+	})
+	d, err := resolveDialect(s.dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinkName, dialectName, destDialect = "mysql", s.dialect, d
+	assumeYes, writeMode, appConfig = true, writeModeUpsert, &fileConfig{}
+}
+
+func TestMySQLCompatibleDestinations(t *testing.T) {
+	pool, err := dockertest.NewPool("")
+	if err == nil {
+		err = pool.Client.Ping()
+	}
+	if err != nil {
+		t.Skipf("docker is not available: %v", err)
+	}
+	pool.MaxWait = 3 * time.Minute
+
+	servers := []struct {
+		dialect     string
+		opts        *dockertest.RunOptions
+		port        string
+		credentials string
+	}{
+		{
+			dialect: "mysql",
+			opts: &dockertest.RunOptions{
+				Repository: "mysql",
+				Tag:        "8.0",
+				Env:        []string{"MYSQL_ROOT_PASSWORD=secret"},
+			},
+			port:        "3306/tcp",
+			credentials: "root:secret",
+		},
+		{
+			dialect: "tidb",
+			opts: &dockertest.RunOptions{
+				Repository: "pingcap/tidb",
+				Tag:        "v7.5.1",
+			},
+			port:        "4000/tcp",
+			credentials: "root",
+		},
+	}
+	for _, s := range servers {
+		t.Run(s.dialect, func(t *testing.T) {
+			server := startDestinationServer(t, pool, s.dialect, s.opts, s.port, s.credentials)
+			t.Run("numeric points key", server.testNumericPointsKeyMigration)
+			t.Run("time precision", server.testTimePrecisionMigration)
+			t.Run("staging swap", server.testStagingSwap)
+			t.Run("index plan", server.testIndexPlan)
+			t.Run("charset", server.testCharset)
+		})
+	}
+}
+
+// exportEnergy runs the energy exporter over the plug_1 entities of the recorder.
+func exportEnergy(t *testing.T, recorder, dsn string) {
+	t.Helper()
+	selector, err := newEntitySelector(matchPrefix, "plug_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	startRun()
+	if err := transferNumericData(context.Background(), recorder, dsn, newEnergyFamily(selector), numericExportOptions{idStrategy: idStrategyAuto}); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+}
+
+func queryCount(t *testing.T, db *sql.DB, query string, args ...any) int64 {
+	t.Helper()
+	var n int64
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", strings.TrimSpace(query), err)
+	}
+	return n
+}
+
+// legacyEnergyPoints is energy_points as a release before numericPointsKey created it.
+const legacyEnergyPoints = `
+CREATE TABLE energy_points (
+    state_id BIGINT NOT NULL AUTO_INCREMENT,
+    entity_id VARCHAR(255) NOT NULL,
+    state VARCHAR(255) NOT NULL,
+    numeric_state DOUBLE NULL,
+    last_updated %s NULL,
+    PRIMARY KEY (state_id)
+) DEFAULT CHARSET=%s
+`
+
+func (s *destinationServer) testNumericPointsKeyMigration(t *testing.T) {
+	s.useDestination(t)
+	recorder := newRecorderFixture(t, 5, 10*time.Minute)
+	dsn, db := s.database(t)
+
+	if _, err := db.Exec(fmt.Sprintf(legacyEnergyPoints, "DATETIME(6)", "utf8mb4")); err != nil {
+		t.Fatalf("create legacy table: %v", err)
+	}
+	// Rows the older release stored twice under new state_ids.
+	if _, err := db.Exec(`
+INSERT INTO energy_points (state_id, entity_id, state, numeric_state, last_updated) VALUES
+    (1, 'sensor.legacy_power', '10', 10, '2024-01-01 00:00:00'),
+    (2, 'sensor.legacy_power', '11', 11, '2024-01-01 00:00:00'),
+    (3, 'sensor.legacy_power', '12', 12, '2024-01-01 00:01:00')`); err != nil {
+		t.Fatalf("insert legacy rows: %v", err)
+	}
+
+	exportEnergy(t, recorder, dsn)
+	indexes, err := loadTableIndexes(context.Background(), db, "energy_points")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, ok := indexes[uniqueKeyName("energy_points", numericPointsKey)]
+	if !ok || key.nonUnique || strings.Join(key.columns, ",") != "entity_id,last_updated" {
+		t.Fatalf("energy_points has no unique key on (entity_id, last_updated): %+v", indexes)
+	}
+	var kept int64
+	if err := db.QueryRow("SELECT state_id FROM energy_points WHERE entity_id = 'sensor.legacy_power' AND last_updated = '2024-01-01 00:00:00'").Scan(&kept); err != nil {
+		t.Fatalf("read deduplicated row: %v", err)
+	}
+	if kept != 2 {
+		t.Errorf("kept duplicate state_id %d, want the newest, 2", kept)
+	}
+	if n := queryCount(t, db, "SELECT COUNT(*) FROM energy_points WHERE entity_id = 'sensor.legacy_power'"); n != 2 {
+		t.Errorf("sensor.legacy_power has %d rows, want 2", n)
+	}
+
+	// Re-exported rows update the stored ones through the key instead of adding rows.
+	stored := queryCount(t, db, "SELECT COUNT(*) FROM energy_points")
+	exportEnergy(t, recorder, dsn)
+	if n := queryCount(t, db, "SELECT COUNT(*) FROM energy_points"); n != stored {
+		t.Errorf("energy_points holds %d rows after a rerun, want %d", n, stored)
+	}
+}
+
+func (s *destinationServer) testTimePrecisionMigration(t *testing.T) {
+	s.useDestination(t)
+	recorder := newRecorderFixture(t, 5, 10*time.Minute)
+	dsn, db := s.database(t)
+
+	if _, err := db.Exec(fmt.Sprintf(legacyEnergyPoints, "DATETIME", "utf8mb4")); err != nil {
+		t.Fatalf("create legacy table: %v", err)
+	}
+
+	exportEnergy(t, recorder, dsn)
+	var precision int
+	if err := db.QueryRow(`
+SELECT DATETIME_PRECISION
+FROM INFORMATION_SCHEMA.COLUMNS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'energy_points' AND COLUMN_NAME = 'last_updated'`).Scan(&precision); err != nil {
+		t.Fatalf("read last_updated precision: %v", err)
+	}
+	if precision != 6 {
+		t.Errorf("last_updated has precision %d, want 6", precision)
+	}
+	written := rowCount(&writtenRows, "energy_points")
+	if written == 0 {
+		t.Fatal("export wrote no energy rows")
+	}
+
+	// Watermarks read back at full precision, so a rerun finds nothing new.
+	exportEnergy(t, recorder, dsn)
+	if n := rowCount(&writtenRows, "energy_points"); n != 0 {
+		t.Errorf("rerun wrote %d rows, want 0", n)
+	}
+}
+
+func (s *destinationServer) testStagingSwap(t *testing.T) {
+	s.useDestination(t)
+	recorder := newRecorderFixture(t, 5, 10*time.Minute)
+	dsn, db := s.database(t)
+
+	exportEnergy(t, recorder, dsn)
+	exported := queryCount(t, db, "SELECT COUNT(*) FROM energy_points")
+	if exported == 0 {
+		t.Fatal("export wrote no energy rows")
+	}
+	// A row the recorder no longer has, which the re-export drops.
+	if _, err := db.Exec("INSERT INTO energy_points (entity_id, state, numeric_state, last_updated) VALUES ('sensor.purged_power', '1', 1, '2020-01-01 00:00:00')"); err != nil {
+		t.Fatalf("insert purged row: %v", err)
+	}
+
+	writeMode = writeModeStagingSwap
+	exportEnergy(t, recorder, dsn)
+	if n := queryCount(t, db, "SELECT COUNT(*) FROM energy_points"); n != exported {
+		t.Errorf("energy_points holds %d rows after the swap, want the %d re-exported", n, exported)
+	}
+	if n := queryCount(t, db, "SELECT COUNT(*) FROM energy_points WHERE entity_id = 'sensor.purged_power'"); n != 0 {
+		t.Error("the swapped table still holds the purged row")
+	}
+	if n := queryCount(t, db, "SELECT COUNT(*) FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME IN ('energy_points_staging', 'energy_points_old')"); n != 0 {
+		t.Errorf("%d staging or replaced tables were left behind", n)
+	}
+	// The swapped-in table was created LIKE the live one, keys included.
+	indexes, err := loadTableIndexes(context.Background(), db, "energy_points")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := indexes[uniqueKeyName("energy_points", numericPointsKey)]; !ok {
+		t.Errorf("the swapped table lost its unique key: %+v", indexes)
+	}
+}
+
+func (s *destinationServer) testIndexPlan(t *testing.T) {
+	s.useDestination(t)
+	recorder := newRecorderFixture(t, 5, 10*time.Minute)
+	dsn, db := s.database(t)
+	entityTime := "idx_energy_points_entity_last_updated"
+	timeRange := "idx_energy_points_last_updated"
+
+	appConfig.Indexes = map[string][]string{"energy_points": {"latest-per-entity", "time-range"}}
+	exportEnergy(t, recorder, dsn)
+	indexes, err := loadTableIndexes(context.Background(), db, "energy_points")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, ok := indexes[entityTime]; !ok || strings.Join(info.columns, ",") != "entity_id,last_updated" {
+		t.Errorf("%s = %+v, want an index on (entity_id, last_updated)", entityTime, info)
+	}
+	if info, ok := indexes[timeRange]; !ok || strings.Join(info.columns, ",") != "last_updated" {
+		t.Errorf("%s = %+v, want an index on (last_updated)", timeRange, info)
+	}
+
+	// Indexes the plan no longer wants are dropped; others are left alone.
+	if _, err := db.Exec("CREATE INDEX idx_custom_state ON energy_points (state)"); err != nil {
+		t.Fatalf("add custom index: %v", err)
+	}
+	appConfig.Indexes = map[string][]string{"energy_points": {"by-day"}}
+	exportEnergy(t, recorder, dsn)
+	if indexes, err = loadTableIndexes(context.Background(), db, "energy_points"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := indexes[entityTime]; ok {
+		t.Errorf("%s was kept after the plan dropped it", entityTime)
+	}
+	for _, name := range []string{timeRange, "idx_custom_state"} {
+		if _, ok := indexes[name]; !ok {
+			t.Errorf("%s is missing", name)
+		}
+	}
+}
+
+func (s *destinationServer) testCharset(t *testing.T) {
+	s.useDestination(t)
+	recorder := newRecorderFixture(t, 5, 10*time.Minute)
+	dsn, db := s.database(t)
+
+	// A table in the three-byte utf8 of older releases, next to the ones the export creates.
+	if _, err := db.Exec(fmt.Sprintf(legacyEnergyPoints, "DATETIME(6)", "utf8")); err != nil {
+		t.Fatalf("create legacy table: %v", err)
+	}
+	exportEnergy(t, recorder, dsn)
+
+	rows, err := db.Query(`
+SELECT TABLE_NAME, TABLE_COLLATION
+FROM INFORMATION_SCHEMA.TABLES
+WHERE TABLE_SCHEMA = DATABASE()
+ORDER BY TABLE_NAME`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	tables := 0
+	for rows.Next() {
+		var table, collation string
+		if err := rows.Scan(&table, &collation); err != nil {
+			t.Fatal(err)
+		}
+		tables++
+		if collation != destCollation {
+			t.Errorf("%s has collation %s, want %s", table, collation, destCollation)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if tables == 0 {
+		t.Fatal("export created no tables")
+	}
+	if n := queryCount(t, db, `
+SELECT COUNT(*)
+FROM INFORMATION_SCHEMA.COLUMNS
+WHERE TABLE_SCHEMA = DATABASE() AND CHARACTER_SET_NAME IS NOT NULL AND CHARACTER_SET_NAME <> ?`, destCharset); n != 0 {
+		t.Errorf("%d text columns are not %s", n, destCharset)
+	}
+
+	// Four-byte characters, which utf8 could not store, round-trip.
+	const name = "Plug 🔌"
+	if _, err := db.Exec("UPDATE energy_points SET friendly_name = ? WHERE entity_id = 'sensor.plug_1_power'", name); err != nil {
+		t.Fatalf("store a four-byte name: %v", err)
+	}
+	var got string
+	if err := db.QueryRow("SELECT friendly_name FROM energy_points WHERE entity_id = 'sensor.plug_1_power' LIMIT 1").Scan(&got); err != nil {
+		t.Fatalf("read the name back: %v", err)
+	}
+	if got != name {
+		t.Errorf("friendly_name = %q, want %q", got, name)
+	}
+}
@@ -0,0 +1,406 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	RegisterSink("mysql", openMySQLSink)
+}
+
+// mysqlSink writes to a MySQL-compatible database using multi-row INSERT ... ON DUPLICATE KEY
+// UPDATE statements (INSERT IGNORE or INSERT for history under --write-mode), within the limits of
+// the selected --dialect.
+type mysqlSink struct {
+	db       *sql.DB
+	entities *entityDirectory
+
+	mu         sync.Mutex
+	statements map[string]upsertStatement
+	// stateCapacities caches the character capacity of each table's state column.
+	stateCapacities map[string]int
+	// staging maps history tables to the staging table --write-mode staging-swap writes them to, and
+	// stagedRows counts the rows written there; see stagingswap.go.
+	staging    map[string]string
+	stagedRows map[string]int64
+	// leases holds the --lease-ttl table leases this sink took; see lease.go.
+	leases         map[string]*tableLease
+	leaseSchema    sync.Once
+	leaseSchemaErr error
+
+	// samples holds the written rows --verify-sample reads back; see verify.go.
+	samples writeSamples
+
+	// packet holds the bytes a batch may take, from max_allowed_packet; see batchsize.go.
+	packet struct {
+		once   sync.Once
+		budget atomic.Int64
+	}
+}
+
+func openMySQLSink(ctx context.Context, mysqlDSN string) (Sink, error) {
+	if !destDialect.mysqlProtocol {
+		return nil, fmt.Errorf("the mysql sink cannot write to the %s dialect, which does not speak the MySQL protocol", destDialect.name)
+	}
+	db, err := openDestination(ctx, mysqlDSN)
+	if err != nil {
+		return nil, err
+	}
+	return &mysqlSink{
+		db:              db,
+		statements:      make(map[string]upsertStatement),
+		stateCapacities: make(map[string]int),
+		staging:         make(map[string]string),
+		stagedRows:      make(map[string]int64),
+		leases:          make(map[string]*tableLease),
+		entities:        newEntityDirectory(db),
+	}, nil
+}
+
+func (s *mysqlSink) DB() *sql.DB { return s.db }
+
+// Close releases the leases of tables whose export failed before FinalizeTable, so other instances
+// need not wait for them to run out.
+func (s *mysqlSink) Close() error {
+	s.mu.Lock()
+	tables := make([]string, 0, len(s.leases))
+	for table := range s.leases {
+		tables = append(tables, table)
+	}
+	s.mu.Unlock()
+	for _, table := range tables {
+		if err := s.releaseLease(context.Background(), table); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	return s.db.Close()
+}
+
+// EnsureSchema creates the table (and the entities table it references), adds columns missing from
+// tables created by older releases, and runs the table's MySQL migrations.
+func (s *mysqlSink) EnsureSchema(ctx context.Context, table *tableSpec) error {
+	table, err := withComputedColumns(table)
+	if err != nil {
+		return err
+	}
+	if table.entityTable != nil {
+		if err := s.EnsureSchema(ctx, table.entityTable); err != nil {
+			return err
+		}
+	}
+
+	if _, err := execStatement(ctx, s.db, mysqlCreateTable(table)); err != nil {
+		return fmt.Errorf("create %s table: %w", table.name, err)
+	}
+	if err := migrateTableCharset(ctx, s.db, table.name); err != nil {
+		return err
+	}
+	if err := s.addMissingColumns(ctx, table); err != nil {
+		return fmt.Errorf("migrate %s columns: %w", table.name, err)
+	}
+	if err := s.ensureTimePrecision(ctx, table); err != nil {
+		return err
+	}
+	if table.mysqlMigrate != nil {
+		if err := table.mysqlMigrate(ctx, s.db); err != nil {
+			return fmt.Errorf("migrate %s table: %w", table.name, err)
+		}
+	}
+	if err := s.ensureIndexes(ctx, table); err != nil {
+		return fmt.Errorf("ensure %s indexes: %w", table.name, err)
+	}
+	if err := s.ensureStateCapacity(ctx, table, stateFlagChars(), stateMaxLength); err != nil {
+		return err
+	}
+	if tableWriteMode(table) == writeModeStagingSwap {
+		return s.ensureStagingTable(ctx, table)
+	}
+	return nil
+}
+
+// ensureIndexes adds the table's secondary indexes, leaving existing ones alone.
+func (s *mysqlSink) ensureIndexes(ctx context.Context, table *tableSpec) error {
+	const mysqlErrDuplicateKey = 1061
+
+	for _, idx := range table.indexes {
+		kind := "INDEX"
+		if idx.spatial {
+			kind = "SPATIAL INDEX"
+		}
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD %s %s (%s)", table.name, kind, quoteIdentifier(idx.name), strings.Join(idx.columns, ", "))
+		if _, err := execStatement(ctx, s.db, stmt); err != nil {
+			if !isMySQLError(err, mysqlErrDuplicateKey) {
+				return fmt.Errorf("add index %s: %w", idx.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// mysqlCreateTable renders the CREATE TABLE IF NOT EXISTS statement for the table.
+func mysqlCreateTable(table *tableSpec) string {
+	var lines []string
+	for _, c := range table.columns {
+		c = resolveColumn(c)
+		lines = append(lines, c.name+" "+c.sqlType)
+	}
+	if len(table.primaryKey) > 0 {
+		lines = append(lines, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(table.primaryKey, ", ")))
+	}
+	for _, key := range table.uniqueKeys {
+		lines = append(lines, fmt.Sprintf("UNIQUE KEY %s (%s)", uniqueKeyName(table.name, key), strings.Join(key, ", ")))
+	}
+	if destDialect.foreignKeys {
+		for _, fk := range table.foreignKeys {
+			lines = append(lines, fmt.Sprintf("CONSTRAINT fk_%s_%s FOREIGN KEY (%s) REFERENCES %s(%s)", table.name, fk.column, fk.column, fk.refTable, fk.refColumn))
+		}
+	}
+	return fmt.Sprintf("\nCREATE TABLE IF NOT EXISTS %s (\n    %s\n) %s\n", table.name, strings.Join(lines, ",\n    "), tableOptions())
+}
+
+// uniqueKeyName names the unique key over columns of table.
+func uniqueKeyName(table string, columns []string) string {
+	return "uk_" + table + "_" + strings.Join(columns, "_")
+}
+
+// addMissingColumns adds spec columns that an existing table lacks, such as optional delta columns.
+func (s *mysqlSink) addMissingColumns(ctx context.Context, table *tableSpec) error {
+	const mysqlErrDuplicateColumn = 1060
+
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(qctx, fmt.Sprintf("SELECT * FROM %s LIMIT 0", table.name))
+	if err != nil {
+		return explainTimeout(qctx, err)
+	}
+	existing, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range table.columns {
+		if containsString(existing, c.name) {
+			continue
+		}
+		c = resolveColumn(c)
+		if c.backfill != "" {
+			if err := s.addBackfilledColumn(ctx, table, c); err != nil {
+				return err
+			}
+			continue
+		}
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table.name, c.name, c.sqlType)
+		if _, err := execStatement(ctx, s.db, stmt); err != nil {
+			if !isMySQLError(err, mysqlErrDuplicateColumn) {
+				return fmt.Errorf("add column %s: %w", c.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// addBackfilledColumn adds a NOT NULL column to a populated table in three steps: add it as NULL,
+// fill it from the backfill expression, then tighten it to the declared type.
+func (s *mysqlSink) addBackfilledColumn(ctx context.Context, table *tableSpec, c columnSpec) error {
+	nullable := strings.Replace(c.sqlType, "NOT NULL", "NULL", 1)
+	steps := []string{
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table.name, c.name, nullable),
+		fmt.Sprintf("UPDATE %s SET %s = %s", table.name, c.name, c.backfill),
+		fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s", table.name, c.name, c.sqlType),
+	}
+	for _, stmt := range steps {
+		if _, err := execStatement(ctx, s.db, stmt); err != nil {
+			return fmt.Errorf("add column %s: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+func (s *mysqlSink) WriteBatch(ctx context.Context, table *tableSpec, rows [][]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	live := table.name
+	if err := s.checkLease(live); err != nil {
+		return err
+	}
+	table = s.target(table)
+	if longest := longestState(table, rows); longest > 0 {
+		if err := s.ensureStateCapacity(ctx, table, longest, widenedStateChars(longest)); err != nil {
+			return err
+		}
+	}
+
+	columns := table.writeColumns()
+	key := table.name + "(" + strings.Join(columns, ",") + ")"
+	s.mu.Lock()
+	stmt, ok := s.statements[key]
+	if !ok {
+		stmt = destDialect.newWriteStatement(table)
+		s.statements[key] = stmt
+	}
+	s.mu.Unlock()
+
+	return s.execSplitting(table, rows, func(rows [][]any) error {
+		var queryBuilder strings.Builder
+		queryBuilder.Grow(len(stmt.prefix) + len(rows)*len(stmt.placeholder) + len(stmt.suffix) + 1)
+		queryBuilder.WriteString(stmt.prefix)
+		args := make([]any, 0, len(rows)*len(columns))
+		for i, row := range rows {
+			if i > 0 {
+				queryBuilder.WriteString(",")
+			}
+			queryBuilder.WriteString(stmt.placeholder)
+			args = append(args, row...)
+		}
+		queryBuilder.WriteByte('\n')
+		queryBuilder.WriteString(stmt.suffix)
+
+		if _, err := execStatement(ctx, s.db, queryBuilder.String(), args...); err != nil {
+			return fmt.Errorf("write %s rows: %w", table.name, err)
+		}
+		s.samples.observe(table, rows)
+		if table.name != live {
+			s.mu.Lock()
+			s.stagedRows[live] += int64(len(rows))
+			s.mu.Unlock()
+		}
+		return nil
+	})
+}
+
+// entitySource returns the FROM clause and entity expression of the table, joining the entities
+// dimension when the table only stores references.
+func entitySource(table *tableSpec) (from, entity string) {
+	if table.entityTable == nil {
+		return table.name + " t", "t." + table.entityColumn
+	}
+	dim := table.entityTable
+	from = fmt.Sprintf("%s t\nJOIN %s e ON t.%s = e.%s", table.name, dim.name, table.entityColumn, dim.primaryKey[0])
+	return from, "e." + dim.entityColumn
+}
+
+// watermarksQuery selects the newest timeColumn value per entity of the table.
+func watermarksQuery(table *tableSpec) string {
+	from, entity := entitySource(table)
+	return fmt.Sprintf(`
+SELECT %[2]s, MAX(t.%[3]s)
+FROM %[1]s
+GROUP BY %[2]s
+`, from, entity, table.timeColumn)
+}
+
+// LoadWatermarks reads the newest time per entity, after taking the table's lease under
+// --lease-ttl.
+func (s *mysqlSink) LoadWatermarks(ctx context.Context, table *tableSpec) (map[string]time.Time, error) {
+	if err := s.acquireLease(ctx, table); err != nil {
+		return nil, err
+	}
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(qctx, watermarksQuery(s.target(table)))
+	if err != nil {
+		return nil, explainTimeout(qctx, err)
+	}
+	defer rows.Close()
+
+	watermarks := make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			entityID string
+			ts       sql.NullTime
+		)
+		if err := rows.Scan(&entityID, &ts); err != nil {
+			return nil, err
+		}
+		if ts.Valid {
+			watermarks[entityID] = ts.Time
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return watermarks, nil
+}
+
+// LoadLatest reads the newest row per entity; ties on the time column resolve to the highest key.
+func (s *mysqlSink) LoadLatest(ctx context.Context, table *tableSpec, columns []string, fn func(scan func(dest ...any) error) error) error {
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(qctx, latestRowsQuery(s.target(table), columns))
+	if err != nil {
+		return explainTimeout(qctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := fn(rows.Scan); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// latestRowsQuery selects (entity_id, columns...) of the newest row per entity of the table, in
+// key order so ties on the time column resolve to the highest key.
+func latestRowsQuery(table *tableSpec, columns []string) string {
+	from, entity := entitySource(table)
+	selected := []string{entity}
+	for _, c := range columns {
+		selected = append(selected, "t."+c)
+	}
+	order := make([]string, len(table.primaryKey))
+	for i, c := range table.primaryKey {
+		order[i] = "t." + c
+	}
+	return fmt.Sprintf(`
+SELECT %[1]s
+FROM %[2]s
+JOIN (
+    SELECT %[4]s, MAX(%[5]s) AS latest
+    FROM %[3]s
+    GROUP BY %[4]s
+) latest ON t.%[4]s = latest.%[4]s AND t.%[5]s = latest.latest
+ORDER BY %[6]s
+`, strings.Join(selected, ", "), from, table.name, table.entityColumn, table.timeColumn, strings.Join(order, ", "))
+}
+
+func (s *mysqlSink) ResolveEntity(ctx context.Context, entityID string, meta stateMetadata) (int64, error) {
+	return s.entities.Resolve(ctx, entityID, meta)
+}
+
+// FinalizeTable verifies the --verify-sample rows, swaps in the staging table under --write-mode
+// staging-swap, and applies the index plan once the export has written its rows. The table's
+// lease is released either way.
+func (s *mysqlSink) FinalizeTable(ctx context.Context, table *tableSpec) (err error) {
+	defer func() {
+		if releaseErr := s.releaseLease(ctx, table.name); err == nil {
+			err = releaseErr
+		}
+	}()
+	if staging := s.target(table).name; staging != table.name {
+		if err := s.checkLease(table.name); err != nil {
+			return err
+		}
+		if err := s.verifyWrites(ctx, staging); err != nil {
+			return err
+		}
+		if err := s.swapStagingTable(ctx, table, staging); err != nil {
+			return err
+		}
+	} else if err := s.verifyWrites(ctx, table.name); err != nil {
+		return err
+	}
+	if table.entityColumn == "" || table.timeColumn == "" {
+		return nil
+	}
+	return applyIndexPlan(ctx, s.db, table)
+}
@@ -51,3 +51,14 @@ func ensureParseTimeEnabled(mysqlDSN string) string {
 	}
 	return mysqlDSN + "?parseTime=true"
 }
+
+// ensureCompressionEnabled adds compress=true to the DSN unless it already sets compress.
+func ensureCompressionEnabled(mysqlDSN string) string {
+	if mysqlDSN == "" || strings.Contains(strings.ToLower(mysqlDSN), "compress=") {
+		return mysqlDSN
+	}
+	if strings.Contains(mysqlDSN, "?") {
+		return mysqlDSN + "&compress=true"
+	}
+	return mysqlDSN + "?compress=true"
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterSink("ndjson", openNDJSONSink)
+}
+
+// ndjsonSink streams every written row as one JSON object per line, to stdout for the target "-"
+// or appended to a file, so exports can feed pipelines (jq, vector) without a database. Objects
+// carry the destination table in "table" plus one member per write column. The sink keeps no
+// watermarks, so every run streams the full history the exporter selects.
+type ndjsonSink struct {
+	mu     sync.Mutex
+	out    *bufio.Writer
+	closer io.Closer
+}
+
+func openNDJSONSink(ctx context.Context, target string) (Sink, error) {
+	if target == "" || target == "-" {
+		return &ndjsonSink{out: bufio.NewWriter(os.Stdout)}, nil
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open ndjson file: %w", err)
+	}
+	return &ndjsonSink{out: bufio.NewWriter(f), closer: f}, nil
+}
+
+func (s *ndjsonSink) EnsureSchema(ctx context.Context, table *tableSpec) error { return nil }
+
+func (s *ndjsonSink) WriteBatch(ctx context.Context, table *tableSpec, rows [][]any) error {
+	columns := table.writeColumns()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, row := range rows {
+		record := make(map[string]any, len(columns)+1)
+		record["table"] = table.name
+		for i, column := range columns {
+			value, err := jsonValue(row[i])
+			if err != nil {
+				return fmt.Errorf("encode %s.%s: %w", table.name, column, err)
+			}
+			record[column] = value
+		}
+		line, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("encode %s row: %w", table.name, err)
+		}
+		line = append(line, '\n')
+		if _, err := s.out.Write(line); err != nil {
+			return fmt.Errorf("write %s rows: %w", table.name, err)
+		}
+	}
+	// Flush per batch so downstream tools see rows while a long export runs.
+	return s.out.Flush()
+}
+
+// jsonValue unwraps driver values (sql.Null*, pointers) so rows encode as plain JSON: NULL as
+// null, times in RFC 3339 UTC, bytes in base64.
+func jsonValue(v any) (any, error) {
+	value, err := driver.DefaultParameterConverter.ConvertValue(v)
+	if err != nil {
+		return nil, err
+	}
+	switch value := value.(type) {
+	case float64:
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, nil
+		}
+	case time.Time:
+		return value.UTC().Format(time.RFC3339Nano), nil
+	}
+	return value, nil
+}
+
+// LoadWatermarks reports nothing exported, since the stream cannot be read back.
+func (s *ndjsonSink) LoadWatermarks(ctx context.Context, table *tableSpec) (map[string]time.Time, error) {
+	return map[string]time.Time{}, nil
+}
+
+func (s *ndjsonSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.out.Flush()
+	if s.closer != nil {
+		if closeErr := s.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// entitiesTable is the dimension shared by every normalized family.
+var entitiesTable = &tableSpec{
+	name: "entities",
+	columns: []columnSpec{
+		{name: "id", sqlType: "BIGINT NOT NULL AUTO_INCREMENT", generated: true},
+		{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "unit", sqlType: "VARCHAR(64) NULL"},
+		{name: "device_class", sqlType: "VARCHAR(64) NULL"},
+		{name: "state_class", sqlType: "VARCHAR(64) NULL"},
+		{name: "friendly_name", sqlType: "VARCHAR(255) NULL"},
+	},
+	primaryKey:   []string{"id"},
+	uniqueKeys:   [][]string{{"entity_id"}},
+	entityColumn: "entity_id",
+}
+
+// factsTable describes the family's slim <name>_facts table referencing the entities dimension.
+// On MySQL a <name>_facts_wide view exposes the wide layout.
+func (f numericFamily) factsTable() *tableSpec {
+	facts := f.name + "_facts"
+	return &tableSpec{
+		name: facts,
+		columns: []columnSpec{
+			{name: "state_id", sqlType: "BIGINT NOT NULL AUTO_INCREMENT", generated: true},
+			{name: "entity_ref", sqlType: "BIGINT NOT NULL"},
+			{name: "numeric_state", sqlType: "DOUBLE NOT NULL"},
+			{name: "last_updated", sqlType: "DATETIME(6) NULL"},
+			{name: "recorder_state_id", sqlType: "BIGINT NULL"},
+		},
+		primaryKey:    []string{"state_id"},
+		foreignKeys:   []foreignKeySpec{{column: "entity_ref", refTable: "entities", refColumn: "id"}},
+		entityColumn:  "entity_ref",
+		timeColumn:    "last_updated",
+		idColumn:      "recorder_state_id",
+		entityTable:   entitiesTable,
+		indexDefaults: []string{"entity-time"},
+		history:       true,
+		mysqlMigrate: func(ctx context.Context, db *sql.DB) error {
+			return ensureFactsWideView(ctx, db, facts)
+		},
+	}
+}
+
+func ensureFactsWideView(ctx context.Context, db *sql.DB, facts string) error {
+	if !destDialect.views {
+		return nil
+	}
+
+	viewDDL := fmt.Sprintf(`
+CREATE OR REPLACE VIEW %[1]s_wide AS
+SELECT
+    f.state_id,
+    e.entity_id,
+    f.numeric_state,
+    e.unit,
+    e.device_class,
+    e.state_class,
+    e.friendly_name,
+    f.last_updated
+FROM %[1]s f
+JOIN entities e ON f.entity_ref = e.id
+`, facts)
+	if _, err := execStatement(ctx, db, viewDDL); err != nil {
+		return fmt.Errorf("create %s_wide view: %w", facts, err)
+	}
+
+	return nil
+}
+
+type entityDimension struct {
+	id   int64
+	meta stateMetadata
+}
+
+// entityDirectory maps entity_ids to their row in the entities dimension,
+// upserting metadata only when it differs from what was last written.
+type entityDirectory struct {
+	db      *sql.DB
+	entries map[string]entityDimension
+}
+
+func newEntityDirectory(db *sql.DB) *entityDirectory {
+	return &entityDirectory{db: db, entries: make(map[string]entityDimension)}
+}
+
+func (d *entityDirectory) Resolve(ctx context.Context, entityID string, meta stateMetadata) (int64, error) {
+	if entry, ok := d.entries[entityID]; ok && entry.meta == meta {
+		return entry.id, nil
+	}
+
+	upsert := destDialect.upsertClause(entitiesTable.name, []string{"entity_id"}, []string{"unit", "device_class", "state_class", "friendly_name"}, "")
+	if destDialect.lastInsertIDExpr {
+		// LAST_INSERT_ID(id) makes LastInsertId return the existing row's id when the upsert updates it.
+		upsert = `ON DUPLICATE KEY UPDATE
+    id = LAST_INSERT_ID(id),
+    unit = VALUES(unit),
+    device_class = VALUES(device_class),
+    state_class = VALUES(state_class),
+    friendly_name = VALUES(friendly_name)`
+	}
+	stmt := `
+INSERT INTO entities (entity_id, unit, device_class, state_class, friendly_name)
+VALUES (?, ?, ?, ?, ?)
+` + upsert + "\n"
+	res, err := execStatement(ctx, d.db, stmt, entityID, meta.Unit, meta.DeviceClass, meta.StateClass, meta.FriendlyName)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	if destDialect.lastInsertIDExpr {
+		id, err = res.LastInsertId()
+	} else {
+		err = queryRowStatement(ctx, d.db, "SELECT id FROM entities WHERE entity_id = ?", []any{entityID}, &id)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	d.entries[entityID] = entityDimension{id: id, meta: meta}
+	return id, nil
+}
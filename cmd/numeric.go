@@ -0,0 +1,733 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// numericFamily describes a group of numeric sensors exported through the shared pipeline
+// (energy sockets, climate sensors, ...).
+type numericFamily struct {
+	// name prefixes the destination tables: <name>_points, or <name>_facts when normalized.
+	name string
+	// where filters the recorder rows (aliases s, sm, sa); args bind its placeholders. It may be
+	// empty when selector picks the entities.
+	where string
+	args  []any
+	// selector narrows the family to the --entity selection; resolve turns it into where.
+	selector *entitySelector
+	// averageTokens lists entity_id substrings whose samples are averaged per minute.
+	averageTokens []string
+	// migratePoints migrates <name>_points tables written by older releases on MySQL; may be nil.
+	migratePoints func(ctx context.Context, db *sql.DB) error
+	// meterUnits, when set, limits the daily meter rollup to entities reporting in these units;
+	// otherwise every entity of the family is a meter.
+	meterUnits []string
+}
+
+// isMeter reports whether a row of the family feeds the daily meter rollup.
+func (f numericFamily) isMeter(meta stateMetadata) bool {
+	return len(f.meterUnits) == 0 || slices.Contains(f.meterUnits, meta.Unit.String)
+}
+
+// pointsTable describes the wide <name>_points layout shared by numeric families.
+func (f numericFamily) pointsTable() *tableSpec {
+	return &tableSpec{
+		name: f.name + "_points",
+		columns: []columnSpec{
+			{name: "state_id", sqlType: "BIGINT NOT NULL AUTO_INCREMENT", generated: true},
+			{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+			{name: "state", sqlType: "VARCHAR(255) NOT NULL"},
+			{name: "numeric_state", sqlType: "DOUBLE NULL"},
+			{name: "unit", sqlType: "VARCHAR(64) NULL"},
+			{name: "device_class", sqlType: "VARCHAR(64) NULL"},
+			{name: "state_class", sqlType: "VARCHAR(64) NULL"},
+			{name: "friendly_name", sqlType: "VARCHAR(255) NULL"},
+			{name: "last_updated", sqlType: "DATETIME(6) NULL"},
+			{name: "recorder_state_id", sqlType: "BIGINT NULL"},
+		},
+		primaryKey:    []string{"state_id"},
+		uniqueKeys:    [][]string{numericPointsKey},
+		entityColumn:  "entity_id",
+		timeColumn:    "last_updated",
+		idColumn:      "recorder_state_id",
+		indexDefaults: []string{"entity-time"},
+		history:       true,
+		mysqlMigrate: func(ctx context.Context, db *sql.DB) error {
+			if f.migratePoints != nil {
+				if err := f.migratePoints(ctx, db); err != nil {
+					return err
+				}
+			}
+			return migrateNumericPointsKey(ctx, db, f.name+"_points")
+		},
+	}
+}
+
+// numericPointsKey is unique per <name>_points row, so re-exported (and minute-averaged) rows
+// update the stored row instead of adding one under a new AUTO_INCREMENT state_id.
+var numericPointsKey = []string{"entity_id", "last_updated"}
+
+// migrateNumericPointsKey adds numericPointsKey to tables created before it existed. Duplicates
+// would make that fail, so they are removed first, keeping each group's newest state_id.
+func migrateNumericPointsKey(ctx context.Context, db *sql.DB, table string) error {
+	const (
+		mysqlErrDuplicateKey = 1061
+		migrationDeleteBatch = 1000
+	)
+
+	indexes, err := loadTableIndexes(ctx, db, table)
+	if err != nil {
+		return err
+	}
+	for _, info := range indexes {
+		if !info.nonUnique && strings.Join(info.columns, ",") == strings.Join(numericPointsKey, ",") {
+			return nil
+		}
+	}
+	if !destDialect.blockingAlters {
+		return nil
+	}
+
+	ids, err := findDuplicateStateIDs(ctx, db, table, numericPointsKey, "MAX")
+	if err != nil {
+		return fmt.Errorf("find duplicate rows: %w", err)
+	}
+	if len(ids) > 0 {
+		if err := confirm(fmt.Sprintf("delete %d duplicate row(s) from %s before adding its unique key", len(ids), table)); err != nil {
+			return err
+		}
+		if _, err := deleteStateIDs(ctx, db, table, ids, migrationDeleteBatch); err != nil {
+			return err
+		}
+	}
+
+	stmt := fmt.Sprintf("ALTER TABLE %s ADD UNIQUE KEY %s (%s)", table, uniqueKeyName(table, numericPointsKey), strings.Join(numericPointsKey, ", "))
+	if _, err := execStatement(ctx, db, stmt); err != nil {
+		if !isMySQLError(err, mysqlErrDuplicateKey) {
+			return fmt.Errorf("add unique key on (%s): %w", strings.Join(numericPointsKey, ", "), err)
+		}
+	}
+	return nil
+}
+
+// numericDeltaColumns are appended to the destination table by --with-delta.
+var numericDeltaColumns = []columnSpec{
+	{name: "prev_numeric_state", sqlType: "DOUBLE NULL"},
+	{name: "delta", sqlType: "DOUBLE NULL"},
+}
+
+// numericOHLCColumns are appended to the destination table by --ohlc.
+var numericOHLCColumns = []columnSpec{
+	{name: "open_value", sqlType: "DOUBLE NULL"},
+	{name: "close_value", sqlType: "DOUBLE NULL"},
+	{name: "min_value", sqlType: "DOUBLE NULL"},
+	{name: "max_value", sqlType: "DOUBLE NULL"},
+}
+
+// destinationTable returns the table rows are written to for the given options.
+func (f numericFamily) destinationTable(opts numericExportOptions) *tableSpec {
+	table := f.pointsTable()
+	if opts.normalized {
+		table = f.factsTable()
+	}
+	if opts.idStrategy == idStrategyHash {
+		table = withWrittenStateID(table)
+	}
+	if opts.withDelta {
+		table = table.withColumns(numericDeltaColumns...)
+	}
+	if opts.withPreviousState {
+		table = table.withColumns(previousStateColumns...)
+	}
+	if opts.ohlc {
+		table = table.withColumns(numericOHLCColumns...)
+	}
+	return table
+}
+
+// withWrittenStateID returns a copy of the table whose state_id is supplied by the exporter
+// instead of AUTO_INCREMENT.
+func withWrittenStateID(t *tableSpec) *tableSpec {
+	clone := *t
+	clone.columns = append([]columnSpec{}, t.columns...)
+	for i := range clone.columns {
+		if clone.columns[i].name == "state_id" {
+			clone.columns[i].generated = false
+		}
+	}
+	return &clone
+}
+
+// ID strategies for the state_id of numeric rows.
+const (
+	// idStrategyAuto lets the destination number rows (AUTO_INCREMENT).
+	idStrategyAuto = "auto"
+	// idStrategyHash derives state_id from entity_id and the row's time (its bucket for aggregated
+	// rows), so re-exports of the same data always land on the same rows.
+	idStrategyHash = "hash"
+)
+
+// deterministicStateID hashes entity_id and the row's time bucket into a positive BIGINT. Rows
+// without a timestamp fall back to their recorder state_id as the bucket.
+func deterministicStateID(row numericRow) int64 {
+	h := sha256.New()
+	h.Write([]byte(row.entityID))
+	h.Write([]byte{0})
+	var bucket [8]byte
+	switch {
+	case !row.bucket.IsZero():
+		binary.BigEndian.PutUint64(bucket[:], uint64(row.bucket.UnixMicro()))
+	case row.lastUpdated.Valid:
+		binary.BigEndian.PutUint64(bucket[:], uint64(row.lastUpdated.Time.UnixMicro()))
+	default:
+		h.Write([]byte("state_id"))
+		binary.BigEndian.PutUint64(bucket[:], uint64(row.stateID))
+	}
+	h.Write(bucket[:])
+	return int64(binary.BigEndian.Uint64(h.Sum(nil)[:8]) & math.MaxInt64)
+}
+
+func (f numericFamily) needsMinuteAverage(entityID string) bool {
+	lowered := strings.ToLower(entityID)
+	for _, token := range f.averageTokens {
+		if strings.Contains(lowered, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// bucketResolution returns the bucket the entity's samples are aggregated to, 0 for none:
+// --target-resolution for every entity, else a minute for the family's averaged entities.
+func (f numericFamily) bucketResolution(entityID string) time.Duration {
+	switch {
+	case targetResolution > 0:
+		return targetResolution
+	case f.needsMinuteAverage(entityID):
+		return time.Minute
+	default:
+		return 0
+	}
+}
+
+// rowBucket returns the bucket the row is aggregated to, or false when it is written as recorded.
+func (f numericFamily) rowBucket(row numericRow) (time.Duration, bool) {
+	resolution := f.bucketResolution(row.entityID)
+	return resolution, resolution > 0 && row.lastUpdated.Valid && row.numericState.Valid
+}
+
+// numericExportOptions toggles optional destination layouts for numeric exporters.
+type numericExportOptions struct {
+	// normalized writes into the entities/<name>_facts schema instead of <name>_points.
+	normalized bool
+	// withDelta fills prev_numeric_state and delta per entity during export.
+	withDelta bool
+	// withPreviousState fills previous_state and duration_in_previous_state from old_state_id.
+	withPreviousState bool
+	// ohlc fills open_value, close_value, min_value and max_value per aggregated bucket.
+	ohlc bool
+	// idStrategy picks how state_id is assigned: idStrategyAuto or idStrategyHash.
+	idStrategy string
+	// groupBy, when groupByArea, refreshes <name>_area_daily after the export.
+	groupBy string
+	// histogramBuckets, when set, refreshes the power histograms in <name>_histograms per
+	// histogramInterval after the export.
+	histogramBuckets  []float64
+	histogramInterval time.Duration
+	// meterDaily refreshes the daily meter consumption in <name>_daily after the export.
+	meterDaily bool
+	// tariffSplit splits <name>_daily into the windows of the config's tariff and refreshes
+	// <name>_monthly; it implies meterDaily.
+	tariffSplit bool
+	// degreeDaysEntity, when set, refreshes degree_days from this outdoor temperature entity after
+	// the export, with heatingBase and coolingBase (0 = by the sensor's unit).
+	degreeDaysEntity         string
+	heatingBase, coolingBase float64
+	// openMeteo, when enabled, refreshes weather_daily from Open-Meteo after the export.
+	openMeteo openMeteoOptions
+	// carbonZone, when set, refreshes <name>_emissions_daily from the carbon_intensity of this
+	// zone after the export.
+	carbonZone string
+}
+
+func (o numericExportOptions) validate() error {
+	if err := validateGroupBy(o.groupBy); err != nil {
+		return err
+	}
+	if o.groupBy != "" && o.normalized {
+		return fmt.Errorf("--group-by works on the wide <name>_points layout, not with --normalized")
+	}
+	if err := validateHistogram(o.histogramBuckets, o.histogramInterval); err != nil {
+		return err
+	}
+	if o.tariffSplit && appConfig.Tariff == nil {
+		return fmt.Errorf("--tariff-split needs a tariff section in the --config file")
+	}
+	if o.carbonZone != "" && o.normalized {
+		return fmt.Errorf("--carbon-zone works on the wide <name>_points layout, not with --normalized")
+	}
+	if o.degreeDaysEntity != "" && !strings.Contains(o.degreeDaysEntity, ".") {
+		return fmt.Errorf("--degree-days-entity takes an entity_id such as sensor.outdoor_temperature, got %q", o.degreeDaysEntity)
+	}
+	switch o.idStrategy {
+	case idStrategyAuto, idStrategyHash:
+		return nil
+	default:
+		return fmt.Errorf("unknown --id-strategy %q (use %s or %s)", o.idStrategy, idStrategyAuto, idStrategyHash)
+	}
+}
+
+func transferNumericData(ctx context.Context, sqlitePath, mysqlDSN string, family numericFamily, opts numericExportOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if opts.openMeteo.enabled {
+		// Fail before exporting rather than after when the weather location is unknown.
+		if _, _, err := openMeteoLocation(opts.openMeteo, sqlitePath); err != nil {
+			return err
+		}
+	}
+
+	sqliteDB, err := openRecorder(ctx, sqlitePath)
+	if err != nil {
+		return err
+	}
+	defer sqliteDB.Close()
+	if family, err = family.resolve(ctx, sqliteDB); err != nil {
+		return err
+	}
+
+	sink, err := openExportSink(ctx, mysqlDSN)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	table := family.destinationTable(opts)
+
+	entities, canResolve := sink.(entityResolver)
+	if opts.normalized && !canResolve {
+		return fmt.Errorf("the %s sink does not support --normalized", sinkName)
+	}
+
+	if err := sink.EnsureSchema(ctx, table); err != nil {
+		return fmt.Errorf("ensure %s table: %w", table.name, err)
+	}
+
+	entityWatermarks, err := loadWatermarks(ctx, sink, table)
+	if err != nil {
+		return fmt.Errorf("load %s checkpoints: %w", family.name, err)
+	}
+	watermarkTies, err := loadWatermarkTies(ctx, sink, table)
+	if err != nil {
+		return fmt.Errorf("load %s checkpoints: %w", family.name, err)
+	}
+
+	lastValues := map[string]float64{}
+	if opts.withDelta {
+		lastValues, err = loadNumericLastValues(ctx, sink, table)
+		if err != nil {
+			return fmt.Errorf("load previous %s values: %w", family.name, err)
+		}
+	}
+
+	var selectPrevious, joinPrevious string
+	if opts.withPreviousState {
+		selectPrevious, joinPrevious = previousStateSelect, previousStateJoin
+	}
+	const numericBatchSize = 500
+
+	// Partially exported buckets are re-read from their start, as the loop below expects.
+	readFrom := entityWatermarks
+	if opts.idStrategy == idStrategyHash && tableWriteMode(table) == writeModeUpsert {
+		readFrom = make(map[string]time.Time, len(entityWatermarks))
+		for entityID, watermark := range entityWatermarks {
+			if resolution := family.bucketResolution(entityID); resolution > 0 {
+				watermark = bucketStart(watermark, resolution)
+			}
+			readFrom[entityID] = watermark
+		}
+	}
+	incremental, incrementalArgs, err := incrementalPredicate("sm.entity_id", "s.last_updated_ts", readFrom)
+	if err != nil {
+		return fmt.Errorf("load %s checkpoints: %w", family.name, err)
+	}
+
+	if rowBudget > 0 {
+		from := `states s
+JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+LEFT JOIN state_attributes sa ON s.attributes_id = sa.attributes_id`
+		n, err := countNewRecorderRows(ctx, sqliteDB, from, family.where, family.args, "sm.entity_id", "s.last_updated_ts", entityWatermarks)
+		if err != nil {
+			return err
+		}
+		if err := checkRowBudget(table, n, numericBatchSize); err != nil {
+			return err
+		}
+	}
+
+	query := `
+SELECT
+    s.state_id,
+    sm.entity_id,
+    s.state,
+    s.last_updated_ts,
+    COALESCE(sa.shared_attrs, ''),
+    s.attributes_id` + selectPrevious + `
+FROM states s
+JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+LEFT JOIN state_attributes sa ON s.attributes_id = sa.attributes_id
+` + joinPrevious + "WHERE (" + family.where + ") AND " + incremental + " ORDER BY sm.entity_id, s.last_updated_ts, s.state_id"
+
+	args := append(append([]any{}, family.args...), incrementalArgs...)
+	rows, err := sqliteDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("query sqlite database: %w", err)
+	}
+	defer rows.Close()
+
+	writer := newBatchWriter(sink, table, numericBatchSize)
+
+	// earliest is the oldest row written, from which --group-by, emissions, meter, degree-day, and
+	// weather rollups are refreshed.
+	var earliest time.Time
+	// histogramSince is where the histograms change: the oldest previous sample of a power entity
+	// with new rows, or its first new row when it has none.
+	var histogramSince time.Time
+	appendRow := func(row numericRow) error {
+		var values []any
+		if opts.idStrategy == idStrategyHash {
+			values = append(values, deterministicStateID(row))
+		}
+		if opts.normalized {
+			entityRef, err := entities.ResolveEntity(ctx, row.entityID, row.meta)
+			if err != nil {
+				return fmt.Errorf("resolve entity %s: %w", row.entityID, err)
+			}
+			values = append(values,
+				entityRef,
+				row.numericState,
+				row.lastUpdated,
+				row.stateID,
+			)
+		} else {
+			values = append(values, row.pointsValues()...)
+		}
+
+		if opts.withDelta {
+			var prev, delta sql.NullFloat64
+			if last, ok := lastValues[row.entityID]; ok {
+				prev = sql.NullFloat64{Float64: last, Valid: true}
+				delta = sql.NullFloat64{Float64: row.numericState.Float64 - last, Valid: true}
+			}
+			values = append(values, prev, delta)
+			lastValues[row.entityID] = row.numericState.Float64
+		}
+		if opts.withPreviousState {
+			values = append(values, row.previous.values()...)
+		}
+		if opts.ohlc {
+			values = append(values, row.ohlcValues()...)
+		}
+
+		if row.lastUpdated.Valid && (earliest.IsZero() || row.lastUpdated.Time.Before(earliest)) {
+			earliest = row.lastUpdated.Time
+		}
+		if row.samples > 1 {
+			mergedRows.add(table.name, row.samples-1)
+		}
+
+		return writer.Add(ctx, values...)
+	}
+
+	aggregator := newBucketAggregator(appendRow)
+	metadata := newMetadataCache()
+
+	for rows.Next() {
+		var (
+			stateID        int64
+			entityID       string
+			state          string
+			lastUpdatedVal sql.NullFloat64
+			attributesJSON string
+			attributesID   sql.NullInt64
+			previous       previousState
+		)
+
+		dest := []any{&stateID, &entityID, &state, &lastUpdatedVal, &attributesJSON, &attributesID}
+		if opts.withPreviousState {
+			dest = append(dest, previous.scanDest()...)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("scan sqlite row: %w", err)
+		}
+
+		lastUpdated, err := floatToNullTime(lastUpdatedVal)
+		if err != nil {
+			if err := writer.Reject(ctx, rejectedRow{stateID, entityID, state, lastUpdatedVal, attributesJSON}, fmt.Errorf("convert last_updated_ts for state_id %d: %w", stateID, err)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if lastUpdated.Valid {
+			exported := exportedBefore(entityWatermarks, watermarkTies, entityID, lastUpdated.Time, stateID)
+			if watermark, ok := entityWatermarks[entityID]; ok {
+				if resolution := family.bucketResolution(entityID); resolution > 0 && opts.idStrategy == idStrategyHash && tableWriteMode(table) == writeModeUpsert {
+					// Re-read the partially exported bucket; its aggregate lands on the same state_id.
+					// Other write modes keep stored rows, so the bucket keeps its first aggregate.
+					exported = lastUpdated.Time.Before(bucketStart(watermark, resolution))
+				}
+			}
+			if exported {
+				exportedRows.add(table.name, 1)
+				continue
+			}
+		}
+
+		meta, err := metadata.metadata(attributesID, attributesJSON)
+		if err != nil {
+			if err := writer.Reject(ctx, rejectedRow{stateID, entityID, state, lastUpdatedVal, attributesJSON}, fmt.Errorf("parse attributes for state_id %d: %w", stateID, err)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(opts.histogramBuckets) > 0 && lastUpdated.Valid && isPowerSample(meta) {
+			changed := lastUpdated.Time
+			if watermark, ok := entityWatermarks[entityID]; ok && watermark.Before(changed) {
+				changed = watermark
+			}
+			if histogramSince.IsZero() || changed.Before(histogramSince) {
+				histogramSince = changed
+			}
+		}
+
+		numericState := parseNumericState(state)
+		observeAlerts(ctx, entityID, state, numericState, lastUpdated)
+
+		trimmedState := strings.TrimSpace(strings.ToLower(state))
+		if trimmedState == "unavailable" || trimmedState == "unknown" {
+			filteredRows.add(table.name, 1)
+			continue
+		}
+
+		if !numericState.Valid {
+			// Skip non numeric values (e.g. "on"/"off") to avoid writing NULL numeric_state rows.
+			filteredRows.add(table.name, 1)
+			continue
+		}
+		row := numericRow{
+			stateID:      stateID,
+			entityID:     entityID,
+			state:        state,
+			numericState: numericState,
+			meta:         meta,
+			lastUpdated:  lastUpdated,
+			previous:     previous,
+		}
+
+		if resolution, ok := family.rowBucket(row); ok {
+			if err := aggregator.Add(row, resolution, rowAggregation(row)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := aggregator.Flush(); err != nil {
+			return err
+		}
+
+		if err := appendRow(row); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate sqlite rows: %w", err)
+	}
+
+	if err := aggregator.Flush(); err != nil {
+		return err
+	}
+
+	if err := writer.Flush(ctx); err != nil {
+		return err
+	}
+	// The rollups read the finished table, swapped in under --write-mode staging-swap.
+	if err := finalizeTable(ctx, sink, table); err != nil {
+		return err
+	}
+
+	if opts.groupBy == groupByArea && !earliest.IsZero() {
+		if err := refreshNumericAreaDaily(ctx, sink, family, earliest); err != nil {
+			return err
+		}
+	}
+	if opts.carbonZone != "" && !earliest.IsZero() {
+		if err := refreshEmissionsDaily(ctx, sink, family, opts.carbonZone, earliest); err != nil {
+			return err
+		}
+	}
+	if (opts.meterDaily || opts.tariffSplit) && !earliest.IsZero() {
+		var tariff *tariffConfig
+		if opts.tariffSplit {
+			tariff = appConfig.Tariff
+		}
+		if err := refreshMeterDaily(ctx, sqliteDB, sink, family, tariff, earliest); err != nil {
+			return err
+		}
+		if tariff != nil {
+			if err := refreshMeterMonthly(ctx, sink, family, tariff, earliest); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.degreeDaysEntity != "" && !earliest.IsZero() {
+		if err := refreshDegreeDays(ctx, sqliteDB, sink, opts, earliest); err != nil {
+			return err
+		}
+	}
+	if opts.openMeteo.enabled && !earliest.IsZero() {
+		if err := refreshWeatherDaily(ctx, sink, opts.openMeteo, sqlitePath, earliest); err != nil {
+			return err
+		}
+	}
+	if !histogramSince.IsZero() {
+		if err := refreshPowerHistograms(ctx, sqliteDB, sink, family, opts, histogramSince); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stateMetadata holds the descriptive attributes shared by numeric sensors.
+type stateMetadata struct {
+	Unit         sql.NullString
+	DeviceClass  sql.NullString
+	StateClass   sql.NullString
+	FriendlyName sql.NullString
+}
+
+// extractStateMetadata reads the unit, device class, state class, and friendly name from a
+// state's attributes. It runs once per exported row, so it scans for the four keys instead of
+// decoding the whole document; see attributeScanner.
+func extractStateMetadata(raw string) (stateMetadata, error) {
+	meta := stateMetadata{}
+	if strings.TrimSpace(raw) == "" {
+		return meta, nil
+	}
+
+	sc := attributeScanner{raw: raw}
+	for sc.next() {
+		var field *sql.NullString
+		switch sc.key {
+		case "unit_of_measurement":
+			field = &meta.Unit
+		case "device_class":
+			field = &meta.DeviceClass
+		case "state_class":
+			field = &meta.StateClass
+		case "friendly_name":
+			field = &meta.FriendlyName
+		default:
+			continue
+		}
+		// A repeated key replaces the earlier value, as in a full decode.
+		v, ok := sc.stringValue()
+		*field = sql.NullString{String: v, Valid: ok}
+	}
+	if sc.err != nil {
+		return stateMetadata{}, fmt.Errorf("unmarshal shared_attrs: %w", sc.err)
+	}
+	return meta, nil
+}
+
+func parseNumericState(raw string) sql.NullFloat64 {
+	if raw == "" {
+		return sql.NullFloat64{}
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: f, Valid: true}
+}
+
+// loadNumericLastValues returns the numeric_state of the newest exported row per entity so deltas
+// continue across runs.
+func loadNumericLastValues(ctx context.Context, sink Sink, table *tableSpec) (map[string]float64, error) {
+	loader, ok := sink.(latestRowLoader)
+	if !ok {
+		return nil, fmt.Errorf("the %s sink does not support --with-delta", sinkName)
+	}
+
+	values := make(map[string]float64)
+	err := loader.LoadLatest(ctx, table, []string{"numeric_state"}, func(scan func(dest ...any) error) error {
+		var (
+			entityID string
+			value    sql.NullFloat64
+		)
+		if err := scan(&entityID, &value); err != nil {
+			return err
+		}
+		if value.Valid {
+			values[entityID] = value.Float64
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+type numericRow struct {
+	stateID      int64
+	entityID     string
+	state        string
+	numericState sql.NullFloat64
+	meta         stateMetadata
+	lastUpdated  sql.NullTime
+	// bucket is the start of the time bucket an aggregated row stands for, in UTC; zero for raw
+	// rows.
+	bucket time.Time
+	// spread holds an aggregated row's first, last, smallest and largest sample.
+	spread bucketSpread
+	// samples is how many recorder states an aggregated row stands for; zero for raw rows.
+	samples int
+	// previous is the state the row replaced, under --with-previous-state; aggregated rows have none.
+	previous previousState
+}
+
+// ohlcValues returns the row's numericOHLCColumns values; a raw row is a bucket of one sample.
+func (r numericRow) ohlcValues() []any {
+	if r.bucket.IsZero() {
+		return []any{r.numericState, r.numericState, r.numericState, r.numericState}
+	}
+	return []any{r.spread.open, r.spread.close, r.spread.min, r.spread.max}
+}
+
+// pointsValues returns the row's values in the wide <name>_points layout (without state_id).
+func (r numericRow) pointsValues() []any {
+	return []any{
+		r.entityID,
+		r.state,
+		r.numericState,
+		r.meta.Unit,
+		r.meta.DeviceClass,
+		r.meta.StateClass,
+		r.meta.FriendlyName,
+		r.lastUpdated,
+		r.stateID,
+	}
+}
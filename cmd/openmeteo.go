@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// openMeteoArchiveURL is Open-Meteo's historical weather API.
+const openMeteoArchiveURL = "https://archive-api.open-meteo.com/v1/archive"
+
+// openMeteoOptions are the --open-meteo flags of the exporters with daily rollups.
+type openMeteoOptions struct {
+	enabled bool
+	// location is "latitude,longitude"; empty means the home location of .storage/core.config.
+	location string
+	url      string
+	cache    string
+}
+
+func addOpenMeteoFlags(cmd *cobra.Command, opts *openMeteoOptions) {
+	cmd.Flags().BoolVar(&opts.enabled, "open-meteo", false, "Also fetch each exported day's weather (temperature, solar radiation) from Open-Meteo into weather_daily")
+	cmd.Flags().StringVar(&opts.location, "open-meteo-location", "", "Latitude,longitude to fetch the weather for (default: the home location in .storage/core.config)")
+	cmd.Flags().StringVar(&opts.url, "open-meteo-url", openMeteoArchiveURL, "Open-Meteo historical weather API endpoint, for a self-hosted instance")
+	cmd.Flags().StringVar(&opts.cache, "open-meteo-cache", "ha-tools-open-meteo.json", "File caching the fetched days, so every day is fetched once")
+}
+
+// weatherDailyTable holds Open-Meteo's daily weather at the home location, to join the daily
+// rollups to on day. Temperatures are in °C, radiation in MJ/m².
+var weatherDailyTable = &tableSpec{
+	name: "weather_daily",
+	columns: []columnSpec{
+		{name: "day", sqlType: "DATE NOT NULL"},
+		{name: "latitude", sqlType: "DOUBLE NOT NULL"},
+		{name: "longitude", sqlType: "DOUBLE NOT NULL"},
+		{name: "temperature_mean", sqlType: "DOUBLE NULL"},
+		{name: "temperature_min", sqlType: "DOUBLE NULL"},
+		{name: "temperature_max", sqlType: "DOUBLE NULL"},
+		{name: "shortwave_radiation_sum", sqlType: "DOUBLE NULL"},
+		{name: "sunshine_hours", sqlType: "DOUBLE NULL"},
+	},
+	primaryKey: []string{"day", "latitude", "longitude"},
+}
+
+// openMeteoDailyFields are the daily variables requested, in weatherDailyTable column order.
+var openMeteoDailyFields = []string{"temperature_2m_mean", "temperature_2m_min", "temperature_2m_max", "shortwave_radiation_sum", "sunshine_duration"}
+
+// openMeteoDay is one day's weather; a nil value is one Open-Meteo has no data for yet.
+type openMeteoDay [5]*float64
+
+// complete reports whether every value is known, so the day need not be fetched again.
+func (d openMeteoDay) complete() bool {
+	for _, v := range d {
+		if v == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// openMeteoCache maps "latitude,longitude" and day (YYYY-MM-DD) to the fetched weather.
+type openMeteoCache map[string]map[string]openMeteoDay
+
+func loadOpenMeteoCache(path string) (openMeteoCache, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return openMeteoCache{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read open-meteo cache: %w", err)
+	}
+	cache := openMeteoCache{}
+	if err := json.Unmarshal(raw, &cache); err != nil {
+		return nil, fmt.Errorf("parse open-meteo cache %s: %w", path, err)
+	}
+	return cache, nil
+}
+
+func (c openMeteoCache) save(path string) error {
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		return fmt.Errorf("write open-meteo cache: %w", err)
+	}
+	return nil
+}
+
+// openMeteoLocation returns the coordinates to fetch the weather for.
+func openMeteoLocation(opts openMeteoOptions, sqlitePath string) (latitude, longitude float64, err error) {
+	if opts.location != "" {
+		lat, lon, ok := strings.Cut(opts.location, ",")
+		var latErr, lonErr error
+		latitude, latErr = strconv.ParseFloat(strings.TrimSpace(lat), 64)
+		longitude, lonErr = strconv.ParseFloat(strings.TrimSpace(lon), 64)
+		if !ok || latErr != nil || lonErr != nil {
+			return 0, 0, fmt.Errorf("--open-meteo-location must be latitude,longitude, got %q", opts.location)
+		}
+		return latitude, longitude, nil
+	}
+	var config struct {
+		Latitude  *float64 `json:"latitude"`
+		Longitude *float64 `json:"longitude"`
+	}
+	if err := readStorageFile(homeAssistantStorageDir(sqlitePath), "core.config", &config); err != nil {
+		return 0, 0, fmt.Errorf("read the home location (or set --open-meteo-location): %w", err)
+	}
+	if config.Latitude == nil || config.Longitude == nil {
+		return 0, 0, errors.New("core.config has no home location; set --open-meteo-location")
+	}
+	return *config.Latitude, *config.Longitude, nil
+}
+
+// refreshWeatherDaily writes weather_daily for every day from the one holding the given time to
+// today. Days missing from the cache are fetched in one request; only complete days are cached,
+// so today and the days Open-Meteo has not published yet are fetched again on the next run.
+func refreshWeatherDaily(ctx context.Context, sink Sink, opts openMeteoOptions, sqlitePath string, since time.Time) error {
+	latitude, longitude, err := openMeteoLocation(opts, sqlitePath)
+	if err != nil {
+		return err
+	}
+	cache, err := loadOpenMeteoCache(opts.cache)
+	if err != nil {
+		return err
+	}
+	key := strconv.FormatFloat(latitude, 'f', -1, 64) + "," + strconv.FormatFloat(longitude, 'f', -1, 64)
+	if cache[key] == nil {
+		cache[key] = make(map[string]openMeteoDay)
+	}
+	days := cache[key]
+
+	var wanted, missing []string
+	for day := dayStart(since); !day.After(wallClock.Now()); day = day.AddDate(0, 0, 1) {
+		date := day.Format(time.DateOnly)
+		wanted = append(wanted, date)
+		if d, ok := days[date]; !ok || !d.complete() {
+			missing = append(missing, date)
+		}
+	}
+	if len(missing) > 0 {
+		fetched, err := fetchOpenMeteo(ctx, opts.url, latitude, longitude, missing[0], missing[len(missing)-1])
+		if err != nil {
+			return err
+		}
+		for date, d := range fetched {
+			days[date] = d
+		}
+		if err := cache.save(opts.cache); err != nil {
+			return err
+		}
+	}
+
+	if err := sink.EnsureSchema(ctx, weatherDailyTable); err != nil {
+		return fmt.Errorf("ensure %s table: %w", weatherDailyTable.name, err)
+	}
+	const weatherDailyBatchSize = 500
+	writer := newBatchWriter(sink, weatherDailyTable, weatherDailyBatchSize)
+	for _, date := range wanted {
+		d, ok := days[date]
+		if !ok {
+			continue
+		}
+		day, err := time.ParseInLocation(time.DateOnly, date, exportZone)
+		if err != nil {
+			return err
+		}
+		var sunshineHours *float64
+		if d[4] != nil {
+			hours := *d[4] / 3600
+			sunshineHours = &hours
+		}
+		if err := writer.Add(ctx, day, latitude, longitude, d[0], d[1], d[2], d[3], sunshineHours); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(ctx); err != nil {
+		return err
+	}
+	return finalizeTable(ctx, sink, weatherDailyTable)
+}
+
+// fetchOpenMeteo requests the daily weather from start to end (YYYY-MM-DD, inclusive). Days are
+// those of the location's own time zone.
+func fetchOpenMeteo(ctx context.Context, endpoint string, latitude, longitude float64, start, end string) (map[string]openMeteoDay, error) {
+	query := url.Values{}
+	query.Set("latitude", strconv.FormatFloat(latitude, 'f', -1, 64))
+	query.Set("longitude", strconv.FormatFloat(longitude, 'f', -1, 64))
+	query.Set("start_date", start)
+	query.Set("end_date", end)
+	query.Set("daily", strings.Join(openMeteoDailyFields, ","))
+	query.Set("timezone", "auto")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("build open-meteo request: %w", err)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("call open-meteo: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, fmt.Errorf("read open-meteo response: %w", err)
+	}
+
+	// daily holds the dates in time and one series per requested field, NULL where data is missing.
+	var decoded struct {
+		Reason string                     `json:"reason"`
+		Daily  map[string]json.RawMessage `json:"daily"`
+	}
+	if err := json.Unmarshal(body, &decoded); err != nil || resp.StatusCode != http.StatusOK {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("open-meteo returned HTTP %d: %s", resp.StatusCode, decoded.Reason)
+		}
+		return nil, fmt.Errorf("decode open-meteo response: %w", err)
+	}
+	var dates []string
+	if err := json.Unmarshal(decoded.Daily["time"], &dates); err != nil {
+		return nil, fmt.Errorf("decode open-meteo dates: %w", err)
+	}
+	values := make([][]*float64, len(openMeteoDailyFields))
+	for i, field := range openMeteoDailyFields {
+		if raw, ok := decoded.Daily[field]; ok {
+			if err := json.Unmarshal(raw, &values[i]); err != nil {
+				return nil, fmt.Errorf("decode open-meteo %s: %w", field, err)
+			}
+		}
+	}
+
+	days := make(map[string]openMeteoDay, len(dates))
+	for i, date := range dates {
+		var d openMeteoDay
+		for f := range openMeteoDailyFields {
+			if i < len(values[f]) {
+				d[f] = values[f][i]
+			}
+		}
+		days[date] = d
+	}
+	return days, nil
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// ownerPersons is the repeatable --only-entities-owned-by flag of the location exporters.
+var ownerPersons []string
+
+// addOwnerFlags registers the owner filter on an exporter.
+func addOwnerFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&ownerPersons, "only-entities-owned-by", nil, "Only export entities of this person (e.g. person.alice): the person, its device trackers, and their devices' other entities; repeat for several people")
+	cmd.Flags().StringVar(&storageDir, "storage-dir", "", "Home Assistant .storage directory with the person and entity registries (default: .storage next to the recorder)")
+}
+
+// ownerFilter keeps the entities owned by the requested people. A nil filter allows everything.
+type ownerFilter struct {
+	entities map[string]bool
+	// patterns are globs from the config's owners section.
+	patterns []string
+}
+
+// Allows reports whether entityID belongs to one of the people.
+func (f *ownerFilter) Allows(entityID string) bool {
+	if f == nil || f.entities[entityID] {
+		return true
+	}
+	for _, pattern := range f.patterns {
+		if ok, _ := path.Match(pattern, entityID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// newOwnerFilter resolves --only-entities-owned-by for the recorder at sqlitePath. Each person
+// contributes the entities listed under owners in the config, or else its registry entry: the
+// person entity, its device trackers, and every entity on the trackers' devices (e.g. the phone's
+// battery sensor).
+func newOwnerFilter(sqlitePath string) (*ownerFilter, error) {
+	if len(ownerPersons) == 0 {
+		return nil, nil
+	}
+	filter := &ownerFilter{entities: map[string]bool{}}
+
+	var registry *ownerRegistry
+	for _, person := range ownerPersons {
+		if !strings.HasPrefix(person, "person.") {
+			return nil, fmt.Errorf("--only-entities-owned-by %q: expected a person entity such as person.alice", person)
+		}
+		if owned, ok := appConfig.Owners[person]; ok {
+			filter.entities[person] = true
+			for _, entity := range owned {
+				if strings.ContainsAny(entity, "*?[") {
+					filter.patterns = append(filter.patterns, entity)
+				} else {
+					filter.entities[entity] = true
+				}
+			}
+			continue
+		}
+
+		if registry == nil {
+			var err error
+			if registry, err = loadOwnerRegistry(homeAssistantStorageDir(sqlitePath)); err != nil {
+				return nil, fmt.Errorf("resolve %s: %w (list its entities under owners in the config instead)", person, err)
+			}
+		}
+		owned, ok := registry.owned(person)
+		if !ok {
+			return nil, fmt.Errorf("resolve %s: no such person in the registry (known: %s)", person, strings.Join(registry.personIDs(), ", "))
+		}
+		for _, entity := range owned {
+			filter.entities[entity] = true
+		}
+	}
+	return filter, nil
+}
+
+// ownerRegistry joins the person storage with the entity registry.
+type ownerRegistry struct {
+	// trackers maps person entity_ids to their device trackers.
+	trackers map[string][]string
+	// deviceOf and deviceEntities link entities sharing a device.
+	deviceOf       map[string]string
+	deviceEntities map[string][]string
+}
+
+func loadOwnerRegistry(dir string) (*ownerRegistry, error) {
+	persons, err := loadPersons(dir)
+	if err != nil {
+		return nil, err
+	}
+	entities, err := loadEntityRegistry(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	r := &ownerRegistry{
+		trackers:       map[string][]string{},
+		deviceOf:       map[string]string{},
+		deviceEntities: map[string][]string{},
+	}
+	personEntities := map[string]string{}
+	for _, e := range entities {
+		if e.Platform == "person" {
+			personEntities[e.UniqueID] = e.EntityID
+		}
+		if e.DeviceID != nil && *e.DeviceID != "" {
+			r.deviceOf[e.EntityID] = *e.DeviceID
+			r.deviceEntities[*e.DeviceID] = append(r.deviceEntities[*e.DeviceID], e.EntityID)
+		}
+	}
+	for _, p := range persons {
+		entityID, ok := personEntities[p.ID]
+		if !ok {
+			entityID = "person." + slugify(p.Name)
+		}
+		r.trackers[entityID] = p.DeviceTrackers
+	}
+	return r, nil
+}
+
+func (r *ownerRegistry) owned(person string) ([]string, bool) {
+	trackers, ok := r.trackers[person]
+	if !ok {
+		return nil, false
+	}
+	owned := []string{person}
+	for _, tracker := range trackers {
+		owned = append(owned, tracker)
+		if device, ok := r.deviceOf[tracker]; ok {
+			owned = append(owned, r.deviceEntities[device]...)
+		}
+	}
+	return owned, true
+}
+
+func (r *ownerRegistry) personIDs() []string {
+	ids := make([]string, 0, len(r.trackers))
+	for id := range r.trackers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
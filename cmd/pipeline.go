@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// writeQueue is the --write-queue flag: how many full batches a writer hands to the background
+// while the exporter keeps reading. Zero writes every batch before reading on.
+var writeQueue = 2
+
+func init() {
+	rootCmd.PersistentFlags().IntVar(&writeQueue, "write-queue", writeQueue, "Full batches each writer may have in flight while reading continues (0 = write each batch before reading on); reading pauses when the queue is full")
+}
+
+func validateWriteQueue() error {
+	if writeQueue < 0 {
+		return fmt.Errorf("--write-queue must not be negative, got %d", writeQueue)
+	}
+	return nil
+}
+
+// pendingWrites pipelines a writer's batches: each is written on a goroutine of its own once the
+// one before it is done, so batches land in order while the exporter reads the next one. At most
+// --write-queue batches are in flight; a further one waits for a slot.
+type pendingWrites struct {
+	slots chan struct{}
+	// last is closed when the most recently queued batch is done.
+	last chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// send queues the writer's rows for writing in the background. It returns the error of an earlier
+// batch, after which the writer writes nothing more.
+func (b *batchWriter) send(ctx context.Context) error {
+	if b.pending == nil {
+		b.pending = &pendingWrites{slots: make(chan struct{}, writeQueue)}
+	}
+	p := b.pending
+	if err := p.failed(); err != nil {
+		return err
+	}
+	if len(b.rows) == 0 {
+		return nil
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+	rows := b.rows
+	b.rows = nil
+	prev, done := p.last, make(chan struct{})
+	p.last = done
+	go func() {
+		defer close(done)
+		defer func() { <-p.slots }()
+		if prev != nil {
+			<-prev
+		}
+		if p.failed() != nil {
+			return
+		}
+		if err := b.write(ctx, rows); err != nil {
+			p.fail(err)
+		}
+	}()
+	return nil
+}
+
+// wait blocks until every queued batch is written and returns the first write error.
+func (b *batchWriter) wait() error {
+	if b.pending == nil {
+		return nil
+	}
+	if b.pending.last != nil {
+		<-b.pending.last
+	}
+	return b.pending.failed()
+}
+
+func (p *pendingWrites) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *pendingWrites) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
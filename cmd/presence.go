@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	presenceSQLitePaths []string
+	presenceMySQLDSN    string
+	presenceGroupBy     string
+	presencePrevious    bool
+)
+
+// presenceCmd derives zone stays from person and device_tracker state transitions.
+var presenceCmd = &cobra.Command{
+	Use:   "presence",
+	Short: "Export Home Assistant presence history into MySQL",
+	Long:  "Reads person.* and device_tracker.* state transitions (home/not_home/zone names) from the Home Assistant SQLite recorder database and upserts one row per zone stay, with arrival, departure, and duration, into a presence_points table.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(presenceSQLitePaths) == 0 {
+			return errors.New("sqlite database path is required")
+		}
+		if presenceMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+		if err := validateGroupBy(presenceGroupBy); err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		return forEachRecorder(ctx, presenceSQLitePaths, func(sqlitePath string) error {
+			return transferPresenceData(ctx, sqlitePath, presenceMySQLDSN)
+		})
+	},
+}
+
+func init() {
+	presenceCmd.Flags().StringArrayVar(&presenceSQLitePaths, "sqlite", nil, recorderFlagUsage)
+	presenceCmd.Flags().StringVar(&presenceMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	addOwnerFlags(presenceCmd)
+	addGroupByFlag(presenceCmd, &presenceGroupBy)
+	presenceCmd.Flags().BoolVar(&presencePrevious, "with-previous-state", false, previousStateFlagUsage)
+	_ = presenceCmd.MarkFlagRequired("sqlite")
+	_ = presenceCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(presenceCmd)
+}
+
+// presenceStay is one continuous period an entity spent in a zone.
+type presenceStay struct {
+	entityID  string
+	zone      string
+	arrivedAt time.Time
+	// previous is the state the arrival replaced, under --with-previous-state.
+	previous previousState
+}
+
+func transferPresenceData(ctx context.Context, sqlitePath, mysqlDSN string) error {
+	owners, err := newOwnerFilter(sqlitePath)
+	if err != nil {
+		return err
+	}
+
+	sqliteDB, err := openRecorder(ctx, sqlitePath)
+	if err != nil {
+		return err
+	}
+	defer sqliteDB.Close()
+
+	sink, err := openExportSink(ctx, mysqlDSN)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	table := presencePointsTable
+	var selectPrevious, joinPrevious string
+	if presencePrevious {
+		table = table.withColumns(previousStateColumns...)
+		selectPrevious, joinPrevious = previousStateSelect, previousStateJoin
+	}
+	if err := sink.EnsureSchema(ctx, table); err != nil {
+		return fmt.Errorf("ensure presence_points table: %w", err)
+	}
+
+	openStays, err := loadOpenPresenceStays(ctx, sink, table)
+	if err != nil {
+		return fmt.Errorf("load presence checkpoints: %w", err)
+	}
+
+	query := `
+SELECT
+    s.state_id,
+    sm.entity_id,
+    s.state,
+    s.last_updated_ts` + selectPrevious + `
+FROM states s
+JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+` + joinPrevious + `WHERE sm.entity_id LIKE 'person.%' OR sm.entity_id LIKE 'device\_tracker.%' ESCAPE '\'
+ORDER BY sm.entity_id, s.last_updated_ts
+`
+
+	rows, err := sqliteDB.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("query sqlite database: %w", err)
+	}
+	defer rows.Close()
+
+	const presenceBatchSize = 500
+
+	writer := newBatchWriter(sink, table, presenceBatchSize)
+
+	// earliest is the oldest stay written, from which --group-by rollups are refreshed.
+	var earliest time.Time
+	emitStay := func(stay presenceStay, departedAt time.Time) error {
+		if earliest.IsZero() || stay.arrivedAt.Before(earliest) {
+			earliest = stay.arrivedAt
+		}
+		var (
+			departed sql.NullTime
+			duration sql.NullInt64
+		)
+		if !departedAt.IsZero() {
+			departed = sql.NullTime{Time: departedAt, Valid: true}
+			duration = sql.NullInt64{Int64: int64(departedAt.Sub(stay.arrivedAt) / time.Second), Valid: true}
+		}
+		values := []any{stay.entityID, stay.zone, stay.arrivedAt, departed, duration}
+		if presencePrevious {
+			values = append(values, stay.previous.values()...)
+		}
+		return writer.Add(ctx, values...)
+	}
+
+	for rows.Next() {
+		var (
+			stateID        int64
+			entityID       string
+			state          string
+			lastUpdatedVal sql.NullFloat64
+			previous       previousState
+		)
+
+		dest := []any{&stateID, &entityID, &state, &lastUpdatedVal}
+		if presencePrevious {
+			dest = append(dest, previous.scanDest()...)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("scan sqlite row: %w", err)
+		}
+		if !owners.Allows(entityID) {
+			filteredRows.add(table.name, 1)
+			continue
+		}
+
+		lastUpdated, err := floatToNullTime(lastUpdatedVal)
+		if err != nil {
+			if err := writer.Reject(ctx, rejectedRow{stateID, entityID, state, lastUpdatedVal, ""}, fmt.Errorf("convert last_updated_ts for state_id %d: %w", stateID, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		if !lastUpdated.Valid {
+			continue
+		}
+		observeAlerts(ctx, entityID, state, sql.NullFloat64{}, lastUpdated)
+
+		zone := strings.TrimSpace(state)
+		lowered := strings.ToLower(zone)
+		if zone == "" || lowered == "unavailable" || lowered == "unknown" {
+			continue
+		}
+
+		current, ok := openStays[entityID]
+		if ok {
+			if !lastUpdated.Time.After(current.arrivedAt) || current.zone == zone {
+				continue
+			}
+			if err := emitStay(current, lastUpdated.Time); err != nil {
+				return err
+			}
+		}
+		openStays[entityID] = presenceStay{entityID: entityID, zone: zone, arrivedAt: lastUpdated.Time, previous: previous}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate sqlite rows: %w", err)
+	}
+
+	// The newest stay per entity is still ongoing; write it without a departure so the next run
+	// can close it.
+	for _, stay := range openStays {
+		if err := emitStay(stay, time.Time{}); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Flush(ctx); err != nil {
+		return err
+	}
+
+	if presenceGroupBy == groupByArea && !earliest.IsZero() {
+		if err := refreshPresenceAreaDaily(ctx, sink, earliest); err != nil {
+			return err
+		}
+	}
+
+	return finalizeTable(ctx, sink, table)
+}
+
+// presencePointsTable has no default index patterns: the (entity_id, arrived_at) primary key
+// already serves per-entity lookups.
+var presencePointsTable = &tableSpec{
+	name: "presence_points",
+	columns: []columnSpec{
+		{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "zone", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "arrived_at", sqlType: "DATETIME(6) NOT NULL"},
+		{name: "departed_at", sqlType: "DATETIME(6) NULL"},
+		{name: "duration_seconds", sqlType: "BIGINT NULL"},
+	},
+	primaryKey:   []string{"entity_id", "arrived_at"},
+	entityColumn: "entity_id",
+	timeColumn:   "arrived_at",
+	indexes: []indexSpec{
+		{name: "idx_presence_points_zone_arrived_at", columns: []string{"zone", "arrived_at"}},
+	},
+}
+
+// loadOpenPresenceStays returns the newest stay per entity, which later transitions continue or close.
+func loadOpenPresenceStays(ctx context.Context, sink Sink, table *tableSpec) (map[string]presenceStay, error) {
+	stays := make(map[string]presenceStay)
+	loader, ok := sink.(latestRowLoader)
+	if !ok {
+		return stays, nil
+	}
+
+	columns := []string{"zone", "arrived_at"}
+	if presencePrevious {
+		columns = append(columns, "previous_state", "duration_in_previous_state")
+	}
+	err := loader.LoadLatest(ctx, table, columns, func(scan func(dest ...any) error) error {
+		var stay presenceStay
+		dest := []any{&stay.entityID, &stay.zone, &stay.arrivedAt}
+		if presencePrevious {
+			dest = append(dest, stay.previous.scanDest()...)
+		}
+		if err := scan(dest...); err != nil {
+			return err
+		}
+		stays[stay.entityID] = stay
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stays, nil
+}
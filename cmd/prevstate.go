@@ -0,0 +1,42 @@
+package cmd
+
+import "database/sql"
+
+// previousStateColumns are appended to the destination table by --with-previous-state.
+var previousStateColumns = []columnSpec{
+	{name: "previous_state", sqlType: "VARCHAR(255) NULL"},
+	{name: "duration_in_previous_state", sqlType: "DOUBLE NULL"},
+}
+
+// previousStateFlagUsage documents the --with-previous-state flag of the exporters supporting it.
+const previousStateFlagUsage = "Fill previous_state and duration_in_previous_state (seconds) from the recorder's old_state_id chain"
+
+// The recorder links each state to the one it replaced through states.old_state_id, so the
+// previous state and how long it had lasted (from its last change to this row) come from a
+// self-join of the same scan. last_changed_ts is NULL when it equals last_updated_ts.
+const (
+	previousStateJoin   = "LEFT JOIN states prev ON prev.state_id = s.old_state_id\n"
+	previousStateSelect = ",\n    prev.state,\n    s.last_updated_ts - COALESCE(prev.last_changed_ts, prev.last_updated_ts)"
+)
+
+// previousState is the state a row replaced and the seconds it had been in effect; both are NULL
+// for an entity's first state and for states the recorder has already purged.
+type previousState struct {
+	state   sql.NullString
+	seconds sql.NullFloat64
+}
+
+// scanDest returns the destinations for previousStateSelect's columns.
+func (p *previousState) scanDest() []any {
+	return []any{&p.state, &p.seconds}
+}
+
+// values returns the row's previousStateColumns values.
+func (p previousState) values() []any {
+	seconds := p.seconds
+	if seconds.Valid && seconds.Float64 < 0 {
+		// Clock adjustments can order last_changed_ts backwards; a negative duration means nothing.
+		seconds = sql.NullFloat64{}
+	}
+	return []any{p.state, seconds}
+}
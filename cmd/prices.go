@@ -0,0 +1,356 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Supported --provider values of the prices command.
+const (
+	// pricesAwattar is aWATTar's day-ahead market data for Germany (de) or Austria (at).
+	pricesAwattar = "awattar"
+	// pricesNordpool is Nord Pool's day-ahead prices for a delivery area such as SE3 or DK1.
+	pricesNordpool = "nordpool"
+	// pricesOctopus is the unit rates of an Octopus Energy tariff such as Agile.
+	pricesOctopus = "octopus"
+)
+
+var (
+	pricesMySQLDSN string
+	pricesProvider string
+	pricesArea     string
+	pricesCurrency string
+	pricesURL      string
+	pricesDays     int
+)
+
+// pricesCmd fetches dynamic electricity prices into energy_prices.
+var pricesCmd = &cobra.Command{
+	Use:   "prices",
+	Short: "Fetch dynamic electricity prices into MySQL",
+	Long:  "Fetches hourly (or half-hourly) electricity prices from aWATTar, Nord Pool, or Octopus Energy and upserts them into an energy_prices table, converted to a price per kWh. Each run fetches from the day of the newest stored price of the area through tomorrow, so day-ahead prices are picked up as soon as they are published. energy standby --hourly-prices prices standby draw with them.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if pricesMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+		if pricesDays <= 0 {
+			return errors.New("--days must be positive")
+		}
+		provider, err := newPriceProvider(pricesProvider, pricesArea, pricesCurrency, pricesURL)
+		if err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		return transferPrices(ctx, pricesMySQLDSN, provider)
+	},
+}
+
+func init() {
+	pricesCmd.Flags().StringVar(&pricesMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	pricesCmd.Flags().StringVar(&pricesProvider, "provider", pricesAwattar, "Price source: awattar, nordpool, or octopus")
+	pricesCmd.Flags().StringVar(&pricesArea, "area", "", "Market area: de or at for awattar (default de), a delivery area such as SE3 for nordpool, or the tariff code (e.g. E-1R-AGILE-24-10-01-C) for octopus")
+	pricesCmd.Flags().StringVar(&pricesCurrency, "currency", "EUR", "Currency of nordpool prices")
+	pricesCmd.Flags().StringVar(&pricesURL, "url", "", "Provider API endpoint, for a proxy or mirror (default: the provider's public API)")
+	pricesCmd.Flags().IntVar(&pricesDays, "days", 7, "Days of history to fetch when the area has no stored prices yet")
+	_ = pricesCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(pricesCmd)
+}
+
+// energyPricesTable holds one row per price interval in local time, price per kWh including what
+// the provider includes (VAT for octopus, none for the wholesale awattar and nordpool prices).
+var energyPricesTable = &tableSpec{
+	name: "energy_prices",
+	columns: []columnSpec{
+		{name: "provider", sqlType: "VARCHAR(32) NOT NULL"},
+		{name: "area", sqlType: "VARCHAR(64) NOT NULL"},
+		{name: "start_time", sqlType: "DATETIME NOT NULL"},
+		{name: "end_time", sqlType: "DATETIME NOT NULL"},
+		{name: "price", sqlType: "DOUBLE NOT NULL"},
+		{name: "currency", sqlType: "VARCHAR(8) NOT NULL"},
+	},
+	primaryKey:   []string{"provider", "area", "start_time"},
+	indexes:      []indexSpec{{name: "idx_energy_prices_time", columns: []string{"start_time"}}},
+	entityColumn: "area",
+	timeColumn:   "start_time",
+}
+
+// pricePoint is one price interval.
+type pricePoint struct {
+	start, end time.Time
+	// price is per kWh.
+	price float64
+}
+
+// priceProvider fetches the prices of one area.
+type priceProvider struct {
+	name     string
+	area     string
+	currency string
+	endpoint string
+	fetch    func(ctx context.Context, p *priceProvider, from, to time.Time) ([]pricePoint, error)
+	client   *http.Client
+}
+
+func newPriceProvider(name, area, currency, endpoint string) (*priceProvider, error) {
+	p := &priceProvider{name: name, area: area, currency: currency, endpoint: endpoint, client: &http.Client{Timeout: 30 * time.Second}}
+	switch name {
+	case pricesAwattar:
+		if p.area == "" {
+			p.area = "de"
+		}
+		p.area = strings.ToLower(p.area)
+		if p.area != "de" && p.area != "at" {
+			return nil, fmt.Errorf("awattar serves --area de or at, not %q", area)
+		}
+		if p.endpoint == "" {
+			p.endpoint = "https://api.awattar." + p.area + "/v1/marketdata"
+		}
+		p.currency, p.fetch = "EUR", fetchAwattarPrices
+	case pricesNordpool:
+		if p.area == "" {
+			return nil, errors.New("nordpool needs --area, the delivery area such as SE3 or DK1")
+		}
+		p.area, p.currency = strings.ToUpper(p.area), strings.ToUpper(p.currency)
+		if p.endpoint == "" {
+			p.endpoint = "https://dataportal-api.nordpoolgroup.com/api/DayAheadPrices"
+		}
+		p.fetch = fetchNordpoolPrices
+	case pricesOctopus:
+		if _, err := octopusProduct(p.area); err != nil {
+			return nil, err
+		}
+		p.area = strings.ToUpper(p.area)
+		if p.endpoint == "" {
+			p.endpoint = "https://api.octopus.energy/v1/products"
+		}
+		p.currency, p.fetch = "GBP", fetchOctopusPrices
+	default:
+		return nil, fmt.Errorf("unknown --provider %q (supported: %s, %s, %s)", name, pricesAwattar, pricesNordpool, pricesOctopus)
+	}
+	return p, nil
+}
+
+func transferPrices(ctx context.Context, mysqlDSN string, provider *priceProvider) error {
+	sink, err := openExportSink(ctx, mysqlDSN)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	if err := sink.EnsureSchema(ctx, energyPricesTable); err != nil {
+		return fmt.Errorf("ensure energy_prices table: %w", err)
+	}
+	watermarks, err := loadWatermarks(ctx, sink, energyPricesTable)
+	if err != nil {
+		return fmt.Errorf("load energy_prices checkpoints: %w", err)
+	}
+
+	// The newest stored day is fetched again, in case it was stored before it was complete.
+	from := dayStart(inExportZone(wallClock.Now())).AddDate(0, 0, -pricesDays)
+	if newest, ok := watermarks[provider.area]; ok && newest.After(from) {
+		from = dayStart(newest)
+	}
+	to := dayStart(inExportZone(wallClock.Now())).AddDate(0, 0, 2)
+
+	points, err := provider.fetch(ctx, provider, from, to)
+	if err != nil {
+		return err
+	}
+
+	const pricesBatchSize = 500
+	writer := newBatchWriter(sink, energyPricesTable, pricesBatchSize)
+	for _, pt := range points {
+		if err := writer.Add(ctx, provider.name, provider.area, inExportZone(pt.start), inExportZone(pt.end), pt.price, provider.currency); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(ctx); err != nil {
+		return err
+	}
+	return finalizeTable(ctx, sink, energyPricesTable)
+}
+
+// fetchJSON requests endpoint from an HTTP API and decodes the JSON response into dest. A 204
+// No Content, which Nord Pool answers for a day not published yet, reports ok false.
+func fetchJSON(ctx context.Context, client *http.Client, service, endpoint string, header http.Header, dest any) (ok bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return false, fmt.Errorf("build %s request: %w", service, err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("call %s: %w", service, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return false, fmt.Errorf("read %s response: %w", service, err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return false, nil
+	case resp.StatusCode != http.StatusOK:
+		return false, fmt.Errorf("%s returned HTTP %d: %s", service, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.Unmarshal(body, dest); err != nil {
+		return false, fmt.Errorf("decode %s response: %w", service, err)
+	}
+	return true, nil
+}
+
+// fetchAwattarPrices reads aWATTar's market data, in EUR/MWh, for [from, to).
+func fetchAwattarPrices(ctx context.Context, p *priceProvider, from, to time.Time) ([]pricePoint, error) {
+	query := url.Values{}
+	query.Set("start", strconv.FormatInt(from.UnixMilli(), 10))
+	query.Set("end", strconv.FormatInt(to.UnixMilli(), 10))
+	var decoded struct {
+		Data []struct {
+			Start       int64   `json:"start_timestamp"`
+			End         int64   `json:"end_timestamp"`
+			MarketPrice float64 `json:"marketprice"`
+			Unit        string  `json:"unit"`
+		} `json:"data"`
+	}
+	if _, err := fetchJSON(ctx, p.client, p.name, p.endpoint+"?"+query.Encode(), nil, &decoded); err != nil {
+		return nil, err
+	}
+	points := make([]pricePoint, 0, len(decoded.Data))
+	for _, d := range decoded.Data {
+		price, err := perKWh(d.MarketPrice, d.Unit)
+		if err != nil {
+			return nil, fmt.Errorf("awattar: %w", err)
+		}
+		points = append(points, pricePoint{start: time.UnixMilli(d.Start), end: time.UnixMilli(d.End), price: price})
+	}
+	return points, nil
+}
+
+// fetchNordpoolPrices reads Nord Pool's day-ahead prices, in currency/MWh, one delivery day per
+// request. Delivery days are Central European; a day before its prices are published is skipped.
+func fetchNordpoolPrices(ctx context.Context, p *priceProvider, from, to time.Time) ([]pricePoint, error) {
+	var points []pricePoint
+	for day := from.AddDate(0, 0, -1); day.Before(to); day = day.AddDate(0, 0, 1) {
+		query := url.Values{}
+		query.Set("date", day.Format(time.DateOnly))
+		query.Set("market", "DayAhead")
+		query.Set("deliveryArea", p.area)
+		query.Set("currency", p.currency)
+		var decoded struct {
+			Entries []struct {
+				Start   time.Time          `json:"deliveryStart"`
+				End     time.Time          `json:"deliveryEnd"`
+				PerArea map[string]float64 `json:"entryPerArea"`
+			} `json:"multiAreaEntries"`
+		}
+		ok, err := fetchJSON(ctx, p.client, p.name, p.endpoint+"?"+query.Encode(), nil, &decoded)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		for _, e := range decoded.Entries {
+			price, found := e.PerArea[p.area]
+			if !found || !e.End.After(from) || !e.Start.Before(to) {
+				continue
+			}
+			points = append(points, pricePoint{start: e.Start, end: e.End, price: price / 1000})
+		}
+	}
+	return points, nil
+}
+
+// fetchOctopusPrices reads an Octopus tariff's unit rates, in pence/kWh including VAT, following
+// the API's pagination.
+func fetchOctopusPrices(ctx context.Context, p *priceProvider, from, to time.Time) ([]pricePoint, error) {
+	product, err := octopusProduct(p.area)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	query.Set("period_from", from.UTC().Format(time.RFC3339))
+	query.Set("period_to", to.UTC().Format(time.RFC3339))
+	query.Set("page_size", "1500")
+	next := fmt.Sprintf("%s/%s/electricity-tariffs/%s/standard-unit-rates/?%s", strings.TrimSuffix(p.endpoint, "/"), product, p.area, query.Encode())
+
+	var points []pricePoint
+	for next != "" {
+		var decoded struct {
+			Next    *string `json:"next"`
+			Results []struct {
+				ValueIncVAT float64    `json:"value_inc_vat"`
+				ValidFrom   time.Time  `json:"valid_from"`
+				ValidTo     *time.Time `json:"valid_to"`
+			} `json:"results"`
+		}
+		if _, err := fetchJSON(ctx, p.client, p.name, next, nil, &decoded); err != nil {
+			return nil, err
+		}
+		for _, r := range decoded.Results {
+			// A rate without an end holds until it is replaced; it is stored up to the fetched period.
+			start, end := r.ValidFrom, to
+			if r.ValidTo != nil && r.ValidTo.Before(to) {
+				end = *r.ValidTo
+			}
+			if start.Before(from) {
+				start = from
+			}
+			if !start.Before(end) {
+				continue
+			}
+			points = append(points, pricePoint{start: start, end: end, price: r.ValueIncVAT / 100})
+		}
+		next = ""
+		if decoded.Next != nil {
+			next = *decoded.Next
+		}
+	}
+	return points, nil
+}
+
+// octopusProduct derives the product code from a tariff code: E-1R-AGILE-24-10-01-C is the
+// region C tariff of product AGILE-24-10-01.
+func octopusProduct(tariff string) (string, error) {
+	code := strings.ToUpper(tariff)
+	rest, ok := strings.CutPrefix(code, "E-1R-")
+	if !ok {
+		rest, ok = strings.CutPrefix(code, "E-2R-")
+	}
+	if !ok || len(rest) < 3 || rest[len(rest)-2] != '-' {
+		return "", fmt.Errorf("octopus needs --area set to an electricity tariff code such as E-1R-AGILE-24-10-01-C, got %q", tariff)
+	}
+	return rest[:len(rest)-2], nil
+}
+
+// perKWh converts a price quoted per MWh or kWh into one per kWh.
+func perKWh(price float64, unit string) (float64, error) {
+	lower := strings.ToLower(unit)
+	switch {
+	case strings.HasSuffix(lower, "/mwh"):
+		return price / 1000, nil
+	case strings.HasSuffix(lower, "/kwh"):
+		return price, nil
+	default:
+		return 0, fmt.Errorf("unsupported price unit %q", unit)
+	}
+}
@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/spf13/cobra"
+)
+
+// destProfile and sourceProfile are the --dest and --source flags naming connection profiles from
+// the config.
+var (
+	destProfile   string
+	sourceProfile string
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&destProfile, "dest", "", "Connection profile (or alias) from the config supplying --dsn, --dialect, --sink, and TLS options")
+	rootCmd.PersistentFlags().StringVar(&sourceProfile, "source", "", "Connection profile (or alias) from the config supplying --sqlite")
+}
+
+// connectionProfile is a named connection in the config's profiles section. A profile used with
+// --dest needs dsn; one used with --source needs sqlite. Explicit flags win over profile values.
+type connectionProfile struct {
+	// Aliases are further names the profile can be referenced by.
+	Aliases []string `json:"aliases"`
+	// DSN is the sink target, e.g. user@tcp(host:4000)/homedata for the mysql sink. It may be a
+	// secret reference; see secrets.go.
+	DSN string `json:"dsn"`
+	// Password replaces the DSN's password, so the DSN itself holds no secret. It may be a secret
+	// reference such as vault:kv/ha-tools#mysql.
+	Password string `json:"password"`
+	Dialect  string `json:"dialect"`
+	Sink     string `json:"sink"`
+	// RowBudget is the --row-budget of exports to the destination.
+	RowBudget int64 `json:"row_budget"`
+	// TLS configures a client TLS profile for the mysql sink.
+	TLS *profileTLS `json:"tls"`
+	// SQLite is the recorder database path.
+	SQLite string `json:"sqlite"`
+}
+
+// profileTLS holds the TLS options of a mysql connection profile.
+type profileTLS struct {
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+func (p *connectionProfile) validate() error {
+	if p.DSN == "" && p.SQLite == "" {
+		return errors.New("set dsn and/or sqlite")
+	}
+	if p.Dialect != "" {
+		if _, err := resolveDialect(p.Dialect); err != nil {
+			return err
+		}
+	}
+	if p.RowBudget < 0 {
+		return errors.New("row_budget must not be negative")
+	}
+	if p.Sink != "" {
+		if _, ok := sinkFactories[p.Sink]; !ok {
+			return fmt.Errorf("unknown sink %q (registered: %s)", p.Sink, strings.Join(sinkNames(), ", "))
+		}
+	}
+	if (p.Password != "" || p.TLS != nil) && p.Sink != "" && p.Sink != "mysql" {
+		return errors.New("password and tls only apply to the mysql sink")
+	}
+	if t := p.TLS; t != nil && (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("tls: set both cert_file and key_file")
+	}
+	if err := validateSecretRef(p.DSN); err != nil {
+		return fmt.Errorf("dsn: %w", err)
+	}
+	if err := validateSecretRef(p.Password); err != nil {
+		return fmt.Errorf("password: %w", err)
+	}
+	return nil
+}
+
+// validateProfiles checks every profile and that no name or alias is used twice.
+func (c *fileConfig) validateProfiles() error {
+	names := make(map[string]string)
+	for _, name := range sortedKeys(c.Profiles) {
+		profile := c.Profiles[name]
+		if err := profile.validate(); err != nil {
+			return fmt.Errorf("profiles.%s: %w", name, err)
+		}
+		for _, alias := range append([]string{name}, profile.Aliases...) {
+			if other, taken := names[alias]; taken {
+				return fmt.Errorf("profiles.%s: name %q is also used by profiles.%s", name, alias, other)
+			}
+			names[alias] = name
+		}
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// profile looks up a profile by name or alias, returning its canonical name.
+func (c *fileConfig) profile(ref string) (string, *connectionProfile, error) {
+	if p, ok := c.Profiles[ref]; ok {
+		return ref, p, nil
+	}
+	for name, p := range c.Profiles {
+		for _, alias := range p.Aliases {
+			if alias == ref {
+				return name, p, nil
+			}
+		}
+	}
+	if len(c.Profiles) == 0 {
+		return "", nil, fmt.Errorf("unknown profile %q: the config defines no profiles", ref)
+	}
+	return "", nil, fmt.Errorf("unknown profile %q (defined: %s)", ref, strings.Join(sortedKeys(c.Profiles), ", "))
+}
+
+// applyConnectionProfiles fills the command's connection flags from --dest and --source. It runs
+// before the dialect is resolved so a profile's dialect takes effect.
+func applyConnectionProfiles(cmd *cobra.Command) error {
+	if destProfile != "" {
+		name, p, err := appConfig.profile(destProfile)
+		if err != nil {
+			return fmt.Errorf("--dest: %w", err)
+		}
+		if p.DSN == "" {
+			return fmt.Errorf("--dest: profile %s has no dsn", name)
+		}
+		if cmd.Flags().Lookup("dsn") == nil {
+			return fmt.Errorf("--dest: %s does not write to a destination", cmd.CommandPath())
+		}
+		if p.Dialect != "" && !cmd.Flags().Changed("dialect") {
+			dialectName = p.Dialect
+		}
+		if p.Sink != "" && !cmd.Flags().Changed("sink") {
+			sinkName = p.Sink
+		}
+		if p.RowBudget != 0 && !cmd.Flags().Changed("row-budget") {
+			rowBudget = p.RowBudget
+		}
+		if !cmd.Flags().Changed("dsn") {
+			dsn, err := p.destinationDSN(cmd.Context(), name)
+			if err != nil {
+				return fmt.Errorf("--dest: profile %s: %w", name, err)
+			}
+			if err := cmd.Flags().Set("dsn", dsn); err != nil {
+				return err
+			}
+		}
+	}
+
+	if sourceProfile != "" {
+		name, p, err := appConfig.profile(sourceProfile)
+		if err != nil {
+			return fmt.Errorf("--source: %w", err)
+		}
+		if p.SQLite == "" {
+			return fmt.Errorf("--source: profile %s has no sqlite", name)
+		}
+		if cmd.Flags().Lookup("sqlite") == nil {
+			return fmt.Errorf("--source: %s does not read a recorder", cmd.CommandPath())
+		}
+		if !cmd.Flags().Changed("sqlite") {
+			if err := cmd.Flags().Set("sqlite", p.SQLite); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// destinationDSN renders the profile's DSN with its secrets resolved and its password and TLS
+// options applied.
+func (p *connectionProfile) destinationDSN(ctx context.Context, name string) (string, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	dsn, err := resolveSecret(ctx, p.DSN)
+	if err != nil {
+		return "", err
+	}
+	if p.Password == "" && p.TLS == nil {
+		return dsn, nil
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", fmt.Errorf("parse dsn: %w", err)
+	}
+	if p.Password != "" {
+		if cfg.Passwd, err = resolveSecret(ctx, p.Password); err != nil {
+			return "", err
+		}
+	}
+	if p.TLS != nil {
+		key := "profile-" + name
+		tlsConfig, err := p.TLS.config()
+		if err != nil {
+			return "", err
+		}
+		if err := mysql.RegisterTLSConfig(key, tlsConfig); err != nil {
+			return "", fmt.Errorf("register tls config %q: %w", key, err)
+		}
+		cfg.TLSConfig = key
+	}
+	return cfg.FormatDSN(), nil
+}
+
+func (t *profileTLS) config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: read ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificates in %s", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// currentRecorderSchemaVersion is the first recorder schema in the layout the exporters' queries
+// are written for: entity ids in states_meta, event types in event_types, and Unix timestamps in
+// the *_ts columns (Home Assistant 2023.4).
+const currentRecorderSchemaVersion = 41
+
+// untestedSchemaNotice and legacySchemaNotice note once per recorder that its schema is newer
+// than the tested ones, or read through shims.
+var untestedSchemaNotice, legacySchemaNotice sync.Map
+
+// recorderShim is the SELECT a recorder table of an older schema is read through, presenting it
+// in the current layout.
+type recorderShim struct {
+	table, query string
+}
+
+// adaptRecorder picks the query set for the recorder's schema version. Current schemas, and
+// databases without schema_changes, are read as they are. Older ones supported get shims, which
+// restrictRecorder (or emptyRecorder under --explain) installs as TEMP views under the tables'
+// names, so every query of the command runs unchanged. Schemas too old to adapt fail, and ones newer than tested warn or, under
+// --strict-schema, fail.
+func adaptRecorder(ctx context.Context, sqliteDB *sql.DB, sqlitePath string) ([]recorderShim, error) {
+	v, err := recorderSchemaVersion(ctx, sqliteDB)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case v > maxTestedRecorderSchemaVersion:
+		if strictSchema {
+			return nil, fmt.Errorf("recorder %s has schema version %d, newer than the %d this build was tested with (--strict-schema); upgrade ha-tools", sqlitePath, v, maxTestedRecorderSchemaVersion)
+		}
+		if _, warned := untestedSchemaNotice.LoadOrStore(sqlitePath, true); !warned {
+			fmt.Fprintf(os.Stderr, "warning: recorder %s has schema version %d, newer than the %d this build was tested with; check the export or upgrade ha-tools\n", sqlitePath, v, maxTestedRecorderSchemaVersion)
+		}
+		return nil, nil
+	case v == 0 || v >= currentRecorderSchemaVersion:
+		return nil, nil
+	case v < minRecorderSchemaVersion:
+		return nil, fmt.Errorf("recorder %s has schema version %d, which keeps attributes inline in states; ha-tools reads schema %d (Home Assistant 2022.4) and later, so let a newer Home Assistant migrate it first", sqlitePath, v, minRecorderSchemaVersion)
+	}
+
+	if _, noted := legacySchemaNotice.LoadOrStore(sqlitePath, true); !noted {
+		fmt.Fprintf(os.Stderr, "recorder %s has schema version %d; reading it through compatibility views\n", sqlitePath, v)
+	}
+	l := legacyRecorder{ctx: ctx, db: sqliteDB}
+	states, err := l.columns("states")
+	if err != nil {
+		return nil, err
+	}
+	entityID := "entity_id"
+	if states["metadata_id"] && l.has("states_meta") {
+		// Schemas between releases moved entity ids to states_meta row by row.
+		entityID = "COALESCE((SELECT m.entity_id FROM main.states_meta m WHERE m.metadata_id = main.states.metadata_id), entity_id)"
+	}
+	shims := []recorderShim{{"states", `SELECT state_id, ` + entityID + ` AS metadata_id, state, attributes_id, old_state_id, ` +
+		legacyTimestamp(states, "last_updated") + ` AS last_updated_ts, ` +
+		legacyTimestamp(states, "last_changed") + ` AS last_changed_ts FROM main.states`}}
+
+	for _, table := range []string{"statistics", "statistics_short_term"} {
+		if !l.has(table) {
+			continue
+		}
+		cols, err := l.columns(table)
+		if err != nil {
+			return nil, err
+		}
+		shims = append(shims, recorderShim{table, `SELECT id, metadata_id, ` + legacyTimestamp(cols, "start") + ` AS start_ts, mean, min, max, ` +
+			legacyTimestamp(cols, "last_reset") + ` AS last_reset_ts, state, sum FROM main.` + table})
+	}
+
+	events, err := l.columns("events")
+	if err != nil {
+		return nil, err
+	}
+	// Events keep their data inline until event_data, and partly after it; inline data is keyed by
+	// the negated event_id so it cannot collide with a data_id.
+	dataID, eventData := "-event_id", "SELECT -event_id AS data_id, event_data AS shared_data FROM main.events WHERE event_data IS NOT NULL"
+	if l.has("event_data") {
+		dataID = "COALESCE(data_id, -event_id)"
+		eventData = "SELECT data_id, shared_data FROM main.event_data UNION ALL " + eventData + " AND data_id IS NULL"
+	}
+	contextID := "NULL"
+	if events["context_id_bin"] {
+		contextID = "context_id_bin"
+	}
+	shims = append(shims, recorderShim{"events", `SELECT event_id, event_type AS event_type_id, ` + dataID + ` AS data_id, ` +
+		legacyTimestamp(events, "time_fired") + ` AS time_fired_ts, ` + contextID + ` AS context_id_bin FROM main.events`})
+
+	// The lookup tables come from the shimmed views, so --limit and --sample narrow them too.
+	return append(shims,
+		recorderShim{"states_meta", "SELECT DISTINCT metadata_id, metadata_id AS entity_id FROM states"},
+		recorderShim{"event_types", "SELECT DISTINCT event_type_id, event_type_id AS event_type FROM events"},
+		recorderShim{"event_data", eventData},
+	), nil
+}
+
+// recorderHasTable reports whether the recorder has table, or a shim standing in for it.
+func recorderHasTable(ctx context.Context, sqliteDB *sql.DB, table string) (bool, error) {
+	var n int
+	err := sqliteDB.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM (
+    SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?1
+    UNION ALL SELECT name FROM sqlite_temp_master WHERE type = 'view' AND name = ?1
+)`, table).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("look up recorder table %s: %w", table, err)
+	}
+	return n > 0, nil
+}
+
+// legacyRecorder inspects the tables of an older recorder schema.
+type legacyRecorder struct {
+	ctx context.Context
+	db  *sql.DB
+}
+
+func (l legacyRecorder) has(table string) bool {
+	var n int
+	err := l.db.QueryRowContext(l.ctx, "SELECT COUNT(*) FROM main.sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&n)
+	return err == nil && n > 0
+}
+
+func (l legacyRecorder) columns(table string) (map[string]bool, error) {
+	rows, err := l.db.QueryContext(l.ctx, "SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, fmt.Errorf("read recorder %s columns: %w", table, err)
+	}
+	defer rows.Close()
+	cols := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("read recorder %s columns: %w", table, err)
+		}
+		cols[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read recorder %s columns: %w", table, err)
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("recorder has no %s table", table)
+	}
+	return cols, nil
+}
+
+// legacyTimestamp returns the Unix seconds of a timestamp column: its *_ts column where the
+// schema has one and the row was migrated, and otherwise its UTC text column, microseconds kept.
+func legacyTimestamp(cols map[string]bool, column string) string {
+	text := fmt.Sprintf("CAST(strftime('%%s', %[1]s) AS REAL) + CAST('0' || substr(%[1]s, 20, 7) AS REAL)", column)
+	if !cols[column] {
+		text = "NULL"
+	}
+	if cols[column+"_ts"] {
+		return "COALESCE(" + column + "_ts, " + text + ")"
+	}
+	return text
+}
+
+// shimQuery returns the SELECT restrictRecorder reads a table through: its shim, or the table.
+func shimQuery(shims []recorderShim, table string) string {
+	for _, s := range shims {
+		if s.table == table {
+			return "SELECT * FROM (" + s.query + ")"
+		}
+	}
+	return "SELECT * FROM main." + table
+}
+
+// isLimitedSource reports whether --limit and --sample restrict table.
+func isLimitedSource(table string) bool {
+	for _, source := range limitedSources {
+		if source.table == table {
+			return true
+		}
+	}
+	return false
+}
+
+// createShimViews installs the shims of the tables --limit and --sample leave alone, after the
+// restricted ones the lookup tables derive from.
+func createShimViews(ctx context.Context, sqliteDB *sql.DB, shims []recorderShim, limited bool) error {
+	for _, s := range shims {
+		if limited && isLimitedSource(s.table) {
+			continue
+		}
+		if _, err := sqliteDB.ExecContext(ctx, "CREATE TEMP VIEW "+s.table+" AS "+s.query); err != nil {
+			return fmt.Errorf("adapt recorder table %s: %w", s.table, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	registryStorageDir string
+	registryMySQLDSN   string
+)
+
+// registryCmd loads Home Assistant's entity, device, and area registries into MySQL.
+var registryCmd = &cobra.Command{
+	Use:   "registry",
+	Short: "Export the Home Assistant entity, device, and area registries into MySQL",
+	Long:  "Parses core.entity_registry, core.device_registry, and core.area_registry from Home Assistant's .storage directory and upserts them into ha_entities, ha_devices, and ha_areas, so exported telemetry can be joined to manufacturer, model, and area. Rows removed from the registry are deleted.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if registryStorageDir == "" {
+			return errors.New("storage directory is required")
+		}
+		if registryMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		counts, err := transferRegistry(ctx, registryStorageDir, registryMySQLDSN)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "synced %d entities, %d devices, %d areas\n", counts[0], counts[1], counts[2])
+		return nil
+	},
+}
+
+func init() {
+	registryCmd.Flags().StringVar(&registryStorageDir, "storage-dir", "", "Home Assistant .storage directory, e.g. /config/.storage")
+	registryCmd.Flags().StringVar(&registryMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	_ = registryCmd.MarkFlagRequired("storage-dir")
+	_ = registryCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(registryCmd)
+}
+
+// haAreasTable mirrors core.area_registry.
+var haAreasTable = &tableSpec{
+	name: "ha_areas",
+	columns: []columnSpec{
+		{name: "area_id", sqlType: "VARCHAR(64) NOT NULL"},
+		{name: "name", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "floor_id", sqlType: "VARCHAR(64) NULL"},
+		{name: "synced_at", sqlType: "DATETIME NOT NULL"},
+	},
+	primaryKey: []string{"area_id"},
+}
+
+// haDevicesTable mirrors core.device_registry; name prefers the user's name over the integration's.
+var haDevicesTable = &tableSpec{
+	name: "ha_devices",
+	columns: []columnSpec{
+		{name: "device_id", sqlType: "VARCHAR(64) NOT NULL"},
+		{name: "name", sqlType: "VARCHAR(255) NULL"},
+		{name: "manufacturer", sqlType: "VARCHAR(255) NULL"},
+		{name: "model", sqlType: "VARCHAR(255) NULL"},
+		{name: "sw_version", sqlType: "VARCHAR(255) NULL"},
+		{name: "hw_version", sqlType: "VARCHAR(255) NULL"},
+		{name: "area_id", sqlType: "VARCHAR(64) NULL"},
+		{name: "via_device_id", sqlType: "VARCHAR(64) NULL"},
+		{name: "disabled_by", sqlType: "VARCHAR(32) NULL"},
+		{name: "synced_at", sqlType: "DATETIME NOT NULL"},
+	},
+	primaryKey: []string{"device_id"},
+	indexes:    []indexSpec{{name: "idx_ha_devices_area_id", columns: []string{"area_id"}}},
+}
+
+// haEntitiesTable mirrors core.entity_registry. area_id is the entity's own area or else its
+// device's, the way Home Assistant assigns it; name and device_class fall back to the integration's.
+var haEntitiesTable = &tableSpec{
+	name: "ha_entities",
+	columns: []columnSpec{
+		{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "unique_id", sqlType: "VARCHAR(255) NULL"},
+		{name: "platform", sqlType: "VARCHAR(64) NULL"},
+		{name: "device_id", sqlType: "VARCHAR(64) NULL"},
+		{name: "area_id", sqlType: "VARCHAR(64) NULL"},
+		{name: "name", sqlType: "VARCHAR(255) NULL"},
+		{name: "device_class", sqlType: "VARCHAR(64) NULL"},
+		{name: "unit_of_measurement", sqlType: "VARCHAR(64) NULL"},
+		{name: "disabled_by", sqlType: "VARCHAR(32) NULL"},
+		{name: "hidden_by", sqlType: "VARCHAR(32) NULL"},
+		{name: "synced_at", sqlType: "DATETIME NOT NULL"},
+	},
+	primaryKey: []string{"entity_id"},
+	indexes: []indexSpec{
+		{name: "idx_ha_entities_device_id", columns: []string{"device_id"}},
+		{name: "idx_ha_entities_area_id", columns: []string{"area_id"}},
+	},
+}
+
+func firstSet(values ...*string) *string {
+	for _, v := range values {
+		if v != nil && *v != "" {
+			return v
+		}
+	}
+	return nil
+}
+
+// transferRegistry upserts the registries and returns the entity, device, and area counts. A
+// missing device or area registry (older installs, trimmed backups) leaves its table empty.
+func transferRegistry(ctx context.Context, dir, mysqlDSN string) ([3]int, error) {
+	var counts [3]int
+	entities, err := loadEntityRegistry(dir)
+	if err != nil {
+		return counts, err
+	}
+	devices, err := loadDeviceRegistry(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return counts, err
+	}
+	areas, err := loadAreaRegistry(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return counts, err
+	}
+
+	sink, err := openExportSink(ctx, mysqlDSN)
+	if err != nil {
+		return counts, err
+	}
+	defer sink.Close()
+
+	for _, table := range []*tableSpec{haAreasTable, haDevicesTable, haEntitiesTable} {
+		if err := sink.EnsureSchema(ctx, table); err != nil {
+			return counts, fmt.Errorf("ensure %s table: %w", table.name, err)
+		}
+	}
+
+	const registryBatchSize = 500
+	syncedAt := wallClock.Now().UTC().Truncate(time.Second)
+
+	deviceAreas := make(map[string]*string, len(devices))
+	writer := newBatchWriter(sink, haDevicesTable, registryBatchSize)
+	for _, d := range devices {
+		deviceAreas[d.ID] = d.AreaID
+		if err := writer.Add(ctx, d.ID, firstSet(d.NameByUser, d.Name), d.Manufacturer, d.Model, d.SWVersion, d.HWVersion, d.AreaID, d.ViaDeviceID, d.DisabledBy, syncedAt); err != nil {
+			return counts, err
+		}
+	}
+	if err := writer.Flush(ctx); err != nil {
+		return counts, err
+	}
+
+	writer = newBatchWriter(sink, haEntitiesTable, registryBatchSize)
+	for _, e := range entities {
+		area := e.AreaID
+		if firstSet(area) == nil && e.DeviceID != nil {
+			area = deviceAreas[*e.DeviceID]
+		}
+		if err := writer.Add(ctx, e.EntityID, e.UniqueID, e.Platform, e.DeviceID, firstSet(area),
+			firstSet(e.Name, e.OriginalName), firstSet(e.DeviceClass, e.OriginalDeviceClass),
+			e.UnitOfMeasurement, e.DisabledBy, e.HiddenBy, syncedAt); err != nil {
+			return counts, err
+		}
+	}
+	if err := writer.Flush(ctx); err != nil {
+		return counts, err
+	}
+
+	writer = newBatchWriter(sink, haAreasTable, registryBatchSize)
+	for _, a := range areas {
+		if err := writer.Add(ctx, a.ID, a.Name, a.FloorID, syncedAt); err != nil {
+			return counts, err
+		}
+	}
+	if err := writer.Flush(ctx); err != nil {
+		return counts, err
+	}
+
+	if err := pruneRegistryTables(ctx, sink, syncedAt); err != nil {
+		return counts, err
+	}
+	return [3]int{len(entities), len(devices), len(areas)}, nil
+}
+
+// pruneRegistryTables deletes the rows this run did not write: entries removed from the registry.
+func pruneRegistryTables(ctx context.Context, sink Sink, syncedAt time.Time) error {
+	sq, ok := sink.(sqlSink)
+	if !ok {
+		return nil
+	}
+	for _, table := range []*tableSpec{haAreasTable, haDevicesTable, haEntitiesTable} {
+		if _, err := execStatement(ctx, sq.DB(), "DELETE FROM "+table.name+" WHERE synced_at < ?", syncedAt); err != nil {
+			return fmt.Errorf("prune %s: %w", table.name, err)
+		}
+	}
+	return nil
+}
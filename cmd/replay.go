@@ -0,0 +1,291 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	replayMySQLDSN string
+	replayTable    string
+	replayDryRun   bool
+)
+
+// replayCmd retries the rows --on-error=collect parked in ha_tools_rejects.
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Convert rows parked in ha_tools_rejects again and move them into their tables",
+	Long:  "Re-runs the current conversion on every row --on-error=collect stored in ha_tools_rejects. Converted rows are written to their destination table and removed from ha_tools_rejects; rows the exporter would skip anyway (unavailable, non-numeric) are removed too; rows that still fail stay with their new reason.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if replayMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		sink, err := openSink(ctx, sinkName, replayMySQLDSN)
+		if err != nil {
+			return err
+		}
+		defer sink.Close()
+
+		sq, ok := sink.(sqlSink)
+		if !ok {
+			return fmt.Errorf("the %s sink does not support replay", sinkName)
+		}
+		if err := sink.EnsureSchema(ctx, rejectsTable); err != nil {
+			return fmt.Errorf("ensure %s table: %w", rejectsTable.name, err)
+		}
+
+		results, err := replayRejects(ctx, sink, sq.DB(), replayTable)
+		if err != nil {
+			return err
+		}
+		printReplayResults(cmd.OutOrStdout(), results)
+		return nil
+	},
+}
+
+func init() {
+	replayCmd.Flags().StringVar(&replayMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	replayCmd.Flags().StringVar(&replayTable, "table", "", "Only replay rows meant for this table, e.g. gps_points")
+	replayCmd.Flags().BoolVar(&replayDryRun, "dry-run", false, "Convert the rows and report the outcome without writing or deleting anything")
+	_ = replayCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(replayCmd)
+}
+
+// rowReplayer converts a rejected row for its table the way the exporter does. ok is false when the
+// exporter would skip the row without an error (no coordinates, non-numeric state, ...).
+type rowReplayer func(row rejectedRow) (values []any, ok bool, err error)
+
+// replayTarget pairs a destination table with the conversion of its rows.
+type replayTarget struct {
+	table   *tableSpec
+	convert rowReplayer
+}
+
+// replayTargets lists the tables whose rejects can be replayed. Rows are written in the default
+// layout: columns added by optional exporter flags (--spatial, --with-delta, ...) are left NULL, and
+// numeric rows are neither minute-averaged nor given hashed state_ids. Presence stays are derived
+// from sequences of states, so their rejects need a re-export instead.
+var replayTargets = map[string]replayTarget{
+	gpsPointsTable.name:     {gpsPointsTable, replayGPSRow},
+	batteryPointsTable.name: {batteryPointsTable, replayBatteryRow},
+	weatherPointsTable.name: {weatherPointsTable, replayWeatherRow},
+	"energy_points":         {newEnergyFamily(nil).pointsTable(), replayNumericRow},
+	"climate_points":        {newClimateFamily(nil, false).pointsTable(), replayNumericRow},
+	"utilities_points":      {newUtilitiesFamily(nil).pointsTable(), replayNumericRow},
+}
+
+func replayGPSRow(row rejectedRow) ([]any, bool, error) {
+	latitude, longitude, accuracy, err := extractCoordinates(row.attributes)
+	if err != nil {
+		return nil, false, fmt.Errorf("parse attributes for state_id %d: %w", row.stateID, err)
+	}
+	if !latitude.Valid || !longitude.Valid {
+		return nil, false, nil
+	}
+	lastUpdated, err := floatToNullTime(row.lastUpdatedTS)
+	if err != nil {
+		return nil, false, fmt.Errorf("convert last_updated_ts for state_id %d: %w", row.stateID, err)
+	}
+	return []any{row.stateID, row.entityID, row.state, latitude, longitude, accuracy, lastUpdated}, true, nil
+}
+
+func replayBatteryRow(row rejectedRow) ([]any, bool, error) {
+	level, err := extractBatteryLevel(row.state, row.attributes)
+	if err != nil {
+		return nil, false, fmt.Errorf("parse attributes for state_id %d: %w", row.stateID, err)
+	}
+	if !level.Valid {
+		return nil, false, nil
+	}
+	lastUpdated, err := floatToNullTime(row.lastUpdatedTS)
+	if err != nil {
+		return nil, false, fmt.Errorf("convert last_updated_ts for state_id %d: %w", row.stateID, err)
+	}
+	return []any{row.stateID, row.entityID, level, lastUpdated}, true, nil
+}
+
+func replayWeatherRow(row rejectedRow) ([]any, bool, error) {
+	lastUpdated, err := floatToNullTime(row.lastUpdatedTS)
+	if err != nil {
+		return nil, false, fmt.Errorf("convert last_updated_ts for state_id %d: %w", row.stateID, err)
+	}
+	attributes, err := extractWeatherAttributes(row.attributes)
+	if err != nil {
+		return nil, false, fmt.Errorf("parse attributes for state_id %d: %w", row.stateID, err)
+	}
+	values := []any{row.stateID, row.entityID, row.state}
+	for _, v := range attributes {
+		values = append(values, v)
+	}
+	return append(values, lastUpdated), true, nil
+}
+
+func replayNumericRow(row rejectedRow) ([]any, bool, error) {
+	lastUpdated, err := floatToNullTime(row.lastUpdatedTS)
+	if err != nil {
+		return nil, false, fmt.Errorf("convert last_updated_ts for state_id %d: %w", row.stateID, err)
+	}
+	meta, err := extractStateMetadata(row.attributes)
+	if err != nil {
+		return nil, false, fmt.Errorf("parse attributes for state_id %d: %w", row.stateID, err)
+	}
+	numericState := parseNumericState(row.state)
+	if !numericState.Valid {
+		return nil, false, nil
+	}
+	return []any{row.entityID, row.state, numericState, meta.Unit, meta.DeviceClass, meta.StateClass, meta.FriendlyName, lastUpdated, row.stateID}, true, nil
+}
+
+// replayResult counts the outcome of one target table's rejects.
+type replayResult struct {
+	table                                   string
+	replayed, dropped, failing, unsupported int
+}
+
+// replayRejects converts every reject (of onlyTable, when set), writes the converted rows, and only
+// then deletes the replayed and dropped rejects, so a failed write leaves them in place.
+func replayRejects(ctx context.Context, sink Sink, db *sql.DB, onlyTable string) ([]*replayResult, error) {
+	query := "SELECT target_table, state_id, entity_id, state, last_updated_ts, shared_attrs FROM " + rejectsTable.name
+	var args []any
+	if onlyTable != "" {
+		query += " WHERE target_table = ?"
+		args = append(args, onlyTable)
+	}
+	query += " ORDER BY target_table, state_id"
+
+	type rejectKey struct {
+		table   string
+		stateID int64
+	}
+	type newReason struct {
+		key    rejectKey
+		reason string
+	}
+	var (
+		results  = map[string]*replayResult{}
+		writers  = map[string]*batchWriter{}
+		resolved []rejectKey
+		failures []newReason
+	)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", rejectsTable.name, err)
+	}
+	defer rows.Close()
+
+	const replayBatchSize = 500
+	for rows.Next() {
+		var (
+			targetTable string
+			row         rejectedRow
+		)
+		if err := rows.Scan(&targetTable, &row.stateID, &row.entityID, &row.state, &row.lastUpdatedTS, &row.attributes); err != nil {
+			return nil, fmt.Errorf("scan %s row: %w", rejectsTable.name, err)
+		}
+		result, ok := results[targetTable]
+		if !ok {
+			result = &replayResult{table: targetTable}
+			results[targetTable] = result
+		}
+		key := rejectKey{targetTable, row.stateID}
+
+		target, ok := replayTargets[targetTable]
+		if !ok {
+			result.unsupported++
+			continue
+		}
+		values, ok, err := target.convert(row)
+		switch {
+		case err != nil:
+			result.failing++
+			failures = append(failures, newReason{key, err.Error()})
+			continue
+		case !ok:
+			result.dropped++
+			resolved = append(resolved, key)
+			continue
+		}
+
+		result.replayed++
+		resolved = append(resolved, key)
+		if replayDryRun {
+			continue
+		}
+		writer, ok := writers[targetTable]
+		if !ok {
+			writer = newBatchWriter(sink, target.table, replayBatchSize)
+			writers[targetTable] = writer
+		}
+		if err := writer.Add(ctx, values...); err != nil {
+			return nil, fmt.Errorf("write %s: %w", targetTable, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s rows: %w", rejectsTable.name, err)
+	}
+	rows.Close()
+
+	sorted := make([]*replayResult, 0, len(results))
+	for _, r := range results {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].table < sorted[j].table })
+	if replayDryRun {
+		return sorted, nil
+	}
+
+	for table, writer := range writers {
+		if err := writer.Flush(ctx); err != nil {
+			return nil, fmt.Errorf("write %s: %w", table, err)
+		}
+	}
+	for _, f := range failures {
+		stmt := "UPDATE " + rejectsTable.name + " SET reason = ? WHERE target_table = ? AND state_id = ?"
+		if _, err := execStatement(ctx, db, stmt, f.reason, f.key.table, f.key.stateID); err != nil {
+			return nil, fmt.Errorf("update %s: %w", rejectsTable.name, err)
+		}
+	}
+	for start := 0; start < len(resolved); start += replayBatchSize {
+		end := min(start+replayBatchSize, len(resolved))
+		conditions := make([]string, 0, end-start)
+		deleteArgs := make([]any, 0, 2*(end-start))
+		for _, key := range resolved[start:end] {
+			conditions = append(conditions, "(target_table = ? AND state_id = ?)")
+			deleteArgs = append(deleteArgs, key.table, key.stateID)
+		}
+		stmt := "DELETE FROM " + rejectsTable.name + " WHERE " + strings.Join(conditions, " OR ")
+		if _, err := execStatement(ctx, db, stmt, deleteArgs...); err != nil {
+			return nil, fmt.Errorf("delete replayed rows from %s: %w", rejectsTable.name, err)
+		}
+	}
+	return sorted, nil
+}
+
+func printReplayResults(w io.Writer, results []*replayResult) {
+	if len(results) == 0 {
+		fmt.Fprintf(w, "%s is empty\n", rejectsTable.name)
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tREPLAYED\tDROPPED\tSTILL FAILING\tUNSUPPORTED")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", r.table, r.replayed, r.dropped, r.failing, r.unsupported)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportMySQLDSN string
+	reportPeriod   string
+	reportFormat   string
+	reportEntity   string
+	reportPrice    float64
+	reportOutput   string
+	reportEmail    bool
+)
+
+// energyReportCmd renders a billing period's consumption and cost per energy meter.
+var energyReportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Render a billing-period consumption and cost report from energy_points",
+	Long:  "Sums the consumption of every energy meter (unit Wh, kWh, or MWh) in energy_points over a billing period, handling resets as utilities_daily does, prices it with --price or the config's tariff, and renders the report as HTML or CSV. With --email it is sent through the config's smtp server.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if reportMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+		if reportFormat != "html" && reportFormat != "csv" {
+			return fmt.Errorf("unknown --format %q (supported: html, csv)", reportFormat)
+		}
+		if reportPrice < 0 {
+			return errors.New("--price must not be negative")
+		}
+		if reportEmail && appConfig.SMTP == nil {
+			return errors.New("--email needs an smtp section in the --config file")
+		}
+		start, end, err := parseBillingPeriod(reportPeriod, inExportZone(wallClock.Now()))
+		if err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		db, err := openDestination(ctx, reportMySQLDSN)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		report, err := buildEnergyReport(ctx, db, start, end)
+		if err != nil {
+			return err
+		}
+		var rendered bytes.Buffer
+		if reportFormat == "html" {
+			err = report.writeHTML(&rendered)
+		} else {
+			err = report.writeCSV(&rendered)
+		}
+		if err != nil {
+			return fmt.Errorf("render report: %w", err)
+		}
+
+		if reportOutput != "" {
+			if err := os.WriteFile(reportOutput, rendered.Bytes(), 0o644); err != nil {
+				return fmt.Errorf("write report: %w", err)
+			}
+		} else if !reportEmail {
+			if _, err := cmd.OutOrStdout().Write(rendered.Bytes()); err != nil {
+				return err
+			}
+		}
+		if !reportEmail {
+			return nil
+		}
+
+		subject := "Energy report " + report.Label
+		if reportFormat == "html" {
+			err = sendMail(ctx, appConfig.SMTP, subject, "text/html", rendered.Bytes())
+		} else {
+			name := "energy-report-" + strings.ReplaceAll(report.Label, " ", "") + ".csv"
+			err = sendMail(ctx, appConfig.SMTP, subject, "text/plain", []byte(subject+" is attached.\r\n"),
+				mailAttachment{name: name, contentType: "text/csv; charset=utf-8", data: rendered.Bytes()})
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "emailed the report to %s\n", strings.Join(appConfig.SMTP.To, ", "))
+		return nil
+	},
+}
+
+func init() {
+	energyReportCmd.Flags().StringVar(&reportMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	energyReportCmd.Flags().StringVar(&reportPeriod, "period", "", "Billing period: a month (YYYY-MM) or a date range (YYYY-MM-DD..YYYY-MM-DD, inclusive); default: last month")
+	energyReportCmd.Flags().StringVar(&reportFormat, "format", "html", "Report format: html or csv")
+	energyReportCmd.Flags().StringVar(&reportEntity, "entity", "", "Optional slug narrowing the reported meters (substring of entity_id)")
+	energyReportCmd.Flags().Float64Var(&reportPrice, "price", 0, "Electricity price per kWh (0 = the config's tariff, if it has prices)")
+	energyReportCmd.Flags().StringVar(&reportOutput, "output", "", "Write the report to this file instead of stdout")
+	energyReportCmd.Flags().BoolVar(&reportEmail, "email", false, "Email the report to the recipients of the config's smtp section")
+	_ = energyReportCmd.MarkFlagRequired("dsn")
+
+	energyCmd.AddCommand(energyReportCmd)
+}
+
+// parseBillingPeriod returns the local-time bounds [start, end) of a --period value. An empty
+// value is the calendar month before now.
+func parseBillingPeriod(raw string, now time.Time) (start, end time.Time, err error) {
+	if raw == "" {
+		thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, exportZone)
+		return thisMonth.AddDate(0, -1, 0), thisMonth, nil
+	}
+	if from, to, ok := strings.Cut(raw, ".."); ok {
+		start, err1 := time.ParseInLocation(time.DateOnly, from, exportZone)
+		last, err2 := time.ParseInLocation(time.DateOnly, to, exportZone)
+		if err1 != nil || err2 != nil || last.Before(start) {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid --period %q (use YYYY-MM or YYYY-MM-DD..YYYY-MM-DD)", raw)
+		}
+		return start, last.AddDate(0, 0, 1), nil
+	}
+	month, err := time.ParseInLocation("2006-01", raw, exportZone)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid --period %q (use YYYY-MM or YYYY-MM-DD..YYYY-MM-DD)", raw)
+	}
+	return month, month.AddDate(0, 1, 0), nil
+}
+
+// energyReport is the rendered data: one line per meter and the totals.
+type energyReport struct {
+	Label      string
+	Start, End time.Time
+	Generated  time.Time
+	// Windows are the tariff window names, when the config has a tariff.
+	Windows []string
+	Lines   []*energyReportLine
+	Total   energyReportLine
+	Priced  bool
+}
+
+// energyReportLine is one meter's consumption over the period, in kWh.
+type energyReportLine struct {
+	EntityID string
+	Name     string
+	Unit     string
+	Days     int
+	Readings int
+	Resets   int
+	KWh      float64
+	Windows  []float64
+	Cost     float64
+}
+
+func buildEnergyReport(ctx context.Context, db *sql.DB, start, end time.Time) (*energyReport, error) {
+	report := &energyReport{Start: start, End: end, Generated: inExportZone(wallClock.Now())}
+	last := end.AddDate(0, 0, -1)
+	switch {
+	case start.Day() == 1 && end.Equal(start.AddDate(0, 1, 0)):
+		report.Label = start.Format("2006-01")
+	case start.Equal(last):
+		report.Label = start.Format(time.DateOnly)
+	default:
+		report.Label = start.Format(time.DateOnly) + " to " + last.Format(time.DateOnly)
+	}
+
+	tariff := appConfig.Tariff
+	if tariff != nil {
+		for _, w := range tariff.Windows {
+			report.Windows = append(report.Windows, w.Name)
+		}
+	}
+	report.Priced = reportPrice > 0 || (tariff != nil && tariff.priced())
+	report.Total.Windows = make([]float64, len(report.Windows))
+
+	// Each meter's newest reading before the period is the baseline of its first day.
+	query := `
+SELECT p.entity_id, p.numeric_state, p.unit, p.friendly_name, p.last_updated
+FROM energy_points p
+WHERE p.numeric_state IS NOT NULL AND p.unit IN ('Wh', 'kWh', 'MWh') AND p.last_updated < ?
+  AND (p.last_updated >= ? OR (p.entity_id, p.last_updated) IN (
+      SELECT entity_id, MAX(last_updated) FROM energy_points
+      WHERE last_updated < ? AND numeric_state IS NOT NULL AND unit IN ('Wh', 'kWh', 'MWh')
+      GROUP BY entity_id
+  ))`
+	args := []any{end, start, start}
+	if reportEntity != "" {
+		query += " AND p.entity_id LIKE ?" + likeEscape
+		args = append(args, likeContains(reportEntity))
+	}
+	query += "\nORDER BY p.entity_id, p.last_updated"
+
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(qctx, query, args...)
+	if err != nil {
+		return nil, explainTimeout(qctx, fmt.Errorf("read energy_points: %w", err))
+	}
+	defer rows.Close()
+
+	var line *energyReportLine
+	daily := &meterDaily{since: start, tariff: tariff, emit: func(entityID string, d *meterDay) error {
+		if line == nil || line.EntityID != entityID {
+			line = &energyReportLine{EntityID: entityID, Windows: make([]float64, len(report.Windows))}
+			report.Lines = append(report.Lines, line)
+		}
+		factor := kwhFactor(d.meta.Unit.String)
+		line.Name, line.Unit = d.meta.FriendlyName.String, d.meta.Unit.String
+		line.Days++
+		line.Readings += d.readings
+		line.Resets += d.resets
+		line.KWh += d.consumption * factor
+		for i, consumption := range d.windows {
+			line.Windows[i] += consumption * factor
+		}
+		switch {
+		case reportPrice > 0:
+			line.Cost += d.consumption * factor * reportPrice
+		case report.Priced:
+			line.Cost += tariff.cost(d.windows, d.meta.Unit.String)
+		}
+		return nil
+	}}
+	for rows.Next() {
+		var (
+			entityID    string
+			value       float64
+			meta        stateMetadata
+			lastUpdated time.Time
+		)
+		if err := rows.Scan(&entityID, &value, &meta.Unit, &meta.FriendlyName, &lastUpdated); err != nil {
+			return nil, fmt.Errorf("scan energy_points row: %w", err)
+		}
+		if err := daily.reading(entityID, inExportZone(lastUpdated), value, meta); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, explainTimeout(qctx, fmt.Errorf("read energy_points: %w", err))
+	}
+	if err := daily.flush(); err != nil {
+		return nil, err
+	}
+
+	for _, l := range report.Lines {
+		report.Total.Readings += l.Readings
+		report.Total.Resets += l.Resets
+		report.Total.KWh += l.KWh
+		report.Total.Cost += l.Cost
+		for i, kwh := range l.Windows {
+			report.Total.Windows[i] += kwh
+		}
+	}
+	return report, nil
+}
+
+func (r *energyReport) writeCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	header := []string{"entity_id", "name", "days", "readings", "resets", "consumption_kwh"}
+	for _, name := range r.Windows {
+		header = append(header, name+"_kwh")
+	}
+	if r.Priced {
+		header = append(header, "cost")
+	}
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	for _, l := range append(r.Lines, &r.Total) {
+		record := []string{l.EntityID, l.Name, strconv.Itoa(l.Days), strconv.Itoa(l.Readings), strconv.Itoa(l.Resets), format(l.KWh)}
+		if l == &r.Total {
+			record[0], record[2] = "total", ""
+		}
+		for _, kwh := range l.Windows {
+			record = append(record, format(kwh))
+		}
+		if r.Priced {
+			record = append(record, strconv.FormatFloat(l.Cost, 'f', 2, 64))
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+var energyReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"kwh":  func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) },
+	"cost": func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Energy report {{.Label}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 10px; border-bottom: 1px solid #ddd; }
+td.n { text-align: right; }
+tr.total td { font-weight: bold; border-top: 2px solid #444; }
+</style>
+</head>
+<body>
+<h1>Energy report {{.Label}}</h1>
+<p>{{.Start.Format "2006-01-02"}} to {{(.End.AddDate 0 0 -1).Format "2006-01-02"}}, generated {{.Generated.Format "2006-01-02 15:04"}}</p>
+{{- if .Lines}}
+<table>
+<tr><th>Meter</th><th>Days</th><th>kWh</th>{{range .Windows}}<th>{{.}} kWh</th>{{end}}{{if .Priced}}<th>Cost</th>{{end}}</tr>
+{{- range .Lines}}
+<tr><td>{{if .Name}}{{.Name}}<br><small>{{.EntityID}}</small>{{else}}{{.EntityID}}{{end}}{{if .Resets}} <small>({{.Resets}} reset{{if gt .Resets 1}}s{{end}})</small>{{end}}</td><td class="n">{{.Days}}</td><td class="n">{{kwh .KWh}}</td>{{range .Windows}}<td class="n">{{kwh .}}</td>{{end}}{{if $.Priced}}<td class="n">{{cost .Cost}}</td>{{end}}</tr>
+{{- end}}
+<tr class="total"><td>Total</td><td></td><td class="n">{{kwh .Total.KWh}}</td>{{range .Total.Windows}}<td class="n">{{kwh .}}</td>{{end}}{{if .Priced}}<td class="n">{{cost .Total.Cost}}</td>{{end}}</tr>
+</table>
+{{- else}}
+<p>No energy meter readings in this period.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+func (r *energyReport) writeHTML(w io.Writer) error {
+	return energyReportTemplate.Execute(w, r)
+}
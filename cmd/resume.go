@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resumeFrom is the --resume flag: a token printed by an interrupted run, or a file holding one.
+// resumeFile is where an interrupted run saves its token.
+var (
+	resumeFrom string
+	resumeFile = "ha-tools-resume.token"
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&resumeFrom, "resume", "", "Resume token (or a file holding one) from an interrupted run: the entities it covers continue from where that run stopped instead of from the destination's watermarks")
+	rootCmd.PersistentFlags().StringVar(&resumeFile, "resume-file", resumeFile, "File an interrupted run saves its resume token to (empty = only print it)")
+}
+
+// resumeTokenPrefix marks (and versions) a resume token: the prefix followed by base64url of the
+// gzipped JSON resumeState. Tokens of the legacyResumeTokenPrefix version hold times only.
+const (
+	resumeTokenPrefix       = "ha2."
+	legacyResumeTokenPrefix = "ha1."
+)
+
+// resumePoint is where an entity's committed rows end: the newest time, and the highest idColumn
+// value among the rows at that time. ID is 0 for tables without an idColumn (and in legacy
+// tokens), whose rows at At are all taken as committed.
+type resumePoint struct {
+	At time.Time `json:"at"`
+	ID int64     `json:"id,omitempty"`
+}
+
+// resumeState is the content of a resume token: per table, where each entity's committed rows end.
+type resumeState struct {
+	Tables map[string]map[string]resumePoint `json:"tables"`
+}
+
+// committedRows tracks what this run has written, seeded from --resume, so an interrupted run can
+// hand out a token covering both.
+var committedRows struct {
+	mu sync.Mutex
+	// resumed holds the --resume points; tables where the committed rows end per entity.
+	resumed map[string]map[string]resumePoint
+	tables  map[string]map[string]resumePoint
+	// abandoned is set when the rows committed no longer describe every destination; see
+	// fanout.go.
+	abandoned bool
+}
+
+// loadResumeToken reads --resume.
+func loadResumeToken() error {
+	if resumeFrom == "" {
+		return nil
+	}
+	token := strings.TrimSpace(resumeFrom)
+	if !strings.HasPrefix(token, resumeTokenPrefix) && !strings.HasPrefix(token, legacyResumeTokenPrefix) {
+		raw, err := os.ReadFile(token)
+		if err != nil {
+			return fmt.Errorf("--resume: %q is neither a resume token nor a readable file: %w", resumeFrom, err)
+		}
+		token = strings.TrimSpace(string(raw))
+	}
+	state, err := decodeResumeToken(token)
+	if err != nil {
+		return fmt.Errorf("--resume: %w", err)
+	}
+
+	committedRows.mu.Lock()
+	defer committedRows.mu.Unlock()
+	committedRows.resumed = state.Tables
+	committedRows.tables = make(map[string]map[string]resumePoint, len(state.Tables))
+	for table, entities := range state.Tables {
+		committedRows.tables[table] = make(map[string]resumePoint, len(entities))
+		for entityID, point := range entities {
+			committedRows.tables[table][entityID] = point
+		}
+	}
+	return nil
+}
+
+func decodeResumeToken(token string) (resumeState, error) {
+	var state resumeState
+	payload, legacy := strings.CutPrefix(token, legacyResumeTokenPrefix)
+	if !legacy {
+		var ok bool
+		if payload, ok = strings.CutPrefix(token, resumeTokenPrefix); !ok {
+			return state, fmt.Errorf("not a resume token (expected the %s prefix)", resumeTokenPrefix)
+		}
+	}
+	compressed, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return state, fmt.Errorf("decode resume token: %w", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return state, fmt.Errorf("decode resume token: %w", err)
+	}
+	if !legacy {
+		if err := json.NewDecoder(zr).Decode(&state); err != nil {
+			return state, fmt.Errorf("decode resume token: %w", err)
+		}
+		return state, nil
+	}
+
+	var times struct {
+		Tables map[string]map[string]time.Time `json:"tables"`
+	}
+	if err := json.NewDecoder(zr).Decode(&times); err != nil {
+		return state, fmt.Errorf("decode resume token: %w", err)
+	}
+	state.Tables = make(map[string]map[string]resumePoint, len(times.Tables))
+	for table, entities := range times.Tables {
+		state.Tables[table] = make(map[string]resumePoint, len(entities))
+		for entityID, at := range entities {
+			state.Tables[table][entityID] = resumePoint{At: at}
+		}
+	}
+	return state, nil
+}
+
+func encodeResumeToken(state resumeState) (string, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(state); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return resumeTokenPrefix + base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// loadWatermarks returns the table's watermarks from the sink, with the entities --resume covers
+// taken from the token instead, so a run resumes exactly where the interrupted one stopped even on
+// a replica that has not caught up.
+func loadWatermarks(ctx context.Context, sink Sink, table *tableSpec) (map[string]time.Time, error) {
+	watermarks, err := sink.LoadWatermarks(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	committedRows.mu.Lock()
+	defer committedRows.mu.Unlock()
+	for entityID, point := range committedRows.resumed[table.name] {
+		if watermarks == nil {
+			watermarks = make(map[string]time.Time)
+		}
+		watermarks[entityID] = point.At
+	}
+	return watermarks, nil
+}
+
+// recordCommittedRows notes where the rows the sink has accepted end per entity, keyed like the
+// sink's watermarks: the newest time and, for tables with an idColumn, the highest id at it.
+// Tables referencing an entities dimension are skipped: their watermarks are keyed by the
+// entity_id the dimension resolves to.
+func recordCommittedRows(table *tableSpec, rows [][]any) {
+	if table.timeColumn == "" || table.entityColumn == "" || table.entityTable != nil || table == rejectsTable {
+		return
+	}
+	columns := table.writeColumns()
+	entityAt, timeAt := slices.Index(columns, table.entityColumn), slices.Index(columns, table.timeColumn)
+	if entityAt < 0 || timeAt < 0 {
+		return
+	}
+	idAt := slices.Index(columns, table.idColumn)
+
+	committedRows.mu.Lock()
+	defer committedRows.mu.Unlock()
+	if committedRows.abandoned {
+		return
+	}
+	entities := committedRows.tables[table.name]
+	for _, values := range rows {
+		at, ok := rowTime(values[timeAt])
+		if !ok || values[entityAt] == nil {
+			continue
+		}
+		entityID, ok := rowString(values[entityAt])
+		if !ok {
+			entityID = fmt.Sprint(values[entityAt])
+		}
+		var id int64
+		if idAt >= 0 {
+			id, _ = rowInt64(values[idAt])
+		}
+		if entities == nil {
+			if committedRows.tables == nil {
+				committedRows.tables = make(map[string]map[string]resumePoint)
+			}
+			entities = make(map[string]resumePoint)
+			committedRows.tables[table.name] = entities
+		}
+		last, seen := entities[entityID]
+		switch {
+		case !seen || at.After(last.At):
+			entities[entityID] = resumePoint{At: at, ID: id}
+		case at.Equal(last.At) && id > last.ID:
+			entities[entityID] = resumePoint{At: last.At, ID: id}
+		}
+	}
+}
+
+// abandonResumeToken stops tracking committed rows: the run hands out no resume token.
+func abandonResumeToken() {
+	committedRows.mu.Lock()
+	defer committedRows.mu.Unlock()
+	committedRows.abandoned = true
+	committedRows.tables = nil
+}
+
+// reportResumeToken prints the token resuming an interrupted run and saves it to --resume-file.
+// Runs that committed nothing have nothing to resume.
+func reportResumeToken(w io.Writer) error {
+	committedRows.mu.Lock()
+	state := resumeState{Tables: committedRows.tables}
+	committedRows.mu.Unlock()
+	if len(state.Tables) == 0 {
+		return nil
+	}
+
+	token, err := encodeResumeToken(state)
+	if err != nil {
+		return fmt.Errorf("encode resume token: %w", err)
+	}
+	if resumeFile == "" {
+		fmt.Fprintf(w, "resume token: %s\n", token)
+		return nil
+	}
+	if err := os.WriteFile(resumeFile, []byte(token+"\n"), 0o600); err != nil {
+		fmt.Fprintf(w, "resume token: %s\n", token)
+		return fmt.Errorf("save resume token: %w", err)
+	}
+	fmt.Fprintf(w, "resume token (saved to %s; continue with --resume=%s): %s\n", resumeFile, resumeFile, token)
+	return nil
+}
@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The tests in this file run the daily rollups, which read the exported rows back from the
+// destination, against a SQLite database holding those rows; the rollup rows go to a memSink.
+
+// rollupSink is a memSink whose exported tables are read back from db.
+type rollupSink struct {
+	*memSink
+	db *sql.DB
+}
+
+func (s rollupSink) DB() *sql.DB { return s.db }
+
+// newRollupSink returns a sink whose DB holds the tables created by schema, and the store the
+// rollups are written to.
+func newRollupSink(t *testing.T, schema ...string) (rollupSink, *memStore) {
+	t.Helper()
+	_, store := newMemStore(t)
+	db := openFixture(t, filepath.Join(t.TempDir(), "destination.db"))
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("create destination table: %v", err)
+		}
+	}
+	return rollupSink{memSink: &memSink{store: store}, db: db}, store
+}
+
+// rollupRows returns the table's rows keyed by the values of the key columns, joined by "|".
+func rollupRows(t *testing.T, store *memStore, table string, key ...string) map[string]map[string]any {
+	t.Helper()
+	rows := make(map[string]map[string]any)
+	for _, row := range store.rows(table) {
+		k := ""
+		for i, column := range key {
+			if i > 0 {
+				k += "|"
+			}
+			k += row[column].(string)
+		}
+		rows[k] = row
+	}
+	return rows
+}
+
+// utcTime parses a UTC time written as 2006-01-02 15:04.
+func utcTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	at, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return at
+}
+
+func TestBatteryDailyGroupsByExportZoneDay(t *testing.T) {
+	useClock(t, "", "Europe/Berlin")
+	sink, store := newRollupSink(t, "CREATE TABLE battery_points (state_id INTEGER PRIMARY KEY, entity_id TEXT NOT NULL, battery_level REAL NOT NULL, last_updated DATETIME)")
+	ctx := context.Background()
+	for i, r := range []struct {
+		at    string
+		level float64
+	}{
+		{"2024-07-01 21:30", 50}, // 23:30 on 1 July in Berlin
+		{"2024-07-01 22:30", 40}, // 00:30 on 2 July
+		{"2024-07-02 10:00", 30},
+		{"2024-07-02 23:00", 60}, // 01:00 on 3 July
+	} {
+		if _, err := sink.db.Exec("INSERT INTO battery_points VALUES (?, 'sensor.phone_battery_level', ?, ?)", i+1, r.level, utcTime(t, r.at)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.EnsureSchema(ctx, batteryDailyTable); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(run string) {
+		t.Helper()
+		days := rollupRows(t, store, "battery_daily", "day")
+		want := map[string]struct {
+			min     float64
+			samples int64
+		}{"2024-07-01": {50, 1}, "2024-07-02": {30, 2}, "2024-07-03": {60, 1}}
+		if len(days) != len(want) {
+			t.Errorf("%s: battery_daily holds days %v, want %d", run, days, len(want))
+		}
+		for day, w := range want {
+			row, ok := days[day]
+			if !ok {
+				t.Errorf("%s: battery_daily has no row for %s", run, day)
+				continue
+			}
+			if row["min_level"] != w.min || row["samples"] != w.samples {
+				t.Errorf("%s: %s min_level = %v, samples = %v, want %v and %v", run, day, row["min_level"], row["samples"], w.min, w.samples)
+			}
+		}
+	}
+	if err := refreshBatteryDaily(ctx, sink, utcTime(t, "2024-07-01 00:00")); err != nil {
+		t.Fatal(err)
+	}
+	check("full refresh")
+	// An incremental run from midday on 2 July recomputes that whole local day, which started at
+	// 22:00 UTC on 1 July, and leaves 1 July alone.
+	if err := refreshBatteryDaily(ctx, sink, utcTime(t, "2024-07-02 10:00")); err != nil {
+		t.Fatal(err)
+	}
+	check("incremental refresh")
+}
+
+func TestAreaDailyGroupsByExportZoneDay(t *testing.T) {
+	useClock(t, "", "Europe/Berlin")
+	sink, store := newRollupSink(t,
+		"CREATE TABLE ha_entities (entity_id TEXT PRIMARY KEY, area_id TEXT)",
+		"CREATE TABLE energy_points (state_id INTEGER PRIMARY KEY, entity_id TEXT NOT NULL, numeric_state REAL, unit TEXT, last_updated DATETIME)",
+		"CREATE TABLE presence_points (entity_id TEXT NOT NULL, zone TEXT NOT NULL, arrived_at DATETIME NOT NULL, departed_at DATETIME, duration_seconds INTEGER)",
+	)
+	ctx := context.Background()
+	exec := func(query string, args ...any) {
+		t.Helper()
+		if _, err := sink.db.Exec(query, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exec("INSERT INTO ha_entities VALUES ('sensor.kitchen_energy', 'kitchen'), ('sensor.oven_energy', 'kitchen'), ('person.alex', 'hall')")
+	for _, r := range []struct {
+		entityID string
+		at       string
+		value    float64
+	}{
+		{"sensor.kitchen_energy", "2024-07-01 21:30", 10}, // 23:30 on 1 July in Berlin
+		{"sensor.kitchen_energy", "2024-07-01 22:30", 12}, // 00:30 on 2 July
+		{"sensor.kitchen_energy", "2024-07-02 10:00", 15},
+		{"sensor.oven_energy", "2024-07-01 23:00", 3}, // 01:00 on 2 July
+		{"sensor.oven_energy", "2024-07-02 12:00", 4},
+	} {
+		exec("INSERT INTO energy_points (entity_id, numeric_state, unit, last_updated) VALUES (?, ?, 'kWh', ?)", r.entityID, r.value, utcTime(t, r.at))
+	}
+	exec("INSERT INTO presence_points VALUES ('person.alex', 'home', ?, ?, 3600), ('person.alex', 'home', ?, NULL, NULL)",
+		utcTime(t, "2024-07-01 21:00"), utcTime(t, "2024-07-01 22:00"), utcTime(t, "2024-07-01 22:15"))
+	family := numericFamily{name: "energy"}
+
+	check := func(run string) {
+		t.Helper()
+		areas := rollupRows(t, store, "energy_area_daily", "area_id", "day")
+		if len(areas) != 2 {
+			t.Errorf("%s: energy_area_daily holds %d rows, want 2: %v", run, len(areas), areas)
+		}
+		if row := areas["kitchen|2024-07-01"]; row == nil || row["samples"] != int64(1) || row["increase"] != 0.0 {
+			t.Errorf("%s: kitchen on 1 July = %v, want 1 sample", run, row)
+		}
+		row := areas["kitchen|2024-07-02"]
+		if row == nil || row["entities"] != int64(2) || row["samples"] != int64(4) || row["min_value"] != 3.0 || row["max_value"] != 15.0 || row["increase"] != 4.0 || row["avg_value"] != 8.5 {
+			t.Errorf("%s: kitchen on 2 July = %v, want 2 entities, 4 samples from 3 to 15, increase 4, average 8.5", run, row)
+		}
+
+		stays := rollupRows(t, store, "presence_area_daily", "area_id", "day")
+		if row := stays["hall|2024-07-01"]; row == nil || row["stays"] != int64(1) || row["seconds"] != int64(3600) {
+			t.Errorf("%s: hall on 1 July = %v, want one stay of 3600 seconds", run, row)
+		}
+		if row := stays["hall|2024-07-02"]; row == nil || row["stays"] != int64(1) || row["seconds"] != int64(0) || row["entities"] != int64(1) {
+			t.Errorf("%s: hall on 2 July = %v, want one ongoing stay", run, row)
+		}
+	}
+	refresh := func(since string) {
+		t.Helper()
+		if err := refreshNumericAreaDaily(ctx, sink, family, utcTime(t, since)); err != nil {
+			t.Fatal(err)
+		}
+		if err := refreshPresenceAreaDaily(ctx, sink, utcTime(t, since)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	refresh("2024-07-01 00:00")
+	check("full refresh")
+	// Midday on 2 July: the whole local day, from 22:00 UTC on 1 July, is recomputed.
+	refresh("2024-07-02 10:00")
+	check("incremental refresh")
+}
+
+func TestEmissionsDailyGroupsByExportZoneDay(t *testing.T) {
+	useClock(t, "", "Europe/Berlin")
+	sink, store := newRollupSink(t,
+		"CREATE TABLE energy_points (state_id INTEGER PRIMARY KEY, entity_id TEXT NOT NULL, numeric_state REAL, unit TEXT, last_updated DATETIME)",
+		"CREATE TABLE carbon_intensity (provider TEXT, zone TEXT, start_time DATETIME, end_time DATETIME, intensity REAL, estimated BOOLEAN)",
+	)
+	ctx := context.Background()
+	exec := func(query string, args ...any) {
+		t.Helper()
+		if _, err := sink.db.Exec(query, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range []struct {
+		at    string
+		value float64
+	}{
+		{"2024-07-01 20:00", 100},
+		{"2024-07-01 21:30", 101}, // 23:30 on 1 July in Berlin
+		{"2024-07-01 22:30", 103}, // 00:30 on 2 July
+		{"2024-07-02 10:00", 106},
+	} {
+		exec("INSERT INTO energy_points (entity_id, numeric_state, unit, last_updated) VALUES ('sensor.house_energy', ?, 'kWh', ?)", r.value, utcTime(t, r.at))
+	}
+	// Intensity is known until midnight UTC only, so the reading at 10:00 is not covered.
+	exec("INSERT INTO carbon_intensity VALUES ('test', 'DE', ?, ?, 200, 0), ('test', 'DE', ?, ?, 300, 0)",
+		utcTime(t, "2024-07-01 20:00"), utcTime(t, "2024-07-01 22:00"), utcTime(t, "2024-07-01 22:00"), utcTime(t, "2024-07-02 00:00"))
+	family := numericFamily{name: "energy"}
+
+	check := func(run string) {
+		t.Helper()
+		days := rollupRows(t, store, "energy_emissions_daily", "day")
+		if len(days) != 2 {
+			t.Errorf("%s: energy_emissions_daily holds %d rows, want 2: %v", run, len(days), days)
+		}
+		if row := days["2024-07-01"]; row == nil || row["energy_kwh"] != 1.0 || row["emissions_g"] != 200.0 || row["intensity"] != 200.0 {
+			t.Errorf("%s: 1 July = %v, want 1 kWh at 200 g/kWh", run, row)
+		}
+		row := days["2024-07-02"]
+		if row == nil || row["energy_kwh"] != 5.0 || row["covered_kwh"] != 2.0 || row["emissions_g"] != 600.0 || row["intensity"] != 300.0 || row["zone"] != "DE" {
+			t.Errorf("%s: 2 July = %v, want 5 kWh of which 2 at 300 g/kWh in DE", run, row)
+		}
+	}
+	if err := refreshEmissionsDaily(ctx, sink, family, "DE", utcTime(t, "2024-07-01 00:00")); err != nil {
+		t.Fatal(err)
+	}
+	check("full refresh")
+	// Midday on 2 July: the whole local day, from 22:00 UTC on 1 July, is recomputed.
+	if err := refreshEmissionsDaily(ctx, sink, family, "DE", utcTime(t, "2024-07-02 10:00")); err != nil {
+		t.Fatal(err)
+	}
+	check("incremental refresh")
+}
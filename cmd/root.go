@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -13,12 +17,130 @@ var rootCmd = &cobra.Command{
 	Short: "CLI utilities for Home Assistant workflows",
 	Long: `ha-tools bundles helpful commands for interacting with Home Assistant
 and related automation tooling.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if configPath != "" {
+			cfg, err := loadConfig(configPath)
+			if err != nil {
+				return err
+			}
+			appConfig = cfg
+			alerts = newAlertEvaluator(cfg)
+			if alerts != nil {
+				if err := alerts.load(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := applyConnectionProfiles(cmd); err != nil {
+			return err
+		}
+
+		if err := resolveClock(); err != nil {
+			return err
+		}
+		d, err := resolveDialect(dialectName)
+		if err != nil {
+			return err
+		}
+		destDialect = d
+		if err := validateOnError(); err != nil {
+			return err
+		}
+		if err := validateWriteMode(); err != nil {
+			return err
+		}
+		if err := validateWriteQueue(); err != nil {
+			return err
+		}
+		if err := validatePoolFlags(); err != nil {
+			return err
+		}
+		if err := validateSessionFlags(); err != nil {
+			return err
+		}
+		if err := validateLeaseTTL(); err != nil {
+			return err
+		}
+		if err := validateDestTimeZone(); err != nil {
+			return err
+		}
+		if err := validateVerifyFlags(); err != nil {
+			return err
+		}
+		if err := validateTargetResolution(); err != nil {
+			return err
+		}
+		if err := validateSourceLimit(); err != nil {
+			return err
+		}
+		if err := validateRowBudget(); err != nil {
+			return err
+		}
+		if err := validateAttributesCache(); err != nil {
+			return err
+		}
+		if err := validateAlsoDests(); err != nil {
+			return err
+		}
+		if err := loadResumeToken(); err != nil {
+			return err
+		}
+		if err := validateCollation(); err != nil {
+			return err
+		}
+		cmd.SetContext(startRunTimeout(cmd.Context()))
+		return runPreSQLHooks(cmd.Context(), cmd)
+	},
 }
 
-// Execute runs the root command and propagates any failure to os.Exit.
+// Execute runs the root command and exits with the code describing its outcome (see summary.go).
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	started := time.Now()
+	// The first interrupt cancels the command so it can report how to resume; a second one kills
+	// it.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	cmd, err := rootCmd.ExecuteContextC(ctx)
+	stop()
+	runCancel()
+	if closeErr := closeTransforms(); err == nil {
+		err = closeErr
+	}
+	if fanoutErr := fanoutError(); err == nil {
+		err = fanoutErr
+	}
+	err = runPostSQLHooks(cmd, err)
+	if alertErr := finishAlerts(); alertErr != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", alertErr)
+	}
+	skipped := reportSkippedRows(os.Stderr)
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		if resumeErr := reportResumeToken(os.Stderr); resumeErr != nil {
+			fmt.Fprintln(os.Stderr, resumeErr)
+		}
+	}
+	code := exitCode(err, skipped)
+	if metricsPath != "" {
+		if metricsErr := writeRunMetrics(cmd.CommandPath()); metricsErr != nil {
+			fmt.Fprintln(os.Stderr, metricsErr)
+			if code == exitOK {
+				code = exitFailed
+			}
+		}
+	}
+	if summaryPath != "" {
+		if summaryErr := writeRunSummary(cmd.CommandPath(), started, code, err, skipped); summaryErr != nil {
+			fmt.Fprintln(os.Stderr, summaryErr)
+			if code == exitOK {
+				code = exitFailed
+			}
+		}
+	}
+	if code != exitOK {
+		os.Exit(code)
 	}
 }
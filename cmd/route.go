@@ -0,0 +1,431 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	routeSQLitePaths []string
+	routeMySQLDSN    string
+)
+
+// routeCmd exports several families of states in one pass over the recorder, as the config's
+// routes direct them.
+var routeCmd = &cobra.Command{
+	Use:   "route",
+	Short: "Export states to the tables the config's routes pick, in one pass over the recorder",
+	Long:  "Reads every state of the Home Assistant SQLite recorder database once and writes each to the table of the first matching rule in the config's routes section (by entity_id pattern and/or device_class), e.g. power sensors to energy_points, temperature sensors to climate_points, and trackers to gps_points. States no rule matches are skipped.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(routeSQLitePaths) == 0 {
+			return errors.New("sqlite database path is required")
+		}
+		if routeMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+		if len(appConfig.Routes) == 0 {
+			return errors.New("route needs a config (--config) with a routes section")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		return forEachRecorder(ctx, routeSQLitePaths, func(sqlitePath string) error {
+			return transferRoutedData(ctx, sqlitePath, routeMySQLDSN, appConfig.Routes)
+		})
+	},
+}
+
+func init() {
+	routeCmd.Flags().StringArrayVar(&routeSQLitePaths, "sqlite", nil, recorderFlagUsage)
+	routeCmd.Flags().StringVar(&routeMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	_ = routeCmd.MarkFlagRequired("sqlite")
+	_ = routeCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(routeCmd)
+}
+
+// routeRule is one entry of the config's routes list, e.g.
+// {"device_class": ["power", "energy"], "table": "energy_points"} or
+// {"entity": "device_tracker.*", "table": "gps_points"}. A state matches when it meets every
+// criterion set; the first matching rule wins.
+type routeRule struct {
+	// Entity is an entity_id or a glob such as sensor.*_power.
+	Entity string `json:"entity"`
+	// DeviceClass lists the device_class attributes the rule takes.
+	DeviceClass []string `json:"device_class"`
+	// Table is gps_points or a <name>_points table in the layout of energy_points.
+	Table string `json:"table"`
+	// MinuteAverage aggregates a numeric entity's samples per minute.
+	MinuteAverage bool `json:"minute_average"`
+	// Resolution aggregates a numeric entity's samples to buckets of this size, such as "5m", like
+	// --target-resolution does for every rule.
+	Resolution string `json:"resolution"`
+
+	// resolution is Resolution parsed by validate.
+	resolution time.Duration
+}
+
+var routeTablePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*_points$`)
+
+// routeReservedTables are *_points tables with layouts of their own that routes cannot fill.
+var routeReservedTables = map[string]bool{
+	batteryPointsTable.name:        true,
+	weatherPointsTable.name:        true,
+	presencePointsTable.name:       true,
+	latestPointsTable.name:         true,
+	"statistics_points":            true,
+	"statistics_short_term_points": true,
+}
+
+func (r *routeRule) validate() error {
+	if r.Entity == "" && len(r.DeviceClass) == 0 {
+		return errors.New("set entity and/or device_class")
+	}
+	if _, err := path.Match(r.Entity, ""); err != nil {
+		return fmt.Errorf("invalid entity pattern %q", r.Entity)
+	}
+	if r.Resolution != "" {
+		d, err := time.ParseDuration(r.Resolution)
+		if err != nil {
+			return fmt.Errorf("invalid resolution %q", r.Resolution)
+		}
+		if err := validateResolution("resolution", d); err != nil {
+			return err
+		}
+		r.resolution = d
+	}
+	switch {
+	case r.Table == gpsPointsTable.name:
+		if r.MinuteAverage {
+			return errors.New("minute_average applies to numeric tables, not gps_points")
+		}
+		if r.Resolution != "" {
+			return errors.New("resolution applies to numeric tables, not gps_points")
+		}
+	case !routeTablePattern.MatchString(r.Table):
+		return fmt.Errorf("table %q must be gps_points or a <name>_points table", r.Table)
+	case routeReservedTables[r.Table]:
+		return fmt.Errorf("table %s has its own exporter and cannot be routed to", r.Table)
+	}
+	return nil
+}
+
+// bucket returns the bucket the rule aggregates a numeric row to, or false when it is written as
+// recorded: the rule's resolution, else --target-resolution, else a minute for minute_average.
+func (r *routeRule) bucket(row numericRow) (time.Duration, bool) {
+	if !row.lastUpdated.Valid {
+		return 0, false
+	}
+	switch {
+	case r.resolution > 0:
+		return r.resolution, true
+	case targetResolution > 0:
+		return targetResolution, true
+	case r.MinuteAverage:
+		return time.Minute, true
+	default:
+		return 0, false
+	}
+}
+
+func (r *routeRule) matches(entityID string, meta stateMetadata) bool {
+	if r.Entity != "" {
+		if ok, _ := path.Match(r.Entity, entityID); !ok {
+			return false
+		}
+	}
+	if len(r.DeviceClass) > 0 {
+		return meta.DeviceClass.Valid && slices.Contains(r.DeviceClass, meta.DeviceClass.String)
+	}
+	return true
+}
+
+// deviceClassRouted reports whether a device_class rule could claim the entity, which makes its
+// unparsable attributes worth reporting.
+func deviceClassRouted(rules []*routeRule, entityID string) bool {
+	for _, r := range rules {
+		if len(r.DeviceClass) == 0 {
+			continue
+		}
+		if ok, _ := path.Match(r.Entity, entityID); r.Entity == "" || ok {
+			return true
+		}
+	}
+	return false
+}
+
+// routeTarget is one destination table of a routed export.
+type routeTarget struct {
+	table  *tableSpec
+	gps    bool
+	writer *batchWriter
+	// watermarks, ties, and aggregator serve numeric tables.
+	watermarks map[string]time.Time
+	ties       map[string]int64
+	aggregator *bucketAggregator
+}
+
+// transferRoutedData scans the recorder once and writes each state through the first matching
+// rule. Numeric tables resume from their watermarks like energy and climate-sensors; gps_points is
+// re-exported in full like the gps command.
+func transferRoutedData(ctx context.Context, sqlitePath, mysqlDSN string, rules []*routeRule) error {
+	sqliteDB, err := openRecorder(ctx, sqlitePath)
+	if err != nil {
+		return err
+	}
+	defer sqliteDB.Close()
+
+	sink, err := openExportSink(ctx, mysqlDSN)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	job, err := newRoutedJob(ctx, sink, rules)
+	if err != nil {
+		return err
+	}
+	if err := scanRecorderStates(ctx, sqliteDB, scanStart(job), func(st recorderState) error {
+		return job.handle(ctx, st)
+	}); err != nil {
+		return err
+	}
+	return job.finish(ctx)
+}
+
+// recorderState is one row of the recorder's states with its attributes.
+type recorderState struct {
+	stateID        int64
+	entityID       string
+	state          string
+	lastUpdatedVal sql.NullFloat64
+	attributesJSON string
+	// meta holds the parsed attributes; metaErr is set when they do not parse.
+	meta    stateMetadata
+	metaErr error
+}
+
+func (st recorderState) raw() rejectedRow {
+	return rejectedRow{st.stateID, st.entityID, st.state, st.lastUpdatedVal, st.attributesJSON}
+}
+
+// scanStart returns the watermarks a recorder scan serving the jobs may start each entity at: the
+// oldest it has among their tables. It returns nil, for a full scan, when a job writes gps_points,
+// which keeps no watermarks, or a table without any yet, which any entity may be routed to.
+func scanStart(jobs ...*routedJob) map[string]time.Time {
+	start := make(map[string]time.Time)
+	for _, job := range jobs {
+		for _, target := range job.order {
+			if target.gps || len(target.watermarks) == 0 {
+				return nil
+			}
+			for entityID, at := range target.watermarks {
+				if current, ok := start[entityID]; !ok || at.Before(current) {
+					start[entityID] = at
+				}
+			}
+		}
+	}
+	return start
+}
+
+// scanRecorderStates reads every state once, ordered per entity by time, and hands it to fn.
+// Entities in start are read from that time on (see incrementalPredicate); nil reads everything.
+func scanRecorderStates(ctx context.Context, sqliteDB *sql.DB, start map[string]time.Time, fn func(recorderState) error) error {
+	incremental, args, err := incrementalPredicate("sm.entity_id", "s.last_updated_ts", start)
+	if err != nil {
+		return err
+	}
+	query := `
+SELECT
+    s.state_id,
+    sm.entity_id,
+    s.state,
+    s.last_updated_ts,
+    COALESCE(sa.shared_attrs, ''),
+    s.attributes_id
+FROM states s
+JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+LEFT JOIN state_attributes sa ON s.attributes_id = sa.attributes_id
+WHERE ` + incremental + `
+ORDER BY sm.entity_id, s.last_updated_ts, s.state_id
+`
+
+	rows, err := sqliteDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("query sqlite database: %w", err)
+	}
+	defer rows.Close()
+
+	metadata := newMetadataCache()
+	for rows.Next() {
+		var (
+			st           recorderState
+			attributesID sql.NullInt64
+		)
+		if err := rows.Scan(&st.stateID, &st.entityID, &st.state, &st.lastUpdatedVal, &st.attributesJSON, &attributesID); err != nil {
+			return fmt.Errorf("scan sqlite row: %w", err)
+		}
+		st.meta, st.metaErr = metadata.metadata(attributesID, st.attributesJSON)
+		if err := fn(st); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate sqlite rows: %w", err)
+	}
+	return nil
+}
+
+// routedJob writes the states its rules match to one sink.
+type routedJob struct {
+	rules   []*routeRule
+	sink    Sink
+	targets map[string]*routeTarget
+	order   []*routeTarget
+}
+
+// newRoutedJob prepares the tables the rules write to and loads their watermarks.
+func newRoutedJob(ctx context.Context, sink Sink, rules []*routeRule) (*routedJob, error) {
+	const routeBatchSize = 500
+
+	job := &routedJob{rules: rules, sink: sink, targets: make(map[string]*routeTarget)}
+	for _, rule := range rules {
+		if _, ok := job.targets[rule.Table]; ok {
+			continue
+		}
+		target := &routeTarget{gps: rule.Table == gpsPointsTable.name}
+		if target.gps {
+			if err := checkCoordinateEncryption(ctx, sink, gpsPointsTable.name, false); err != nil {
+				return nil, err
+			}
+			target.table = gpsPointsTable
+		} else {
+			target.table = numericFamily{name: strings.TrimSuffix(rule.Table, "_points")}.pointsTable()
+		}
+		if err := sink.EnsureSchema(ctx, target.table); err != nil {
+			return nil, fmt.Errorf("ensure %s table: %w", target.table.name, err)
+		}
+		target.writer = newBatchWriter(sink, target.table, routeBatchSize)
+		if !target.gps {
+			var err error
+			if target.watermarks, err = loadWatermarks(ctx, sink, target.table); err != nil {
+				return nil, fmt.Errorf("load %s checkpoints: %w", target.table.name, err)
+			}
+			if target.ties, err = loadWatermarkTies(ctx, sink, target.table); err != nil {
+				return nil, fmt.Errorf("load %s checkpoints: %w", target.table.name, err)
+			}
+			writer, table := target.writer, target.table
+			target.aggregator = newBucketAggregator(func(row numericRow) error {
+				if row.samples > 1 {
+					mergedRows.add(table.name, row.samples-1)
+				}
+				return writer.Add(ctx, row.pointsValues()...)
+			})
+		}
+		job.targets[rule.Table] = target
+		job.order = append(job.order, target)
+	}
+	return job, nil
+}
+
+// handle writes the state through the first matching rule.
+func (j *routedJob) handle(ctx context.Context, st recorderState) error {
+	// Unparsable attributes have no device_class, so only entity rules can still claim the row.
+	var rule *routeRule
+	for _, r := range j.rules {
+		if r.matches(st.entityID, st.meta) {
+			rule = r
+			break
+		}
+	}
+	if rule == nil {
+		if st.metaErr != nil && deviceClassRouted(j.rules, st.entityID) {
+			return skipBadRow(st.entityID, st.stateID, fmt.Errorf("parse attributes for state_id %d: %w", st.stateID, st.metaErr))
+		}
+		return nil
+	}
+	target := j.targets[rule.Table]
+	if st.metaErr != nil {
+		return target.writer.Reject(ctx, st.raw(), fmt.Errorf("parse attributes for state_id %d: %w", st.stateID, st.metaErr))
+	}
+
+	lastUpdated, err := floatToNullTime(st.lastUpdatedVal)
+	if err != nil {
+		return target.writer.Reject(ctx, st.raw(), fmt.Errorf("convert last_updated_ts for state_id %d: %w", st.stateID, err))
+	}
+
+	if target.gps {
+		latitude, longitude, accuracy, err := extractCoordinates(st.attributesJSON)
+		if err != nil {
+			return target.writer.Reject(ctx, st.raw(), fmt.Errorf("parse attributes for state_id %d: %w", st.stateID, err))
+		}
+		observeAlerts(ctx, st.entityID, st.state, sql.NullFloat64{}, lastUpdated)
+		if !latitude.Valid || !longitude.Valid {
+			filteredRows.add(target.table.name, 1)
+			return nil
+		}
+		return target.writer.Add(ctx, st.stateID, st.entityID, st.state, latitude, longitude, accuracy, lastUpdated)
+	}
+
+	if lastUpdated.Valid && exportedBefore(target.watermarks, target.ties, st.entityID, lastUpdated.Time, st.stateID) {
+		exportedRows.add(target.table.name, 1)
+		return nil
+	}
+	numericState := parseNumericState(st.state)
+	observeAlerts(ctx, st.entityID, st.state, numericState, lastUpdated)
+	trimmedState := strings.TrimSpace(strings.ToLower(st.state))
+	if trimmedState == "unavailable" || trimmedState == "unknown" {
+		filteredRows.add(target.table.name, 1)
+		return nil
+	}
+	if !numericState.Valid {
+		filteredRows.add(target.table.name, 1)
+		return nil
+	}
+	row := numericRow{
+		stateID:      st.stateID,
+		entityID:     st.entityID,
+		state:        st.state,
+		numericState: numericState,
+		meta:         st.meta,
+		lastUpdated:  lastUpdated,
+	}
+	if resolution, ok := rule.bucket(row); ok {
+		return target.aggregator.Add(row, resolution, rowAggregation(row))
+	}
+	if err := target.aggregator.Flush(); err != nil {
+		return err
+	}
+	return target.writer.Add(ctx, row.pointsValues()...)
+}
+
+// finish writes what is still queued and runs the tables' post-export maintenance.
+func (j *routedJob) finish(ctx context.Context) error {
+	for _, target := range j.order {
+		if target.aggregator != nil {
+			if err := target.aggregator.Flush(); err != nil {
+				return err
+			}
+		}
+		if err := target.writer.Flush(ctx); err != nil {
+			return err
+		}
+		if err := finalizeTable(ctx, j.sink, target.table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
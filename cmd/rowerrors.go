@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	onErrorAbort   = "abort"
+	onErrorSkip    = "skip"
+	onErrorCollect = "collect"
+)
+
+// onError is the --on-error policy for recorder rows that cannot be converted; deadLetterPath is
+// where collect records them.
+var (
+	onError        = onErrorAbort
+	deadLetterPath = "ha-tools-dead-letter.jsonl"
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&onError, "on-error", onError, "What to do with a recorder row that cannot be converted (malformed attributes, bad timestamps): abort, skip (log and continue), or collect (skip and record it in --dead-letter)")
+	rootCmd.PersistentFlags().StringVar(&deadLetterPath, "dead-letter", deadLetterPath, "JSON-lines file --on-error=collect appends skipped rows to")
+}
+
+// deadLetter is one skipped row in the --dead-letter report.
+type deadLetter struct {
+	EntityID string `json:"entity_id"`
+	StateID  int64  `json:"state_id"`
+	Reason   string `json:"reason"`
+}
+
+// skippedRows counts the rows skipped by this run and owns the dead-letter file.
+var skippedRows struct {
+	mu    sync.Mutex
+	count int
+	file  *os.File
+	enc   *json.Encoder
+}
+
+func validateOnError() error {
+	switch onError {
+	case onErrorAbort, onErrorSkip, onErrorCollect:
+		return nil
+	}
+	return fmt.Errorf("unknown --on-error %q (supported: %s, %s, %s)", onError, onErrorAbort, onErrorSkip, onErrorCollect)
+}
+
+// skipBadRow applies --on-error to a row that failed with err: it returns err under abort and nil
+// when the caller should skip the row and carry on.
+func skipBadRow(entityID string, stateID int64, err error) error {
+	if onError == onErrorAbort {
+		return err
+	}
+
+	skippedRows.mu.Lock()
+	defer skippedRows.mu.Unlock()
+	skippedRows.count++
+	fmt.Fprintf(os.Stderr, "skipping %s state_id %d: %v\n", entityID, stateID, err)
+	if onError != onErrorCollect {
+		return nil
+	}
+
+	if skippedRows.file == nil {
+		f, openErr := os.OpenFile(deadLetterPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if openErr != nil {
+			return fmt.Errorf("open dead-letter report: %w", openErr)
+		}
+		skippedRows.file = f
+		skippedRows.enc = json.NewEncoder(f)
+	}
+	if writeErr := skippedRows.enc.Encode(deadLetter{EntityID: entityID, StateID: stateID, Reason: err.Error()}); writeErr != nil {
+		return fmt.Errorf("write dead-letter report: %w", writeErr)
+	}
+	return nil
+}
+
+// reportSkippedRows closes the dead-letter file and summarizes what was skipped, also after a
+// failed run. It returns the number of skipped rows.
+func reportSkippedRows(w io.Writer) int {
+	skippedRows.mu.Lock()
+	defer skippedRows.mu.Unlock()
+	if skippedRows.count == 0 {
+		return 0
+	}
+
+	where := ""
+	if skippedRows.file != nil {
+		if err := skippedRows.file.Close(); err != nil {
+			fmt.Fprintf(w, "close dead-letter report: %v\n", err)
+		}
+		skippedRows.file = nil
+		where = "; see " + deadLetterPath
+	}
+	fmt.Fprintf(w, "skipped %d row(s) that could not be converted%s\n", skippedRows.count, where)
+	return skippedRows.count
+}
+
+// rejectedRow is the raw recorder row of a rejected state, kept so replay can convert it again.
+type rejectedRow struct {
+	stateID       int64
+	entityID      string
+	state         string
+	lastUpdatedTS sql.NullFloat64
+	attributes    string
+}
+
+// rejectsTable stores the rows --on-error=collect skipped, keyed by the table they were meant for.
+var rejectsTable = &tableSpec{
+	name: "ha_tools_rejects",
+	columns: []columnSpec{
+		{name: "target_table", sqlType: "VARCHAR(64) NOT NULL"},
+		{name: "state_id", sqlType: "BIGINT NOT NULL"},
+		{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "state", sqlType: "TEXT NOT NULL"},
+		{name: "last_updated_ts", sqlType: "DOUBLE NULL"},
+		{name: "shared_attrs", sqlType: "MEDIUMTEXT NOT NULL"},
+		{name: "reason", sqlType: "TEXT NOT NULL"},
+		{name: "rejected_at", sqlType: "DATETIME NOT NULL"},
+	},
+	primaryKey: []string{"target_table", "state_id"},
+}
+
+// Reject applies --on-error to a row the exporter could not convert. Under collect the raw row is
+// also queued into ha_tools_rejects, written with the writer's batches, for `replay`.
+func (b *batchWriter) Reject(ctx context.Context, row rejectedRow, err error) error {
+	if err := skipBadRow(row.entityID, row.stateID, err); err != nil {
+		return err
+	}
+	if onError != onErrorCollect {
+		return nil
+	}
+
+	if b.rejects == nil {
+		if err := b.sink.EnsureSchema(ctx, rejectsTable); err != nil {
+			return fmt.Errorf("ensure %s table: %w", rejectsTable.name, err)
+		}
+		b.rejects = newBatchWriter(b.sink, rejectsTable, b.size)
+	}
+	return b.rejects.Add(ctx, b.table.name, row.stateID, row.entityID, row.state, row.lastUpdatedTS, row.attributes, err.Error(), time.Now().UTC())
+}
@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	runSQLitePaths []string
+	runJobNames    []string
+)
+
+// runCmd executes every job of the config over one shared scan of the recorder.
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run the config's jobs in one pass over the recorder",
+	Long:  "Reads every state of the Home Assistant SQLite recorder database once and hands it to each job of the config's jobs section. Every job routes states to its own destination, with its own sink, watermarks, and routes, so several exports cost a single read of the recorder. A failing job stops; the others carry on and the run reports its error at the end.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(runSQLitePaths) == 0 {
+			return errors.New("sqlite database path is required")
+		}
+		if len(appConfig.Jobs) == 0 {
+			return errors.New("run needs a config (--config) with a jobs section")
+		}
+		jobs, err := selectRunJobs(appConfig.Jobs, runJobNames)
+		if err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		failed := make(map[string]error)
+		err = forEachRecorder(ctx, runSQLitePaths, func(sqlitePath string) error {
+			return runJobs(ctx, sqlitePath, jobs, failed)
+		})
+		errs := []error{err}
+		for _, job := range jobs {
+			if jobErr, ok := failed[job.Name]; ok {
+				errs = append(errs, fmt.Errorf("job %s: %w", job.Name, jobErr))
+			}
+		}
+		return errors.Join(errs...)
+	},
+}
+
+func init() {
+	runCmd.Flags().StringArrayVar(&runSQLitePaths, "sqlite", nil, recorderFlagUsage)
+	runCmd.Flags().StringArrayVar(&runJobNames, "job", nil, "Only run the named job. Repeatable")
+	_ = runCmd.MarkFlagRequired("sqlite")
+
+	rootCmd.AddCommand(runCmd)
+}
+
+// runJob is one entry of the config's jobs list: routes written to one destination, e.g.
+// {"name": "archive", "dest": "nas", "routes": [...]}.
+type runJob struct {
+	Name string `json:"name"`
+	// Dest names a connection profile supplying the DSN and sink; DSN and Sink set them directly.
+	Dest string `json:"dest"`
+	// DSN is the sink target. It may be a secret reference; see secrets.go.
+	DSN  string `json:"dsn"`
+	Sink string `json:"sink"`
+	// Routes pick the job's tables like the top-level routes; see route.go.
+	Routes []*routeRule `json:"routes"`
+}
+
+// validateJobs checks every job and that no name is used twice.
+func (c *fileConfig) validateJobs() error {
+	names := make(map[string]bool)
+	for i, job := range c.Jobs {
+		if err := job.validate(c); err != nil {
+			return fmt.Errorf("jobs[%d]: %w", i, err)
+		}
+		if names[job.Name] {
+			return fmt.Errorf("jobs[%d]: name %q is used by another job", i, job.Name)
+		}
+		names[job.Name] = true
+	}
+	return nil
+}
+
+func (j *runJob) validate(c *fileConfig) error {
+	if j.Name == "" {
+		return errors.New("name is required")
+	}
+	if (j.Dest == "") == (j.DSN == "") {
+		return errors.New("set exactly one of dest and dsn")
+	}
+	if j.Dest != "" {
+		name, p, err := c.profile(j.Dest)
+		if err != nil {
+			return fmt.Errorf("dest: %w", err)
+		}
+		if p.DSN == "" {
+			return fmt.Errorf("dest: profile %s has no dsn", name)
+		}
+	}
+	if err := validateSecretRef(j.DSN); err != nil {
+		return fmt.Errorf("dsn: %w", err)
+	}
+	if j.Sink != "" {
+		if _, ok := sinkFactories[j.Sink]; !ok {
+			return fmt.Errorf("unknown sink %q (registered: %s)", j.Sink, strings.Join(sinkNames(), ", "))
+		}
+	}
+	if len(j.Routes) == 0 {
+		return errors.New("routes is required")
+	}
+	for i, rule := range j.Routes {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("routes[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// destination resolves the job's sink and target. A profile's dialect must match the run's, since
+// all jobs share the --dialect in effect.
+func (j *runJob) destination(ctx context.Context) (string, string, error) {
+	sink, dsn := j.Sink, j.DSN
+	if j.Dest != "" {
+		name, p, err := appConfig.profile(j.Dest)
+		if err != nil {
+			return "", "", err
+		}
+		if p.Dialect != "" && p.Dialect != destDialect.name {
+			return "", "", fmt.Errorf("profile %s uses the %s dialect, but the run uses %s; run the job on its own with --dialect=%s", name, p.Dialect, destDialect.name, p.Dialect)
+		}
+		if sink == "" {
+			sink = p.Sink
+		}
+		if dsn, err = p.destinationDSN(ctx, name); err != nil {
+			return "", "", fmt.Errorf("profile %s: %w", name, err)
+		}
+	} else {
+		var err error
+		if dsn, err = resolveSecret(ctx, dsn); err != nil {
+			return "", "", fmt.Errorf("dsn: %w", err)
+		}
+	}
+	if sink == "" {
+		sink = sinkName
+	}
+	return sink, dsn, nil
+}
+
+// selectRunJobs returns the jobs named by --job, or all of them.
+func selectRunJobs(jobs []*runJob, names []string) ([]*runJob, error) {
+	if len(names) == 0 {
+		return jobs, nil
+	}
+	var selected []*runJob
+	for _, name := range names {
+		i := slices.IndexFunc(jobs, func(j *runJob) bool { return j.Name == name })
+		if i < 0 {
+			defined := make([]string, len(jobs))
+			for k, j := range jobs {
+				defined[k] = j.Name
+			}
+			return nil, fmt.Errorf("unknown job %q (defined: %s)", name, strings.Join(defined, ", "))
+		}
+		if !slices.Contains(selected, jobs[i]) {
+			selected = append(selected, jobs[i])
+		}
+	}
+	return selected, nil
+}
+
+// runJobs scans the recorder once for every job that has not failed yet. A job's error is recorded
+// in failed and drops it from the rest of the run; only a failure to read the recorder stops the
+// others.
+func runJobs(ctx context.Context, sqlitePath string, jobs []*runJob, failed map[string]error) error {
+	sqliteDB, err := openRecorder(ctx, sqlitePath)
+	if err != nil {
+		return err
+	}
+	defer sqliteDB.Close()
+
+	fail := func(job *runJob, err error) {
+		fmt.Fprintf(os.Stderr, "job %s failed: %v\n", job.Name, err)
+		failed[job.Name] = err
+	}
+
+	type activeJob struct {
+		job    *runJob
+		routed *routedJob
+	}
+	var active []*activeJob
+	for _, job := range jobs {
+		if _, ok := failed[job.Name]; ok {
+			continue
+		}
+		name, target, err := job.destination(ctx)
+		if err != nil {
+			fail(job, err)
+			continue
+		}
+		if err := checkPartialRead(name); err != nil {
+			fail(job, err)
+			continue
+		}
+		sink, err := openSink(ctx, name, target)
+		if err != nil {
+			fail(job, err)
+			continue
+		}
+		defer sink.Close()
+		routed, err := newRoutedJob(ctx, sink, job.Routes)
+		if err != nil {
+			fail(job, err)
+			continue
+		}
+		active = append(active, &activeJob{job: job, routed: routed})
+	}
+	if len(active) == 0 {
+		return nil
+	}
+
+	errAllFailed := errors.New("every job failed")
+	routed := make([]*routedJob, len(active))
+	for i, a := range active {
+		routed[i] = a.routed
+	}
+	err = scanRecorderStates(ctx, sqliteDB, scanStart(routed...), func(st recorderState) error {
+		for i := 0; i < len(active); {
+			if err := active[i].routed.handle(ctx, st); err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				fail(active[i].job, err)
+				active = slices.Delete(active, i, i+1)
+				continue
+			}
+			i++
+		}
+		if len(active) == 0 {
+			return errAllFailed
+		}
+		return nil
+	})
+	if errors.Is(err, errAllFailed) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, a := range active {
+		if err := a.routed.finish(ctx); err != nil {
+			fail(a.job, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Secret references may stand in for credentials in the config (profile dsn and password,
+// home_assistant.token). Anything without one of these prefixes is used literally.
+const (
+	// secretEnvPrefix reads an environment variable: env:MYSQL_PASSWORD.
+	secretEnvPrefix = "env:"
+	// secretFilePrefix reads a file, trimming the trailing newline: file:/run/secrets/mysql.
+	secretFilePrefix = "file:"
+	// secretVaultPrefix reads a HashiCorp Vault KV field: vault:<mount>/<path>#<field>.
+	secretVaultPrefix = "vault:"
+	// secretAWSPrefix reads an AWS Secrets Manager secret, or one key of a JSON secret:
+	// aws-sm:<secret id or ARN>[#<key>].
+	secretAWSPrefix = "aws-sm:"
+	// secretOnePasswordPrefix reads a 1Password secret reference through the op CLI:
+	// op://<vault>/<item>/<field>.
+	secretOnePasswordPrefix = "op://"
+)
+
+// secretCache memoizes resolved references for the process, so every secret is fetched once per
+// run however many connections use it.
+var secretCache struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+var secretClient = &http.Client{Timeout: 10 * time.Second}
+
+// validateSecretRef checks the syntax of a reference without fetching it.
+func validateSecretRef(value string) error {
+	switch {
+	case strings.HasPrefix(value, secretEnvPrefix):
+		if strings.TrimPrefix(value, secretEnvPrefix) == "" {
+			return errors.New("env: needs a variable name")
+		}
+	case strings.HasPrefix(value, secretFilePrefix):
+		if strings.TrimPrefix(value, secretFilePrefix) == "" {
+			return errors.New("file: needs a path")
+		}
+	case strings.HasPrefix(value, secretVaultPrefix):
+		path, field, ok := strings.Cut(strings.TrimPrefix(value, secretVaultPrefix), "#")
+		if !ok || field == "" || !strings.Contains(strings.Trim(path, "/"), "/") {
+			return fmt.Errorf("%q must look like vault:<mount>/<path>#<field>", value)
+		}
+	case strings.HasPrefix(value, secretAWSPrefix):
+		if id, _, _ := strings.Cut(strings.TrimPrefix(value, secretAWSPrefix), "#"); id == "" {
+			return fmt.Errorf("%q must look like aws-sm:<secret id>[#<key>]", value)
+		}
+	}
+	return nil
+}
+
+// resolveSecret returns the value a config string stands for.
+func resolveSecret(ctx context.Context, value string) (string, error) {
+	fetch := secretFetcher(value)
+	if fetch == nil {
+		return value, nil
+	}
+
+	secretCache.mu.Lock()
+	defer secretCache.mu.Unlock()
+	if v, ok := secretCache.values[value]; ok {
+		return v, nil
+	}
+	v, err := fetch(ctx)
+	if err != nil {
+		return "", fmt.Errorf("resolve secret %s: %w", value, err)
+	}
+	if secretCache.values == nil {
+		secretCache.values = make(map[string]string)
+	}
+	secretCache.values[value] = v
+	return v, nil
+}
+
+// secretFetcher returns the function fetching a reference, or nil for literal values.
+func secretFetcher(value string) func(ctx context.Context) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretEnvPrefix):
+		return func(ctx context.Context) (string, error) {
+			name := strings.TrimPrefix(value, secretEnvPrefix)
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				return "", fmt.Errorf("$%s is not set", name)
+			}
+			return v, nil
+		}
+	case strings.HasPrefix(value, secretFilePrefix):
+		return func(ctx context.Context) (string, error) {
+			raw, err := os.ReadFile(strings.TrimPrefix(value, secretFilePrefix))
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimRight(string(raw), "\r\n"), nil
+		}
+	case strings.HasPrefix(value, secretVaultPrefix):
+		return func(ctx context.Context) (string, error) {
+			return readVaultSecret(ctx, strings.TrimPrefix(value, secretVaultPrefix))
+		}
+	case strings.HasPrefix(value, secretAWSPrefix):
+		return func(ctx context.Context) (string, error) {
+			return readAWSSecret(ctx, strings.TrimPrefix(value, secretAWSPrefix))
+		}
+	case strings.HasPrefix(value, secretOnePasswordPrefix):
+		return func(ctx context.Context) (string, error) {
+			out, err := exec.CommandContext(ctx, "op", "read", "--no-newline", value).Output()
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				return "", fmt.Errorf("op read: %s", strings.TrimSpace(string(exitErr.Stderr)))
+			}
+			if err != nil {
+				return "", fmt.Errorf("op read: %w", err)
+			}
+			return string(out), nil
+		}
+	}
+	return nil
+}
+
+// readVaultSecret reads <mount>/<path>#<field> from Vault at $VAULT_ADDR with $VAULT_TOKEN (or
+// ~/.vault-token), trying the KV version 2 layout first and version 1 second.
+func readVaultSecret(ctx context.Context, ref string) (string, error) {
+	path, field, _ := strings.Cut(ref, "#")
+	mount, rest, _ := strings.Cut(strings.Trim(path, "/"), "/")
+
+	addr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return "", errors.New("$VAULT_ADDR is not set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if raw, err := os.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+				token = strings.TrimSpace(string(raw))
+			}
+		}
+	}
+	if token == "" {
+		return "", errors.New("$VAULT_TOKEN is not set and ~/.vault-token is missing")
+	}
+
+	get := func(url string) (map[string]any, int, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		req.Header.Set("X-Vault-Token", token)
+		if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+			req.Header.Set("X-Vault-Namespace", ns)
+		}
+		resp, err := secretClient.Do(req)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			return nil, resp.StatusCode, fmt.Errorf("vault returned HTTP %d", resp.StatusCode)
+		}
+		var body struct {
+			Data map[string]any `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return nil, resp.StatusCode, fmt.Errorf("decode vault response: %w", err)
+		}
+		return body.Data, resp.StatusCode, nil
+	}
+
+	data, status, err := get(addr + "/v1/" + mount + "/data/" + rest)
+	if err == nil {
+		data, _ = data["data"].(map[string]any)
+	} else if status == http.StatusNotFound {
+		data, _, err = get(addr + "/v1/" + mount + "/" + rest)
+	}
+	if err != nil {
+		return "", err
+	}
+	v, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no string field %q", path, field)
+	}
+	return v, nil
+}
+
+// readAWSSecret reads <secret id>[#<key>] from AWS Secrets Manager with the credentials and region
+// in the standard AWS_* environment variables (or the region in an ARN). Credentials are only read
+// from the environment: shared config profiles, SSO, and instance or task roles are not supported.
+func readAWSSecret(ctx context.Context, ref string) (string, error) {
+	id, key, _ := strings.Cut(ref, "#")
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if parts := strings.Split(id, ":"); len(parts) > 3 && parts[0] == "arn" {
+		region = parts[3]
+	}
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	switch {
+	case region == "":
+		return "", errors.New("$AWS_REGION is not set")
+	case accessKey == "" || secretKey == "":
+		return "", errors.New("$AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY must be set (profiles, SSO, and instance roles are not read)")
+	}
+
+	payload, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return "", err
+	}
+	host := "secretsmanager." + region + ".amazonaws.com"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+host+"/", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	headers := map[string]string{
+		"content-type": "application/x-amz-json-1.1",
+		"host":         host,
+		"x-amz-date":   time.Now().UTC().Format("20060102T150405Z"),
+		"x-amz-target": "secretsmanager.GetSecretValue",
+	}
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		headers["x-amz-security-token"] = token
+	}
+	for name, value := range headers {
+		if name != "host" {
+			req.Header.Set(name, value)
+		}
+	}
+	req.Header.Set("Authorization", signAWSRequest(headers, payload, region, "secretsmanager", accessKey, secretKey))
+
+	resp, err := secretClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var awsErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(body, &awsErr)
+		return "", fmt.Errorf("secrets manager returned HTTP %d: %s %s", resp.StatusCode, awsErr.Type, awsErr.Message)
+	}
+	var secret struct {
+		SecretString *string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", fmt.Errorf("decode secrets manager response: %w", err)
+	}
+	if secret.SecretString == nil {
+		return "", fmt.Errorf("secret %s is binary; store the value as a string", id)
+	}
+	if key == "" {
+		return *secret.SecretString, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(*secret.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object, so #%s cannot be read: %w", id, key, err)
+	}
+	v, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s has no string key %q", id, key)
+	}
+	return v, nil
+}
+
+// signAWSRequest returns the Signature Version 4 Authorization header for a POST to / whose
+// headers (lower-case names, including host and x-amz-date) are all signed.
+func signAWSRequest(headers map[string]string, payload []byte, region, service, accessKey, secretKey string) string {
+	names := sortedKeys(headers)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{
+		http.MethodPost, "/", "", canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	amzDate := headers["x-amz-date"]
+	day := amzDate[:8]
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	hmacSHA256 := func(key []byte, data string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(data))
+		return mac.Sum(nil)
+	}
+	signingKey := hmacSHA256([]byte("AWS4"+secretKey), day)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	return "AWS4-HMAC-SHA256 Credential=" + accessKey + "/" + scope + ", SignedHeaders=" + signedHeaders + ", Signature=" + signature
+}
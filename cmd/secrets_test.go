@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestSignAWSRequest checks signAWSRequest against the POST cases of AWS's Signature Version 4 test
+// suite (aws-sig-v4-test-suite), which all sign with these credentials, scope, and date.
+func TestSignAWSRequest(t *testing.T) {
+	const (
+		accessKey = "AKIDEXAMPLE"
+		secretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+		amzDate   = "20150830T123600Z"
+		host      = "example.amazonaws.com"
+		scope     = "AKIDEXAMPLE/20150830/us-east-1/service/aws4_request"
+	)
+	tests := []struct {
+		name          string
+		headers       map[string]string
+		payload       string
+		signedHeaders string
+		signature     string
+	}{
+		{
+			name:          "post-vanilla",
+			headers:       map[string]string{"host": host, "x-amz-date": amzDate},
+			signedHeaders: "host;x-amz-date",
+			signature:     "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:          "post-x-www-form-urlencoded",
+			headers:       map[string]string{"content-type": "application/x-www-form-urlencoded", "host": host, "x-amz-date": amzDate},
+			payload:       "Param1=value1",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+		{
+			name:          "post-header-key-sort",
+			headers:       map[string]string{"x-amz-date": amzDate, "my-header1": "value1", "host": host},
+			signedHeaders: "host;my-header1;x-amz-date",
+			signature:     "c5410059b04c1ee005303aed430f6e6645f61f4dc9e1461ec8f8916fdf18852c",
+		},
+		{
+			name:          "post-header-value-case",
+			headers:       map[string]string{"host": host, "my-header1": "VALUE1", "x-amz-date": amzDate},
+			signedHeaders: "host;my-header1;x-amz-date",
+			signature:     "cdbc9802e29d2942e5e10b5bccfdd67c5f22c7c4e8ae67b53629efa58b974b7d",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var payload []byte
+			if tt.payload != "" {
+				payload = []byte(tt.payload)
+			}
+			got := signAWSRequest(tt.headers, payload, "us-east-1", "service", accessKey, secretKey)
+			want := "AWS4-HMAC-SHA256 Credential=" + scope + ", SignedHeaders=" + tt.signedHeaders + ", Signature=" + tt.signature
+			if got != want {
+				t.Errorf("signAWSRequest() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+// roundTripFunc serves a test's requests without a network.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestReadAWSSecretUsesEnvironmentCredentials(t *testing.T) {
+	saved := secretClient
+	t.Cleanup(func() { secretClient = saved })
+	var seen *http.Request
+	secretClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		seen = req
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"SecretString":"{\"password\":\"s3cret\"}"}`)),
+			Header:     make(http.Header),
+		}, nil
+	})}
+
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	// A profile alone is not enough: only the credential variables are read.
+	t.Setenv("AWS_PROFILE", "default")
+	if _, err := readAWSSecret(context.Background(), "arn:aws:secretsmanager:eu-central-1:123456789012:secret:ha-tools#password"); err == nil || !strings.Contains(err.Error(), "AWS_ACCESS_KEY_ID") {
+		t.Fatalf("readAWSSecret() without credential variables = %v, want an error naming them", err)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	t.Setenv("AWS_SESSION_TOKEN", "session-token")
+	got, err := readAWSSecret(context.Background(), "arn:aws:secretsmanager:eu-central-1:123456789012:secret:ha-tools#password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "s3cret" {
+		t.Errorf("readAWSSecret() = %q, want s3cret", got)
+	}
+	if want := "https://secretsmanager.eu-central-1.amazonaws.com/"; seen.URL.String() != want {
+		t.Errorf("request went to %s, want %s", seen.URL, want)
+	}
+	if got := seen.Header.Get("X-Amz-Security-Token"); got != "session-token" {
+		t.Errorf("x-amz-security-token = %q, want the session token", got)
+	}
+	auth := seen.Header.Get("Authorization")
+	for _, part := range []string{
+		"Credential=AKIDEXAMPLE/",
+		"/eu-central-1/secretsmanager/aws4_request",
+		"SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target,",
+	} {
+		if !strings.Contains(auth, part) {
+			t.Errorf("Authorization %q does not contain %q", auth, part)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	serviceName     string
+	serviceSchedule string
+	serviceJobs     []string
+	serviceUser     string
+	serviceUnitDir  string
+	serviceCron     bool
+	serviceCronDir  string
+	serviceDryRun   bool
+	serviceForce    bool
+)
+
+// installServiceCmd schedules sync jobs with systemd (or cron) on the machine running ha-tools.
+var installServiceCmd = &cobra.Command{
+	Use:   "install-service",
+	Short: "Install a systemd timer (or cron entry) running sync jobs on a schedule",
+	Long:  "Writes a oneshot systemd service running each --job with this ha-tools binary, plus a timer firing it on --schedule (a systemd OnCalendar expression). With --cron, writes an /etc/cron.d entry instead, with --schedule as a cron expression.",
+	Example: `  ha-tools install-service --schedule '*-*-* *:0/15:00' \
+    --job "energy --sqlite=/config/home-assistant_v2.db --dsn='user:pass@tcp(db:3306)/ha' --entity=dryer" \
+    --job "gps --sqlite=/config/home-assistant_v2.db --dsn='user:pass@tcp(db:3306)/ha'"`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if serviceSchedule == "" || strings.ContainsAny(serviceSchedule, "\r\n") {
+			return errors.New("--schedule is required and must be a single line")
+		}
+		if len(serviceJobs) == 0 {
+			return errors.New("at least one --job is required")
+		}
+		for _, job := range serviceJobs {
+			if strings.TrimSpace(job) == "" || strings.ContainsAny(job, "\r\n") {
+				return fmt.Errorf("invalid --job %q: it must be a single non-empty line", job)
+			}
+		}
+		if serviceCron && len(strings.Fields(serviceSchedule)) != 5 {
+			return fmt.Errorf("--cron needs a five-field cron schedule, got %q", serviceSchedule)
+		}
+
+		binary, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("locate ha-tools binary: %w", err)
+		}
+		commands, err := serviceCommands(cmd, binary)
+		if err != nil {
+			return err
+		}
+
+		var files []serviceFile
+		if serviceCron {
+			files = []serviceFile{{path: filepath.Join(serviceCronDir, serviceName), content: cronEntry(commands)}}
+		} else {
+			files = []serviceFile{
+				{path: filepath.Join(serviceUnitDir, serviceName+".service"), content: systemdService(commands)},
+				{path: filepath.Join(serviceUnitDir, serviceName+".timer"), content: systemdTimer()},
+			}
+		}
+
+		return installServiceFiles(cmd.OutOrStdout(), files)
+	},
+}
+
+func init() {
+	installServiceCmd.Flags().StringVar(&serviceName, "name", "ha-tools-sync", "Unit (or cron file) name")
+	installServiceCmd.Flags().StringVar(&serviceSchedule, "schedule", "", "When to run: a systemd OnCalendar expression, or a cron expression with --cron")
+	installServiceCmd.Flags().StringArrayVar(&serviceJobs, "job", nil, "ha-tools arguments of one sync job, e.g. \"energy --sqlite=... --dsn=... --entity=...\" (repeatable; run in order)")
+	installServiceCmd.Flags().StringVar(&serviceUser, "user", "", "User the jobs run as (default: root)")
+	installServiceCmd.Flags().StringVar(&serviceUnitDir, "unit-dir", "/etc/systemd/system", "Directory the systemd units are written to")
+	installServiceCmd.Flags().BoolVar(&serviceCron, "cron", false, "Write a cron entry instead of systemd units")
+	installServiceCmd.Flags().StringVar(&serviceCronDir, "cron-dir", "/etc/cron.d", "Directory the cron entry is written to")
+	installServiceCmd.Flags().BoolVar(&serviceDryRun, "dry-run", false, "Print the files instead of writing them")
+	installServiceCmd.Flags().BoolVar(&serviceForce, "force", false, "Overwrite existing files")
+	_ = installServiceCmd.MarkFlagRequired("schedule")
+
+	rootCmd.AddCommand(installServiceCmd)
+}
+
+type serviceFile struct {
+	path    string
+	content string
+}
+
+// serviceCommands returns the command line of every job. Global flags in effect now (--config,
+// --dialect, --sink, ...) are carried over so scheduled runs behave like this invocation.
+func serviceCommands(cmd *cobra.Command, binary string) ([]string, error) {
+	var global []string
+	if configPath != "" {
+		abs, err := filepath.Abs(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("resolve --config: %w", err)
+		}
+		global = append(global, "--config="+shellQuote(abs))
+	}
+	for _, arg := range inheritedFlagArgs(cmd) {
+		name, value, _ := strings.Cut(arg, "=")
+		if name != "--config" {
+			global = append(global, name+"="+shellQuote(value))
+		}
+	}
+
+	commands := make([]string, len(serviceJobs))
+	for i, job := range serviceJobs {
+		parts := append([]string{shellQuote(binary), strings.TrimSpace(job)}, global...)
+		commands[i] = strings.Join(parts, " ")
+	}
+	return commands, nil
+}
+
+func systemdService(commands []string) string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=ha-tools sync jobs\n")
+	b.WriteString("Wants=network-online.target\n")
+	b.WriteString("After=network-online.target\n\n")
+	b.WriteString("[Service]\n")
+	b.WriteString("Type=oneshot\n")
+	if serviceUser != "" {
+		b.WriteString("User=" + serviceUser + "\n")
+	}
+	for _, command := range commands {
+		// systemd has no shell; escape % specifiers and keep the quoting, which it understands.
+		b.WriteString("ExecStart=" + strings.ReplaceAll(command, "%", "%%") + "\n")
+	}
+	return b.String()
+}
+
+func systemdTimer() string {
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=Run " + serviceName + ".service on a schedule\n\n")
+	b.WriteString("[Timer]\n")
+	b.WriteString("OnCalendar=" + serviceSchedule + "\n")
+	b.WriteString("Persistent=true\n\n")
+	b.WriteString("[Install]\n")
+	b.WriteString("WantedBy=timers.target\n")
+	return b.String()
+}
+
+// cronEntry runs the jobs sequentially, stopping at the first failure like the systemd service.
+func cronEntry(commands []string) string {
+	user := serviceUser
+	if user == "" {
+		user = "root"
+	}
+	// cron treats unescaped % as a newline.
+	line := strings.ReplaceAll(strings.Join(commands, " && "), "%", "\\%")
+	return fmt.Sprintf("# Installed by ha-tools install-service\n%s %s %s\n", serviceSchedule, user, line)
+}
+
+func installServiceFiles(out io.Writer, files []serviceFile) error {
+	if serviceDryRun {
+		for _, f := range files {
+			fmt.Fprintf(out, "# %s\n%s\n", f.path, f.content)
+		}
+		return nil
+	}
+
+	if !serviceForce {
+		for _, f := range files {
+			if _, err := os.Stat(f.path); err == nil {
+				return fmt.Errorf("%s already exists; pass --force to overwrite it", f.path)
+			}
+		}
+	}
+	for _, f := range files {
+		if err := os.WriteFile(f.path, []byte(f.content), 0o644); err != nil {
+			return fmt.Errorf("write %s: %w", f.path, err)
+		}
+		fmt.Fprintf(out, "wrote %s\n", f.path)
+	}
+
+	if serviceCron {
+		fmt.Fprintln(out, "cron picks up the entry automatically.")
+	} else {
+		fmt.Fprintf(out, "Enable it with: systemctl daemon-reload && systemctl enable --now %s.timer\n", serviceName)
+	}
+	return nil
+}
+
+// shellQuote single-quotes s unless it only holds characters that need no quoting.
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:@,+", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
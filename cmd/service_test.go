@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+// useServiceJobs runs the test with --job set to jobs and no --config.
+func useServiceJobs(t *testing.T, jobs ...string) {
+	t.Helper()
+	savedJobs, savedConfig := serviceJobs, configPath
+	t.Cleanup(func() { serviceJobs, configPath = savedJobs, savedConfig })
+	serviceJobs, configPath = jobs, ""
+}
+
+func TestServiceCommandsCarryGlobalFlags(t *testing.T) {
+	useServiceJobs(t, "energy --sqlite=/x.db --dsn=d --entity=dryer")
+	sub := newFlagTree(t, "--dialect=tidb", "--time-zone", "Europe/Berlin")
+
+	got, err := serviceCommands(sub, "/usr/bin/ha-tools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/usr/bin/ha-tools energy --sqlite=/x.db --dsn=d --entity=dryer --dialect=tidb --time-zone=Europe/Berlin"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("serviceCommands = %q, want %q", got, want)
+	}
+}
+
+func TestRepeatableHookFlagsArePassedPerValue(t *testing.T) {
+	useServiceJobs(t, "gps")
+	sub := newFlagTree(t, "--pre-sql=a.sql", "--pre-sql=b c.sql", "--post-sql=d.sql")
+
+	global := inheritedFlagArgs(sub)
+	if want := []string{"--post-sql=d.sql", "--pre-sql=a.sql", "--pre-sql=b c.sql"}; !reflect.DeepEqual(global, want) {
+		t.Errorf("watch job flags = %q, want %q", global, want)
+	}
+	got, err := serviceCommands(sub, "/usr/bin/ha-tools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/usr/bin/ha-tools gps --post-sql=d.sql --pre-sql=a.sql --pre-sql='b c.sql'"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("serviceCommands = %q, want %q", got, want)
+	}
+}
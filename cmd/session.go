@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// lockWaitTimeout, isolationLevel, and tidbTxnMode are the --mysql-lock-wait-timeout,
+// --mysql-isolation-level, and --tidb-txn-mode flags: session variables every destination
+// connection starts with.
+var (
+	lockWaitTimeout time.Duration
+	isolationLevel  string
+	tidbTxnMode     string
+)
+
+// isolationLevels maps the --mysql-isolation-level values to transaction_isolation's.
+var isolationLevels = map[string]string{
+	"read-uncommitted": "READ-UNCOMMITTED",
+	"read-committed":   "READ-COMMITTED",
+	"repeatable-read":  "REPEATABLE-READ",
+	"serializable":     "SERIALIZABLE",
+}
+
+func init() {
+	rootCmd.PersistentFlags().DurationVar(&lockWaitTimeout, "mysql-lock-wait-timeout", 0, "innodb_lock_wait_timeout of destination sessions, in whole seconds (e.g. 10s): how long a write waits for row locks readers or other writers hold before its batch fails (0 = server default)")
+	rootCmd.PersistentFlags().StringVar(&isolationLevel, "mysql-isolation-level", "", "transaction_isolation of destination sessions: read-uncommitted, read-committed, repeatable-read, or serializable (default: the server's); read-committed takes no gap locks, so upserts deadlock less with concurrent readers")
+	rootCmd.PersistentFlags().StringVar(&tidbTxnMode, "tidb-txn-mode", "", "tidb_txn_mode of destination sessions on TiDB: pessimistic (wait for locks) or optimistic (fail the batch on a write conflict at commit) (default: the server's)")
+}
+
+func validateSessionFlags() error {
+	if lockWaitTimeout < 0 {
+		return fmt.Errorf("--mysql-lock-wait-timeout must not be negative, got %s", lockWaitTimeout)
+	}
+	if lockWaitTimeout > 0 && lockWaitTimeout < time.Second {
+		return fmt.Errorf("--mysql-lock-wait-timeout must be at least 1s, got %s", lockWaitTimeout)
+	}
+	if isolationLevel != "" {
+		if _, ok := isolationLevels[isolationLevel]; !ok {
+			return fmt.Errorf("unknown --mysql-isolation-level %q (supported: read-uncommitted, read-committed, repeatable-read, serializable)", isolationLevel)
+		}
+		// TiDB rejects the levels it does not implement unless tidb_skip_isolation_level_check is on.
+		if destDialect.name == "tidb" && isolationLevel != "read-committed" && isolationLevel != "repeatable-read" {
+			return fmt.Errorf("--mysql-isolation-level %s is not supported by TiDB (use read-committed or repeatable-read)", isolationLevel)
+		}
+	}
+	switch tidbTxnMode {
+	case "":
+	case "pessimistic", "optimistic":
+		if destDialect.name != "tidb" {
+			return fmt.Errorf("--tidb-txn-mode only applies to --dialect tidb, got %s", destDialect.name)
+		}
+	default:
+		return fmt.Errorf("unknown --tidb-txn-mode %q (supported: pessimistic, optimistic)", tidbTxnMode)
+	}
+	return nil
+}
+
+// applySessionFlags adds the session variables the flags set to the DSN. The driver runs SET for
+// every DSN parameter it does not know when it opens a connection, so each pooled connection gets
+// them, also the ones replacing connections --mysql-conn-lifetime closed. A variable the DSN
+// already sets wins over the flag.
+func applySessionFlags(mysqlDSN string) string {
+	if lockWaitTimeout > 0 {
+		seconds := int64((lockWaitTimeout + time.Second - 1) / time.Second)
+		mysqlDSN = ensureSessionVariable(mysqlDSN, "innodb_lock_wait_timeout", fmt.Sprint(seconds))
+	}
+	if isolationLevel != "" {
+		mysqlDSN = ensureSessionVariable(mysqlDSN, "transaction_isolation", "'"+isolationLevels[isolationLevel]+"'")
+	}
+	if tidbTxnMode != "" {
+		mysqlDSN = ensureSessionVariable(mysqlDSN, "tidb_txn_mode", "'"+tidbTxnMode+"'")
+	}
+	return mysqlDSN
+}
+
+// ensureSessionVariable adds name=value to the DSN unless it already sets name.
+func ensureSessionVariable(mysqlDSN, name, value string) string {
+	if mysqlDSN == "" {
+		return mysqlDSN
+	}
+	if i := strings.Index(mysqlDSN, "?"); i >= 0 {
+		for _, param := range strings.Split(mysqlDSN[i+1:], "&") {
+			if key, _, _ := strings.Cut(param, "="); strings.EqualFold(key, name) {
+				return mysqlDSN
+			}
+		}
+		return mysqlDSN + "&" + name + "=" + url.QueryEscape(value)
+	}
+	return mysqlDSN + "?" + name + "=" + url.QueryEscape(value)
+}
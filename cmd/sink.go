@@ -0,0 +1,315 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ha-tools/haerrors"
+)
+
+// Sink is a destination exporters write rows to. Implementations register themselves with
+// RegisterSink so new backends can be added without touching the exporters.
+type Sink interface {
+	// EnsureSchema creates or migrates the destination for the table.
+	EnsureSchema(ctx context.Context, table *tableSpec) error
+	// WriteBatch writes rows whose values follow table.writeColumns(), idempotently unless
+	// --write-mode insert applies to the table.
+	WriteBatch(ctx context.Context, table *tableSpec, rows [][]any) error
+	// LoadWatermarks returns the newest exported timeColumn value per entity.
+	LoadWatermarks(ctx context.Context, table *tableSpec) (map[string]time.Time, error)
+	// Close releases the sink's resources.
+	Close() error
+}
+
+// latestRowLoader is implemented by sinks that can read back the newest row per entity, which
+// exporters use to continue derived values (deltas, open presence stays) across runs.
+type latestRowLoader interface {
+	// LoadLatest calls fn once per entity; scan reads (entity_id, columns...) of its newest row.
+	LoadLatest(ctx context.Context, table *tableSpec, columns []string, fn func(scan func(dest ...any) error) error) error
+}
+
+// entityResolver is implemented by sinks supporting the normalized entities dimension.
+type entityResolver interface {
+	// ResolveEntity upserts the entity's metadata and returns its dimension id.
+	ResolveEntity(ctx context.Context, entityID string, meta stateMetadata) (int64, error)
+}
+
+// tableFinalizer is implemented by sinks with post-export maintenance such as index plans.
+type tableFinalizer interface {
+	FinalizeTable(ctx context.Context, table *tableSpec) error
+}
+
+// batchSizer is implemented by sinks whose writes are bounded in bytes; writers size their batches
+// to fit instead of using a fixed row count.
+type batchSizer interface {
+	// BatchRows returns how many rows of about rowBytes each fit in one write to the table, or 0
+	// when the limit is unknown.
+	BatchRows(table *tableSpec, rowBytes int) int
+}
+
+// sqlSink is implemented by SQL sinks; SQL-only features (rollups, hooks) use the handle directly.
+type sqlSink interface {
+	DB() *sql.DB
+}
+
+// sinkFactory opens a sink for a target such as a DSN or file path.
+type sinkFactory func(ctx context.Context, target string) (Sink, error)
+
+var sinkFactories = map[string]sinkFactory{}
+
+// sinkName is the --sink flag value selecting the registered sink exporters write to.
+var sinkName = "mysql"
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&sinkName, "sink", sinkName, "Destination sink the exporters write to")
+}
+
+// RegisterSink makes a sink available under name.
+func RegisterSink(name string, factory sinkFactory) {
+	if _, exists := sinkFactories[name]; exists {
+		panic(fmt.Sprintf("sink %q registered twice", name))
+	}
+	sinkFactories[name] = factory
+}
+
+func sinkNames() []string {
+	names := make([]string, 0, len(sinkFactories))
+	for name := range sinkFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// openSink opens the registered sink called name.
+func openSink(ctx context.Context, name, target string) (Sink, error) {
+	factory, ok := sinkFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown sink %q (registered: %s)", name, strings.Join(sinkNames(), ", "))
+	}
+	if explainMode {
+		return openExplainSink(name), nil
+	}
+	return factory(ctx, target)
+}
+
+// columnSpec describes one destination column.
+type columnSpec struct {
+	name string
+	// sqlType is the MySQL column definition, e.g. "VARCHAR(255) NOT NULL".
+	sqlType string
+	// generated columns (auto-increment keys) are filled by the destination, not by exporters.
+	generated bool
+	// valueExpr wraps the written value's ? placeholder, e.g. to build geometries; empty writes it as-is.
+	valueExpr string
+	// backfill computes the column for existing rows when a NOT NULL column is added to a populated
+	// table: it is added as NULL, filled from this expression, then tightened to sqlType.
+	backfill string
+}
+
+// foreignKeySpec references another table's key; sinks without foreign keys ignore it.
+type foreignKeySpec struct {
+	column    string
+	refTable  string
+	refColumn string
+}
+
+// indexSpec is a secondary index the sink keeps on the table, outside the index plan.
+type indexSpec struct {
+	name    string
+	columns []string
+	// spatial marks a SPATIAL index; sinks without spatial support skip it.
+	spatial bool
+}
+
+// tableSpec describes a destination table independently of the sink writing it.
+type tableSpec struct {
+	name        string
+	columns     []columnSpec
+	primaryKey  []string
+	uniqueKeys  [][]string
+	foreignKeys []foreignKeySpec
+	indexes     []indexSpec
+
+	// entityColumn and timeColumn drive watermarks and the index plan.
+	entityColumn string
+	timeColumn   string
+	// idColumn, when set, holds the recorder id ordering rows of an entity that share a timeColumn
+	// value; watermarks break ties on it (see exportedBefore).
+	idColumn string
+	// entityTable, when set, means entityColumn holds ids of that entities dimension table.
+	entityTable *tableSpec
+	// indexDefaults are the index plan patterns used when the config declares none.
+	indexDefaults []string
+	// newerOnly keeps stored rows whose timeColumn is newer than the written one.
+	newerOnly bool
+	// history marks recorder rows that never change once exported, which --write-mode append and
+	// insert write without upserting.
+	history bool
+
+	// mysqlMigrate runs MySQL-only schema steps (legacy migrations, views) after the table exists.
+	mysqlMigrate func(ctx context.Context, db *sql.DB) error
+}
+
+// writeColumns returns the columns exporters supply values for, in order.
+func (t *tableSpec) writeColumns() []string {
+	columns := make([]string, 0, len(t.columns))
+	for _, c := range t.columns {
+		if !c.generated {
+			columns = append(columns, c.name)
+		}
+	}
+	return columns
+}
+
+// writePlaceholders returns the value expression of each write column, in writeColumns order.
+func (t *tableSpec) writePlaceholders() []string {
+	placeholders := make([]string, 0, len(t.columns))
+	for _, c := range t.columns {
+		if c.generated {
+			continue
+		}
+		if c.valueExpr != "" {
+			placeholders = append(placeholders, c.valueExpr)
+		} else {
+			placeholders = append(placeholders, "?")
+		}
+	}
+	return placeholders
+}
+
+// withColumns returns a copy of the table with extra columns appended.
+func (t *tableSpec) withColumns(extra ...columnSpec) *tableSpec {
+	clone := *t
+	clone.columns = append(append([]columnSpec{}, t.columns...), extra...)
+	return &clone
+}
+
+// withColumnType returns a copy of the table with the named column's type replaced.
+func (t *tableSpec) withColumnType(name, sqlType string) *tableSpec {
+	clone := *t
+	clone.columns = append([]columnSpec{}, t.columns...)
+	for i, c := range clone.columns {
+		if c.name == name {
+			clone.columns[i].sqlType = sqlType
+		}
+	}
+	return &clone
+}
+
+// withIndexes returns a copy of the table with extra secondary indexes.
+func (t *tableSpec) withIndexes(extra ...indexSpec) *tableSpec {
+	clone := *t
+	clone.indexes = append(append([]indexSpec{}, t.indexes...), extra...)
+	return &clone
+}
+
+// batchWriter accumulates rows and hands them to the sink in batches of size rows, or as many as
+// fit the sink's byte limit when it is a batchSizer.
+type batchWriter struct {
+	sink  Sink
+	table *tableSpec
+	size  int
+	// limit is the batch size adapted to the sink unless fixedSize is set; see batchsize.go.
+	limit     atomic.Int64
+	fixedSize bool
+	rows      [][]any
+	// rejects queues rows for ha_tools_rejects under --on-error=collect; nil until the first one.
+	rejects *batchWriter
+	// pending tracks the batches being written in the background; see pipeline.go.
+	pending *pendingWrites
+	// batches counts the batches handed to the sink, for the context of a failing one.
+	batches int
+}
+
+func newBatchWriter(sink Sink, table *tableSpec, size int) *batchWriter {
+	return &batchWriter{sink: sink, table: table, size: size}
+}
+
+// Add queues one row, flushing when the batch is full. values must follow table.writeColumns().
+func (b *batchWriter) Add(ctx context.Context, values ...any) error {
+	b.rows = append(b.rows, values)
+	if len(b.rows) >= b.batchRows() {
+		if writeQueue > 0 {
+			if err := b.send(ctx); err != nil {
+				return err
+			}
+		} else if err := b.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	if runExpired() {
+		// Checkpoint: everything read so far is written, so the watermarks pick up from here.
+		if err := b.Flush(ctx); err != nil {
+			return err
+		}
+		return errRunTimeout()
+	}
+	return nil
+}
+
+// Flush writes any queued rows and waits for the batches still being written in the background.
+func (b *batchWriter) Flush(ctx context.Context) error {
+	if b.rejects != nil {
+		if err := b.rejects.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	if b.pending != nil {
+		if err := b.send(ctx); err != nil {
+			return err
+		}
+		return b.wait()
+	}
+	if len(b.rows) == 0 {
+		return nil
+	}
+	rows := b.rows
+	b.rows = nil
+	return b.write(ctx, rows)
+}
+
+// write passes rows through --transform and computed columns and hands them to the sink.
+func (b *batchWriter) write(ctx context.Context, rows [][]any) error {
+	read := len(rows)
+	rows, err := transformRows(b.table, rows)
+	if err != nil {
+		return err
+	}
+	if dropped := read - len(rows); dropped > 0 {
+		filteredRows.add(b.table.name, dropped)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	table, rows, err := computeColumns(b.table, rows)
+	if err != nil {
+		return err
+	}
+	b.adaptBatchSize(table, rows)
+	b.batches++
+	if err := b.sink.WriteBatch(ctx, table, rows); err != nil {
+		return &haerrors.BatchError{Table: b.table.name, Batch: b.batches, Rows: len(rows), Err: err}
+	}
+	writtenRows.add(b.table.name, len(rows))
+	recordCommittedRows(table, rows)
+	if latestPoints {
+		if err := updateLatestPoints(ctx, b.sink, b.table, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finalizeTable runs sink-specific post-export maintenance for the table.
+func finalizeTable(ctx context.Context, sink Sink, table *tableSpec) error {
+	if f, ok := sink.(tableFinalizer); ok {
+		return f.FinalizeTable(ctx, table)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// sourceLimit and sourceSample are the --limit and --sample flags, which restrict the recorder
+// rows commands read, for trying out a configuration against the real recorder quickly.
+var (
+	sourceLimit  int
+	sourceSample float64
+
+	sourceLimitNotice sync.Once
+)
+
+func init() {
+	rootCmd.PersistentFlags().IntVar(&sourceLimit, "limit", 0, "Read at most this many of the recorder's newest states (and statistics and event rows), for quick test runs (0 = all)")
+	rootCmd.PersistentFlags().Float64Var(&sourceSample, "sample", 0, "Read only this fraction of the recorder's states (and statistics and event rows), e.g. 0.01; the same rows are picked on every run (0 = all)")
+}
+
+func validateSourceLimit() error {
+	if sourceLimit < 0 {
+		return fmt.Errorf("--limit must not be negative, got %d", sourceLimit)
+	}
+	if sourceSample < 0 || sourceSample > 1 {
+		return fmt.Errorf("--sample must be between 0 and 1, got %v", sourceSample)
+	}
+	return nil
+}
+
+// scratchSinks are the sinks --limit and --sample may export to: files for inspecting a test run,
+// which no later full run to a real destination resumes from.
+var scratchSinks = []string{"ndjson", "sqlfile"}
+
+// checkPartialRead refuses to export a --limit or --sample run to the sink unless it is a scratch
+// sink or the run only explains. The rows read are the newest ones, so the watermarks they leave
+// in a real destination would make its next full run skip the unread history for good.
+func checkPartialRead(sink string) error {
+	if sourceLimit == 0 && sourceSample == 0 || explainMode || slices.Contains(scratchSinks, sink) {
+		return nil
+	}
+	return fmt.Errorf("--limit and --sample read part of the recorder, and the watermarks they leave in the %s destination would make its next full run skip the rest for good; export to --sink=%s instead, or use --explain", sink, strings.Join(scratchSinks, " or --sink="))
+}
+
+// limitedSources are the recorder tables --limit and --sample restrict, with their row ids.
+var limitedSources = []struct{ table, id string }{
+	{"states", "state_id"},
+	{"statistics", "id"},
+	{"statistics_short_term", "id"},
+	{"events", "event_id"},
+}
+
+// restrictRecorder shadows the recorder's row tables with TEMP views holding only the rows
+// --limit and --sample select, so every query of the command reads through them unchanged.
+// TEMP views belong to the connection, which openRecorder keeps to one. The shims of an older
+// schema are installed the same way, and restricted tables read through them.
+func restrictRecorder(ctx context.Context, sqliteDB *sql.DB, shims []recorderShim) error {
+	if sourceLimit == 0 && sourceSample == 0 {
+		return createShimViews(ctx, sqliteDB, shims, false)
+	}
+	sourceLimitNotice.Do(func() {
+		var notes []string
+		if sourceSample > 0 {
+			notes = append(notes, fmt.Sprintf("a %g sample", sourceSample))
+		}
+		if sourceLimit > 0 {
+			notes = append(notes, fmt.Sprintf("at most the %d newest", sourceLimit))
+		}
+		fmt.Fprintf(os.Stderr, "reading %s of the recorder's rows (--limit/--sample)\n", strings.Join(notes, " and "))
+	})
+
+	for _, source := range limitedSources {
+		var n int
+		if err := sqliteDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM main.sqlite_master WHERE type = 'table' AND name = ?", source.table).Scan(&n); err != nil {
+			return fmt.Errorf("look up recorder table %s: %w", source.table, err)
+		}
+		if n == 0 {
+			continue
+		}
+		stmt := "CREATE TEMP VIEW " + source.table + " AS " + shimQuery(shims, source.table)
+		if sourceSample > 0 {
+			// A multiplicative hash spreads consecutive ids, so the sample covers every entity and
+			// picks the same rows each run.
+			const modulus = 1000003
+			stmt += fmt.Sprintf(" WHERE (%s * 2654435761) %% %d < %d", source.id, modulus, int64(sourceSample*modulus))
+		}
+		if sourceLimit > 0 {
+			stmt += fmt.Sprintf(" ORDER BY %s DESC LIMIT %d", source.id, sourceLimit)
+		}
+		if _, err := sqliteDB.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("restrict recorder table %s: %w", source.table, err)
+		}
+	}
+	return createShimViews(ctx, sqliteDB, shims, true)
+}
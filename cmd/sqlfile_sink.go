@@ -0,0 +1,292 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterSink("sqlfile", openSQLFileSink)
+}
+
+// sqlFileSink appends the statements a SQL sink would run to a file, for destinations that are
+// only reachable by hand (air-gapped databases, change review). Rows are inlined as literals in
+// the --dialect's syntax. Watermarks live in a <file>.watermarks.json sidecar, so every run adds
+// only the delta since the previous one.
+type sqlFileSink struct {
+	path string
+	file *os.File
+	out  *bufio.Writer
+
+	mu sync.Mutex
+	// created records the tables whose CREATE TABLE was already written by this run.
+	created    map[string]bool
+	statements map[string]upsertStatement
+	watermarks sqlFileWatermarks
+}
+
+// sqlFileWatermarks maps table -> entity -> newest written timeColumn value.
+type sqlFileWatermarks map[string]map[string]time.Time
+
+func openSQLFileSink(ctx context.Context, path string) (Sink, error) {
+	if path == "" || path == "-" {
+		return nil, errors.New("the sqlfile sink needs a file path as its target, e.g. --dsn out.sql")
+	}
+	watermarks, err := readSQLFileWatermarks(sqlFileWatermarksPath(path))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open sql file: %w", err)
+	}
+	s := &sqlFileSink{
+		path:       path,
+		file:       f,
+		out:        bufio.NewWriter(f),
+		created:    make(map[string]bool),
+		statements: make(map[string]upsertStatement),
+		watermarks: watermarks,
+	}
+	fmt.Fprintf(s.out, "-- ha-tools delta generated %s (%s dialect)\n", time.Now().UTC().Format(time.RFC3339), destDialect.name)
+	return s, nil
+}
+
+func sqlFileWatermarksPath(path string) string {
+	return path + ".watermarks.json"
+}
+
+func readSQLFileWatermarks(path string) (sqlFileWatermarks, error) {
+	watermarks := make(sqlFileWatermarks)
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return watermarks, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read sql file watermarks: %w", err)
+	}
+	if err := json.Unmarshal(raw, &watermarks); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return watermarks, nil
+}
+
+// EnsureSchema writes the table's CREATE TABLE IF NOT EXISTS once per run. Table definitions are
+// MySQL DDL, so other dialects must create the tables themselves.
+func (s *sqlFileSink) EnsureSchema(ctx context.Context, table *tableSpec) error {
+	table, err := withComputedColumns(table)
+	if err != nil {
+		return err
+	}
+	if table.entityTable != nil {
+		if err := s.EnsureSchema(ctx, table.entityTable); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.created[table.name] {
+		return nil
+	}
+	s.created[table.name] = true
+	if !destDialect.mysqlProtocol {
+		_, err := fmt.Fprintf(s.out, "-- table %s must already exist\n", table.name)
+		return err
+	}
+	_, err = s.out.WriteString(strings.TrimSpace(mysqlCreateTable(table)) + ";\n")
+	return err
+}
+
+func (s *sqlFileSink) WriteBatch(ctx context.Context, table *tableSpec, rows [][]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	columns := table.writeColumns()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := table.name + "(" + strings.Join(columns, ",") + ")"
+	stmt, ok := s.statements[key]
+	if !ok {
+		stmt = destDialect.newWriteStatement(table)
+		s.statements[key] = stmt
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.TrimPrefix(stmt.prefix, "\n"))
+	for i, row := range rows {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		values, err := bindLiterals(stmt.placeholder, row)
+		if err != nil {
+			return fmt.Errorf("render %s row: %w", table.name, err)
+		}
+		b.WriteString(values)
+	}
+	b.WriteByte('\n')
+	b.WriteString(strings.TrimPrefix(stmt.suffix, "\n"))
+	query := strings.TrimRight(b.String(), "\n") + ";\n"
+	if _, err := s.out.WriteString(query); err != nil {
+		return fmt.Errorf("write %s rows: %w", table.name, err)
+	}
+	s.advanceWatermarks(table, columns, rows)
+	return nil
+}
+
+// advanceWatermarks records the newest timeColumn value written per entity.
+func (s *sqlFileSink) advanceWatermarks(table *tableSpec, columns []string, rows [][]any) {
+	entityIdx, timeIdx := -1, -1
+	for i, c := range columns {
+		switch c {
+		case table.entityColumn:
+			entityIdx = i
+		case table.timeColumn:
+			timeIdx = i
+		}
+	}
+	if table.entityColumn == "" || entityIdx < 0 || timeIdx < 0 {
+		return
+	}
+	marks := s.watermarks[table.name]
+	if marks == nil {
+		marks = make(map[string]time.Time)
+		s.watermarks[table.name] = marks
+	}
+	for _, row := range rows {
+		entity, err := driver.DefaultParameterConverter.ConvertValue(row[entityIdx])
+		if err != nil || entity == nil {
+			continue
+		}
+		ts, err := driver.DefaultParameterConverter.ConvertValue(row[timeIdx])
+		if err != nil {
+			continue
+		}
+		t, ok := ts.(time.Time)
+		if !ok {
+			continue
+		}
+		id := fmt.Sprint(entity)
+		if current, seen := marks[id]; !seen || t.After(current) {
+			marks[id] = t
+		}
+	}
+}
+
+func (s *sqlFileSink) LoadWatermarks(ctx context.Context, table *tableSpec) (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	watermarks := make(map[string]time.Time, len(s.watermarks[table.name]))
+	for entity, t := range s.watermarks[table.name] {
+		watermarks[entity] = t
+	}
+	return watermarks, nil
+}
+
+// Close flushes the file and then saves the watermarks, so they never run ahead of the statements
+// on disk.
+func (s *sqlFileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.out.Flush(); err != nil {
+		s.file.Close()
+		return fmt.Errorf("write sql file: %w", err)
+	}
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("close sql file: %w", err)
+	}
+
+	raw, err := json.MarshalIndent(s.watermarks, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := sqlFileWatermarksPath(s.path)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return fmt.Errorf("save sql file watermarks: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("save sql file watermarks: %w", err)
+	}
+	return nil
+}
+
+// bindLiterals replaces the ? placeholders of a row's value list with the row's values as SQL
+// literals.
+func bindLiterals(placeholder string, row []any) (string, error) {
+	var b strings.Builder
+	n := 0
+	for _, r := range placeholder {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		if n >= len(row) {
+			return "", fmt.Errorf("row has %d values, statement needs more", len(row))
+		}
+		literal, err := sqlLiteral(row[n])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(literal)
+		n++
+	}
+	if n != len(row) {
+		return "", fmt.Errorf("row has %d values, statement takes %d", len(row), n)
+	}
+	return b.String(), nil
+}
+
+// sqlLiteral renders a value the way the destination driver would bind it, in --dialect syntax.
+func sqlLiteral(v any) (string, error) {
+	value, err := driver.DefaultParameterConverter.ConvertValue(v)
+	if err != nil {
+		return "", err
+	}
+	switch value := value.(type) {
+	case nil:
+		return "NULL", nil
+	case int64:
+		return strconv.FormatInt(value, 10), nil
+	case float64:
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return "", fmt.Errorf("cannot write %v as a SQL literal", value)
+		}
+		return strconv.FormatFloat(value, 'g', -1, 64), nil
+	case bool:
+		if destDialect.name == "postgres" {
+			return strings.ToUpper(strconv.FormatBool(value)), nil
+		}
+		if value {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Time:
+		return "'" + value.UTC().Format("2006-01-02 15:04:05.999999") + "'", nil
+	case []byte:
+		if destDialect.name == "postgres" {
+			return `'\x` + hex.EncodeToString(value) + "'", nil
+		}
+		return "X'" + hex.EncodeToString(value) + "'", nil
+	case string:
+		if destDialect.mysqlProtocol {
+			// MySQL treats backslashes in string literals as escapes.
+			value = strings.ReplaceAll(value, `\`, `\\`)
+		}
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'", nil
+	}
+	return "", fmt.Errorf("cannot write %T as a SQL literal", value)
+}
@@ -0,0 +1,259 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// preSQL, postSQL, and failureSQL are the --pre-sql, --post-sql, and --failure-sql hooks: SQL
+// run against the command's --dsn before it starts, after it succeeded, or after it failed.
+var (
+	preSQL     []string
+	postSQL    []string
+	failureSQL []string
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringArrayVar(&preSQL, "pre-sql", nil, "SQL (or @file.sql) to run against the destination before the command; a failure stops the command. Repeatable")
+	rootCmd.PersistentFlags().StringArrayVar(&postSQL, "post-sql", nil, "SQL (or @file.sql) to run against the destination after the command succeeded; a failure fails the command. Repeatable")
+	rootCmd.PersistentFlags().StringArrayVar(&failureSQL, "failure-sql", nil, "SQL (or @file.sql) to run against the destination after the command or another hook failed. Repeatable")
+}
+
+// hookVars are the variables hook SQL can reference as {{.Name}}; {{quote .Error}} renders a
+// string as a SQL literal.
+type hookVars struct {
+	// Command is the command path, e.g. "ha-tools energy".
+	Command string
+	// StartedAt is the run's start as a UTC DATETIME literal body, e.g. 2024-05-01 03:00:00.
+	StartedAt string
+	// StartedAtUnix is the run's start in Unix seconds.
+	StartedAtUnix int64
+	// Status is "running" in --pre-sql, "ok" in --post-sql, and "failed" in --failure-sql.
+	Status string
+	// RowsWritten is the number of rows written so far.
+	RowsWritten int64
+	// Error is the failure's message in --failure-sql.
+	Error string
+}
+
+// sqlHookRun is the state hooks share across one command.
+var sqlHookRun struct {
+	started time.Time
+	dsn     string
+}
+
+// hasSQLHooks reports whether any hook is configured.
+func hasSQLHooks() bool {
+	return len(preSQL)+len(postSQL)+len(failureSQL) > 0
+}
+
+// runPreSQLHooks runs --pre-sql for the command. watch and addon only pass the hooks on to their
+// jobs, so each job runs them around its own export.
+func runPreSQLHooks(ctx context.Context, cmd *cobra.Command) error {
+	sqlHookRun.started = time.Now()
+	if !hasSQLHooks() || cmd.Name() == "watch" || cmd.Name() == "addon" {
+		return nil
+	}
+	flag := cmd.Flags().Lookup("dsn")
+	if flag == nil {
+		return fmt.Errorf("--pre-sql, --post-sql, and --failure-sql need a command with --dsn; %s has none", cmd.CommandPath())
+	}
+	if sinkName != "mysql" {
+		return fmt.Errorf("SQL hooks run on the mysql sink, not %s", sinkName)
+	}
+	sqlHookRun.dsn = flag.Value.String()
+	if sqlHookRun.dsn == "" {
+		return errors.New("SQL hooks need --dsn")
+	}
+	return runSQLHooks(ctx, "--pre-sql", preSQL, hookVars{Command: cmd.CommandPath(), Status: "running"})
+}
+
+// runPostSQLHooks runs --post-sql after a successful command, or --failure-sql after a failed one
+// (including a failed --pre-sql or --post-sql), and returns the command's resulting error. Hooks
+// run outside --run-timeout, so cleanup still happens after a timed-out run.
+func runPostSQLHooks(cmd *cobra.Command, runErr error) error {
+	if sqlHookRun.dsn == "" {
+		return runErr
+	}
+	ctx := context.Background()
+	vars := hookVars{Command: cmd.CommandPath()}
+
+	if runErr == nil {
+		vars.Status = "ok"
+		runErr = runSQLHooks(ctx, "--post-sql", postSQL, vars)
+		if runErr == nil {
+			return nil
+		}
+	}
+
+	vars.Status, vars.Error = "failed", runErr.Error()
+	if err := runSQLHooks(ctx, "--failure-sql", failureSQL, vars); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return runErr
+}
+
+// runSQLHooks renders and executes every statement of the hooks, in order, on one connection so
+// session state (variables, temporary tables) carries across statements.
+func runSQLHooks(ctx context.Context, flag string, hooks []string, vars hookVars) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+	vars.StartedAt = sqlHookRun.started.UTC().Format(time.DateTime)
+	vars.StartedAtUnix = sqlHookRun.started.Unix()
+	writtenRows.mu.Lock()
+	for _, n := range writtenRows.tables {
+		vars.RowsWritten += n
+	}
+	writtenRows.mu.Unlock()
+
+	if explainMode {
+		return explainSQLHooks(flag, hooks, vars)
+	}
+	db, err := openDestination(ctx, sqlHookRun.dsn)
+	if err != nil {
+		return fmt.Errorf("%s: %w", flag, err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", flag, err)
+	}
+	defer conn.Close()
+
+	for _, hook := range hooks {
+		source, err := loadSQLHook(hook)
+		if err != nil {
+			return fmt.Errorf("%s: %w", flag, err)
+		}
+		rendered, err := renderSQLHook(source, vars)
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", flag, hookName(hook), err)
+		}
+		for _, stmt := range splitSQLStatements(rendered) {
+			qctx, cancel := withStatementTimeout(ctx)
+			_, err := conn.ExecContext(qctx, stmt)
+			err = explainTimeout(qctx, err)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("%s %s: %w\n%s", flag, hookName(hook), err, stmt)
+			}
+		}
+	}
+	return nil
+}
+
+// explainSQLHooks prints the statements of the hooks under --explain instead of running them.
+func explainSQLHooks(flag string, hooks []string, vars hookVars) error {
+	for _, hook := range hooks {
+		source, err := loadSQLHook(hook)
+		if err != nil {
+			return fmt.Errorf("%s: %w", flag, err)
+		}
+		rendered, err := renderSQLHook(source, vars)
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", flag, hookName(hook), err)
+		}
+		fmt.Fprintf(explainOut, "-- %s %s\n", flag, hookName(hook))
+		for _, stmt := range splitSQLStatements(rendered) {
+			fmt.Fprintf(explainOut, "%s;\n", stmt)
+		}
+	}
+	return nil
+}
+
+// loadSQLHook returns the hook's SQL: the contents of the file for @path, else the value itself.
+func loadSQLHook(hook string) (string, error) {
+	path, isFile := strings.CutPrefix(hook, "@")
+	if !isFile {
+		return hook, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read hook: %w", err)
+	}
+	return string(raw), nil
+}
+
+func hookName(hook string) string {
+	if strings.HasPrefix(hook, "@") {
+		return hook[1:]
+	}
+	return "(inline)"
+}
+
+func renderSQLHook(source string, vars hookVars) (string, error) {
+	tmpl, err := template.New("hook").Option("missingkey=error").Funcs(template.FuncMap{
+		"quote": func(s string) string {
+			return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), "'", "''") + "'"
+		},
+	}).Parse(source)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// splitSQLStatements splits a script on the semicolons ending its statements, ignoring those in
+// quotes and comments, and drops empty statements.
+func splitSQLStatements(script string) []string {
+	var (
+		statements []string
+		current    strings.Builder
+		quote      byte
+	)
+	flush := func() {
+		if stmt := strings.TrimSpace(current.String()); stmt != "" {
+			statements = append(statements, stmt)
+		}
+		current.Reset()
+	}
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case quote != 0:
+			current.WriteByte(c)
+			if c == '\\' && quote != '`' && i+1 < len(script) {
+				i++
+				current.WriteByte(script[i])
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			current.WriteByte(c)
+		case c == '#' || (c == '-' && strings.HasPrefix(script[i:], "-- ")):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end
+				current.WriteByte('\n')
+			}
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+		case c == ';':
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+	return statements
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitSQLStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"single statement without semicolon", "SELECT 1", []string{"SELECT 1"}},
+		{"statements", "SELECT 1;\nSELECT 2;\n", []string{"SELECT 1", "SELECT 2"}},
+		{"empty statements", ";; SELECT 1 ;\n;", []string{"SELECT 1"}},
+		{"empty script", " \n\t", nil},
+		{"semicolon in single quotes", "INSERT INTO t VALUES ('a;b'); SELECT 2", []string{"INSERT INTO t VALUES ('a;b')", "SELECT 2"}},
+		{"semicolon in double quotes", `SELECT "a;b"; SELECT 2`, []string{`SELECT "a;b"`, "SELECT 2"}},
+		{"semicolon in backticks", "SELECT 1 AS `a;b`; SELECT 2", []string{"SELECT 1 AS `a;b`", "SELECT 2"}},
+		{"escaped quote", `SELECT 'it\'s;'; SELECT 2`, []string{`SELECT 'it\'s;'`, "SELECT 2"}},
+		{"doubled quote", "SELECT 'it''s;'; SELECT 2", []string{"SELECT 'it''s;'", "SELECT 2"}},
+		{"backslash in backticks", "SELECT 1 AS `a\\`; SELECT 2", []string{"SELECT 1 AS `a\\`", "SELECT 2"}},
+		{"comment markers in quotes", "SELECT '-- #', '/* x */'", []string{"SELECT '-- #', '/* x */'"}},
+		{"hash comment", "SELECT 1; # drop; this\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"dash comment", "SELECT 1 -- trailing; note\n+ 1;", []string{"SELECT 1 \n+ 1"}},
+		{"comment at end", "SELECT 1; -- done;", []string{"SELECT 1"}},
+		{"double dash without space", "SELECT 2--1", []string{"SELECT 2--1"}},
+		{"block comment", "SELECT /* a; b */ 1; SELECT 2", []string{"SELECT  1", "SELECT 2"}},
+		{"multi-line block comment", "/*\n setup;\n*/\nSELECT 1;", []string{"SELECT 1"}},
+		{"unterminated block comment", "SELECT 1; /* never closed; ", []string{"SELECT 1"}},
+		{"quote in comment", "SELECT 1; -- it's\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitSQLStatements(tt.script); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitSQLStatements(%q) = %q, want %q", tt.script, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// stagingTableName names the table --write-mode staging-swap re-exports a history table into.
+func stagingTableName(table string) string {
+	return table + "_staging"
+}
+
+// ensureStagingTable prepares the staging copy of a history table under --write-mode
+// staging-swap: an empty table shaped like the live one (CREATE TABLE ... LIKE keeps its columns,
+// keys, and indexes), which the table's rows are written to and its watermarks read from, so the
+// export starts over. A staging table left by an earlier run is dropped, unless --resume continues
+// that run.
+func (s *mysqlSink) ensureStagingTable(ctx context.Context, table *tableSpec) error {
+	s.mu.Lock()
+	_, ok := s.staging[table.name]
+	s.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	staging := stagingTableName(table.name)
+	if resumeFrom == "" {
+		if _, err := execStatement(ctx, s.db, "DROP TABLE IF EXISTS "+staging); err != nil {
+			return fmt.Errorf("drop stale %s table: %w", staging, err)
+		}
+	}
+	if _, err := execStatement(ctx, s.db, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s LIKE %s", staging, table.name)); err != nil {
+		return fmt.Errorf("create %s table: %w", staging, err)
+	}
+	s.mu.Lock()
+	s.staging[table.name] = staging
+	s.mu.Unlock()
+	return nil
+}
+
+// target returns the table the sink reads and writes in place of table: its staging copy while
+// one is open, or the table itself.
+func (s *mysqlSink) target(table *tableSpec) *tableSpec {
+	s.mu.Lock()
+	staging, ok := s.staging[table.name]
+	s.mu.Unlock()
+	if !ok {
+		return table
+	}
+	staged := *table
+	staged.name = staging
+	return &staged
+}
+
+// swapStagingTable replaces the live table with its staging copy once the export has written
+// every row. The counts are checked first: the staging table must hold no more rows than this run
+// wrote to it (unless it continues an earlier run), and replacing a table with fewer rows, such
+// as after the recorder purged older history, needs a confirmation. A single RENAME TABLE then
+// swaps both tables, so readers see either the old table or the complete new one.
+func (s *mysqlSink) swapStagingTable(ctx context.Context, table *tableSpec, staging string) error {
+	var staged, live int64
+	if err := queryRowStatement(ctx, s.db, "SELECT COUNT(*) FROM "+staging, nil, &staged); err != nil {
+		return fmt.Errorf("count %s rows: %w", staging, err)
+	}
+	if err := queryRowStatement(ctx, s.db, "SELECT COUNT(*) FROM "+table.name, nil, &live); err != nil {
+		return fmt.Errorf("count %s rows: %w", table.name, err)
+	}
+	s.mu.Lock()
+	written := s.stagedRows[table.name]
+	s.mu.Unlock()
+	if resumeFrom == "" && staged > written {
+		return fmt.Errorf("%s holds %d rows, but this run wrote %d; another process may be writing to it, so %s was not replaced", staging, staged, written, table.name)
+	}
+	if staged < live {
+		if err := confirm(fmt.Sprintf("replace %s (%d rows) with %s (%d rows)", table.name, live, staging, staged)); err != nil {
+			return err
+		}
+	}
+
+	old := table.name + "_old"
+	if _, err := execStatement(ctx, s.db, "DROP TABLE IF EXISTS "+old); err != nil {
+		return fmt.Errorf("drop stale %s table: %w", old, err)
+	}
+	stmt := fmt.Sprintf("RENAME TABLE %[1]s TO %[2]s, %[3]s TO %[1]s", table.name, old, staging)
+	if _, err := execStatement(ctx, s.db, stmt); err != nil {
+		return fmt.Errorf("swap %s into %s: %w", staging, table.name, err)
+	}
+	s.mu.Lock()
+	delete(s.staging, table.name)
+	s.mu.Unlock()
+	if _, err := execStatement(ctx, s.db, "DROP TABLE "+old); err != nil {
+		return fmt.Errorf("drop replaced %s table: %w", old, err)
+	}
+	fmt.Fprintf(os.Stderr, "swapped %s into %s: %d rows (was %d)\n", staging, table.name, staged, live)
+	return nil
+}
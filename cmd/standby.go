@@ -0,0 +1,312 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	standbyMySQLDSN   string
+	standbyEntity     string
+	standbyWindow     time.Duration
+	standbyNightStart int
+	standbyNightEnd   int
+	standbyPercentile float64
+	standbyPrice      float64
+	standbyHourly     bool
+	standbyPriceArea  string
+	standbyJSON       bool
+	standbyWrite      bool
+)
+
+// hoursPerMonth is the average month length used to project standby draw into kWh.
+const hoursPerMonth = 365.25 * 24 / 12
+
+// energyStandbyCmd estimates each socket's always-on draw from its overnight readings.
+var energyStandbyCmd = &cobra.Command{
+	Use:   "standby",
+	Short: "Estimate standby (vampire) power per socket from energy_points",
+	Long:  "Takes a low percentile of each power entity's overnight readings in energy_points as its standby draw and projects it into monthly kWh and, with --price or --hourly-prices, monthly cost. Results are printed as a table or JSON and optionally written to an energy_standby table.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if standbyMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+		if standbyWindow <= 0 {
+			return errors.New("--window must be positive")
+		}
+		if standbyNightStart < 0 || standbyNightStart > 23 || standbyNightEnd < 0 || standbyNightEnd > 23 || standbyNightStart == standbyNightEnd {
+			return errors.New("--night-start and --night-end must be distinct hours between 0 and 23")
+		}
+		if standbyPercentile <= 0 || standbyPercentile >= 100 {
+			return errors.New("--percentile must be between 0 and 100")
+		}
+		if standbyPrice < 0 {
+			return errors.New("--price must not be negative")
+		}
+		if standbyHourly && standbyPrice > 0 {
+			return errors.New("--price and --hourly-prices are mutually exclusive")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		return reportStandbyPower(ctx, cmd.OutOrStdout(), standbyMySQLDSN)
+	},
+}
+
+func init() {
+	energyStandbyCmd.Flags().StringVar(&standbyMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	energyStandbyCmd.Flags().StringVar(&standbyEntity, "entity", "", "Optional slug narrowing the analysed entities (substring of entity_id)")
+	energyStandbyCmd.Flags().DurationVar(&standbyWindow, "window", 30*24*time.Hour, "History to analyse")
+	energyStandbyCmd.Flags().IntVar(&standbyNightStart, "night-start", 1, "First overnight hour (local time) treated as standby")
+	energyStandbyCmd.Flags().IntVar(&standbyNightEnd, "night-end", 5, "Hour (local time) the overnight period ends, exclusive")
+	energyStandbyCmd.Flags().Float64Var(&standbyPercentile, "percentile", 5, "Percentile of overnight power taken as the standby draw")
+	energyStandbyCmd.Flags().Float64Var(&standbyPrice, "price", 0, "Electricity price per kWh used for the monthly cost (0 to omit)")
+	energyStandbyCmd.Flags().BoolVar(&standbyHourly, "hourly-prices", false, "Price the monthly cost with the window's hourly prices from energy_prices (see the prices command) instead of --price")
+	energyStandbyCmd.Flags().StringVar(&standbyPriceArea, "price-area", "", "Area of energy_prices to use with --hourly-prices, when it holds more than one")
+	energyStandbyCmd.Flags().BoolVar(&standbyJSON, "json", false, "Print the report as JSON")
+	energyStandbyCmd.Flags().BoolVar(&standbyWrite, "write", false, "Also upsert the results into an energy_standby table")
+	_ = energyStandbyCmd.MarkFlagRequired("dsn")
+
+	energyCmd.AddCommand(energyStandbyCmd)
+}
+
+var energyStandbyTable = &tableSpec{
+	name: "energy_standby",
+	columns: []columnSpec{
+		{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "window_start", sqlType: "DATETIME NOT NULL"},
+		{name: "window_end", sqlType: "DATETIME NOT NULL"},
+		{name: "samples", sqlType: "INT NOT NULL"},
+		{name: "standby_watts", sqlType: "DOUBLE NOT NULL"},
+		{name: "monthly_kwh", sqlType: "DOUBLE NOT NULL"},
+		{name: "monthly_cost", sqlType: "DOUBLE NULL"},
+	},
+	primaryKey: []string{"entity_id"},
+}
+
+// standbyResult is one entity's estimated standby draw.
+type standbyResult struct {
+	EntityID     string    `json:"entity_id"`
+	WindowStart  time.Time `json:"window_start"`
+	WindowEnd    time.Time `json:"window_end"`
+	Samples      int       `json:"samples"`
+	StandbyWatts float64   `json:"standby_watts"`
+	MonthlyKWh   float64   `json:"monthly_kwh"`
+	MonthlyCost  *float64  `json:"monthly_cost,omitempty"`
+}
+
+func reportStandbyPower(ctx context.Context, out io.Writer, mysqlDSN string) error {
+	sink, err := openSink(ctx, sinkName, mysqlDSN)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	sq, ok := sink.(sqlSink)
+	if !ok {
+		return fmt.Errorf("energy standby reads energy_points back and needs a SQL sink, not %s", sinkName)
+	}
+
+	windowEnd := wallClock.Now()
+	windowStart := windowEnd.Add(-standbyWindow)
+	readings, err := loadOvernightPower(ctx, sq, windowStart)
+	if err != nil {
+		return fmt.Errorf("scan energy_points: %w", err)
+	}
+
+	price := standbyPrice
+	if standbyHourly {
+		if price, err = loadMeanPrice(ctx, sq, windowStart, windowEnd); err != nil {
+			return err
+		}
+	}
+
+	entityIDs := make([]string, 0, len(readings))
+	for entityID := range readings {
+		entityIDs = append(entityIDs, entityID)
+	}
+	sort.Strings(entityIDs)
+
+	results := make([]standbyResult, 0, len(entityIDs))
+	for _, entityID := range entityIDs {
+		watts := percentile(readings[entityID], standbyPercentile)
+		r := standbyResult{
+			EntityID:     entityID,
+			WindowStart:  windowStart,
+			WindowEnd:    windowEnd,
+			Samples:      len(readings[entityID]),
+			StandbyWatts: watts,
+			MonthlyKWh:   watts * hoursPerMonth / 1000,
+		}
+		if price > 0 {
+			cost := r.MonthlyKWh * price
+			r.MonthlyCost = &cost
+		}
+		results = append(results, r)
+	}
+
+	if standbyJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return fmt.Errorf("encode report: %w", err)
+		}
+	} else {
+		printStandbyReport(out, results, price > 0)
+	}
+
+	if !standbyWrite || len(results) == 0 {
+		return nil
+	}
+	if err := sink.EnsureSchema(ctx, energyStandbyTable); err != nil {
+		return fmt.Errorf("ensure energy_standby table: %w", err)
+	}
+
+	const standbyBatchSize = 500
+
+	writer := newBatchWriter(sink, energyStandbyTable, standbyBatchSize)
+	for _, r := range results {
+		if err := writer.Add(ctx, r.EntityID, r.WindowStart, r.WindowEnd, r.Samples, r.StandbyWatts, r.MonthlyKWh, r.MonthlyCost); err != nil {
+			return err
+		}
+	}
+	return writer.Flush(ctx)
+}
+
+// loadOvernightPower returns the overnight power readings in watts per entity. The overnight
+// period is evaluated in the local time zone, which is why it is filtered here rather than in SQL.
+func loadOvernightPower(ctx context.Context, sq sqlSink, since time.Time) (map[string][]float64, error) {
+	query := `
+SELECT entity_id, numeric_state, unit, last_updated
+FROM energy_points
+WHERE last_updated >= ? AND numeric_state IS NOT NULL
+  AND (device_class = 'power' OR unit IN ('W', 'kW'))`
+	args := []any{since}
+	if standbyEntity != "" {
+		query += " AND entity_id LIKE ?" + likeEscape
+		args = append(args, likeContains(standbyEntity))
+	}
+
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := sq.DB().QueryContext(qctx, query, args...)
+	if err != nil {
+		return nil, explainTimeout(qctx, err)
+	}
+	defer rows.Close()
+
+	readings := make(map[string][]float64)
+	for rows.Next() {
+		var (
+			entityID    string
+			value       float64
+			unit        sql.NullString
+			lastUpdated time.Time
+		)
+		if err := rows.Scan(&entityID, &value, &unit, &lastUpdated); err != nil {
+			return nil, err
+		}
+		if !isOvernightHour(inExportZone(lastUpdated).Hour()) {
+			continue
+		}
+		if strings.EqualFold(unit.String, "kW") {
+			value *= 1000
+		}
+		readings[entityID] = append(readings[entityID], value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+// loadMeanPrice returns the time-weighted mean of the energy_prices intervals starting in
+// [since, until). Standby draw is constant around the clock, so its cost over the window is the
+// energy times this mean, which is what it would cost at the actual hourly prices.
+func loadMeanPrice(ctx context.Context, sq sqlSink, since, until time.Time) (float64, error) {
+	query := `
+SELECT COUNT(DISTINCT provider, area),
+       SUM(price * TIMESTAMPDIFF(SECOND, start_time, end_time)) / SUM(TIMESTAMPDIFF(SECOND, start_time, end_time))
+FROM energy_prices
+WHERE start_time >= ? AND start_time < ?`
+	args := []any{since, until}
+	if standbyPriceArea != "" {
+		query += " AND area = ?"
+		args = append(args, standbyPriceArea)
+	}
+
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	var (
+		areas int
+		mean  sql.NullFloat64
+	)
+	if err := sq.DB().QueryRowContext(qctx, query, args...).Scan(&areas, &mean); err != nil {
+		return 0, fmt.Errorf("read energy_prices: %w", explainTimeout(qctx, err))
+	}
+	switch {
+	case areas > 1:
+		return 0, fmt.Errorf("energy_prices holds %d areas for the window; choose one with --price-area", areas)
+	case !mean.Valid:
+		return 0, errors.New("energy_prices has no prices for the window; fetch them with the prices command")
+	}
+	return mean.Float64, nil
+}
+
+// isOvernightHour reports whether hour falls in [--night-start, --night-end), wrapping past midnight.
+func isOvernightHour(hour int) bool {
+	if standbyNightStart < standbyNightEnd {
+		return hour >= standbyNightStart && hour < standbyNightEnd
+	}
+	return hour >= standbyNightStart || hour < standbyNightEnd
+}
+
+// percentile returns the nearest-rank p-th percentile of values, sorting them in place.
+func percentile(values []float64, p float64) float64 {
+	sort.Float64s(values)
+	rank := int(math.Ceil(p / 100 * float64(len(values))))
+	if rank < 1 {
+		rank = 1
+	}
+	return values[rank-1]
+}
+
+func printStandbyReport(w io.Writer, results []standbyResult, priced bool) {
+	if len(results) == 0 {
+		fmt.Fprintln(w, "no overnight power readings found")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "entity\tsamples\tstandby W\tkWh/month\tcost/month")
+	var totalKWh, totalCost float64
+	for _, r := range results {
+		cost := "-"
+		if r.MonthlyCost != nil {
+			cost = fmt.Sprintf("%.2f", *r.MonthlyCost)
+			totalCost += *r.MonthlyCost
+		}
+		totalKWh += r.MonthlyKWh
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.2f\t%s\n", r.EntityID, r.Samples, r.StandbyWatts, r.MonthlyKWh, cost)
+	}
+	total := "-"
+	if priced {
+		total = fmt.Sprintf("%.2f", totalCost)
+	}
+	fmt.Fprintf(tw, "total\t\t\t%.2f\t%s\n", totalKWh, total)
+	tw.Flush()
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// stateMaxLength is the --state-max-length flag: the number of characters state columns are
+// created (or widened) to hold; 0 makes them TEXT.
+var stateMaxLength = 255
+
+func init() {
+	rootCmd.PersistentFlags().IntVar(&stateMaxLength, "state-max-length", stateMaxLength, "Characters the destination state columns hold (0 = TEXT); longer states still widen the column automatically")
+}
+
+// isStateColumn reports whether c is a VARCHAR state column, which is sized by --state-max-length
+// and widened on demand. Numeric (statistics) and TEXT (ha_tools_rejects) state columns are left alone.
+func isStateColumn(c columnSpec) bool {
+	return c.name == "state" && strings.HasPrefix(c.sqlType, "VARCHAR(")
+}
+
+// stateColumnType renders the type of a state column holding chars characters, keeping the
+// NULL/NOT NULL of the declared type. Beyond VARCHAR(4096) it moves to TEXT types, which do not
+// count against MySQL's 64KB row size limit.
+func stateColumnType(declared string, chars int) string {
+	nullability := "NULL"
+	if strings.Contains(declared, "NOT NULL") {
+		nullability = "NOT NULL"
+	}
+	switch {
+	case chars <= 0 || (chars > 4096 && chars <= maxTextChars):
+		return "TEXT " + nullability
+	case chars > maxTextChars:
+		return "MEDIUMTEXT " + nullability
+	default:
+		return fmt.Sprintf("VARCHAR(%d) %s", chars, nullability)
+	}
+}
+
+// maxTextChars is what a utf8mb4 TEXT column is guaranteed to hold (65535 bytes, 4 per character).
+const maxTextChars = 65535 / 4
+
+// resolveColumn applies --state-max-length to state columns.
+func resolveColumn(c columnSpec) columnSpec {
+	if isStateColumn(c) && stateMaxLength != 255 {
+		c.sqlType = stateColumnType(c.sqlType, stateMaxLength)
+	}
+	return c
+}
+
+// stateCapacity returns how many characters the table's state column holds, or -1 when the table
+// has no such column.
+func stateCapacity(ctx context.Context, db *sql.DB, table string) (int, error) {
+	var (
+		dataType string
+		maxChars sql.NullInt64
+	)
+	err := queryRowStatement(ctx, db, `
+SELECT DATA_TYPE, CHARACTER_MAXIMUM_LENGTH
+FROM INFORMATION_SCHEMA.COLUMNS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = 'state'
+`, []any{table}, &dataType, &maxChars)
+	if errors.Is(err, sql.ErrNoRows) {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read %s.state type: %w", table, err)
+	}
+
+	switch strings.ToLower(dataType) {
+	case "varchar", "char":
+		return int(maxChars.Int64), nil
+	case "text":
+		return maxTextChars, nil
+	case "mediumtext":
+		return 16777215 / 4, nil
+	case "longtext":
+		return 1<<32/4 - 1, nil
+	}
+	// Numeric state columns are not widened.
+	return -1, nil
+}
+
+// stateFlagChars is the capacity --state-max-length asks for.
+func stateFlagChars() int {
+	if stateMaxLength <= 0 {
+		return maxTextChars
+	}
+	return stateMaxLength
+}
+
+// widenedStateChars picks the capacity for a state of n characters: steps of VARCHAR(1024),
+// VARCHAR(4096), and TEXT, so a long state doesn't trigger one ALTER per extra character.
+func widenedStateChars(n int) int {
+	for _, step := range []int{1024, 4096, maxTextChars} {
+		if n <= step {
+			return step
+		}
+	}
+	return n
+}
+
+// ensureStateCapacity widens the table's state column to columnChars characters when it holds fewer
+// than needed. Widening a VARCHAR or moving it to TEXT never loses data.
+func (s *mysqlSink) ensureStateCapacity(ctx context.Context, table *tableSpec, needed, columnChars int) error {
+	var state columnSpec
+	found := false
+	for _, c := range table.columns {
+		if isStateColumn(c) {
+			state, found = c, true
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	capacity, ok := s.stateCapacities[table.name]
+	if !ok {
+		var err error
+		if capacity, err = stateCapacity(ctx, s.db, table.name); err != nil {
+			return err
+		}
+		s.stateCapacities[table.name] = capacity
+	}
+	if capacity < 0 || needed <= capacity {
+		return nil
+	}
+
+	newType := stateColumnType(state.sqlType, columnChars)
+	stmt := fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN state %s", table.name, newType)
+	if _, err := execStatement(ctx, s.db, stmt); err != nil {
+		return fmt.Errorf("widen %s.state to %s: %w", table.name, newType, err)
+	}
+	fmt.Fprintf(os.Stderr, "widened %s.state from %d characters to %s\n", table.name, capacity, newType)
+	// Re-read: TEXT types report their own capacity.
+	delete(s.stateCapacities, table.name)
+	return nil
+}
+
+// longestState returns the length in characters of the longest state in rows, or 0 when the
+// table has no state column.
+func longestState(table *tableSpec, rows [][]any) int {
+	index := -1
+	for i, name := range table.writeColumns() {
+		if name == "state" {
+			index = i
+		}
+	}
+	if index < 0 {
+		return 0
+	}
+
+	longest := 0
+	for _, row := range rows {
+		var n int
+		switch v := row[index].(type) {
+		case string:
+			n = utf8.RuneCountInString(v)
+		case sql.NullString:
+			n = utf8.RuneCountInString(v.String)
+		}
+		longest = max(longest, n)
+	}
+	return longest
+}
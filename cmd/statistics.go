@@ -0,0 +1,275 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	statisticsSQLitePaths []string
+	statisticsMySQLDSN    string
+	statisticsShortTerm   bool
+)
+
+// statisticsCmd exports long-term (and optionally short-term) statistics with their metadata.
+var statisticsCmd = &cobra.Command{
+	Use:   "statistics",
+	Short: "Export Home Assistant long-term statistics into MySQL",
+	Long:  "Copies statistics_meta and the hourly statistics table (optionally statistics_short_term) from the Home Assistant SQLite recorder database into MySQL, preserving metadata_id and resolving statistic_id so mean/sum series can be joined back to entities.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(statisticsSQLitePaths) == 0 {
+			return errors.New("sqlite database path is required")
+		}
+		if statisticsMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		return forEachRecorder(ctx, statisticsSQLitePaths, func(sqlitePath string) error {
+			return transferStatisticsData(ctx, sqlitePath, statisticsMySQLDSN, statisticsShortTerm)
+		})
+	},
+}
+
+func init() {
+	statisticsCmd.Flags().StringArrayVar(&statisticsSQLitePaths, "sqlite", nil, recorderFlagUsage)
+	statisticsCmd.Flags().StringVar(&statisticsMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	statisticsCmd.Flags().BoolVar(&statisticsShortTerm, "short-term", false, "Also export the 5-minute statistics_short_term table")
+	_ = statisticsCmd.MarkFlagRequired("sqlite")
+	_ = statisticsCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(statisticsCmd)
+}
+
+func transferStatisticsData(ctx context.Context, sqlitePath, mysqlDSN string, shortTerm bool) error {
+	sqliteDB, err := openRecorder(ctx, sqlitePath)
+	if err != nil {
+		return err
+	}
+	defer sqliteDB.Close()
+
+	sink, err := openExportSink(ctx, mysqlDSN)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	tables := []*tableSpec{statisticsPointsTable("statistics_points")}
+	if shortTerm {
+		tables = append(tables, statisticsPointsTable("statistics_short_term_points"))
+	}
+	for _, table := range append([]*tableSpec{statisticsMetaTable}, tables...) {
+		if err := sink.EnsureSchema(ctx, table); err != nil {
+			return fmt.Errorf("ensure %s table: %w", table.name, err)
+		}
+	}
+
+	statisticIDs, err := transferStatisticsMeta(ctx, sqliteDB, sink)
+	if err != nil {
+		return fmt.Errorf("export statistics_meta: %w", err)
+	}
+
+	sources := []string{"statistics", "statistics_short_term"}
+	for i, table := range tables {
+		if err := transferStatisticsTable(ctx, sqliteDB, sink, sources[i], table, statisticIDs); err != nil {
+			return fmt.Errorf("export %s: %w", sources[i], err)
+		}
+	}
+	return nil
+}
+
+// transferStatisticsMeta copies every statistics_meta row and returns the metadata_id to
+// statistic_id mapping.
+func transferStatisticsMeta(ctx context.Context, sqliteDB *sql.DB, sink Sink) (map[int64]string, error) {
+	const query = `
+SELECT id, statistic_id, source, unit_of_measurement, has_mean, has_sum, name
+FROM statistics_meta
+ORDER BY id
+`
+	rows, err := sqliteDB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("query sqlite database: %w", err)
+	}
+	defer rows.Close()
+
+	const metaBatchSize = 500
+
+	writer := newBatchWriter(sink, statisticsMetaTable, metaBatchSize)
+
+	statisticIDs := make(map[int64]string)
+	for rows.Next() {
+		var (
+			id          int64
+			statisticID string
+			source      sql.NullString
+			unit        sql.NullString
+			hasMean     sql.NullBool
+			hasSum      sql.NullBool
+			name        sql.NullString
+		)
+		if err := rows.Scan(&id, &statisticID, &source, &unit, &hasMean, &hasSum, &name); err != nil {
+			return nil, fmt.Errorf("scan sqlite row: %w", err)
+		}
+		statisticIDs[id] = statisticID
+
+		if err := writer.Add(ctx, id, statisticID, source, unit, hasMean, hasSum, name); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate sqlite rows: %w", err)
+	}
+
+	if err := writer.Flush(ctx); err != nil {
+		return nil, err
+	}
+	return statisticIDs, nil
+}
+
+// transferStatisticsTable copies rows newer than the destination's latest start per metadata_id
+// from a recorder statistics table, keeping the recorder id and metadata_id.
+func transferStatisticsTable(ctx context.Context, sqliteDB *sql.DB, sink Sink, source string, table *tableSpec, statisticIDs map[int64]string) error {
+	watermarks, err := loadWatermarks(ctx, sink, table)
+	if err != nil {
+		return fmt.Errorf("load %s checkpoints: %w", table.name, err)
+	}
+
+	const statisticsBatchSize = 500
+
+	if rowBudget > 0 {
+		n, err := countNewRecorderRows(ctx, sqliteDB, source, "", nil, "CAST(metadata_id AS TEXT)", "start_ts", watermarks)
+		if err != nil {
+			return err
+		}
+		if err := checkRowBudget(table, n, statisticsBatchSize); err != nil {
+			return err
+		}
+	}
+
+	incremental, args, err := incrementalPredicate("CAST(metadata_id AS TEXT)", "start_ts", watermarks)
+	if err != nil {
+		return fmt.Errorf("load %s checkpoints: %w", table.name, err)
+	}
+	query := fmt.Sprintf(`
+SELECT id, metadata_id, start_ts, mean, min, max, last_reset_ts, state, sum
+FROM %s
+WHERE %s
+ORDER BY id
+`, source, incremental)
+	rows, err := sqliteDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("query sqlite database: %w", err)
+	}
+	defer rows.Close()
+
+	writer := newBatchWriter(sink, table, statisticsBatchSize)
+
+	for rows.Next() {
+		var (
+			id          int64
+			metadataID  int64
+			startVal    sql.NullFloat64
+			mean        sql.NullFloat64
+			minVal      sql.NullFloat64
+			maxVal      sql.NullFloat64
+			lastResetTS sql.NullFloat64
+			state       sql.NullFloat64
+			sum         sql.NullFloat64
+		)
+		if err := rows.Scan(&id, &metadataID, &startVal, &mean, &minVal, &maxVal, &lastResetTS, &state, &sum); err != nil {
+			return fmt.Errorf("scan sqlite row: %w", err)
+		}
+
+		var statisticID sql.NullString
+		if v, ok := statisticIDs[metadataID]; ok {
+			statisticID = sql.NullString{String: v, Valid: true}
+		}
+		// Rejected statistics rows are recorded under their statistic_id (or metadata_id when
+		// statistics_meta has none) with start_ts standing in for the time.
+		series := strconv.FormatInt(metadataID, 10)
+		if statisticID.Valid {
+			series = statisticID.String
+		}
+		raw := rejectedRow{stateID: id, entityID: series, lastUpdatedTS: startVal}
+
+		start, err := floatToNullTime(startVal)
+		if err != nil {
+			if err := writer.Reject(ctx, raw, fmt.Errorf("convert start_ts for id %d: %w", id, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		if start.Valid && exportedBefore(watermarks, nil, strconv.FormatInt(metadataID, 10), start.Time, id) {
+			exportedRows.add(table.name, 1)
+			continue
+		}
+		lastReset, err := floatToNullTime(lastResetTS)
+		if err != nil {
+			if err := writer.Reject(ctx, raw, fmt.Errorf("convert last_reset_ts for id %d: %w", id, err)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := writer.Add(ctx, id, metadataID, statisticID, start, mean, minVal, maxVal, lastReset, state, sum); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate sqlite rows: %w", err)
+	}
+
+	if err := writer.Flush(ctx); err != nil {
+		return err
+	}
+
+	return finalizeTable(ctx, sink, table)
+}
+
+var statisticsMetaTable = &tableSpec{
+	name: "statistics_meta",
+	columns: []columnSpec{
+		{name: "id", sqlType: "BIGINT NOT NULL"},
+		{name: "statistic_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "source", sqlType: "VARCHAR(32) NULL"},
+		{name: "unit_of_measurement", sqlType: "VARCHAR(255) NULL"},
+		{name: "has_mean", sqlType: "BOOLEAN NULL"},
+		{name: "has_sum", sqlType: "BOOLEAN NULL"},
+		{name: "name", sqlType: "VARCHAR(255) NULL"},
+	},
+	primaryKey: []string{"id"},
+	uniqueKeys: [][]string{{"statistic_id"}},
+}
+
+// statisticsPointsTable describes a statistics destination table; metadata_id identifies the series.
+func statisticsPointsTable(name string) *tableSpec {
+	return &tableSpec{
+		name: name,
+		columns: []columnSpec{
+			{name: "id", sqlType: "BIGINT NOT NULL"},
+			{name: "metadata_id", sqlType: "BIGINT NOT NULL"},
+			{name: "statistic_id", sqlType: "VARCHAR(255) NULL"},
+			{name: "start", sqlType: "DATETIME(6) NULL"},
+			{name: "mean", sqlType: "DOUBLE NULL"},
+			{name: "min", sqlType: "DOUBLE NULL"},
+			{name: "max", sqlType: "DOUBLE NULL"},
+			{name: "last_reset", sqlType: "DATETIME(6) NULL"},
+			{name: "state", sqlType: "DOUBLE NULL"},
+			{name: "sum", sqlType: "DOUBLE NULL"},
+		},
+		primaryKey:    []string{"id"},
+		entityColumn:  "metadata_id",
+		timeColumn:    "start",
+		indexDefaults: []string{"entity-time"},
+		history:       true,
+	}
+}
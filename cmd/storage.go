@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// storageDir is the --storage-dir flag: Home Assistant's .storage directory holding the registries.
+// Empty means the .storage directory next to the recorder.
+var storageDir string
+
+// homeAssistantStorageDir resolves the .storage directory for a recorder path.
+func homeAssistantStorageDir(sqlitePath string) string {
+	if storageDir != "" {
+		return storageDir
+	}
+	return filepath.Join(filepath.Dir(strings.TrimPrefix(sqlitePath, "file:")), ".storage")
+}
+
+// readStorageFile decodes the data member of a .storage JSON file into data. A missing file is
+// reported as fs.ErrNotExist so callers can fall back to other sources.
+func readStorageFile(dir, name string, data any) error {
+	path := filepath.Join(dir, name)
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+		}
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	envelope := struct {
+		Data any `json:"data"`
+	}{Data: data}
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// registryEntity is one entry of core.entity_registry.
+type registryEntity struct {
+	EntityID            string  `json:"entity_id"`
+	DeviceID            *string `json:"device_id"`
+	AreaID              *string `json:"area_id"`
+	Platform            string  `json:"platform"`
+	UniqueID            string  `json:"unique_id"`
+	Name                *string `json:"name"`
+	OriginalName        *string `json:"original_name"`
+	DeviceClass         *string `json:"device_class"`
+	OriginalDeviceClass *string `json:"original_device_class"`
+	UnitOfMeasurement   *string `json:"unit_of_measurement"`
+	DisabledBy          *string `json:"disabled_by"`
+	HiddenBy            *string `json:"hidden_by"`
+}
+
+func loadEntityRegistry(dir string) ([]registryEntity, error) {
+	var data struct {
+		Entities []registryEntity `json:"entities"`
+	}
+	if err := readStorageFile(dir, "core.entity_registry", &data); err != nil {
+		return nil, err
+	}
+	return data.Entities, nil
+}
+
+// registryDevice is one entry of core.device_registry.
+type registryDevice struct {
+	ID           string  `json:"id"`
+	Name         *string `json:"name"`
+	NameByUser   *string `json:"name_by_user"`
+	Manufacturer *string `json:"manufacturer"`
+	Model        *string `json:"model"`
+	SWVersion    *string `json:"sw_version"`
+	HWVersion    *string `json:"hw_version"`
+	AreaID       *string `json:"area_id"`
+	ViaDeviceID  *string `json:"via_device_id"`
+	DisabledBy   *string `json:"disabled_by"`
+}
+
+func loadDeviceRegistry(dir string) ([]registryDevice, error) {
+	var data struct {
+		Devices []registryDevice `json:"devices"`
+	}
+	if err := readStorageFile(dir, "core.device_registry", &data); err != nil {
+		return nil, err
+	}
+	return data.Devices, nil
+}
+
+// registryArea is one entry of core.area_registry.
+type registryArea struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	FloorID *string `json:"floor_id"`
+}
+
+func loadAreaRegistry(dir string) ([]registryArea, error) {
+	var data struct {
+		Areas []registryArea `json:"areas"`
+	}
+	if err := readStorageFile(dir, "core.area_registry", &data); err != nil {
+		return nil, err
+	}
+	return data.Areas, nil
+}
+
+// storagePerson is one person from the person storage file.
+type storagePerson struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	DeviceTrackers []string `json:"device_trackers"`
+}
+
+func loadPersons(dir string) ([]storagePerson, error) {
+	var data struct {
+		Items []storagePerson `json:"items"`
+	}
+	if err := readStorageFile(dir, "person", &data); err != nil {
+		return nil, err
+	}
+	return data.Items, nil
+}
+
+// slugify approximates Home Assistant's slugify for ASCII names, e.g. "Alice B." -> "alice_b".
+func slugify(name string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			underscore = false
+			continue
+		}
+		if !underscore && b.Len() > 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
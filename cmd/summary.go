@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"ha-tools/haerrors"
+)
+
+// Exit codes let orchestrators tell a clean run from one that worked but lost data.
+const (
+	exitOK = 0
+	// exitFailed: the command failed.
+	exitFailed = 1
+	// exitSkippedRows: the command completed, but --on-error skipped rows.
+	exitSkippedRows = 2
+	// exitSchemaDrift: a destination table differs from the layout ha-tools writes and will not be
+	// changed in place.
+	exitSchemaDrift = 3
+	// exitRunTimeout: --run-timeout stopped the command at a checkpoint; the next run resumes.
+	exitRunTimeout = 4
+	// exitVerificationFailed: a verification (checksum) found differences.
+	exitVerificationFailed = 5
+	// exitSourceLocked: another process held the recorder locked past the busy timeout; try again
+	// later.
+	exitSourceLocked = 6
+)
+
+// exitStatuses names the exit codes in the --summary-file.
+var exitStatuses = map[int]string{
+	exitOK:                 "ok",
+	exitFailed:             "failed",
+	exitSkippedRows:        "skipped_rows",
+	exitSchemaDrift:        "schema_drift",
+	exitRunTimeout:         "run_timeout",
+	exitVerificationFailed: "verification_failed",
+	exitSourceLocked:       "source_locked",
+}
+
+// summaryPath is the --summary-file flag.
+var summaryPath string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&summaryPath, "summary-file", "", "Write a JSON summary of the run (exit code; rows written, already exported, filtered, and merged per table; rows skipped) to this file when the command ends")
+}
+
+// errSchemaDrift marks a destination table whose layout ha-tools will not migrate in place.
+func errSchemaDrift(table, format string, args ...any) error {
+	return &haerrors.SchemaDriftError{Table: table, Err: fmt.Errorf(format, args...)}
+}
+
+// verificationError marks a verification that ran but found differences.
+type verificationError struct {
+	err error
+}
+
+func (e *verificationError) Error() string { return e.err.Error() }
+func (e *verificationError) Unwrap() error { return e.err }
+
+// exitCode maps the command's outcome to its exit code.
+func exitCode(err error, skipped int) int {
+	var (
+		verify  *verificationError
+		timeout *timeoutError
+	)
+	switch {
+	case err == nil && skipped > 0:
+		return exitSkippedRows
+	case err == nil:
+		return exitOK
+	case errors.Is(err, haerrors.ErrSchemaDrift):
+		return exitSchemaDrift
+	case errors.Is(err, haerrors.ErrSourceLocked):
+		return exitSourceLocked
+	case errors.As(err, &verify):
+		return exitVerificationFailed
+	case errors.As(err, &timeout) && timeout.flag == "--run-timeout":
+		return exitRunTimeout
+	}
+	return exitFailed
+}
+
+// rowCounter counts rows per table during this run.
+type rowCounter struct {
+	mu     sync.Mutex
+	tables map[string]int64
+}
+
+func (c *rowCounter) add(table string, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tables == nil {
+		c.tables = make(map[string]int64)
+	}
+	c.tables[table] += int64(n)
+}
+
+// counts returns a copy of the counts and their sum.
+func (c *rowCounter) counts() (map[string]int64, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int64, len(c.tables))
+	var sum int64
+	for table, n := range c.tables {
+		counts[table] = n
+		sum += n
+	}
+	return counts, sum
+}
+
+var (
+	// writtenRows counts the rows sinks accepted.
+	writtenRows rowCounter
+	// exportedRows counts the recorder rows skipped because an earlier run exported them, going by
+	// the watermarks.
+	exportedRows rowCounter
+	// filteredRows counts the rows left out on purpose: states that are unavailable, unknown, or
+	// not numbers where numbers are exported, entities --only-entities-owned-by excludes, rows
+	// without a battery level or coordinates, points within --min-movement, and rows --transform
+	// drops.
+	filteredRows rowCounter
+	// mergedRows counts the samples aggregation folded into another sample's row: a bucket of n
+	// samples merges n-1.
+	mergedRows rowCounter
+)
+
+// runSummary is the --summary-file document.
+type runSummary struct {
+	Command         string           `json:"command"`
+	StartedAt       time.Time        `json:"started_at"`
+	FinishedAt      time.Time        `json:"finished_at"`
+	DurationSeconds float64          `json:"duration_seconds"`
+	ExitCode        int              `json:"exit_code"`
+	Status          string           `json:"status"`
+	Error           string           `json:"error,omitempty"`
+	RowsWritten     map[string]int64 `json:"rows_written"`
+	RowsWrittenSum  int64            `json:"rows_written_total"`
+	RowsSkipped     int              `json:"rows_skipped"`
+	DeadLetter      string           `json:"dead_letter,omitempty"`
+	// RowsExported, RowsFiltered, and RowsMerged count per table the rows not written because an
+	// earlier run exported them, because filters left them out, or because aggregation merged them.
+	RowsExported map[string]int64 `json:"rows_already_exported"`
+	RowsFiltered map[string]int64 `json:"rows_filtered"`
+	RowsMerged   map[string]int64 `json:"rows_merged"`
+}
+
+// writeRunSummary writes the summary to --summary-file.
+func writeRunSummary(command string, started time.Time, code int, err error, skipped int) error {
+	summary := runSummary{
+		Command:         command,
+		StartedAt:       started.UTC(),
+		FinishedAt:      time.Now().UTC(),
+		DurationSeconds: time.Since(started).Seconds(),
+		ExitCode:        code,
+		Status:          exitStatuses[code],
+		RowsSkipped:     skipped,
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	summary.RowsWritten, summary.RowsWrittenSum = writtenRows.counts()
+	summary.RowsExported, _ = exportedRows.counts()
+	summary.RowsFiltered, _ = filteredRows.counts()
+	summary.RowsMerged, _ = mergedRows.counts()
+	if skipped > 0 && onError == onErrorCollect {
+		summary.DeadLetter = deadLetterPath
+	}
+
+	raw, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(summaryPath, append(raw, '\n')); err != nil {
+		return fmt.Errorf("write summary file: %w", err)
+	}
+	return nil
+}
+
+// replaceFile writes data to path through a temporary file and a rename, so readers never see a
+// partial file.
+func replaceFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".ha-tools-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// tariffConfig is the config's time-of-use tariff: the windows --tariff-split divides meter
+// consumption into and prices it by, e.g.
+//
+//	{"windows": [
+//	  {"name": "weekend", "days": ["sat", "sun"], "price": 0.22},
+//	  {"name": "peak", "from": "07:00", "to": "23:00", "price": 0.34},
+//	  {"name": "offpeak", "price": 0.22}
+//	]}
+type tariffConfig struct {
+	Windows []*tariffWindow `json:"windows"`
+}
+
+// tariffWindow is one window of a tariff. A time belongs to the first window holding it; one
+// without days holds every day and one without from and to every hour, so the last window can
+// take the rest.
+type tariffWindow struct {
+	Name string `json:"name"`
+	// Days are the weekdays (mon ... sun) the window applies on, by the local calendar day.
+	Days []string `json:"days"`
+	// From and To bound the window in local time (HH:MM, To exclusive); a To before From wraps
+	// past midnight.
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Price is per kWh, or per unit of meters not measuring energy (m³ of water or gas).
+	Price *float64 `json:"price"`
+
+	days     [7]bool
+	from, to int
+	allDay   bool
+}
+
+var tariffWindowName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+var tariffWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func (t *tariffConfig) validate() error {
+	if len(t.Windows) == 0 {
+		return errors.New("tariff: add at least one window")
+	}
+	seen := make(map[string]bool)
+	for i, w := range t.Windows {
+		if w == nil || !tariffWindowName.MatchString(w.Name) {
+			return fmt.Errorf("tariff.windows[%d]: name must be lowercase letters, digits, and _", i)
+		}
+		if seen[w.Name] {
+			return fmt.Errorf("tariff.windows[%d]: duplicate name %q", i, w.Name)
+		}
+		seen[w.Name] = true
+
+		if len(w.Days) == 0 {
+			w.days = [7]bool{true, true, true, true, true, true, true}
+		}
+		for _, day := range w.Days {
+			weekday, ok := tariffWeekdays[strings.ToLower(day)]
+			if !ok {
+				return fmt.Errorf("tariff.windows[%d]: unknown day %q (use mon, tue, wed, thu, fri, sat, sun)", i, day)
+			}
+			w.days[weekday] = true
+		}
+
+		switch {
+		case w.From == "" && w.To == "":
+			w.allDay = true
+		case w.From == "" || w.To == "":
+			return fmt.Errorf("tariff.windows[%d]: set both from and to, or neither", i)
+		default:
+			var err error
+			if w.from, err = parseTariffClock(w.From); err != nil {
+				return fmt.Errorf("tariff.windows[%d].from: %w", i, err)
+			}
+			if w.to, err = parseTariffClock(w.To); err != nil {
+				return fmt.Errorf("tariff.windows[%d].to: %w", i, err)
+			}
+			if w.from == w.to {
+				return fmt.Errorf("tariff.windows[%d]: from and to are equal; leave both out for the whole day", i)
+			}
+		}
+		if w.Price != nil && *w.Price < 0 {
+			return fmt.Errorf("tariff.windows[%d]: price must not be negative", i)
+		}
+	}
+	return nil
+}
+
+// parseTariffClock returns the minute of the day of an HH:MM time; 24:00 is the end of the day.
+func parseTariffClock(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("want HH:MM, got %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// window returns the index of the window holding t, in the export zone, or -1 when none does.
+func (t *tariffConfig) window(at time.Time) int {
+	at = inExportZone(at)
+	minute := at.Hour()*60 + at.Minute()
+	for i, w := range t.Windows {
+		if !w.days[at.Weekday()] {
+			continue
+		}
+		switch {
+		case w.allDay:
+		case w.from < w.to:
+			if minute < w.from || minute >= w.to {
+				continue
+			}
+		default:
+			if minute < w.from && minute >= w.to {
+				continue
+			}
+		}
+		return i
+	}
+	return -1
+}
+
+// priced reports whether every window has a price, so consumption can be costed.
+func (t *tariffConfig) priced() bool {
+	for _, w := range t.Windows {
+		if w.Price == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// cost prices consumption split by window, in the meter's unit.
+func (t *tariffConfig) cost(windows []float64, unit string) float64 {
+	var cost float64
+	for i, w := range t.Windows {
+		cost += windows[i] * *w.Price * kwhFactor(unit)
+	}
+	return cost
+}
+
+// kwhFactor converts an energy unit into kWh; other units are priced as they are.
+func kwhFactor(unit string) float64 {
+	switch unit {
+	case "Wh":
+		return 0.001
+	case "MWh":
+		return 1000
+	default:
+		return 1
+	}
+}
+
+const tariffSplitUsage = "Split the daily meter consumption into the windows of the config's tariff, with a column and cost per window, and roll it up per month into <name>_monthly"
+
+// addTariffSplitFlag registers --tariff-split on an exporter with daily meter rollups.
+func addTariffSplitFlag(cmd *cobra.Command, split *bool) {
+	cmd.Flags().BoolVar(split, "tariff-split", false, tariffSplitUsage)
+}
+
+// tariffColumns are the columns --tariff-split adds to the meter rollups: the consumption in
+// each window, and its cost when every window has a price.
+func tariffColumns(t *tariffConfig) []columnSpec {
+	columns := make([]columnSpec, 0, len(t.Windows)+1)
+	for _, w := range t.Windows {
+		columns = append(columns, columnSpec{name: w.Name + "_consumption", sqlType: "DOUBLE NOT NULL DEFAULT 0"})
+	}
+	return append(columns, columnSpec{name: "cost", sqlType: "DOUBLE NULL"})
+}
+
+// meterMonthlyTable rolls <name>_daily up per entity and calendar month (the month's first day).
+func meterMonthlyTable(f numericFamily, t *tariffConfig) *tableSpec {
+	columns := []columnSpec{
+		{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "month", sqlType: "DATE NOT NULL"},
+		{name: "unit", sqlType: "VARCHAR(64) NULL"},
+		{name: "days", sqlType: "INT NOT NULL"},
+		{name: "consumption", sqlType: "DOUBLE NOT NULL"},
+	}
+	return &tableSpec{
+		name:       f.name + "_monthly",
+		columns:    append(columns, tariffColumns(t)...),
+		primaryKey: []string{"entity_id", "month"},
+	}
+}
+
+// refreshMeterMonthly recomputes the months touched since the given time from <name>_daily.
+func refreshMeterMonthly(ctx context.Context, sink Sink, f numericFamily, t *tariffConfig, since time.Time) error {
+	sums := make([]string, 0, len(t.Windows)+1)
+	for _, c := range tariffColumns(t) {
+		sums = append(sums, "SUM("+c.name+")")
+	}
+	query := `
+SELECT entity_id, DATE_SUB(day, INTERVAL DAYOFMONTH(day) - 1 DAY) AS month, MAX(unit), COUNT(*), SUM(consumption), ` + strings.Join(sums, ", ") + `
+FROM ` + meterDailyTable(f, t).name + `
+WHERE day >= ?
+GROUP BY entity_id, month
+`
+	monthStart := time.Date(since.Year(), since.Month(), 1, 0, 0, 0, 0, since.Location())
+	return refreshRollup(ctx, sink, meterMonthlyTable(f, t), query, monthStart)
+}
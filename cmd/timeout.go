@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// queryTimeout bounds each destination statement; runTimeout bounds the whole command. Zero
+// disables either.
+var (
+	queryTimeout time.Duration
+	runTimeout   time.Duration
+)
+
+// runDeadline is when --run-timeout expires. The command context is only cancelled
+// runTimeoutGrace later, so the writer can flush its last batch and stop at a checkpoint first.
+var (
+	runDeadline time.Time
+	runCancel   context.CancelFunc = func() {}
+)
+
+const runTimeoutGrace = time.Minute
+
+func init() {
+	rootCmd.PersistentFlags().DurationVar(&queryTimeout, "query-timeout", 0, "Cancel any single destination statement running longer than this (e.g. 2m; 0 = no limit)")
+	rootCmd.PersistentFlags().DurationVar(&runTimeout, "run-timeout", 0, "Stop the command after this long at the next batch boundary (e.g. 1h; 0 = no limit); the next run resumes from the watermarks")
+}
+
+// timeoutError reports which flag's limit was hit.
+type timeoutError struct {
+	flag  string
+	limit time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	if e.flag == "--run-timeout" {
+		return fmt.Sprintf("stopped after %s %s; rows written so far are kept and the next run resumes from there", e.flag, e.limit)
+	}
+	return fmt.Sprintf("statement exceeded %s %s", e.flag, e.limit)
+}
+
+// startRunTimeout installs the --run-timeout deadline on ctx.
+func startRunTimeout(ctx context.Context) context.Context {
+	if runTimeout <= 0 {
+		return ctx
+	}
+	runDeadline = time.Now().Add(runTimeout)
+	ctx, runCancel = context.WithDeadlineCause(ctx, runDeadline.Add(runTimeoutGrace), &timeoutError{flag: "--run-timeout", limit: runTimeout})
+	return ctx
+}
+
+// runExpired reports whether --run-timeout has passed; long loops check it at batch boundaries.
+func runExpired() bool {
+	return !runDeadline.IsZero() && time.Now().After(runDeadline)
+}
+
+func errRunTimeout() error {
+	return &timeoutError{flag: "--run-timeout", limit: runTimeout}
+}
+
+// withStatementTimeout derives the context of one destination statement from ctx.
+func withStatementTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, queryTimeout, &timeoutError{flag: "--query-timeout", limit: queryTimeout})
+}
+
+// explainTimeout replaces the driver's bare "context deadline exceeded" with the limit that
+// cancelled ctx, keeping err in the chain.
+func explainTimeout(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	var te *timeoutError
+	if cause := context.Cause(ctx); errors.As(cause, &te) {
+		return fmt.Errorf("%w: %w", te, err)
+	}
+	return err
+}
+
+// execStatement runs one destination statement under --query-timeout.
+func execStatement(ctx context.Context, db *sql.DB, query string, args ...any) (sql.Result, error) {
+	ctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	res, err := db.ExecContext(ctx, query, args...)
+	return res, explainTimeout(ctx, err)
+}
+
+// queryRowStatement runs a single-row destination query under --query-timeout and scans it.
+func queryRowStatement(ctx context.Context, db *sql.DB, query string, args []any, dest ...any) error {
+	ctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	return explainTimeout(ctx, db.QueryRowContext(ctx, query, args...).Scan(dest...))
+}
@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	topSQLitePath string
+	topMySQLDSN   string
+	topTables     []string
+	topInterval   time.Duration
+	topLimit      int
+	topOnce       bool
+)
+
+// topCmd shows the export pipeline's progress per entity, refreshing in place.
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Live dashboard of export rows/sec, lag, and errors per entity",
+	Long:  "Polls the recorder and the destination every --interval and redraws a table of every exported entity: rows in the destination, rows written per second since the previous poll, lag (the recorder's newest state minus the newest exported row), and errors (rows in ha_tools_rejects plus entries in the --dead-letter file). Run it next to watch or the add-on to see whether the sync keeps up; entities lagging most come first. Ctrl-C quits.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if topSQLitePath == "" {
+			return errors.New("sqlite database path is required")
+		}
+		if topMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+		if topInterval <= 0 {
+			return errors.New("--interval must be positive")
+		}
+		if !destDialect.mysqlProtocol {
+			return fmt.Errorf("top reads the destination over the MySQL protocol, which the %s dialect does not speak", destDialect.name)
+		}
+		tables := lagTables(topTables)
+		if err := validateLagTables(tables); err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		sqliteDB, err := openRecorder(ctx, topSQLitePath)
+		if err != nil {
+			return err
+		}
+		defer sqliteDB.Close()
+		db, err := openDestination(ctx, topMySQLDSN)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		out := cmd.OutOrStdout()
+		redraw := !topOnce && isTerminal(out)
+		var previous *topSnapshot
+		for {
+			snapshot, err := sampleTop(ctx, sqliteDB, db, tables)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			if redraw {
+				// Move home and clear, so the table updates in place.
+				fmt.Fprint(out, "\x1b[H\x1b[2J")
+			}
+			renderTop(out, snapshot, previous, topLimit)
+			if topOnce {
+				return nil
+			}
+			if !redraw {
+				fmt.Fprintln(out)
+			}
+			previous = snapshot
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(topInterval):
+			}
+		}
+	},
+}
+
+func init() {
+	topCmd.Flags().StringVar(&topSQLitePath, "sqlite", "", "Path to the Home Assistant SQLite recorder database")
+	topCmd.Flags().StringVar(&topMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	topCmd.Flags().StringArrayVar(&topTables, "table", nil, "Destination table to watch (default: the exporters' history tables and the config's route tables). Repeatable")
+	topCmd.Flags().DurationVar(&topInterval, "interval", 2*time.Second, "How often to poll and redraw")
+	topCmd.Flags().IntVar(&topLimit, "limit", 40, "Entities shown, most lagging first (0 = all)")
+	topCmd.Flags().BoolVar(&topOnce, "once", false, "Print one snapshot and exit")
+	_ = topCmd.MarkFlagRequired("sqlite")
+	_ = topCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(topCmd)
+}
+
+// topEntity is one line of the dashboard.
+type topEntity struct {
+	*entityLag
+	errors int64
+}
+
+type topSnapshot struct {
+	at       time.Time
+	entities map[lagKey]*topEntity
+}
+
+// sampleTop measures the lag and adds the errors recorded per entity: rows in ha_tools_rejects
+// and entries in the --dead-letter file.
+func sampleTop(ctx context.Context, sqliteDB, db *sql.DB, tables []string) (*topSnapshot, error) {
+	snapshot := &topSnapshot{at: time.Now(), entities: make(map[lagKey]*topEntity)}
+	lags, err := measureLag(ctx, sqliteDB, db, tables)
+	if err != nil {
+		return nil, err
+	}
+	for key, l := range lags {
+		snapshot.entities[key] = &topEntity{entityLag: l}
+	}
+
+	err = queryRows(ctx, db, "SELECT target_table, entity_id, COUNT(*) FROM "+rejectsTable.name+" GROUP BY target_table, entity_id", func(scan func(dest ...any) error) error {
+		var (
+			key   lagKey
+			count int64
+		)
+		if err := scan(&key.table, &key.entityID, &count); err != nil {
+			return err
+		}
+		if e, ok := snapshot.entities[key]; ok {
+			e.errors += count
+		}
+		return nil
+	})
+	if err != nil && !isMySQLError(err, mysqlErrNoSuchTable) {
+		return nil, fmt.Errorf("read %s: %w", rejectsTable.name, err)
+	}
+
+	deadLetters, err := countDeadLetters(deadLetterPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range snapshot.entities {
+		e.errors += deadLetters[e.entityID]
+	}
+	return snapshot, nil
+}
+
+// countDeadLetters counts the --dead-letter entries per entity; a missing file has none.
+func countDeadLetters(path string) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return counts, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read dead-letter report: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry deadLetter
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			counts[entry.EntityID]++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read dead-letter report: %w", err)
+	}
+	return counts, nil
+}
+
+// renderTop writes the dashboard: a summary line, then one line per entity, most lagging first.
+// Rates compare against the previous snapshot and are blank on the first one.
+func renderTop(w io.Writer, snapshot, previous *topSnapshot, limit int) {
+	entities := make([]*topEntity, 0, len(snapshot.entities))
+	var (
+		maxLag    time.Duration
+		maxLagKey lagKey
+		errs      int64
+	)
+	for _, e := range snapshot.entities {
+		entities = append(entities, e)
+		if lag, ok := e.lag(); ok && lag > maxLag {
+			maxLag, maxLagKey = lag, e.lagKey
+		}
+		errs += e.errors
+	}
+	sort.Slice(entities, func(i, j int) bool {
+		li, _ := entities[i].lag()
+		lj, _ := entities[j].lag()
+		if li != lj {
+			return li > lj
+		}
+		if entities[i].table != entities[j].table {
+			return entities[i].table < entities[j].table
+		}
+		return entities[i].entityID < entities[j].entityID
+	})
+
+	summary := fmt.Sprintf("ha-tools top  %s  every %s  %d entities  %d errors", snapshot.at.Format(time.DateTime), topInterval, len(entities), errs)
+	if maxLag > 0 {
+		summary += fmt.Sprintf("  max lag %s (%s)", formatLag(maxLag), maxLagKey.entityID)
+	}
+	fmt.Fprintln(w, summary)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tENTITY\tROWS\tROWS/S\tLAG\tERRORS")
+	for i, e := range entities {
+		if limit > 0 && i == limit {
+			fmt.Fprintf(tw, "\t(%d more)\n", len(entities)-limit)
+			break
+		}
+		rate := "-"
+		if previous != nil {
+			if before, ok := previous.entities[e.lagKey]; ok {
+				if elapsed := snapshot.at.Sub(previous.at).Seconds(); elapsed > 0 {
+					rate = fmt.Sprintf("%.1f", float64(e.rows-before.rows)/elapsed)
+				}
+			}
+		}
+		lag := "-"
+		if d, ok := e.lag(); ok {
+			lag = formatLag(d)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%d\n", e.table, e.entityID, e.rows, rate, lag, e.errors)
+	}
+	tw.Flush()
+}
+
+// isTerminal reports whether w is an interactive terminal, where top redraws in place.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
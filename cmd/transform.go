@@ -0,0 +1,286 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// transformCommands is the --transform flag: shell commands every written row passes through, in
+// order.
+var transformCommands []string
+
+func init() {
+	rootCmd.PersistentFlags().StringArrayVar(&transformCommands, "transform", nil, "Shell command transforming rows before they are written: it reads one JSON object per row on stdin and answers each with the (changed) row or null to drop it. Repeatable; applied in order")
+}
+
+// transformProcess is one --transform command, started on the first batch and fed every batch of
+// the run. The protocol is NDJSON in both directions: each input line is a row as the ndjson sink
+// writes it ("table" plus one member per write column), and the command answers each line, in
+// order, with one line holding the row to write or null to drop it. Members missing from the
+// answer are written as NULL; "table" is ignored.
+type transformProcess struct {
+	mu      sync.Mutex
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+	// stderr keeps the tail of the command's error output for error messages; it is also copied
+	// to our stderr.
+	stderr *tailBuffer
+}
+
+var transforms struct {
+	mu        sync.Mutex
+	processes []*transformProcess
+}
+
+// transformRows passes a batch through every --transform command and returns the rows to write.
+// Rows whose answer does not fit the table's columns follow --on-error.
+func transformRows(table *tableSpec, rows [][]any) ([][]any, error) {
+	if len(transformCommands) == 0 || table == rejectsTable {
+		return rows, nil
+	}
+	processes, err := startTransforms()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range processes {
+		if rows, err = p.apply(table, rows); err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+	}
+	return rows, nil
+}
+
+func startTransforms() ([]*transformProcess, error) {
+	transforms.mu.Lock()
+	defer transforms.mu.Unlock()
+	if transforms.processes != nil {
+		return transforms.processes, nil
+	}
+	processes := make([]*transformProcess, 0, len(transformCommands))
+	for _, command := range transformCommands {
+		p := &transformProcess{command: command, stderr: &tailBuffer{limit: 4096}}
+		p.cmd = exec.Command("sh", "-c", command)
+		p.cmd.Stderr = io.MultiWriter(os.Stderr, p.stderr)
+		// The shell may leave the command running as its child after being killed; do not wait for
+		// the orphan to close the pipes.
+		p.cmd.WaitDelay = time.Second
+		stdin, err := p.cmd.StdinPipe()
+		if err != nil {
+			return nil, fmt.Errorf("--transform %q: %w", command, err)
+		}
+		stdout, err := p.cmd.StdoutPipe()
+		if err != nil {
+			return nil, fmt.Errorf("--transform %q: %w", command, err)
+		}
+		if err := p.cmd.Start(); err != nil {
+			for _, started := range processes {
+				started.close()
+			}
+			return nil, fmt.Errorf("--transform %q: start: %w", command, err)
+		}
+		p.stdin, p.stdout = stdin, bufio.NewReaderSize(stdout, 64*1024)
+		processes = append(processes, p)
+	}
+	transforms.processes = processes
+	return processes, nil
+}
+
+// apply sends the batch to the command and reads its answers. Rows are written from a goroutine
+// so a command answering line by line never blocks on a full pipe.
+func (p *transformProcess) apply(table *tableSpec, rows [][]any) ([][]any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	columns := table.writeColumns()
+	lines := make([][]byte, len(rows))
+	for i, row := range rows {
+		record := make(map[string]any, len(columns)+1)
+		record["table"] = table.name
+		for j, column := range columns {
+			value, err := jsonValue(row[j])
+			if err != nil {
+				return nil, fmt.Errorf("--transform: encode %s.%s: %w", table.name, column, err)
+			}
+			record[column] = value
+		}
+		line, err := json.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("--transform: encode %s row: %w", table.name, err)
+		}
+		lines[i] = append(line, '\n')
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		for _, line := range lines {
+			if _, err := p.stdin.Write(line); err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- nil
+	}()
+
+	specs := make([]columnSpec, 0, len(columns))
+	for _, c := range table.columns {
+		if !c.generated {
+			specs = append(specs, resolveColumn(c))
+		}
+	}
+	kept := rows[:0:0]
+	for i, row := range rows {
+		answer, err := p.stdout.ReadBytes('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = errors.New("command closed its output")
+			}
+			<-written
+			return nil, p.failed(fmt.Errorf("answer %d of %d for %s: %w", i+1, len(rows), table.name, err))
+		}
+		values, err := parseTransformAnswer(specs, answer)
+		if err != nil {
+			var (
+				entityID string
+				stateID  int64
+			)
+			if j := indexOfColumn(specs, table.entityColumn); j >= 0 && row[j] != nil {
+				entityID = fmt.Sprint(row[j])
+			}
+			if j := indexOfColumn(specs, "state_id"); j >= 0 {
+				stateID, _ = row[j].(int64)
+			}
+			if err := skipBadRow(entityID, stateID, fmt.Errorf("--transform %q: %s: %w", p.command, table.name, err)); err != nil {
+				// The rest of the batch is unread, so stop the command rather than wait for it.
+				p.cmd.Process.Kill()
+				p.close()
+				<-written
+				return nil, err
+			}
+			continue
+		}
+		if values != nil {
+			kept = append(kept, values)
+		}
+	}
+	if err := <-written; err != nil {
+		return nil, p.failed(err)
+	}
+	return kept, nil
+}
+
+// parseTransformAnswer converts one answer line to row values, or nil for a dropped row.
+func parseTransformAnswer(columns []columnSpec, answer []byte) ([]any, error) {
+	answer = bytes.TrimSpace(answer)
+	if string(answer) == "null" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(answer))
+	dec.UseNumber()
+	var object map[string]any
+	if err := dec.Decode(&object); err != nil {
+		return nil, fmt.Errorf("answer is not a JSON object or null: %w", err)
+	}
+	if object == nil {
+		return nil, errors.New("answer is not a JSON object or null")
+	}
+	delete(object, "table")
+	values := make([]any, len(columns))
+	for i, c := range columns {
+		field := importField{null: true}
+		switch v := object[c.name].(type) {
+		case nil:
+		case string:
+			field = importField{raw: v}
+		case json.Number:
+			field = importField{raw: v.String()}
+		case bool:
+			field = importField{raw: strconv.FormatBool(v)}
+		default:
+			return nil, fmt.Errorf("%s must be a string, number, boolean, or null", c.name)
+		}
+		delete(object, c.name)
+		value, err := parseImportValue(c, field)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.name, err)
+		}
+		values[i] = value
+	}
+	for name := range object {
+		return nil, fmt.Errorf("unknown column %s", name)
+	}
+	return values, nil
+}
+
+// failed stops the command after a protocol error and explains the failure with its exit status
+// and the tail of its error output.
+func (p *transformProcess) failed(err error) error {
+	waitErr := p.close()
+	msg := fmt.Sprintf("--transform %q: %v", p.command, err)
+	if waitErr != nil {
+		msg += fmt.Sprintf(" (%v)", waitErr)
+	}
+	if tail := bytes.TrimSpace(p.stderr.Bytes()); len(tail) > 0 {
+		msg += ": " + string(tail)
+	}
+	return errors.New(msg)
+}
+
+func (p *transformProcess) close() error {
+	p.stdin.Close()
+	return p.cmd.Wait()
+}
+
+// closeTransforms ends the transform commands by closing their stdin and waits for them; a command
+// exiting non-zero fails the run.
+func closeTransforms() error {
+	transforms.mu.Lock()
+	defer transforms.mu.Unlock()
+	var errs []error
+	for _, p := range transforms.processes {
+		if p.cmd.ProcessState != nil {
+			continue
+		}
+		if err := p.close(); err != nil {
+			errs = append(errs, fmt.Errorf("--transform %q: %w", p.command, err))
+		}
+	}
+	transforms.processes = nil
+	return errors.Join(errs...)
+}
+
+// tailBuffer keeps the last limit bytes written to it.
+type tailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	buf   []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.limit {
+		t.buf = t.buf[len(t.buf)-t.limit:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) Bytes() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]byte(nil), t.buf...)
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+)
+
+// upsertStatement holds the fragments of a multi-row idempotent INSERT statement; callers join
+// prefix, one placeholder per row, and suffix, then pass the result through the dialect's rebind.
+type upsertStatement struct {
+	prefix      string
+	suffix      string
+	placeholder string
+}
+
+// newWriteStatement builds the statement sinks write the table's rows with, following
+// --write-mode.
+func (d *sqlDialect) newWriteStatement(table *tableSpec) upsertStatement {
+	columns := table.writeColumns()
+	switch mode := tableWriteMode(table); mode {
+	case writeModeAppend, writeModeInsert:
+		return d.newAppendStatement(table.name, columns, table.writePlaceholders(), table.conflictKey(), mode == writeModeAppend)
+	}
+	var newerColumn string
+	if table.newerOnly {
+		newerColumn = table.timeColumn
+	}
+	return d.newUpsertStatement(table.name, columns, table.writePlaceholders(), table.conflictKey(), newerColumn)
+}
+
+// newUpsertStatement builds the upsert fragments for the given table and column order in the
+// dialect's syntax. placeholders holds each column's value expression ("?" or an expression
+// wrapping it). conflict names the key ON CONFLICT dialects match rows on. A non-empty newerColumn
+// only lets rows whose newerColumn is at least the stored one replace it.
+func (d *sqlDialect) newUpsertStatement(table string, columns, placeholders, conflict []string, newerColumn string) upsertStatement {
+	return newInsertStatement("INSERT INTO", table, columns, placeholders, d.upsertClause(table, conflict, columns, newerColumn))
+}
+
+// newAppendStatement builds the fragments of an INSERT that never touches stored rows. With
+// ignoreDuplicates, rows whose conflict key is already stored are skipped (INSERT IGNORE, ON
+// CONFLICT DO NOTHING); otherwise they fail the statement.
+func (d *sqlDialect) newAppendStatement(table string, columns, placeholders, conflict []string, ignoreDuplicates bool) upsertStatement {
+	switch {
+	case !ignoreDuplicates:
+		return newInsertStatement("INSERT INTO", table, columns, placeholders, "")
+	case d.upsert == onConflict:
+		return newInsertStatement("INSERT INTO", table, columns, placeholders, "ON CONFLICT ("+strings.Join(conflict, ", ")+") DO NOTHING")
+	default:
+		return newInsertStatement("INSERT IGNORE INTO", table, columns, placeholders, "")
+	}
+}
+
+func newInsertStatement(verb, table string, columns, placeholders []string, clause string) upsertStatement {
+	var prefix strings.Builder
+
+	prefix.WriteString("\n" + verb + " ")
+	prefix.WriteString(table)
+	prefix.WriteString("(\n")
+	for i, column := range columns {
+		sep := ",\n"
+		if i == len(columns)-1 {
+			sep = "\n"
+		}
+		prefix.WriteString("    " + column + sep)
+	}
+	prefix.WriteString(") VALUES")
+
+	suffix := "\n"
+	if clause != "" {
+		suffix = "\n" + clause + "\n"
+	}
+	return upsertStatement{
+		prefix:      prefix.String(),
+		suffix:      suffix,
+		placeholder: "\n    (" + strings.Join(placeholders, ", ") + ")",
+	}
+}
+
+// upsertClause renders the clause following INSERT ... VALUES or INSERT ... SELECT that turns
+// it into an upsert of columns: ON DUPLICATE KEY UPDATE for MySQL-compatible dialects, ON
+// CONFLICT (conflict) DO UPDATE elsewhere. newerColumn works as in newUpsertStatement.
+func (d *sqlDialect) upsertClause(table string, conflict, columns []string, newerColumn string) string {
+	key := make(map[string]bool, len(conflict))
+	for _, c := range conflict {
+		key[c] = true
+	}
+
+	var assignments []string
+	for _, column := range columns {
+		switch {
+		case d.upsert == onConflict && key[column]:
+			// The conflicting row already holds the key.
+		case newerColumn == "":
+			assignments = append(assignments, column+" = "+d.incoming(column))
+		case column != newerColumn:
+			newer := d.incoming(newerColumn) + " >= " + d.current(table, newerColumn)
+			assignments = append(assignments, column+" = "+d.ifElse(newer, d.incoming(column), d.current(table, column)))
+		}
+	}
+	if newerColumn != "" {
+		// MySQL applies assignments left to right, so the guard column is updated last.
+		assignments = append(assignments, newerColumn+" = "+d.greatest(d.current(table, newerColumn), d.incoming(newerColumn)))
+	}
+
+	if d.upsert == onConflict {
+		target := "ON CONFLICT (" + strings.Join(conflict, ", ") + ")"
+		if len(assignments) == 0 {
+			return target + " DO NOTHING"
+		}
+		return target + " DO UPDATE SET\n    " + strings.Join(assignments, ",\n    ")
+	}
+	return "ON DUPLICATE KEY UPDATE\n    " + strings.Join(assignments, ",\n    ")
+}
+
+// incoming refers to the value the INSERT tried to write.
+func (d *sqlDialect) incoming(column string) string {
+	if d.upsert == onConflict {
+		return "excluded." + column
+	}
+	return "VALUES(" + column + ")"
+}
+
+// current refers to the value stored in the conflicting row.
+func (d *sqlDialect) current(table, column string) string {
+	if d.upsert == onConflict {
+		return table + "." + column
+	}
+	return column
+}
+
+func (d *sqlDialect) ifElse(cond, then, otherwise string) string {
+	if d.upsert == onConflict {
+		return "CASE WHEN " + cond + " THEN " + then + " ELSE " + otherwise + " END"
+	}
+	return "IF(" + cond + ", " + then + ", " + otherwise + ")"
+}
+
+func (d *sqlDialect) greatest(a, b string) string {
+	if d.name == "sqlite" {
+		return "MAX(" + a + ", " + b + ")"
+	}
+	return "GREATEST(" + a + ", " + b + ")"
+}
+
+// rebind rewrites ? placeholders into the dialect's style, e.g. $1, $2 for Postgres. Question
+// marks inside quoted strings are left alone.
+func (d *sqlDialect) rebind(query string) string {
+	if !d.numberedPlaceholders || !strings.Contains(query, "?") {
+		return query
+	}
+	var (
+		b     strings.Builder
+		n     int
+		quote rune
+	)
+	b.Grow(len(query) + len(query)/4)
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// conflictKey returns the key written rows are matched on: the primary key when it is written
+// (not generated), else the first unique key.
+func (t *tableSpec) conflictKey() []string {
+	written := make(map[string]bool, len(t.columns))
+	for _, c := range t.writeColumns() {
+		written[c] = true
+	}
+	for _, key := range append([][]string{t.primaryKey}, t.uniqueKeys...) {
+		complete := len(key) > 0
+		for _, c := range key {
+			complete = complete && written[c]
+		}
+		if complete {
+			return key
+		}
+	}
+	return t.primaryKey
+}
@@ -0,0 +1,291 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	utilitiesSQLitePaths []string
+	utilitiesMySQLDSN    string
+	utilitiesEntity      string
+	utilitiesMatch       string
+	utilitiesOptions     numericExportOptions
+)
+
+// utilitiesCmd exports water and gas meters through the shared numeric pipeline.
+var utilitiesCmd = &cobra.Command{
+	Use:   "utilities",
+	Short: "Export Home Assistant water and gas meters into MySQL",
+	Long:  "Reads the states of sensors with device_class water or gas from the Home Assistant SQLite recorder database, upserts them into a utilities_points table, and refreshes each meter's daily consumption in utilities_daily. A meter reading that drops is taken as a counter reset, after which the meter counts from zero.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(utilitiesSQLitePaths) == 0 {
+			return errors.New("sqlite database path is required")
+		}
+		if utilitiesMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		selector, err := newEntitySelector(utilitiesMatch, utilitiesEntity)
+		if err != nil {
+			return err
+		}
+		family := newUtilitiesFamily(selector)
+		opts := utilitiesOptions
+		opts.meterDaily = true
+		return forEachRecorder(ctx, utilitiesSQLitePaths, func(sqlitePath string) error {
+			return transferNumericData(ctx, sqlitePath, utilitiesMySQLDSN, family, opts)
+		})
+	},
+}
+
+func init() {
+	utilitiesCmd.Flags().StringArrayVar(&utilitiesSQLitePaths, "sqlite", nil, recorderFlagUsage)
+	utilitiesCmd.Flags().StringVar(&utilitiesMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	utilitiesCmd.Flags().StringVar(&utilitiesEntity, "entity", "", "Optional slug narrowing the exported meters (see --match)")
+	addMatchFlag(utilitiesCmd, &utilitiesMatch)
+	utilitiesCmd.Flags().BoolVar(&utilitiesOptions.withDelta, "with-delta", false, "Fill prev_numeric_state and delta columns per entity during export")
+	utilitiesCmd.Flags().BoolVar(&utilitiesOptions.withPreviousState, "with-previous-state", false, previousStateFlagUsage)
+	utilitiesCmd.Flags().StringVar(&utilitiesOptions.idStrategy, "id-strategy", idStrategyAuto, "How state_id is assigned: auto (AUTO_INCREMENT) or hash (derived from entity_id and time, idempotent for averaged rows)")
+	addGroupByFlag(utilitiesCmd, &utilitiesOptions.groupBy)
+	addTariffSplitFlag(utilitiesCmd, &utilitiesOptions.tariffSplit)
+	addDegreeDayFlags(utilitiesCmd, &utilitiesOptions)
+	addOpenMeteoFlags(utilitiesCmd, &utilitiesOptions.openMeteo)
+	_ = utilitiesCmd.MarkFlagRequired("sqlite")
+	_ = utilitiesCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(utilitiesCmd)
+}
+
+// newUtilitiesFamily matches water and gas sensors, optionally narrowed by the --entity selection.
+func newUtilitiesFamily(selector *entitySelector) numericFamily {
+	return numericFamily{
+		name:     "utilities",
+		where:    `sm.entity_id LIKE 'sensor.%' AND (sa.shared_attrs LIKE '%"device_class":"water"%' OR sa.shared_attrs LIKE '%"device_class":"gas"%')`,
+		selector: selector,
+	}
+}
+
+// meterDailyTable holds each meter's consumption per local day: the sum of the day's increases of
+// its reading, counting from zero after a reset. start_value is the reading the day started from,
+// the last one before it when there is one. A tariff, when set, adds its window columns.
+func meterDailyTable(f numericFamily, tariff *tariffConfig) *tableSpec {
+	table := &tableSpec{
+		name: f.name + "_daily",
+		columns: []columnSpec{
+			{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+			{name: "day", sqlType: "DATE NOT NULL"},
+			{name: "device_class", sqlType: "VARCHAR(64) NULL"},
+			{name: "unit", sqlType: "VARCHAR(64) NULL"},
+			{name: "start_value", sqlType: "DOUBLE NULL"},
+			{name: "end_value", sqlType: "DOUBLE NOT NULL"},
+			{name: "consumption", sqlType: "DOUBLE NOT NULL"},
+			{name: "readings", sqlType: "INT NOT NULL"},
+			{name: "resets", sqlType: "INT NOT NULL"},
+		},
+		primaryKey: []string{"entity_id", "day"},
+	}
+	if tariff != nil {
+		table.columns = append(table.columns, tariffColumns(tariff)...)
+	}
+	return table
+}
+
+// meterResetRatio is how far a reading must fall below the previous one to count as a reset. A
+// smaller drop is taken as meter jitter: the day keeps the higher reading as its baseline, so the
+// jitter is not counted twice.
+const meterResetRatio = 0.9
+
+// meterDay accumulates one meter's readings of one day.
+type meterDay struct {
+	day         time.Time
+	meta        stateMetadata
+	start       sql.NullFloat64
+	end         float64
+	consumption float64
+	readings    int
+	resets      int
+	// windows is the consumption per tariff window, with --tariff-split.
+	windows []float64
+}
+
+// meterDaily turns a meter's readings, in time order, into meterDay rows.
+type meterDaily struct {
+	since time.Time
+	emit  func(entityID string, d *meterDay) error
+	// tariff, when set, splits each increase into the window of the reading that ends it.
+	tariff *tariffConfig
+
+	entityID string
+	// baseline is the reading increases are measured from.
+	baseline sql.NullFloat64
+	current  *meterDay
+}
+
+// reading adds the meter's reading at t. Readings before since only set the baseline.
+func (m *meterDaily) reading(entityID string, t time.Time, value float64, meta stateMetadata) error {
+	if entityID != m.entityID {
+		if err := m.flush(); err != nil {
+			return err
+		}
+		m.entityID, m.baseline = entityID, sql.NullFloat64{}
+	}
+	if t.Before(m.since) {
+		m.baseline = sql.NullFloat64{Float64: value, Valid: true}
+		return nil
+	}
+
+	day := dayStart(t)
+	if m.current != nil && !m.current.day.Equal(day) {
+		if err := m.flush(); err != nil {
+			return err
+		}
+	}
+	if m.current == nil {
+		m.current = &meterDay{day: day, start: m.baseline}
+		if m.tariff != nil {
+			m.current.windows = make([]float64, len(m.tariff.Windows))
+		}
+	}
+	d := m.current
+	d.meta = meta
+	d.readings++
+	var increase float64
+	switch {
+	case !m.baseline.Valid:
+		m.baseline = sql.NullFloat64{Float64: value, Valid: true}
+	case value >= m.baseline.Float64:
+		increase = value - m.baseline.Float64
+		m.baseline.Float64 = value
+	case value < m.baseline.Float64*meterResetRatio:
+		d.resets++
+		increase = value
+		m.baseline.Float64 = value
+	}
+	d.consumption += increase
+	if m.tariff != nil {
+		if w := m.tariff.window(t); w >= 0 {
+			d.windows[w] += increase
+		}
+	}
+	d.end = value
+	return nil
+}
+
+func (m *meterDaily) flush() error {
+	if m.current == nil {
+		return nil
+	}
+	d := m.current
+	m.current = nil
+	return m.emit(m.entityID, d)
+}
+
+// refreshMeterDaily recomputes the family's daily consumption from the recorder for every day
+// since the one holding the given time. Each meter's newest reading before that day is the
+// baseline of its first day. A tariff, when set, splits the consumption into its windows.
+func refreshMeterDaily(ctx context.Context, sqliteDB *sql.DB, sink Sink, family numericFamily, tariff *tariffConfig, since time.Time) error {
+	table := meterDailyTable(family, tariff)
+	if err := sink.EnsureSchema(ctx, table); err != nil {
+		return fmt.Errorf("ensure %s table: %w", table.name, err)
+	}
+	start := dayStart(since)
+
+	query := `
+SELECT
+    sm.entity_id,
+    s.state,
+    s.last_updated_ts,
+    COALESCE(sa.shared_attrs, ''),
+    s.attributes_id
+FROM states s
+JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+LEFT JOIN state_attributes sa ON s.attributes_id = sa.attributes_id
+WHERE (` + family.where + `) AND (s.last_updated_ts >= ? OR s.state_id IN (
+    SELECT (
+        SELECT p.state_id FROM states p
+        WHERE p.metadata_id = m.metadata_id AND p.last_updated_ts < ? AND p.state NOT IN ('unavailable', 'unknown')
+        ORDER BY p.last_updated_ts DESC LIMIT 1
+    )
+    FROM states_meta m
+))
+ORDER BY sm.entity_id, s.last_updated_ts
+`
+	startTS := float64(start.UnixMicro()) / 1e6
+	args := append(append([]any{}, family.args...), startTS, startTS)
+	rows, err := sqliteDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("query sqlite database for %s: %w", table.name, err)
+	}
+	defer rows.Close()
+
+	const meterDailyBatchSize = 500
+	writer := newBatchWriter(sink, table, meterDailyBatchSize)
+	round := func(v float64) float64 { return math.Round(v*1e6) / 1e6 }
+	daily := &meterDaily{since: start, tariff: tariff, emit: func(entityID string, d *meterDay) error {
+		values := []any{entityID, d.day, d.meta.DeviceClass, d.meta.Unit, d.start, d.end, round(d.consumption), d.readings, d.resets}
+		if tariff != nil {
+			for _, consumption := range d.windows {
+				values = append(values, round(consumption))
+			}
+			var cost sql.NullFloat64
+			if tariff.priced() {
+				cost = sql.NullFloat64{Float64: round(tariff.cost(d.windows, d.meta.Unit.String)), Valid: true}
+			}
+			values = append(values, cost)
+		}
+		return writer.Add(ctx, values...)
+	}}
+	metadata := newMetadataCache()
+	for rows.Next() {
+		var (
+			entityID, state, attributesJSON string
+			lastUpdatedVal                  sql.NullFloat64
+			attributesID                    sql.NullInt64
+		)
+		if err := rows.Scan(&entityID, &state, &lastUpdatedVal, &attributesJSON, &attributesID); err != nil {
+			return fmt.Errorf("scan sqlite row: %w", err)
+		}
+		// Rows the export rejects are left out here as well.
+		lastUpdated, err := floatToNullTime(lastUpdatedVal)
+		if err != nil || !lastUpdated.Valid {
+			continue
+		}
+		meta, err := metadata.metadata(attributesID, attributesJSON)
+		if err != nil {
+			continue
+		}
+		if !family.isMeter(meta) {
+			continue
+		}
+		value := parseNumericState(strings.TrimSpace(state))
+		if !value.Valid {
+			continue
+		}
+		if err := daily.reading(entityID, lastUpdated.Time, value.Float64, meta); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate sqlite rows: %w", err)
+	}
+	if err := daily.flush(); err != nil {
+		return err
+	}
+	if err := writer.Flush(ctx); err != nil {
+		return err
+	}
+	return finalizeTable(ctx, sink, table)
+}
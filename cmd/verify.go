@@ -0,0 +1,359 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// verifySample is the --verify-sample flag: rows per table the mysql sink reads back after the
+// export; verifyReplicaRead is the TiDB tidb_replica_read used for it.
+var (
+	verifySample      int
+	verifyReplicaRead = "follower"
+)
+
+func init() {
+	rootCmd.PersistentFlags().IntVar(&verifySample, "verify-sample", 0, "After exporting a table, read back this many randomly sampled written rows and compare their values, failing with exit code 5 on lost or different rows (0 = off; mysql sink only)")
+	rootCmd.PersistentFlags().StringVar(&verifyReplicaRead, "verify-replica-read", verifyReplicaRead, "tidb_replica_read for --verify-sample on TiDB (leader, follower, leader-and-follower, closest-replicas), so the check also covers the replicas reads are served from")
+}
+
+func validateVerifyFlags() error {
+	if verifySample < 0 {
+		return fmt.Errorf("--verify-sample must not be negative, got %d", verifySample)
+	}
+	switch verifyReplicaRead {
+	case "leader", "follower", "leader-and-follower", "closest-replicas":
+		return nil
+	}
+	return fmt.Errorf("unknown --verify-replica-read %q (supported: leader, follower, leader-and-follower, closest-replicas)", verifyReplicaRead)
+}
+
+// writeSamples keeps a uniform sample (reservoir) of the rows written per table during the run.
+type writeSamples struct {
+	mu     sync.Mutex
+	tables map[string]*tableSample
+}
+
+type tableSample struct {
+	// table is the spec the rows were written with, including computed columns.
+	table *tableSpec
+	// keyAt locates the verifyKey columns in the rows, and keyTypes holds their types; byKey
+	// indexes the sampled rows by key, so a later write of the same key replaces the sampled row.
+	keyAt    []int
+	keyTypes []string
+	byKey    map[string]int
+	seen     int
+	rows     [][]any
+}
+
+// observe adds rows the sink has written to the table's sample.
+func (w *writeSamples) observe(table *tableSpec, rows [][]any) {
+	if verifySample == 0 || table.newerOnly {
+		// Rows of newer-only tables are meant to be replaced by later ones.
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.tables == nil {
+		w.tables = make(map[string]*tableSample)
+	}
+	sample := w.tables[table.name]
+	if sample == nil || !slices.Equal(sample.table.writeColumns(), table.writeColumns()) {
+		sample = &tableSample{table: table, byKey: make(map[string]int)}
+		w.tables[table.name] = sample
+		columns := table.writeColumns()
+		for _, c := range verifyKey(table) {
+			sample.keyAt = append(sample.keyAt, slices.Index(columns, c))
+			sample.keyTypes = append(sample.keyTypes, columnSQLType(table, c))
+		}
+	}
+	if len(sample.keyAt) == 0 {
+		sample.seen += len(rows)
+		return
+	}
+	for _, row := range rows {
+		sample.seen++
+		k := sample.rowKey(row)
+		if i, ok := sample.byKey[k]; ok {
+			sample.rows[i] = row
+			continue
+		}
+		i := len(sample.rows)
+		if i >= verifySample {
+			if i = rand.IntN(sample.seen); i >= verifySample {
+				continue
+			}
+			delete(sample.byKey, sample.rowKey(sample.rows[i]))
+			sample.rows[i] = row
+		} else {
+			sample.rows = append(sample.rows, row)
+		}
+		sample.byKey[k] = i
+	}
+}
+
+func (t *tableSample) rowKey(row []any) string {
+	parts := make([]string, len(t.keyAt))
+	for i, at := range t.keyAt {
+		parts[i] = fmt.Sprint(verifyValue(verifyParam(row[at], t.keyTypes[i])))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// take returns and forgets the sample of the table.
+func (w *writeSamples) take(name string) *tableSample {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sample := w.tables[name]
+	delete(w.tables, name)
+	return sample
+}
+
+// verifyWrites reads the table's sampled rows back by key and compares every written value.
+// Times are compared in whole seconds, which every DATETIME column keeps.
+func (s *mysqlSink) verifyWrites(ctx context.Context, name string) error {
+	sample := s.samples.take(name)
+	if sample == nil || sample.seen == 0 {
+		return nil
+	}
+	table := sample.table
+	columns := table.writeColumns()
+	key := verifyKey(table)
+	if len(key) == 0 {
+		fmt.Fprintf(os.Stderr, "verify %s: skipped, no key among the written columns\n", table.name)
+		return nil
+	}
+	keyAt := make([]int, len(key))
+	keyTypes := make([]string, len(key))
+	conditions := make([]string, len(key))
+	for i, c := range key {
+		keyAt[i] = slices.Index(columns, c)
+		keyTypes[i] = columnSQLType(table, c)
+		conditions[i] = c + " = ?"
+	}
+	// Columns written through an expression (geometries) read back in another form.
+	var compared []int
+	for _, c := range table.columns {
+		if !c.generated && c.valueExpr == "" {
+			compared = append(compared, slices.Index(columns, c.name))
+		}
+	}
+	selected := make([]string, len(compared))
+	for i, at := range compared {
+		selected[i] = columns[at]
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(selected, ", "), table.name, strings.Join(conditions, " AND "))
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", table.name, err)
+	}
+	defer conn.Close()
+	how := "leader"
+	if destDialect.followerRead {
+		if _, err := conn.ExecContext(ctx, "SET SESSION tidb_replica_read = ?", verifyReplicaRead); err != nil {
+			return fmt.Errorf("verify %s: set tidb_replica_read: %w", table.name, err)
+		}
+		how = verifyReplicaRead
+	}
+	// Append mode keeps rows that existed before the run, so only their presence is checked.
+	presenceOnly := tableWriteMode(table) == writeModeAppend
+
+	var problems []string
+	for _, row := range sample.rows {
+		args := make([]any, len(key))
+		for i, at := range keyAt {
+			args[i] = verifyParam(row[at], keyTypes[i])
+		}
+		got := make([]any, len(compared))
+		dest := make([]any, len(compared))
+		for i := range got {
+			dest[i] = &got[i]
+		}
+		err := func() error {
+			qctx, cancel := withStatementTimeout(ctx)
+			defer cancel()
+			return explainTimeout(qctx, conn.QueryRowContext(qctx, query, args...).Scan(dest...))
+		}()
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			problems = append(problems, fmt.Sprintf("%s: missing", describeKey(key, args)))
+			continue
+		case err != nil:
+			return fmt.Errorf("verify %s: %w", table.name, err)
+		case presenceOnly:
+			continue
+		}
+		for i, at := range compared {
+			if !verifyEqual(row[at], got[i]) {
+				problems = append(problems, fmt.Sprintf("%s: %s is %v, wrote %v", describeKey(key, args), columns[at], verifyDisplay(got[i]), verifyDisplay(row[at])))
+				break
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		fmt.Fprintf(os.Stderr, "verify %s: %d sampled rows of %d match (%s read)\n", table.name, len(sample.rows), sample.seen, how)
+		return nil
+	}
+	for i, p := range problems {
+		if i == 10 {
+			fmt.Fprintf(os.Stderr, "verify %s: ... and %d more\n", table.name, len(problems)-i)
+			break
+		}
+		fmt.Fprintf(os.Stderr, "verify %s: %s\n", table.name, p)
+	}
+	return &verificationError{err: fmt.Errorf("verify %s: %d of %d sampled rows were lost or differ (%s read)", table.name, len(problems), len(sample.rows), how)}
+}
+
+// verifyKey returns the first primary or unique key whose columns are all written, or nil.
+func verifyKey(table *tableSpec) []string {
+	columns := table.writeColumns()
+	for _, key := range append([][]string{table.primaryKey}, table.uniqueKeys...) {
+		if len(key) > 0 && !slices.ContainsFunc(key, func(c string) bool { return !slices.Contains(columns, c) }) {
+			return key
+		}
+	}
+	return nil
+}
+
+// columnSQLType returns the declared type of the table's column, or "" when it has none.
+func columnSQLType(table *tableSpec, name string) string {
+	for _, c := range table.columns {
+		if c.name == name {
+			return c.sqlType
+		}
+	}
+	return ""
+}
+
+// verifyParam prepares a key value of a column of sqlType for the lookup, rounding times like
+// DATETIME (whole seconds) or DATETIME(6) (microseconds) does on insert.
+func verifyParam(v any, sqlType string) any {
+	precision := time.Second
+	if strings.HasPrefix(sqlType, "DATETIME(6)") {
+		precision = time.Microsecond
+	}
+	switch v := v.(type) {
+	case time.Time:
+		return v.Round(precision)
+	case sql.NullTime:
+		if v.Valid {
+			return v.Time.Round(precision)
+		}
+		return nil
+	}
+	return v
+}
+
+func describeKey(key []string, args []any) string {
+	parts := make([]string, len(key))
+	for i, c := range key {
+		parts[i] = fmt.Sprintf("%s=%v", c, verifyDisplay(args[i]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// verifyValue normalizes a written or read-back value: nil, a string, a float64, or a time.
+func verifyValue(v any) any {
+	switch v := v.(type) {
+	case sql.NullString:
+		if v.Valid {
+			return v.String
+		}
+		return nil
+	case sql.NullFloat64:
+		if v.Valid {
+			return v.Float64
+		}
+		return nil
+	case sql.NullInt64:
+		if v.Valid {
+			return float64(v.Int64)
+		}
+		return nil
+	case sql.NullInt32:
+		if v.Valid {
+			return float64(v.Int32)
+		}
+		return nil
+	case sql.NullBool:
+		if v.Valid && v.Bool {
+			return float64(1)
+		} else if v.Valid {
+			return float64(0)
+		}
+		return nil
+	case sql.NullTime:
+		if v.Valid {
+			return v.Time
+		}
+		return nil
+	case []byte:
+		return string(v)
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case bool:
+		if v {
+			return float64(1)
+		}
+		return float64(0)
+	}
+	return v
+}
+
+// verifyEqual compares a written value with the value read back.
+func verifyEqual(wrote, read any) bool {
+	w, r := verifyValue(wrote), verifyValue(read)
+	if w == nil || r == nil {
+		return w == nil && r == nil
+	}
+	switch w := w.(type) {
+	case time.Time:
+		rt, ok := r.(time.Time)
+		return ok && w.Round(time.Second).Equal(rt.Round(time.Second))
+	case float64:
+		rf, ok := r.(float64)
+		if s, isString := r.(string); isString {
+			// DECIMAL columns read back as text.
+			parsed, err := strconv.ParseFloat(s, 64)
+			rf, ok = parsed, err == nil
+		}
+		return ok && (w == rf || math.Abs(w-rf) <= 1e-9*math.Max(math.Abs(w), math.Abs(rf)))
+	case string:
+		rs, ok := r.(string)
+		return ok && w == rs
+	}
+	return fmt.Sprint(w) == fmt.Sprint(r)
+}
+
+func verifyDisplay(v any) any {
+	switch v := verifyValue(v).(type) {
+	case nil:
+		return "NULL"
+	case time.Time:
+		return v.Round(time.Second).UTC().Format(time.DateTime)
+	case string:
+		return strconv.Quote(v)
+	default:
+		return v
+	}
+}
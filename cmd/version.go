@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// version, commit, and buildDate describe the build. Release builds set them with
+//
+//	go build -ldflags "-X ha-tools/cmd.version=v1.4.0 -X ha-tools/cmd.commit=$(git rev-parse HEAD) -X ha-tools/cmd.buildDate=$(date -u +%FT%TZ)"
+//
+// and other builds fall back to the module and VCS information Go embeds.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+const (
+	// minRecorderSchemaVersion is the oldest recorder schema the exporters read, the first with
+	// state_attributes (Home Assistant 2022.4); see recorderschema.go for schemas before the
+	// current layout.
+	minRecorderSchemaVersion = 25
+	// maxTestedRecorderSchemaVersion is the newest recorder schema the exporters were tested with.
+	maxTestedRecorderSchemaVersion = 48
+	// destinationSchemaVersion numbers the layout of the destination tables this build writes. It
+	// is bumped when a release changes existing tables so that older releases can no longer write
+	// them.
+	destinationSchemaVersion = 1
+)
+
+// strictSchema is the --strict-schema flag: refuse recorders newer than the tested schemas
+// instead of warning.
+var strictSchema bool
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the version, build, and supported schema versions",
+	Long:  "Prints the ha-tools version, commit, and build date, the recorder schema versions the exporters support and were tested with, and the destination schema version this build writes.",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		printVersion(cmd.OutOrStdout(), currentBuild())
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&strictSchema, "strict-schema", false, fmt.Sprintf("Refuse recorders with a schema newer than the tested %d instead of warning", maxTestedRecorderSchemaVersion))
+	rootCmd.AddCommand(versionCmd)
+}
+
+// buildInfo is what versionCmd reports about the binary.
+type buildInfo struct {
+	version, commit, date string
+	modified              bool
+}
+
+// currentBuild combines the -ldflags values with the build information Go embeds.
+func currentBuild() buildInfo {
+	b := buildInfo{version: version, commit: commit, date: buildDate}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	if b.version == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		b.version = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if b.commit == "" {
+				b.commit = s.Value
+			}
+		case "vcs.time":
+			if b.date == "" {
+				b.date = s.Value
+			}
+		case "vcs.modified":
+			b.modified = s.Value == "true"
+		}
+	}
+	return b
+}
+
+func printVersion(w io.Writer, b buildInfo) {
+	orUnknown := func(s string) string {
+		if s == "" {
+			return "unknown"
+		}
+		return s
+	}
+	v := b.version
+	if v == "" {
+		v = "dev"
+	}
+	c := orUnknown(b.commit)
+	if b.modified {
+		c += " (modified)"
+	}
+	fmt.Fprintf(w, "ha-tools %s\n", v)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "commit\t%s\n", c)
+	fmt.Fprintf(tw, "built\t%s\n", orUnknown(b.date))
+	fmt.Fprintf(tw, "go\t%s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(tw, "recorder schema\t%d and later (before %d through compatibility views), tested up to %d\n", minRecorderSchemaVersion, currentRecorderSchemaVersion, maxTestedRecorderSchemaVersion)
+	fmt.Fprintf(tw, "destination schema\t%d\n", destinationSchemaVersion)
+	tw.Flush()
+}
+
+// recorderSchemaVersion returns the recorder's schema version from schema_changes, or 0 when the
+// database has no schema_changes table.
+func recorderSchemaVersion(ctx context.Context, sqliteDB *sql.DB) (int, error) {
+	var n int
+	if err := sqliteDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_changes'").Scan(&n); err != nil {
+		return 0, fmt.Errorf("look up recorder schema_changes: %w", err)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	var v int
+	err := sqliteDB.QueryRowContext(ctx, "SELECT schema_version FROM schema_changes ORDER BY change_id DESC LIMIT 1").Scan(&v)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("read recorder schema version: %w", err)
+	}
+	return v, nil
+}
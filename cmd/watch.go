@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	watchSQLitePath  string
+	watchMySQLDSN    string
+	watchJobs        []string
+	watchPoll        time.Duration
+	watchMinInterval time.Duration
+	watchMaxLag      time.Duration
+	watchLagNotify   string
+	watchLagWebhook  string
+)
+
+// watchCmd re-runs exporters whenever the recorder changes.
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Re-run exporters whenever the recorder changes",
+	Long:  "Polls the recorder's PRAGMA data_version and newest state_id every --poll interval over one read-only connection and runs the --job exporters (incrementally, from their watermarks) only when Home Assistant committed something since the last run. An idle recorder costs one cheap query per poll.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if watchSQLitePath == "" {
+			return errors.New("sqlite database path is required")
+		}
+		if len(watchJobs) == 0 {
+			return errors.New("at least one --job is required")
+		}
+		if watchPoll <= 0 {
+			return errors.New("--poll must be positive")
+		}
+		monitor, err := newLagMonitor(watchMaxLag, watchMySQLDSN, watchLagNotify, watchLagWebhook)
+		if err != nil {
+			return err
+		}
+		defer monitor.close()
+		evaluator := detachAlerts()
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		binary, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("locate ha-tools binary: %w", err)
+		}
+		global := inheritedFlagArgs(cmd)
+		jobs := &addonOptions{Recorder: watchSQLitePath, DSN: watchMySQLDSN, Jobs: watchJobs}
+
+		sqliteDB, err := openRecorder(ctx, watchSQLitePath)
+		if err != nil {
+			return err
+		}
+		defer sqliteDB.Close()
+		// data_version only reports commits made by other connections since this connection's
+		// previous read, so every poll must use the same one.
+		conn, err := sqliteDB.Conn(ctx)
+		if err != nil {
+			return fmt.Errorf("open sqlite connection: %w", err)
+		}
+		defer conn.Close()
+
+		out, errOut := cmd.OutOrStdout(), cmd.ErrOrStderr()
+		var (
+			last    recorderVersion
+			lastRun time.Time
+		)
+		for {
+			current, err := readRecorderVersion(ctx, conn)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			if current != last && time.Since(lastRun) >= watchMinInterval {
+				if !last.isZero() {
+					addonLog(out, "recorder changed (newest state_id %d)", current.maxStateID)
+				}
+				last, lastRun = current, time.Now()
+				runAddonJobs(ctx, out, errOut, binary, global, jobs)
+				if monitor != nil && ctx.Err() == nil {
+					monitor.check(ctx, out, errOut, sqliteDB, watchMySQLDSN)
+				}
+			}
+			// Fire "for" rules as they come due, even while the recorder is idle.
+			if evaluator != nil && ctx.Err() == nil {
+				if err := evaluator.sync(ctx); err != nil {
+					addonLog(errOut, "alerts: %v", err)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				addonLog(out, "stopping")
+				return nil
+			case <-time.After(watchPoll):
+			}
+		}
+	},
+}
+
+// oneShotFlags apply to a single run: a --resume token continues the interrupted run once, and
+// --now would stop the clock of every later run. They are not passed on to repeated jobs.
+var oneShotFlags = map[string]bool{"resume": true, "now": true}
+
+// inheritedFlagArgs returns the global flags given to cmd as --name=value arguments for the
+// ha-tools processes it starts, leaving out oneShotFlags. Cobra parses them into the subcommand's
+// merged flag set, so only the flags cmd inherits, not rootCmd's own set, report them as changed.
+// Repeatable flags such as --pre-sql are passed once per value.
+func inheritedFlagArgs(cmd *cobra.Command) []string {
+	var args []string
+	cmd.InheritedFlags().VisitAll(func(f *pflag.Flag) {
+		if !f.Changed || oneShotFlags[f.Name] {
+			return
+		}
+		if values, ok := f.Value.(pflag.SliceValue); ok {
+			for _, v := range values.GetSlice() {
+				args = append(args, "--"+f.Name+"="+v)
+			}
+			return
+		}
+		args = append(args, "--"+f.Name+"="+f.Value.String())
+	})
+	return args
+}
+
+func init() {
+	watchCmd.Flags().StringVar(&watchSQLitePath, "sqlite", "", "Path to the Home Assistant SQLite recorder database; also passed as --sqlite to jobs that take it")
+	watchCmd.Flags().StringVar(&watchMySQLDSN, "dsn", "", "MySQL DSN passed as --dsn to jobs that take it and do not set it")
+	watchCmd.Flags().StringArrayVar(&watchJobs, "job", nil, "Exporter to run on changes, as ha-tools arguments (e.g. \"gps\" or \"energy --entity=dryer\"); repeat for several, run in order")
+	watchCmd.Flags().DurationVar(&watchPoll, "poll", 5*time.Second, "How often to check the recorder for changes")
+	watchCmd.Flags().DurationVar(&watchMinInterval, "min-interval", 0, "Minimum time between runs; changes in between are picked up by the next run")
+	watchCmd.Flags().DurationVar(&watchMaxLag, "max-lag", 0, "After each run, check the sync lag (recorder's newest state minus newest exported row) and report entities lagging more than this (e.g. 15m; requires --dsn)")
+	watchCmd.Flags().StringVar(&watchLagNotify, "lag-notify", "", "Home Assistant notify service (e.g. mobile_app_phone) told when entities exceed --max-lag; needs the config's home_assistant section")
+	watchCmd.Flags().StringVar(&watchLagWebhook, "lag-webhook", "", "URL receiving a JSON POST when entities exceed --max-lag")
+	_ = watchCmd.MarkFlagRequired("sqlite")
+	_ = watchCmd.MarkFlagRequired("job")
+
+	rootCmd.AddCommand(watchCmd)
+}
+
+// recorderVersion fingerprints the recorder's content.
+type recorderVersion struct {
+	// dataVersion changes whenever another connection commits to the database.
+	dataVersion int64
+	// maxStateID catches new states when data_version is unavailable, e.g. with immutable=1 or
+	// after the database file was replaced.
+	maxStateID int64
+}
+
+func (v recorderVersion) isZero() bool {
+	return v == recorderVersion{}
+}
+
+func readRecorderVersion(ctx context.Context, conn *sql.Conn) (recorderVersion, error) {
+	var v recorderVersion
+	if err := conn.QueryRowContext(ctx, "PRAGMA data_version").Scan(&v.dataVersion); err != nil {
+		return v, fmt.Errorf("read recorder data_version: %w", err)
+	}
+	var maxStateID sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT MAX(state_id) FROM states").Scan(&maxStateID); err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return v, fmt.Errorf("read recorder: %w (is this a Home Assistant recorder?)", err)
+		}
+		return v, fmt.Errorf("read newest state_id: %w", err)
+	}
+	v.maxStateID = maxStateID.Int64
+	return v, nil
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// newFlagTree returns a root command with global flags like rootCmd's and a subcommand parsed
+// from args, the way Execute parses them.
+func newFlagTree(t *testing.T, args ...string) *cobra.Command {
+	t.Helper()
+	root := &cobra.Command{Use: "ha-tools"}
+	root.PersistentFlags().String("dialect", "mysql", "")
+	root.PersistentFlags().String("time-zone", "", "")
+	root.PersistentFlags().StringArray("pre-sql", nil, "")
+	root.PersistentFlags().StringArray("post-sql", nil, "")
+	root.PersistentFlags().String("resume", "", "")
+	root.PersistentFlags().String("now", "", "")
+	sub := &cobra.Command{Use: "watch"}
+	sub.Flags().String("sqlite", "", "")
+	root.AddCommand(sub)
+	if err := sub.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+func TestWatchForwardsGlobalFlagsToJobs(t *testing.T) {
+	// A resume token and a fixed clock apply to one run, not to every poll.
+	sub := newFlagTree(t, "--dialect", "postgres", "--sqlite=fx.db", "--time-zone=Europe/Berlin", "--resume=token", "--now=2024-03-31T02:30:00Z")
+	global := inheritedFlagArgs(sub)
+	if want := []string{"--dialect=postgres", "--time-zone=Europe/Berlin"}; !reflect.DeepEqual(global, want) {
+		t.Fatalf("inheritedFlagArgs = %q, want %q", global, want)
+	}
+
+	jobs := &addonOptions{Recorder: "fx.db", DSN: "x"}
+	got := jobs.jobArgs("energy --entity=plug --explain", global)
+	want := []string{"energy", "--entity=plug", "--explain", "--sqlite=fx.db", "--dsn=x", "--dialect=postgres", "--time-zone=Europe/Berlin"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("job args = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// watermarkTieLoader is implemented by sinks that can read back, per entity, the highest idColumn
+// value stored at the entity's watermark, so rows sharing the watermark's instant are told apart
+// by id.
+type watermarkTieLoader interface {
+	LoadWatermarkTies(ctx context.Context, table *tableSpec) (map[string]int64, error)
+}
+
+// loadWatermarkTies returns the table's watermark ties from the sink, or nil when the table has no
+// idColumn. Entities --resume covers take the token's id instead, so rows sharing the instant the
+// interrupted run stopped at are told apart even on a destination that cannot read ties back.
+func loadWatermarkTies(ctx context.Context, sink Sink, table *tableSpec) (map[string]int64, error) {
+	if table.idColumn == "" {
+		return nil, nil
+	}
+	var ties map[string]int64
+	if loader, ok := sink.(watermarkTieLoader); ok {
+		var err error
+		if ties, err = loader.LoadWatermarkTies(ctx, table); err != nil {
+			return nil, err
+		}
+	}
+	committedRows.mu.Lock()
+	defer committedRows.mu.Unlock()
+	for entityID, point := range committedRows.resumed[table.name] {
+		if point.ID == 0 {
+			delete(ties, entityID)
+			continue
+		}
+		if ties == nil {
+			ties = make(map[string]int64)
+		}
+		ties[entityID] = point.ID
+	}
+	return ties, nil
+}
+
+// exportedBefore reports whether an earlier run exported the entity's row at t with the given id.
+// Rows before the watermark were, and rows after it were not. Rows at the watermark's instant were
+// exported up to the tie's id; without a tie (no idColumn, or rows stored before the table had
+// one) they are all taken as exported.
+func exportedBefore(watermarks map[string]time.Time, ties map[string]int64, entityID string, t time.Time, id int64) bool {
+	watermark, ok := watermarks[entityID]
+	switch {
+	case !ok || t.After(watermark):
+		return false
+	case t.Before(watermark):
+		return true
+	}
+	tie, ok := ties[entityID]
+	return !ok || id <= tie
+}
+
+// watermarkFloorMargin is how much earlier than the oldest watermark an incremental recorder query
+// starts reading, so the float seconds the recorder stores times in never round a row at a
+// watermark out of the query. exportedBefore skips the rows read twice.
+const watermarkFloorMargin = time.Millisecond
+
+// incrementalPredicate returns a recorder WHERE condition, and its arguments, that narrows an
+// incremental export to the rows its watermarks leave to export: rows from the oldest watermark
+// on, rows without a time, and every row of entities without a watermark yet (entityExpr is
+// matched against the watermarks' keys). It only narrows the read; rows between the oldest and an
+// entity's own watermark are still dropped by exportedBefore.
+func incrementalPredicate(entityExpr, timeExpr string, watermarks map[string]time.Time) (string, []any, error) {
+	if len(watermarks) == 0 {
+		return "1 = 1", nil, nil
+	}
+	var oldest time.Time
+	entities := make([]string, 0, len(watermarks))
+	for entityID, at := range watermarks {
+		if oldest.IsZero() || at.Before(oldest) {
+			oldest = at
+		}
+		entities = append(entities, entityID)
+	}
+	encoded, err := json.Marshal(entities)
+	if err != nil {
+		return "", nil, err
+	}
+	floor := float64(oldest.Add(-watermarkFloorMargin).UnixMicro()) / 1e6
+	predicate := fmt.Sprintf("(%[2]s IS NULL OR %[2]s >= ? OR %[1]s NOT IN (SELECT value FROM json_each(?)))", entityExpr, timeExpr)
+	return predicate, []any{floor, string(encoded)}, nil
+}
+
+// LoadWatermarkTies reads the highest idColumn value among each entity's rows at its watermark.
+func (s *mysqlSink) LoadWatermarkTies(ctx context.Context, table *tableSpec) (map[string]int64, error) {
+	return queryWatermarkTies(ctx, s.db, s.target(table))
+}
+
+// queryWatermarkTies runs the LoadWatermarkTies query against a SQL destination.
+func queryWatermarkTies(ctx context.Context, db *sql.DB, table *tableSpec) (map[string]int64, error) {
+	from, entity := entitySource(table)
+	query := fmt.Sprintf(`
+SELECT %[2]s, MAX(t.%[5]s)
+FROM %[1]s
+JOIN (
+    SELECT %[4]s, MAX(%[6]s) AS latest
+    FROM %[3]s
+    GROUP BY %[4]s
+) latest ON t.%[4]s = latest.%[4]s AND t.%[6]s = latest.latest
+GROUP BY %[2]s
+`, from, entity, table.name, table.entityColumn, table.idColumn, table.timeColumn)
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(qctx, query)
+	if err != nil {
+		return nil, explainTimeout(qctx, err)
+	}
+	defer rows.Close()
+
+	ties := make(map[string]int64)
+	for rows.Next() {
+		var (
+			entityID string
+			id       sql.NullInt64
+		)
+		if err := rows.Scan(&entityID, &id); err != nil {
+			return nil, err
+		}
+		if id.Valid {
+			ties[entityID] = id.Int64
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ties, nil
+}
+
+// ensureTimePrecision widens the DATETIME(6) columns of a table created by an older release, when
+// they were whole-second DATETIMEs, to the fractional seconds the spec declares. MySQL rounds times
+// to the column's precision, so whole seconds put the watermark up to half a second away from the
+// row it stands for, and the next run skipped or repeated the rows in between. Rows stored before
+// keep their whole seconds.
+func (s *mysqlSink) ensureTimePrecision(ctx context.Context, table *tableSpec) error {
+	if !destDialect.blockingAlters {
+		return nil
+	}
+	declared := make(map[string]string)
+	for _, c := range table.columns {
+		if strings.HasPrefix(c.sqlType, "DATETIME(6)") {
+			declared[c.name] = c.sqlType
+		}
+	}
+	if len(declared) == 0 {
+		return nil
+	}
+
+	qctx, cancel := withStatementTimeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(qctx, `
+SELECT COLUMN_NAME
+FROM INFORMATION_SCHEMA.COLUMNS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND DATA_TYPE = 'datetime' AND DATETIME_PRECISION < 6
+ORDER BY ORDINAL_POSITION
+`, table.name)
+	if err != nil {
+		return fmt.Errorf("read %s column types: %w", table.name, explainTimeout(qctx, err))
+	}
+	var widen []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			rows.Close()
+			return fmt.Errorf("read %s column types: %w", table.name, err)
+		}
+		if _, ok := declared[column]; ok {
+			widen = append(widen, column)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read %s column types: %w", table.name, err)
+	}
+	if len(widen) == 0 {
+		return nil
+	}
+
+	// One ALTER rebuilds the table once for all its columns.
+	modify := make([]string, len(widen))
+	for i, column := range widen {
+		modify[i] = "MODIFY COLUMN " + column + " " + declared[column]
+	}
+	stmt := fmt.Sprintf("ALTER TABLE %s %s", table.name, strings.Join(modify, ", "))
+	if _, err := execStatement(ctx, s.db, stmt); err != nil {
+		return fmt.Errorf("widen %s.%s to microseconds: %w", table.name, strings.Join(widen, ", "), err)
+	}
+	fmt.Fprintf(os.Stderr, "widened %s.%s to microseconds\n", table.name, strings.Join(widen, ", "))
+	return nil
+}
@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	weatherSQLitePaths []string
+	weatherMySQLDSN    string
+)
+
+// weatherAttributeColumns lists the sun/weather attributes flattened into weather_points columns.
+var weatherAttributeColumns = []string{
+	"elevation",
+	"azimuth",
+	"temperature",
+	"humidity",
+	"pressure",
+	"wind_speed",
+	"cloud_coverage",
+	"uv_index",
+}
+
+// weatherCmd exports sun position and weather entity snapshots.
+var weatherCmd = &cobra.Command{
+	Use:   "weather",
+	Short: "Export Home Assistant sun and weather entities into MySQL",
+	Long:  "Reads sun.sun and weather.* states from the Home Assistant SQLite recorder database, flattens elevation/azimuth/temperature/humidity and related attributes into columns, and upserts them into a weather_points table.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(weatherSQLitePaths) == 0 {
+			return errors.New("sqlite database path is required")
+		}
+		if weatherMySQLDSN == "" {
+			return errors.New("mysql dsn is required")
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		return forEachRecorder(ctx, weatherSQLitePaths, func(sqlitePath string) error {
+			return transferWeatherData(ctx, sqlitePath, weatherMySQLDSN)
+		})
+	},
+}
+
+func init() {
+	weatherCmd.Flags().StringArrayVar(&weatherSQLitePaths, "sqlite", nil, recorderFlagUsage)
+	weatherCmd.Flags().StringVar(&weatherMySQLDSN, "dsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/database")
+	_ = weatherCmd.MarkFlagRequired("sqlite")
+	_ = weatherCmd.MarkFlagRequired("dsn")
+
+	rootCmd.AddCommand(weatherCmd)
+}
+
+func transferWeatherData(ctx context.Context, sqlitePath, mysqlDSN string) error {
+	sqliteDB, err := openRecorder(ctx, sqlitePath)
+	if err != nil {
+		return err
+	}
+	defer sqliteDB.Close()
+
+	sink, err := openExportSink(ctx, mysqlDSN)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	if err := sink.EnsureSchema(ctx, weatherPointsTable); err != nil {
+		return fmt.Errorf("ensure weather_points table: %w", err)
+	}
+
+	entityWatermarks, err := loadWatermarks(ctx, sink, weatherPointsTable)
+	if err != nil {
+		return fmt.Errorf("load weather checkpoints: %w", err)
+	}
+	watermarkTies, err := loadWatermarkTies(ctx, sink, weatherPointsTable)
+	if err != nil {
+		return fmt.Errorf("load weather checkpoints: %w", err)
+	}
+
+	incremental, args, err := incrementalPredicate("sm.entity_id", "s.last_updated_ts", entityWatermarks)
+	if err != nil {
+		return fmt.Errorf("load weather checkpoints: %w", err)
+	}
+	query := `
+SELECT
+    s.state_id,
+    sm.entity_id,
+    s.state,
+    s.last_updated_ts,
+    COALESCE(sa.shared_attrs, '')
+FROM states s
+JOIN states_meta sm ON s.metadata_id = sm.metadata_id
+LEFT JOIN state_attributes sa ON s.attributes_id = sa.attributes_id
+WHERE (sm.entity_id = 'sun.sun' OR sm.entity_id LIKE 'weather.%')
+  AND ` + incremental + `
+ORDER BY s.state_id
+`
+
+	rows, err := sqliteDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("query sqlite database: %w", err)
+	}
+	defer rows.Close()
+
+	const weatherBatchSize = 500
+
+	writer := newBatchWriter(sink, weatherPointsTable, weatherBatchSize)
+
+	for rows.Next() {
+		var (
+			stateID        int64
+			entityID       string
+			state          string
+			lastUpdatedVal sql.NullFloat64
+			attributesJSON string
+		)
+
+		if err := rows.Scan(&stateID, &entityID, &state, &lastUpdatedVal, &attributesJSON); err != nil {
+			return fmt.Errorf("scan sqlite row: %w", err)
+		}
+
+		lastUpdated, err := floatToNullTime(lastUpdatedVal)
+		if err != nil {
+			if err := writer.Reject(ctx, rejectedRow{stateID, entityID, state, lastUpdatedVal, attributesJSON}, fmt.Errorf("convert last_updated_ts for state_id %d: %w", stateID, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		if lastUpdated.Valid && exportedBefore(entityWatermarks, watermarkTies, entityID, lastUpdated.Time, stateID) {
+			exportedRows.add(weatherPointsTable.name, 1)
+			continue
+		}
+		observeAlerts(ctx, entityID, state, sql.NullFloat64{}, lastUpdated)
+
+		attributes, err := extractWeatherAttributes(attributesJSON)
+		if err != nil {
+			if err := writer.Reject(ctx, rejectedRow{stateID, entityID, state, lastUpdatedVal, attributesJSON}, fmt.Errorf("parse attributes for state_id %d: %w", stateID, err)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		values := []any{stateID, entityID, state}
+		for _, v := range attributes {
+			values = append(values, v)
+		}
+		values = append(values, lastUpdated)
+		if err := writer.Add(ctx, values...); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate sqlite rows: %w", err)
+	}
+
+	if err := writer.Flush(ctx); err != nil {
+		return err
+	}
+
+	return finalizeTable(ctx, sink, weatherPointsTable)
+}
+
+// weatherPointsTable flattens weatherAttributeColumns between the state and last_updated columns.
+var weatherPointsTable = newWeatherPointsTable()
+
+func newWeatherPointsTable() *tableSpec {
+	columns := []columnSpec{
+		{name: "state_id", sqlType: "BIGINT NOT NULL"},
+		{name: "entity_id", sqlType: "VARCHAR(255) NOT NULL"},
+		{name: "state", sqlType: "VARCHAR(255) NOT NULL"},
+	}
+	for _, name := range weatherAttributeColumns {
+		columns = append(columns, columnSpec{name: name, sqlType: "DOUBLE NULL"})
+	}
+	columns = append(columns, columnSpec{name: "last_updated", sqlType: "DATETIME(6) NULL"})
+
+	return &tableSpec{
+		name:          "weather_points",
+		columns:       columns,
+		primaryKey:    []string{"state_id"},
+		entityColumn:  "entity_id",
+		timeColumn:    "last_updated",
+		idColumn:      "state_id",
+		indexDefaults: []string{"entity-time"},
+		history:       true,
+	}
+}
+
+// extractWeatherAttributes returns one value per weatherAttributeColumns entry.
+func extractWeatherAttributes(raw string) ([]sql.NullFloat64, error) {
+	values := make([]sql.NullFloat64, len(weatherAttributeColumns))
+	trimmed := strings.TrimSpace(raw)
+	if trimmed == "" {
+		return values, nil
+	}
+
+	var attrs map[string]any
+	if err := json.Unmarshal([]byte(trimmed), &attrs); err != nil {
+		return values, fmt.Errorf("unmarshal shared_attrs: %w", err)
+	}
+
+	for i, name := range weatherAttributeColumns {
+		if v, ok := pickFloat(attrs[name]); ok {
+			values[i] = sql.NullFloat64{Float64: v, Valid: true}
+		}
+	}
+	return values, nil
+}
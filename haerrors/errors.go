@@ -0,0 +1,58 @@
+// Package haerrors holds the failure modes of ha-tools exports that the command tells apart:
+// schema drift and a locked recorder have their own exit codes and summary statuses, and a failed
+// batch carries its position. The exporters are internal to the ha-tools command, which classifies
+// the errors they return with errors.Is against the Err* values, or errors.As into the *Error
+// types for their details.
+package haerrors
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrSchemaDrift is a destination table whose layout ha-tools will not migrate in place.
+	ErrSchemaDrift = errors.New("destination schema drift")
+	// ErrSourceLocked is a recorder database another process holds locked past the busy timeout.
+	ErrSourceLocked = errors.New("recorder database locked")
+	// ErrBatchFailed is a batch of rows the destination did not accept.
+	ErrBatchFailed = errors.New("batch write failed")
+)
+
+// SchemaDriftError reports which table drifted and how.
+type SchemaDriftError struct {
+	Table string
+	Err   error
+}
+
+func (e *SchemaDriftError) Error() string        { return e.Err.Error() }
+func (e *SchemaDriftError) Unwrap() error        { return e.Err }
+func (e *SchemaDriftError) Is(target error) bool { return target == ErrSchemaDrift }
+
+// SourceLockedError reports the recorder that was locked.
+type SourceLockedError struct {
+	Path string
+	Err  error
+}
+
+func (e *SourceLockedError) Error() string {
+	return fmt.Sprintf("recorder %s is locked by another process: %v", e.Path, e.Err)
+}
+func (e *SourceLockedError) Unwrap() error        { return e.Err }
+func (e *SourceLockedError) Is(target error) bool { return target == ErrSourceLocked }
+
+// BatchError reports the batch that failed. Batches before it were written, so the watermarks
+// pick up from the start of this one on the next run.
+type BatchError struct {
+	Table string
+	// Batch counts the batches written to Table by the failing writer, starting at 1.
+	Batch int
+	Rows  int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch %d (%d rows): %v", e.Batch, e.Rows, e.Err)
+}
+func (e *BatchError) Unwrap() error        { return e.Err }
+func (e *BatchError) Is(target error) bool { return target == ErrBatchFailed }